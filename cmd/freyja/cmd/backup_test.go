@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ssargent/freyjadb/pkg/output"
+	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteVerifyReport(t *testing.T) {
+	dataDir := t.TempDir()
+	kv, err := store.NewKVStore(store.KVStoreConfig{DataDir: dataDir})
+	require.NoError(t, err)
+	_, err = kv.Open()
+	require.NoError(t, err)
+	defer kv.Close()
+	require.NoError(t, kv.Put([]byte("user:1"), []byte("v1")))
+
+	backupDir := t.TempDir()
+	require.NoError(t, kv.WithConsistentView(func(manifest *store.BackupManifest) error {
+		data, err := os.ReadFile(filepath.Join(dataDir, manifest.Segments[0].Path))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(backupDir, manifest.Segments[0].Path), data, 0600))
+		return store.SaveBackupManifest(backupDir, manifest)
+	}))
+	require.NoError(t, kv.Put([]byte("user:1"), []byte("v2")))
+
+	report, err := store.VerifyBackup(backupDir, store.VerifyOptions{SamplePct: 100, Live: kv})
+	require.NoError(t, err)
+	require.False(t, report.OK)
+
+	var buf bytes.Buffer
+	require.NoError(t, writeVerifyReport(&buf, report))
+	assert.Contains(t, buf.String(), "Live:      1 mismatches")
+	assert.Contains(t, buf.String(), "Result:    FAILED")
+	assert.Contains(t, buf.String(), `"user:1"`)
+
+	format, err := output.Parse("csv")
+	require.NoError(t, err)
+	buf.Reset()
+	require.NoError(t, format.Write(&buf, report.Problems, verifyProblemRows(report.Problems)))
+	assert.Contains(t, buf.String(), "kind,segment,offset,key,detail\nlive,0,0,user:1,value differs from live store\n")
+}
+
+func TestBackupKeysAndSummary(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "backup.key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("0123456789abcdef\n"), 0600))
+
+	keys, err := backupKeys(keyFile)
+	require.NoError(t, err)
+	want, err := store.NewMasterKey([]byte("0123456789abcdef")) // Trailing newline ignored
+	require.NoError(t, err)
+	assert.Equal(t, want.ID(), keys.(*store.MasterKey).ID())
+	none, err := backupKeys("")
+	require.NoError(t, err)
+	assert.Nil(t, none)
+
+	kv, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	require.NoError(t, err)
+	_, err = kv.Open()
+	require.NoError(t, err)
+	defer kv.Close()
+	require.NoError(t, kv.Put([]byte("user:1"), []byte("v1")))
+
+	backupDir := filepath.Join(t.TempDir(), "backup")
+	manifest, err := kv.Backup(backupDir, store.BackupOptions{Keys: keys})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	writeBackupSummary(&buf, "Backed up", backupDir, manifest)
+	assert.Contains(t, buf.String(), "Backed up 1 segments (")
+	assert.Contains(t, buf.String(), "1 keys, encrypted with key "+want.ID())
+
+	report, err := store.VerifyBackup(backupDir, store.VerifyOptions{SamplePct: 100, Keys: keys})
+	require.NoError(t, err)
+	buf.Reset()
+	require.NoError(t, writeVerifyReport(&buf, report))
+	assert.Contains(t, buf.String(), "Encrypted: yes, signature valid")
+}
+
+func TestWriteBackupSetSummary(t *testing.T) {
+	stores, err := store.NewStoreManager(store.StoreManagerConfig{DataDir: t.TempDir()})
+	require.NoError(t, err)
+	defer stores.Close()
+	kv, err := stores.Default(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, kv.Put([]byte("user:1"), []byte("v1")))
+	_, err = stores.System(context.Background())
+	require.NoError(t, err)
+
+	backupDir := filepath.Join(t.TempDir(), "backup")
+	set, err := stores.BackupSet(context.Background(), backupDir, store.BackupSetOptions{})
+	require.NoError(t, err)
+	assert.True(t, store.IsBackupSet(backupDir))
+
+	var buf bytes.Buffer
+	writeBackupSetSummary(&buf, "Backed up", backupDir, set)
+	assert.Contains(t, buf.String(), "Backed up 2 stores to "+backupDir)
+	assert.Contains(t, buf.String(), "  default    1 segments (")
+	assert.Contains(t, buf.String(), "1 keys) at commit sequence ")
+	assert.Contains(t, buf.String(), "  system     1 segments (")
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ssargent/freyjadb/pkg/output"
+	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteCompactionEstimate(t *testing.T) {
+	kv, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	require.NoError(t, err)
+	_, err = kv.Open()
+	require.NoError(t, err)
+	defer kv.Close()
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, kv.Put([]byte("user:1"), bytes.Repeat([]byte("x"), 2048)))
+	}
+	estimate, err := kv.EstimateCompaction()
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, writeCompactionEstimate(&buf, estimate))
+	assert.Contains(t, buf.String(), "RECLAIMABLE")
+	assert.Contains(t, buf.String(), "Reclaimable: 2.0KiB of 4.1KiB")
+
+	format, err := output.Parse("csv")
+	require.NoError(t, err)
+	buf.Reset()
+	require.NoError(t, format.Write(&buf, estimate.Segments, compactionRows(estimate.Segments)))
+	assert.Contains(t, buf.String(), "file_id,path,size_bytes")
+	assert.Contains(t, buf.String(), ",true\n")
+}
+
+func TestWriteCompactionResult(t *testing.T) {
+	kv, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	require.NoError(t, err)
+	_, err = kv.Open()
+	require.NoError(t, err)
+	defer kv.Close()
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, kv.Put([]byte("user:1"), bytes.Repeat([]byte("x"), 2048)))
+	}
+	result, err := kv.Compact(store.CompactOptions{Cluster: store.ClusterPrefix})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	writeCompactionResult(&buf, result)
+	assert.Contains(t, buf.String(), "Compacted 1 segments into segment 1")
+	assert.Contains(t, buf.String(), "1 live keys (cluster prefix, depth 2)")
+
+	var empty bytes.Buffer
+	writeCompactionResult(&empty, &store.CompactionResult{})
+	assert.Equal(t, "Nothing to compact\n", empty.String())
+}
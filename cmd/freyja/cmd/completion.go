@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/ssargent/freyjadb/pkg/config"
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// noStoreAnnotation marks commands that run without opening the store
+const noStoreAnnotation = "no-store"
+
+// Environment variables that point key completion at a running server
+const (
+	serverEnv = "FREYJA_SERVER"  // Base URL, e.g. http://localhost:8080
+	apiKeyEnv = "FREYJA_API_KEY" // Client API key; defaults to the one in the config file
+)
+
+// maxCompletions caps the keys listed for one completion request
+const maxCompletions = 1000
+
+// completionTimeout bounds a completion request to a server
+const completionTimeout = 2 * time.Second
+
+// completionCmd generates shell completion scripts
+var completionCmd = &cobra.Command{
+	Use:   "completion <bash|zsh|fish|powershell>",
+	Short: "Generate a shell completion script",
+	Long: `Generate a completion script for bash, zsh, fish or PowerShell.
+
+Keys and prefixes complete dynamically, one ':'-separated part at a time.
+They are read from --data-dir, which is opened as a read-only standby so a
+server using the same directory is not disturbed. With --profile, or a
+current profile from 'freyja profile use', they are read from that
+profile's server. Set FREYJA_SERVER to complete against a running server
+instead; FREYJA_API_KEY overrides the client API key from the config file.
+
+Examples:
+  source <(freyja completion bash)
+  freyja completion zsh > "${fpath[1]}/_freyja"
+  freyja completion fish > ~/.config/fish/completions/freyja.fish
+  freyja completion powershell | Out-String | Invoke-Expression`,
+	Args:        cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs:   []string{"bash", "zsh", "fish", "powershell"},
+	Annotations: map[string]string{noStoreAnnotation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		root, out := cmd.Root(), cmd.OutOrStdout()
+		switch args[0] {
+		case "bash":
+			return root.GenBashCompletionV2(out, true)
+		case "zsh":
+			return root.GenZshCompletion(out)
+		case "fish":
+			return root.GenFishCompletion(out, true)
+		default:
+			return root.GenPowerShellCompletionWithDesc(out)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(completionCmd)
+}
+
+// completeKey completes the key argument of get, put, delete and scan
+func completeKey(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp // Only the first argument is a key
+	}
+
+	keys, err := completionKeys(cmd, toComplete)
+	if err != nil {
+		cobra.CompDebugln(err.Error(), true)
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	candidates, partial := completionCandidates(keys, toComplete)
+	directive := cobra.ShellCompDirectiveNoFileComp
+	if partial {
+		directive |= cobra.ShellCompDirectiveNoSpace
+	}
+	return candidates, directive
+}
+
+// completionCandidates narrows keys under prefix to the next part of each,
+// so "us" offers "user:" rather than every user key. It reports whether any
+// candidate is a partial key the shell should not follow with a space.
+func completionCandidates(keys []string, prefix string) ([]string, bool) {
+	seen := make(map[string]bool)
+	var candidates []string
+	var partial bool
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		candidate := key
+		if i := strings.IndexByte(key[len(prefix):], ':'); i >= 0 && len(prefix)+i+1 < len(key) {
+			candidate = key[:len(prefix)+i+1]
+			partial = true
+		}
+		if !seen[candidate] {
+			seen[candidate] = true
+			candidates = append(candidates, candidate)
+		}
+	}
+	sort.Strings(candidates)
+	if len(candidates) > maxCompletions {
+		candidates = candidates[:maxCompletions]
+	}
+	return candidates, partial
+}
+
+// completionKeys lists the keys under prefix from the server in
+// FREYJA_SERVER if set, then from the server of the selected profile,
+// otherwise from the local data directory
+func completionKeys(cmd *cobra.Command, prefix string) ([]string, error) {
+	if server := os.Getenv(serverEnv); server != "" {
+		return serverKeys(server, completionAPIKey(), prefix)
+	}
+	profile, err := selectedProfile(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if profile != nil {
+		remote, err := newRemoteKV(*profile, completionTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return remote.ListKeys([]byte(prefix))
+	}
+	dataDir, _ := cmd.Flags().GetString("data-dir")
+	return localKeys(dataDir, prefix)
+}
+
+// completionAPIKey returns FREYJA_API_KEY, or the client key from the config file
+func completionAPIKey() string {
+	if key := os.Getenv(apiKeyEnv); key != "" {
+		return key
+	}
+	configPath := config.GetDefaultConfigPath()
+	if !config.ConfigExists(configPath) {
+		return ""
+	}
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return ""
+	}
+	return cfg.Security.ClientAPIKey
+}
+
+// localKeys lists keys under prefix in dataDir without modifying it. A
+// missing directory has no keys.
+func localKeys(dataDir, prefix string) ([]string, error) {
+	if _, err := os.Stat(dataDir); err != nil {
+		return nil, nil
+	}
+
+	kv, err := store.NewKVStore(store.KVStoreConfig{DataDir: dataDir, OpenMode: store.OpenStandby})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := kv.Open(); err != nil {
+		return nil, err
+	}
+	defer kv.Close()
+
+	return kv.ListKeys([]byte(prefix))
+}
+
+// serverKeys lists keys under prefix with GET /api/v1/kv
+func serverKeys(server, apiKey, prefix string) ([]string, error) {
+	remote, err := newRemoteKV(config.Profile{URL: server, APIKey: apiKey}, completionTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return remote.ListKeys([]byte(prefix))
+}
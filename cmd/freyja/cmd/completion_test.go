@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompletionCandidates(t *testing.T) {
+	keys := []string{"user:1", "user:2", "user:1:profile", "order:1", "config"}
+	tests := []struct {
+		prefix      string
+		want        []string
+		wantPartial bool
+	}{
+		{prefix: "", want: []string{"config", "order:", "user:"}, wantPartial: true},
+		{prefix: "us", want: []string{"user:"}, wantPartial: true},
+		{prefix: "user:", want: []string{"user:1", "user:1:", "user:2"}, wantPartial: true},
+		{prefix: "user:1:", want: []string{"user:1:profile"}},
+		{prefix: "missing"},
+	}
+	for _, tt := range tests {
+		got, partial := completionCandidates(keys, tt.prefix)
+		assert.Equal(t, tt.want, got, tt.prefix)
+		assert.Equal(t, tt.wantPartial, partial, tt.prefix)
+	}
+}
+
+func TestCompletionKeys(t *testing.T) {
+	dataDir := t.TempDir()
+	kv, err := store.NewKVStore(store.KVStoreConfig{DataDir: dataDir})
+	require.NoError(t, err)
+	_, err = kv.Open()
+	require.NoError(t, err)
+	defer kv.Close()
+	for _, key := range []string{"user:1", "user:2", "order:1"} {
+		require.NoError(t, kv.Put([]byte(key), []byte("value")))
+	}
+
+	// The local directory is read while its store is still open
+	keys, err := localKeys(dataDir, "user:")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"user:1", "user:2"}, keys)
+	keys, err = localKeys(t.TempDir()+"/missing", "")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "client-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"success":false,"error":"invalid API key"}`))
+			return
+		}
+		assert.Equal(t, "/api/v1/kv", r.URL.Path)
+		assert.Equal(t, "user:", r.URL.Query().Get("prefix"))
+		w.Write([]byte(`{"success":true,"data":{"keys":["user:1"]}}`))
+	}))
+	defer server.Close()
+
+	keys, err = serverKeys(server.URL+"/", "client-key", "user:")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user:1"}, keys)
+	_, err = serverKeys(server.URL, "wrong", "user:")
+	assert.ErrorContains(t, err, "invalid API key")
+
+	t.Setenv(serverEnv, server.URL)
+	t.Setenv(apiKeyEnv, "client-key")
+	candidates, directive := completeKey(getCmd, nil, "user:")
+	assert.Equal(t, []string{"user:1"}, candidates)
+	assert.NotZero(t, directive)
+}
@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/ssargent/freyjadb/pkg/api"
+	"github.com/ssargent/freyjadb/pkg/config"
+)
+
+// configCmd groups configuration maintenance commands
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage FreyjaDB configuration and keys",
+}
+
+// rotateKeysCmd replaces API keys in the configuration
+var rotateKeysCmd = &cobra.Command{
+	Use:   "rotate-keys",
+	Short: "Generate new API keys",
+	Long: `Generate new client and/or system API keys and save them to the
+configuration (or its secrets file). The system API key is also updated in
+the system store, so stop the server first and restart it afterwards.
+
+The system key is not rotated: it encrypts the system store.
+
+Examples:
+  freyja config rotate-keys
+  freyja config rotate-keys --client --print-keys`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		configPath, _ := cmd.Flags().GetString("config")
+		client, _ := cmd.Flags().GetBool("client")
+		system, _ := cmd.Flags().GetBool("system-api")
+		printKeys, _ := cmd.Flags().GetBool("print-keys")
+		if !client && !system {
+			client, system = true, true
+		}
+
+		configPath, cfg, err := loadExistingConfig(configPath)
+		if err != nil {
+			return err
+		}
+		if err := config.RotateAPIKeys(cfg, client, system); err != nil {
+			return err
+		}
+
+		// Update the system store before the config, so the config never
+		// names a system key the store doesn't accept
+		if system {
+			if err := storeSystemAPIKey(cfg); err != nil {
+				return err
+			}
+		}
+		if err := config.SaveConfig(cfg, configPath); err != nil {
+			if system {
+				cmd.PrintErrf("The system store already uses the new system API key: %s\n", cfg.Security.SystemAPIKey)
+			}
+			return err
+		}
+
+		if client {
+			cmd.Printf("✅ Rotated client API key\n")
+		}
+		if system {
+			cmd.Printf("✅ Rotated system API key\n")
+		}
+		if printKeys {
+			if client {
+				cmd.Printf("Client API Key: %s\n", cfg.Security.ClientAPIKey)
+			}
+			if system {
+				cmd.Printf("System API Key: %s\n", cfg.Security.SystemAPIKey)
+			}
+		}
+		cmd.Printf("Restart the server to use the new keys\n")
+		return nil
+	},
+}
+
+// splitSecretsCmd moves keys out of the main configuration file
+var splitSecretsCmd = &cobra.Command{
+	Use:   "split-secrets [secrets-file]",
+	Short: "Move keys into a separate secrets file",
+	Long: `Move the keys out of the main configuration into a secrets file readable
+only by its owner, so the main file can be shared or checked in. The
+secrets file defaults to secrets.yaml beside the configuration.
+
+Examples:
+  freyja config split-secrets
+  freyja config split-secrets /etc/freyja/secrets.yaml`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		configPath, _ := cmd.Flags().GetString("config")
+
+		configPath, cfg, err := loadExistingConfig(configPath)
+		if err != nil {
+			return err
+		}
+
+		cfg.SecretsFile = config.DefaultSecretsFile
+		if len(args) == 1 {
+			cfg.SecretsFile = args[0]
+		}
+		if err := config.SaveConfig(cfg, configPath); err != nil {
+			return err
+		}
+
+		cmd.Printf("✅ Keys moved to %s\n", config.SecretsPath(cfg, configPath))
+		return nil
+	},
+}
+
+// loadExistingConfig loads the config at path (or the default location),
+// which must already exist
+func loadExistingConfig(path string) (string, *config.Config, error) {
+	if path == "" {
+		path = config.GetDefaultConfigPath()
+	}
+	if !config.ConfigExists(path) {
+		return "", nil, fmt.Errorf("no configuration at %s (run 'freyja up' first)", path)
+	}
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	return path, cfg, nil
+}
+
+// storeSystemAPIKey replaces the system-root key in the system store, if the
+// store has been initialized
+func storeSystemAPIKey(cfg *config.Config) error {
+	if _, err := os.Stat(filepath.Join(cfg.DataDir, "system", "active.data")); os.IsNotExist(err) {
+		return nil // Initialized with the new key on the next 'freyja up'
+	}
+	if container == nil {
+		return fmt.Errorf("dependency container not initialized")
+	}
+
+	systemService, err := container.GetSystemServiceFactory().CreateSystemService(
+		cfg.DataDir, cfg.Security.SystemKey, true, cfg.Security.MaxRecordSize)
+	if err != nil {
+		return fmt.Errorf("failed to create system service: %w", err)
+	}
+	if err := systemService.Open(); err != nil {
+		return fmt.Errorf("failed to open system store: %w", err)
+	}
+	defer systemService.Close()
+
+	key, err := systemService.GetAPIKey("system-root")
+	if err != nil {
+		key = &api.APIKey{ID: "system-root", Description: "System root API key for administrative operations"}
+	}
+	key.Key = cfg.Security.SystemAPIKey
+	key.CreatedAt = time.Now()
+	key.IsActive = true
+	if err := systemService.StoreAPIKey(*key); err != nil {
+		return fmt.Errorf("failed to store system API key: %w", err)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(rotateKeysCmd)
+	configCmd.AddCommand(splitSecretsCmd)
+
+	configCmd.PersistentFlags().String("config", "", "Path to config file (default: OS-specific location)")
+	rotateKeysCmd.Flags().Bool("client", false, "Rotate the client API key")
+	rotateKeysCmd.Flags().Bool("system-api", false, "Rotate the system API key")
+	rotateKeysCmd.Flags().Bool("print-keys", false, "Print the new keys")
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// CommandDescription describes a command for --describe-commands, so
+// wrappers and UIs can discover the CLI's operations
+type CommandDescription struct {
+	Name      string               `json:"name"`
+	Path      string               `json:"path"` // Full invocation, e.g. "freyja backup verify"
+	Usage     string               `json:"usage"`
+	Short     string               `json:"short"`
+	Long      string               `json:"long,omitempty"`
+	Aliases   []string             `json:"aliases,omitempty"`
+	Runnable  bool                 `json:"runnable"`
+	ValidArgs []string             `json:"valid_args,omitempty"`
+	Flags     []FlagDescription    `json:"flags,omitempty"` // Flags inherited from a parent are listed on the parent
+	Commands  []CommandDescription `json:"commands,omitempty"`
+}
+
+// FlagDescription describes one flag of a command
+type FlagDescription struct {
+	Name       string `json:"name"`
+	Shorthand  string `json:"shorthand,omitempty"`
+	Type       string `json:"type"`
+	Default    string `json:"default"`
+	Usage      string `json:"usage"`
+	Persistent bool   `json:"persistent"` // Also applies to every subcommand
+}
+
+// describeCommand describes cmd and its visible subcommands
+func describeCommand(cmd *cobra.Command) CommandDescription {
+	desc := CommandDescription{
+		Name:      cmd.Name(),
+		Path:      cmd.CommandPath(),
+		Usage:     cmd.UseLine(),
+		Short:     cmd.Short,
+		Long:      cmd.Long,
+		Aliases:   cmd.Aliases,
+		Runnable:  cmd.Runnable(),
+		ValidArgs: cmd.ValidArgs,
+	}
+
+	persistent := cmd.PersistentFlags()
+	cmd.LocalFlags().VisitAll(func(flag *pflag.Flag) {
+		if flag.Hidden || flag.Name == "help" {
+			return
+		}
+		desc.Flags = append(desc.Flags, FlagDescription{
+			Name:       flag.Name,
+			Shorthand:  flag.Shorthand,
+			Type:       flag.Value.Type(),
+			Default:    flag.DefValue,
+			Usage:      flag.Usage,
+			Persistent: persistent.Lookup(flag.Name) != nil,
+		})
+	})
+
+	for _, sub := range cmd.Commands() {
+		if sub.IsAvailableCommand() {
+			desc.Commands = append(desc.Commands, describeCommand(sub))
+		}
+	}
+	return desc
+}
+
+// writeCommandDescriptions prints the command tree rooted at cmd in format
+func writeCommandDescriptions(w io.Writer, cmd *cobra.Command, format string) error {
+	if format != "json" {
+		return fmt.Errorf("unsupported description format %q (supported: json)", format)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(describeCommand(cmd))
+}
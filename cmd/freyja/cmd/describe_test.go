@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteCommandDescriptions(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeCommandDescriptions(&buf, rootCmd, "json"))
+
+	var root CommandDescription
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &root))
+	assert.Equal(t, "freyja", root.Path)
+
+	find := func(commands []CommandDescription, name string) *CommandDescription {
+		for i := range commands {
+			if commands[i].Name == name {
+				return &commands[i]
+			}
+		}
+		return nil
+	}
+
+	backup := find(root.Commands, "backup")
+	require.NotNil(t, backup)
+	verify := find(backup.Commands, "verify")
+	require.NotNil(t, verify)
+	assert.Equal(t, "freyja backup verify", verify.Path)
+	assert.True(t, verify.Runnable)
+
+	var sample *FlagDescription
+	for i := range verify.Flags {
+		if verify.Flags[i].Name == "sample" {
+			sample = &verify.Flags[i]
+		}
+	}
+	require.NotNil(t, sample)
+	assert.Equal(t, "float64", sample.Type)
+
+	completion := find(root.Commands, "completion")
+	require.NotNil(t, completion)
+	assert.Equal(t, []string{"bash", "zsh", "fish", "powershell"}, completion.ValidArgs)
+	assert.Nil(t, find(root.Commands, "help"), "hidden and help commands are left out")
+
+	assert.ErrorContains(t, writeCommandDescriptions(&buf, rootCmd, "yaml"), "unsupported")
+}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 
 	"github.com/spf13/cobra"
-	"github.com/ssargent/freyjadb/pkg/store"
 )
 
 // getCmd represents the get command
@@ -15,14 +14,16 @@ var getCmd = &cobra.Command{
 
 Example:
   freyja get mykey`,
-	Args: cobra.ExactArgs(1),
+	Annotations:       map[string]string{remoteAnnotation: "true"},
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeKey,
 	Run: func(cmd *cobra.Command, args []string) {
 		key := []byte(args[0])
 
-		// Get store from context
-		kv, ok := cmd.Context().Value("store").(*store.KVStore)
-		if !ok {
-			fmt.Printf("Error: store not found in context\n")
+		// Get store from context, or the server of the selected profile
+		kv, err := commandKV(cmd)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
 			return
 		}
 
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/ssargent/freyjadb/pkg/migrate"
+	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestImportFromBolt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.db")
+	db, err := bolt.Open(path, 0o600, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		users, err := tx.CreateBucket([]byte("users"))
+		if err != nil {
+			return err
+		}
+		return users.Put([]byte("alice"), []byte(`{"name":"alice"}`))
+	}))
+	require.NoError(t, db.Close())
+
+	kv, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	require.NoError(t, err)
+	_, err = kv.Open()
+	require.NoError(t, err)
+	defer kv.Close()
+
+	cmd := importCmd
+	cmd.SetContext(context.WithValue(context.Background(), "store", kv))
+	require.NoError(t, cmd.Flags().Set("from", "bolt"))
+	require.NoError(t, cmd.Flags().Set("key-prefix", "legacy:"))
+	defer func() {
+		_ = cmd.Flags().Set("from", "")
+		_ = cmd.Flags().Set("key-prefix", "")
+	}()
+
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	require.NoError(t, cmd.RunE(cmd, []string{path}))
+	assert.Contains(t, out.String(), "Imported 1 of 1 keys")
+
+	value, err := kv.Get([]byte("legacy:users:alice"))
+	require.NoError(t, err)
+	assert.Equal(t, `{"name":"alice"}`, string(value))
+}
+
+func TestWriteImportResult(t *testing.T) {
+	var out bytes.Buffer
+	writeImportResult(&out, &migrate.Result{Read: 10, Imported: 8, Skipped: 2, Bytes: 2048})
+	assert.Equal(t, "Imported 8 of 10 keys (2.0KiB, 2 skipped) in 0s\n", out.String())
+}
@@ -206,10 +206,12 @@ ExecStart=/usr/local/bin/freyja serve --data-dir=${DATA_DIR} \
 --api-key=%s --system-key=${SYSTEM_KEY} --port=%d --enable-encryption
 Restart=always
 RestartSec=5
+KillSignal=SIGTERM
+TimeoutStopSec=%d
 
 [Install]
 WantedBy=multi-user.target
-`, dataDir, systemKey, apiKey, port)
+`, dataDir, systemKey, apiKey, port, stopTimeoutSeconds(0))
 
 	file, err := os.Create(servicePath)
 	if err != nil {
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ssargent/freyjadb/pkg/api"
+	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTailLog(t *testing.T) {
+	kv, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	require.NoError(t, err)
+	_, err = kv.Open()
+	require.NoError(t, err)
+	defer kv.Close()
+
+	require.NoError(t, kv.Put([]byte("user:1"), []byte("ada")))
+	require.NoError(t, kv.Delete([]byte("user:1")))
+	require.NoError(t, kv.DeletePrefix([]byte("tmp:")))
+
+	var buf bytes.Buffer
+	require.NoError(t, tailLog(context.Background(), kv, store.LogTailOptions{}, &buf, false))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 4)
+	assert.Contains(t, lines[0], "KIND")
+	assert.Contains(t, lines[1], `put           "user:1"`)
+	assert.Contains(t, lines[2], `delete        "user:1"`)
+	assert.Contains(t, lines[3], "range-delete")
+
+	buf.Reset()
+	require.NoError(t, tailLog(context.Background(), kv, store.LogTailOptions{Limit: 1}, &buf, true))
+	var item api.LogRecordItem
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &item))
+	assert.Equal(t, "user:1", item.Key)
+	assert.Equal(t, uint32(3), item.ValueSize)
+
+	// Following ends quietly when interrupted
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	buf.Reset()
+	require.NoError(t, tailLog(ctx, kv, store.LogTailOptions{Follow: true}, &buf, true))
+}
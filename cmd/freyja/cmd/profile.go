@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/ssargent/freyjadb/pkg/config"
+	"github.com/ssargent/freyjadb/pkg/output"
+)
+
+// profilesEnv overrides where connection profiles are kept
+const profilesEnv = "FREYJA_PROFILES"
+
+// profileCmd manages named connection profiles
+var profileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Manage connection profiles for remote servers",
+	Long: `Manage named connection profiles, each with a server URL, client API
+key and TLS settings. get, put, delete and scan run against the server of
+--profile, or of the current profile set with 'freyja profile use', instead
+of opening --data-dir. Passing --data-dir runs them locally even when a
+profile is current.
+
+Profiles are kept in freyja/profiles.yaml in the user config directory, or
+in the file named by FREYJA_PROFILES. The file is readable only by its
+owner since it holds API keys.
+
+Examples:
+  freyja profile add prod --url https://db1.example.com:8080 --api-key $KEY --ca-file ca.pem
+  freyja profile use prod
+  freyja get user:1
+  freyja scan user: --profile staging
+  freyja profile use --local`,
+	Annotations: map[string]string{noStoreAnnotation: "true"},
+}
+
+// profileAddCmd adds or replaces a profile
+var profileAddCmd = &cobra.Command{
+	Use:         "add <name>",
+	Short:       "Add or replace a connection profile",
+	Args:        cobra.ExactArgs(1),
+	Annotations: map[string]string{noStoreAnnotation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var profile config.Profile
+		profile.URL, _ = cmd.Flags().GetString("url")
+		profile.APIKey, _ = cmd.Flags().GetString("api-key")
+		profile.TLS.CAFile, _ = cmd.Flags().GetString("ca-file")
+		profile.TLS.CertFile, _ = cmd.Flags().GetString("cert-file")
+		profile.TLS.KeyFile, _ = cmd.Flags().GetString("key-file")
+		profile.TLS.InsecureSkipVerify, _ = cmd.Flags().GetBool("insecure-skip-verify")
+		use, _ := cmd.Flags().GetBool("use")
+
+		path := profilesPath()
+		profiles, err := config.LoadProfiles(path)
+		if err != nil {
+			return err
+		}
+		if err := profiles.Set(args[0], profile); err != nil {
+			return err
+		}
+		if use {
+			profiles.Current = args[0]
+		}
+		if err := profiles.Save(path); err != nil {
+			return err
+		}
+		cmd.Printf("Saved profile '%s' to %s\n", args[0], path)
+		return nil
+	},
+}
+
+// profileListCmd lists the profiles
+var profileListCmd = &cobra.Command{
+	Use:         "list",
+	Short:       "List connection profiles",
+	Args:        cobra.NoArgs,
+	Annotations: map[string]string{noStoreAnnotation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		profiles, err := config.LoadProfiles(profilesPath())
+		if err != nil {
+			return err
+		}
+		rows := output.Rows{Header: []string{"current", "name", "url", "api_key", "tls"}}
+		for _, name := range profiles.Names() {
+			profile := profiles.Profiles[name]
+			current := ""
+			if name == profiles.Current {
+				current = "*"
+			}
+			rows.Add(current, name, profile.URL, maskAPIKey(profile.APIKey), describeProfileTLS(profile.TLS))
+		}
+		return output.WriteTable(cmd.OutOrStdout(), rows)
+	},
+}
+
+// profileUseCmd sets the current profile
+var profileUseCmd = &cobra.Command{
+	Use:         "use <name>",
+	Short:       "Set the profile remote commands use by default",
+	Args:        cobra.MaximumNArgs(1),
+	Annotations: map[string]string{noStoreAnnotation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		local, _ := cmd.Flags().GetBool("local")
+		if local == (len(args) == 1) {
+			return fmt.Errorf("give a profile name or --local")
+		}
+		var name string
+		if !local {
+			name = args[0]
+		}
+
+		path := profilesPath()
+		profiles, err := config.LoadProfiles(path)
+		if err != nil {
+			return err
+		}
+		if err := profiles.Use(name); err != nil {
+			return err
+		}
+		if err := profiles.Save(path); err != nil {
+			return err
+		}
+		if local {
+			cmd.Printf("Commands now use --data-dir unless --profile is given\n")
+		} else {
+			cmd.Printf("Commands now use profile '%s'\n", name)
+		}
+		return nil
+	},
+}
+
+// profileRemoveCmd deletes a profile
+var profileRemoveCmd = &cobra.Command{
+	Use:         "remove <name>",
+	Short:       "Remove a connection profile",
+	Args:        cobra.ExactArgs(1),
+	Annotations: map[string]string{noStoreAnnotation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := profilesPath()
+		profiles, err := config.LoadProfiles(path)
+		if err != nil {
+			return err
+		}
+		if err := profiles.Remove(args[0]); err != nil {
+			return err
+		}
+		if err := profiles.Save(path); err != nil {
+			return err
+		}
+		cmd.Printf("Removed profile '%s'\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(profileCmd)
+	profileCmd.AddCommand(profileAddCmd, profileListCmd, profileUseCmd, profileRemoveCmd)
+
+	profileAddCmd.Flags().String("url", "", "Server base URL, e.g. https://db1.example.com:8080")
+	profileAddCmd.Flags().String("api-key", "", "Client API key")
+	profileAddCmd.Flags().String("ca-file", "", "PEM bundle of CAs to trust instead of the system roots")
+	profileAddCmd.Flags().String("cert-file", "", "Client certificate for mutual TLS")
+	profileAddCmd.Flags().String("key-file", "", "Key of the client certificate")
+	profileAddCmd.Flags().Bool("insecure-skip-verify", false, "Don't verify the server certificate (testing only)")
+	profileAddCmd.Flags().Bool("use", false, "Also make this the current profile")
+	_ = profileAddCmd.MarkFlagRequired("url")
+
+	profileUseCmd.Flags().Bool("local", false, "Stop using a current profile")
+}
+
+// maskAPIKey hides all but the last four characters of an API key
+func maskAPIKey(key string) string {
+	if len(key) <= 4 {
+		if key == "" {
+			return ""
+		}
+		return "****"
+	}
+	return "****" + key[len(key)-4:]
+}
+
+// describeProfileTLS summarizes a profile's TLS settings for listing
+func describeProfileTLS(settings config.ProfileTLS) string {
+	switch {
+	case settings.InsecureSkipVerify:
+		return "insecure"
+	case settings.CertFile != "":
+		return "mutual"
+	case settings.CAFile != "":
+		return "custom-ca"
+	default:
+		return "default"
+	}
+}
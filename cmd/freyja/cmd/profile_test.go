@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ssargent/freyjadb/pkg/config"
+	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeServer serves the /api/v1/kv endpoints from a map
+func fakeServer(t *testing.T, apiKey string) *httptest.Server {
+	var mu sync.Mutex
+	values := make(map[string]string)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("X-API-Key") != apiKey {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"success":false,"error":"invalid API key"}`))
+			return
+		}
+		if r.URL.Path == "/api/v1/kv" {
+			var keys []string
+			for key := range values {
+				if strings.HasPrefix(key, r.URL.Query().Get("prefix")) {
+					keys = append(keys, `"`+key+`"`)
+				}
+			}
+			w.Write([]byte(`{"success":true,"data":{"keys":[` + strings.Join(keys, ",") + `]}}`))
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, "/api/v1/kv/")
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			values[key] = string(body)
+			w.Write([]byte(`{"success":true}`))
+		case http.MethodDelete:
+			delete(values, key)
+			w.Write([]byte(`{"success":true}`))
+		default:
+			value, ok := values[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"success":false,"error":"Key not found"}`))
+				return
+			}
+			w.Write([]byte(value))
+		}
+	}))
+}
+
+func TestRemoteKV(t *testing.T) {
+	server := fakeServer(t, "client-key")
+	defer server.Close()
+
+	remote, err := newRemoteKV(config.Profile{URL: server.URL + "/", APIKey: "client-key"}, remoteTimeout)
+	require.NoError(t, err)
+	require.NoError(t, remote.Put([]byte("user:1"), []byte("alice")))
+	require.NoError(t, remote.Put([]byte("user:2"), []byte("bob")))
+
+	value, err := remote.Get([]byte("user:1"))
+	require.NoError(t, err)
+	assert.Equal(t, "alice", string(value))
+	_, err = remote.Get([]byte("user:3"))
+	assert.ErrorIs(t, err, store.ErrKeyNotFound)
+
+	// Scan works the same against a server as against a local store
+	results, err := scanStore(remote, "user:", 0, false, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []ScanResult{{Key: "user:1", Value: "alice"}, {Key: "user:2", Value: "bob"}}, results)
+
+	require.NoError(t, remote.Delete([]byte("user:1")))
+	keys, err := remote.ListKeys([]byte("user:"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user:2"}, keys)
+
+	wrong, err := newRemoteKV(config.Profile{URL: server.URL, APIKey: "wrong"}, remoteTimeout)
+	require.NoError(t, err)
+	_, err = wrong.Get([]byte("user:2"))
+	assert.ErrorContains(t, err, "invalid API key")
+}
+
+func TestProfileCommands(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.yaml")
+	t.Setenv(profilesEnv, path)
+	server := fakeServer(t, "client-key")
+	defer server.Close()
+
+	run := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		rootCmd.SetOut(&out)
+		rootCmd.SetErr(&out)
+		rootCmd.SetArgs(args)
+		defer rootCmd.SetArgs(nil)
+		err := rootCmd.Execute()
+		// Flags keep their values between runs of the same commands
+		for _, name := range []string{"profile", "data-dir"} {
+			flag := rootCmd.PersistentFlags().Lookup(name)
+			require.NoError(t, flag.Value.Set(flag.DefValue))
+			flag.Changed = false
+		}
+		return out.String(), err
+	}
+
+	_, err := run("profile", "add", "prod", "--url", server.URL, "--api-key", "client-key", "--use")
+	require.NoError(t, err)
+	_, err = run("profile", "add", "staging", "--url", "not a url")
+	assert.Error(t, err)
+
+	out, err := run("profile", "list")
+	require.NoError(t, err)
+	assert.Contains(t, out, "prod")
+	assert.Contains(t, out, "****-key")
+	assert.NotContains(t, out, "client-key")
+
+	// With a current profile, scan runs against its server
+	remote, err := newRemoteKV(config.Profile{URL: server.URL, APIKey: "client-key"}, remoteTimeout)
+	require.NoError(t, err)
+	require.NoError(t, remote.Put([]byte("user:1"), []byte("alice")))
+	out, err = run("scan", "user:", "--keys-only", "--format", "csv")
+	require.NoError(t, err)
+	assert.Equal(t, "key\nuser:1\n", out)
+
+	_, err = run("scan", "--profile", "prod", "--data-dir", t.TempDir())
+	assert.ErrorContains(t, err, "can't be used together")
+	_, err = run("scan", "--profile", "missing")
+	assert.ErrorIs(t, err, config.ErrProfileNotFound)
+
+	_, err = run("profile", "use", "--local")
+	require.NoError(t, err)
+	profiles, err := config.LoadProfiles(path)
+	require.NoError(t, err)
+	assert.Empty(t, profiles.Current)
+
+	_, err = run("profile", "remove", "prod")
+	require.NoError(t, err)
+	profiles, err = config.LoadProfiles(path)
+	require.NoError(t, err)
+	assert.Empty(t, profiles.Names())
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// progressBarWidth is the number of cells in the recovery progress bar
+const progressBarWidth = 30
+
+// recoveryProgressAnnotation marks commands that print a recovery progress bar
+const recoveryProgressAnnotation = "recovery-progress"
+
+// newRecoveryProgressPrinter returns a callback that redraws a recovery
+// progress bar on w
+func newRecoveryProgressPrinter(w io.Writer) func(store.RecoveryProgress) {
+	return func(p store.RecoveryProgress) {
+		fmt.Fprintf(w, "\r%s", formatRecoveryProgress(p))
+		if p.Done {
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// formatRecoveryProgress renders a single progress line
+func formatRecoveryProgress(p store.RecoveryProgress) string {
+	fraction := p.Fraction()
+	if fraction > 1 {
+		fraction = 1
+	}
+	filled := int(fraction * progressBarWidth)
+	bar := strings.Repeat("#", filled) + strings.Repeat("-", progressBarWidth-filled)
+
+	line := fmt.Sprintf("Recovering [%s] %3.0f%% %s/%s %d records",
+		bar, fraction*100, formatBytes(p.BytesScanned), formatBytes(p.TotalBytes), p.RecordsValidated)
+	if p.Done {
+		return line + fmt.Sprintf(" in %s", p.Elapsed.Round(time.Millisecond))
+	}
+	if p.ETA > 0 {
+		line += fmt.Sprintf(" ETA %s", p.ETA.Round(time.Second))
+	}
+	return line
+}
+
+// formatBytes renders a byte count with a binary unit suffix
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatRecoveryProgress(t *testing.T) {
+	line := formatRecoveryProgress(store.RecoveryProgress{
+		BytesScanned:     512 * 1024 * 1024,
+		TotalBytes:       1024 * 1024 * 1024,
+		RecordsValidated: 42,
+		ETA:              90 * time.Second,
+	})
+
+	assert.Contains(t, line, "["+strings.Repeat("#", 15)+strings.Repeat("-", 15)+"]")
+	assert.Contains(t, line, " 50%")
+	assert.Contains(t, line, "512.0MiB/1.0GiB")
+	assert.Contains(t, line, "42 records")
+	assert.Contains(t, line, "ETA 1m30s")
+}
+
+func TestRecoveryProgressPrinter(t *testing.T) {
+	var buf bytes.Buffer
+	printer := newRecoveryProgressPrinter(&buf)
+
+	printer(store.RecoveryProgress{BytesScanned: 10, TotalBytes: 20})
+	printer(store.RecoveryProgress{BytesScanned: 20, TotalBytes: 20, Done: true})
+
+	out := buf.String()
+	assert.Equal(t, 2, strings.Count(out, "\r"))
+	assert.True(t, strings.HasSuffix(out, "\n"))
+	assert.Contains(t, out, "100%")
+}
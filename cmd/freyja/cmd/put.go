@@ -4,7 +4,6 @@ import (
 	"fmt"
 
 	"github.com/spf13/cobra"
-	"github.com/ssargent/freyjadb/pkg/store"
 )
 
 // putCmd represents the put command
@@ -15,15 +14,17 @@ var putCmd = &cobra.Command{
 
 Example:
   freyja put mykey myvalue`,
-	Args: cobra.ExactArgs(2),
+	Annotations:       map[string]string{remoteAnnotation: "true"},
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeKey,
 	Run: func(cmd *cobra.Command, args []string) {
 		key := []byte(args[0])
 		value := []byte(args[1])
 
-		// Get store from context
-		kv, ok := cmd.Context().Value("store").(*store.KVStore)
-		if !ok {
-			fmt.Printf("Error: store not found in context\n")
+		// Get store from context, or the server of the selected profile
+		kv, err := commandKV(cmd)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
 			return
 		}
 
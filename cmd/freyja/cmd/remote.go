@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/ssargent/freyjadb/pkg/config"
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// remoteAnnotation marks commands that talk to a server instead of opening
+// the store when a profile is selected
+const remoteAnnotation = "remote"
+
+// remoteTimeout bounds a request of a remote command
+const remoteTimeout = 30 * time.Second
+
+// kvClient is what the key-value commands need from a store, local or remote
+type kvClient interface {
+	Get(key []byte) ([]byte, error)
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	ListKeys(prefix []byte) ([]string, error)
+}
+
+// commandKV returns the store a key-value command works on: the server of
+// the selected profile, or the store the root command opened
+func commandKV(cmd *cobra.Command) (kvClient, error) {
+	if remote, ok := cmd.Context().Value("remote").(*remoteKV); ok {
+		return remote, nil
+	}
+	if kv, ok := cmd.Context().Value("store").(*store.KVStore); ok {
+		return kv, nil
+	}
+	return nil, fmt.Errorf("store not found in context")
+}
+
+// selectedProfile returns the profile a remote command connects with: the
+// one named by --profile, otherwise the current one unless --data-dir asks
+// for a local store. It returns nil when the command should run locally.
+func selectedProfile(cmd *cobra.Command) (*config.Profile, error) {
+	name, _ := cmd.Flags().GetString("profile")
+	dataDirSet := cmd.Flags().Changed("data-dir")
+	if name != "" && dataDirSet {
+		return nil, fmt.Errorf("--profile and --data-dir can't be used together")
+	}
+
+	profiles, err := config.LoadProfiles(profilesPath())
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		if dataDirSet || profiles.Current == "" {
+			return nil, nil
+		}
+		name = profiles.Current
+	}
+	profile, err := profiles.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// profilesPath returns where profiles are kept; FREYJA_PROFILES overrides
+// the default in the user config directory
+func profilesPath() string {
+	if path := os.Getenv(profilesEnv); path != "" {
+		return path
+	}
+	return config.GetDefaultProfilesPath()
+}
+
+// remoteKV is a kvClient for a server's /api/v1/kv endpoints
+type remoteKV struct {
+	server string
+	apiKey string
+	client *http.Client
+}
+
+// newRemoteKV connects to the server of profile, giving up on requests
+// after timeout
+func newRemoteKV(profile config.Profile, timeout time.Duration) (*remoteKV, error) {
+	tlsConfig, err := profile.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: timeout}
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		client.Transport = transport
+	}
+	return &remoteKV{
+		server: strings.TrimRight(profile.URL, "/"),
+		apiKey: profile.APIKey,
+		client: client,
+	}, nil
+}
+
+// do sends a request to path under /api/v1, returning the response body of
+// a 2xx response. A 404 is ErrKeyNotFound; other failures carry the
+// server's error message.
+func (r *remoteKV) do(method, path string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, r.server+"/api/v1"+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-API-Key", r.apiKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return data, nil
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, store.ErrKeyNotFound
+	}
+	var failure struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(data, &failure) == nil && failure.Error != "" {
+		return nil, fmt.Errorf("%s: %s", resp.Status, failure.Error)
+	}
+	return nil, fmt.Errorf("%s", resp.Status)
+}
+
+// Get reads a key's value with GET /api/v1/kv/{key}
+func (r *remoteKV) Get(key []byte) ([]byte, error) {
+	return r.do(http.MethodGet, "/kv/"+url.PathEscape(string(key)), nil)
+}
+
+// Put writes a value with PUT /api/v1/kv/{key}
+func (r *remoteKV) Put(key, value []byte) error {
+	if value == nil {
+		value = []byte{}
+	}
+	_, err := r.do(http.MethodPut, "/kv/"+url.PathEscape(string(key)), value)
+	return err
+}
+
+// Delete removes a key with DELETE /api/v1/kv/{key}
+func (r *remoteKV) Delete(key []byte) error {
+	_, err := r.do(http.MethodDelete, "/kv/"+url.PathEscape(string(key)), nil)
+	return err
+}
+
+// ListKeys lists the keys under prefix with GET /api/v1/kv
+func (r *remoteKV) ListKeys(prefix []byte) ([]string, error) {
+	data, err := r.do(http.MethodGet, "/kv?prefix="+url.QueryEscape(string(prefix)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %w", err)
+	}
+	var body struct {
+		Data struct {
+			Keys []string `json:"keys"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, fmt.Errorf("failed to decode keys: %w", err)
+	}
+	return body.Data.Keys, nil
+}
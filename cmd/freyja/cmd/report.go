@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/ssargent/freyjadb/pkg/api"
+	"github.com/ssargent/freyjadb/pkg/config"
+	"github.com/ssargent/freyjadb/pkg/output"
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// reportCmd represents the report command
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Show storage usage trends and capacity forecast",
+	Long: `Show daily storage usage snapshots recorded in the system store, the
+growth trend across them and a linear forecast of future size.
+
+The running server records a snapshot every hour (one is kept per day).
+This command also records one for today before reporting.
+
+Examples:
+  freyja report
+  freyja report --days 90 --horizon 180
+  freyja report --format json
+  freyja report --format csv > usage.csv
+  freyja report --format 'template={{.Date}} {{.DataBytes}}'`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dataDir, _ := cmd.Flags().GetString("data-dir")
+		days, _ := cmd.Flags().GetInt("days")
+		horizon, _ := cmd.Flags().GetInt("horizon")
+		spec, _ := cmd.Flags().GetString("format")
+		record, _ := cmd.Flags().GetBool("record")
+
+		format, err := output.Parse(spec)
+		if err != nil {
+			return err
+		}
+
+		kv, ok := cmd.Context().Value("store").(*store.KVStore)
+		if !ok {
+			return fmt.Errorf("store not found in context")
+		}
+		if container == nil {
+			return fmt.Errorf("dependency container not initialized")
+		}
+
+		configPath := config.GetDefaultConfigPath()
+		if !config.ConfigExists(configPath) {
+			return fmt.Errorf("no configuration at %s (run 'freyja up' first)", configPath)
+		}
+		cfg, err := config.LoadConfig(configPath)
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		systemService, err := container.GetSystemServiceFactory().CreateSystemService(
+			dataDir, cfg.Security.SystemKey, true, cfg.Security.MaxRecordSize)
+		if err != nil {
+			return fmt.Errorf("failed to create system service: %w", err)
+		}
+		if err := systemService.Open(); err != nil {
+			return fmt.Errorf("failed to open system service: %w", err)
+		}
+		defer systemService.Close()
+
+		if record {
+			snap := api.NewUsageSnapshot(kv.Stats(), kv.DataDirHealth(), time.Now())
+			if err := systemService.RecordUsageSnapshot(snap); err != nil {
+				return fmt.Errorf("failed to record usage snapshot: %w", err)
+			}
+		}
+
+		snapshots, err := systemService.UsageSnapshots(days)
+		if err != nil {
+			return err
+		}
+		report := api.BuildUsageReport(snapshots, horizon)
+
+		switch format.Kind {
+		case output.KindTable:
+			return writeUsageReport(cmd.OutOrStdout(), report)
+		case output.KindJSON:
+			return output.WriteJSON(cmd.OutOrStdout(), report)
+		default:
+			// CSV and templates work on the daily snapshots
+			return format.Write(cmd.OutOrStdout(), report.Snapshots, usageSnapshotRows(report.Snapshots))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+	reportCmd.Flags().Int("days", api.DefaultReportDays, "Number of daily snapshots to include")
+	reportCmd.Flags().Int("horizon", api.DefaultForecastDays, "Forecast horizon in days")
+	reportCmd.Flags().StringP("format", "o", output.KindTable, output.Usage)
+	reportCmd.Flags().Bool("record", true, "Record today's snapshot before reporting")
+}
+
+// writeUsageReport renders a report as a table followed by its trend and forecast
+func writeUsageReport(w io.Writer, report *api.UsageReport) error {
+	if len(report.Snapshots) == 0 {
+		_, err := fmt.Fprintln(w, "No usage snapshots recorded yet")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATE\tKEYS\tDATA\tLIVE\tRECLAIMABLE\tFREE")
+	for _, snap := range report.Snapshots {
+		free := "-"
+		if snap.FreeBytes > 0 {
+			free = formatBytes(int64(snap.FreeBytes)) //nolint:gosec // free space fits in int64
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", snap.Date, snap.Keys, formatBytes(snap.DataBytes),
+			formatBytes(snap.LiveBytes), formatBytes(snap.ReclaimableBytes), free)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if report.Trend == nil {
+		_, err := fmt.Fprintln(w, "\nAt least two snapshots on different days are needed for a forecast")
+		return err
+	}
+
+	fmt.Fprintf(w, "\nGrowth: %+.0f keys/day, %s/day\n", report.Trend.KeysPerDay,
+		formatSignedBytes(report.Trend.BytesPerDay))
+	f := report.Forecast
+	fmt.Fprintf(w, "Forecast in %d days: %d keys, %s\n", f.HorizonDays, f.ProjectedKeys, formatBytes(f.ProjectedBytes))
+	if f.DaysUntilFull > 0 {
+		fmt.Fprintf(w, "Disk full in about %.0f days\n", f.DaysUntilFull)
+	}
+	return nil
+}
+
+// usageSnapshotRows lays snapshots out for CSV, with sizes in bytes
+func usageSnapshotRows(snapshots []api.UsageSnapshot) output.Rows {
+	rows := output.Rows{Header: []string{
+		"date", "taken_at", "keys", "data_bytes", "live_bytes", "reclaimable_bytes", "free_bytes",
+	}}
+	for _, snap := range snapshots {
+		rows.Add(snap.Date, snap.TakenAt.Format(time.RFC3339), strconv.Itoa(snap.Keys),
+			strconv.FormatInt(snap.DataBytes, 10), strconv.FormatInt(snap.LiveBytes, 10),
+			strconv.FormatInt(snap.ReclaimableBytes, 10), strconv.FormatUint(snap.FreeBytes, 10))
+	}
+	return rows
+}
+
+// formatSignedBytes renders a possibly negative byte rate
+func formatSignedBytes(n float64) string {
+	if n < 0 {
+		return "-" + formatBytes(int64(-n))
+	}
+	return "+" + formatBytes(int64(n))
+}
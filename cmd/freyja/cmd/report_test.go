@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteUsageReport(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeUsageReport(&buf, &api.UsageReport{}))
+	assert.Contains(t, buf.String(), "No usage snapshots")
+
+	day := 24 * time.Hour
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	report := api.BuildUsageReport([]api.UsageSnapshot{
+		{Date: "2025-01-01", TakenAt: start, Keys: 100, DataBytes: 1 << 20, LiveBytes: 1 << 19, ReclaimableBytes: 1 << 19},
+		{Date: "2025-01-02", TakenAt: start.Add(day), Keys: 200, DataBytes: 2 << 20, LiveBytes: 2 << 20,
+			FreeBytes: 10 << 20},
+	}, 30)
+
+	buf.Reset()
+	require.NoError(t, writeUsageReport(&buf, report))
+	out := buf.String()
+	assert.Contains(t, out, "2025-01-02")
+	assert.Contains(t, out, "512.0KiB")
+	assert.Contains(t, out, "Growth: +100 keys/day, +1.0MiB/day")
+	assert.Contains(t, out, "Forecast in 30 days: 3200 keys, 32.0MiB")
+	assert.Contains(t, out, "Disk full in about 10 days")
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// rollbackCmd represents the rollback command
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Roll the store back to how it was at an earlier time",
+	Long: `Replay the log up to a point in time and write back every key that
+changed since: deleted and overwritten keys get their old value, and keys
+created since are deleted. Use it to undo an accidental bulk delete without
+a backup. The rollback is made of new writes, so it can itself be rolled
+back.
+
+--to is an RFC 3339 time or a duration before now. The log only holds the
+history since the last compaction or memtable flush, so points before that
+are refused. Sequences are not rolled back.
+
+Examples:
+  freyja rollback --to 15m
+  freyja rollback --to 2025-06-01T09:30:00Z`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		spec, _ := cmd.Flags().GetString("to")
+		if spec == "" {
+			return fmt.Errorf("--to is required")
+		}
+		point, err := parseRestorePoint(spec, time.Now())
+		if err != nil {
+			return err
+		}
+
+		kv, ok := cmd.Context().Value("store").(*store.KVStore)
+		if !ok {
+			return fmt.Errorf("store not found in context")
+		}
+		result, err := kv.RestoreToTimestamp(point)
+		if err != nil {
+			return fmt.Errorf("failed to roll back: %w", err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Rolled back to %s: %d keys restored, %d deleted\n",
+			point.UTC().Format(time.RFC3339), result.Restored, result.Deleted)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+	rollbackCmd.Flags().String("to", "", "Time to roll back to: RFC 3339, or a duration before now such as 15m")
+}
+
+// parseRestorePoint reads an RFC 3339 time or a duration before now
+func parseRestorePoint(spec string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(spec); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("--to duration must not be negative, got %s", spec)
+		}
+		return now.Add(-d), nil
+	}
+	point, err := time.Parse(time.RFC3339Nano, spec)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("--to must be an RFC 3339 time or a duration, got %q", spec)
+	}
+	return point, nil
+}
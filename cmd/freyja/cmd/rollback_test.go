@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRestorePoint(t *testing.T) {
+	now := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	point, err := parseRestorePoint("15m", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(-15*time.Minute), point)
+
+	point, err = parseRestorePoint("2025-06-01T09:30:00Z", now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 6, 1, 9, 30, 0, 0, time.UTC), point)
+
+	_, err = parseRestorePoint("-5m", now)
+	assert.Error(t, err)
+	_, err = parseRestorePoint("yesterday", now)
+	assert.Error(t, err)
+}
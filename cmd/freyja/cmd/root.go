@@ -5,13 +5,20 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
 
+	"github.com/ssargent/freyjadb/pkg/api"
+	"github.com/ssargent/freyjadb/pkg/codec"
 	"github.com/ssargent/freyjadb/pkg/config"
 	"github.com/ssargent/freyjadb/pkg/di"
 	"github.com/ssargent/freyjadb/pkg/store"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/cobra"
 )
 
@@ -24,7 +31,37 @@ var rootCmd = &cobra.Command{
 	Short: "FreyjaDB - Embeddable KV Store",
 	Long: `FreyjaDB is a Bitcask-style embeddable key-value store with
 optional partitioning and sort keys.`,
+	Annotations: map[string]string{noStoreAnnotation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if format, _ := cmd.Flags().GetString("describe-commands"); format != "" {
+			return writeCommandDescriptions(cmd.OutOrStdout(), cmd, format)
+		}
+		return cmd.Help()
+	},
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// Completion and command descriptions never touch the store
+		if cmd.Annotations[noStoreAnnotation] == "true" || cmd.Name() == cobra.ShellCompRequestCmd {
+			return nil
+		}
+
+		// Key-value commands talk to the server of the selected profile
+		if cmd.Annotations[remoteAnnotation] == "true" {
+			profile, err := selectedProfile(cmd)
+			if err != nil {
+				return err
+			}
+			if profile != nil {
+				remote, err := newRemoteKV(*profile, remoteTimeout)
+				if err != nil {
+					return fmt.Errorf("failed to connect with profile: %w", err)
+				}
+				cmd.SetContext(context.WithValue(cmd.Context(), "remote", remote))
+				return nil
+			}
+		} else if cmd.Flags().Changed("profile") {
+			return fmt.Errorf("%s does not support --profile", cmd.CommandPath())
+		}
+
 		dataDir, _ := cmd.Flags().GetString("data-dir")
 		if err := os.MkdirAll(dataDir, 0750); err != nil {
 			return fmt.Errorf("failed to create data dir: %w", err)
@@ -32,6 +69,24 @@ optional partitioning and sort keys.`,
 
 		// Load config if it exists, otherwise use defaults
 		var maxRecordSize int
+		var startup config.Startup
+		var dataDirs []string
+		var placement string
+		var dedupeWrites bool
+		var writeLimits []store.PrefixWriteLimit
+		var groupCommitWindow time.Duration
+		var writeMode string
+		var memtableSize int64
+		var maxSegmentSize int64
+		var compactionCluster string
+		var clusterDepth int
+		var readaheadSize int
+		var compression string
+		var compressionMinSize int
+		var backgroundLatencyTarget time.Duration
+		var minBackgroundRate int64
+		var slowWriteThreshold time.Duration
+		var slo config.SLO
 		configPath := config.GetDefaultConfigPath()
 		if config.ConfigExists(configPath) {
 			cfg, err := config.LoadConfig(configPath)
@@ -40,28 +95,114 @@ optional partitioning and sort keys.`,
 				maxRecordSize = 4096
 			} else {
 				maxRecordSize = cfg.Security.MaxRecordSize
+				startup = cfg.Startup
+				dataDirs = cfg.DataDirs
+				placement = cfg.Placement
+				dedupeWrites = cfg.DedupeWrites
+				for _, limit := range cfg.WriteLimits {
+					writeLimits = append(writeLimits, store.PrefixWriteLimit{
+						Prefix:       limit.Prefix,
+						MaxPerMinute: limit.MaxPerMinute,
+					})
+				}
+				groupCommitWindow = cfg.GroupCommitWindow
+				writeMode = cfg.WriteMode
+				memtableSize = cfg.MemtableSize
+				maxSegmentSize = cfg.MaxSegmentSize
+				compactionCluster = cfg.CompactionCluster
+				clusterDepth = cfg.ClusterDepth
+				readaheadSize = cfg.ReadaheadSize
+				compression = cfg.Compression
+				compressionMinSize = cfg.CompressionMinSize
+				backgroundLatencyTarget = cfg.BackgroundLatencyTarget
+				minBackgroundRate = cfg.MinBackgroundRate
+				slowWriteThreshold = cfg.Logging.SlowWriteThreshold
+				slo = cfg.SLO
 			}
 		} else {
 			// No config exists, use default
 			maxRecordSize = 4096
 		}
 
-		kvStore, err := store.NewKVStore(store.KVStoreConfig{
-			DataDir:       dataDir,
-			MaxRecordSize: maxRecordSize,
+		algorithm, err := codec.ParseCompression(compression)
+		if err != nil {
+			return err
+		}
+
+		storeConfig := store.KVStoreConfig{
+			MaxRecordSize:  maxRecordSize,
+			DataDirs:       dataDirs,
+			Placement:      store.PlacementPolicy(placement),
+			IndexLoad:      store.IndexLoadMode(startup.IndexLoad),
+			WarmupPrefixes: startup.WarmupPrefixes,
+			WarmupMaxBytes: startup.WarmupMaxBytes,
+			RecoveryBudget: startup.RecoveryBudget,
+			FastRestart:    startup.FastRestart,
+			LockTimeout:    startup.LockTimeout,
+			DedupeWrites:   dedupeWrites,
+			WriteLimits:    writeLimits,
+
+			GroupCommitWindow: groupCommitWindow,
+			WriteMode:         store.WriteMode(writeMode),
+			MemtableSize:      memtableSize,
+			MaxSegmentSize:    maxSegmentSize,
+			CompactionCluster: store.ClusterStrategy(compactionCluster),
+			ClusterDepth:      clusterDepth,
+			ReadaheadSize:     readaheadSize,
+			Compression:       codec.CompressionOptions{Algorithm: algorithm, MinSize: compressionMinSize},
+
+			BackgroundLatencyTarget: backgroundLatencyTarget,
+			MinBackgroundRate:       minBackgroundRate,
+
+			SlowWriteThreshold: slowWriteThreshold,
+		}
+		sloTracker, err := useSLOTracker(slo)
+		if err != nil {
+			return err
+		}
+		if cmd.Annotations[recoveryProgressAnnotation] == "true" {
+			storeConfig.OnRecoveryProgress = newRecoveryProgressPrinter(cmd.ErrOrStderr())
+		}
+
+		stores, err := store.NewStoreManager(store.StoreManagerConfig{
+			DataDir: dataDir,
+			Base:    storeConfig,
+			Configure: func(name string, config *store.KVStoreConfig) {
+				if name == store.DefaultStoreName {
+					// Exported on /metrics when the command serves the API
+					config.Stats = api.DefaultMetrics().StoreStats()
+					if sloTracker != nil {
+						config.Stats = sloTracker.Recorder(config.Stats)
+					}
+				}
+			},
 		})
 		if err != nil {
 			return fmt.Errorf("failed to create store: %w", err)
 		}
-		recovery, err := kvStore.Open()
+
+		// Ctrl-C aborts a long recovery without modifying the data file
+		openCtx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+		kvStore, err := stores.Default(openCtx)
+		stop()
+		if errors.Is(err, context.Canceled) {
+			return fmt.Errorf("recovery aborted; data files were not modified: %w", err)
+		}
 		if err != nil {
 			return fmt.Errorf("failed to open store: %w", err)
 		}
-		if recovery.RecordsTruncated > 0 {
+		if recovery := stores.Recovery(store.DefaultStoreName); recovery != nil && recovery.RecordsTruncated > 0 {
 			fmt.Printf("Recovered from corruption: %d records truncated\n", recovery.RecordsTruncated)
 		}
 		// Store in command context
-		cmd.SetContext(context.WithValue(cmd.Context(), "store", kvStore))
+		ctx := context.WithValue(cmd.Context(), "store", kvStore)
+		cmd.SetContext(context.WithValue(ctx, "stores", stores))
+		return nil
+	},
+	PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
+		if stores, ok := cmd.Context().Value("stores").(*store.StoreManager); ok {
+			return stores.Close()
+		}
 		return nil
 	},
 }
@@ -78,6 +219,8 @@ func Execute() {
 func init() {
 	// Global data directory flag
 	rootCmd.PersistentFlags().StringP("data-dir", "d", "./data", "Data directory for the store")
+	rootCmd.PersistentFlags().String("profile", "", "Connection profile of the server to use instead of --data-dir")
+	rootCmd.Flags().String("describe-commands", "", "Print every command and flag in the given format (json) and exit")
 
 	// Setup commands
 	setupDeleteCmd()
@@ -85,6 +228,93 @@ func init() {
 	setupInstallCmd()
 }
 
+// serverStore returns the store to serve: the one registered in the
+// container if any, otherwise the store the root command opened
+func serverStore(cmd *cobra.Command) (api.IKVStore, bool) {
+	if container != nil && container.GetStore() != nil {
+		return container.GetStore(), true
+	}
+	kv, ok := cmd.Context().Value("store").(*store.KVStore)
+	return kv, ok
+}
+
+// useStoreManager registers the store manager the root command opened, so
+// servers started by the command serve its namespaces and system store. It
+// is skipped when the server's data directory is not the manager's, since
+// the system store must come from the server's directory.
+func useStoreManager(cmd *cobra.Command, dataDir string) {
+	stores, ok := cmd.Context().Value("stores").(*store.StoreManager)
+	if ok && container != nil && filepath.Clean(stores.DataDir()) == filepath.Clean(dataDir) {
+		container.SetStoreManager(stores)
+	}
+}
+
+// useAlertMonitor registers a monitor for the configured soft limits, so
+// servers started by the command post alerts. Nothing is registered when no
+// webhook is configured.
+func useAlertMonitor(alerts config.Alerts) error {
+	if alerts.WebhookURL == "" || container == nil {
+		return nil
+	}
+	monitor, err := api.NewAlertMonitor(api.AlertConfig{
+		WebhookURL:          alerts.WebhookURL,
+		Interval:            alerts.Interval,
+		DiskUsedPct:         alerts.DiskUsedPct,
+		MaxKeys:             alerts.MaxKeys,
+		ErrorRatePct:        alerts.ErrorRatePct,
+		MaxReplicationLagKB: alerts.MaxReplicationLagKB,
+	})
+	if err != nil {
+		return fmt.Errorf("invalid alerts configuration: %w", err)
+	}
+	container.SetAlertMonitor(monitor)
+	return nil
+}
+
+// useMiddleware sets the middleware stack of servers started by the
+// command; an empty order keeps the default stack
+func useMiddleware(middleware config.Middleware) {
+	if container == nil {
+		return
+	}
+	container.SetMiddleware(api.MiddlewareConfig{
+		Order:            middleware.Order,
+		CompressionLevel: middleware.CompressionLevel,
+	})
+}
+
+// useSLOTracker registers a tracker for the configured latency objectives,
+// exported on /metrics, so servers started by the command report them.
+// Nothing is registered when no objectives are configured.
+func useSLOTracker(slo config.SLO) (*api.SLOTracker, error) {
+	if len(slo.Objectives) == 0 || container == nil {
+		return nil, nil
+	}
+	objectives := make([]api.SLO, 0, len(slo.Objectives))
+	for _, objective := range slo.Objectives {
+		objectives = append(objectives, api.SLO{
+			Name:      objective.Name,
+			Operation: objective.Operation,
+			Threshold: objective.Threshold,
+			Objective: objective.Objective,
+		})
+	}
+	tracker, err := api.NewSLOTracker(api.SLOConfig{Objectives: objectives, Window: slo.Window})
+	if err != nil {
+		return nil, fmt.Errorf("invalid slo configuration: %w", err)
+	}
+
+	// A command run again in the same process replaces its tracker
+	if previous := container.GetSLOTracker(); previous != nil {
+		prometheus.Unregister(previous)
+	}
+	if err := prometheus.Register(tracker); err != nil {
+		return nil, fmt.Errorf("failed to register SLO metrics: %w", err)
+	}
+	container.SetSLOTracker(tracker)
+	return tracker, nil
+}
+
 // SetContainer sets the dependency injection container for the cmd package
 func SetContainer(c *di.Container) {
 	container = c
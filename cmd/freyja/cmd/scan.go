@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"github.com/ssargent/freyjadb/pkg/output"
+	"github.com/ssargent/freyjadb/pkg/redact"
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// ScanResult is one key-value pair printed by scan
+type ScanResult struct {
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+// scanCmd represents the scan command
+var scanCmd = &cobra.Command{
+	Use:   "scan [prefix]",
+	Short: "List key-value pairs by key prefix",
+	Long: `List the key-value pairs whose keys start with prefix, in key order.
+With no prefix every key is listed.
+
+Results can be printed as a table, JSON, CSV or with a Go template that is
+executed once per result with .Key and .Value.
+
+To share an export for debugging or support, redact it as it is written:
+--skip-prefix leaves keys out, and --drop, --hash and --replace rewrite
+fields of JSON values by dotted path (e.g. user.email). Hashes are stable,
+so hashed values can still be matched up. Values that aren't JSON are
+printed unchanged.
+
+Examples:
+  freyja scan user:
+  freyja scan user: --keys-only --limit 10
+  freyja scan user: --format csv > users.csv
+  freyja scan user: --format 'template={{.Key}}'
+  freyja scan user: --profile prod
+  freyja scan --format json --skip-prefix session: --drop password --hash email > export.json`,
+	Annotations:       map[string]string{remoteAnnotation: "true"},
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeKey,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		spec, _ := cmd.Flags().GetString("format")
+		format, err := output.Parse(spec)
+		if err != nil {
+			return err
+		}
+		limit, _ := cmd.Flags().GetInt("limit")
+		keysOnly, _ := cmd.Flags().GetBool("keys-only")
+		transform, err := scanTransform(cmd)
+		if err != nil {
+			return err
+		}
+
+		kv, err := commandKV(cmd)
+		if err != nil {
+			return err
+		}
+
+		var prefix string
+		if len(args) == 1 {
+			prefix = args[0]
+		}
+		results, err := scanStore(kv, prefix, limit, keysOnly, transform)
+		if err != nil {
+			return err
+		}
+
+		return writeScanResults(cmd.OutOrStdout(), format, results, keysOnly)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(scanCmd)
+	scanCmd.Flags().StringP("format", "o", output.KindTable, output.Usage)
+	scanCmd.Flags().Int("limit", 0, "Maximum number of results (0 for no limit)")
+	scanCmd.Flags().Bool("keys-only", false, "List keys without reading their values")
+	scanCmd.Flags().StringArray("skip-prefix", nil, "Leave out keys under this prefix (repeatable)")
+	scanCmd.Flags().StringArray("drop", nil, "Remove this JSON field path from values (repeatable)")
+	scanCmd.Flags().StringArray("hash", nil, "Replace this JSON field path with a SHA-256 of its value (repeatable)")
+	scanCmd.Flags().StringArray("replace", nil, "Replace a JSON field, as path=value (repeatable)")
+}
+
+// scanTransform builds the redaction given by the scan flags, or nil
+func scanTransform(cmd *cobra.Command) (*redact.Transform, error) {
+	var opts redact.Options
+	opts.SkipPrefixes, _ = cmd.Flags().GetStringArray("skip-prefix")
+	opts.Drop, _ = cmd.Flags().GetStringArray("drop")
+	opts.Hash, _ = cmd.Flags().GetStringArray("hash")
+	opts.Replace, _ = cmd.Flags().GetStringArray("replace")
+	return redact.New(opts)
+}
+
+// scanStore reads up to limit pairs under prefix, sorted by key, redacted
+// by transform
+func scanStore(kv kvClient, prefix string, limit int, keysOnly bool,
+	transform *redact.Transform) ([]ScanResult, error) {
+	keys, err := kv.ListKeys([]byte(prefix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %w", err)
+	}
+	sort.Strings(keys)
+
+	results := make([]ScanResult, 0, len(keys))
+	for _, key := range keys {
+		if limit > 0 && len(results) >= limit {
+			break
+		}
+		if transform.Skip(key) {
+			continue
+		}
+		result := ScanResult{Key: key}
+		if !keysOnly {
+			value, err := kv.Get([]byte(key))
+			if errors.Is(err, store.ErrKeyNotFound) {
+				continue // Deleted since the keys were listed
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", key, err)
+			}
+			if value, err = transform.Apply(value); err != nil {
+				return nil, fmt.Errorf("failed to redact %s: %w", key, err)
+			}
+			result.Value = string(value)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// writeScanResults prints scan results in the requested format
+func writeScanResults(w io.Writer, format *output.Format, results []ScanResult, keysOnly bool) error {
+	rows := output.Rows{Header: []string{"key", "value"}}
+	if keysOnly {
+		rows.Header = rows.Header[:1]
+	}
+	for _, result := range results {
+		if keysOnly {
+			rows.Add(result.Key)
+		} else {
+			rows.Add(result.Key, result.Value)
+		}
+	}
+	return format.Write(w, results, rows)
+}
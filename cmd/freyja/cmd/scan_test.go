@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/ssargent/freyjadb/pkg/output"
+	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanStore(t *testing.T) {
+	kv, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	require.NoError(t, err)
+	_, err = kv.Open()
+	require.NoError(t, err)
+	defer kv.Close()
+
+	for _, key := range []string{"user:2", "user:1", "user:3", "order:1"} {
+		require.NoError(t, kv.Put([]byte(key), []byte("v-"+key)))
+	}
+
+	results, err := scanStore(kv, "user:", 2, false, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []ScanResult{{Key: "user:1", Value: "v-user:1"}, {Key: "user:2", Value: "v-user:2"}}, results)
+
+	results, err = scanStore(kv, "", 0, true, nil)
+	require.NoError(t, err)
+	require.Len(t, results, 4)
+	assert.Equal(t, "order:1", results[0].Key)
+	assert.Empty(t, results[0].Value)
+
+	tests := []struct {
+		spec     string
+		keysOnly bool
+		want     string
+	}{
+		{"csv", false, "key,value\nuser:1,v-user:1\nuser:2,v-user:2\n"},
+		{"csv", true, "key\nuser:1\nuser:2\n"},
+		{"template={{.Key}}", false, "user:1\nuser:2\n"},
+	}
+	for _, tt := range tests {
+		format, err := output.Parse(tt.spec)
+		require.NoError(t, err)
+		results, err := scanStore(kv, "user:", 2, tt.keysOnly, nil)
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		require.NoError(t, writeScanResults(&buf, format, results, tt.keysOnly))
+		assert.Equal(t, tt.want, buf.String(), tt.spec)
+	}
+}
+
+func TestScanStoreRedacted(t *testing.T) {
+	kv, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	require.NoError(t, err)
+	_, err = kv.Open()
+	require.NoError(t, err)
+	defer kv.Close()
+
+	require.NoError(t, kv.Put([]byte("session:1"), []byte(`{"token":"abc"}`)))
+	require.NoError(t, kv.Put([]byte("user:1"), []byte(`{"name":"bob","password":"x","email":"bob@example.com"}`)))
+	require.NoError(t, kv.Put([]byte("user:2"), []byte("not json")))
+
+	scanCmd.Flags().Set("skip-prefix", "session:")
+	scanCmd.Flags().Set("drop", "password")
+	scanCmd.Flags().Set("replace", "email=hidden")
+	defer func() {
+		for _, name := range []string{"skip-prefix", "drop", "replace"} {
+			scanCmd.Flags().Lookup(name).Value.(pflag.SliceValue).Replace(nil)
+		}
+	}()
+	transform, err := scanTransform(scanCmd)
+	require.NoError(t, err)
+
+	results, err := scanStore(kv, "", 1, false, transform)
+	require.NoError(t, err)
+	assert.Equal(t, []ScanResult{{Key: "user:1", Value: `{"email":"hidden","name":"bob"}`}}, results)
+
+	results, err = scanStore(kv, "user:2", 0, false, transform)
+	require.NoError(t, err)
+	assert.Equal(t, []ScanResult{{Key: "user:2", Value: "not json"}}, results)
+}
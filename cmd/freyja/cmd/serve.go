@@ -8,7 +8,7 @@ import (
 	"strings"
 
 	"github.com/spf13/cobra"
-	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/ssargent/freyjadb/pkg/config"
 )
 
 // serveCmd represents the serve command
@@ -59,8 +59,8 @@ Examples:
 			}
 		}
 
-		// Get store from context
-		kv, ok := cmd.Context().Value("store").(*store.KVStore)
+		// Get store from the container or context
+		kv, ok := serverStore(cmd)
 		if !ok {
 			cmd.Println("Error: store not found in context")
 			return
@@ -72,6 +72,22 @@ Examples:
 			return
 		}
 
+		useStoreManager(cmd, dataDir)
+		grace, _ := cmd.Flags().GetDuration("shutdown-grace")
+		if configPath := config.GetDefaultConfigPath(); config.ConfigExists(configPath) {
+			if cfg, err := config.LoadConfig(configPath); err == nil {
+				if err := useAlertMonitor(cfg.Alerts); err != nil {
+					cmd.Printf("Error: %v\n", err)
+					return
+				}
+				if grace <= 0 {
+					grace = cfg.ShutdownGracePeriod
+				}
+				useMiddleware(cfg.Middleware)
+				container.SetViewerKey(cfg.Security.ViewerAPIKey, cfg.Security.ViewerMaxValueBytes)
+			}
+		}
+		container.SetShutdownGracePeriod(grace)
 		serverFactory := container.GetServerFactory()
 		serverStarter := serverFactory.CreateServerStarter()
 
@@ -97,6 +113,8 @@ func init() {
 	serveCmd.Flags().String("data-dir", "./data", "Data directory for storing databases")
 	serveCmd.Flags().String("system-encryption-key", "", "Encryption key for system data (32 bytes recommended)")
 	serveCmd.Flags().Bool("enable-encryption", false, "Enable encryption for system data")
+	serveCmd.Flags().Duration("shutdown-grace", 0,
+		"How long to wait for in-flight requests when stopping (default from config, or 30s)")
 	serveCmd.MarkFlagRequired("api-key")
 	serveCmd.MarkFlagRequired("system-key")
 }
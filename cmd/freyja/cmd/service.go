@@ -8,11 +8,17 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/ssargent/freyjadb/pkg/api"
 	"github.com/ssargent/freyjadb/pkg/config"
 )
 
+// storeCloseTimeout is the time allowed for closing the store after
+// in-flight requests have drained
+const storeCloseTimeout = 30 * time.Second
+
 // serviceCmd represents the service command
 var serviceCmd = &cobra.Command{
 	Use:   "service",
@@ -44,6 +50,7 @@ Examples:
 		user, _ := cmd.Flags().GetString("user")
 		port, _ := cmd.Flags().GetInt("port")
 		startNow, _ := cmd.Flags().GetBool("start")
+		secretsFile, _ := cmd.Flags().GetString("secrets-file")
 
 		// Use default config path if not specified
 		if configPath == "" {
@@ -72,7 +79,7 @@ Examples:
 			cmd.Printf("✅ Loaded existing configuration\n")
 		} else {
 			// Bootstrap config
-			cfg, err = config.BootstrapConfig(configPath, dataDir)
+			cfg, err = config.BootstrapConfigWithSecrets(configPath, dataDir, secretsFile)
 			if err != nil {
 				cmd.Printf("Error bootstrapping config: %v\n", err)
 				os.Exit(1)
@@ -273,6 +280,7 @@ func init() {
 	// Install command flags
 	installServiceCmd.Flags().String("data-dir", "/var/lib/freyjadb", "Data directory for the service")
 	installServiceCmd.Flags().String("config", "", "Path to config file")
+	installServiceCmd.Flags().String("secrets-file", "", "Keep generated keys in this file instead of the config")
 	installServiceCmd.Flags().String("user", "freyja", "User to run the service as")
 	installServiceCmd.Flags().Int("port", 8080, "Port for the service")
 	installServiceCmd.Flags().Bool("start", true, "Start the service after installation")
@@ -294,6 +302,8 @@ User=%s
 Group=%s
 ExecStart=/usr/local/bin/freyja up --config %s
 Restart=on-failure
+KillSignal=SIGTERM
+TimeoutStopSec=%d
 NoNewPrivileges=true
 UMask=0077
 ReadWritePaths=%s
@@ -301,12 +311,22 @@ ReadWritePaths=%s
 
 [Install]
 WantedBy=multi-user.target
-`, user, user, configPath, cfg.DataDir, filepath.Dir(configPath))
+`, user, user, configPath, stopTimeoutSeconds(cfg.ShutdownGracePeriod), cfg.DataDir, filepath.Dir(configPath))
 
 	unitPath := "/etc/systemd/system/freyja.service"
 	return os.WriteFile(unitPath, []byte(unitContent), 0600)
 }
 
+// stopTimeoutSeconds is how long systemd waits for the server to stop
+// before killing it: the grace period for in-flight requests plus time to
+// close the store
+func stopTimeoutSeconds(grace time.Duration) int {
+	if grace <= 0 {
+		grace = api.DefaultShutdownGracePeriod
+	}
+	return int((grace + storeCloseTimeout).Seconds())
+}
+
 // runSystemctlCommand runs a systemctl command
 func runSystemctlCommand(args ...string) error {
 	return runCommand("systemctl", args...)
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/ssargent/freyjadb/pkg/output"
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// ttlCmd represents the ttl command
+var ttlCmd = &cobra.Command{
+	Use:   "ttl",
+	Short: "Inspect keys that expire",
+}
+
+// ttlReportCmd represents the ttl report command
+var ttlReportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Show how many keys expire in each upcoming window",
+	Long: `Count the keys with a TTL by how soon they expire: within a minute, an
+hour, a day, a week, 30 days or later. Each window lists the keys that
+expire after the previous one and the record bytes they hold, which the
+next compaction after they expire reclaims. Keys that have already expired
+but are still on disk are counted separately.
+
+Examples:
+  freyja ttl report
+  freyja ttl report --format json
+  freyja ttl report --format csv > expiry.csv`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		spec, _ := cmd.Flags().GetString("format")
+		format, err := output.Parse(spec)
+		if err != nil {
+			return err
+		}
+
+		kv, ok := cmd.Context().Value("store").(*store.KVStore)
+		if !ok {
+			return fmt.Errorf("store not found in context")
+		}
+		hist, err := kv.ExpiryHistogram()
+		if err != nil {
+			return fmt.Errorf("failed to build expiry report: %w", err)
+		}
+
+		switch format.Kind {
+		case output.KindTable:
+			return writeExpiryReport(cmd.OutOrStdout(), hist)
+		case output.KindJSON:
+			return output.WriteJSON(cmd.OutOrStdout(), hist)
+		default:
+			// CSV and templates work on the buckets
+			return format.Write(cmd.OutOrStdout(), hist.Buckets, expiryRows(hist.Buckets))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(ttlCmd)
+	ttlCmd.AddCommand(ttlReportCmd)
+	ttlReportCmd.Flags().StringP("format", "o", output.KindTable, output.Usage)
+}
+
+// writeExpiryReport renders a histogram as a table followed by totals
+func writeExpiryReport(w io.Writer, hist *store.ExpiryHistogram) error {
+	if hist.Expiring == 0 && hist.Expired == 0 {
+		_, err := fmt.Fprintln(w, "No keys have a TTL")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "WITHIN\tKEYS\tSIZE")
+	for _, b := range hist.Buckets {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", b.Within, b.Keys, formatBytes(mbToBytes(b.SizeMB)))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\nExpiring: %d keys\n", hist.Expiring)
+	if hist.Expired > 0 {
+		fmt.Fprintf(w, "Expired, awaiting compaction: %d keys, %s\n", hist.Expired,
+			formatBytes(mbToBytes(hist.ExpiredMB)))
+	}
+	return nil
+}
+
+// expiryRows lays histogram buckets out for CSV
+func expiryRows(buckets []store.ExpiryBucket) output.Rows {
+	rows := output.Rows{Header: []string{"within", "keys", "size_bytes"}}
+	for _, b := range buckets {
+		rows.Add(b.Within, strconv.Itoa(b.Keys), strconv.FormatInt(mbToBytes(b.SizeMB), 10))
+	}
+	return rows
+}
+
+// mbToBytes converts the mebibytes of explain output back to bytes
+func mbToBytes(mb float64) int64 {
+	return int64(mb * 1024 * 1024)
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/output"
+	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteExpiryReport(t *testing.T) {
+	kv, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	require.NoError(t, err)
+	_, err = kv.Open()
+	require.NoError(t, err)
+	defer kv.Close()
+
+	var buf bytes.Buffer
+	hist, err := kv.ExpiryHistogram()
+	require.NoError(t, err)
+	require.NoError(t, writeExpiryReport(&buf, hist))
+	assert.Contains(t, buf.String(), "No keys have a TTL")
+
+	require.NoError(t, kv.PutWithTTL([]byte("session:1"), []byte("v"), 10*time.Millisecond))
+	require.NoError(t, kv.PutWithTTL([]byte("session:2"), []byte("v"), 30*time.Minute))
+	require.NoError(t, kv.PutWithTTL([]byte("cache:1"), []byte("v"), 48*time.Hour))
+	time.Sleep(20 * time.Millisecond)
+
+	hist, err = kv.ExpiryHistogram()
+	require.NoError(t, err)
+	buf.Reset()
+	require.NoError(t, writeExpiryReport(&buf, hist))
+	out := buf.String()
+	assert.Contains(t, out, "WITHIN")
+	assert.Regexp(t, `1h\s+1\s`, out)
+	assert.Regexp(t, `7d\s+1\s`, out)
+	assert.Contains(t, out, "Expiring: 2 keys")
+	assert.Contains(t, out, "Expired, awaiting compaction: 1 keys")
+
+	format, err := output.Parse("csv")
+	require.NoError(t, err)
+	buf.Reset()
+	require.NoError(t, format.Write(&buf, hist.Buckets, expiryRows(hist.Buckets)))
+	assert.Contains(t, buf.String(), "within,keys,size_bytes")
+	assert.Contains(t, buf.String(), "later,0,0")
+}
@@ -9,13 +9,13 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/ssargent/freyjadb/pkg/config"
-	"github.com/ssargent/freyjadb/pkg/store"
 )
 
 // upCmd represents the up command
 var upCmd = &cobra.Command{
-	Use:   "up",
-	Short: "Bootstrap and start FreyjaDB server",
+	Use:         "up",
+	Annotations: map[string]string{recoveryProgressAnnotation: "true"},
+	Short:       "Bootstrap and start FreyjaDB server",
 	Long: `Bootstrap FreyjaDB by creating configuration and keys if they don't exist,
 then start the REST API server. This is the recommended way to get FreyjaDB running.
 
@@ -34,6 +34,7 @@ Examples:
 		bind, _ := cmd.Flags().GetString("bind")
 		configPath, _ := cmd.Flags().GetString("config")
 		printKeys, _ := cmd.Flags().GetBool("print-keys")
+		secretsFile, _ := cmd.Flags().GetString("secrets-file")
 
 		// Use default config path if not specified
 		if configPath == "" {
@@ -56,7 +57,7 @@ Examples:
 			// Bootstrap new config
 			cmd.Printf("🔧 First run detected. Bootstrapping FreyjaDB...\n")
 
-			cfg, err = config.BootstrapConfig(configPath, dataDir)
+			cfg, err = config.BootstrapConfigWithSecrets(configPath, dataDir, secretsFile)
 			if err != nil {
 				cmd.Printf("Error bootstrapping config: %v\n", err)
 				os.Exit(1)
@@ -69,7 +70,11 @@ Examples:
 				cmd.Printf("System Key: %s\n", cfg.Security.SystemKey)
 				cmd.Printf("System API Key: %s\n", cfg.Security.SystemAPIKey)
 				cmd.Printf("Client API Key: %s\n", cfg.Security.ClientAPIKey)
-				cmd.Printf("\n⚠️  Store these keys securely! They are also saved in %s\n", configPath)
+				savedIn := configPath
+				if cfg.SecretsFile != "" {
+					savedIn = config.SecretsPath(cfg, configPath)
+				}
+				cmd.Printf("\n⚠️  Store these keys securely! They are also saved in %s\n", savedIn)
 			}
 		}
 
@@ -83,6 +88,9 @@ Examples:
 		if bind != "127.0.0.1" { // Only override if explicitly set
 			cfg.Bind = bind
 		}
+		if grace, _ := cmd.Flags().GetDuration("shutdown-grace"); grace > 0 {
+			cfg.ShutdownGracePeriod = grace
+		}
 
 		// Initialize system if needed
 		if err := initializeSystemIfNeeded(cfg); err != nil {
@@ -99,11 +107,19 @@ Examples:
 			os.Exit(1)
 		}
 
+		useStoreManager(cmd, cfg.DataDir)
+		container.SetShutdownGracePeriod(cfg.ShutdownGracePeriod)
+		useMiddleware(cfg.Middleware)
+		container.SetViewerKey(cfg.Security.ViewerAPIKey, cfg.Security.ViewerMaxValueBytes)
+		if err := useAlertMonitor(cfg.Alerts); err != nil {
+			cmd.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
 		serverFactory := container.GetServerFactory()
 		serverStarter := serverFactory.CreateServerStarter()
 
-		// Get store from context (created by root command)
-		kv, ok := cmd.Context().Value("store").(*store.KVStore)
+		// Get store from the container or context (created by root command)
+		kv, ok := serverStore(cmd)
 		if !ok {
 			cmd.Printf("Error: store not found in context\n")
 			os.Exit(1)
@@ -125,7 +141,11 @@ func init() {
 	upCmd.Flags().String("bind", "127.0.0.1", "Address to bind server to")
 	upCmd.Flags().String("config", "", "Path to config file (default: OS-specific location)")
 	upCmd.Flags().Bool("non-interactive", false, "Skip prompts and use defaults")
+	upCmd.Flags().Duration("shutdown-grace", 0,
+		"How long to wait for in-flight requests when stopping (default from config, or 30s)")
 	upCmd.Flags().Bool("print-keys", false, "Print generated API keys to console")
+	upCmd.Flags().String("secrets-file", "",
+		"Keep generated keys in this file (mode 0600) instead of the config; relative to the config directory")
 }
 
 // initializeSystemIfNeeded initializes the system store if it doesn't exist
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// addConflictFlags registers the flags saveEntity understands
+func addConflictFlags(cmd *cobra.Command, withExpectVersion bool) {
+	cmd.Flags().Bool("force", false, "Overwrite even if the entity was changed by another writer")
+	if withExpectVersion {
+		cmd.Flags().Int64("expect-version", 0, "Fail unless the stored entity is at this version")
+	}
+}
+
+// applyExpectedVersion makes the write conditional on --expect-version, if given
+func applyExpectedVersion(cmd *cobra.Command, entity *Entity) {
+	if cmd.Flags().Changed("expect-version") {
+		entity.Version, _ = cmd.Flags().GetInt64("expect-version")
+	}
+}
+
+// saveEntity stores entity. If another writer changed it since it was read,
+// both versions are shown and the user is asked whether to overwrite, unless
+// --force (always overwrite) or --yes (assume yes) was given.
+func saveEntity(cmd *cobra.Command, entity *Entity) error {
+	if force, _ := cmd.Flags().GetBool("force"); force {
+		return loreStore.ForcePutEntity(entity)
+	}
+
+	err := loreStore.PutEntity(entity)
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		return err
+	}
+
+	if err := writeConflict(os.Stdout, conflict); err != nil {
+		return err
+	}
+	if !config.Yes && !confirm("Overwrite the stored version with yours? (y/N): ") {
+		return fmt.Errorf("%w (re-run with --force to overwrite)", conflict)
+	}
+	return loreStore.ForcePutEntity(entity)
+}
+
+// confirm asks a yes/no question on stdin
+func confirm(prompt string) bool {
+	fmt.Print(prompt)
+	var response string
+	if n, err := fmt.Scanln(&response); err != nil || n != 1 {
+		return false
+	}
+	response = strings.ToLower(response)
+	return response == confirmYes || response == confirmYesLong
+}
+
+// writeConflict shows the fields that differ between the stored and attempted versions
+func writeConflict(out io.Writer, conflict *ConflictError) error {
+	if _, err := fmt.Fprintf(out, "%s\n\n", conflict.Error()); err != nil {
+		return err
+	}
+
+	stored, yours := conflict.Current, conflict.Attempted
+	if stored == nil {
+		stored = &Entity{}
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "FIELD\tSTORED (v%d)\tYOURS\n", stored.Version)
+	fields := []struct {
+		name          string
+		stored, yours string
+	}{
+		{"Name", stored.Name, yours.Name},
+		{"AKA", formatStringSlice(stored.Aka), formatStringSlice(yours.Aka)},
+		{"Summary", stored.Summary, yours.Summary},
+		{"Details", stored.Details, yours.Details},
+		{"Tags", formatStringSlice(stored.Tags), formatStringSlice(yours.Tags)},
+		{"Links", fmt.Sprint(len(stored.Links)), fmt.Sprint(len(yours.Links))},
+	}
+	names := sortedFieldNames(stored.Fields)
+	for _, name := range sortedFieldNames(yours.Fields) {
+		if _, ok := stored.Fields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		fields = append(fields, struct {
+			name          string
+			stored, yours string
+		}{name, formatFieldValue(stored.Fields[name]), formatFieldValue(yours.Fields[name])})
+	}
+	for _, f := range fields {
+		if f.stored != f.yours {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", f.name, f.stored, f.yours)
+		}
+	}
+	if conflict.Current != nil {
+		fmt.Fprintf(w, "Updated\t%s\t\n", stored.UpdatedAt.Format("2006-01-02 15:04:05"))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(out)
+	return err
+}
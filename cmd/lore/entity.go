@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"time"
 )
 
@@ -12,6 +13,7 @@ const (
 	EntityTypeCharacter EntityType = "character"
 	EntityTypePlace     EntityType = "place"
 	EntityTypeGroup     EntityType = "group"
+	EntityTypeEvent     EntityType = "event"
 )
 
 // Link represents a relationship between entities
@@ -23,16 +25,18 @@ type Link struct {
 
 // Entity represents a lore entity with common fields
 type Entity struct {
-	ID        string     `json:"id"`
-	Type      EntityType `json:"type"`
-	Name      string     `json:"name"`
-	Aka       []string   `json:"aka,omitempty"`
-	Summary   string     `json:"summary,omitempty"`
-	Details   string     `json:"details,omitempty"`
-	Tags      []string   `json:"tags,omitempty"`
-	Links     []Link     `json:"links,omitempty"`
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at"`
+	ID        string                 `json:"id"`
+	Type      EntityType             `json:"type"`
+	Name      string                 `json:"name"`
+	Aka       []string               `json:"aka,omitempty"`
+	Summary   string                 `json:"summary,omitempty"`
+	Details   string                 `json:"details,omitempty"`
+	Tags      []string               `json:"tags,omitempty"`
+	Links     []Link                 `json:"links,omitempty"`
+	Fields    map[string]interface{} `json:"fields,omitempty"` // Custom fields declared by the entity's type
+	Version   int64                  `json:"version"`          // Incremented on every write; 0 means never stored
+	CreatedAt time.Time              `json:"created_at"`
+	UpdatedAt time.Time              `json:"updated_at"`
 }
 
 // Character represents a character entity
@@ -97,3 +101,22 @@ type LoreError struct {
 func (e *LoreError) Error() string {
 	return e.Message
 }
+
+// ConflictError reports that an entity changed since the writer read it
+type ConflictError struct {
+	Current   *Entity // Stored version, nil if the entity was deleted
+	Attempted *Entity // Version the writer tried to store
+}
+
+func (e *ConflictError) Error() string {
+	if e.Current == nil {
+		return fmt.Sprintf("conflict: %s '%s' was deleted since version %d was read",
+			e.Attempted.Type, e.Attempted.ID, e.Attempted.Version)
+	}
+	if e.Attempted.Version == 0 {
+		return fmt.Sprintf("conflict: %s '%s' already exists (version %d)",
+			e.Current.Type, e.Current.ID, e.Current.Version)
+	}
+	return fmt.Sprintf("conflict: %s '%s' is at version %d, expected %d",
+		e.Current.Type, e.Current.ID, e.Current.Version, e.Attempted.Version)
+}
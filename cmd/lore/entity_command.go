@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// newEntityCommand generates the create/get/list/update/delete commands for
+// an entity type, with a flag for each of the type's custom fields
+func newEntityCommand(def *EntityTypeDef) *cobra.Command {
+	name := def.Name
+	entityType := EntityType(name)
+	title := strings.ToUpper(name[:1]) + name[1:]
+
+	short := "Manage " + name + " entities"
+	if def.Description != "" {
+		short = "Manage " + def.Description
+	}
+	typeCmd := &cobra.Command{
+		Use:   name,
+		Short: short,
+		Long:  fmt.Sprintf(`Create, read, update, and delete %s entities.`, name),
+	}
+
+	createCmd := &cobra.Command{
+		Use:   "create <name> [flags]",
+		Short: fmt.Sprintf("Create a new %s", name),
+		Long: fmt.Sprintf(`Create a new %s with the specified name.
+
+Examples:
+  lore %s create "Example" --summary "A short summary" --tags "one,two"`, name, name),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			entityName := args[0]
+
+			// Create entity
+			entity := NewEntity(entityType, entityName)
+			entity.Summary, _ = cmd.Flags().GetString("summary")
+			entity.Details, _ = cmd.Flags().GetString("details")
+			tagsStr, _ := cmd.Flags().GetString("tags")
+			entity.Tags = splitList(tagsStr)
+			if def.Aka {
+				akaStr, _ := cmd.Flags().GetString("aka")
+				entity.Aka = splitList(akaStr)
+			}
+
+			// Fill in custom fields, falling back to the template's defaults
+			for _, field := range def.Fields {
+				raw, _ := cmd.Flags().GetString(field.Name)
+				if raw == "" {
+					raw = field.Default
+				}
+				if raw == "" {
+					continue
+				}
+				if err := setField(entity, &field, raw); err != nil {
+					return err
+				}
+			}
+
+			// Store entity; an existing entity with the same ID is a conflict
+			if err := saveEntity(cmd, entity); err != nil {
+				return fmt.Errorf("failed to create %s: %w", name, err)
+			}
+
+			if !config.Quiet {
+				fmt.Printf("Created %s '%s' with ID '%s'\n", name, entityName, entity.ID)
+			}
+
+			return nil
+		},
+	}
+
+	getCmd := &cobra.Command{
+		Use:   "get <id>",
+		Short: fmt.Sprintf("Get a %s by ID", name),
+		Long:  fmt.Sprintf(`Retrieve and display a %s by its ID.`, name),
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			entity, err := loreStore.GetEntity(entityType, args[0])
+			if err != nil {
+				return err
+			}
+
+			return outputEntity(entity)
+		},
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: fmt.Sprintf("List all %s entities", name),
+		Long:  fmt.Sprintf(`List all %s entities in the project.`, name),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			entities, err := loreStore.ListEntities(entityType)
+			if err != nil {
+				return err
+			}
+
+			return outputEntities(entities)
+		},
+	}
+
+	updateCmd := &cobra.Command{
+		Use:   "update <id> [flags]",
+		Short: fmt.Sprintf("Update a %s", name),
+		Long: fmt.Sprintf(`Update an existing %s with new information. Passing an empty
+value for an optional custom field removes it.
+
+Examples:
+  lore %s update example --summary "A longer summary"`, name, name),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id := args[0]
+
+			// Get existing entity
+			entity, err := loreStore.GetEntity(entityType, id)
+			if err != nil {
+				return err
+			}
+			applyExpectedVersion(cmd, entity)
+
+			// Get flags and update entity
+			if summary, _ := cmd.Flags().GetString("summary"); summary != "" {
+				entity.Summary = summary
+			}
+			if def.Aka {
+				if akaStr, _ := cmd.Flags().GetString("aka"); akaStr != "" {
+					entity.Aka = splitList(akaStr)
+				}
+			}
+			if tagsStr, _ := cmd.Flags().GetString("tags"); tagsStr != "" {
+				entity.Tags = splitList(tagsStr)
+			}
+			if details, _ := cmd.Flags().GetString("details"); details != "" {
+				entity.Details = details
+			}
+			for _, field := range def.Fields {
+				if !cmd.Flags().Changed(field.Name) {
+					continue
+				}
+				raw, _ := cmd.Flags().GetString(field.Name)
+				if raw == "" {
+					delete(entity.Fields, field.Name)
+					continue
+				}
+				if err := setField(entity, &field, raw); err != nil {
+					return err
+				}
+			}
+
+			// Store updated entity, detecting edits made since it was read
+			if err := saveEntity(cmd, entity); err != nil {
+				return fmt.Errorf("failed to update %s: %w", name, err)
+			}
+
+			if !config.Quiet {
+				fmt.Printf("Updated %s '%s'\n", name, id)
+			}
+
+			return nil
+		},
+	}
+
+	deleteCmd := &cobra.Command{
+		Use:   "delete <id>",
+		Short: fmt.Sprintf("Delete a %s", name),
+		Long:  fmt.Sprintf(`Delete a %s by its ID.`, name),
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id := args[0]
+
+			// Confirm deletion if not using --yes flag
+			if !config.Yes && !confirm(fmt.Sprintf("Are you sure you want to delete %s '%s'? (y/N): ", name, id)) {
+				fmt.Println("Deletion cancelled")
+				return nil
+			}
+
+			if err := loreStore.DeleteEntity(entityType, id); err != nil {
+				return err
+			}
+
+			if !config.Quiet {
+				fmt.Printf("Deleted %s '%s'\n", name, id)
+			}
+
+			return nil
+		},
+	}
+
+	// Common and custom field flags
+	for _, cmd := range []*cobra.Command{createCmd, updateCmd} {
+		cmd.Flags().String("summary", "", title+" summary")
+		if def.Aka {
+			cmd.Flags().String("aka", "", "Alternative names (comma-separated)")
+		}
+		cmd.Flags().String("tags", "", "Tags (comma-separated)")
+		cmd.Flags().String("details", "", "Detailed description")
+		for _, field := range def.Fields {
+			cmd.Flags().String(field.Name, "", fieldUsage(&field))
+		}
+	}
+
+	// Concurrent-edit handling
+	addConflictFlags(createCmd, false)
+	addConflictFlags(updateCmd, true)
+
+	typeCmd.AddCommand(createCmd, getCmd, listCmd, updateCmd, deleteCmd)
+	return typeCmd
+}
+
+// setField parses raw for field and stores it on the entity
+func setField(entity *Entity, field *FieldDef, raw string) error {
+	value, err := field.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if entity.Fields == nil {
+		entity.Fields = make(map[string]interface{})
+	}
+	entity.Fields[field.Name] = value
+	return nil
+}
+
+// fieldUsage describes a custom field flag
+func fieldUsage(field *FieldDef) string {
+	usage := field.Description
+	if usage == "" {
+		usage = strings.ToUpper(field.Name[:1]) + field.Name[1:]
+	}
+	usage += " (" + field.Type
+	if field.Required {
+		usage += ", required"
+	}
+	if len(field.Values) > 0 {
+		usage += ", one of " + strings.Join(field.Values, "|")
+	}
+	usage += ")"
+	if field.Default != "" {
+		usage += " [default " + field.Default + "]"
+	}
+	return usage
+}
@@ -3,8 +3,11 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/ssargent/freyjadb/pkg/output"
 )
 
 // Global configuration
@@ -22,16 +25,15 @@ const (
 
 // Global variables
 var (
-	config    Config
-	loreStore *LoreStore
-	rootCmd   *cobra.Command
+	config       Config
+	loreStore    *LoreStore
+	outputFormat *output.Format
+	rootCmd      *cobra.Command
 )
 
 func main() {
 	setupRootCmd()
-	setupCharacterCommands()
-	setupGroupCommands()
-	setupPlaceCommands()
+	setupEntityCommands()
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
@@ -43,15 +45,22 @@ func setupRootCmd() {
 		Use:   "lore",
 		Short: "Book Lore CLI - Manage writing reference notes",
 		Long: `A command-line tool for writers to create, browse, and update
-reference notes about characters, places, and groups.
+reference notes about characters, places, groups, events, and any
+custom entity types defined with "lore type define".
 
 Examples:
    lore character create "John Doe" --summary "A brave knight"
    lore place list
-   lore group get merchants-guild`,
+   lore group get merchants-guild
+   lore timeline --character john-doe
+   lore type define artifact --field power:string:required`,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-			// Initialize store
 			var err error
+			if outputFormat, err = output.Parse(config.Format); err != nil {
+				return err
+			}
+
+			// Initialize store
 			loreStore, err = NewLoreStore(config.ProjectDir)
 			if err != nil {
 				return fmt.Errorf("failed to initialize store: %w", err)
@@ -75,7 +84,7 @@ Examples:
 
 	// Global flags
 	rootCmd.PersistentFlags().StringVarP(&config.ProjectDir, "project", "p", ".", "path to project directory")
-	rootCmd.PersistentFlags().StringVarP(&config.Format, "format", "o", "table", "output format (table or json)")
+	rootCmd.PersistentFlags().StringVarP(&config.Format, "format", "o", "table", output.Usage)
 	rootCmd.PersistentFlags().BoolVarP(&config.Quiet, "quiet", "q", false, "suppress non-essential messages")
 	rootCmd.PersistentFlags().BoolVarP(&config.Yes, "yes", "y", false, "assume 'yes' for prompts")
 
@@ -85,8 +94,45 @@ Examples:
 	}
 
 	// Add subcommands
-	rootCmd.AddCommand(characterCmd)
-	rootCmd.AddCommand(placeCmd)
-	rootCmd.AddCommand(groupCmd)
 	rootCmd.AddCommand(relationshipCmd)
+	rootCmd.AddCommand(typeCmd)
+	rootCmd.AddCommand(timelineCmd)
+}
+
+// setupEntityCommands adds a command for each entity type. Custom types live
+// in the project's store, so the project flag is read ahead of cobra to find
+// them; if the project has no store yet only the builtin types are added.
+func setupEntityCommands() {
+	defs := builtinEntityTypes
+	if custom, err := loadProjectEntityTypes(os.Args[1:]); err == nil {
+		defs = custom
+	}
+
+	for _, def := range defs {
+		rootCmd.AddCommand(newEntityCommand(def))
+	}
+}
+
+// loadProjectEntityTypes lists the entity types of the project named by args
+func loadProjectEntityTypes(args []string) ([]*EntityTypeDef, error) {
+	flags := pflag.NewFlagSet("lore", pflag.ContinueOnError)
+	flags.ParseErrorsWhitelist.UnknownFlags = true
+	flags.Usage = func() {}
+	projectDir := flags.StringP("project", "p", config.ProjectDir, "")
+	_ = flags.Parse(args)
+
+	if _, err := os.Stat(filepath.Join(*projectDir, ".lore")); err != nil {
+		return nil, err
+	}
+
+	ls, err := NewLoreStore(*projectDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := ls.Open(); err != nil {
+		return nil, err
+	}
+	defer func() { _ = ls.Close() }()
+
+	return ls.ListEntityTypes()
 }
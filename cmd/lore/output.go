@@ -1,29 +1,65 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
 	"text/tabwriter"
 	"time"
-)
 
-const formatJSON = "json"
+	"github.com/ssargent/freyjadb/pkg/output"
+)
 
 // outputEntity displays a single entity
 func outputEntity(entity *Entity) error {
-	if config.Format == formatJSON {
-		return outputEntityJSON(entity)
+	if outputFormat.Kind == output.KindTable {
+		return outputEntityTable(entity)
 	}
-	return outputEntityTable(entity)
+	return outputFormat.Write(os.Stdout, entity, entityRows([]*Entity{entity}))
 }
 
 // outputEntities displays multiple entities
 func outputEntities(entities []*Entity) error {
-	if config.Format == formatJSON {
-		return outputEntitiesJSON(entities)
+	if outputFormat.Kind == output.KindTable {
+		return outputEntitiesTable(entities)
+	}
+	return outputFormat.Write(os.Stdout, entities, entityRows(entities))
+}
+
+// entityRows lays entities out for CSV, one column per field, with the
+// union of their custom fields after the common ones
+func entityRows(entities []*Entity) output.Rows {
+	custom := make(map[string]interface{})
+	for _, entity := range entities {
+		for name := range entity.Fields {
+			custom[name] = nil
+		}
+	}
+	fieldNames := sortedFieldNames(custom)
+
+	rows := output.Rows{Header: append([]string{
+		"id", "type", "name", "aka", "summary", "details", "tags", "version", "created_at", "updated_at",
+	}, fieldNames...)}
+	for _, entity := range entities {
+		row := []string{
+			entity.ID,
+			string(entity.Type),
+			entity.Name,
+			formatStringSlice(entity.Aka),
+			entity.Summary,
+			entity.Details,
+			formatStringSlice(entity.Tags),
+			strconv.FormatInt(entity.Version, 10),
+			entity.CreatedAt.Format(time.RFC3339),
+			entity.UpdatedAt.Format(time.RFC3339),
+		}
+		for _, name := range fieldNames {
+			row = append(row, formatFieldValue(entity.Fields[name]))
+		}
+		rows.Add(row...)
 	}
-	return outputEntitiesTable(entities)
+	return rows
 }
 
 // outputEntityTable displays a single entity in table format
@@ -65,12 +101,21 @@ func outputEntityTable(entity *Entity) error {
 		}
 	}
 
+	for _, name := range sortedFieldNames(entity.Fields) {
+		if _, err := fmt.Fprintf(w, "%s:\t%s\n", name, formatFieldValue(entity.Fields[name])); err != nil {
+			return err
+		}
+	}
+
 	if len(entity.Links) > 0 {
 		if _, err := fmt.Fprintf(w, "Links:\t%d relationships\n", len(entity.Links)); err != nil {
 			return err
 		}
 	}
 
+	if _, err := fmt.Fprintf(w, "Version:\t%d\n", entity.Version); err != nil {
+		return err
+	}
 	if _, err := fmt.Fprintf(w, "Created:\t%s\n", entity.CreatedAt.Format(time.RFC3339)); err != nil {
 		return err
 	}
@@ -119,26 +164,48 @@ func outputEntitiesTable(entities []*Entity) error {
 	return nil
 }
 
-// outputEntityJSON displays a single entity in JSON format
-func outputEntityJSON(entity *Entity) error {
-	encoder := json.NewEncoder(os.Stdout)
-	encoder.SetIndent("", "  ")
-	return encoder.Encode(entity)
-}
+// outputTimeline displays events in the order given
+func outputTimeline(events []*Entity) error {
+	if outputFormat.Kind != output.KindTable {
+		return outputFormat.Write(os.Stdout, events, entityRows(events))
+	}
 
-// outputEntitiesJSON displays multiple entities in JSON format
-func outputEntitiesJSON(entities []*Entity) error {
-	encoder := json.NewEncoder(os.Stdout)
-	encoder.SetIndent("", "  ")
-	return encoder.Encode(entities)
+	if len(events) == 0 {
+		fmt.Println("No events found")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer func() { _ = w.Flush() }()
+
+	fmt.Fprintln(w, "AT\tID\tNAME\tSUMMARY")
+	for _, event := range events {
+		summary := event.Summary
+		if len(summary) > 50 {
+			summary = summary[:47] + "..."
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			formatFieldValue(event.Fields[eventTimeField]), event.ID, event.Name, summary)
+	}
+
+	return nil
 }
 
 // outputEntityWithRelationships displays an entity with its relationships
 func outputEntityWithRelationships(entityWithRels *EntityWithRelationships) error {
-	if config.Format == formatJSON {
-		return outputEntityWithRelationshipsJSON(entityWithRels)
+	if outputFormat.Kind == output.KindTable {
+		return outputEntityWithRelationshipsTable(entityWithRels)
+	}
+
+	// CSV has one row per relationship; JSON and templates see the whole result
+	rows := output.Rows{Header: []string{"direction", "relation", "key"}}
+	for _, rel := range entityWithRels.Outgoing {
+		rows.Add(rel.Direction, rel.Relationship.Relation, rel.OtherKey)
 	}
-	return outputEntityWithRelationshipsTable(entityWithRels)
+	for _, rel := range entityWithRels.Incoming {
+		rows.Add(rel.Direction, rel.Relationship.Relation, rel.OtherKey)
+	}
+	return outputFormat.Write(os.Stdout, entityWithRels, rows)
 }
 
 // outputEntityWithRelationshipsTable displays an entity with relationships in table format
@@ -180,13 +247,6 @@ func outputEntityWithRelationshipsTable(entityWithRels *EntityWithRelationships)
 	return nil
 }
 
-// outputEntityWithRelationshipsJSON displays an entity with relationships in JSON format
-func outputEntityWithRelationshipsJSON(entityWithRels *EntityWithRelationships) error {
-	encoder := json.NewEncoder(os.Stdout)
-	encoder.SetIndent("", "  ")
-	return encoder.Encode(entityWithRels)
-}
-
 // formatStringSlice formats a slice of strings for display
 func formatStringSlice(slice []string) string {
 	if len(slice) == 0 {
@@ -198,3 +258,34 @@ func formatStringSlice(slice []string) string {
 	}
 	return result
 }
+
+// sortedFieldNames returns the names of an entity's custom fields in order
+func sortedFieldNames(fields map[string]interface{}) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// formatFieldValue formats a custom field value for display. Values read
+// back from JSON hold lists as []interface{} and numbers as float64.
+func formatFieldValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []string:
+		return formatStringSlice(v)
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = formatFieldValue(item)
+		}
+		return formatStringSlice(items)
+	default:
+		return fmt.Sprint(v)
+	}
+}
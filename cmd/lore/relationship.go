@@ -146,16 +146,12 @@ func parseEntitySpec(spec string) (EntityType, string, error) {
 		return "", "", fmt.Errorf("invalid entity specification: %s (expected format: type:id)", spec)
 	}
 
-	entityType := EntityType(parts[0])
-	id := parts[1]
-
-	// Validate entity type
-	switch entityType {
-	case EntityTypeCharacter, EntityTypePlace, EntityTypeGroup:
-		return entityType, id, nil
-	default:
-		return "", "", fmt.Errorf("unknown entity type: %s", entityType)
+	// Validate entity type against the builtin and project-defined types
+	if _, err := loreStore.GetEntityType(parts[0]); err != nil {
+		return "", "", err
 	}
+
+	return EntityType(parts[0]), parts[1], nil
 }
 
 func init() {
@@ -1,19 +1,24 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
 
+	"github.com/ssargent/freyjadb/pkg/index"
+	"github.com/ssargent/freyjadb/pkg/keys"
 	"github.com/ssargent/freyjadb/pkg/store"
 )
 
 // LoreStore manages persistence of lore entities using FreyjaDB
 type LoreStore struct {
-	kvStore *store.KVStore
-	isOpen  bool
+	kvStore  *store.KVStore
+	indexes  *index.IndexManager // Secondary indexes, e.g. the event timeline
+	indexDir string
+	isOpen   bool
 }
 
 // NewLoreStore creates a new lore store
@@ -32,8 +37,10 @@ func NewLoreStore(projectDir string) (*LoreStore, error) {
 	}
 
 	return &LoreStore{
-		kvStore: kvStore,
-		isOpen:  false,
+		kvStore:  kvStore,
+		indexes:  index.NewIndexManager(indexOrder),
+		indexDir: filepath.Join(dataDir, "indexes"),
+		isOpen:   false,
 	}, nil
 }
 
@@ -49,6 +56,11 @@ func (ls *LoreStore) Open() error {
 	}
 
 	ls.isOpen = true
+	if err := ls.openIndexes(); err != nil {
+		ls.isOpen = false
+		_ = ls.kvStore.Close()
+		return err
+	}
 	return nil
 }
 
@@ -59,12 +71,19 @@ func (ls *LoreStore) Close() error {
 	}
 
 	ls.isOpen = false
-	return ls.kvStore.Close()
+	indexErr := ls.indexes.CheckpointAll(ls.indexDir)
+	if err := ls.kvStore.Close(); err != nil {
+		return err
+	}
+	if indexErr != nil {
+		return fmt.Errorf("failed to save indexes: %w", indexErr)
+	}
+	return nil
 }
 
 // makeKey creates a storage key for an entity
 func makeKey(entityType EntityType, id string) []byte {
-	return []byte(fmt.Sprintf("%s:%s", entityType, id))
+	return []byte(keys.Compose(string(entityType), id))
 }
 
 // generateID creates a slug-like ID from a name
@@ -87,8 +106,21 @@ func generateID(name string) string {
 	return id
 }
 
-// PutEntity stores an entity
+// PutEntity stores an entity if the stored copy is still at entity.Version
+// (0 for a new entity). On success entity.Version is advanced; if another
+// writer got there first a *ConflictError is returned and nothing is written.
 func (ls *LoreStore) PutEntity(entity *Entity) error {
+	return ls.putEntity(entity, false)
+}
+
+// ForcePutEntity stores an entity regardless of the stored version
+func (ls *LoreStore) ForcePutEntity(entity *Entity) error {
+	return ls.putEntity(entity, true)
+}
+
+// putEntity writes an entity with a compare-and-swap on its version, using
+// the store's atomic Update so the check and the write cannot interleave
+func (ls *LoreStore) putEntity(entity *Entity, force bool) error {
 	if !ls.isOpen {
 		return fmt.Errorf("store is not open")
 	}
@@ -101,17 +133,51 @@ func (ls *LoreStore) PutEntity(entity *Entity) error {
 	if err := entity.Validate(); err != nil {
 		return err
 	}
-
-	// Update timestamp
-	entity.UpdatedAt = time.Now()
+	def, err := ls.GetEntityType(string(entity.Type))
+	if err != nil {
+		return err
+	}
+	if err := def.ValidateFields(entity); err != nil {
+		return err
+	}
 
 	key := makeKey(entity.Type, entity.ID)
-	data, err := entity.ToJSON()
+	var written Entity
+	var previous *Entity
+	err = ls.kvStore.Update(key, func(old []byte) ([]byte, error) {
+		var current *Entity
+		if old != nil {
+			var err error
+			if current, err = EntityFromJSON(old); err != nil {
+				return nil, fmt.Errorf("failed to deserialize stored entity: %w", err)
+			}
+		}
+
+		var currentVersion int64
+		if current != nil {
+			currentVersion = current.Version
+		}
+		if !force && currentVersion != entity.Version {
+			return nil, &ConflictError{Current: current, Attempted: entity}
+		}
+
+		previous = current
+		written = *entity
+		written.Version = currentVersion + 1
+		written.UpdatedAt = time.Now()
+		return written.ToJSON()
+	})
 	if err != nil {
-		return fmt.Errorf("failed to serialize entity: %w", err)
+		var conflict *ConflictError
+		if errors.As(err, &conflict) {
+			return conflict
+		}
+		return fmt.Errorf("failed to store entity: %w", err)
 	}
 
-	return ls.kvStore.Put(key, data)
+	entity.Version = written.Version
+	entity.UpdatedAt = written.UpdatedAt
+	return ls.reindexEvent(previous, &written)
 }
 
 // GetEntity retrieves an entity by type and ID
@@ -146,7 +212,7 @@ func (ls *LoreStore) DeleteEntity(entityType EntityType, id string) error {
 	key := makeKey(entityType, id)
 
 	// Check if entity exists first
-	_, err := ls.kvStore.Get(key)
+	data, err := ls.kvStore.Get(key)
 	if err != nil {
 		if err == store.ErrKeyNotFound {
 			return &LoreError{fmt.Sprintf("%s '%s' not found", entityType, id)}
@@ -154,7 +220,13 @@ func (ls *LoreStore) DeleteEntity(entityType EntityType, id string) error {
 		return fmt.Errorf("failed to check entity existence: %w", err)
 	}
 
-	return ls.kvStore.Delete(key)
+	if err := ls.kvStore.Delete(key); err != nil {
+		return err
+	}
+	if entity, err := EntityFromJSON(data); err == nil {
+		return ls.reindexEvent(entity, nil)
+	}
+	return nil
 }
 
 // ListEntities returns all entities of a given type
@@ -163,14 +235,13 @@ func (ls *LoreStore) ListEntities(entityType EntityType) ([]*Entity, error) {
 		return nil, fmt.Errorf("store is not open")
 	}
 
-	prefix := fmt.Sprintf("%s:", entityType)
-	keys, err := ls.kvStore.ListKeys([]byte(prefix))
+	stored, err := ls.kvStore.ListKeys([]byte(keys.Keyspace(entityType).Prefix()))
 	if err != nil {
 		return nil, fmt.Errorf("failed to list keys: %w", err)
 	}
 
 	var entities []*Entity
-	for _, key := range keys {
+	for _, key := range stored {
 		value, err := ls.kvStore.Get([]byte(key))
 		if err != nil {
 			continue // Skip keys that can't be read
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/ssargent/freyjadb/pkg/index"
+)
+
+const (
+	eventTimeField     = "at"       // Field of the builtin event type that places it on the timeline
+	timelineIndexField = "event_at" // Secondary index over event times
+	indexOrder         = 32
+)
+
+// parseStoryTime converts an event time to a number that orders the
+// timeline. Plain integers are story dates (e.g. a year of the story's own
+// calendar); calendar dates and RFC 3339 timestamps become Unix seconds.
+// A project should stick to one kind so that times compare meaningfully.
+func parseStoryTime(raw string) (int64, error) {
+	raw = strings.TrimSpace(raw)
+	if n, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return n, nil
+	}
+	if t, err := time.Parse(fieldDateLayout, raw); err == nil {
+		return t.Unix(), nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t.Unix(), nil
+	}
+	return 0, fmt.Errorf("must be a story date number, YYYY-MM-DD or an RFC 3339 timestamp")
+}
+
+// timelineKey maps a story time onto the index's key space. The index
+// compares int64 values by their big-endian bytes, which orders negative
+// numbers after positive ones; flipping the sign bit restores numeric order.
+func timelineKey(t int64) int64 {
+	return t ^ math.MinInt64
+}
+
+// eventTime returns the timeline position of an event, if it has one
+func eventTime(entity *Entity) (int64, bool) {
+	if entity == nil || entity.Type != EntityTypeEvent {
+		return 0, false
+	}
+	raw, ok := entity.Fields[eventTimeField].(string)
+	if !ok {
+		return 0, false
+	}
+	t, err := parseStoryTime(raw)
+	return t, err == nil
+}
+
+// openIndexes loads the secondary indexes, building the timeline from the
+// stored events the first time a project is opened with it, or if its
+// files could not be loaded
+func (ls *LoreStore) openIndexes() error {
+	if err := os.MkdirAll(ls.indexDir, 0o750); err != nil {
+		return fmt.Errorf("failed to create index directory: %w", err)
+	}
+	if err := ls.indexes.LoadAll(ls.indexDir); err != nil {
+		return fmt.Errorf("failed to load indexes: %w", err)
+	}
+
+	existing, err := filepath.Glob(filepath.Join(ls.indexDir, "index_"+timelineIndexField+".*"))
+	if err != nil {
+		return err
+	}
+	idx := ls.indexes.GetOrCreateIndex(timelineIndexField)
+	if len(existing) == 0 || !idx.Available() {
+		if _, err := ls.RebuildTimeline(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reindexEvent moves an event's timeline entry from its old time to its new
+// one. Either side may be nil, for a created or deleted entity.
+func (ls *LoreStore) reindexEvent(old, updated *Entity) error {
+	idx := ls.indexes.GetOrCreateIndex(timelineIndexField)
+	if t, ok := eventTime(old); ok {
+		idx.Delete(timelineKey(t), makeKey(old.Type, old.ID))
+	}
+	if t, ok := eventTime(updated); ok {
+		if err := idx.Insert(timelineKey(t), makeKey(updated.Type, updated.ID)); err != nil {
+			return fmt.Errorf("failed to index event: %w", err)
+		}
+	}
+	return nil
+}
+
+// RebuildTimeline recreates the timeline index from the stored events and
+// returns how many were indexed
+func (ls *LoreStore) RebuildTimeline() (int, error) {
+	if !ls.isOpen {
+		return 0, fmt.Errorf("store is not open")
+	}
+
+	idx := ls.indexes.GetOrCreateIndex(timelineIndexField)
+	idx.BeginRebuild()
+	indexed, err := ls.rebuildTimelineEntries(idx)
+	idx.FinishRebuild(err)
+	return indexed, err
+}
+
+// rebuildTimelineEntries adds every stored event with a time to a
+// rebuilding timeline index
+func (ls *LoreStore) rebuildTimelineEntries(idx *index.SecondaryIndex) (int, error) {
+	events, err := ls.ListEntities(EntityTypeEvent)
+	if err != nil {
+		return 0, err
+	}
+	indexed := 0
+	for _, event := range events {
+		if t, ok := eventTime(event); ok {
+			idx.InsertRebuilt(timelineKey(t), makeKey(event.Type, event.ID))
+			indexed++
+		}
+	}
+	return indexed, nil
+}
+
+// Timeline returns events between from and to inclusive, in time order,
+// using a range query on the timeline index. Nil bounds are open.
+func (ls *LoreStore) Timeline(from, to *int64) ([]*Entity, error) {
+	if !ls.isOpen {
+		return nil, fmt.Errorf("store is not open")
+	}
+
+	var start, end interface{}
+	if from != nil {
+		start = timelineKey(*from)
+	}
+	if to != nil {
+		end = timelineKey(*to)
+	}
+
+	entries, err := ls.indexes.GetOrCreateIndex(timelineIndexField).SearchRangeEntries(start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query timeline: %w", err)
+	}
+
+	events := make([]*Entity, 0, len(entries))
+	for _, entry := range entries {
+		entityType, id, ok := strings.Cut(string(entry.PrimaryKey), ":")
+		if !ok {
+			continue
+		}
+		event, err := ls.GetEntity(EntityType(entityType), id)
+		if err != nil {
+			continue // Entry outlived its event, e.g. after a crash before checkpoint
+		}
+		// Skip entries left at an event's old time
+		if t, ok := eventTime(event); !ok || timelineKey(t) != entry.FieldValue {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+var timelineCmd = &cobra.Command{
+	Use:   "timeline [flags]",
+	Short: "List events in chronological order",
+	Long: `List events ordered by their "at" time, optionally limited to a time range
+or to events related to particular characters, places or other entities.
+
+Event times are story date numbers (e.g. a year in the story's calendar) or
+calendar dates. Filters by entity match events with a relationship to that
+entity in either direction; several filters must all match.
+
+Examples:
+  lore event create "Battle of the Bells" --at 298
+  lore relationship create character:arya-stark fought_in event:battle-of-the-bells
+  lore timeline --from 290 --to 300
+  lore timeline --character arya-stark --place winterfell`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if rebuild, _ := cmd.Flags().GetBool("rebuild"); rebuild {
+			indexed, err := loreStore.RebuildTimeline()
+			if err != nil {
+				return fmt.Errorf("failed to rebuild timeline: %w", err)
+			}
+			if !config.Quiet {
+				fmt.Fprintf(os.Stderr, "Indexed %d events\n", indexed)
+			}
+		}
+
+		from, err := timeFlag(cmd, "from")
+		if err != nil {
+			return err
+		}
+		to, err := timeFlag(cmd, "to")
+		if err != nil {
+			return err
+		}
+
+		var filters []string
+		characters, _ := cmd.Flags().GetStringArray("character")
+		for _, id := range characters {
+			filters = append(filters, string(makeKey(EntityTypeCharacter, id)))
+		}
+		places, _ := cmd.Flags().GetStringArray("place")
+		for _, id := range places {
+			filters = append(filters, string(makeKey(EntityTypePlace, id)))
+		}
+		specs, _ := cmd.Flags().GetStringArray("with")
+		for _, spec := range specs {
+			entityType, id, err := parseEntitySpec(spec)
+			if err != nil {
+				return err
+			}
+			filters = append(filters, string(makeKey(entityType, id)))
+		}
+
+		events, err := loreStore.Timeline(from, to)
+		if err != nil {
+			return err
+		}
+		for _, filter := range filters {
+			if events, err = filterRelatedEvents(events, filter); err != nil {
+				return err
+			}
+		}
+
+		return outputTimeline(events)
+	},
+}
+
+// timeFlag parses an optional story time flag
+func timeFlag(cmd *cobra.Command, name string) (*int64, error) {
+	if !cmd.Flags().Changed(name) {
+		return nil, nil
+	}
+	raw, _ := cmd.Flags().GetString(name)
+	t, err := parseStoryTime(raw)
+	if err != nil {
+		return nil, &LoreError{fmt.Sprintf("--%s %v", name, err)}
+	}
+	return &t, nil
+}
+
+// filterRelatedEvents keeps the events related to the entity stored under key
+func filterRelatedEvents(events []*Entity, key string) ([]*Entity, error) {
+	entityType, id, _ := strings.Cut(key, ":")
+	if !loreStore.EntityExists(EntityType(entityType), id) {
+		return nil, &LoreError{fmt.Sprintf("%s '%s' not found", entityType, id)}
+	}
+
+	relationships, err := loreStore.GetEntityRelationships(EntityType(entityType), id, "both", "")
+	if err != nil {
+		return nil, err
+	}
+	related := make(map[string]bool, len(relationships))
+	for _, rel := range relationships {
+		related[rel.OtherKey] = true
+	}
+
+	filtered := events[:0]
+	for _, event := range events {
+		if related[string(makeKey(event.Type, event.ID))] {
+			filtered = append(filtered, event)
+		}
+	}
+	return filtered, nil
+}
+
+func init() {
+	timelineCmd.Flags().String("from", "", "Earliest event time to include")
+	timelineCmd.Flags().String("to", "", "Latest event time to include")
+	timelineCmd.Flags().StringArray("character", nil, "Only events related to this character ID")
+	timelineCmd.Flags().StringArray("place", nil, "Only events related to this place ID")
+	timelineCmd.Flags().StringArray("with", nil, "Only events related to this entity (type:id)")
+	timelineCmd.Flags().Bool("rebuild", false, "Rebuild the timeline index from stored events first")
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/ssargent/freyjadb/pkg/output"
+)
+
+var typeCmd = &cobra.Command{
+	Use:   "type",
+	Short: "Manage entity types",
+	Long: `Define custom entity types and the fields their entities carry.
+
+Each type gets its own command (lore <type> create|get|list|update|delete)
+with a flag per field. Definitions are stored in the project, so every
+invocation in the project sees the same types.`,
+}
+
+var typeDefineCmd = &cobra.Command{
+	Use:   "define <name> [flags]",
+	Short: "Define or redefine an entity type",
+	Long: `Define an entity type, replacing any stored definition with the same name.
+Redefining a builtin type (character, place, group or event) replaces the
+builtin definition.
+
+Fields are given as name:type[:required][:default=value][:values=a|b|c],
+where type is one of string, number, bool, date, time or list.
+
+Examples:
+  lore type define artifact --description "magical artifacts" \
+    --field power:string:required --field weight:number
+  lore type define faction --field alignment:string:values=good|neutral|evil`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		def := &EntityTypeDef{Name: args[0]}
+		def.Description, _ = cmd.Flags().GetString("description")
+		def.Aka, _ = cmd.Flags().GetBool("aka")
+
+		specs, _ := cmd.Flags().GetStringArray("field")
+		for _, spec := range specs {
+			field, err := parseFieldSpec(spec)
+			if err != nil {
+				return err
+			}
+			def.Fields = append(def.Fields, field)
+		}
+
+		if err := loreStore.PutEntityType(def); err != nil {
+			return fmt.Errorf("failed to define type: %w", err)
+		}
+
+		if !config.Quiet {
+			fmt.Printf("Defined type '%s' with %d fields\n", def.Name, len(def.Fields))
+		}
+		return nil
+	},
+}
+
+var typeApplyCmd = &cobra.Command{
+	Use:   "apply <file>",
+	Short: "Define entity types from a YAML file",
+	Long: `Define every entity type in a YAML file. The file holds either one
+definition or a list of them under "types":
+
+  types:
+    - name: artifact
+      description: magical artifacts
+      fields:
+        - name: power
+          type: string
+          required: true
+        - name: rarity
+          type: string
+          values: [common, rare, legendary]
+          default: common`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		defs, err := LoadEntityTypes(args[0])
+		if err != nil {
+			return err
+		}
+
+		// Validate everything before storing anything
+		for _, def := range defs {
+			if err := def.Validate(); err != nil {
+				return err
+			}
+		}
+		for _, def := range defs {
+			if err := loreStore.PutEntityType(def); err != nil {
+				return fmt.Errorf("failed to define type '%s': %w", def.Name, err)
+			}
+			if !config.Quiet {
+				fmt.Printf("Defined type '%s' with %d fields\n", def.Name, len(def.Fields))
+			}
+		}
+		return nil
+	},
+}
+
+var typeListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List entity types",
+	Long:  `List the builtin and custom entity types available in the project.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		defs, err := loreStore.ListEntityTypes()
+		if err != nil {
+			return err
+		}
+
+		rows := output.Rows{Header: []string{"name", "kind", "fields", "description"}}
+		for _, def := range defs {
+			kind := "custom"
+			if def.Builtin {
+				kind = "builtin"
+			}
+			names := make([]string, len(def.Fields))
+			for i, field := range def.Fields {
+				names[i] = field.Name
+			}
+			rows.Add(def.Name, kind, formatStringSlice(names), def.Description)
+		}
+		return outputFormat.Write(os.Stdout, defs, rows)
+	},
+}
+
+var typeGetCmd = &cobra.Command{
+	Use:   "get <name>",
+	Short: "Show an entity type's fields",
+	Long:  `Display an entity type's definition and field template.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		def, err := loreStore.GetEntityType(args[0])
+		if err != nil {
+			return err
+		}
+
+		if outputFormat.Kind != output.KindTable {
+			rows := output.Rows{Header: []string{"field", "type", "required", "default", "values", "description"}}
+			for _, field := range def.Fields {
+				rows.Add(field.Name, field.Type, strconv.FormatBool(field.Required), field.Default,
+					strings.Join(field.Values, "|"), field.Description)
+			}
+			return outputFormat.Write(os.Stdout, def, rows)
+		}
+
+		fmt.Printf("Type: %s\n", def.Name)
+		if def.Description != "" {
+			fmt.Printf("Description: %s\n", def.Description)
+		}
+		if len(def.Fields) == 0 {
+			fmt.Println("No custom fields")
+			return nil
+		}
+		fmt.Println()
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		defer func() { _ = w.Flush() }()
+
+		fmt.Fprintln(w, "FIELD\tTYPE\tREQUIRED\tDEFAULT\tVALUES")
+		for _, field := range def.Fields {
+			fmt.Fprintf(w, "%s\t%s\t%t\t%s\t%s\n", field.Name, field.Type, field.Required,
+				field.Default, strings.Join(field.Values, "|"))
+		}
+		return nil
+	},
+}
+
+var typeDeleteCmd = &cobra.Command{
+	Use:   "delete <name>",
+	Short: "Delete a custom entity type",
+	Long: `Delete a stored entity type definition. Entities of the type are kept;
+deleting a redefined builtin type restores the builtin definition.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+
+		if !config.Yes && !confirm(fmt.Sprintf("Are you sure you want to delete type '%s'? (y/N): ", name)) {
+			fmt.Println("Deletion cancelled")
+			return nil
+		}
+
+		if err := loreStore.DeleteEntityType(name); err != nil {
+			return err
+		}
+
+		if !config.Quiet {
+			fmt.Printf("Deleted type '%s'\n", name)
+		}
+		return nil
+	},
+}
+
+func init() {
+	typeDefineCmd.Flags().String("description", "", "Type description, shown in help")
+	typeDefineCmd.Flags().Bool("aka", false, "Give entities of this type alternative names")
+	typeDefineCmd.Flags().StringArray("field", nil, "Field spec name:type[:required][:default=v][:values=a|b]")
+
+	typeCmd.AddCommand(typeDefineCmd)
+	typeCmd.AddCommand(typeApplyCmd)
+	typeCmd.AddCommand(typeListCmd)
+	typeCmd.AddCommand(typeGetCmd)
+	typeCmd.AddCommand(typeDeleteCmd)
+}
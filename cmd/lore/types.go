@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/keys"
+	"github.com/ssargent/freyjadb/pkg/store"
+	"gopkg.in/yaml.v3"
+)
+
+// Field types a custom field may declare
+const (
+	FieldTypeString = "string"
+	FieldTypeNumber = "number"
+	FieldTypeBool   = "bool"
+	FieldTypeDate   = "date" // YYYY-MM-DD
+	FieldTypeList   = "list" // Comma-separated on the command line
+	FieldTypeTime   = "time" // Story date number or calendar date, see parseStoryTime
+)
+
+const (
+	entityTypeKeys  = keys.Keyspace("entitytype")
+	fieldDateLayout = "2006-01-02"
+)
+
+// typeNamePattern restricts type and field names to what is safe in keys and flag names
+var typeNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// reservedTypeNames collide with key prefixes or top-level commands
+var reservedTypeNames = map[string]bool{
+	"entitytype":   true,
+	"relationship": true,
+	"type":         true,
+	"timeline":     true,
+	"help":         true,
+	"completion":   true,
+}
+
+// reservedFieldNames are flags every entity command already has
+var reservedFieldNames = map[string]bool{
+	"project":        true,
+	"format":         true,
+	"quiet":          true,
+	"yes":            true,
+	"summary":        true,
+	"details":        true,
+	"tags":           true,
+	"aka":            true,
+	"force":          true,
+	"expect-version": true,
+	"help":           true,
+}
+
+// FieldDef describes one custom field of an entity type
+type FieldDef struct {
+	Name        string   `json:"name" yaml:"name"`
+	Type        string   `json:"type" yaml:"type"`
+	Required    bool     `json:"required,omitempty" yaml:"required,omitempty"`
+	Default     string   `json:"default,omitempty" yaml:"default,omitempty"`
+	Values      []string `json:"values,omitempty" yaml:"values,omitempty"` // Allowed values, if restricted
+	Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// EntityTypeDef describes an entity type and the template of fields its entities carry
+type EntityTypeDef struct {
+	Name        string     `json:"name" yaml:"name"`
+	Description string     `json:"description,omitempty" yaml:"description,omitempty"`
+	Aka         bool       `json:"aka,omitempty" yaml:"aka,omitempty"` // Whether entities take alternative names
+	Fields      []FieldDef `json:"fields,omitempty" yaml:"fields,omitempty"`
+	Builtin     bool       `json:"-" yaml:"-"`
+}
+
+// builtinEntityTypes are available in every project. A stored definition
+// with the same name replaces the builtin one.
+var builtinEntityTypes = []*EntityTypeDef{
+	{Name: string(EntityTypeCharacter), Description: "characters", Aka: true, Builtin: true},
+	{Name: string(EntityTypePlace), Description: "places", Builtin: true},
+	{Name: string(EntityTypeGroup), Description: "groups", Builtin: true},
+	{Name: string(EntityTypeEvent), Description: "events", Builtin: true, Fields: []FieldDef{
+		{Name: eventTimeField, Type: FieldTypeTime, Required: true,
+			Description: "When it happens, as a story date number or YYYY-MM-DD"},
+	}},
+}
+
+// builtinEntityType returns the builtin definition named name, or nil
+func builtinEntityType(name string) *EntityTypeDef {
+	for _, def := range builtinEntityTypes {
+		if def.Name == name {
+			return def
+		}
+	}
+	return nil
+}
+
+// Field returns the field named name, or nil
+func (d *EntityTypeDef) Field(name string) *FieldDef {
+	for i := range d.Fields {
+		if d.Fields[i].Name == name {
+			return &d.Fields[i]
+		}
+	}
+	return nil
+}
+
+// Validate checks the definition's names, field types and defaults
+func (d *EntityTypeDef) Validate() error {
+	if !typeNamePattern.MatchString(d.Name) {
+		return &LoreError{fmt.Sprintf("invalid type name '%s' (use lowercase letters, digits, '-' and '_')", d.Name)}
+	}
+	if reservedTypeNames[d.Name] {
+		return &LoreError{fmt.Sprintf("type name '%s' is reserved", d.Name)}
+	}
+
+	seen := make(map[string]bool, len(d.Fields))
+	for _, field := range d.Fields {
+		if !typeNamePattern.MatchString(field.Name) {
+			return &LoreError{fmt.Sprintf("invalid field name '%s' in type '%s'", field.Name, d.Name)}
+		}
+		if reservedFieldNames[field.Name] {
+			return &LoreError{fmt.Sprintf("field name '%s' is reserved", field.Name)}
+		}
+		if seen[field.Name] {
+			return &LoreError{fmt.Sprintf("duplicate field '%s' in type '%s'", field.Name, d.Name)}
+		}
+		seen[field.Name] = true
+
+		switch field.Type {
+		case FieldTypeString, FieldTypeNumber, FieldTypeBool, FieldTypeDate, FieldTypeList, FieldTypeTime:
+		default:
+			return &LoreError{fmt.Sprintf("field '%s' has unknown type '%s'", field.Name, field.Type)}
+		}
+		if field.Default != "" {
+			if _, err := field.Parse(field.Default); err != nil {
+				return &LoreError{fmt.Sprintf("invalid default for field '%s': %v", field.Name, err)}
+			}
+		}
+	}
+	return nil
+}
+
+// Parse converts a command-line value to the field's type and checks it
+// against the allowed values
+func (f *FieldDef) Parse(raw string) (interface{}, error) {
+	var value interface{}
+	switch f.Type {
+	case FieldTypeNumber:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, &LoreError{fmt.Sprintf("field '%s' must be a number", f.Name)}
+		}
+		value = n
+	case FieldTypeBool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, &LoreError{fmt.Sprintf("field '%s' must be true or false", f.Name)}
+		}
+		value = b
+	case FieldTypeDate:
+		if _, err := time.Parse(fieldDateLayout, raw); err != nil {
+			return nil, &LoreError{fmt.Sprintf("field '%s' must be a date (YYYY-MM-DD)", f.Name)}
+		}
+		value = raw
+	case FieldTypeTime:
+		if _, err := parseStoryTime(raw); err != nil {
+			return nil, &LoreError{fmt.Sprintf("field '%s' %v", f.Name, err)}
+		}
+		value = raw
+	case FieldTypeList:
+		value = splitList(raw)
+	default:
+		value = raw
+	}
+
+	if len(f.Values) > 0 {
+		items := []string{raw}
+		if list, ok := value.([]string); ok {
+			items = list
+		}
+		for _, item := range items {
+			if !slices.Contains(f.Values, item) {
+				return nil, &LoreError{fmt.Sprintf("field '%s' must be one of: %s",
+					f.Name, strings.Join(f.Values, ", "))}
+			}
+		}
+	}
+	return value, nil
+}
+
+// ValidateFields checks an entity's custom fields against its type definition
+func (d *EntityTypeDef) ValidateFields(entity *Entity) error {
+	for name := range entity.Fields {
+		if d.Field(name) == nil {
+			return &LoreError{fmt.Sprintf("%s has no field '%s'", d.Name, name)}
+		}
+	}
+	for _, field := range d.Fields {
+		if _, ok := entity.Fields[field.Name]; field.Required && !ok {
+			return &LoreError{fmt.Sprintf("field '%s' is required for %s", field.Name, d.Name)}
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma-separated value and trims each item
+func splitList(raw string) []string {
+	if raw == "" {
+		return nil
+	}
+	items := strings.Split(raw, ",")
+	for i, item := range items {
+		items[i] = strings.TrimSpace(item)
+	}
+	return items
+}
+
+// parseFieldSpec parses a --field flag of the form
+// name:type[:required][:default=value][:values=a|b|c]
+func parseFieldSpec(spec string) (FieldDef, error) {
+	parts := strings.Split(spec, ":")
+	field := FieldDef{Name: parts[0], Type: FieldTypeString}
+	if len(parts) > 1 && parts[1] != "" {
+		field.Type = parts[1]
+	}
+
+	for _, opt := range parts[min(len(parts), 2):] {
+		switch key, value, _ := strings.Cut(opt, "="); key {
+		case "required":
+			field.Required = true
+		case "default":
+			field.Default = value
+		case "values":
+			field.Values = strings.Split(value, "|")
+		default:
+			return FieldDef{}, &LoreError{fmt.Sprintf("unknown field option '%s' in '%s'", opt, spec)}
+		}
+	}
+	return field, nil
+}
+
+// LoadEntityTypes reads type definitions from a YAML file holding either a
+// single definition or a list under "types"
+func LoadEntityTypes(path string) ([]*EntityTypeDef, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // path is supplied by the user
+	if err != nil {
+		return nil, fmt.Errorf("failed to read type file: %w", err)
+	}
+
+	var file struct {
+		Types []*EntityTypeDef `yaml:"types"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse type file: %w", err)
+	}
+	if len(file.Types) > 0 {
+		return file.Types, nil
+	}
+
+	var def EntityTypeDef
+	if err := yaml.Unmarshal(data, &def); err != nil {
+		return nil, fmt.Errorf("failed to parse type file: %w", err)
+	}
+	if def.Name == "" {
+		return nil, &LoreError{"type file defines no types"}
+	}
+	return []*EntityTypeDef{&def}, nil
+}
+
+// PutEntityType stores a type definition in the project
+func (ls *LoreStore) PutEntityType(def *EntityTypeDef) error {
+	if !ls.isOpen {
+		return fmt.Errorf("store is not open")
+	}
+	if err := def.Validate(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(def)
+	if err != nil {
+		return fmt.Errorf("failed to serialize entity type: %w", err)
+	}
+	return ls.kvStore.Put(entityTypeKeys.Bytes(def.Name), data)
+}
+
+// GetEntityType returns the definition of a stored or builtin type
+func (ls *LoreStore) GetEntityType(name string) (*EntityTypeDef, error) {
+	if !ls.isOpen {
+		return nil, fmt.Errorf("store is not open")
+	}
+
+	data, err := ls.kvStore.Get(entityTypeKeys.Bytes(name))
+	if err == store.ErrKeyNotFound {
+		if def := builtinEntityType(name); def != nil {
+			return def, nil
+		}
+		return nil, &LoreError{fmt.Sprintf("unknown entity type: %s", name)}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get entity type: %w", err)
+	}
+
+	var def EntityTypeDef
+	if err := json.Unmarshal(data, &def); err != nil {
+		return nil, fmt.Errorf("failed to deserialize entity type: %w", err)
+	}
+	return &def, nil
+}
+
+// ListEntityTypes returns builtin and stored types, sorted by name
+func (ls *LoreStore) ListEntityTypes() ([]*EntityTypeDef, error) {
+	if !ls.isOpen {
+		return nil, fmt.Errorf("store is not open")
+	}
+
+	stored, err := ls.kvStore.ListKeys([]byte(entityTypeKeys.Prefix()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list entity types: %w", err)
+	}
+
+	byName := make(map[string]*EntityTypeDef, len(builtinEntityTypes)+len(stored))
+	for _, def := range builtinEntityTypes {
+		byName[def.Name] = def
+	}
+	for _, key := range stored {
+		name, _ := entityTypeKeys.Trim(key)
+		def, err := ls.GetEntityType(name)
+		if err != nil {
+			return nil, err
+		}
+		byName[def.Name] = def
+	}
+
+	defs := make([]*EntityTypeDef, 0, len(byName))
+	for _, def := range byName {
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+	return defs, nil
+}
+
+// DeleteEntityType removes a stored definition. Deleting a redefined builtin
+// type restores the builtin definition; entities of the type are kept.
+func (ls *LoreStore) DeleteEntityType(name string) error {
+	if !ls.isOpen {
+		return fmt.Errorf("store is not open")
+	}
+
+	key := entityTypeKeys.Bytes(name)
+	if _, err := ls.kvStore.Get(key); err != nil {
+		if err == store.ErrKeyNotFound {
+			if builtinEntityType(name) != nil {
+				return &LoreError{fmt.Sprintf("'%s' is a builtin type and cannot be deleted", name)}
+			}
+			return &LoreError{fmt.Sprintf("unknown entity type: %s", name)}
+		}
+		return fmt.Errorf("failed to check entity type existence: %w", err)
+	}
+	return ls.kvStore.Delete(key)
+}
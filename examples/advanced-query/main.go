@@ -10,6 +10,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/ssargent/freyjadb/pkg/freyja"
 	"github.com/ssargent/freyjadb/pkg/index"
 	"github.com/ssargent/freyjadb/pkg/query"
 	"github.com/ssargent/freyjadb/pkg/store"
@@ -19,8 +20,8 @@ import (
 type User struct {
 	ID        string    `json:"id"`
 	Name      string    `json:"name"`
-	Age       int       `json:"age"`
-	City      string    `json:"city"`
+	Age       int       `json:"age" freyja:"index"`
+	City      string    `json:"city" freyja:"index"`
 	Email     string    `json:"email"`
 	CreatedAt time.Time `json:"created_at"`
 }
@@ -326,7 +327,28 @@ func main() {
 	}
 	fmt.Printf("   📊 Found %d users aged 25-35\n", betweenCount)
 
-	// 4. Demonstrate statistics
+	// 4. The same queries through a typed collection, which indexes the
+	// fields tagged `freyja:"index"` on every Put
+	fmt.Println("\n5️⃣ Querying a typed collection:")
+	people, err := freyja.Collection[User](kvStore, "people:")
+	if err != nil {
+		log.Fatalf("Failed to open collection: %v", err)
+	}
+	for _, user := range users {
+		if err := people.Put(user.ID, user); err != nil {
+			log.Fatalf("Failed to store user %s: %v", user.ID, err)
+		}
+	}
+	newYorkers, err := people.Query(ctx, "city", "=", "New York")
+	if err != nil {
+		log.Fatalf("Collection query failed: %v", err)
+	}
+	for _, user := range newYorkers {
+		fmt.Printf("   🗂️  %s - %s\n", user.Name, user.City)
+	}
+	fmt.Printf("   📊 Found %d users in New York\n", len(newYorkers))
+
+	// 5. Demonstrate statistics
 	fmt.Println("\n📈 System Statistics:")
 	stats := kvStore.Stats()
 	fmt.Printf("   📊 Total keys: %d\n", stats.Keys)
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// DefaultAlertInterval is how often thresholds are evaluated when
+// AlertConfig.Interval is zero
+const DefaultAlertInterval = time.Minute
+
+// alertMinRequests is the fewest requests in an interval for which the
+// error rate is judged, so one failure on an idle server doesn't alert
+const alertMinRequests = 10
+
+// alertTimeout bounds each webhook delivery
+const alertTimeout = 10 * time.Second
+
+// Names of the thresholds an AlertMonitor checks
+const (
+	AlertDiskUsed       = "disk_used"
+	AlertKeyCount       = "key_count"
+	AlertErrorRate      = "error_rate"
+	AlertReplicationLag = "replication_lag"
+)
+
+// Alert states
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// AlertConfig sets soft limits and where to report them. A zero threshold
+// is not checked.
+type AlertConfig struct {
+	WebhookURL string        // Receives a JSON POST per alert; Slack incoming webhooks display its text
+	Interval   time.Duration // How often thresholds are evaluated (DefaultAlertInterval if zero)
+
+	DiskUsedPct         float64 // Filesystem holding the data directory is fuller than this percentage
+	MaxKeys             int     // Store holds more keys than this
+	ErrorRatePct        float64 // More than this percentage of requests in an interval failed with a 5xx
+	MaxReplicationLagKB int64   // A standby store is further behind its primary's log than this
+}
+
+// Alert is the webhook payload sent when a threshold is crossed, and again
+// when the value falls back within it
+type Alert struct {
+	Text      string    `json:"text"` // One-line summary
+	Name      string    `json:"alert"`
+	Status    string    `json:"status"` // AlertFiring or AlertResolved
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Host      string    `json:"host"`
+	DataDir   string    `json:"data_dir"`
+	Time      time.Time `json:"time"`
+}
+
+// standbyStore is implemented by stores that can follow another writer
+type standbyStore interface {
+	StandbyLag() int64
+}
+
+// AlertMonitor evaluates soft limits in the background and posts an Alert
+// to a webhook whenever one starts or stops being exceeded, giving small
+// deployments basic alerting without a monitoring stack
+type AlertMonitor struct {
+	config AlertConfig
+	client *http.Client
+	host   string
+
+	requests atomic.Int64 // Responses seen by countResponses
+	failures atomic.Int64 // Of which 5xx
+
+	mutex        sync.Mutex
+	firing       map[string]bool
+	seenRequests int64 // Counters at the previous evaluation
+	seenFailures int64
+}
+
+// NewAlertMonitor validates config and returns a monitor for it
+func NewAlertMonitor(config AlertConfig) (*AlertMonitor, error) {
+	target, err := url.Parse(config.WebhookURL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("alert webhook URL must be an http or https URL, got %q", config.WebhookURL)
+	}
+	if config.DiskUsedPct < 0 || config.DiskUsedPct > 100 || config.ErrorRatePct < 0 || config.ErrorRatePct > 100 {
+		return nil, fmt.Errorf("alert percentages must be between 0 and 100")
+	}
+	if config.MaxKeys < 0 || config.MaxReplicationLagKB < 0 || config.Interval < 0 {
+		return nil, fmt.Errorf("alert thresholds and interval must not be negative")
+	}
+	if config.Interval == 0 {
+		config.Interval = DefaultAlertInterval
+	}
+
+	host, _ := os.Hostname()
+	return &AlertMonitor{
+		config: config,
+		client: &http.Client{Timeout: alertTimeout},
+		host:   host,
+		firing: make(map[string]bool),
+	}, nil
+}
+
+// countResponses is middleware counting responses for the error rate
+func (m *AlertMonitor) countResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(rw, r)
+		m.requests.Add(1)
+		if rw.statusCode >= http.StatusInternalServerError {
+			m.failures.Add(1)
+		}
+	})
+}
+
+// run evaluates the thresholds every interval and delivers the alerts
+func (m *AlertMonitor) run(kv IKVStore, dataDir string, logger *slog.Logger) {
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, alert := range m.evaluate(kv, dataDir, time.Now()) {
+			if err := m.send(alert); err != nil {
+				logger.Warn("failed to deliver alert", "alert", alert.Name, "status", alert.Status, "error", err)
+			}
+		}
+	}
+}
+
+// evaluate checks every configured threshold and returns an alert for each
+// one that started or stopped being exceeded since the last evaluation
+func (m *AlertMonitor) evaluate(kv IKVStore, dataDir string, now time.Time) []Alert {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var alerts []Alert
+	check := func(name string, value, threshold float64, format string) {
+		exceeded := value > threshold
+		if exceeded == m.firing[name] {
+			return
+		}
+		m.firing[name] = exceeded
+
+		alert := Alert{
+			Name:      name,
+			Status:    AlertResolved,
+			Value:     value,
+			Threshold: threshold,
+			Host:      m.host,
+			DataDir:   dataDir,
+			Time:      now,
+		}
+		if exceeded {
+			alert.Status = AlertFiring
+		}
+		alert.Text = fmt.Sprintf("[FreyjaDB %s] %s on %s: "+format, alert.Status, name, m.host, value, threshold)
+		alerts = append(alerts, alert)
+	}
+
+	if m.config.DiskUsedPct > 0 {
+		if used, total, err := store.FilesystemUsage(dataDir); err == nil && total > 0 {
+			check(AlertDiskUsed, float64(used)*100/float64(total), m.config.DiskUsedPct,
+				"disk %.1f%% used (threshold %.1f%%)")
+		}
+	}
+
+	if m.config.MaxKeys > 0 {
+		check(AlertKeyCount, float64(kv.Stats().Keys), float64(m.config.MaxKeys),
+			"%.0f keys (threshold %.0f)")
+	}
+
+	if m.config.ErrorRatePct > 0 {
+		requests, failures := m.requests.Load(), m.failures.Load()
+		if delta := requests - m.seenRequests; delta >= alertMinRequests {
+			rate := float64(failures-m.seenFailures) * 100 / float64(delta)
+			check(AlertErrorRate, rate, m.config.ErrorRatePct, "%.1f%% of requests failed (threshold %.1f%%)")
+			m.seenRequests, m.seenFailures = requests, failures
+		}
+	}
+
+	if standby, ok := kv.(standbyStore); ok && m.config.MaxReplicationLagKB > 0 {
+		check(AlertReplicationLag, float64(standby.StandbyLag())/1024, float64(m.config.MaxReplicationLagKB),
+			"standby %.0f KB behind (threshold %.0f KB)")
+	}
+
+	return alerts
+}
+
+// send posts an alert to the webhook
+func (m *AlertMonitor) send(alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	resp, err := m.client.Post(m.config.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
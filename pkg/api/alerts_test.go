@@ -0,0 +1,122 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// laggingStore is a MemoryStore that reports itself as a lagging standby
+type laggingStore struct {
+	*MemoryStore
+	lag int64
+}
+
+func (s *laggingStore) StandbyLag() int64 { return s.lag }
+
+func TestAlertMonitor_Evaluate(t *testing.T) {
+	var mutex sync.Mutex
+	var received []Alert
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&alert))
+		mutex.Lock()
+		received = append(received, alert)
+		mutex.Unlock()
+	}))
+	defer webhook.Close()
+
+	monitor, err := NewAlertMonitor(AlertConfig{
+		WebhookURL:          webhook.URL,
+		DiskUsedPct:         0.0001, // Any real filesystem is fuller
+		MaxKeys:             1,
+		ErrorRatePct:        50,
+		MaxReplicationLagKB: 1,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultAlertInterval, monitor.config.Interval)
+
+	kv := &laggingStore{MemoryStore: NewMemoryStore(), lag: 4096}
+	require.NoError(t, kv.Put([]byte("a"), []byte("1")))
+	require.NoError(t, kv.Put([]byte("b"), []byte("2")))
+
+	// Most requests fail
+	handler := monitor.countResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	for i := 0; i < alertMinRequests; i++ {
+		path := "/fail"
+		if i%4 == 0 {
+			path = "/ok"
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	alerts := monitor.evaluate(kv, t.TempDir(), time.Now())
+	names := make(map[string]Alert)
+	for _, alert := range alerts {
+		names[alert.Name] = alert
+		assert.Equal(t, AlertFiring, alert.Status)
+	}
+	require.Len(t, names, 4, "alerts: %+v", alerts)
+	assert.Equal(t, float64(2), names[AlertKeyCount].Value)
+	assert.Equal(t, float64(70), names[AlertErrorRate].Value)
+	assert.Equal(t, float64(4), names[AlertReplicationLag].Value)
+	assert.Contains(t, names[AlertKeyCount].Text, "2 keys (threshold 1)")
+
+	// Still exceeded: nothing new to report
+	assert.Empty(t, monitor.evaluate(kv, t.TempDir(), time.Now()))
+
+	// Back within limits
+	require.NoError(t, kv.Delete([]byte("a")))
+	kv.lag = 0
+	alerts = monitor.evaluate(kv, t.TempDir(), time.Now())
+	require.Len(t, alerts, 2)
+	for _, alert := range alerts {
+		assert.Equal(t, AlertResolved, alert.Status)
+		require.NoError(t, monitor.send(alert))
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	require.Len(t, received, 2)
+	assert.Equal(t, alerts[0].Text, received[0].Text)
+	assert.True(t, alerts[0].Time.Equal(received[0].Time))
+}
+
+func TestNewAlertMonitor_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config AlertConfig
+	}{
+		{name: "no webhook", config: AlertConfig{MaxKeys: 1}},
+		{name: "not http", config: AlertConfig{WebhookURL: "ftp://example.com/hook"}},
+		{name: "disk over 100", config: AlertConfig{WebhookURL: "https://example.com/hook", DiskUsedPct: 120}},
+		{name: "negative keys", config: AlertConfig{WebhookURL: "https://example.com/hook", MaxKeys: -1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewAlertMonitor(tt.config)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestAlertMonitor_SendFailure(t *testing.T) {
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer webhook.Close()
+
+	monitor, err := NewAlertMonitor(AlertConfig{WebhookURL: webhook.URL})
+	require.NoError(t, err)
+	assert.ErrorContains(t, monitor.send(Alert{Name: AlertKeyCount}), "403")
+}
@@ -0,0 +1,240 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Scopes granted to authenticated principals
+const (
+	ScopeRead  = "read"  // GET/HEAD on data endpoints
+	ScopeWrite = "write" // PUT/POST/DELETE on data endpoints
+	ScopeAdmin = "admin" // System administration endpoints
+	ScopeView  = "view"  // GET on data endpoints with values truncated, for viewer keys
+)
+
+// Auth modes selectable in AuthConfig
+const (
+	AuthModeAPIKey = "apikey" // Static or system-stored API keys (default)
+	AuthModeJWT    = "jwt"    // Bearer JWTs only
+	AuthModeBoth   = "apikey+jwt"
+)
+
+// errNoCredentials is returned by a provider when the request carries no
+// credentials it understands, so the next provider gets a chance
+var errNoCredentials = errors.New("no credentials for this provider")
+
+// AuthError is an authentication failure whose message is returned to the client
+type AuthError struct {
+	Message string
+}
+
+func (e *AuthError) Error() string {
+	return e.Message
+}
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	Subject string   // API key ID or token subject
+	Method  string   // Provider that authenticated the caller
+	Scopes  []string // Granted scopes
+}
+
+// HasScope reports whether the principal was granted scope
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthProvider authenticates a request. Implementations return
+// errNoCredentials when the request has no credentials of their kind, an
+// *AuthError when the credentials are invalid, and any other error when
+// authentication could not be performed.
+type AuthProvider interface {
+	Authenticate(r *http.Request) (*Principal, error)
+	// CredentialHint names the credential the provider expects, for error messages
+	CredentialHint() string
+}
+
+// AuthConfig selects and configures the authentication providers
+type AuthConfig struct {
+	Mode string     // AuthModeAPIKey (default), AuthModeJWT or AuthModeBoth
+	JWT  *JWTConfig // Required for the jwt modes
+}
+
+// Scopes granted to static client keys and to the system-root key
+var (
+	clientScopes = []string{ScopeRead, ScopeWrite}
+	systemScopes = []string{ScopeRead, ScopeWrite, ScopeAdmin}
+)
+
+// principalContextKey is the context key for the authenticated Principal
+type principalContextKey struct{}
+
+// PrincipalFromContext returns the principal stored by the auth middleware
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalContextKey{}).(*Principal)
+	return p, ok
+}
+
+// StaticKeyProvider accepts a single fixed X-API-Key
+type StaticKeyProvider struct {
+	Key string
+}
+
+// CredentialHint implements AuthProvider
+func (p *StaticKeyProvider) CredentialHint() string {
+	return "X-API-Key header"
+}
+
+// Authenticate implements AuthProvider
+func (p *StaticKeyProvider) Authenticate(r *http.Request) (*Principal, error) {
+	apiKey := r.Header.Get("X-API-Key")
+	if apiKey == "" {
+		return nil, errNoCredentials
+	}
+	if subtle.ConstantTimeCompare([]byte(apiKey), []byte(p.Key)) != 1 {
+		return nil, &AuthError{"Invalid API key"}
+	}
+	return &Principal{Subject: "api-key", Method: AuthModeAPIKey, Scopes: clientScopes}, nil
+}
+
+// SystemKeyProvider accepts the system-root API key kept in the system store
+type SystemKeyProvider struct {
+	SystemService SystemManager
+}
+
+// CredentialHint implements AuthProvider
+func (p *SystemKeyProvider) CredentialHint() string {
+	return "X-API-Key header"
+}
+
+// Authenticate implements AuthProvider
+func (p *SystemKeyProvider) Authenticate(r *http.Request) (*Principal, error) {
+	apiKey := r.Header.Get("X-API-Key")
+	if apiKey == "" {
+		return nil, errNoCredentials
+	}
+
+	systemKey, err := p.SystemService.GetAPIKey("system-root")
+	if err != nil {
+		return nil, errors.New("System authentication not configured") //nolint:staticcheck // returned to clients verbatim
+	}
+	if subtle.ConstantTimeCompare([]byte(apiKey), []byte(systemKey.Key)) != 1 {
+		return nil, &AuthError{"Invalid system API key"}
+	}
+	return &Principal{Subject: systemKey.ID, Method: AuthModeAPIKey, Scopes: systemScopes}, nil
+}
+
+// authMiddleware tries each provider in turn and stores the first successful
+// principal in the request context
+func authMiddleware(providers ...AuthProvider) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, provider := range providers {
+				principal, err := provider.Authenticate(r)
+				if errors.Is(err, errNoCredentials) {
+					continue
+				}
+				var authErr *AuthError
+				if errors.As(err, &authErr) {
+					sendError(w, authErr.Message, http.StatusUnauthorized)
+					return
+				}
+				if err != nil {
+					sendError(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+
+				ctx := context.WithValue(r.Context(), principalContextKey{}, principal)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			hints := make([]string, 0, len(providers))
+			for _, provider := range providers {
+				if hint := provider.CredentialHint(); !slices.Contains(hints, hint) {
+					hints = append(hints, hint)
+				}
+			}
+			sendError(w, "Missing "+strings.Join(hints, " or "), http.StatusUnauthorized)
+		})
+	}
+}
+
+// requireScope rejects principals holding none of scopes
+func requireScope(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := PrincipalFromContext(r.Context())
+			if !ok || !slices.ContainsFunc(scopes, principal.HasScope) {
+				sendError(w, "Insufficient scope: "+strings.Join(scopes, " or ")+" required", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireMethodScope requires ScopeRead or ScopeView for safe methods and
+// ScopeWrite otherwise
+func requireMethodScope(next http.Handler) http.Handler {
+	read, write := requireScope(ScopeRead, ScopeView)(next), requireScope(ScopeWrite)(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			read.ServeHTTP(w, r)
+		default:
+			write.ServeHTTP(w, r)
+		}
+	})
+}
+
+// buildAuthProviders assembles the providers for the configured auth mode
+func buildAuthProviders(config ServerConfig, systemService SystemManager) ([]AuthProvider, error) {
+	var keyProvider AuthProvider = &StaticKeyProvider{Key: config.APIKey}
+	var providers []AuthProvider
+	if systemService != nil && systemService.IsOpen() {
+		keyProvider = &SystemKeyProvider{SystemService: systemService}
+		// Temporary tokens carry their own prefix, so they are checked first
+		providers = append(providers, &TemporaryTokenProvider{SystemService: systemService})
+	}
+
+	mode := config.Auth.Mode
+	if mode == "" {
+		mode = AuthModeAPIKey
+	}
+
+	// The viewer key is checked before the others, which reject keys they
+	// don't know
+	if config.ViewerKey != "" && mode != AuthModeJWT {
+		providers = append(providers, &ViewerKeyProvider{Key: config.ViewerKey})
+	}
+
+	switch mode {
+	case AuthModeAPIKey:
+		return append(providers, keyProvider), nil
+	case AuthModeJWT, AuthModeBoth:
+		if config.Auth.JWT == nil {
+			return nil, errors.New("jwt auth mode requires a JWT configuration")
+		}
+		jwtProvider, err := NewJWTProvider(*config.Auth.JWT)
+		if err != nil {
+			return nil, err
+		}
+		if mode == AuthModeJWT {
+			return append(providers, jwtProvider), nil
+		}
+		return append(providers, keyProvider, jwtProvider), nil
+	default:
+		return nil, errors.New("unknown auth mode: " + mode)
+	}
+}
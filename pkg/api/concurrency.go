@@ -0,0 +1,128 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// concurrencyLimiter caps the expensive requests each principal has in
+// flight: scans, key listings, relationship queries, explains and log tails.
+// One client looping over scans then can't take every worker and store
+// read from the rest.
+type concurrencyLimiter struct {
+	limit int
+	wait  time.Duration
+
+	mutex sync.Mutex
+	slots map[string]*principalSlots // By principal subject; dropped when idle
+}
+
+// principalSlots is a principal's semaphore and how many of its requests
+// hold or wait for a slot
+type principalSlots struct {
+	sem   chan struct{}
+	users int
+}
+
+// newConcurrencyLimiter returns a limiter allowing limit expensive requests
+// per principal, queueing others for up to wait. It returns nil, which
+// limits nothing, when limit is not positive.
+func newConcurrencyLimiter(limit int, wait time.Duration) *concurrencyLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &concurrencyLimiter{limit: limit, wait: wait, slots: make(map[string]*principalSlots)}
+}
+
+// acquire takes one of subject's slots, waiting up to the queue timeout or
+// until the request is canceled. It reports whether it got one; if so,
+// release must be called when the request finishes.
+func (l *concurrencyLimiter) acquire(r *http.Request, subject string) bool {
+	l.mutex.Lock()
+	slots, ok := l.slots[subject]
+	if !ok {
+		slots = &principalSlots{sem: make(chan struct{}, l.limit)}
+		l.slots[subject] = slots
+	}
+	slots.users++
+	l.mutex.Unlock()
+
+	select {
+	case slots.sem <- struct{}{}:
+		return true
+	default:
+	}
+	if l.wait > 0 {
+		timer := time.NewTimer(l.wait)
+		defer timer.Stop()
+		select {
+		case slots.sem <- struct{}{}:
+			return true
+		case <-timer.C:
+		case <-r.Context().Done():
+		}
+	}
+	l.leave(subject, slots)
+	return false
+}
+
+// release frees a slot taken by acquire
+func (l *concurrencyLimiter) release(subject string) {
+	l.mutex.Lock()
+	slots := l.slots[subject]
+	l.mutex.Unlock()
+
+	<-slots.sem
+	l.leave(subject, slots)
+}
+
+// leave forgets a request, dropping the principal's slots once none remain
+func (l *concurrencyLimiter) leave(subject string, slots *principalSlots) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	slots.users--
+	if slots.users == 0 {
+		delete(l.slots, subject)
+	}
+}
+
+// inFlight returns how many expensive requests subject has running
+func (l *concurrencyLimiter) inFlight(subject string) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if slots, ok := l.slots[subject]; ok {
+		return len(slots.sem)
+	}
+	return 0
+}
+
+// limited serves an expensive endpoint within the caller's concurrency
+// limit (ServerConfig.MaxConcurrentPerKey). Requests over the limit wait
+// up to ConcurrencyQueueTimeout for a slot, then fail with 429.
+func (s *Server) limited(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.limiter == nil {
+			handler(w, r)
+			return
+		}
+
+		subject := "anonymous"
+		if principal, ok := PrincipalFromContext(r.Context()); ok {
+			subject = principal.Subject
+		}
+		if !s.limiter.acquire(r, subject) {
+			w.Header().Set("Retry-After", "1")
+			sendError(w, fmt.Sprintf("Too many concurrent expensive requests for %s: at most %d scans, "+
+				"listings and queries may run at once per API key", subject, s.limiter.limit),
+				http.StatusTooManyRequests)
+			return
+		}
+		defer s.limiter.release(subject)
+
+		handler(w, r)
+	}
+}
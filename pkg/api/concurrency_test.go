@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// requestAs returns a request authenticated as subject
+func requestAs(subject string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/scan", nil)
+	ctx := context.WithValue(r.Context(), principalContextKey{}, &Principal{Subject: subject})
+	return r.WithContext(ctx)
+}
+
+func TestLimitedRejectsOverLimit(t *testing.T) {
+	server := NewServer(NewMemoryStore(), &SystemService{}, ServerConfig{MaxConcurrentPerKey: 1}, NopMetrics{})
+
+	started, unblock := make(chan struct{}), make(chan struct{})
+	handler := server.limited(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-unblock
+		w.WriteHeader(http.StatusOK)
+	})
+
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		handler(w, requestAs("key-a"))
+		done <- w.Code
+	}()
+	<-started
+	assert.Equal(t, 1, server.limiter.inFlight("key-a"))
+
+	// The same key is turned away while its slot is taken
+	w := httptest.NewRecorder()
+	handler(w, requestAs("key-a"))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "key-a")
+
+	// Other keys have their own slots
+	go func() {
+		w := httptest.NewRecorder()
+		handler(w, requestAs("key-b"))
+		done <- w.Code
+	}()
+	<-started
+
+	close(unblock)
+	assert.Equal(t, http.StatusOK, <-done)
+	assert.Equal(t, http.StatusOK, <-done)
+	assert.Equal(t, 0, server.limiter.inFlight("key-a"))
+	assert.Empty(t, server.limiter.slots, "idle keys should be forgotten")
+}
+
+func TestLimitedQueuesUpToTimeout(t *testing.T) {
+	server := NewServer(NewMemoryStore(), &SystemService{}, ServerConfig{
+		MaxConcurrentPerKey:     1,
+		ConcurrencyQueueTimeout: time.Second,
+	}, NopMetrics{})
+
+	started, unblock := make(chan struct{}, 2), make(chan struct{})
+	handler := server.limited(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-unblock
+		w.WriteHeader(http.StatusOK)
+	})
+
+	done := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			w := httptest.NewRecorder()
+			handler(w, requestAs("key-a"))
+			done <- w.Code
+		}()
+	}
+	<-started
+	select {
+	case <-started:
+		t.Fatal("Expected the second request to queue behind the first")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Releasing the first slot admits the queued request
+	unblock <- struct{}{}
+	require.Equal(t, http.StatusOK, <-done)
+	<-started
+	close(unblock)
+	assert.Equal(t, http.StatusOK, <-done)
+
+	// A queued request whose client goes away gives up
+	server.limiter.wait = time.Hour
+	hold := make(chan struct{})
+	go server.limited(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-hold
+	})(httptest.NewRecorder(), requestAs("key-a"))
+	<-started
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	handler(w, requestAs("key-a").WithContext(
+		context.WithValue(ctx, principalContextKey{}, &Principal{Subject: "key-a"})))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	close(hold)
+}
+
+func TestLimitedWithoutLimit(t *testing.T) {
+	server := NewServer(NewMemoryStore(), &SystemService{}, ServerConfig{}, NopMetrics{})
+	assert.Nil(t, server.limiter)
+
+	w := httptest.NewRecorder()
+	server.limited(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})(w, requestAs("key-a"))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
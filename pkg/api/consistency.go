@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMinSeqWait is how long a read with ?min_seq waits for the store to
+// catch up when the server config doesn't set MinSeqWait
+const DefaultMinSeqWait = 5 * time.Second
+
+// SeqHeader carries a commit sequence as a consistency token. Write
+// responses set it to the sequence that includes the write; a read or batch
+// that sends it back waits until the store has applied that sequence, like
+// ?min_seq. Clients can pass it between services to order their operations.
+const SeqHeader = "X-Freyja-Seq"
+
+// seqStore is implemented by stores that number their commits, which is
+// what read-your-writes sessions are built on
+type seqStore interface {
+	CommitSeq() uint64
+	WaitForSeq(ctx context.Context, seq uint64) error
+}
+
+// writeResult is the body of a successful write: its message and, when the
+// store numbers commits, the sequence a client passes back as ?min_seq or
+// X-Freyja-Seq to read its own write. The sequence is also set on w.
+func (s *Server) writeResult(w http.ResponseWriter, message string) map[string]interface{} {
+	result := map[string]interface{}{"message": message}
+	if seq, ok := s.setSeqHeader(w); ok {
+		result["seq"] = seq
+	}
+	return result
+}
+
+// setSeqHeader sets X-Freyja-Seq on a write response to the store's commit
+// sequence, if it numbers commits
+func (s *Server) setSeqHeader(w http.ResponseWriter) (uint64, bool) {
+	kv, ok := s.store.(seqStore)
+	if !ok {
+		return 0, false
+	}
+	seq := kv.CommitSeq()
+	w.Header().Set(SeqHeader, strconv.FormatUint(seq, 10))
+	return seq, true
+}
+
+// requestMinSeq returns the sequence a request must see: the larger of
+// ?min_seq and X-Freyja-Seq, or zero if it sends neither
+func requestMinSeq(r *http.Request) (uint64, error) {
+	var minSeq uint64
+	if raw := r.URL.Query().Get("min_seq"); raw != "" {
+		seq, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return 0, errors.New("min_seq must be a non-negative integer")
+		}
+		minSeq = seq
+	}
+	if raw := r.Header.Get(SeqHeader); raw != "" {
+		seq, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s must be a non-negative integer", SeqHeader)
+		}
+		minSeq = max(minSeq, seq)
+	}
+	return minSeq, nil
+}
+
+// awaitMinSeq holds a read or batch until the store has applied the
+// sequence in ?min_seq or X-Freyja-Seq, so a session sees its earlier writes
+// even when served by a store that is still catching up. If the store doesn't get there within
+// MinSeqWait, the read is redirected to PrimaryURL or fails with 503. It
+// reports false once it has sent a response.
+func (s *Server) awaitMinSeq(w http.ResponseWriter, r *http.Request) bool {
+	minSeq, err := requestMinSeq(r)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if minSeq == 0 {
+		return true
+	}
+
+	kv, ok := s.store.(seqStore)
+	if !ok {
+		return true // Without sequence numbers every acknowledged write is already visible
+	}
+
+	wait := s.config.MinSeqWait
+	if wait <= 0 {
+		wait = DefaultMinSeqWait
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), wait)
+	defer cancel()
+	if err := kv.WaitForSeq(ctx, minSeq); err == nil {
+		return true
+	}
+
+	if s.config.PrimaryURL != "" {
+		http.Redirect(w, r, strings.TrimRight(s.config.PrimaryURL, "/")+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+		return false
+	}
+	w.Header().Set("Retry-After", "1")
+	sendError(w, fmt.Sprintf("Sequence %d is not visible yet (store is at %d)", minSeq, kv.CommitSeq()),
+		http.StatusServiceUnavailable)
+	return false
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadYourWrites(t *testing.T) {
+	systemService, err := NewSystemServiceWithStore(SystemConfig{}, NewMemoryStore())
+	require.NoError(t, err)
+
+	serve := func(config ServerConfig) *httptest.Server {
+		config.SystemKey = "root-key"
+		config.MinSeqWait = 20 * time.Millisecond
+		handler, err := NewHandler(NewMemoryStore(), config, Dependencies{SystemService: systemService, Metrics: NopMetrics{}})
+		require.NoError(t, err)
+		return httptest.NewServer(handler)
+	}
+	srv := serve(ServerConfig{})
+	defer srv.Close()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	do := func(base, method, path string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, base+path, bytes.NewBufferString("value"))
+		require.NoError(t, err)
+		req.Header.Set("X-API-Key", "root-key")
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := do(srv.URL, http.MethodPut, "/api/v1/kv/user:1")
+	var body struct {
+		Data struct {
+			Seq uint64 `json:"seq"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	resp.Body.Close()
+	require.Equal(t, uint64(1), body.Data.Seq)
+
+	resp = do(srv.URL, http.MethodGet, fmt.Sprintf("/api/v1/kv/user:1?min_seq=%d", body.Data.Seq))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// A sequence the store never reaches fails, or redirects to the primary
+	resp = do(srv.URL, http.MethodGet, "/api/v1/kv?min_seq=100")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+
+	standby := serve(ServerConfig{PrimaryURL: "http://primary:8080/"})
+	defer standby.Close()
+	resp = do(standby.URL, http.MethodGet, "/api/v1/scan?prefix=user&min_seq=100")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	assert.Equal(t, "http://primary:8080/api/v1/scan?prefix=user&min_seq=100", resp.Header.Get("Location"))
+
+	resp = do(srv.URL, http.MethodGet, "/api/v1/kv/user:1?min_seq=-1")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestConsistencyTokens(t *testing.T) {
+	systemService, err := NewSystemServiceWithStore(SystemConfig{}, NewMemoryStore())
+	require.NoError(t, err)
+	handler, err := NewHandler(NewMemoryStore(), ServerConfig{SystemKey: "root-key", MinSeqWait: 20 * time.Millisecond},
+		Dependencies{SystemService: systemService, Metrics: NopMetrics{}})
+	require.NoError(t, err)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	do := func(method, path, token, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		req.Header.Set("X-API-Key", "root-key")
+		if token != "" {
+			req.Header.Set(SeqHeader, token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	// Every write carries its sequence in the header
+	resp := do(http.MethodPut, "/api/v1/kv/user:1", "", "value")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get(SeqHeader))
+	resp = do(http.MethodPut, "/api/v1/kv/user:2", "", "value")
+	token := resp.Header.Get(SeqHeader)
+	assert.Equal(t, "2", token)
+
+	resp = do(http.MethodPost, "/api/v1/relationships/_bulk", token,
+		`{"relationships":[{"from_key":"user:1","to_key":"user:2","relation":"follows"}]}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get(SeqHeader))
+
+	// Reads and batches wait for the token
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/v1/kv/user:1", token, "").StatusCode)
+	assert.Equal(t, http.StatusServiceUnavailable, do(http.MethodGet, "/api/v1/kv/user:1", "100", "").StatusCode)
+	assert.Equal(t, http.StatusServiceUnavailable, do(http.MethodPost, "/api/v1/relationships/_bulk", "100",
+		`{"relationships":[{"from_key":"user:1","to_key":"user:1","relation":"follows"}]}`).StatusCode)
+
+	// The larger of the header and ?min_seq wins
+	assert.Equal(t, http.StatusServiceUnavailable, do(http.MethodGet, "/api/v1/kv/user:1?min_seq=100", token, "").StatusCode)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/api/v1/kv/user:1", "soon", "").StatusCode)
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/keys"
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// Explain history defaults
+const (
+	explainKeys             = keys.Keyspace("explain")
+	explainKeyLayout        = "2006-01-02T15:04:05Z" // Fixed width, so keys sort by time
+	explainSnapshotInterval = 15 * time.Minute
+	ExplainHistoryRetention = 7 * 24 * time.Hour // Older snapshots are pruned
+)
+
+// ExplainSnapshot is the trend-worthy subset of an explain result, recorded
+// periodically so /explain?history= can show how the store changed
+type ExplainSnapshot struct {
+	TakenAt         time.Time `json:"taken_at"`
+	SchemaVersion   int       `json:"schema_version"`
+	ActiveKeys      int       `json:"active_keys"`
+	Tombstones      int       `json:"tombstones"`
+	TotalSizeMB     float64   `json:"total_size_mb"`
+	LiveSizeMB      float64   `json:"live_size_mb"`
+	IndexMemoryMB   float64   `json:"index_memory_mb"`
+	Segments        int       `json:"segments"`
+	CompactionReady int       `json:"compaction_ready"`
+	CRCErrors       int       `json:"crc_errors"`
+}
+
+// NewExplainSnapshot extracts a snapshot from an explain result
+func NewExplainSnapshot(res *store.ExplainResult, at time.Time) ExplainSnapshot {
+	return ExplainSnapshot{
+		TakenAt:         at.UTC().Truncate(time.Second),
+		SchemaVersion:   res.SchemaVersion,
+		ActiveKeys:      res.Global.ActiveKeys,
+		Tombstones:      res.Global.Tombstones,
+		TotalSizeMB:     res.Global.TotalSizeMB,
+		LiveSizeMB:      res.Global.LiveSizeMB,
+		IndexMemoryMB:   res.Global.IndexMemoryMB,
+		Segments:        len(res.Segments),
+		CompactionReady: len(res.Diagnostics.CompactionReady),
+		CRCErrors:       res.Diagnostics.CRCErrors,
+	}
+}
+
+// ExplainResponse is an explain result with the snapshots recorded over
+// the requested history window, oldest first, and what recent opens of the
+// store recovered
+type ExplainResponse struct {
+	*store.ExplainResult
+	History  []ExplainSnapshot `json:"history,omitempty"`
+	Recovery *RecoverySummary  `json:"recovery,omitempty"` // Recent opens of the store
+}
+
+// RecordExplainSnapshot stores snap under its timestamp in the system
+// keyspace and prunes snapshots older than ExplainHistoryRetention
+func (s *SystemService) RecordExplainSnapshot(snap ExplainSnapshot) error {
+	if !s.isOpen {
+		return fmt.Errorf("system service is not open")
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal explain snapshot: %w", err)
+	}
+	encryptedData, err := s.encrypt(data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt explain snapshot: %w", err)
+	}
+	if err := s.store.Put(explainKeys.Bytes(snap.TakenAt.UTC().Format(explainKeyLayout)), encryptedData); err != nil {
+		return err
+	}
+
+	stale, err := s.explainSnapshotKeys()
+	if err != nil {
+		return err
+	}
+	cutoff := explainKeys.Key(snap.TakenAt.Add(-ExplainHistoryRetention).UTC().Format(explainKeyLayout))
+	for _, key := range stale {
+		if key >= cutoff {
+			break
+		}
+		if err := s.store.Delete([]byte(key)); err != nil {
+			return fmt.Errorf("failed to prune explain snapshot %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// ExplainSnapshots returns the snapshots taken at or after since, oldest first
+func (s *SystemService) ExplainSnapshots(since time.Time) ([]ExplainSnapshot, error) {
+	if !s.isOpen {
+		return nil, fmt.Errorf("system service is not open")
+	}
+
+	stored, err := s.explainSnapshotKeys()
+	if err != nil {
+		return nil, err
+	}
+	from := explainKeys.Key(since.UTC().Format(explainKeyLayout))
+
+	snapshots := make([]ExplainSnapshot, 0)
+	for _, key := range stored {
+		if key < from {
+			continue
+		}
+		encryptedData, err := s.store.Get([]byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get explain snapshot %s: %w", key, err)
+		}
+		data, err := s.decrypt(encryptedData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt explain snapshot %s: %w", key, err)
+		}
+		var snap ExplainSnapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal explain snapshot %s: %w", key, err)
+		}
+		snapshots = append(snapshots, snap)
+	}
+	return snapshots, nil
+}
+
+// explainSnapshotKeys lists the stored snapshot keys, oldest first
+func (s *SystemService) explainSnapshotKeys() ([]string, error) {
+	stored, err := s.store.ListKeys([]byte(explainKeys.Prefix()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list explain snapshots: %w", err)
+	}
+	sort.Strings(stored)
+	return stored, nil
+}
+
+// recordExplainSnapshot captures the store's current explain summary
+func (s *Server) recordExplainSnapshot() error {
+	res, err := s.store.Explain(context.Background(), store.ExplainOptions{})
+	if err != nil {
+		return err
+	}
+	return s.systemService.RecordExplainSnapshot(NewExplainSnapshot(res, time.Now()))
+}
+
+// startExplainRecorder records an explain snapshot every
+// explainSnapshotInterval for /explain?history=
+func (s *Server) startExplainRecorder() {
+	ticker := time.NewTicker(explainSnapshotInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.recordExplainSnapshot(); err != nil {
+			s.logger.Warn("failed to record explain snapshot", "error", err)
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplainHistory(t *testing.T) {
+	systemService, err := NewSystemServiceWithStore(SystemConfig{}, NewMemoryStore())
+	require.NoError(t, err)
+
+	now := time.Now().UTC()
+	res := store.NewExplainResult(context.Background())
+	for i, age := range []time.Duration{8 * 24 * time.Hour, 30 * time.Hour, 2 * time.Hour, time.Hour} {
+		res.Global.ActiveKeys = i
+		require.NoError(t, systemService.RecordExplainSnapshot(NewExplainSnapshot(res, now.Add(-age))))
+	}
+
+	// The 8-day-old snapshot was pruned when newer ones were recorded
+	all, err := systemService.ExplainSnapshots(time.Time{})
+	require.NoError(t, err)
+	require.Len(t, all, 3)
+	assert.Equal(t, 1, all[0].ActiveKeys)
+
+	kvStore := NewMemoryStore()
+	require.NoError(t, kvStore.Put([]byte("user:1"), []byte("v")))
+	handler, err := NewHandler(kvStore, ServerConfig{SystemKey: "root-key"}, Dependencies{
+		SystemService: systemService,
+		Metrics:       NopMetrics{},
+	})
+	require.NoError(t, err)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/explain"+query, nil)
+		req.Header.Set("X-API-Key", "root-key")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("?history=24h")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var body struct {
+		Data struct {
+			SchemaVersion int `json:"schema_version"`
+			Global        struct {
+				ActiveKeys int `json:"active_keys"`
+			} `json:"global"`
+			History []ExplainSnapshot `json:"history"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, store.ExplainSchemaVersion, body.Data.SchemaVersion)
+	assert.Equal(t, 1, body.Data.Global.ActiveKeys)
+	require.Len(t, body.Data.History, 2)
+	assert.Equal(t, []int{2, 3}, []int{body.Data.History[0].ActiveKeys, body.Data.History[1].ActiveKeys})
+
+	assert.Equal(t, http.StatusBadRequest, get("?history=yesterday").Code)
+	assert.NotContains(t, get("").Body.String(), `"history"`)
+}
@@ -1,9 +1,7 @@
 // Package api provides factory implementations for dependency injection
 package api
 
-import (
-	"github.com/ssargent/freyjadb/pkg/store"
-)
+import "time"
 
 // DefaultSystemServiceFactory is the default implementation of SystemServiceFactory
 type DefaultSystemServiceFactory struct{}
@@ -14,24 +12,49 @@ func NewSystemServiceFactory() SystemServiceFactory {
 }
 
 // DefaultServerFactory is the default implementation of ServerFactory
-type DefaultServerFactory struct{}
+type DefaultServerFactory struct {
+	Dependencies        Dependencies     // Passed to every server it starts
+	ShutdownGracePeriod time.Duration    // See ServerConfig.ShutdownGracePeriod
+	Middleware          MiddlewareConfig // See ServerConfig.Middleware
+	ViewerKey           string           // See ServerConfig.ViewerKey
+	ViewerMaxValueBytes int              // See ServerConfig.ViewerMaxValueBytes
+}
 
 // NewServerFactory creates a new server factory
 func NewServerFactory() ServerFactory {
 	return &DefaultServerFactory{}
 }
 
+// NewServerFactoryWithDependencies creates a server factory whose servers
+// use deps in place of the defaults
+func NewServerFactoryWithDependencies(deps Dependencies) ServerFactory {
+	return &DefaultServerFactory{Dependencies: deps}
+}
+
 // CreateServerStarter creates a server starter
 func (f *DefaultServerFactory) CreateServerStarter() ServerStarter {
-	return &DefaultServerStarter{}
+	return &DefaultServerStarter{
+		Dependencies:        f.Dependencies,
+		ShutdownGracePeriod: f.ShutdownGracePeriod,
+		Middleware:          f.Middleware,
+		ViewerKey:           f.ViewerKey,
+		ViewerMaxValueBytes: f.ViewerMaxValueBytes,
+	}
 }
 
 // DefaultServerStarter is the default implementation of ServerStarter
-type DefaultServerStarter struct{}
+type DefaultServerStarter struct {
+	Dependencies        Dependencies
+	ShutdownGracePeriod time.Duration
+	Middleware          MiddlewareConfig
+	ViewerKey           string
+	ViewerMaxValueBytes int
+}
 
-// StartServer starts the API server with the given configuration
+// StartServer starts the API server with the given configuration. It
+// returns after a SIGINT or SIGTERM once the server has been shut down.
 func (s *DefaultServerStarter) StartServer(
-	kvStore *store.KVStore,
+	kvStore IKVStore,
 	port int,
 	apiKey, systemKey, dataDir, systemEncryptionKey string,
 	enableEncryption bool,
@@ -44,8 +67,12 @@ func (s *DefaultServerStarter) StartServer(
 		SystemDataDir:       dataDir,
 		SystemEncryptionKey: systemEncryptionKey,
 		EnableEncryption:    enableEncryption,
+		ShutdownGracePeriod: s.ShutdownGracePeriod,
+		Middleware:          s.Middleware,
+		ViewerKey:           s.ViewerKey,
+		ViewerMaxValueBytes: s.ViewerMaxValueBytes,
 	}
-	return StartServer(kvStore, config)
+	return StartServerWithDependencies(kvStore, config, s.Dependencies)
 }
 
 // CreateSystemService creates a new system service with the given config
@@ -2,9 +2,11 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
-	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -13,6 +15,10 @@ import (
 	"github.com/ssargent/freyjadb/pkg/store"
 )
 
+// DefaultMaxBulkRelationships caps the edges in one bulk request when the
+// server config doesn't set MaxBulkRelationships
+const DefaultMaxBulkRelationships = 1000
+
 // KeyValueResponse represents the response when including relationships
 type KeyValueResponse struct {
 	Value         interface{}                `json:"value"`
@@ -23,18 +29,34 @@ type KeyValueResponse struct {
 // Server holds the API server state
 type Server struct {
 	store         IKVStore
-	systemService *SystemService
+	systemService SystemManager
 	config        ServerConfig
-	metrics       *Metrics
+	metrics       MetricsRecorder
+	logger        *slog.Logger
+	pipelines     *valuePipelines
+	pipelineErr   error               // Invalid pipeline configuration; fails every write
+	stores        *store.StoreManager // Namespace stores; nil when namespaces aren't served
+	alerts        *AlertMonitor       // Soft limit alerting; nil when not configured
+	slo           *SLOTracker         // Latency objectives; nil when none are configured
+	jobs          *jobRunner
+	quiesce       *quiesceState       // Quiesce held through /system/quiesce
+	limiter       *concurrencyLimiter // Expensive requests in flight per API key; nil when unlimited
+	ownedSystem   io.Closer           // System service the server opened, closed by Close
 }
 
 // NewServer creates a new API server
-func NewServer(store IKVStore, systemService *SystemService, config ServerConfig, metrics *Metrics) *Server {
+func NewServer(store IKVStore, systemService SystemManager, config ServerConfig, metrics MetricsRecorder) *Server {
+	pipelines, err := newValuePipelines(config.ValuePipelines, config.PipelineKeys)
 	return &Server{
 		store:         store,
 		systemService: systemService,
 		config:        config,
 		metrics:       metrics,
+		logger:        slog.Default(),
+		pipelines:     pipelines,
+		pipelineErr:   err,
+		quiesce:       &quiesceState{},
+		limiter:       newConcurrencyLimiter(config.MaxConcurrentPerKey, config.ConcurrencyQueueTimeout),
 	}
 }
 
@@ -56,15 +78,18 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 // handlePut godoc
 //
 //	@Summary		Put a key-value pair
-//	@Description	Store a key-value pair in the database
+//	@Description	Store a key-value pair in the database. Use ?ttl= to expire it after a duration.
 //	@Tags			kv
 //	@Accept			octet-stream,json
 //	@Produce		json
 //	@Param			key		path		string				true	"Key"
 //	@Param			body	body		[]byte				true	"Value"
+//	@Param			ttl		query		string				false	"Expire the value after this duration (e.g. 90s, 24h) or number of seconds"
 //	@Param			Content-Type	header		string				false	"Content type (application/json or application/octet-stream)"
+//	@Param			X-Key-Encoding	header		string	false	"base64 to send and receive keys as base64"
 //	@Success		200		{object}	map[string]string
 //	@Failure		400		{object}	map[string]string
+//	@Failure		429		{object}	map[string]string
 //	@Failure		500		{object}	map[string]string
 //	@Security		ApiKeyAuth
 //	@Router			/kv/{key} [put]
@@ -79,14 +104,29 @@ func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Read the request body
-	body := make([]byte, r.ContentLength)
-	_, err := r.Body.Read(body)
-	if err != nil && err.Error() != "EOF" {
+	storeKey, err := s.requestKey(r)
+	if err != nil {
 		if s.metrics != nil {
 			s.metrics.RecordDBOperation("put", false, time.Since(start))
 		}
-		sendError(w, "Failed to read request body", http.StatusBadRequest)
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ttl, err := requestTTL(r)
+	if err != nil {
+		if s.metrics != nil {
+			s.metrics.RecordDBOperation("put", false, time.Since(start))
+		}
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	expiring, ok := s.store.(ttlStore)
+	if ttl > 0 && !ok {
+		if s.metrics != nil {
+			s.metrics.RecordDBOperation("put", false, time.Since(start))
+		}
+		sendError(w, "Store does not support TTLs", http.StatusNotImplemented)
 		return
 	}
 
@@ -94,6 +134,38 @@ func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
 	contentTypeHeader := r.Header.Get("Content-Type")
 	contentType := getContentTypeFromHeader(contentTypeHeader)
 
+	// Large raw values go to disk as they arrive; streamed records can't
+	// carry an expiry
+	if streamer, ok := s.streamingPut(r, storeKey, contentType); ok && ttl == 0 {
+		if err := putStream(streamer, storeKey, r); err != nil {
+			if s.metrics != nil {
+				s.metrics.RecordDBOperation("put", false, time.Since(start))
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				sendError(w, "Request body is shorter than its Content-Length", http.StatusBadRequest)
+				return
+			}
+			sendError(w, fmt.Sprintf("Failed to put key-value: %v", err), writeErrorStatus(w, err))
+			return
+		}
+		if s.metrics != nil {
+			s.metrics.RecordDBOperation("put", true, time.Since(start))
+		}
+		sendSuccess(w, s.writeResult(w, "Key-value pair stored successfully"))
+		return
+	}
+
+	// Read the request body
+	body := make([]byte, r.ContentLength)
+	_, err = r.Body.Read(body)
+	if err != nil && err.Error() != "EOF" {
+		if s.metrics != nil {
+			s.metrics.RecordDBOperation("put", false, time.Since(start))
+		}
+		sendError(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
 	var dataToStore []byte
 
 	// Handle JSON marshaling if content type is JSON
@@ -121,29 +193,32 @@ func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
 		dataToStore = body
 	}
 
-	// Encode data with content type metadata
-	encodedData := encodeDataWithContentType(dataToStore, contentType)
-
-	unescapedKey, err := url.QueryUnescape(chi.URLParam(r, "key"))
+	// Encode data with content type metadata, through the key's value pipeline
+	encodedData, err := s.encodeValue(storeKey, dataToStore, contentType)
 	if err != nil {
 		if s.metrics != nil {
 			s.metrics.RecordDBOperation("put", false, time.Since(start))
 		}
-		sendError(w, "Invalid key encoding", http.StatusBadRequest)
+		sendError(w, fmt.Sprintf("Failed to encode value: %v", err), http.StatusInternalServerError)
 		return
 	}
-	if err := s.store.Put([]byte(unescapedKey), encodedData); err != nil {
+	if ttl > 0 {
+		err = expiring.PutWithTTL(storeKey, encodedData, ttl)
+	} else {
+		err = s.store.Put(storeKey, encodedData)
+	}
+	if err != nil {
 		if s.metrics != nil {
 			s.metrics.RecordDBOperation("put", false, time.Since(start))
 		}
-		sendError(w, fmt.Sprintf("Failed to put key-value: %v", err), http.StatusInternalServerError)
+		sendError(w, fmt.Sprintf("Failed to put key-value: %v", err), writeErrorStatus(w, err))
 		return
 	}
 
 	if s.metrics != nil {
 		s.metrics.RecordDBOperation("put", true, time.Since(start))
 	}
-	sendSuccess(w, map[string]string{"message": "Key-value pair stored successfully"})
+	sendSuccess(w, s.writeResult(w, "Key-value pair stored successfully"))
 }
 
 // handleGet godoc
@@ -155,6 +230,7 @@ func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
 //	@Produce		octet-stream,json
 //	@Param			key		path		string	true	"Key"
 //	@Param			include	query		string	false	"Include additional data (relationships)"
+//	@Param			X-Key-Encoding	header		string	false	"base64 to send and receive keys as base64"
 //	@Success		200		{string}	byte
 //	@Success		200		{object}	KeyValueResponse
 //	@Failure		400		{object}	map[string]string
@@ -163,6 +239,9 @@ func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
 //	@Router			/kv/{key} [get]
 //	@Security		ApiKeyAuth
 func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	if !s.awaitMinSeq(w, r) {
+		return
+	}
 	start := time.Now()
 	key := chi.URLParam(r, "key")
 	if key == "" {
@@ -173,7 +252,13 @@ func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
 
 	includeRelationships := r.URL.Query().Get("include") == "relationships"
 
-	encodedValue, err := s.store.Get([]byte(key))
+	storeKey, err := s.requestKey(r)
+	if err != nil {
+		s.metrics.RecordDBOperation("get", false, time.Since(start))
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	encodedValue, err := s.store.Get(storeKey)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			s.metrics.RecordDBOperation("get", false, time.Since(start))
@@ -186,14 +271,26 @@ func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Decode the data and extract content type
-	data, contentType := decodeDataWithContentType(encodedValue)
+	data, contentType, err := s.decodeValue(encodedValue)
+	if err != nil {
+		s.metrics.RecordDBOperation("get", false, time.Since(start))
+		sendError(w, fmt.Sprintf("Failed to decode value: %v", err), http.StatusInternalServerError)
+		return
+	}
 
 	s.metrics.RecordDBOperation("get", true, time.Since(start))
 
+	// Viewers see large values cut short
+	size := len(data)
+	data, contentType, truncated := truncateValue(data, contentType, s.viewerLimit(r))
+	if truncated {
+		w.Header().Set(ValueTruncatedHeader, strconv.Itoa(size))
+	}
+
 	if includeRelationships {
 		// Fetch relationships
 		query := store.RelationshipQuery{
-			Key:       key,
+			Key:       string(storeKey),
 			Direction: "both",
 			Limit:     100, // Default limit
 		}
@@ -242,8 +339,10 @@ func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
 //	@Accept			json
 //	@Produce		json
 //	@Param			key	path		string	true	"Key"
+//	@Param			X-Key-Encoding	header		string	false	"base64 to send and receive keys as base64"
 //	@Success		200	{object}	map[string]string
 //	@Failure		400	{object}	map[string]string
+//	@Failure		429	{object}	map[string]string
 //	@Failure		500	{object}	map[string]string
 //	@Router			/kv/{key} [delete]
 //	@Security		ApiKeyAuth
@@ -256,36 +355,65 @@ func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.store.Delete([]byte(key)); err != nil {
+	storeKey, err := s.requestKey(r)
+	if err != nil {
 		s.metrics.RecordDBOperation("delete", false, time.Since(start))
-		sendError(w, fmt.Sprintf("Failed to delete key: %v", err), http.StatusInternalServerError)
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.store.Delete(storeKey); err != nil {
+		s.metrics.RecordDBOperation("delete", false, time.Since(start))
+		sendError(w, fmt.Sprintf("Failed to delete key: %v", err), writeErrorStatus(w, err))
 		return
 	}
 
 	s.metrics.RecordDBOperation("delete", true, time.Since(start))
-	sendSuccess(w, map[string]string{"message": "Key deleted successfully"})
+	sendSuccess(w, s.writeResult(w, "Key deleted successfully"))
 }
 
 // handleListKeys godoc
 //
 //	@Summary		List keys
-//	@Description	List all keys with optional prefix
+//	@Description	List all keys with optional prefix. Send Accept: application/x-ndjson to stream the keys
+//	@Description	one per line instead, as GET /scan?keys_only=true does.
 //	@Tags			kv
 //	@Accept			json
-//	@Produce		json
+//	@Produce		json,x-ndjson
 //	@Param			prefix	query		string	false	"Key prefix"
+//	@Param			limit	query		int		false	"Maximum number of keys to stream"
+//	@Param			X-Key-Encoding	header		string	false	"base64 to send and receive keys as base64"
 //	@Success		200	{object}	map[string]interface{}
 //	@Failure		500	{object}	map[string]string
 //	@Router			/kv [get]
 //	@Security		ApiKeyAuth
 func (s *Server) handleListKeys(w http.ResponseWriter, r *http.Request) {
-	prefix := r.URL.Query().Get("prefix")
+	if !s.awaitMinSeq(w, r) {
+		return
+	}
+	if wantsNDJSON(r) {
+		s.streamScan(w, r, true)
+		return
+	}
 
-	keys, err := s.store.ListKeys([]byte(prefix))
+	codec, err := requestKeyCodec(r)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	prefix, err := codec.prefix(r)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	keys, err := s.store.ListKeys(prefix)
 	if err != nil {
 		sendError(w, fmt.Sprintf("Failed to list keys: %v", err), http.StatusInternalServerError)
 		return
 	}
+	for i, key := range keys {
+		keys[i] = codec.encode(key)
+	}
 
 	sendSuccess(w, map[string]interface{}{"keys": keys})
 }
@@ -324,7 +452,85 @@ func (s *Server) handleCreateRelationship(w http.ResponseWriter, r *http.Request
 	}
 
 	s.metrics.RecordRelationshipOperation("create", true)
-	sendSuccess(w, map[string]string{"message": "Relationship created successfully"})
+	sendSuccess(w, s.writeResult(w, "Relationship created successfully"))
+}
+
+// handleBulkCreateRelationships godoc
+//
+//	@Summary		Create relationships in bulk
+//	@Description	Create up to MaxBulkRelationships relationships; if any edge is invalid none are written
+//	@Tags			relationships
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		BulkRelationshipRequest	true	"Relationships to create"
+//	@Param			X-Freyja-Seq	header		int	false	"Commit sequence the store must reach before the batch runs"
+//	@Success		200		{object}	BulkRelationshipResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		422		{object}	BulkRelationshipResponse
+//	@Failure		500		{object}	map[string]string
+//	@Router			/relationships/_bulk [post]
+//	@Security		ApiKeyAuth
+func (s *Server) handleBulkCreateRelationships(w http.ResponseWriter, r *http.Request) {
+	if !s.awaitMinSeq(w, r) {
+		return
+	}
+	var req BulkRelationshipRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.metrics.RecordRelationshipOperation("bulk_create", false)
+		sendError(w, "Invalid JSON request", http.StatusBadRequest)
+		return
+	}
+
+	maxEdges := s.config.MaxBulkRelationships
+	if maxEdges <= 0 {
+		maxEdges = DefaultMaxBulkRelationships
+	}
+	if len(req.Relationships) == 0 || len(req.Relationships) > maxEdges {
+		s.metrics.RecordRelationshipOperation("bulk_create", false)
+		sendError(w, fmt.Sprintf("relationships must hold between 1 and %d edges", maxEdges), http.StatusBadRequest)
+		return
+	}
+
+	rels := make([]store.Relationship, len(req.Relationships))
+	for i, edge := range req.Relationships {
+		rels[i] = store.Relationship{FromKey: edge.FromKey, ToKey: edge.ToKey, Relation: edge.Relation}
+	}
+	edgeErrs, err := s.store.PutRelationships(rels)
+	if err != nil && !errors.Is(err, store.ErrRelationshipBatch) {
+		s.metrics.RecordRelationshipOperation("bulk_create", false)
+		sendError(w, fmt.Sprintf("Failed to create relationships: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	resp := BulkRelationshipResponse{Results: make([]BulkRelationshipResult, len(rels))}
+	for i, edge := range req.Relationships {
+		result := BulkRelationshipResult{
+			Index:    i,
+			FromKey:  edge.FromKey,
+			ToKey:    edge.ToKey,
+			Relation: edge.Relation,
+			Status:   "created",
+		}
+		switch {
+		case i < len(edgeErrs) && edgeErrs[i] != nil:
+			result.Status = "invalid"
+			result.Error = edgeErrs[i].Error()
+		case err != nil:
+			result.Status = "rejected"
+		default:
+			resp.Created++
+		}
+		resp.Results[i] = result
+	}
+
+	if err != nil {
+		s.metrics.RecordRelationshipOperation("bulk_create", false)
+		sendErrorData(w, "Relationship batch rejected: no edges were written", http.StatusUnprocessableEntity, resp)
+		return
+	}
+	s.metrics.RecordRelationshipOperation("bulk_create", true)
+	resp.Seq, _ = s.setSeqHeader(w)
+	sendSuccess(w, resp)
 }
 
 // handleDeleteRelationship godoc
@@ -357,7 +563,7 @@ func (s *Server) handleDeleteRelationship(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	sendSuccess(w, map[string]string{"message": "Relationship deleted successfully"})
+	sendSuccess(w, s.writeResult(w, "Relationship deleted successfully"))
 }
 
 // handleGetRelationships godoc
@@ -377,6 +583,9 @@ func (s *Server) handleDeleteRelationship(w http.ResponseWriter, r *http.Request
 //	@Router			/relationships [get]
 //	@Security		ApiKeyAuth
 func (s *Server) handleGetRelationships(w http.ResponseWriter, r *http.Request) {
+	if !s.awaitMinSeq(w, r) {
+		return
+	}
 	key := r.URL.Query().Get("key")
 	direction := r.URL.Query().Get("direction")
 	relation := r.URL.Query().Get("relation")
@@ -421,9 +630,11 @@ func (s *Server) handleGetRelationships(w http.ResponseWriter, r *http.Request)
 //	@Tags			diagnostics
 //	@Accept			json
 //	@Produce		json
-//	@Param			pk	query		string	false	"Primary key to explain"
-//	@Success		200	{object}	map[string]interface{}
-//	@Failure		500	{object}	map[string]string
+//	@Param			pk		query		string	false	"Primary key to explain"
+//	@Param			history	query		string	false	"Include snapshots recorded over this window, e.g. 24h"
+//	@Success		200		{object}	ExplainResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
 //	@Router			/explain [get]
 //	@Security		ApiKeyAuth
 func (s *Server) handleExplain(w http.ResponseWriter, r *http.Request) {
@@ -436,13 +647,101 @@ func (s *Server) handleExplain(w http.ResponseWriter, r *http.Request) {
 		opts.PK = pk
 	}
 
+	var window time.Duration
+	if raw := r.URL.Query().Get("history"); raw != "" {
+		var err error
+		if window, err = time.ParseDuration(raw); err != nil || window <= 0 {
+			sendError(w, "history must be a positive duration such as 24h", http.StatusBadRequest)
+			return
+		}
+	}
+
 	result, err := s.store.Explain(r.Context(), opts)
 	if err != nil {
 		sendError(w, fmt.Sprintf("Failed to get explain data: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	sendSuccess(w, result)
+	response := ExplainResponse{ExplainResult: result}
+	s.explainRecovery(&response)
+	if window > 0 {
+		if response.History, err = s.systemService.ExplainSnapshots(time.Now().Add(-window)); err != nil {
+			sendError(w, fmt.Sprintf("Failed to load explain history: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	sendSuccess(w, response)
+}
+
+// handleCompactionEstimate godoc
+//
+//	@Summary		Estimate compaction savings
+//	@Description	Dry-run a compaction: bytes each segment would reclaim and roughly how long it would take
+//	@Tags			diagnostics
+//	@Produce		json
+//	@Success		200	{object}	store.CompactionEstimate
+//	@Failure		500	{object}	map[string]string
+//	@Router			/compaction/estimate [get]
+//	@Security		ApiKeyAuth
+func (s *Server) handleCompactionEstimate(w http.ResponseWriter, r *http.Request) {
+	estimate, err := s.store.EstimateCompaction()
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to estimate compaction: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	sendSuccess(w, estimate)
+}
+
+// handleListStores godoc
+//
+//	@Summary		List stores
+//	@Description	Statistics for every store the server has open (default, system and namespaces), and the
+//	@Description	namespaces present on disk
+//	@Tags			diagnostics
+//	@Produce		json
+//	@Success		200	{object}	StoresResponse
+//	@Failure		500	{object}	map[string]string
+//	@Router			/stores [get]
+//	@Security		ApiKeyAuth
+func (s *Server) handleListStores(w http.ResponseWriter, r *http.Request) {
+	namespaces, err := s.stores.Namespaces()
+	if err != nil {
+		sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if namespaces == nil {
+		namespaces = []string{}
+	}
+
+	sendSuccess(w, StoresResponse{Stores: s.stores.Stats(), Namespaces: namespaces})
+}
+
+// inNamespace serves a KV handler from the store of the request's
+// {namespace}, opening it if needed. With create false a namespace that
+// doesn't exist yet gets a 404 instead of being created, so reads never
+// leave empty stores behind.
+func (s *Server) inNamespace(create bool,
+	handler func(*Server, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		kv, err := s.stores.Namespace(r.Context(), chi.URLParam(r, "namespace"), create)
+		switch {
+		case errors.Is(err, store.ErrInvalidNamespace):
+			sendError(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, store.ErrNamespaceNotFound):
+			sendError(w, "Namespace not found", http.StatusNotFound)
+			return
+		case err != nil:
+			sendError(w, fmt.Sprintf("Failed to open namespace: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		scoped := *s
+		scoped.store = kv
+		handler(&scoped, w, r)
+	}
 }
 
 // handleStats godoc
@@ -460,9 +759,26 @@ func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 	stats := s.store.Stats()
 	// Update metrics with current stats
 	s.metrics.UpdateDBStats(stats.Keys, stats.DataSize)
+	s.metrics.UpdateReadRepairs(stats.ReadRepairs, stats.ReadRepairFailures)
+	s.metrics.UpdateKeyCategories(stats)
 	sendSuccess(w, stats)
 }
 
+// handlePrefixStats godoc
+//
+//	@Summary		Get statistics for a key prefix
+//	@Description	Key count, bytes, average value size and last write time of the keys under a prefix.
+//	@Description	The counts are maintained as keys are written, so this is cheap enough for dashboards.
+//	@Tags			diagnostics
+//	@Produce		json
+//	@Param			prefix	query		string	false	"Key prefix, e.g. users: (default every key)"
+//	@Success		200		{object}	store.PrefixStats
+//	@Router			/stats/prefix [get]
+//	@Security		ApiKeyAuth
+func (s *Server) handlePrefixStats(w http.ResponseWriter, r *http.Request) {
+	sendSuccess(w, s.store.PrefixStats([]byte(r.URL.Query().Get("prefix"))))
+}
+
 // Content type constants
 const (
 	ContentTypeRaw    = 0
@@ -496,6 +812,23 @@ func decodeDataWithContentType(encodedData []byte) ([]byte, int) {
 	return data, contentType
 }
 
+// encodeValue prepares a request body for the store: the value header
+// plus whatever the key's value pipeline does to the data
+func (s *Server) encodeValue(key, data []byte, contentType int) ([]byte, error) {
+	if s.pipelineErr != nil {
+		return nil, s.pipelineErr
+	}
+	return s.pipelines.encode(key, data, contentType)
+}
+
+// decodeValue reverses encodeValue using the stages recorded in the value
+func (s *Server) decodeValue(encoded []byte) ([]byte, int, error) {
+	if s.pipelines == nil {
+		return nil, 0, s.pipelineErr
+	}
+	return s.pipelines.decode(encoded)
+}
+
 // getContentTypeFromHeader extracts content type from HTTP Content-Type header
 func getContentTypeFromHeader(contentTypeHeader string) int {
 	if strings.Contains(contentTypeHeader, "application/json") {
@@ -522,11 +855,61 @@ func (s *Server) startMetricsUpdater() {
 	for range ticker.C {
 		stats := s.store.Stats()
 		s.metrics.UpdateDBStats(stats.Keys, stats.DataSize)
+		s.metrics.UpdateReadRepairs(stats.ReadRepairs, stats.ReadRepairFailures)
+		s.metrics.UpdateKeyCategories(stats)
 	}
 }
 
 // System API handlers
 
+// maxSequenceCount caps the IDs a single sequence request can allocate
+const maxSequenceCount = 10000
+
+// handleNextSequence godoc
+//
+//	@Summary		Allocate sequence IDs
+//	@Description	Allocate the next IDs of a named sequence. IDs start at 1 and increase monotonically
+//	@Description	across restarts; IDs allocated before a restart may leave gaps but are never reused.
+//	@Tags			sequence
+//	@Accept			json
+//	@Produce		json
+//	@Param			name	path		string	true	"Sequence name"
+//	@Param			count	query		int		false	"Number of consecutive IDs to allocate (default 1)"
+//	@Success		200	{object}	SequenceResponse
+//	@Failure		400	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Router			/sequence/{name} [post]
+//	@Security		ApiKeyAuth
+func (s *Server) handleNextSequence(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	name := chi.URLParam(r, "name")
+	if name == "" {
+		sendError(w, "Sequence name is required", http.StatusBadRequest)
+		return
+	}
+
+	count := 1
+	if countStr := r.URL.Query().Get("count"); countStr != "" {
+		c, err := strconv.Atoi(countStr)
+		if err != nil || c < 1 || c > maxSequenceCount {
+			sendError(w, fmt.Sprintf("count must be between 1 and %d", maxSequenceCount), http.StatusBadRequest)
+			return
+		}
+		count = c
+	}
+
+	id, err := s.store.NextIDs(name, count)
+	if err != nil {
+		s.metrics.RecordDBOperation("sequence", false, time.Since(start))
+		sendError(w, fmt.Sprintf("Failed to allocate IDs: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	s.metrics.RecordDBOperation("sequence", true, time.Since(start))
+	s.setSeqHeader(w)
+	sendSuccess(w, SequenceResponse{Name: name, ID: id, Count: count})
+}
+
 // handleCreateAPIKey godoc
 //
 //	@Summary		Create a new API key
@@ -567,6 +950,7 @@ func (s *Server) handleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.audit(r, "api_key.create", slog.String("id", apiKey.ID))
 	sendSuccess(w, map[string]interface{}{
 		"message": "API key created successfully",
 		"id":      apiKey.ID,
@@ -644,6 +1028,7 @@ func (s *Server) handleDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.audit(r, "api_key.delete", slog.String("id", keyID))
 	sendSuccess(w, map[string]string{"message": "API key deleted successfully"})
 }
 
@@ -706,5 +1091,6 @@ func (s *Server) handleSetSystemConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.audit(r, "config.set", slog.String("key", key))
 	sendSuccess(w, map[string]string{"message": "Configuration updated successfully"})
 }
@@ -7,12 +7,15 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/ssargent/freyjadb/pkg/store"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
 )
 
@@ -291,3 +294,259 @@ func TestHandlePut(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleNextSequence(t *testing.T) {
+	tests := []struct {
+		name           string
+		sequence       string
+		query          string
+		expectedStatus int
+		expectedBody   string
+		mocks          func(store *MockIKVStore)
+	}{
+		{
+			name:           "single ID",
+			sequence:       "orders",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"success":true,"data":{"name":"orders","id":42,"count":1}}`,
+			mocks: func(store *MockIKVStore) {
+				store.EXPECT().NextIDs("orders", 1).Return(uint64(42), nil)
+			},
+		},
+		{
+			name:           "block of IDs",
+			sequence:       "orders",
+			query:          "?count=5",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"success":true,"data":{"name":"orders","id":7,"count":5}}`,
+			mocks: func(store *MockIKVStore) {
+				store.EXPECT().NextIDs("orders", 5).Return(uint64(7), nil)
+			},
+		},
+		{
+			name:           "invalid count",
+			sequence:       "orders",
+			query:          "?count=0",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"success":false,"error":"count must be between 1 and 10000"}`,
+			mocks:          func(store *MockIKVStore) {},
+		},
+		{
+			name:           "store error",
+			sequence:       "orders",
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   `{"success":false,"error":"Failed to allocate IDs: store is not open"}`,
+			mocks: func(store *MockIKVStore) {
+				store.EXPECT().NextIDs("orders", 1).Return(uint64(0), errors.New("store is not open"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockStore := NewMockIKVStore(ctrl)
+			tt.mocks(mockStore)
+			server := NewServer(mockStore, &SystemService{}, ServerConfig{}, NopMetrics{})
+
+			req := httptest.NewRequest(http.MethodPost, "/sequence/"+tt.sequence+tt.query, nil)
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("name", tt.sequence)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+			w := httptest.NewRecorder()
+			server.handleNextSequence(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, tt.expectedBody, strings.TrimSpace(w.Body.String()))
+		})
+	}
+}
+
+func TestHandleCompactionEstimate(t *testing.T) {
+	tests := []struct {
+		name           string
+		expectedStatus int
+		expectedBody   string
+		mocks          func(store *MockIKVStore)
+	}{
+		{
+			name:           "estimate",
+			expectedStatus: http.StatusOK,
+			expectedBody: `{"success":true,"data":{"segments":[{"file_id":0,"path":"active.data","size_bytes":100,` +
+				`"live_bytes":40,"live_keys":2,"reclaimable_bytes":60,"dead_pct":60,"ready":true,` +
+				`"estimated_duration":1000}],"total_bytes":100,"live_bytes":40,"reclaimable_bytes":60,` +
+				`"estimated_duration":1000}}`,
+			mocks: func(kv *MockIKVStore) {
+				kv.EXPECT().EstimateCompaction().Return(&store.CompactionEstimate{
+					Segments: []store.SegmentCompactionEstimate{{
+						Path: "active.data", SizeBytes: 100, LiveBytes: 40, LiveKeys: 2, ReclaimableBytes: 60,
+						DeadPct: 60, Ready: true, EstimatedDuration: 1000,
+					}},
+					TotalBytes: 100, LiveBytes: 40, ReclaimableBytes: 60, EstimatedDuration: 1000,
+				}, nil)
+			},
+		},
+		{
+			name:           "store error",
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   `{"success":false,"error":"Failed to estimate compaction: store is not open"}`,
+			mocks: func(kv *MockIKVStore) {
+				kv.EXPECT().EstimateCompaction().Return(nil, errors.New("store is not open"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockStore := NewMockIKVStore(ctrl)
+			tt.mocks(mockStore)
+			server := NewServer(mockStore, &SystemService{}, ServerConfig{}, NopMetrics{})
+
+			w := httptest.NewRecorder()
+			server.handleCompactionEstimate(w, httptest.NewRequest(http.MethodGet, "/compaction/estimate", nil))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, tt.expectedBody, strings.TrimSpace(w.Body.String()))
+		})
+	}
+}
+
+func TestHandlePrefixStats(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStore := NewMockIKVStore(ctrl)
+	mockStore.EXPECT().PrefixStats([]byte("users:")).Return(store.PrefixStats{
+		Prefix: "users:", Keys: 2, Bytes: 80, ValueBytes: 30, AvgValueSize: 15,
+		LastWrite: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+	})
+	server := NewServer(mockStore, &SystemService{}, ServerConfig{}, NopMetrics{})
+
+	w := httptest.NewRecorder()
+	server.handlePrefixStats(w, httptest.NewRequest(http.MethodGet, "/stats/prefix?prefix=users:", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"success":true,"data":{"prefix":"users:","keys":2,"bytes":80,"value_bytes":30,`+
+		`"avg_value_size":15,"last_write":"2025-01-02T03:04:05Z"}}`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestHandleBulkCreateRelationships(t *testing.T) {
+	systemService, err := NewSystemServiceWithStore(SystemConfig{}, NewMemoryStore())
+	require.NoError(t, err)
+	kvStore := NewMemoryStore()
+	for _, key := range []string{"user:1", "user:2", "user:3"} {
+		require.NoError(t, kvStore.Put([]byte(key), []byte("{}")))
+	}
+	handler, err := NewHandler(kvStore, ServerConfig{SystemKey: "root-key", MaxBulkRelationships: 3}, Dependencies{
+		SystemService: systemService,
+		Metrics:       NopMetrics{},
+	})
+	require.NoError(t, err)
+
+	post := func(body string) (*httptest.ResponseRecorder, BulkRelationshipResponse) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/relationships/_bulk", strings.NewReader(body))
+		req.Header.Set("X-API-Key", "root-key")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		var resp struct {
+			Data BulkRelationshipResponse `json:"data"`
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp.Data
+	}
+
+	// One missing target rejects the batch and reports every edge
+	w, resp := post(`{"relationships":[{"from_key":"user:1","to_key":"user:2","relation":"follows"},` +
+		`{"from_key":"user:1","to_key":"user:9","relation":"follows"}]}`)
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code, w.Body.String())
+	require.Len(t, resp.Results, 2)
+	assert.Equal(t, "rejected", resp.Results[0].Status)
+	assert.Equal(t, "invalid", resp.Results[1].Status)
+	assert.Contains(t, resp.Results[1].Error, "user:9")
+	assert.Zero(t, resp.Created)
+	rels, err := kvStore.GetRelationships(store.RelationshipQuery{Key: "user:1", Direction: "both"})
+	require.NoError(t, err)
+	assert.Empty(t, rels)
+
+	w, resp = post(`{"relationships":[{"from_key":"user:1","to_key":"user:2","relation":"follows"},` +
+		`{"from_key":"user:1","to_key":"user:3","relation":"follows"}]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, 2, resp.Created)
+	assert.Equal(t, 1, resp.Results[1].Index)
+	rels, err = kvStore.GetRelationships(store.RelationshipQuery{Key: "user:1", Direction: "outgoing"})
+	require.NoError(t, err)
+	assert.Len(t, rels, 2)
+
+	// Empty and oversized batches are refused before touching the store
+	w, _ = post(`{"relationships":[]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	edge := `{"from_key":"user:1","to_key":"user:2","relation":"likes"}`
+	w, _ = post(`{"relationships":[` + strings.Repeat(edge+",", 3) + edge + `]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = post(`not json`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestNamespaceRoutes(t *testing.T) {
+	dir := t.TempDir()
+	stores, err := store.NewStoreManager(store.StoreManagerConfig{DataDir: dir})
+	require.NoError(t, err)
+	defer stores.Close()
+
+	// The system service comes from the manager's system store
+	handler, err := NewHandler(NewMemoryStore(), ServerConfig{SystemKey: "root-key", SystemDataDir: dir}, Dependencies{
+		Metrics: NopMetrics{},
+		Stores:  stores,
+	})
+	require.NoError(t, err)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-API-Key", "root-key")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPut, "/api/v1/ns/orders/kv/order:1", "shipped")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = do(http.MethodGet, "/api/v1/ns/orders/kv/order:1", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "shipped", w.Body.String())
+
+	// Namespaces are isolated from the default store
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/v1/kv/order:1", "").Code)
+
+	w = do(http.MethodGet, "/api/v1/ns/orders/kv?prefix=order:", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "order:1")
+
+	// Reads don't create namespaces; bad names are rejected
+	w = do(http.MethodGet, "/api/v1/ns/missing/kv/x", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "Namespace not found")
+	assert.NoDirExists(t, filepath.Join(dir, "ns", "missing"))
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/api/v1/ns/Bad/kv/x", "v").Code)
+
+	w = do(http.MethodGet, "/api/v1/stores", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp struct {
+		Data StoresResponse `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, []string{"orders"}, resp.Data.Namespaces)
+	var names []string
+	for _, s := range resp.Data.Stores {
+		names = append(names, s.Name)
+	}
+	assert.Equal(t, []string{"system", "orders"}, names)
+}
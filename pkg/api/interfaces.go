@@ -1,7 +1,12 @@
 // Package api provides interfaces for dependency injection
 package api
 
-import "github.com/ssargent/freyjadb/pkg/store"
+import (
+	"net/http"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/store"
+)
 
 // SystemInitializer defines the interface for system initialization operations
 type SystemInitializer interface {
@@ -16,6 +21,83 @@ type SystemInitializer interface {
 
 	// GetAPIKey retrieves an API key
 	GetAPIKey(keyID string) (*APIKey, error)
+
+	// StoreAPIKey stores or replaces an API key
+	StoreAPIKey(apiKey APIKey) error
+
+	// RecordUsageSnapshot stores a daily usage snapshot
+	RecordUsageSnapshot(snap UsageSnapshot) error
+
+	// UsageSnapshots returns the most recent daily usage snapshots, oldest first
+	UsageSnapshots(days int) ([]UsageSnapshot, error)
+}
+
+// SystemStore is the key-value storage behind a SystemService. A
+// *store.KVStore satisfies it, as does MemoryStore.
+type SystemStore interface {
+	Put(key, value []byte) error
+	Get(key []byte) ([]byte, error)
+	Delete(key []byte) error
+	ListKeys(prefix []byte) ([]string, error)
+	Close() error
+}
+
+// SystemManager is the system service as the server and its auth providers
+// use it. *SystemService is the implementation; tests and embedders may
+// supply their own.
+type SystemManager interface {
+	IsOpen() bool
+
+	// API keys
+	StoreAPIKey(apiKey APIKey) error
+	GetAPIKey(keyID string) (*APIKey, error)
+	ValidateAPIKey(apiKeyValue string) (bool, error)
+	ListAPIKeys() ([]string, error)
+	DeleteAPIKey(keyID string) error
+
+	// System configuration
+	StoreSystemConfig(key string, value interface{}) error
+	GetSystemConfig(key string, value interface{}) error
+
+	// Temporary tokens
+	IssueTemporaryToken(subject string, scopes []string, ttl time.Duration,
+		description string) (string, *TemporaryToken, error)
+	ValidateTemporaryToken(value string) (*TemporaryToken, error)
+	RevokeTemporaryToken(id string) error
+	PurgeExpiredTokens() (int, error)
+
+	// Usage reports
+	RecordUsageSnapshot(snap UsageSnapshot) error
+	UsageSnapshots(days int) ([]UsageSnapshot, error)
+
+	// Explain history
+	RecordExplainSnapshot(snap ExplainSnapshot) error
+	ExplainSnapshots(since time.Time) ([]ExplainSnapshot, error)
+
+	// Recovery history
+	RecordRecoveryEvent(event RecoveryEvent) error
+	RecoveryEvents(name string, limit int) ([]RecoveryEvent, error)
+
+	// Jobs
+	StoreJob(job Job) error
+	GetJob(id string) (*Job, error)
+	ListJobs() ([]Job, error)
+	DeleteJob(id string) error
+}
+
+// MetricsRecorder records server metrics. *Metrics reports them to
+// Prometheus; NopMetrics discards them.
+type MetricsRecorder interface {
+	RecordHTTPRequest(method, endpoint string, statusCode int, duration time.Duration)
+	RecordDBOperation(operation string, success bool, duration time.Duration)
+	UpdateDBStats(keys int, dataSize int64)
+	UpdateReadRepairs(repaired, failed int64)
+	UpdateKeyCategories(stats *store.StoreStats)
+	RecordAuthRequest(success bool)
+	RecordRelationshipOperation(operation string, success bool)
+	RecordHealthCheck(success bool)
+	InstrumentHandler(method, endpoint string, handler http.HandlerFunc) http.HandlerFunc
+	InstrumentAuthMiddleware(next func(http.Handler) http.Handler) func(http.Handler) http.Handler
 }
 
 // SystemServiceFactory creates system services
@@ -27,7 +109,7 @@ type SystemServiceFactory interface {
 // ServerStarter defines the interface for starting the API server
 type ServerStarter interface {
 	// StartServer starts the API server with the given configuration
-	StartServer(kvStore *store.KVStore,
+	StartServer(kvStore IKVStore,
 		port int,
 		apiKey, systemKey, dataDir, systemEncryptionKey string,
 		enableEncryption bool,
@@ -0,0 +1,569 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ssargent/freyjadb/pkg/keys"
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// Job defaults
+const (
+	jobKeys        = keys.Keyspace("job")
+	jobIDBytes     = 8
+	MaxRunningJobs = 4                  // Jobs one server runs at once
+	JobRetention   = 7 * 24 * time.Hour // Finished jobs older than this are pruned at startup
+)
+
+// Job states
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+)
+
+// Built-in job types
+const (
+	JobVerifyBackup    = "verify_backup"
+	JobArchiveSegments = "archive_segments"
+)
+
+var (
+	errUnknownJobType = errors.New("unknown job type")
+	errTooManyJobs    = errors.New("too many running jobs")
+	errJobFinished    = errors.New("job has already finished")
+	errJobsStopped    = errors.New("server is shutting down")
+)
+
+// Job is a long-running operation started through the API. Its record is
+// kept in the system store, so the outcome can be read after a restart; a
+// job the server was running when it stopped is marked failed.
+type Job struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	Status     string          `json:"status"`
+	Params     json.RawMessage `json:"params,omitempty"`
+	Done       int64           `json:"done"`  // Progress, in units that depend on the job type
+	Total      int64           `json:"total"` // Units of work in all, 0 while unknown
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Subject    string          `json:"subject,omitempty"`    // Principal that started the job
+	RequestID  string          `json:"request_id,omitempty"` // Request that started the job
+	CreatedAt  time.Time       `json:"created_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+}
+
+// JobRequest is the body of a job start request
+type JobRequest struct {
+	Type   string          `json:"type"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// JobFunc runs one job of a type against the server's store. It reports
+// progress as it goes, should return once ctx is done, and returns a result
+// that is stored as JSON.
+type JobFunc func(ctx context.Context, kv IKVStore, params json.RawMessage, progress JobProgressFunc) (any, error)
+
+// JobProgressFunc reports that done of total units of a job's work are complete
+type JobProgressFunc func(done, total int64)
+
+// VerifyBackupJobParams are the params of a verify_backup job
+type VerifyBackupJobParams struct {
+	Dir         string  `json:"dir"`                    // Backup directory on the server
+	SamplePct   float64 `json:"sample_pct,omitempty"`   // Share of keys checked (store.DefaultVerifySamplePct if zero)
+	CompareLive bool    `json:"compare_live,omitempty"` // Also compare sampled keys with the live store
+}
+
+// defaultJobTypes returns the job types every server runs
+func defaultJobTypes() map[string]JobFunc {
+	return map[string]JobFunc{
+		JobVerifyBackup:    verifyBackupJob,
+		JobArchiveSegments: archiveSegmentsJob,
+	}
+}
+
+// verifyBackupJob verifies a backup directory; progress counts segments
+func verifyBackupJob(ctx context.Context, kv IKVStore, params json.RawMessage,
+	progress JobProgressFunc) (any, error) {
+	var p VerifyBackupJobParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, fmt.Errorf("invalid params: %w", err)
+	}
+	if p.Dir == "" {
+		return nil, fmt.Errorf("dir is required")
+	}
+
+	opts := store.VerifyOptions{
+		SamplePct: p.SamplePct,
+		OnProgress: func(done, segments int) {
+			progress(int64(done), int64(segments))
+		},
+	}
+	if p.CompareLive {
+		live, ok := kv.(*store.KVStore)
+		if !ok {
+			return nil, fmt.Errorf("the store can't be compared with a backup")
+		}
+		opts.Live = live
+	}
+	return store.VerifyBackupContext(ctx, p.Dir, opts)
+}
+
+// archiveSegmentsJob moves cold segments to the archive tier. It can't be
+// canceled once started.
+func archiveSegmentsJob(ctx context.Context, kv IKVStore, params json.RawMessage,
+	progress JobProgressFunc) (any, error) {
+	archiver, ok := kv.(interface{ ArchiveColdSegments() ([]uint32, error) })
+	if !ok {
+		return nil, fmt.Errorf("the store has no archive tier")
+	}
+
+	progress(0, 1)
+	archived, err := archiver.ArchiveColdSegments()
+	if err != nil {
+		return nil, err
+	}
+	progress(1, 1)
+	return map[string]interface{}{"archived": archived}, nil
+}
+
+// jobRunner starts jobs in the background and tracks the ones running
+type jobRunner struct {
+	kv     IKVStore
+	system SystemManager
+	logger *slog.Logger
+	types  map[string]JobFunc
+
+	mutex   sync.Mutex
+	running map[string]*runningJob
+	stopped bool           // Set by stop; no more jobs start
+	wg      sync.WaitGroup // Jobs that have not yet recorded how they ended
+}
+
+// runningJob is a job in progress and the way to cancel it
+type runningJob struct {
+	job    Job
+	cancel context.CancelFunc
+}
+
+// newJobRunner creates a runner for the built-in job types plus extra,
+// which may also replace built-in ones
+func newJobRunner(kv IKVStore, system SystemManager, logger *slog.Logger, extra map[string]JobFunc) *jobRunner {
+	types := defaultJobTypes()
+	for name, fn := range extra {
+		types[name] = fn
+	}
+	return &jobRunner{
+		kv:      kv,
+		system:  system,
+		logger:  logger,
+		types:   types,
+		running: make(map[string]*runningJob),
+	}
+}
+
+// typeNames lists the job types the runner accepts
+func (jr *jobRunner) typeNames() []string {
+	names := make([]string, 0, len(jr.types))
+	for name := range jr.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// start records a new job and runs it in the background
+func (jr *jobRunner) start(req JobRequest, subject, requestID string) (*Job, error) {
+	fn, ok := jr.types[req.Type]
+	if !ok {
+		return nil, errUnknownJobType
+	}
+
+	idBytes := make([]byte, jobIDBytes)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate job ID: %w", err)
+	}
+	params := req.Params
+	if len(params) == 0 {
+		params = json.RawMessage("{}")
+	}
+	job := Job{
+		ID:        hex.EncodeToString(idBytes),
+		Type:      req.Type,
+		Status:    JobRunning,
+		Params:    params,
+		Subject:   subject,
+		RequestID: requestID,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	jr.mutex.Lock()
+	defer jr.mutex.Unlock()
+
+	if jr.stopped {
+		return nil, errJobsStopped
+	}
+	if len(jr.running) >= MaxRunningJobs {
+		return nil, errTooManyJobs
+	}
+	if err := jr.system.StoreJob(job); err != nil {
+		return nil, fmt.Errorf("failed to store job: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(store.WithRequestID(context.Background(), requestID))
+	rj := &runningJob{job: job, cancel: cancel}
+	jr.running[job.ID] = rj
+	jr.wg.Add(1)
+	go jr.run(ctx, rj, fn)
+	return &job, nil
+}
+
+// run executes a job and records how it ended
+func (jr *jobRunner) run(ctx context.Context, rj *runningJob, fn JobFunc) {
+	defer jr.wg.Done()
+	defer rj.cancel()
+
+	result, err := func() (result any, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("job panicked: %v", r)
+			}
+		}()
+		return fn(ctx, jr.kv, rj.job.Params, func(done, total int64) {
+			jr.mutex.Lock()
+			rj.job.Done, rj.job.Total = done, total
+			jr.mutex.Unlock()
+		})
+	}()
+
+	var data []byte
+	if err == nil && result != nil {
+		data, err = json.Marshal(result)
+	}
+
+	jr.mutex.Lock()
+	job := rj.job
+	jr.mutex.Unlock()
+
+	finished := time.Now().UTC()
+	job.FinishedAt = &finished
+	switch {
+	case ctx.Err() != nil:
+		job.Status = JobCanceled
+	case err != nil:
+		job.Status = JobFailed
+		job.Error = err.Error()
+	default:
+		job.Status = JobSucceeded
+		job.Result = data
+	}
+
+	// The record is stored before the job leaves the running set, so readers
+	// never fall back to the stale running record
+	if err := jr.system.StoreJob(job); err != nil {
+		jr.logger.Warn("failed to store job", "id", job.ID, "type", job.Type, "error", err)
+	}
+
+	jr.mutex.Lock()
+	delete(jr.running, job.ID)
+	jr.mutex.Unlock()
+
+	jr.logger.Info("job finished", "id", job.ID, "type", job.Type, "status", job.Status,
+		"request_id", job.RequestID, "error", job.Error)
+}
+
+// get returns a job, running or finished
+func (jr *jobRunner) get(id string) (*Job, error) {
+	jr.mutex.Lock()
+	if rj, ok := jr.running[id]; ok {
+		job := rj.job
+		jr.mutex.Unlock()
+		return &job, nil
+	}
+	jr.mutex.Unlock()
+
+	return jr.system.GetJob(id)
+}
+
+// list returns every job, oldest first
+func (jr *jobRunner) list() ([]Job, error) {
+	jobs, err := jr.system.ListJobs()
+	if err != nil {
+		return nil, err
+	}
+
+	jr.mutex.Lock()
+	for i := range jobs {
+		if rj, ok := jr.running[jobs[i].ID]; ok {
+			jobs[i] = rj.job
+		}
+	}
+	jr.mutex.Unlock()
+	return jobs, nil
+}
+
+// cancel asks a running job to stop. It stops at its next cancellation
+// point and is then recorded as canceled.
+func (jr *jobRunner) cancel(id string) (*Job, error) {
+	jr.mutex.Lock()
+	if rj, ok := jr.running[id]; ok {
+		rj.cancel()
+		job := rj.job
+		jr.mutex.Unlock()
+		return &job, nil
+	}
+	jr.mutex.Unlock()
+
+	if _, err := jr.system.GetJob(id); err != nil {
+		return nil, err
+	}
+	return nil, errJobFinished
+}
+
+// stop cancels every running job and waits until each has been recorded as
+// canceled, or as finished if it completed first. No jobs start afterwards.
+func (jr *jobRunner) stop() {
+	jr.mutex.Lock()
+	jr.stopped = true
+	for _, rj := range jr.running {
+		rj.cancel()
+	}
+	jr.mutex.Unlock()
+
+	jr.wg.Wait()
+}
+
+// recoverJobs marks jobs left running by a previous process as failed and
+// prunes finished jobs older than JobRetention
+func (jr *jobRunner) recoverJobs(now time.Time) error {
+	jobs, err := jr.system.ListJobs()
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		switch {
+		case job.Status == JobRunning:
+			finished := now.UTC()
+			job.Status = JobFailed
+			job.Error = "interrupted by a server restart"
+			job.FinishedAt = &finished
+			if err := jr.system.StoreJob(job); err != nil {
+				return err
+			}
+		case job.FinishedAt != nil && now.Sub(*job.FinishedAt) > JobRetention:
+			if err := jr.system.DeleteJob(job.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// StoreJob saves a job record in the system store
+func (s *SystemService) StoreJob(job Job) error {
+	if !s.isOpen {
+		return fmt.Errorf("system service is not open")
+	}
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job: %w", err)
+	}
+	encryptedData, err := s.encrypt(data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt job: %w", err)
+	}
+	return s.store.Put(jobKeys.Bytes(job.ID), encryptedData)
+}
+
+// GetJob loads a job record by ID
+func (s *SystemService) GetJob(id string) (*Job, error) {
+	if !s.isOpen {
+		return nil, fmt.Errorf("system service is not open")
+	}
+
+	encryptedData, err := s.store.Get(jobKeys.Bytes(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+	data, err := s.decrypt(encryptedData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt job: %w", err)
+	}
+
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal job: %w", err)
+	}
+	return &job, nil
+}
+
+// ListJobs returns every stored job record, oldest first
+func (s *SystemService) ListJobs() ([]Job, error) {
+	if !s.isOpen {
+		return nil, fmt.Errorf("system service is not open")
+	}
+
+	stored, err := s.store.ListKeys([]byte(jobKeys.Prefix()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+
+	jobs := make([]Job, 0, len(stored))
+	for _, key := range stored {
+		id, _ := jobKeys.Trim(key)
+		job, err := s.GetJob(id)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.Before(jobs[j].CreatedAt) })
+	return jobs, nil
+}
+
+// DeleteJob removes a job record
+func (s *SystemService) DeleteJob(id string) error {
+	if !s.isOpen {
+		return fmt.Errorf("system service is not open")
+	}
+
+	return s.store.Delete(jobKeys.Bytes(id))
+}
+
+// handleStartJob godoc
+//
+//	@Summary		Start a job
+//	@Description	Start a long-running operation in the background and return its job record
+//	@Tags			jobs
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		JobRequest	true	"Job type and params"
+//	@Success		200		{object}	map[string]interface{}
+//	@Failure		400		{object}	map[string]string
+//	@Failure		429		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/jobs [post]
+//	@Security		ApiKeyAuth
+func (s *Server) handleStartJob(w http.ResponseWriter, r *http.Request) {
+	var req JobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, "Invalid JSON request", http.StatusBadRequest)
+		return
+	}
+
+	subject := ""
+	if principal, ok := PrincipalFromContext(r.Context()); ok {
+		subject = principal.Subject
+	}
+
+	job, err := s.jobs.start(req, subject, RequestIDFromContext(r.Context()))
+	switch {
+	case errors.Is(err, errUnknownJobType):
+		sendError(w, fmt.Sprintf("Unknown job type %q (supported: %s)", req.Type,
+			strings.Join(s.jobs.typeNames(), ", ")), http.StatusBadRequest)
+		return
+	case errors.Is(err, errTooManyJobs):
+		sendError(w, fmt.Sprintf("At most %d jobs can run at once", MaxRunningJobs), http.StatusTooManyRequests)
+		return
+	case errors.Is(err, errJobsStopped):
+		sendError(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		sendError(w, fmt.Sprintf("Failed to start job: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, "job.start", slog.String("id", job.ID), slog.String("type", job.Type))
+	sendSuccess(w, job)
+}
+
+// handleListJobs godoc
+//
+//	@Summary		List jobs
+//	@Description	List running and finished jobs, oldest first
+//	@Tags			jobs
+//	@Produce		json
+//	@Success		200	{object}	map[string]interface{}
+//	@Failure		500	{object}	map[string]string
+//	@Router			/jobs [get]
+//	@Security		ApiKeyAuth
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	jobs, err := s.jobs.list()
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to list jobs: %v", err), http.StatusInternalServerError)
+		return
+	}
+	sendSuccess(w, map[string]interface{}{"jobs": jobs})
+}
+
+// handleGetJob godoc
+//
+//	@Summary		Get a job
+//	@Description	Get a job's status, progress and, once finished, its result or error
+//	@Tags			jobs
+//	@Produce		json
+//	@Param			id	path		string	true	"Job ID"
+//	@Success		200	{object}	map[string]interface{}
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Router			/jobs/{id} [get]
+//	@Security		ApiKeyAuth
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := s.jobs.get(chi.URLParam(r, "id"))
+	switch {
+	case errors.Is(err, store.ErrKeyNotFound):
+		sendError(w, "Job not found", http.StatusNotFound)
+		return
+	case err != nil:
+		sendError(w, fmt.Sprintf("Failed to get job: %v", err), http.StatusInternalServerError)
+		return
+	}
+	sendSuccess(w, job)
+}
+
+// handleCancelJob godoc
+//
+//	@Summary		Cancel a job
+//	@Description	Ask a running job to stop. It is recorded as canceled once it reaches its next cancellation point.
+//	@Tags			jobs
+//	@Produce		json
+//	@Param			id	path		string	true	"Job ID"
+//	@Success		200	{object}	map[string]interface{}
+//	@Failure		404	{object}	map[string]string
+//	@Failure		409	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Router			/jobs/{id} [delete]
+//	@Security		ApiKeyAuth
+func (s *Server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	job, err := s.jobs.cancel(id)
+	switch {
+	case errors.Is(err, store.ErrKeyNotFound):
+		sendError(w, "Job not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errJobFinished):
+		sendError(w, "Job has already finished", http.StatusConflict)
+		return
+	case err != nil:
+		sendError(w, fmt.Sprintf("Failed to cancel job: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, "job.cancel", slog.String("id", id), slog.String("type", job.Type))
+	sendSuccess(w, job)
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobsAPI(t *testing.T) {
+	systemService, err := NewSystemServiceWithStore(SystemConfig{}, NewMemoryStore())
+	require.NoError(t, err)
+
+	handler, err := NewHandler(NewMemoryStore(), ServerConfig{SystemKey: "root-key"}, Dependencies{
+		SystemService: systemService,
+		Metrics:       NopMetrics{},
+		JobTypes: map[string]JobFunc{
+			"echo": func(ctx context.Context, kv IKVStore, params json.RawMessage, progress JobProgressFunc) (any, error) {
+				return params, nil
+			},
+			"wait": func(ctx context.Context, kv IKVStore, params json.RawMessage, progress JobProgressFunc) (any, error) {
+				progress(1, 2)
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		},
+	})
+	require.NoError(t, err)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	do := func(method, path, body string) (int, Job) {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		req.Header.Set("X-API-Key", "root-key")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var response struct {
+			Data Job `json:"data"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+		return resp.StatusCode, response.Data
+	}
+	waitFor := func(id, status string) Job {
+		t.Helper()
+		var job Job
+		require.Eventually(t, func() bool {
+			_, job = do(http.MethodGet, "/api/v1/jobs/"+id, "")
+			return job.Status == status
+		}, 5*time.Second, 10*time.Millisecond)
+		return job
+	}
+
+	code, echo := do(http.MethodPost, "/api/v1/jobs", `{"type":"echo","params":{"n":1}}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, JobRunning, echo.Status)
+	echo = waitFor(echo.ID, JobSucceeded)
+	assert.JSONEq(t, `{"n":1}`, string(echo.Result))
+	assert.Equal(t, "system-root", echo.Subject)
+	assert.NotNil(t, echo.FinishedAt)
+
+	// A running job reports progress and can be canceled once
+	code, wait := do(http.MethodPost, "/api/v1/jobs", `{"type":"wait"}`)
+	require.Equal(t, http.StatusOK, code)
+	require.Eventually(t, func() bool {
+		_, wait = do(http.MethodGet, "/api/v1/jobs/"+wait.ID, "")
+		return wait.Done == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(2), wait.Total)
+	code, _ = do(http.MethodDelete, "/api/v1/jobs/"+wait.ID, "")
+	assert.Equal(t, http.StatusOK, code)
+	waitFor(wait.ID, JobCanceled)
+	code, _ = do(http.MethodDelete, "/api/v1/jobs/"+wait.ID, "")
+	assert.Equal(t, http.StatusConflict, code)
+
+	// Built-in jobs fail with their error
+	code, verify := do(http.MethodPost, "/api/v1/jobs", `{"type":"verify_backup","params":{"dir":"/nonexistent"}}`)
+	require.Equal(t, http.StatusOK, code)
+	verify = waitFor(verify.ID, JobFailed)
+	assert.Contains(t, verify.Error, "backup manifest")
+
+	code, _ = do(http.MethodPost, "/api/v1/jobs", `{"type":"compact"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = do(http.MethodGet, "/api/v1/jobs/missing", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = do(http.MethodDelete, "/api/v1/jobs/missing", "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	jobs, err := systemService.ListJobs()
+	require.NoError(t, err)
+	require.Len(t, jobs, 3)
+	assert.Equal(t, echo.ID, jobs[0].ID)
+}
+
+func TestJobRunner_RecoverJobs(t *testing.T) {
+	systemService, err := NewSystemServiceWithStore(SystemConfig{}, NewMemoryStore())
+	require.NoError(t, err)
+
+	now := time.Now().UTC()
+	old := now.Add(-JobRetention - time.Hour)
+	recent := now.Add(-time.Hour)
+	require.NoError(t, systemService.StoreJob(Job{ID: "interrupted", Type: "echo", Status: JobRunning, CreatedAt: old}))
+	require.NoError(t, systemService.StoreJob(Job{ID: "old", Type: "echo", Status: JobSucceeded, CreatedAt: old,
+		FinishedAt: &old}))
+	require.NoError(t, systemService.StoreJob(Job{ID: "recent", Type: "echo", Status: JobFailed, CreatedAt: recent,
+		FinishedAt: &recent}))
+
+	runner := newJobRunner(NewMemoryStore(), systemService, nil, nil)
+	require.NoError(t, runner.recoverJobs(now))
+
+	jobs, err := systemService.ListJobs()
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	assert.Equal(t, "interrupted", jobs[0].ID)
+	assert.Equal(t, JobFailed, jobs[0].Status)
+	assert.Contains(t, jobs[0].Error, "restart")
+	assert.Equal(t, "recent", jobs[1].ID)
+}
@@ -0,0 +1,418 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for JWTConfig
+const (
+	defaultScopeClaim       = "scope"
+	defaultJWKSMinRefresh   = time.Minute
+	defaultJWKSFetchTimeout = 10 * time.Second
+)
+
+// JWTConfig configures bearer token authentication
+type JWTConfig struct {
+	Issuer   string // Expected "iss"; also used for OIDC discovery when JWKSURL is empty
+	Audience string // Expected "aud" (skipped when empty)
+	JWKSURL  string // JSON Web Key Set endpoint for RS256/ES256 tokens
+
+	HMACSecret []byte // Shared secret for HS256 tokens (optional)
+
+	ScopeClaim   string              // Claim holding scopes/groups (default "scope")
+	ScopeMapping map[string][]string // Claim value -> FreyjaDB scopes; unmapped values are used as-is when empty
+
+	Leeway         time.Duration // Allowed clock skew for exp/nbf
+	JWKSMinRefresh time.Duration // Minimum time between JWKS refetches on unknown key IDs
+	HTTPClient     *http.Client  // Client for discovery and JWKS requests
+}
+
+// JWTProvider authenticates "Authorization: Bearer" JWTs
+type JWTProvider struct {
+	config    JWTConfig
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	mutex     sync.Mutex
+}
+
+// NewJWTProvider validates config and creates a JWT provider. Keys are
+// fetched lazily on the first request.
+func NewJWTProvider(config JWTConfig) (*JWTProvider, error) {
+	if config.JWKSURL == "" && config.Issuer == "" && len(config.HMACSecret) == 0 {
+		return nil, errors.New("jwt auth requires an issuer, a JWKS URL or an HMAC secret")
+	}
+	if config.ScopeClaim == "" {
+		config.ScopeClaim = defaultScopeClaim
+	}
+	if config.JWKSMinRefresh <= 0 {
+		config.JWKSMinRefresh = defaultJWKSMinRefresh
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: defaultJWKSFetchTimeout}
+	}
+
+	return &JWTProvider{config: config}, nil
+}
+
+// CredentialHint implements AuthProvider
+func (p *JWTProvider) CredentialHint() string {
+	return "Authorization bearer token"
+}
+
+// Authenticate implements AuthProvider
+func (p *JWTProvider) Authenticate(r *http.Request) (*Principal, error) {
+	header := r.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || token == "" {
+		return nil, errNoCredentials
+	}
+
+	claims, err := p.verify(r.Context(), token)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, _ := claims["sub"].(string)
+	return &Principal{
+		Subject: subject,
+		Method:  AuthModeJWT,
+		Scopes:  p.mapScopes(claims[p.config.ScopeClaim]),
+	}, nil
+}
+
+// jwtHeader is the decoded JOSE header
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// verify checks the token signature and registered claims and returns all claims
+func (p *JWTProvider) verify(ctx context.Context, token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, &AuthError{"Malformed token"}
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, &AuthError{"Malformed token header"}
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, &AuthError{"Malformed token signature"}
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	if err := p.verifySignature(ctx, header, signed, signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, &AuthError{"Malformed token claims"}
+	}
+
+	if err := p.validateClaims(claims, time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// verifySignature checks signature over signed using the algorithm in header
+func (p *JWTProvider) verifySignature(ctx context.Context, header jwtHeader, signed, signature []byte) error {
+	digest := sha256.Sum256(signed)
+
+	switch header.Alg {
+	case "HS256":
+		if len(p.config.HMACSecret) == 0 {
+			return &AuthError{"HS256 tokens are not accepted"}
+		}
+		mac := hmac.New(sha256.New, p.config.HMACSecret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return &AuthError{"Invalid token signature"}
+		}
+		return nil
+
+	case "RS256":
+		key, err := p.publicKey(ctx, header.Kid)
+		if err != nil {
+			return err
+		}
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return &AuthError{"Token key does not match algorithm"}
+		}
+		if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature); err != nil {
+			return &AuthError{"Invalid token signature"}
+		}
+		return nil
+
+	case "ES256":
+		key, err := p.publicKey(ctx, header.Kid)
+		if err != nil {
+			return err
+		}
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return &AuthError{"Token key does not match algorithm"}
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, s) {
+			return &AuthError{"Invalid token signature"}
+		}
+		return nil
+
+	default:
+		return &AuthError{fmt.Sprintf("Unsupported token algorithm %q", header.Alg)}
+	}
+}
+
+// validateClaims checks iss, aud, exp and nbf
+func (p *JWTProvider) validateClaims(claims map[string]interface{}, now time.Time) error {
+	if p.config.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != p.config.Issuer {
+			return &AuthError{"Invalid token issuer"}
+		}
+	}
+
+	if p.config.Audience != "" && !claimContains(claims["aud"], p.config.Audience) {
+		return &AuthError{"Invalid token audience"}
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return &AuthError{"Token has no expiry"}
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(p.config.Leeway)) {
+		return &AuthError{"Token has expired"}
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(p.config.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return &AuthError{"Token is not valid yet"}
+	}
+
+	return nil
+}
+
+// mapScopes turns a scope claim (space-separated string or array) into scopes
+func (p *JWTProvider) mapScopes(claim interface{}) []string {
+	var values []string
+	switch v := claim.(type) {
+	case string:
+		values = strings.Fields(v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	var scopes []string
+	seen := make(map[string]bool)
+	add := func(scope string) {
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+
+	for _, value := range values {
+		if len(p.config.ScopeMapping) == 0 {
+			add(value)
+			continue
+		}
+		for _, scope := range p.config.ScopeMapping[value] {
+			add(scope)
+		}
+	}
+	return scopes
+}
+
+// publicKey returns the key for kid, refetching the JWKS when kid is unknown
+func (p *JWTProvider) publicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if key, ok := p.lookupKeyInternal(kid); ok {
+		return key, nil
+	}
+
+	if !p.fetchedAt.IsZero() && time.Since(p.fetchedAt) < p.config.JWKSMinRefresh {
+		return nil, &AuthError{"Unknown token signing key"}
+	}
+
+	keys, err := p.fetchJWKS(ctx)
+	p.fetchedAt = time.Now()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+	p.keys = keys
+
+	if key, ok := p.lookupKeyInternal(kid); ok {
+		return key, nil
+	}
+	return nil, &AuthError{"Unknown token signing key"}
+}
+
+// lookupKeyInternal finds a cached key; an empty kid matches a lone key
+func (p *JWTProvider) lookupKeyInternal(kid string) (crypto.PublicKey, bool) {
+	if key, ok := p.keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// fetchJWKS downloads and parses the key set, discovering its URL via OIDC
+// metadata when only an issuer is configured
+func (p *JWTProvider) fetchJWKS(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	jwksURL := p.config.JWKSURL
+	if jwksURL == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		wellKnown := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+		if err := p.getJSON(ctx, wellKnown, &discovery); err != nil {
+			return nil, fmt.Errorf("oidc discovery failed: %w", err)
+		}
+		if discovery.JWKSURI == "" {
+			return nil, errors.New("oidc discovery document has no jwks_uri")
+		}
+		jwksURL = discovery.JWKSURI
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, jwksURL, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue // Skip keys we can't use (other key types or curves)
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+// getJSON performs a GET request and decodes the JSON response into out
+func (p *JWTProvider) getJSON(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// jsonWebKey is a single entry of a JWKS document
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey converts an RSA or P-256 JWK into a Go public key
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("rsa exponent too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("ec point is not on curve")
+		}
+		return key, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// claimContains reports whether an aud-style claim (string or array) holds want
+func claimContains(claim interface{}, want string) bool {
+	switch v := claim.(type) {
+	case string:
+		return v == want
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decodeSegment base64url-decodes a JWT segment and unmarshals its JSON
+func decodeSegment(segment string, out interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
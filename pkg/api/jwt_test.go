@@ -0,0 +1,249 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signJWT builds a compact JWT signed with key using alg
+func signJWT(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		require.NoError(t, err)
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// newOIDCServer serves discovery metadata and a JWKS with one RSA and one EC key
+func newOIDCServer(t *testing.T, rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey, fetches *int32) *httptest.Server {
+	t.Helper()
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"jwks_uri": srv.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(fetches, 1)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{"kty": "RSA", "kid": "rsa-1", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+				{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+			},
+		})
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestJWTProvider_Authenticate(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	var fetches int32
+	srv := newOIDCServer(t, rsaKey, ecKey, &fetches)
+
+	provider, err := NewJWTProvider(JWTConfig{
+		Issuer:       srv.URL,
+		Audience:     "freyja",
+		HMACSecret:   []byte("shared-secret"),
+		ScopeClaim:   "groups",
+		ScopeMapping: map[string][]string{"db-readers": {ScopeRead}, "db-admins": {ScopeRead, ScopeWrite, ScopeAdmin}},
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":    srv.URL,
+			"aud":    []string{"other", "freyja"},
+			"sub":    "ci-bot",
+			"exp":    now.Add(time.Hour).Unix(),
+			"groups": []string{"db-readers", "unmapped"},
+		}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+		scopes  []string
+	}{
+		{name: "rs256", token: signJWT(t, "RS256", "rsa-1", rsaKey, claims(nil)), scopes: []string{ScopeRead}},
+		{name: "es256", token: signJWT(t, "ES256", "ec-1", ecKey, claims(nil)), scopes: []string{ScopeRead}},
+		{
+			name: "hs256 with admin group",
+			token: signJWT(t, "HS256", "", []byte("shared-secret"),
+				claims(map[string]interface{}{"groups": []string{"db-admins"}})),
+			scopes: []string{ScopeRead, ScopeWrite, ScopeAdmin},
+		},
+		{
+			name:    "wrong signer",
+			token:   signJWT(t, "RS256", "rsa-1", otherKey, claims(nil)),
+			wantErr: "Invalid token signature",
+		},
+		{
+			name:    "unknown kid",
+			token:   signJWT(t, "RS256", "rsa-9", rsaKey, claims(nil)),
+			wantErr: "Unknown token signing key",
+		},
+		{
+			name:    "wrong issuer",
+			token:   signJWT(t, "RS256", "rsa-1", rsaKey, claims(map[string]interface{}{"iss": "evil"})),
+			wantErr: "Invalid token issuer",
+		},
+		{
+			name:    "wrong audience",
+			token:   signJWT(t, "RS256", "rsa-1", rsaKey, claims(map[string]interface{}{"aud": "other"})),
+			wantErr: "Invalid token audience",
+		},
+		{
+			name:    "expired",
+			token:   signJWT(t, "RS256", "rsa-1", rsaKey, claims(map[string]interface{}{"exp": now.Add(-time.Minute).Unix()})),
+			wantErr: "Token has expired",
+		},
+		{name: "alg none", token: signJWT(t, "none", "", nil, claims(nil)), wantErr: "Unsupported token algorithm"},
+		{name: "malformed", token: "not-a-jwt", wantErr: "Malformed token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+
+			principal, err := provider.Authenticate(req)
+			if tt.wantErr != "" {
+				var authErr *AuthError
+				require.ErrorAs(t, err, &authErr)
+				assert.Contains(t, authErr.Message, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "ci-bot", principal.Subject)
+			assert.Equal(t, tt.scopes, principal.Scopes)
+		})
+	}
+
+	// Unknown key IDs don't hammer the JWKS endpoint
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+	// Requests without a bearer token are left to other providers
+	_, err = provider.Authenticate(httptest.NewRequest("GET", "/", nil))
+	assert.ErrorIs(t, err, errNoCredentials)
+}
+
+func TestAuthMiddleware_Scopes(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	jwtProvider, err := NewJWTProvider(JWTConfig{HMACSecret: []byte("s")})
+	require.NoError(t, err)
+	chain := authMiddleware(&StaticKeyProvider{Key: "client"}, jwtProvider)
+
+	readOnly := signJWT(t, "HS256", "", []byte("s"), map[string]interface{}{
+		"exp": time.Now().Add(time.Hour).Unix(), "scope": "read",
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		admin  bool
+		header [2]string
+		want   int
+	}{
+		{"no credentials", "GET", false, [2]string{}, http.StatusUnauthorized},
+		{"client key reads", "GET", false, [2]string{"X-API-Key", "client"}, http.StatusOK},
+		{"client key writes", "PUT", false, [2]string{"X-API-Key", "client"}, http.StatusOK},
+		{"client key is not admin", "GET", true, [2]string{"X-API-Key", "client"}, http.StatusForbidden},
+		{"bad key", "GET", false, [2]string{"X-API-Key", "nope"}, http.StatusUnauthorized},
+		{"read-only token reads", "GET", false, [2]string{"Authorization", "Bearer " + readOnly}, http.StatusOK},
+		{"read-only token cannot write", "DELETE", false, [2]string{"Authorization", "Bearer " + readOnly},
+			http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var handler http.Handler = requireMethodScope(ok)
+			if tt.admin {
+				handler = requireScope(ScopeAdmin)(ok)
+			}
+
+			req := httptest.NewRequest(tt.method, "/", nil)
+			if tt.header[0] != "" {
+				req.Header.Set(tt.header[0], tt.header[1])
+			}
+			w := httptest.NewRecorder()
+			chain(handler).ServeHTTP(w, req)
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
+
+func TestBuildAuthProviders(t *testing.T) {
+	providers, err := buildAuthProviders(ServerConfig{APIKey: "k"}, nil)
+	require.NoError(t, err)
+	assert.Len(t, providers, 1)
+
+	_, err = buildAuthProviders(ServerConfig{Auth: AuthConfig{Mode: AuthModeJWT}}, nil)
+	assert.Error(t, err)
+
+	providers, err = buildAuthProviders(ServerConfig{
+		APIKey: "k",
+		Auth:   AuthConfig{Mode: AuthModeBoth, JWT: &JWTConfig{HMACSecret: []byte("s")}},
+	}, nil)
+	require.NoError(t, err)
+	assert.Len(t, providers, 2)
+
+	_, err = buildAuthProviders(ServerConfig{Auth: AuthConfig{Mode: "magic"}}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Key encodings selected with the X-Key-Encoding header
+const (
+	KeyEncodingHeader = "X-Key-Encoding"
+	KeyEncodingBase64 = "base64" // Keys, prefixes and returned keys are base64
+)
+
+// keyCodec decodes the keys and prefixes of a KV request and encodes the
+// keys in its response. Binary keys can't travel in URL paths or JSON
+// strings intact, so clients send X-Key-Encoding: base64 to exchange them
+// as base64 instead.
+type keyCodec struct {
+	base64 bool
+}
+
+// requestKeyCodec returns the key codec a request asked for
+func requestKeyCodec(r *http.Request) (keyCodec, error) {
+	switch encoding := strings.ToLower(r.Header.Get(KeyEncodingHeader)); encoding {
+	case "":
+		return keyCodec{}, nil
+	case KeyEncodingBase64:
+		return keyCodec{base64: true}, nil
+	default:
+		return keyCodec{}, fmt.Errorf("unsupported key encoding %q", encoding)
+	}
+}
+
+// decode returns the raw bytes of a key or prefix as sent by the client.
+// Base64 keys may use either alphabet, with or without padding, though only
+// the URL-safe alphabet survives in a path unescaped.
+func (c keyCodec) decode(s string) ([]byte, error) {
+	if !c.base64 {
+		return []byte(s), nil
+	}
+	for _, enc := range []*base64.Encoding{
+		base64.RawURLEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.StdEncoding,
+	} {
+		if key, err := enc.DecodeString(s); err == nil {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid base64 key %q", s)
+}
+
+// encode formats a stored key for a response, using unpadded URL-safe
+// base64 so it can be pasted straight into a path
+func (c keyCodec) encode(key string) string {
+	if !c.base64 {
+		return key
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// pathKey returns the key in the request path. chi matches escaped paths
+// as sent, so escaped keys such as user%2F123 are unescaped here.
+func (c keyCodec) pathKey(r *http.Request) ([]byte, error) {
+	key := chi.URLParam(r, "key")
+	if r.URL.RawPath != "" {
+		unescaped, err := url.PathUnescape(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key escaping: %w", err)
+		}
+		key = unescaped
+	}
+	return c.decode(key)
+}
+
+// prefix returns the request's prefix query parameter
+func (c keyCodec) prefix(r *http.Request) ([]byte, error) {
+	return c.decode(r.URL.Query().Get("prefix"))
+}
+
+// requestKey returns the store key addressed by a /kv/{key} request
+func (s *Server) requestKey(r *http.Request) ([]byte, error) {
+	codec, err := requestKeyCodec(r)
+	if err != nil {
+		return nil, err
+	}
+	return codec.pathKey(r)
+}
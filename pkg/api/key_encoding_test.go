@@ -0,0 +1,102 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyEncoding(t *testing.T) {
+	systemService, err := NewSystemServiceWithStore(SystemConfig{}, NewMemoryStore())
+	require.NoError(t, err)
+	kvStore := NewMemoryStore()
+	handler, err := NewHandler(kvStore, ServerConfig{SystemKey: "root-key"}, Dependencies{
+		SystemService: systemService,
+		Metrics:       NopMetrics{},
+	})
+	require.NoError(t, err)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	do := func(method, path, encoding string, body []byte) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("X-API-Key", "root-key")
+		req.Header.Set("Content-Type", "text/plain")
+		if encoding != "" {
+			req.Header.Set(KeyEncodingHeader, encoding)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+	readBody := func(resp *http.Response) string {
+		t.Helper()
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	binaryKey := []byte{'b', 'i', 'n', ':', 0x00, 0xff, '/', '?', 0x10}
+	encoded := base64.RawURLEncoding.EncodeToString(binaryKey)
+
+	resp := do(http.MethodPut, "/api/v1/kv/"+encoded, KeyEncodingBase64, []byte("binary"))
+	require.Equal(t, http.StatusOK, resp.StatusCode, readBody(resp))
+	stored, err := kvStore.Get(binaryKey)
+	require.NoError(t, err, "the decoded key should be stored")
+	data, _ := decodeDataWithContentType(stored)
+	assert.Equal(t, "binary", string(data))
+
+	// Standard padded base64 works too once escaped for the path
+	std := url.PathEscape(base64.StdEncoding.EncodeToString(binaryKey))
+	resp = do(http.MethodGet, "/api/v1/kv/"+std, "BASE64", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "binary", readBody(resp))
+
+	// Listing and scanning return base64 keys for a base64 prefix
+	require.NoError(t, kvStore.Put([]byte("text:1"), []byte("v")))
+	prefix := base64.RawURLEncoding.EncodeToString([]byte("bin:"))
+	resp = do(http.MethodGet, "/api/v1/kv?prefix="+prefix, KeyEncodingBase64, nil)
+	var list struct {
+		Data struct {
+			Keys []string `json:"keys"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(readBody(resp)), &list))
+	assert.Equal(t, []string{encoded}, list.Data.Keys)
+
+	resp = do(http.MethodGet, "/api/v1/scan?keys_only=true&prefix="+prefix, KeyEncodingBase64, nil)
+	scanner := bufio.NewScanner(bytes.NewBufferString(readBody(resp)))
+	require.True(t, scanner.Scan())
+	var item ScanItem
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &item))
+	assert.Equal(t, encoded, item.Key)
+
+	resp = do(http.MethodDelete, "/api/v1/kv/"+encoded, KeyEncodingBase64, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, readBody(resp))
+	_, err = kvStore.Get(binaryKey)
+	assert.Error(t, err)
+
+	// Bad input is rejected rather than stored under a mangled key
+	resp = do(http.MethodPut, "/api/v1/kv/not*base64", KeyEncodingBase64, []byte("v"))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, readBody(resp))
+	resp = do(http.MethodGet, "/api/v1/kv/text:1", "hex", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, readBody(resp))
+
+	// Without the header, escaped keys are unescaped the same way everywhere
+	resp = do(http.MethodPut, "/api/v1/kv/"+url.PathEscape("100%/off"), "", []byte("sale"))
+	require.Equal(t, http.StatusOK, resp.StatusCode, readBody(resp))
+	resp = do(http.MethodGet, "/api/v1/kv/"+url.PathEscape("100%/off"), "", nil)
+	assert.Equal(t, "sale", readBody(resp))
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// MaxLogTailRate caps how many records per second /system/log streams, so a
+// debugging session can't saturate the disk the store is serving from
+const MaxLogTailRate = 1000
+
+// logStore is implemented by stores whose raw log records can be listed
+type logStore interface {
+	TailLogRecords(ctx context.Context, opts store.LogTailOptions,
+		fn func(store.LogRecordInfo) error) (int, int64, error)
+}
+
+// LogRecordItem is one line of a streamed log tail. The next record starts
+// at offset + size.
+type LogRecordItem struct {
+	FileID         uint32 `json:"file_id"`
+	Offset         int64  `json:"offset"`
+	Size           int64  `json:"size"`
+	Key            string `json:"key"`
+	ValueSize      uint32 `json:"value_size"`
+	Timestamp      uint64 `json:"timestamp"`
+	Tombstone      bool   `json:"tombstone"`
+	RangeTombstone bool   `json:"range_tombstone,omitempty"`
+	Internal       bool   `json:"internal,omitempty"`
+}
+
+// handleTailLog godoc
+//
+//	@Summary		Stream raw log records
+//	@Description	Stream the records of a segment as newline-delimited JSON, from an offset on, for
+//	@Description	debugging what was actually written. Values are not included. With follow=true the
+//	@Description	stream waits for new records until the limit is reached or the client disconnects.
+//	@Description	Records are sent at most at the given rate, which is capped at 1000 per second.
+//	@Tags			system
+//	@Produce		x-ndjson
+//	@Param			segment	query		int		false	"Segment FileID (0 for the active log)"
+//	@Param			offset	query		int		false	"Byte offset to start at"
+//	@Param			limit	query		int		false	"Maximum number of records"
+//	@Param			follow	query		bool	false	"Wait for new records at the end of the segment"
+//	@Param			rate	query		int		false	"Maximum records per second"
+//	@Param			X-Key-Encoding	header		string	false	"base64 to receive keys as base64"
+//	@Success		200		{object}	LogRecordItem
+//	@Failure		400		{object}	map[string]string
+//	@Failure		501		{object}	map[string]string
+//	@Router			/system/log [get]
+//	@Security		ApiKeyAuth
+func (s *Server) handleTailLog(w http.ResponseWriter, r *http.Request) {
+	logs, ok := s.store.(logStore)
+	if !ok {
+		sendError(w, "Store does not expose its log", http.StatusNotImplemented)
+		return
+	}
+
+	opts, err := s.logTailOptions(r)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	codec, err := requestKeyCodec(r)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	out := newNDJSONWriter(w)
+
+	summary := StreamSummary{}
+	count, _, err := logs.TailLogRecords(r.Context(), opts, func(record store.LogRecordInfo) error {
+		if err := out.Write(LogRecordItem{
+			FileID:         record.FileID,
+			Offset:         record.Offset,
+			Size:           record.Size,
+			Key:            codec.encode(string(record.Key)),
+			ValueSize:      record.ValueSize,
+			Timestamp:      record.Timestamp,
+			Tombstone:      record.Tombstone,
+			RangeTombstone: record.RangeTombstone,
+			Internal:       record.Internal,
+		}); err != nil {
+			return err
+		}
+		if opts.Follow {
+			out.Flush()
+		}
+		return nil
+	})
+	if r.Context().Err() != nil {
+		return // Client went away; nobody is left to read a summary
+	}
+	summary.Count = count
+	summary.Truncated = count == opts.Limit
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	out.Finish(summary)
+}
+
+// logTailOptions reads the segment, offset, limit, follow and rate
+// parameters of a log tail. The limit is capped like a scan's and the rate
+// at MaxLogTailRate.
+func (s *Server) logTailOptions(r *http.Request) (store.LogTailOptions, error) {
+	query := r.URL.Query()
+	opts := store.LogTailOptions{Rate: MaxLogTailRate}
+
+	if raw := query.Get("segment"); raw != "" {
+		fileID, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			return opts, errors.New("segment must be a non-negative integer")
+		}
+		opts.FileID = uint32(fileID)
+	}
+	if raw := query.Get("offset"); raw != "" {
+		offset, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || offset < 0 {
+			return opts, errors.New("offset must be a non-negative integer")
+		}
+		opts.Offset = offset
+	}
+	if raw := query.Get("follow"); raw != "" {
+		follow, err := strconv.ParseBool(raw)
+		if err != nil {
+			return opts, fmt.Errorf("invalid follow value %q", raw)
+		}
+		opts.Follow = follow
+	}
+
+	var err error
+	if opts.Limit, err = s.scanLimit(r); err != nil {
+		return opts, err
+	}
+	if opts.Rate, err = queryInt(r, "rate", MaxLogTailRate); err != nil {
+		return opts, err
+	}
+	opts.Rate = min(opts.Rate, MaxLogTailRate)
+	return opts, nil
+}
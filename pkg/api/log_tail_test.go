@@ -0,0 +1,93 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleTailLog(t *testing.T) {
+	kvStore, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	require.NoError(t, err)
+	_, err = kvStore.Open()
+	require.NoError(t, err)
+	defer kvStore.Close()
+
+	require.NoError(t, kvStore.Put([]byte("user:1"), []byte("ada")))
+	require.NoError(t, kvStore.Put([]byte("user:2"), []byte("grace")))
+	require.NoError(t, kvStore.Delete([]byte("user:1")))
+
+	server := NewServer(kvStore, &SystemService{}, ServerConfig{}, nil)
+	srv := httptest.NewServer(http.HandlerFunc(server.handleTailLog))
+	defer srv.Close()
+
+	read := func(query string) ([]LogRecordItem, StreamSummary) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + "/?" + query)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, ContentTypeNDJSON, resp.Header.Get("Content-Type"))
+
+		var items []LogRecordItem
+		var summary StreamSummary
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			var line struct {
+				LogRecordItem
+				Summary *StreamSummary `json:"summary"`
+			}
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+			if line.Summary != nil {
+				summary = *line.Summary
+				continue
+			}
+			items = append(items, line.LogRecordItem)
+		}
+		return items, summary
+	}
+
+	items, summary := read("")
+	require.Len(t, items, 3)
+	assert.Equal(t, StreamSummary{Count: 3}, summary)
+	assert.Equal(t, "user:1", items[0].Key)
+	assert.Equal(t, uint32(3), items[0].ValueSize)
+	assert.False(t, items[0].Tombstone)
+	assert.True(t, items[2].Tombstone)
+	assert.NotZero(t, items[2].Timestamp)
+
+	// Resuming at a record's end skips it; the limit truncates
+	next := items[0].Offset + items[0].Size
+	items, summary = read("offset=" + strconv.FormatInt(next, 10) + "&limit=1")
+	require.Len(t, items, 1)
+	assert.Equal(t, "user:2", items[0].Key)
+	assert.Equal(t, StreamSummary{Count: 1, Truncated: true}, summary)
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		for _, query := range []string{"segment=-1", "offset=x", "follow=maybe", "rate=0"} {
+			resp, err := http.Get(srv.URL + "/?" + query)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+		}
+	})
+
+	t.Run("unknown segment", func(t *testing.T) {
+		_, summary := read("segment=9")
+		assert.Contains(t, summary.Error, "unknown segment 9")
+	})
+
+	t.Run("store without a log", func(t *testing.T) {
+		server := NewServer(NewMemoryStore(), &SystemService{}, ServerConfig{}, nil)
+		rec := httptest.NewRecorder()
+		server.handleTailLog(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusNotImplemented, rec.Code)
+	})
+}
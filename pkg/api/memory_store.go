@@ -0,0 +1,318 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// MemoryStore is an in-memory IKVStore and SystemStore. It keeps nothing on
+// disk, so it suits tests and embedders that want the API without a data
+// directory; it is not a substitute for a KVStore in production.
+type MemoryStore struct {
+	data          map[string][]byte
+	relationships []store.Relationship
+	sequences     map[string]uint64 // Sequence name -> last ID handed out
+	commits       uint64            // Writes applied; the commit sequence
+	mutex         sync.RWMutex
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: make(map[string][]byte), sequences: make(map[string]uint64)}
+}
+
+// Put stores a copy of value under key
+func (m *MemoryStore) Put(key, value []byte) error {
+	if len(key) == 0 {
+		return store.ErrInvalidKey
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.data[string(key)] = append([]byte(nil), value...)
+	m.commits++
+	return nil
+}
+
+// Get returns a copy of the value stored under key
+func (m *MemoryStore) Get(key []byte) ([]byte, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	value, ok := m.data[string(key)]
+	if !ok {
+		return nil, store.ErrKeyNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+// Delete removes key; deleting a missing key is not an error
+func (m *MemoryStore) Delete(key []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.data, string(key))
+	m.commits++
+	return nil
+}
+
+// ListKeys returns the keys beginning with prefix in sorted order
+func (m *MemoryStore) ListKeys(prefix []byte) ([]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	keys := make([]string, 0)
+	for key := range m.data {
+		if strings.HasPrefix(key, string(prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// PutRelationship records a relationship between two existing keys
+func (m *MemoryStore) PutRelationship(fromKey, toKey, relation string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	rel := store.Relationship{FromKey: fromKey, ToKey: toKey, Relation: relation}
+	if err := m.validateRelationshipInternal(rel); err != nil {
+		return err
+	}
+	m.putRelationshipInternal(rel)
+	return nil
+}
+
+// PutRelationships records a batch of relationships with the same
+// all-or-nothing semantics as KVStore.PutRelationships
+func (m *MemoryStore) PutRelationships(rels []store.Relationship) ([]error, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	edgeErrs := make([]error, len(rels))
+	var err error
+	for i, rel := range rels {
+		if edgeErrs[i] = m.validateRelationshipInternal(rel); edgeErrs[i] != nil {
+			err = store.ErrRelationshipBatch
+		}
+	}
+	if err != nil {
+		return edgeErrs, err
+	}
+
+	for _, rel := range rels {
+		m.putRelationshipInternal(rel)
+	}
+	return edgeErrs, nil
+}
+
+// validateRelationshipInternal checks that both ends of rel exist (caller
+// must hold the mutex)
+func (m *MemoryStore) validateRelationshipInternal(rel store.Relationship) error {
+	if rel.FromKey == "" || rel.ToKey == "" || rel.Relation == "" {
+		return fmt.Errorf("from_key, to_key, and relation are required")
+	}
+	if _, ok := m.data[rel.FromKey]; !ok {
+		return fmt.Errorf("source entity does not exist: %s", rel.FromKey)
+	}
+	if _, ok := m.data[rel.ToKey]; !ok {
+		return fmt.Errorf("target entity does not exist: %s", rel.ToKey)
+	}
+	return nil
+}
+
+// putRelationshipInternal records rel unless it already exists (caller
+// must hold the mutex)
+func (m *MemoryStore) putRelationshipInternal(rel store.Relationship) {
+	for _, existing := range m.relationships {
+		if existing.FromKey == rel.FromKey && existing.ToKey == rel.ToKey && existing.Relation == rel.Relation {
+			return
+		}
+	}
+	if rel.CreatedAt.IsZero() {
+		rel.CreatedAt = time.Now()
+	}
+	m.relationships = append(m.relationships, rel)
+	m.commits++
+}
+
+// DeleteRelationship removes a relationship if it exists
+func (m *MemoryStore) DeleteRelationship(fromKey, toKey, relation string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i, rel := range m.relationships {
+		if rel.FromKey == fromKey && rel.ToKey == toKey && rel.Relation == relation {
+			m.relationships = append(m.relationships[:i], m.relationships[i+1:]...)
+			m.commits++
+			break
+		}
+	}
+	return nil
+}
+
+// GetRelationships returns the relationships of query.Key, with the same
+// direction and limit semantics as KVStore.GetRelationships
+func (m *MemoryStore) GetRelationships(query store.RelationshipQuery) ([]store.RelationshipResult, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	limit := query.Limit
+	if limit == 0 {
+		limit = 100 // Default limit
+	}
+
+	var results []store.RelationshipResult
+	add := func(direction string, outgoing bool) {
+		for i := range m.relationships {
+			rel := m.relationships[i]
+			if len(results) >= limit {
+				return
+			}
+			if query.Relation != "" && rel.Relation != query.Relation {
+				continue
+			}
+			self, other := rel.ToKey, rel.FromKey
+			if outgoing {
+				self, other = rel.FromKey, rel.ToKey
+			}
+			if self == query.Key {
+				results = append(results, store.RelationshipResult{Relationship: &rel, OtherKey: other, Direction: direction})
+			}
+		}
+	}
+	if query.Direction == "outgoing" || query.Direction == "both" {
+		add("outgoing", true)
+	}
+	if query.Direction == "incoming" || query.Direction == "both" {
+		add("incoming", false)
+	}
+	return results, nil
+}
+
+// NextIDs reserves count consecutive IDs of the named sequence and returns
+// the first; sequences start at 1 and are lost with the store
+func (m *MemoryStore) NextIDs(name string, count int) (uint64, error) {
+	if name == "" {
+		return 0, store.ErrInvalidKey
+	}
+	if count < 1 {
+		return 0, store.ErrInvalidCount
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	first := m.sequences[name] + 1
+	m.sequences[name] += uint64(count)
+	m.commits++
+	return first, nil
+}
+
+// CommitSeq returns how many writes the store has applied
+func (m *MemoryStore) CommitSeq() uint64 {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.commits
+}
+
+// WaitForSeq returns once the store has applied seq writes or ctx is done.
+// Every write is applied before it returns, so only a sequence from the
+// future waits.
+func (m *MemoryStore) WaitForSeq(ctx context.Context, seq uint64) error {
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+
+	for m.CommitSeq() < seq {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Explain reports the key count and data size; there are no segments or
+// indexes to describe
+func (m *MemoryStore) Explain(ctx context.Context, _ store.ExplainOptions) (*store.ExplainResult, error) {
+	stats := m.Stats()
+	res := store.NewExplainResult(ctx)
+	res.Global.TotalKeys = stats.Keys
+	res.Global.ActiveKeys = stats.Keys
+	res.Global.TotalSizeMB = float64(stats.DataSize) / (1024 * 1024)
+	res.Global.LiveSizeMB = res.Global.TotalSizeMB
+	return res, nil
+}
+
+// EstimateCompaction reports nothing to reclaim: a memory store keeps no
+// segments or superseded records
+func (m *MemoryStore) EstimateCompaction() (*store.CompactionEstimate, error) {
+	stats := m.Stats()
+	return &store.CompactionEstimate{
+		Segments:   []store.SegmentCompactionEstimate{},
+		TotalBytes: stats.DataSize,
+		LiveBytes:  stats.LiveDataSize,
+	}, nil
+}
+
+// Stats returns the key count and the bytes held by keys and values
+func (m *MemoryStore) Stats() *store.StoreStats {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	stats := &store.StoreStats{Keys: len(m.data)}
+	for key, value := range m.data {
+		size := int64(len(key) + len(value))
+		stats.DataSize += size
+		if isInternalKey(key) {
+			stats.InternalKeys++
+			stats.InternalBytes += size
+		} else {
+			stats.UserKeys++
+			stats.UserBytes += size
+		}
+	}
+	stats.LiveDataSize = stats.DataSize
+	return stats
+}
+
+// PrefixStats counts the keys under prefix by scanning them. Sizes are
+// those of keys and values alone, and no write times are kept.
+func (m *MemoryStore) PrefixStats(prefix []byte) store.PrefixStats {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	stats := store.PrefixStats{Prefix: string(prefix)}
+	for key, value := range m.data {
+		if strings.HasPrefix(key, string(prefix)) {
+			stats.Keys++
+			stats.Bytes += int64(len(key) + len(value))
+			stats.ValueBytes += int64(len(value))
+		}
+	}
+	if stats.Keys > 0 {
+		stats.AvgValueSize = float64(stats.ValueBytes) / float64(stats.Keys)
+	}
+	return stats
+}
+
+// isInternalKey reports whether key is in one of store.DefaultInternalKeyspaces
+func isInternalKey(key string) bool {
+	for _, ks := range store.DefaultInternalKeyspaces {
+		if ks.Contains(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Close implements SystemStore; the data stays readable afterwards
+func (m *MemoryStore) Close() error {
+	return nil
+}
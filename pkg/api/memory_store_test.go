@@ -0,0 +1,99 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHandler_MemoryStores(t *testing.T) {
+	systemService, err := NewSystemServiceWithStore(SystemConfig{}, NewMemoryStore())
+	require.NoError(t, err)
+
+	kvStore := NewMemoryStore()
+	handler, err := NewHandler(kvStore, ServerConfig{SystemKey: "root-key"}, Dependencies{
+		SystemService: systemService,
+		Metrics:       NopMetrics{},
+	})
+	require.NoError(t, err)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	do := func(method, path, apiKey string, body []byte) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("X-API-Key", apiKey)
+		req.Header.Set("Content-Type", "text/plain")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// The system key was stored through the injected system service
+	stored, err := systemService.GetAPIKey("system-root")
+	require.NoError(t, err)
+	assert.Equal(t, "root-key", stored.Key)
+
+	resp := do(http.MethodGet, "/api/v1/health", "wrong-key", nil)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	for _, key := range []string{"user:1", "user:2"} {
+		resp = do(http.MethodPut, "/api/v1/kv/"+key, "root-key", []byte("hello"))
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	value, err := kvStore.Get([]byte("user:1"))
+	require.NoError(t, err)
+	data, _ := decodeDataWithContentType(value)
+	assert.Equal(t, []byte("hello"), data)
+
+	rel, err := json.Marshal(RelationshipRequest{FromKey: "user:1", ToKey: "user:2", Relation: "follows"})
+	require.NoError(t, err)
+	resp = do(http.MethodPost, "/api/v1/relationships", "root-key", rel)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do(http.MethodGet, "/api/v1/relationships?key=user:2&direction=incoming", "root-key", nil)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var body struct {
+		Data struct {
+			Relationships []struct {
+				OtherKey  string `json:"other_key"`
+				Direction string `json:"direction"`
+			} `json:"relationships"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.Len(t, body.Data.Relationships, 1)
+	assert.Equal(t, "user:1", body.Data.Relationships[0].OtherKey)
+	assert.Equal(t, "incoming", body.Data.Relationships[0].Direction)
+}
+
+func TestSystemServiceWithStore_Reopen(t *testing.T) {
+	memory := NewMemoryStore()
+	systemService, err := NewSystemServiceWithStore(SystemConfig{EnableEncryption: true, EncryptionKey: "k"}, memory)
+	require.NoError(t, err)
+	assert.True(t, systemService.IsOpen())
+
+	require.NoError(t, systemService.StoreAPIKey(APIKey{ID: "ci", Key: "secret", IsActive: true}))
+	raw, err := memory.Get([]byte("apikey:ci"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "secret", "values should be encrypted at rest")
+
+	require.NoError(t, systemService.Close())
+	_, err = systemService.GetAPIKey("ci")
+	assert.Error(t, err)
+
+	require.NoError(t, systemService.Open())
+	key, err := systemService.GetAPIKey("ci")
+	require.NoError(t, err)
+	assert.Equal(t, "secret", key.Key)
+}
@@ -3,10 +3,14 @@ package api
 import (
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/ssargent/freyjadb/pkg/metrics"
+	"github.com/ssargent/freyjadb/pkg/store"
 )
 
 const (
@@ -24,8 +28,9 @@ type Metrics struct {
 	// Database operation metrics
 	dbOperationsTotal   *prometheus.CounterVec
 	dbOperationDuration *prometheus.HistogramVec
-	dbKeysTotal         prometheus.Gauge
-	dbDataSizeBytes     prometheus.Gauge
+
+	// Store statistics pushed by the server, exported by a metrics.Collector
+	dbStats *pushedStats
 
 	// API key authentication metrics
 	authRequestsTotal *prometheus.CounterVec
@@ -35,6 +40,9 @@ type Metrics struct {
 
 	// Health check metrics
 	healthChecksTotal *prometheus.CounterVec
+
+	// Storage engine metrics, fed by the store's StatsRecorder
+	storeStats *metrics.StatsRecorder
 }
 
 // NewMetrics creates and registers all Prometheus metrics
@@ -84,20 +92,6 @@ func NewMetrics() *Metrics {
 			[]string{"operation"},
 		),
 
-		dbKeysTotal: promauto.NewGauge(
-			prometheus.GaugeOpts{
-				Name: "freyja_db_keys_total",
-				Help: "Total number of keys in the database",
-			},
-		),
-
-		dbDataSizeBytes: promauto.NewGauge(
-			prometheus.GaugeOpts{
-				Name: "freyja_db_data_size_bytes",
-				Help: "Total size of data in the database in bytes",
-			},
-		),
-
 		// Authentication metrics
 		authRequestsTotal: promauto.NewCounterVec(
 			prometheus.CounterOpts{
@@ -124,11 +118,59 @@ func NewMetrics() *Metrics {
 			},
 			[]string{"status"},
 		),
+
+		dbStats:    &pushedStats{},
+		storeStats: metrics.NewStatsRecorder(prometheus.DefaultRegisterer),
 	}
+	prometheus.MustRegister(metrics.NewCollector(m.dbStats))
 
 	return m
 }
 
+// StoreStats returns the recorder to set as KVStoreConfig.Stats so the
+// storage engine's metrics are exported alongside the API's
+func (m *Metrics) StoreStats() store.StatsRecorder {
+	return m.storeStats
+}
+
+// PrometheusStatsRecorder is a store.StatsRecorder that exports the store's
+// counters as freyja_store_<name>_total and its histograms as
+// freyja_store_<name>.
+//
+// Deprecated: Use metrics.StatsRecorder, which doesn't need pkg/api.
+type PrometheusStatsRecorder = metrics.StatsRecorder
+
+// NewPrometheusStatsRecorder registers a metric with reg for every name in
+// store.StatCounters and store.StatHistograms.
+//
+// Deprecated: Use metrics.NewStatsRecorder.
+func NewPrometheusStatsRecorder(reg prometheus.Registerer) *PrometheusStatsRecorder {
+	return metrics.NewStatsRecorder(reg)
+}
+
+// pushedStats holds the store statistics the server last pushed through
+// the Update methods, for the freyja_db_* collector to read
+type pushedStats struct {
+	mutex sync.Mutex
+	stats store.StoreStats
+}
+
+// Stats implements metrics.StatsSource
+func (p *pushedStats) Stats() *store.StoreStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	stats := p.stats
+	return &stats
+}
+
+// update changes the pushed statistics
+func (p *pushedStats) update(fn func(stats *store.StoreStats)) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	fn(&p.stats)
+}
+
 // RecordHTTPRequest records an HTTP request
 func (m *Metrics) RecordHTTPRequest(method, endpoint string, statusCode int, duration time.Duration) {
 	statusCodeStr := strconv.Itoa(statusCode)
@@ -148,10 +190,41 @@ func (m *Metrics) RecordDBOperation(operation string, success bool, duration tim
 	m.dbOperationDuration.WithLabelValues(operation).Observe(duration.Seconds())
 }
 
+// requestIDExemplarLabel names the request ID in duration exemplars
+const requestIDExemplarLabel = "request_id"
+
+// observeWithRequestID records v with a request_id exemplar when the observer
+// supports it. IDs too long for an exemplar, which ObserveWithExemplar would
+// panic on, are recorded without one.
+func observeWithRequestID(obs prometheus.Observer, v float64, requestID string) {
+	fits := utf8.RuneCountInString(requestIDExemplarLabel)+utf8.RuneCountInString(requestID) <= prometheus.ExemplarMaxRunes
+	if eo, ok := obs.(prometheus.ExemplarObserver); ok && requestID != "" && fits {
+		eo.ObserveWithExemplar(v, prometheus.Labels{requestIDExemplarLabel: requestID})
+		return
+	}
+	obs.Observe(v)
+}
+
 // UpdateDBStats updates database statistics
 func (m *Metrics) UpdateDBStats(keys int, dataSize int64) {
-	m.dbKeysTotal.Set(float64(keys))
-	m.dbDataSizeBytes.Set(float64(dataSize))
+	m.dbStats.update(func(stats *store.StoreStats) {
+		stats.Keys, stats.DataSize = keys, dataSize
+	})
+}
+
+// UpdateReadRepairs updates the read repair counts
+func (m *Metrics) UpdateReadRepairs(repaired, failed int64) {
+	m.dbStats.update(func(stats *store.StoreStats) {
+		stats.ReadRepairs, stats.ReadRepairFailures = repaired, failed
+	})
+}
+
+// UpdateKeyCategories updates the key and byte counts per category, and
+// the rest of the statistics the freyja_db_* metrics export
+func (m *Metrics) UpdateKeyCategories(stats *store.StoreStats) {
+	m.dbStats.update(func(pushed *store.StoreStats) {
+		*pushed = *stats
+	})
 }
 
 // RecordAuthRequest records an authentication request
@@ -197,9 +270,11 @@ func (m *Metrics) InstrumentHandler(method, endpoint string, handler http.Handle
 		// Call the original handler
 		handler(rw, r)
 
-		// Record metrics
+		// Record metrics, linking the duration sample to the request that produced it
 		duration := time.Since(start)
-		m.RecordHTTPRequest(method, endpoint, rw.statusCode, duration)
+		m.httpRequestsTotal.WithLabelValues(method, endpoint, strconv.Itoa(rw.statusCode)).Inc()
+		observeWithRequestID(m.httpRequestDuration.WithLabelValues(method, endpoint), duration.Seconds(),
+			RequestIDFromContext(r.Context()))
 	}
 }
 
@@ -235,3 +310,47 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.statusCode = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+// Unwrap exposes the wrapped writer so http.ResponseController can flush
+// streamed responses
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// NopMetrics is a MetricsRecorder that records nothing, for tests and for
+// embedders that collect metrics their own way
+type NopMetrics struct{}
+
+// RecordHTTPRequest implements MetricsRecorder
+func (NopMetrics) RecordHTTPRequest(string, string, int, time.Duration) {}
+
+// RecordDBOperation implements MetricsRecorder
+func (NopMetrics) RecordDBOperation(string, bool, time.Duration) {}
+
+// UpdateDBStats implements MetricsRecorder
+func (NopMetrics) UpdateDBStats(int, int64) {}
+
+// UpdateReadRepairs implements MetricsRecorder
+func (NopMetrics) UpdateReadRepairs(int64, int64) {}
+
+// UpdateKeyCategories implements MetricsRecorder
+func (NopMetrics) UpdateKeyCategories(*store.StoreStats) {}
+
+// RecordAuthRequest implements MetricsRecorder
+func (NopMetrics) RecordAuthRequest(bool) {}
+
+// RecordRelationshipOperation implements MetricsRecorder
+func (NopMetrics) RecordRelationshipOperation(string, bool) {}
+
+// RecordHealthCheck implements MetricsRecorder
+func (NopMetrics) RecordHealthCheck(bool) {}
+
+// InstrumentHandler implements MetricsRecorder
+func (NopMetrics) InstrumentHandler(_, _ string, handler http.HandlerFunc) http.HandlerFunc {
+	return handler
+}
+
+// InstrumentAuthMiddleware implements MetricsRecorder
+func (NopMetrics) InstrumentAuthMiddleware(next func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return next
+}
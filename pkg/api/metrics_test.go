@@ -0,0 +1,33 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusStatsRecorder(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	recorder := NewPrometheusStatsRecorder(reg)
+
+	recorder.Count(store.StatPuts, 2)
+	recorder.Count(store.StatPuts, 1)
+	recorder.Observe(store.StatWriteSeconds, 0.002)
+	recorder.Count("unknown", 5) // Ignored
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	names := make(map[string]bool)
+	for _, family := range families {
+		names[family.GetName()] = true
+		if family.GetName() == "freyja_store_puts_total" {
+			assert.Equal(t, 3.0, family.GetMetric()[0].GetCounter().GetValue())
+		}
+	}
+	assert.Len(t, names, len(store.StatCounters)+len(store.StatHistograms))
+	assert.True(t, names["freyja_store_puts_total"])
+	assert.True(t, names["freyja_store_write_seconds"])
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddlewareConfig(t *testing.T) {
+	kv, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	require.NoError(t, err)
+	_, err = kv.Open()
+	require.NoError(t, err)
+	defer kv.Close()
+	systemService, err := NewSystemServiceWithStore(SystemConfig{}, NewMemoryStore())
+	require.NoError(t, err)
+
+	newHandler := func(middleware MiddlewareConfig) (http.Handler, error) {
+		return NewHandler(kv, ServerConfig{SystemKey: "root-key", Middleware: middleware}, Dependencies{
+			SystemService: systemService,
+			Metrics:       NopMetrics{},
+		})
+	}
+	health := func(handler http.Handler, header string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/health", nil)
+		if header != "" {
+			req.Header.Set("X-API-Key", header)
+		}
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// Custom middlewares run around the built-ins and after authentication
+	var calls []string
+	trace := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if name == "authenticated" {
+					principal, ok := PrincipalFromContext(r.Context())
+					require.True(t, ok)
+					name += ":" + principal.Subject
+				} else if name == "after" {
+					name += ":" + w.Header().Get(RequestIDHeader)
+				}
+				calls = append(calls, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	handler, err := newHandler(MiddlewareConfig{
+		Before:        []func(http.Handler) http.Handler{trace("before")},
+		After:         []func(http.Handler) http.Handler{trace("after")},
+		Authenticated: []func(http.Handler) http.Handler{trace("authenticated")},
+	})
+	require.NoError(t, err)
+	w := health(handler, "root-key")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Len(t, calls, 3)
+	assert.Equal(t, "before", calls[0])
+	assert.Equal(t, "after:"+w.Header().Get(RequestIDHeader), calls[1]) // The request ID is assigned by then
+	assert.Equal(t, "authenticated:system-root", calls[2])
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+
+	// Unauthenticated requests never reach Authenticated
+	calls = nil
+	assert.Equal(t, http.StatusUnauthorized, health(handler, "").Code)
+	require.Len(t, calls, 2)
+	assert.Equal(t, "before", calls[0])
+
+	// A custom order can drop built-ins and add compression
+	handler, err = newHandler(MiddlewareConfig{Order: []string{MiddlewareRecoverer, MiddlewareCompress}})
+	require.NoError(t, err)
+	w = health(handler, "root-key")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get(RequestIDHeader))
+	require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	reader, err := gzip.NewReader(w.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.True(t, strings.Contains(string(body), "healthy"), string(body))
+
+	for _, middleware := range []MiddlewareConfig{
+		{Order: []string{"gzip"}},
+		{Order: []string{MiddlewareLogger, MiddlewareLogger}},
+		{CompressionLevel: 12},
+	} {
+		_, err := newHandler(middleware)
+		assert.Error(t, err, "%+v", middleware)
+	}
+}
@@ -69,6 +69,21 @@ func (mr *MockIKVStoreMockRecorder) DeleteRelationship(fromKey, toKey, relation
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteRelationship", reflect.TypeOf((*MockIKVStore)(nil).DeleteRelationship), fromKey, toKey, relation)
 }
 
+// EstimateCompaction mocks base method.
+func (m *MockIKVStore) EstimateCompaction() (*store.CompactionEstimate, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EstimateCompaction")
+	ret0, _ := ret[0].(*store.CompactionEstimate)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// EstimateCompaction indicates an expected call of EstimateCompaction.
+func (mr *MockIKVStoreMockRecorder) EstimateCompaction() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EstimateCompaction", reflect.TypeOf((*MockIKVStore)(nil).EstimateCompaction))
+}
+
 // Explain mocks base method.
 func (m *MockIKVStore) Explain(arg0 context.Context, arg1 store.ExplainOptions) (*store.ExplainResult, error) {
 	m.ctrl.T.Helper()
@@ -129,6 +144,35 @@ func (mr *MockIKVStoreMockRecorder) ListKeys(prefix any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListKeys", reflect.TypeOf((*MockIKVStore)(nil).ListKeys), prefix)
 }
 
+// NextIDs mocks base method.
+func (m *MockIKVStore) NextIDs(name string, count int) (uint64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NextIDs", name, count)
+	ret0, _ := ret[0].(uint64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// NextIDs indicates an expected call of NextIDs.
+func (mr *MockIKVStoreMockRecorder) NextIDs(name, count any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NextIDs", reflect.TypeOf((*MockIKVStore)(nil).NextIDs), name, count)
+}
+
+// PrefixStats mocks base method.
+func (m *MockIKVStore) PrefixStats(prefix []byte) store.PrefixStats {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PrefixStats", prefix)
+	ret0, _ := ret[0].(store.PrefixStats)
+	return ret0
+}
+
+// PrefixStats indicates an expected call of PrefixStats.
+func (mr *MockIKVStoreMockRecorder) PrefixStats(prefix any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PrefixStats", reflect.TypeOf((*MockIKVStore)(nil).PrefixStats), prefix)
+}
+
 // Put mocks base method.
 func (m *MockIKVStore) Put(key, value []byte) error {
 	m.ctrl.T.Helper()
@@ -157,6 +201,21 @@ func (mr *MockIKVStoreMockRecorder) PutRelationship(fromKey, toKey, relation any
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PutRelationship", reflect.TypeOf((*MockIKVStore)(nil).PutRelationship), fromKey, toKey, relation)
 }
 
+// PutRelationships mocks base method.
+func (m *MockIKVStore) PutRelationships(arg0 []store.Relationship) ([]error, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PutRelationships", arg0)
+	ret0, _ := ret[0].([]error)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PutRelationships indicates an expected call of PutRelationships.
+func (mr *MockIKVStoreMockRecorder) PutRelationships(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PutRelationships", reflect.TypeOf((*MockIKVStore)(nil).PutRelationships), arg0)
+}
+
 // Stats mocks base method.
 func (m *MockIKVStore) Stats() *store.StoreStats {
 	m.ctrl.T.Helper()
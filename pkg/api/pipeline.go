@@ -0,0 +1,236 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ValuePipeline transforms the values written under a key prefix before
+// they reach the store. Stages run in order on writes and in reverse on
+// reads:
+//
+//	gzip           compress the value
+//	encrypt:<id>   encrypt with AES-256-GCM using PipelineKeys[<id>]
+//
+// The stages applied are recorded in the value header, so reads reverse
+// exactly what was done to each value even after the pipeline changes.
+type ValuePipeline struct {
+	Prefix string   `json:"prefix" yaml:"prefix"`
+	Stages []string `json:"stages" yaml:"stages"`
+}
+
+// Value header flag marking a value written through a pipeline. Such values
+// are laid out as [content type][flag][stage count] then, per stage,
+// [stage id][param length][param], then the transformed data.
+const pipelineHeaderFlag = 0x01
+
+// Stage identifiers recorded in value headers
+const (
+	stageGzip    byte = 1
+	stageEncrypt byte = 2
+)
+
+// valueStage is one step of a pipeline as recorded in a value header
+type valueStage struct {
+	id    byte
+	param string // Key ID for encryption
+}
+
+// valuePipelines holds the server's pipelines, longest prefix first
+type valuePipelines struct {
+	routes []pipelineRoute
+	keys   map[string]cipher.AEAD
+}
+
+// pipelineRoute is a parsed ValuePipeline
+type pipelineRoute struct {
+	prefix []byte
+	stages []valueStage
+}
+
+// newValuePipelines parses and validates pipeline configuration, deriving
+// a cipher for every configured key
+func newValuePipelines(configs []ValuePipeline, keys map[string]string) (*valuePipelines, error) {
+	p := &valuePipelines{keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, secret := range keys {
+		if id == "" || len(id) > 255 || secret == "" {
+			return nil, fmt.Errorf("pipeline key %q must have a 1-255 byte ID and a secret", id)
+		}
+		keyHash := sha256.Sum256([]byte(secret))
+		block, err := aes.NewCipher(keyHash[:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher for pipeline key %q: %w", id, err)
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create GCM for pipeline key %q: %w", id, err)
+		}
+		p.keys[id] = gcm
+	}
+
+	seen := make(map[string]bool)
+	for _, config := range configs {
+		if seen[config.Prefix] {
+			return nil, fmt.Errorf("duplicate value pipeline for prefix %q", config.Prefix)
+		}
+		seen[config.Prefix] = true
+		if len(config.Stages) == 0 || len(config.Stages) > 255 {
+			return nil, fmt.Errorf("value pipeline for prefix %q must have 1-255 stages", config.Prefix)
+		}
+
+		route := pipelineRoute{prefix: []byte(config.Prefix)}
+		for _, spec := range config.Stages {
+			stage, err := p.parseStage(spec)
+			if err != nil {
+				return nil, fmt.Errorf("value pipeline for prefix %q: %w", config.Prefix, err)
+			}
+			route.stages = append(route.stages, stage)
+		}
+		p.routes = append(p.routes, route)
+	}
+	sort.SliceStable(p.routes, func(i, j int) bool {
+		return len(p.routes[i].prefix) > len(p.routes[j].prefix)
+	})
+	return p, nil
+}
+
+// parseStage parses a stage spec such as "gzip" or "encrypt:<id>"
+func (p *valuePipelines) parseStage(spec string) (valueStage, error) {
+	name, param, _ := strings.Cut(spec, ":")
+	switch name {
+	case "gzip":
+		return valueStage{id: stageGzip}, nil
+	case "encrypt":
+		if _, ok := p.keys[param]; !ok {
+			return valueStage{}, fmt.Errorf("stage %q names an unknown key", spec)
+		}
+		return valueStage{id: stageEncrypt, param: param}, nil
+	}
+	return valueStage{}, fmt.Errorf("unknown stage %q", spec)
+}
+
+// encode transforms data with the pipeline for key, if any, and prefixes
+// the value header. Values outside every pipeline keep the plain header.
+func (p *valuePipelines) encode(key, data []byte, contentType int) ([]byte, error) {
+	stages := p.stagesFor(key)
+	if len(stages) == 0 {
+		return encodeDataWithContentType(data, contentType), nil
+	}
+
+	header := []byte{byte(contentType), pipelineHeaderFlag, byte(len(stages))}
+	for _, stage := range stages {
+		var err error
+		if data, err = p.apply(stage, data); err != nil {
+			return nil, err
+		}
+		header = append(header, stage.id, byte(len(stage.param)))
+		header = append(header, stage.param...)
+	}
+	return append(header, data...), nil
+}
+
+// stagesFor returns the stages of the longest pipeline prefix matching key,
+// or nil if no pipeline covers it
+func (p *valuePipelines) stagesFor(key []byte) []valueStage {
+	for _, route := range p.routes {
+		if bytes.HasPrefix(key, route.prefix) {
+			return route.stages
+		}
+	}
+	return nil
+}
+
+// decode reverses the stages recorded in a value's header and returns the
+// original data and content type
+func (p *valuePipelines) decode(encoded []byte) ([]byte, int, error) {
+	if len(encoded) < ContentTypeHeader || encoded[1] != pipelineHeaderFlag {
+		data, contentType := decodeDataWithContentType(encoded)
+		return data, contentType, nil
+	}
+	if len(encoded) < 3 {
+		return nil, 0, fmt.Errorf("truncated value header")
+	}
+
+	contentType := int(encoded[0])
+	stages := make([]valueStage, encoded[2])
+	rest := encoded[3:]
+	for i := range stages {
+		if len(rest) < 2 || len(rest) < 2+int(rest[1]) {
+			return nil, 0, fmt.Errorf("truncated value header")
+		}
+		stages[i] = valueStage{id: rest[0], param: string(rest[2 : 2+int(rest[1])])}
+		rest = rest[2+int(rest[1]):]
+	}
+
+	data := rest
+	for i := len(stages) - 1; i >= 0; i-- {
+		var err error
+		if data, err = p.reverse(stages[i], data); err != nil {
+			return nil, 0, err
+		}
+	}
+	return data, contentType, nil
+}
+
+// apply runs one stage over data
+func (p *valuePipelines) apply(stage valueStage, data []byte) ([]byte, error) {
+	switch stage.id {
+	case stageGzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, fmt.Errorf("failed to compress value: %w", err)
+		}
+		if err := zw.Close(); err != nil {
+			return nil, fmt.Errorf("failed to compress value: %w", err)
+		}
+		return buf.Bytes(), nil
+	case stageEncrypt:
+		gcm := p.keys[stage.param]
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return nil, fmt.Errorf("failed to generate nonce: %w", err)
+		}
+		return gcm.Seal(nonce, nonce, data, nil), nil
+	}
+	return nil, fmt.Errorf("unknown value stage %d", stage.id)
+}
+
+// reverse undoes one stage
+func (p *valuePipelines) reverse(stage valueStage, data []byte) ([]byte, error) {
+	switch stage.id {
+	case stageGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress value: %w", err)
+		}
+		defer zr.Close()
+		out, err := io.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress value: %w", err)
+		}
+		return out, nil
+	case stageEncrypt:
+		gcm, ok := p.keys[stage.param]
+		if !ok {
+			return nil, fmt.Errorf("value was encrypted with unknown key %q", stage.param)
+		}
+		if len(data) < gcm.NonceSize() {
+			return nil, fmt.Errorf("ciphertext too short")
+		}
+		out, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt value with key %q: %w", stage.param, err)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unknown value stage %d", stage.id)
+}
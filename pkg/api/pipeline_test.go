@@ -0,0 +1,152 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValuePipelines(t *testing.T) {
+	keys := map[string]string{"k1": "secret-one", "k2": "secret-two"}
+	p, err := newValuePipelines([]ValuePipeline{
+		{Prefix: "logs:", Stages: []string{"gzip"}},
+		{Prefix: "secrets:", Stages: []string{"gzip", "encrypt:k1"}},
+		{Prefix: "secrets:other:", Stages: []string{"encrypt:k2"}},
+	}, keys)
+	require.NoError(t, err)
+
+	value := []byte(strings.Repeat("a log line\n", 100))
+	tests := []struct {
+		key        string
+		wantStages int
+	}{
+		{key: "plain", wantStages: 0},
+		{key: "logs:1", wantStages: 1},
+		{key: "secrets:1", wantStages: 2},
+		{key: "secrets:other:1", wantStages: 1}, // Longest prefix wins
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			encoded, err := p.encode([]byte(tt.key), value, ContentTypeRaw)
+			require.NoError(t, err)
+			if tt.wantStages == 0 {
+				assert.Equal(t, encodeDataWithContentType(value, ContentTypeRaw), encoded)
+			} else {
+				assert.Equal(t, byte(pipelineHeaderFlag), encoded[1])
+				assert.Equal(t, byte(tt.wantStages), encoded[2])
+				assert.False(t, bytes.Contains(encoded, []byte("a log line")), "value stored untransformed")
+			}
+
+			data, contentType, err := p.decode(encoded)
+			require.NoError(t, err)
+			assert.Equal(t, value, data)
+			assert.Equal(t, ContentTypeRaw, contentType)
+		})
+	}
+
+	// Reads follow the header, not the current configuration
+	encoded, err := p.encode([]byte("secrets:1"), value, ContentTypeJSON)
+	require.NoError(t, err)
+	unconfigured, err := newValuePipelines(nil, keys)
+	require.NoError(t, err)
+	data, contentType, err := unconfigured.decode(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, value, data)
+	assert.Equal(t, ContentTypeJSON, contentType)
+
+	// Without the key the value can't be read
+	keyless, err := newValuePipelines(nil, nil)
+	require.NoError(t, err)
+	_, _, err = keyless.decode(encoded)
+	assert.ErrorContains(t, err, `unknown key "k1"`)
+
+	// A different secret under the same ID fails authentication
+	wrongKey, err := newValuePipelines(nil, map[string]string{"k1": "not-the-secret"})
+	require.NoError(t, err)
+	_, _, err = wrongKey.decode(encoded)
+	assert.Error(t, err)
+
+	_, _, err = p.decode(encoded[:5])
+	assert.ErrorContains(t, err, "truncated")
+}
+
+func TestValuePipelines_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		configs []ValuePipeline
+		keys    map[string]string
+		wantErr string
+	}{
+		{name: "unknown stage", configs: []ValuePipeline{{Prefix: "a:", Stages: []string{"zstd"}}},
+			wantErr: "unknown stage"},
+		{name: "unknown key", configs: []ValuePipeline{{Prefix: "a:", Stages: []string{"encrypt:nope"}}},
+			wantErr: "unknown key"},
+		{name: "no stages", configs: []ValuePipeline{{Prefix: "a:"}}, wantErr: "1-255 stages"},
+		{name: "duplicate prefix", configs: []ValuePipeline{
+			{Prefix: "a:", Stages: []string{"gzip"}}, {Prefix: "a:", Stages: []string{"gzip"}},
+		}, wantErr: "duplicate"},
+		{name: "empty secret", keys: map[string]string{"k1": ""}, wantErr: "secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newValuePipelines(tt.configs, tt.keys)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+
+	_, err := NewHandler(NewMemoryStore(), ServerConfig{
+		ValuePipelines: []ValuePipeline{{Prefix: "a:", Stages: []string{"zstd"}}},
+	}, Dependencies{SystemService: &SystemService{}, Metrics: NopMetrics{}})
+	assert.ErrorContains(t, err, "invalid value pipelines")
+}
+
+func TestHandlePutGet_ValuePipeline(t *testing.T) {
+	kv := NewMemoryStore()
+	server := NewServer(kv, &SystemService{}, ServerConfig{
+		ValuePipelines: []ValuePipeline{{Prefix: "secrets:", Stages: []string{"gzip", "encrypt:main"}}},
+		PipelineKeys:   map[string]string{"main": "hunter2"},
+	}, NopMetrics{})
+
+	withKey := func(req *http.Request, key string) *http.Request {
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("key", key)
+		return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	}
+
+	body := `{"password":"swordfish"}`
+	req := httptest.NewRequest(http.MethodPut, "/kv/secrets:db", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.handlePut(w, withKey(req, "secrets:db"))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	stored, err := kv.Get([]byte("secrets:db"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(stored), "swordfish")
+
+	w = httptest.NewRecorder()
+	server.handleGet(w, withKey(httptest.NewRequest(http.MethodGet, "/kv/secrets:db", nil), "secrets:db"))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, body, w.Body.String())
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	// Scans decode values the same way
+	w = httptest.NewRecorder()
+	server.handleScan(w, httptest.NewRequest(http.MethodGet, "/scan?prefix=secrets:", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"value":{"password":"swordfish"}`)
+
+	// Without the key, reads fail rather than returning ciphertext
+	keyless := NewServer(kv, &SystemService{}, ServerConfig{}, NopMetrics{})
+	w = httptest.NewRecorder()
+	keyless.handleGet(w, withKey(httptest.NewRequest(http.MethodGet, "/kv/secrets:db", nil), "secrets:db"))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "Failed to decode value")
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// Quiesce defaults
+const (
+	DefaultQuiesceTimeout = 5 * time.Minute // Used when a request does not ask for a timeout
+	MaxQuiesceTimeout     = time.Hour       // Longest a quiesce can block writes
+	quiesceWait           = 30 * time.Second
+	quiesceIDBytes        = 8
+)
+
+// quiesceStore is implemented by stores that can be quiesced
+type quiesceStore interface {
+	Quiesce(ctx context.Context) (*store.Quiesce, error)
+}
+
+// QuiesceRequest is the body of a quiesce request
+type QuiesceRequest struct {
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+}
+
+// QuiesceStatus describes the quiesce the server holds
+type QuiesceStatus struct {
+	ID        string                `json:"id"`
+	Since     time.Time             `json:"since"`
+	ExpiresAt time.Time             `json:"expires_at"` // When it is released if nobody releases it first
+	Manifest  *store.BackupManifest `json:"manifest"`
+}
+
+// quiesceState tracks the quiesce held through the API. The store allows
+// one at a time; a second request is refused rather than left waiting.
+type quiesceState struct {
+	mutex  sync.Mutex
+	held   *store.Quiesce
+	status QuiesceStatus
+	timer  *time.Timer
+}
+
+// release lets writes resume if id is still the quiesce held, reporting
+// whether it was
+func (qs *quiesceState) release(id string) bool {
+	qs.mutex.Lock()
+	defer qs.mutex.Unlock()
+
+	if qs.held == nil || qs.status.ID != id {
+		return false
+	}
+	qs.timer.Stop()
+	qs.held.Release()
+	qs.held = nil
+	return true
+}
+
+// handleQuiesce godoc
+//
+//	@Summary		Quiesce the store
+//	@Description	Block new writes, wait for those in flight and fsync the store, so the data directory
+//	@Description	can be snapshotted or detached at the filesystem level. Reads continue. Writes stay
+//	@Description	blocked until the quiesce is released with DELETE /system/quiesce/{id}, or until its
+//	@Description	timeout passes, so a forgotten quiesce can't stall the store for good. Blocked writes
+//	@Description	fail with a timeout if the quiesce outlasts the server's write timeout.
+//	@Tags			system
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		QuiesceRequest	false	"Auto-release timeout"
+//	@Success		200		{object}	QuiesceStatus
+//	@Failure		400		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Failure		501		{object}	map[string]string
+//	@Failure		503		{object}	map[string]string
+//	@Router			/system/quiesce [post]
+//	@Security		ApiKeyAuth
+func (s *Server) handleQuiesce(w http.ResponseWriter, r *http.Request) {
+	qstore, ok := s.store.(quiesceStore)
+	if !ok {
+		sendError(w, "Store cannot be quiesced", http.StatusNotImplemented)
+		return
+	}
+
+	var req QuiesceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		sendError(w, "Invalid JSON request", http.StatusBadRequest)
+		return
+	}
+	timeout := DefaultQuiesceTimeout
+	if req.TimeoutSeconds != 0 {
+		timeout = time.Duration(req.TimeoutSeconds) * time.Second
+	}
+	if timeout <= 0 || timeout > MaxQuiesceTimeout {
+		sendError(w, fmt.Sprintf("timeout_seconds must be between 1 and %d", int(MaxQuiesceTimeout.Seconds())),
+			http.StatusBadRequest)
+		return
+	}
+
+	qs := s.quiesce
+	qs.mutex.Lock()
+	defer qs.mutex.Unlock()
+	if qs.held != nil {
+		sendError(w, fmt.Sprintf("Store is already quiesced (id %s)", qs.status.ID), http.StatusConflict)
+		return
+	}
+
+	idBytes := make([]byte, quiesceIDBytes)
+	if _, err := rand.Read(idBytes); err != nil {
+		sendError(w, fmt.Sprintf("Failed to generate quiesce ID: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), quiesceWait)
+	defer cancel()
+	held, err := qstore.Quiesce(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		sendError(w, "Timed out waiting for in-flight writes", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to quiesce: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	id := hex.EncodeToString(idBytes)
+	qs.held = held
+	qs.status = QuiesceStatus{
+		ID:        id,
+		Since:     held.Since(),
+		ExpiresAt: held.Since().Add(timeout),
+		Manifest:  held.Manifest(),
+	}
+	qs.timer = time.AfterFunc(timeout, func() {
+		if qs.release(id) {
+			s.logger.Warn("quiesce released after its timeout", slog.String("id", id),
+				slog.Duration("timeout", timeout))
+		}
+	})
+
+	s.audit(r, "quiesce.start", slog.String("id", id), slog.Time("expires_at", qs.status.ExpiresAt))
+	sendSuccess(w, qs.status)
+}
+
+// handleGetQuiesce godoc
+//
+//	@Summary		Get the quiesce held
+//	@Description	Get the quiesce blocking writes, if any
+//	@Tags			system
+//	@Produce		json
+//	@Success		200	{object}	QuiesceStatus
+//	@Failure		404	{object}	map[string]string
+//	@Router			/system/quiesce [get]
+//	@Security		ApiKeyAuth
+func (s *Server) handleGetQuiesce(w http.ResponseWriter, r *http.Request) {
+	s.quiesce.mutex.Lock()
+	defer s.quiesce.mutex.Unlock()
+
+	if s.quiesce.held == nil {
+		sendError(w, "Store is not quiesced", http.StatusNotFound)
+		return
+	}
+	sendSuccess(w, s.quiesce.status)
+}
+
+// handleReleaseQuiesce godoc
+//
+//	@Summary		Release a quiesce
+//	@Description	Let writes resume after a quiesce
+//	@Tags			system
+//	@Produce		json
+//	@Param			id	path		string	true	"Quiesce ID"
+//	@Success		200	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Router			/system/quiesce/{id} [delete]
+//	@Security		ApiKeyAuth
+func (s *Server) handleReleaseQuiesce(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if !s.quiesce.release(id) {
+		sendError(w, fmt.Sprintf("No quiesce %s is held; it may have timed out", id), http.StatusNotFound)
+		return
+	}
+
+	s.audit(r, "quiesce.release", slog.String("id", id))
+	sendSuccess(w, map[string]string{"message": "Quiesce released"})
+}
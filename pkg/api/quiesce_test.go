@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleQuiesce(t *testing.T) {
+	kvStore, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	require.NoError(t, err)
+	_, err = kvStore.Open()
+	require.NoError(t, err)
+	defer kvStore.Close()
+	server := NewServer(kvStore, &SystemService{}, ServerConfig{}, nil)
+
+	quiesce := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.handleQuiesce(w, httptest.NewRequest(http.MethodPost, "/system/quiesce", strings.NewReader(body)))
+		return w
+	}
+	release := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, "/system/quiesce/"+id, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		w := httptest.NewRecorder()
+		server.handleReleaseQuiesce(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusBadRequest, quiesce(`{"timeout_seconds":-1}`).Code)
+
+	w := quiesce("")
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Data QuiesceStatus `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	id := resp.Data.ID
+	assert.NotEmpty(t, id)
+	assert.WithinDuration(t, resp.Data.Since.Add(DefaultQuiesceTimeout), resp.Data.ExpiresAt, time.Second)
+	assert.True(t, kvStore.IsQuiesced())
+	assert.Equal(t, http.StatusConflict, quiesce("").Code)
+
+	status := httptest.NewRecorder()
+	server.handleGetQuiesce(status, httptest.NewRequest(http.MethodGet, "/system/quiesce", nil))
+	assert.Equal(t, http.StatusOK, status.Code)
+
+	assert.Equal(t, http.StatusNotFound, release("someone-else").Code)
+	assert.Equal(t, http.StatusOK, release(id).Code)
+	assert.False(t, kvStore.IsQuiesced())
+	assert.Equal(t, http.StatusNotFound, release(id).Code)
+	require.NoError(t, kvStore.Put([]byte("a"), []byte("1")))
+}
+
+func TestHandleQuiesce_AutoRelease(t *testing.T) {
+	kvStore, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	require.NoError(t, err)
+	_, err = kvStore.Open()
+	require.NoError(t, err)
+	defer kvStore.Close()
+	server := NewServer(kvStore, &SystemService{}, ServerConfig{}, nil)
+
+	w := httptest.NewRecorder()
+	server.handleQuiesce(w, httptest.NewRequest(http.MethodPost, "/system/quiesce",
+		strings.NewReader(`{"timeout_seconds":1}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// A write waits for the timeout, then goes through
+	start := time.Now()
+	require.NoError(t, kvStore.Put([]byte("a"), []byte("1")))
+	assert.GreaterOrEqual(t, time.Since(start), 500*time.Millisecond)
+	assert.False(t, kvStore.IsQuiesced())
+}
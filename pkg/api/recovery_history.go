@@ -0,0 +1,277 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/keys"
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// Recovery history defaults
+const (
+	recoveryKeys           = keys.Keyspace("recovery")
+	recoveryKeyLayout      = "2006-01-02T15:04:05.000000000Z" // Fixed width, so keys sort by time
+	recoveryCheckInterval  = time.Minute
+	MaxRecoveryEvents      = 1000 // Older events are pruned
+	DefaultRecoveryHistory = 100  // Events returned when a request doesn't ask for a limit
+	recoveryExplainOpens   = 20   // Opens of the default store summarized by /explain
+)
+
+// RecoveryEvent records what one open of a store recovered, so operators
+// can see whether corruption keeps coming back
+type RecoveryEvent struct {
+	Store            string               `json:"store"`
+	At               time.Time            `json:"at"`
+	RecordsValidated int64                `json:"records_validated"`
+	RecordsTruncated int64                `json:"records_truncated"`
+	TruncatedBytes   int64                `json:"truncated_bytes"`
+	LogSize          int64                `json:"log_size"` // Active log size after recovery
+	IndexRebuilt     bool                 `json:"index_rebuilt"`
+	CleanShutdown    bool                 `json:"clean_shutdown"`
+	DurationMs       float64              `json:"duration_ms"`
+	Policy           store.RecoveryPolicy `json:"policy"`
+}
+
+// NewRecoveryEvent describes the recovery of the named store
+func NewRecoveryEvent(name string, res *store.RecoveryResult) RecoveryEvent {
+	return RecoveryEvent{
+		Store:            name,
+		At:               res.StartedAt.UTC(),
+		RecordsValidated: res.RecordsValidated,
+		RecordsTruncated: res.RecordsTruncated,
+		TruncatedBytes:   res.TruncatedBytes(),
+		LogSize:          res.FileSizeAfter,
+		IndexRebuilt:     res.IndexRebuilt,
+		CleanShutdown:    res.CleanShutdown,
+		DurationMs:       float64(res.RecoveryTime) / float64(time.Millisecond),
+		Policy:           res.Policy,
+	}
+}
+
+// Corrupt reports whether the recovery had to cut off corrupt data
+func (e RecoveryEvent) Corrupt() bool {
+	return e.RecordsTruncated > 0 || e.TruncatedBytes > 0
+}
+
+// key is where the event is stored; opening the same store at the same
+// instant is the same event, so recording it twice is harmless
+func (e RecoveryEvent) key() []byte {
+	return recoveryKeys.Bytes(e.At.UTC().Format(recoveryKeyLayout) + "/" + e.Store)
+}
+
+// RecoverySummary totals a run of recovery events
+type RecoverySummary struct {
+	Opens            int        `json:"opens"`
+	CorruptOpens     int        `json:"corrupt_opens"`
+	RecordsTruncated int64      `json:"records_truncated"`
+	TruncatedBytes   int64      `json:"truncated_bytes"`
+	LastCorruption   *time.Time `json:"last_corruption,omitempty"`
+}
+
+// SummarizeRecoveries totals events, which must be oldest first
+func SummarizeRecoveries(events []RecoveryEvent) RecoverySummary {
+	summary := RecoverySummary{Opens: len(events)}
+	for _, event := range events {
+		if !event.Corrupt() {
+			continue
+		}
+		at := event.At
+		summary.CorruptOpens++
+		summary.RecordsTruncated += event.RecordsTruncated
+		summary.TruncatedBytes += event.TruncatedBytes
+		summary.LastCorruption = &at
+	}
+	return summary
+}
+
+// RecoveryHistory is the response of /system/recovery-history
+type RecoveryHistory struct {
+	Events  []RecoveryEvent `json:"events"` // Oldest first
+	Summary RecoverySummary `json:"summary"`
+}
+
+// RecordRecoveryEvent stores event in the system keyspace and prunes the
+// oldest events beyond MaxRecoveryEvents
+func (s *SystemService) RecordRecoveryEvent(event RecoveryEvent) error {
+	if !s.isOpen {
+		return fmt.Errorf("system service is not open")
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal recovery event: %w", err)
+	}
+	encryptedData, err := s.encrypt(data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt recovery event: %w", err)
+	}
+	if err := s.store.Put(event.key(), encryptedData); err != nil {
+		return err
+	}
+
+	stored, err := s.recoveryEventKeys()
+	if err != nil {
+		return err
+	}
+	for len(stored) > MaxRecoveryEvents {
+		if err := s.store.Delete([]byte(stored[0])); err != nil {
+			return fmt.Errorf("failed to prune recovery event %s: %w", stored[0], err)
+		}
+		stored = stored[1:]
+	}
+	return nil
+}
+
+// RecoveryEvents returns the latest limit events, oldest first, of the
+// named store or of every store if name is empty. A limit of 0 returns all.
+func (s *SystemService) RecoveryEvents(name string, limit int) ([]RecoveryEvent, error) {
+	if !s.isOpen {
+		return nil, fmt.Errorf("system service is not open")
+	}
+
+	stored, err := s.recoveryEventKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	// Walk back from the newest, since only the latest events are wanted
+	events := make([]RecoveryEvent, 0)
+	for i := len(stored) - 1; i >= 0 && (limit <= 0 || len(events) < limit); i-- {
+		encryptedData, err := s.store.Get([]byte(stored[i]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get recovery event %s: %w", stored[i], err)
+		}
+		data, err := s.decrypt(encryptedData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt recovery event %s: %w", stored[i], err)
+		}
+		var event RecoveryEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal recovery event %s: %w", stored[i], err)
+		}
+		if name == "" || event.Store == name {
+			events = append(events, event)
+		}
+	}
+
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, nil
+}
+
+// recoveryEventKeys lists the stored event keys, oldest first
+func (s *SystemService) recoveryEventKeys() ([]string, error) {
+	stored, err := s.store.ListKeys([]byte(recoveryKeys.Prefix()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recovery events: %w", err)
+	}
+	sort.Strings(stored)
+	return stored, nil
+}
+
+// recoveryReporter is implemented by stores that remember their last
+// recovery, such as *store.KVStore
+type recoveryReporter interface {
+	LastRecovery() *store.RecoveryResult
+}
+
+// recordRecoveries records the recovery of every open store not recorded
+// yet: those of the store manager, or the served store's own without one.
+// recorded holds the keys already written and is updated.
+func (s *Server) recordRecoveries(recorded map[string]bool) error {
+	recoveries := make(map[string]*store.RecoveryResult)
+	if s.stores != nil {
+		recoveries = s.stores.Recoveries()
+	} else if reporter, ok := s.store.(recoveryReporter); ok {
+		if res := reporter.LastRecovery(); res != nil {
+			recoveries[store.DefaultStoreName] = res
+		}
+	}
+
+	for name, res := range recoveries {
+		event := NewRecoveryEvent(name, res)
+		key := string(event.key())
+		if recorded[key] {
+			continue
+		}
+		if err := s.systemService.RecordRecoveryEvent(event); err != nil {
+			return err
+		}
+		recorded[key] = true
+		if event.Corrupt() {
+			s.logger.Warn("store recovered from corruption", "store", name,
+				"records_truncated", event.RecordsTruncated, "truncated_bytes", event.TruncatedBytes)
+		}
+	}
+	return nil
+}
+
+// startRecoveryRecorder records the recoveries of the stores opened at
+// startup, then checks every recoveryCheckInterval for stores opened since
+func (s *Server) startRecoveryRecorder() {
+	ticker := time.NewTicker(recoveryCheckInterval)
+	defer ticker.Stop()
+	recorded := make(map[string]bool)
+	for {
+		if err := s.recordRecoveries(recorded); err != nil {
+			s.logger.Warn("failed to record recovery", "error", err)
+		}
+		<-ticker.C
+	}
+}
+
+// explainRecovery summarizes the recent opens of the default store for an
+// explain, warning when corruption keeps recurring
+func (s *Server) explainRecovery(res *ExplainResponse) {
+	events, err := s.systemService.RecoveryEvents(store.DefaultStoreName, recoveryExplainOpens)
+	if err != nil {
+		s.logger.Warn("failed to load recovery history", "error", err)
+		return
+	}
+	if len(events) == 0 {
+		return
+	}
+	summary := SummarizeRecoveries(events)
+	res.Recovery = &summary
+	if summary.CorruptOpens > 1 {
+		res.Warnings = append(res.Warnings, fmt.Sprintf(
+			"corruption was truncated on %d of the last %d opens; check the disk and /system/recovery-history",
+			summary.CorruptOpens, summary.Opens))
+	}
+}
+
+// handleRecoveryHistory godoc
+//
+//	@Summary		Get recovery history
+//	@Description	Get what recovery found each time a store was opened: records validated and truncated,
+//	@Description	bytes cut off, and the recovery policy in effect, with totals. Use it to tell a one-off
+//	@Description	crash from corruption that keeps recurring.
+//	@Tags			system
+//	@Produce		json
+//	@Param			store	query		string	false	"Only events of this store (default, system or a namespace)"
+//	@Param			limit	query		int		false	"Number of most recent events (default 100)"
+//	@Success		200		{object}	RecoveryHistory
+//	@Failure		400		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/system/recovery-history [get]
+//	@Security		ApiKeyAuth
+func (s *Server) handleRecoveryHistory(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", DefaultRecoveryHistory)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, err := s.systemService.RecoveryEvents(r.URL.Query().Get("store"), limit)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to load recovery history: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	sendSuccess(w, RecoveryHistory{Events: events, Summary: SummarizeRecoveries(events)})
+}
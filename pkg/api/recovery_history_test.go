@@ -0,0 +1,129 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecoveryEvents(t *testing.T) {
+	systemService, err := NewSystemServiceWithStore(SystemConfig{}, NewMemoryStore())
+	require.NoError(t, err)
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < MaxRecoveryEvents+5; i++ {
+		event := RecoveryEvent{Store: store.DefaultStoreName, At: start.Add(time.Duration(i) * time.Second)}
+		if i%2 == 1 {
+			event.Store = store.SystemStoreName
+		}
+		require.NoError(t, systemService.RecordRecoveryEvent(event))
+	}
+
+	// The oldest events were pruned
+	all, err := systemService.RecoveryEvents("", 0)
+	require.NoError(t, err)
+	require.Len(t, all, MaxRecoveryEvents)
+	assert.Equal(t, start.Add(5*time.Second), all[0].At)
+
+	// The latest events come back oldest first
+	latest, err := systemService.RecoveryEvents(store.DefaultStoreName, 2)
+	require.NoError(t, err)
+	require.Len(t, latest, 2)
+	assert.Equal(t, start.Add((MaxRecoveryEvents+2)*time.Second), latest[0].At)
+	assert.Equal(t, start.Add((MaxRecoveryEvents+4)*time.Second), latest[1].At)
+}
+
+func TestRecoveryHistory(t *testing.T) {
+	// Open a store whose log ends in garbage, so recovery truncates it
+	dataDir := t.TempDir()
+	kv, err := store.NewKVStore(store.KVStoreConfig{DataDir: dataDir})
+	require.NoError(t, err)
+	_, err = kv.Open()
+	require.NoError(t, err)
+	require.NoError(t, kv.Put([]byte("user:1"), []byte("value")))
+	require.NoError(t, kv.Close())
+	log, err := os.OpenFile(filepath.Join(dataDir, "active.data"), os.O_APPEND|os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	_, err = log.Write(bytes.Repeat([]byte{0xab}, 64))
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+	_, err = kv.Open()
+	require.NoError(t, err)
+	defer kv.Close()
+
+	systemService, err := NewSystemServiceWithStore(SystemConfig{}, NewMemoryStore())
+	require.NoError(t, err)
+	server, handler, err := newServerHandler(kv, ServerConfig{SystemKey: "root-key"}, Dependencies{
+		SystemService: systemService,
+		Metrics:       NopMetrics{},
+	})
+	require.NoError(t, err)
+
+	// Recording again doesn't duplicate the event
+	recorded := make(map[string]bool)
+	require.NoError(t, server.recordRecoveries(recorded))
+	require.NoError(t, server.recordRecoveries(recorded))
+	require.NoError(t, server.recordRecoveries(make(map[string]bool)))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-API-Key", "root-key")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/api/v1/system/recovery-history")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var history struct {
+		Data RecoveryHistory `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+	require.Len(t, history.Data.Events, 1)
+	event := history.Data.Events[0]
+	assert.Equal(t, store.DefaultStoreName, event.Store)
+	assert.Equal(t, int64(64), event.TruncatedBytes)
+	assert.Equal(t, int64(1), event.RecordsValidated)
+	assert.True(t, event.Corrupt())
+	assert.Equal(t, 1, history.Data.Summary.CorruptOpens)
+	assert.NotNil(t, history.Data.Summary.LastCorruption)
+
+	// A single corrupt open is summarized by explain without a warning
+	var explain struct {
+		Data struct {
+			Recovery *RecoverySummary `json:"recovery"`
+			Warnings []string         `json:"warnings"`
+		} `json:"data"`
+	}
+	w = get("/api/v1/explain")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &explain))
+	require.NotNil(t, explain.Data.Recovery)
+	assert.Equal(t, 1, explain.Data.Recovery.Opens)
+	assert.Empty(t, explain.Data.Warnings)
+
+	// Corruption on a second open is flagged as recurring
+	require.NoError(t, systemService.RecordRecoveryEvent(RecoveryEvent{
+		Store: store.DefaultStoreName, At: time.Now().UTC(), RecordsTruncated: 1,
+	}))
+	w = get("/api/v1/explain")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &explain))
+	assert.Equal(t, 2, explain.Data.Recovery.CorruptOpens)
+	require.Len(t, explain.Data.Warnings, 1)
+	assert.Contains(t, explain.Data.Warnings[0], "2 of the last 2 opens")
+
+	w = get("/api/v1/system/recovery-history?store=system")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+	assert.Empty(t, history.Data.Events)
+	assert.Equal(t, http.StatusBadRequest, get("/api/v1/system/recovery-history?limit=0").Code)
+}
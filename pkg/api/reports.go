@@ -0,0 +1,254 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/keys"
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// Usage report defaults
+const (
+	reportKeys            = keys.Keyspace("report")
+	reportDateLayout      = "2006-01-02"
+	DefaultReportDays     = 30 // Snapshots included in a report
+	DefaultForecastDays   = 90 // How far ahead capacity is projected
+	usageSnapshotInterval = time.Hour
+)
+
+// UsageSnapshot is one day's storage statistics. Recording again on the same
+// day replaces that day's snapshot, so the system store keeps one per day.
+type UsageSnapshot struct {
+	Date             string    `json:"date"`
+	TakenAt          time.Time `json:"taken_at"`
+	Keys             int       `json:"keys"`
+	DataBytes        int64     `json:"data_bytes"`
+	LiveBytes        int64     `json:"live_bytes"`
+	ReclaimableBytes int64     `json:"reclaimable_bytes"` // What a compaction would save
+	FreeBytes        uint64    `json:"free_bytes,omitempty"`
+}
+
+// NewUsageSnapshot builds a snapshot from store statistics and data directory health
+func NewUsageSnapshot(stats *store.StoreStats, dirs []store.DataDirHealth, at time.Time) UsageSnapshot {
+	snap := UsageSnapshot{
+		Date:             at.UTC().Format(reportDateLayout),
+		TakenAt:          at.UTC(),
+		Keys:             stats.Keys,
+		DataBytes:        stats.DataSize,
+		LiveBytes:        stats.LiveDataSize,
+		ReclaimableBytes: max(stats.DataSize-stats.LiveDataSize, 0),
+	}
+	for _, dir := range dirs {
+		if dir.Healthy {
+			snap.FreeBytes += dir.FreeBytes
+		}
+	}
+	return snap
+}
+
+// UsageTrend is the average daily growth across a report's snapshots
+type UsageTrend struct {
+	KeysPerDay  float64 `json:"keys_per_day"`
+	BytesPerDay float64 `json:"bytes_per_day"`
+}
+
+// CapacityForecast projects data size forward using the linear trend
+type CapacityForecast struct {
+	HorizonDays    int     `json:"horizon_days"`
+	ProjectedBytes int64   `json:"projected_bytes"`
+	ProjectedKeys  int64   `json:"projected_keys"`
+	DaysUntilFull  float64 `json:"days_until_full,omitempty"` // 0 when not growing or free space is unknown
+}
+
+// UsageReport combines recorded snapshots with their trend and forecast
+type UsageReport struct {
+	Snapshots []UsageSnapshot   `json:"snapshots"`
+	Trend     *UsageTrend       `json:"trend,omitempty"` // Needs at least two snapshots
+	Forecast  *CapacityForecast `json:"forecast,omitempty"`
+}
+
+// RecordUsageSnapshot stores snap under its date in the system keyspace
+func (s *SystemService) RecordUsageSnapshot(snap UsageSnapshot) error {
+	if !s.isOpen {
+		return fmt.Errorf("system service is not open")
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage snapshot: %w", err)
+	}
+	encryptedData, err := s.encrypt(data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt usage snapshot: %w", err)
+	}
+
+	return s.store.Put(reportKeys.Bytes(snap.Date), encryptedData)
+}
+
+// UsageSnapshots returns up to the most recent days snapshots, oldest first
+func (s *SystemService) UsageSnapshots(days int) ([]UsageSnapshot, error) {
+	if !s.isOpen {
+		return nil, fmt.Errorf("system service is not open")
+	}
+
+	stored, err := s.store.ListKeys([]byte(reportKeys.Prefix()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list usage snapshots: %w", err)
+	}
+	// Dates sort lexically, so the newest keys are at the end
+	sort.Strings(stored)
+	if days > 0 && len(stored) > days {
+		stored = stored[len(stored)-days:]
+	}
+
+	snapshots := make([]UsageSnapshot, 0, len(stored))
+	for _, key := range stored {
+		encryptedData, err := s.store.Get([]byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get usage snapshot %s: %w", key, err)
+		}
+		data, err := s.decrypt(encryptedData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt usage snapshot %s: %w", key, err)
+		}
+		var snap UsageSnapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal usage snapshot %s: %w", key, err)
+		}
+		snapshots = append(snapshots, snap)
+	}
+
+	return snapshots, nil
+}
+
+// BuildUsageReport fits a least-squares line through the snapshots and
+// projects it horizonDays past the latest one
+func BuildUsageReport(snapshots []UsageSnapshot, horizonDays int) *UsageReport {
+	report := &UsageReport{Snapshots: snapshots}
+	if len(snapshots) < 2 {
+		return report
+	}
+
+	first := snapshots[0].TakenAt
+	days := make([]float64, len(snapshots))
+	keys := make([]float64, len(snapshots))
+	bytes := make([]float64, len(snapshots))
+	for i, snap := range snapshots {
+		days[i] = snap.TakenAt.Sub(first).Hours() / 24
+		keys[i] = float64(snap.Keys)
+		bytes[i] = float64(snap.DataBytes)
+	}
+
+	keySlope, ok := linearSlope(days, keys)
+	if !ok {
+		return report
+	}
+	byteSlope, _ := linearSlope(days, bytes)
+	report.Trend = &UsageTrend{KeysPerDay: keySlope, BytesPerDay: byteSlope}
+
+	last := snapshots[len(snapshots)-1]
+	horizon := float64(horizonDays)
+	report.Forecast = &CapacityForecast{
+		HorizonDays:    horizonDays,
+		ProjectedBytes: max(int64(float64(last.DataBytes)+byteSlope*horizon), 0),
+		ProjectedKeys:  max(int64(float64(last.Keys)+keySlope*horizon), 0),
+	}
+	if byteSlope > 0 && last.FreeBytes > 0 {
+		report.Forecast.DaysUntilFull = float64(last.FreeBytes) / byteSlope
+	}
+
+	return report
+}
+
+// linearSlope returns the least-squares slope of ys over xs; ok is false when
+// all xs are equal
+func linearSlope(xs, ys []float64) (float64, bool) {
+	n := float64(len(xs))
+	var sumX, sumY, sumXY, sumXX float64
+	for i := range xs {
+		sumX += xs[i]
+		sumY += ys[i]
+		sumXY += xs[i] * ys[i]
+		sumXX += xs[i] * xs[i]
+	}
+
+	denom := n*sumXX - sumX*sumX
+	if denom == 0 {
+		return 0, false
+	}
+	return (n*sumXY - sumX*sumY) / denom, true
+}
+
+// recordUsageSnapshot captures the store's current statistics
+func (s *Server) recordUsageSnapshot() error {
+	var dirs []store.DataDirHealth
+	if h, ok := s.store.(interface{ DataDirHealth() []store.DataDirHealth }); ok {
+		dirs = h.DataDirHealth()
+	}
+	return s.systemService.RecordUsageSnapshot(NewUsageSnapshot(s.store.Stats(), dirs, time.Now()))
+}
+
+// startUsageReporter keeps today's usage snapshot current
+func (s *Server) startUsageReporter() {
+	ticker := time.NewTicker(usageSnapshotInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.recordUsageSnapshot(); err != nil {
+			s.logger.Warn("failed to record usage snapshot", "error", err)
+		}
+		<-ticker.C
+	}
+}
+
+// handleUsageReport godoc
+//
+//	@Summary		Get usage report
+//	@Description	Get daily storage snapshots with growth trend and capacity forecast
+//	@Tags			system
+//	@Produce		json
+//	@Param			days	query		int	false	"Number of daily snapshots to include (default 30)"
+//	@Param			horizon	query		int	false	"Forecast horizon in days (default 90)"
+//	@Success		200		{object}	UsageReport
+//	@Failure		400		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/system/reports [get]
+//	@Security		ApiKeyAuth
+func (s *Server) handleUsageReport(w http.ResponseWriter, r *http.Request) {
+	days, err := queryInt(r, "days", DefaultReportDays)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	horizon, err := queryInt(r, "horizon", DefaultForecastDays)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	snapshots, err := s.systemService.UsageSnapshots(days)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to load usage snapshots: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	sendSuccess(w, BuildUsageReport(snapshots, horizon))
+}
+
+// queryInt parses a positive integer query parameter, returning def when absent
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer", name)
+	}
+	return v, nil
+}
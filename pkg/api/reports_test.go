@@ -0,0 +1,46 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageSnapshots(t *testing.T) {
+	service, err := NewSystemService(SystemConfig{DataDir: t.TempDir()})
+	require.NoError(t, err)
+	require.NoError(t, service.Open())
+	defer service.Close()
+
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	dirs := []store.DataDirHealth{{Healthy: true, FreeBytes: 1000}, {Healthy: false, FreeBytes: 5000}}
+	for i := 0; i < 5; i++ {
+		stats := &store.StoreStats{Keys: 10 * (i + 1), DataSize: int64(100 * (i + 1)), LiveDataSize: 50}
+		require.NoError(t, service.RecordUsageSnapshot(NewUsageSnapshot(stats, dirs, start.AddDate(0, 0, i))))
+	}
+	// A second snapshot on the same day replaces the first
+	later := &store.StoreStats{Keys: 50, DataSize: 500, LiveDataSize: 500}
+	require.NoError(t, service.RecordUsageSnapshot(NewUsageSnapshot(later, dirs, start.AddDate(0, 0, 4).Add(time.Hour))))
+
+	snapshots, err := service.UsageSnapshots(3)
+	require.NoError(t, err)
+	require.Len(t, snapshots, 3)
+	assert.Equal(t, "2025-03-03", snapshots[0].Date)
+	assert.Equal(t, "2025-03-05", snapshots[2].Date)
+	assert.Equal(t, int64(0), snapshots[2].ReclaimableBytes)
+	assert.Equal(t, int64(350), snapshots[1].ReclaimableBytes)
+	assert.Equal(t, uint64(1000), snapshots[0].FreeBytes)
+
+	report := BuildUsageReport(snapshots, 10)
+	require.NotNil(t, report.Trend)
+	assert.InDelta(t, 10, report.Trend.KeysPerDay, 0.5)
+	assert.InDelta(t, 100, report.Trend.BytesPerDay, 5)
+	assert.InDelta(t, 1500, report.Forecast.ProjectedBytes, 50)
+	assert.InDelta(t, 10, report.Forecast.DaysUntilFull, 0.5)
+
+	// One snapshot is not enough for a trend
+	assert.Nil(t, BuildUsageReport(snapshots[:1], 10).Trend)
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// RequestIDHeader carries the request ID in both directions
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds client-supplied IDs so they are safe to log and
+// fit, with their label name, in a Prometheus exemplar
+const maxRequestIDLength = prometheus.ExemplarMaxRunes - len(requestIDExemplarLabel)
+
+// RequestIDFromContext returns the ID assigned to the request by the server
+func RequestIDFromContext(ctx context.Context) string {
+	return store.RequestIDFromContext(ctx)
+}
+
+// requestIDMiddleware accepts a well-formed X-Request-ID from the client or
+// generates one, echoes it on the response and stores it in the request
+// context, where store operations taking a context can see it
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(store.WithRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID accepts short IDs made of URL-safe characters only, so a
+// client cannot inject separators or control characters into logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random 128-bit hex ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestLogger writes one structured log line per request, tagged with its request ID
+func requestLogger(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r)
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			level := slog.LevelInfo
+			if status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			logger.LogAttrs(r.Context(), level, "request",
+				slog.String("request_id", RequestIDFromContext(r.Context())),
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", status),
+				slog.Int("bytes", ww.BytesWritten()),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("remote", r.RemoteAddr),
+			)
+		})
+	}
+}
+
+// audit records an administrative action with the request ID and principal
+// that performed it
+func (s *Server) audit(r *http.Request, action string, attrs ...slog.Attr) {
+	subject := ""
+	if principal, ok := PrincipalFromContext(r.Context()); ok {
+		subject = principal.Subject
+	}
+
+	attrs = append([]slog.Attr{
+		slog.String("action", action),
+		slog.String("request_id", RequestIDFromContext(r.Context())),
+		slog.String("subject", subject),
+	}, attrs...)
+	s.logger.LogAttrs(r.Context(), slog.LevelInfo, "audit", attrs...)
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	var seen string
+	handler := requestIDMiddleware(requestLogger(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+		sendError(w, "boom", http.StatusInternalServerError)
+	})))
+
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"generated when absent", "", false},
+		{"accepted from client", "ci-job.42:step_7", true},
+		{"replaced when unsafe", "bad id\nwith newline", false},
+		{"replaced when too long", strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.Reset()
+			req := httptest.NewRequest("GET", "/kv/a", nil)
+			if tt.incoming != "" {
+				req.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			id := w.Header().Get(RequestIDHeader)
+			require.NotEmpty(t, id)
+			assert.Equal(t, id, seen)
+			if tt.keep {
+				assert.Equal(t, tt.incoming, id)
+			} else {
+				assert.NotEqual(t, tt.incoming, id)
+			}
+
+			// Error responses and log lines carry the same ID
+			var response APIResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, id, response.RequestID)
+
+			var entry map[string]interface{}
+			require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+			assert.Equal(t, id, entry["request_id"])
+			assert.Equal(t, float64(http.StatusInternalServerError), entry["status"])
+		})
+	}
+}
+
+func TestAuditIncludesRequestID(t *testing.T) {
+	server, cleanup := setupSystemTestServer(t)
+	defer cleanup()
+
+	var logs bytes.Buffer
+	server.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+
+	handler := requestIDMiddleware(http.HandlerFunc(server.handleDeleteAPIKey))
+	req := httptest.NewRequest("DELETE", "/system/api-keys/k1", nil)
+	req.Header.Set(RequestIDHeader, "audit-1")
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "k1")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	assert.Equal(t, "audit", entry["msg"])
+	assert.Equal(t, "api_key.delete", entry["action"])
+	assert.Equal(t, "audit-1", entry["request_id"])
+}
+
+func TestRequestIDExemplarLimit(t *testing.T) {
+	metrics := &Metrics{
+		httpRequestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total"},
+			[]string{"method", "endpoint", "status_code"}),
+		httpRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "request_duration_seconds"},
+			[]string{"method", "endpoint"}),
+		httpRequestsInFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "requests_in_flight"},
+			[]string{"method", "endpoint"}),
+	}
+	handler := requestIDMiddleware(metrics.InstrumentHandler("GET", "/kv/{key}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// The longest accepted ID is kept and fits its exemplar; longer ones,
+	// up to the old 128 character limit, are replaced rather than panicking
+	for _, incoming := range []string{strings.Repeat("a", maxRequestIDLength), strings.Repeat("b", 128)} {
+		req := httptest.NewRequest("GET", "/kv/a", nil)
+		req.Header.Set(RequestIDHeader, incoming)
+		w := httptest.NewRecorder()
+		require.NotPanics(t, func() { handler.ServeHTTP(w, req) })
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, len(incoming) <= maxRequestIDLength, w.Header().Get(RequestIDHeader) == incoming)
+	}
+	assert.Equal(t, 118, maxRequestIDLength)
+
+	// An ID too long for an exemplar is observed without one
+	obs := metrics.httpRequestDuration.WithLabelValues("GET", "/direct")
+	assert.NotPanics(t, func() { observeWithRequestID(obs, 0.1, strings.Repeat("c", 128)) })
+}
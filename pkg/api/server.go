@@ -12,42 +12,98 @@
 package api
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
-	"github.com/go-chi/cors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/ssargent/freyjadb/pkg/store"
 	"github.com/swaggo/swag"
 )
 
+// Dependencies are the collaborators wired into a server. Nil fields get
+// the defaults: a SystemService opened from the server config, Prometheus
+// metrics, and slog.Default(). Without Stores, namespace routes and the
+// per-store stats endpoint are not served. Without Alerts no soft limits
+// are checked, and without SLO no latency objectives are tracked.
+type Dependencies struct {
+	SystemService SystemManager
+	Metrics       MetricsRecorder
+	Logger        *slog.Logger
+	Stores        *store.StoreManager // Namespace stores, and the system store if SystemService is nil
+	Alerts        *AlertMonitor       // Checks soft limits and posts alerts to a webhook
+	SLO           *SLOTracker         // Judges latency objectives; feed it the store's stats with SLO.Recorder
+	JobTypes      map[string]JobFunc  // Extra job types for /api/v1/jobs, or replacements for built-in ones
+}
+
+var (
+	defaultMetrics     *Metrics
+	defaultMetricsOnce sync.Once
+)
+
+// DefaultMetrics returns the process-wide Prometheus metrics, registering
+// them on first use
+func DefaultMetrics() *Metrics {
+	defaultMetricsOnce.Do(func() {
+		defaultMetrics = NewMetrics()
+	})
+	return defaultMetrics
+}
+
 // StartServer starts the HTTP server with all routes configured
 func StartServer(store IKVStore, config ServerConfig) error {
-	// Set Swagger host with port
-	if SwaggerInfo != nil {
-		SwaggerInfo.Host = fmt.Sprintf("localhost:%d", config.Port)
-	}
+	return StartServerWithDependencies(store, config, Dependencies{})
+}
 
-	// Initialize metrics
-	metrics := NewMetrics()
+// NewHandler returns the API routes for store without starting a listener or
+// the background metrics and usage-report loops, for mounting inside another
+// server or driving from tests
+func NewHandler(store IKVStore, config ServerConfig, deps Dependencies) (http.Handler, error) {
+	_, handler, err := newServerHandler(store, config, deps)
+	return handler, err
+}
 
-	// Initialize system service
-	systemConfig := SystemConfig{
-		DataDir:          config.SystemDataDir,
-		EncryptionKey:    config.SystemEncryptionKey,
-		EnableEncryption: config.EnableEncryption,
+// newServerHandler resolves the dependencies and builds the server and its
+// router
+func newServerHandler(store IKVStore, config ServerConfig, deps Dependencies) (*Server, http.Handler, error) {
+	metrics := deps.Metrics
+	if metrics == nil {
+		metrics = DefaultMetrics()
 	}
-	systemService, err := NewSystemService(systemConfig)
-	if err != nil {
-		return fmt.Errorf("failed to create system service: %w", err)
+	logger := deps.Logger
+	if logger == nil {
+		logger = slog.Default()
 	}
 
-	// Open system service
-	if err := systemService.Open(); err != nil {
-		return fmt.Errorf("failed to open system service: %w", err)
+	systemService := deps.SystemService
+	var ownedSystem io.Closer
+	if systemService == nil {
+		systemConfig := SystemConfig{
+			DataDir:          config.SystemDataDir,
+			EncryptionKey:    config.SystemEncryptionKey,
+			EnableEncryption: config.EnableEncryption,
+			Stores:           deps.Stores,
+		}
+		service, err := NewSystemService(systemConfig)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create system service: %w", err)
+		}
+
+		// Open system service
+		if err := service.Open(); err != nil {
+			return nil, nil, fmt.Errorf("failed to open system service: %w", err)
+		}
+		systemService = service
+		ownedSystem = service
 	}
 
 	// Initialize system API key if provided
@@ -61,60 +117,121 @@ func StartServer(store IKVStore, config ServerConfig) error {
 		}
 
 		if err := systemService.StoreAPIKey(systemAPIKey); err != nil {
-			return fmt.Errorf("failed to store system API key: %w", err)
+			return nil, nil, fmt.Errorf("failed to store system API key: %w", err)
 		}
 	}
 
 	server := NewServer(store, systemService, config, metrics)
+	server.logger = logger
+	server.stores = deps.Stores
+	server.alerts = deps.Alerts
+	server.slo = deps.SLO
+	server.ownedSystem = ownedSystem
+	if server.pipelineErr != nil {
+		return nil, nil, fmt.Errorf("invalid value pipelines: %w", server.pipelineErr)
+	}
+
+	server.jobs = newJobRunner(store, systemService, logger, deps.JobTypes)
+	if err := server.jobs.recoverJobs(time.Now()); err != nil {
+		logger.Warn("failed to recover jobs", "error", err)
+	}
 
 	r := chi.NewRouter()
 
-	// Middleware
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
-	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"*"},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: false,
-		MaxAge:           300,
-	}))
+	// Middleware, in the configured order
+	chain, err := server.middlewares(config.Middleware)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid middleware configuration: %w", err)
+	}
+	r.Use(chain...)
 
 	// Prometheus metrics endpoint (unprotected for scraping)
-	r.Handle("/metrics", promhttp.Handler())
+	// OpenMetrics is enabled so request-duration exemplars carry request IDs
+	r.Handle("/metrics", promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+	}))
 
-	// API key authentication middleware for protected routes
+	authProviders, err := buildAuthProviders(config, systemService)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to configure authentication: %w", err)
+	}
+
+	// Authenticated routes; each principal carries the scopes it was granted
 	r.Route("/api/v1", func(r chi.Router) {
-		// Use system service for authentication if available, otherwise fall back to config
-		if systemService.IsOpen() {
-			r.Use(metrics.InstrumentAuthMiddleware(systemApiKeyMiddleware(systemService)))
-		} else {
-			r.Use(metrics.InstrumentAuthMiddleware(apiKeyMiddleware(config.APIKey)))
-		}
+		r.Use(metrics.InstrumentAuthMiddleware(authMiddleware(authProviders...)))
+		r.Use(server.guardViewers)
+		r.Use(config.Middleware.Authenticated...)
+
+		r.Group(func(r chi.Router) {
+			r.Use(requireMethodScope)
 
-		// Health check
-		r.Get("/health", metrics.InstrumentHandler("GET", "/api/v1/health", server.handleHealth))
+			// Health check
+			r.Get("/health", metrics.InstrumentHandler("GET", "/api/v1/health", server.handleHealth))
 
-		// KV operations
-		r.Put("/kv/{key}", metrics.InstrumentHandler("PUT", "/api/v1/kv/{key}", server.handlePut))
-		r.Get("/kv/{key}", metrics.InstrumentHandler("GET", "/api/v1/kv/{key}", server.handleGet))
-		r.Delete("/kv/{key}", metrics.InstrumentHandler("DELETE", "/api/v1/kv/{key}", server.handleDelete))
-		r.Get("/kv", metrics.InstrumentHandler("GET", "/api/v1/kv", server.handleListKeys))
+			// KV operations
+			r.Put("/kv/{key}", metrics.InstrumentHandler("PUT", "/api/v1/kv/{key}", server.handlePut))
+			r.Get("/kv/{key}", metrics.InstrumentHandler("GET", "/api/v1/kv/{key}", server.handleGet))
+			r.Get("/kv/{key}/ttl", metrics.InstrumentHandler("GET", "/api/v1/kv/{key}/ttl", server.handleGetTTL))
+			r.Delete("/kv/{key}", metrics.InstrumentHandler("DELETE", "/api/v1/kv/{key}", server.handleDelete))
+			r.Get("/kv", metrics.InstrumentHandler("GET", "/api/v1/kv", server.limited(server.handleListKeys)))
+			r.Get("/scan", metrics.InstrumentHandler("GET", "/api/v1/scan", server.limited(server.handleScan)))
+			r.Get("/watch", metrics.InstrumentHandler("GET", "/api/v1/watch", server.handleWatch))
 
-		// Relationships
-		r.Post("/relationships", metrics.InstrumentHandler("POST", "/api/v1/relationships", server.handleCreateRelationship))
-		r.Delete("/relationships", metrics.InstrumentHandler("DELETE",
-			"/api/v1/relationships", server.handleDeleteRelationship))
-		r.Get("/relationships", metrics.InstrumentHandler("GET", "/api/v1/relationships", server.handleGetRelationships))
+			// Relationships
+			r.Post("/relationships", metrics.InstrumentHandler("POST", "/api/v1/relationships", server.handleCreateRelationship))
+			r.Post("/relationships/_bulk", metrics.InstrumentHandler("POST",
+				"/api/v1/relationships/_bulk", server.handleBulkCreateRelationships))
+			r.Delete("/relationships", metrics.InstrumentHandler("DELETE",
+				"/api/v1/relationships", server.handleDeleteRelationship))
+			r.Get("/relationships", metrics.InstrumentHandler("GET",
+				"/api/v1/relationships", server.limited(server.handleGetRelationships)))
 
-		// Diagnostics
-		r.Get("/explain", metrics.InstrumentHandler("GET", "/api/v1/explain", server.handleExplain))
-		r.Get("/stats", metrics.InstrumentHandler("GET", "/api/v1/stats", server.handleStats))
+			// Sequences
+			r.Post("/sequence/{name}", metrics.InstrumentHandler("POST", "/api/v1/sequence/{name}", server.handleNextSequence))
 
-		// System administration endpoints (require system API key)
+			// Diagnostics
+			r.Get("/explain", metrics.InstrumentHandler("GET", "/api/v1/explain", server.limited(server.handleExplain)))
+			r.Get("/stats", metrics.InstrumentHandler("GET", "/api/v1/stats", server.handleStats))
+			r.Get("/stats/prefix", metrics.InstrumentHandler("GET", "/api/v1/stats/prefix", server.handlePrefixStats))
+			r.Get("/stats/writes", metrics.InstrumentHandler("GET", "/api/v1/stats/writes", server.handleWriteStats))
+			r.Get("/compaction/estimate", metrics.InstrumentHandler("GET",
+				"/api/v1/compaction/estimate", server.handleCompactionEstimate))
+
+			// Namespace stores, routed through the store manager
+			if server.stores != nil {
+				r.Get("/stores", metrics.InstrumentHandler("GET", "/api/v1/stores", server.handleListStores))
+				r.Route("/ns/{namespace}", func(r chi.Router) {
+					r.Put("/kv/{key}", metrics.InstrumentHandler("PUT", "/api/v1/ns/{namespace}/kv/{key}",
+						server.inNamespace(true, (*Server).handlePut)))
+					r.Get("/kv/{key}", metrics.InstrumentHandler("GET", "/api/v1/ns/{namespace}/kv/{key}",
+						server.inNamespace(false, (*Server).handleGet)))
+					r.Get("/kv/{key}/ttl", metrics.InstrumentHandler("GET", "/api/v1/ns/{namespace}/kv/{key}/ttl",
+						server.inNamespace(false, (*Server).handleGetTTL)))
+					r.Delete("/kv/{key}", metrics.InstrumentHandler("DELETE", "/api/v1/ns/{namespace}/kv/{key}",
+						server.inNamespace(false, (*Server).handleDelete)))
+					r.Get("/kv", metrics.InstrumentHandler("GET", "/api/v1/ns/{namespace}/kv",
+						server.limited(server.inNamespace(false, (*Server).handleListKeys))))
+					r.Get("/scan", metrics.InstrumentHandler("GET", "/api/v1/ns/{namespace}/scan",
+						server.limited(server.inNamespace(false, (*Server).handleScan))))
+					r.Get("/watch", metrics.InstrumentHandler("GET", "/api/v1/ns/{namespace}/watch",
+						server.inNamespace(false, (*Server).handleWatch)))
+				})
+			}
+		})
+
+		// Long-running operations (require the admin scope)
+		r.Route("/jobs", func(r chi.Router) {
+			r.Use(requireScope(ScopeAdmin))
+
+			r.Post("/", metrics.InstrumentHandler("POST", "/api/v1/jobs", server.handleStartJob))
+			r.Get("/", metrics.InstrumentHandler("GET", "/api/v1/jobs", server.handleListJobs))
+			r.Get("/{id}", metrics.InstrumentHandler("GET", "/api/v1/jobs/{id}", server.handleGetJob))
+			r.Delete("/{id}", metrics.InstrumentHandler("DELETE", "/api/v1/jobs/{id}", server.handleCancelJob))
+		})
+
+		// System administration endpoints (require the admin scope)
 		r.Route("/system", func(r chi.Router) {
-			r.Use(metrics.InstrumentAuthMiddleware(systemApiKeyMiddleware(systemService)))
+			r.Use(requireScope(ScopeAdmin))
 
 			// API key management
 			r.Post("/api-keys", metrics.InstrumentHandler("POST", "/api/v1/system/api-keys", server.handleCreateAPIKey))
@@ -123,6 +240,26 @@ func StartServer(store IKVStore, config ServerConfig) error {
 			r.Delete("/api-keys/{id}", metrics.InstrumentHandler("DELETE",
 				"/api/v1/system/api-keys/{id}", server.handleDeleteAPIKey))
 
+			// Temporary credentials
+			r.Post("/tokens", metrics.InstrumentHandler("POST", "/api/v1/system/tokens", server.handleIssueToken))
+			r.Delete("/tokens/{id}", metrics.InstrumentHandler("DELETE", "/api/v1/system/tokens/{id}", server.handleRevokeToken))
+
+			// Usage reports
+			r.Get("/reports", metrics.InstrumentHandler("GET", "/api/v1/system/reports", server.handleUsageReport))
+			r.Get("/recovery-history", metrics.InstrumentHandler("GET", "/api/v1/system/recovery-history",
+				server.handleRecoveryHistory))
+			r.Get("/slo", metrics.InstrumentHandler("GET", "/api/v1/system/slo", server.handleSLO))
+
+			// Raw log records, for debugging
+			r.Get("/log", metrics.InstrumentHandler("GET",
+				"/api/v1/system/log", server.limited(server.handleTailLog)))
+
+			// Quiescing for filesystem snapshots
+			r.Post("/quiesce", metrics.InstrumentHandler("POST", "/api/v1/system/quiesce", server.handleQuiesce))
+			r.Get("/quiesce", metrics.InstrumentHandler("GET", "/api/v1/system/quiesce", server.handleGetQuiesce))
+			r.Delete("/quiesce/{id}", metrics.InstrumentHandler("DELETE",
+				"/api/v1/system/quiesce/{id}", server.handleReleaseQuiesce))
+
 			// System configuration
 			r.Get("/config/{key}", metrics.InstrumentHandler("GET", "/api/v1/system/config/{key}", server.handleGetSystemConfig))
 			r.Put("/config/{key}", metrics.InstrumentHandler("PUT", "/api/v1/system/config/{key}", server.handleSetSystemConfig))
@@ -202,9 +339,39 @@ func StartServer(store IKVStore, config ServerConfig) error {
 		http.NotFound(w, r)
 	})
 
+	return server, r, nil
+}
+
+// StartServerWithDependencies starts the HTTP server using the given
+// dependencies in place of the defaults
+func StartServerWithDependencies(store IKVStore, config ServerConfig, deps Dependencies) error {
+	// Set Swagger host with port
+	if SwaggerInfo != nil {
+		SwaggerInfo.Host = fmt.Sprintf("localhost:%d", config.Port)
+	}
+
+	server, handler, err := newServerHandler(store, config, deps)
+	if err != nil {
+		return err
+	}
+
 	// Start background metrics updater
 	go server.startMetricsUpdater()
 
+	// Record daily usage snapshots for reports
+	go server.startUsageReporter()
+
+	// Record explain snapshots for /explain?history=
+	go server.startExplainRecorder()
+
+	// Record what opening each store recovered for /system/recovery-history
+	go server.startRecoveryRecorder()
+
+	// Check soft limits and post alerts
+	if server.alerts != nil {
+		go server.alerts.run(server.store, config.DataDir, server.logger)
+	}
+
 	addr := fmt.Sprintf(":%d", config.Port)
 	fmt.Printf("Starting FreyjaDB REST API server on %s\n", addr)
 	fmt.Printf("Metrics available at: http://localhost:%d/metrics\n", config.Port)
@@ -212,13 +379,39 @@ func StartServer(store IKVStore, config ServerConfig) error {
 	// Create HTTP server with timeouts
 	srv := &http.Server{
 		Addr:         addr,
-		Handler:      r,
+		Handler:      handler,
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 
-	log.Fatal(srv.ListenAndServe())
+	// Stop on SIGINT, or the SIGTERM a service manager sends, by draining
+	// requests and closing the stores cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+	stop() // A second signal kills the process
 
+	grace := config.shutdownGracePeriod()
+	fmt.Printf("Shutting down: waiting up to %s for in-flight requests\n", grace)
+	closers := []io.Closer{server}
+	if deps.Stores != nil {
+		closers = append(closers, deps.Stores)
+	}
+	if closer, ok := store.(io.Closer); ok {
+		closers = append(closers, closer)
+	}
+	if err := Shutdown(context.Background(), srv, grace, closers...); err != nil {
+		return fmt.Errorf("unclean shutdown: %w", err)
+	}
+	fmt.Println("Shutdown complete")
 	return nil
 }
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// DefaultShutdownGracePeriod is how long a shutdown waits for in-flight
+// requests when ServerConfig.ShutdownGracePeriod is zero
+const DefaultShutdownGracePeriod = 30 * time.Second
+
+// shutdownGracePeriod returns the configured grace period, or the default
+func (c ServerConfig) shutdownGracePeriod() time.Duration {
+	if c.ShutdownGracePeriod > 0 {
+		return c.ShutdownGracePeriod
+	}
+	return DefaultShutdownGracePeriod
+}
+
+// Shutdown stops a server without losing acknowledged work. srv stops
+// accepting connections and its in-flight requests get up to grace to
+// finish; any still running after that are cut off. Then closers are closed
+// in order, normally the Server, which stops its jobs, and the stores, whose
+// Close fsyncs the log and, with FastRestart, saves the index alongside the
+// clean-shutdown marker. The closers are closed even if draining failed,
+// and every failure is returned.
+func Shutdown(ctx context.Context, srv *http.Server, grace time.Duration, closers ...io.Closer) error {
+	if grace <= 0 {
+		grace = DefaultShutdownGracePeriod
+	}
+
+	var errs []error
+	drainCtx, cancel := context.WithTimeout(ctx, grace)
+	err := srv.Shutdown(drainCtx)
+	cancel()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("requests still in flight after %s: %w", grace, err))
+		if err := srv.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, closer := range closers {
+		if closer == nil {
+			continue
+		}
+		if err := closer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close stops the server's jobs, waiting for each to record how it ended,
+// and closes the system service if the server opened it. Call it once no
+// more requests are being served; Shutdown does so after draining.
+func (s *Server) Close() error {
+	if s.jobs != nil {
+		s.jobs.stop()
+	}
+	if s.ownedSystem != nil {
+		if err := s.ownedSystem.Close(); err != nil {
+			return fmt.Errorf("failed to close system service: %w", err)
+		}
+	}
+	return nil
+}
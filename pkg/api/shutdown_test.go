@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// closeRecorder records whether it has been closed
+type closeRecorder struct {
+	closed atomic.Bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+// serve starts srv on a free local port and returns its base URL
+func serve(t *testing.T, srv *http.Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(ln)
+	return "http://" + ln.Addr().String()
+}
+
+func TestShutdownDrainsRequests(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+	mux.HandleFunc("/fast", func(w http.ResponseWriter, r *http.Request) {})
+	srv := &http.Server{Handler: mux}
+	base := serve(t, srv)
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(base + "/slow")
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		body <- string(data)
+	}()
+	<-started
+
+	closer := &closeRecorder{}
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- Shutdown(context.Background(), srv, 5*time.Second, closer)
+	}()
+
+	// New requests are refused while the slow one is still served, and the
+	// store isn't closed under it
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(base + "/fast")
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.False(t, closer.closed.Load())
+
+	close(release)
+	assert.Equal(t, "done", <-body)
+	require.NoError(t, <-shutdown)
+	assert.True(t, closer.closed.Load())
+}
+
+func TestShutdownGracePeriodExpires(t *testing.T) {
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	})}
+	base := serve(t, srv)
+
+	go func() {
+		if resp, err := http.Get(base); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+
+	// The stuck request is cut off, and the stores are closed regardless
+	closer := &closeRecorder{}
+	err := Shutdown(context.Background(), srv, 50*time.Millisecond, closer)
+	assert.ErrorContains(t, err, "still in flight")
+	assert.True(t, closer.closed.Load())
+}
+
+func TestServerCloseStopsJobs(t *testing.T) {
+	systemService, err := NewSystemServiceWithStore(SystemConfig{}, NewMemoryStore())
+	require.NoError(t, err)
+	server, _, err := newServerHandler(NewMemoryStore(), ServerConfig{}, Dependencies{
+		SystemService: systemService,
+		Metrics:       NopMetrics{},
+		JobTypes: map[string]JobFunc{
+			"wait": func(ctx context.Context, kv IKVStore, params json.RawMessage, progress JobProgressFunc) (any, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	job, err := server.jobs.start(JobRequest{Type: "wait"}, "", "")
+	require.NoError(t, err)
+
+	// Close returns once the job has been recorded as canceled
+	require.NoError(t, server.Close())
+	stored, err := systemService.GetJob(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, JobCanceled, stored.Status)
+
+	_, err = server.jobs.start(JobRequest{Type: "wait"}, "", "")
+	assert.ErrorIs(t, err, errJobsStopped)
+}
@@ -0,0 +1,301 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// DefaultSLOWindow is the rolling window SLOs are judged over when
+// SLOConfig.Window is zero
+const DefaultSLOWindow = time.Hour
+
+// sloSlots is how many slots the window is divided into; counts leave the
+// window a slot at a time
+const sloSlots = 60
+
+// Operations an SLO can cover
+const (
+	SLOOperationGet     = "get"     // Store Gets, timed by the store (store.StatGetSeconds)
+	SLOOperationWrite   = "write"   // Store writes, including the wait for durability (store.StatWriteSeconds)
+	SLOOperationRequest = "request" // API requests, timed by the server from arrival to response
+)
+
+// sloOperations maps the store's histograms to the operations they time
+var sloOperations = map[string]string{
+	store.StatGetSeconds:   SLOOperationGet,
+	store.StatWriteSeconds: SLOOperationWrite,
+}
+
+// SLO is a latency objective: Objective percent of an operation must take
+// at most Threshold, e.g. 99% of Gets under 5ms
+type SLO struct {
+	Name      string        // Reported name (default e.g. "get_under_5ms")
+	Operation string        // SLOOperationGet, SLOOperationWrite or SLOOperationRequest
+	Threshold time.Duration // Operations slower than this spend the error budget
+	Objective float64       // Percentage of operations that must be within Threshold, e.g. 99.9
+}
+
+// SLOConfig lists the objectives an SLOTracker judges
+type SLOConfig struct {
+	Objectives []SLO
+	Window     time.Duration // Rolling window compliance is computed over (DefaultSLOWindow if zero)
+}
+
+// SLOStatus is how an objective has fared over the window
+type SLOStatus struct {
+	Name          string  `json:"name"`
+	Operation     string  `json:"operation"`
+	ThresholdMs   float64 `json:"threshold_ms"`
+	ObjectivePct  float64 `json:"objective_pct"`
+	Total         int64   `json:"total"` // Operations in the window
+	Slow          int64   `json:"slow"`  // Of Total, over the threshold
+	CompliancePct float64 `json:"compliance_pct"`
+
+	// ErrorBudgetRemainingPct is the share of the slow operations the
+	// objective allows that haven't happened yet: 100 untouched, 0 spent
+	// and negative overspent
+	ErrorBudgetRemainingPct float64 `json:"error_budget_remaining_pct"`
+
+	// BurnRate is how fast the budget is being spent: slow operations as a
+	// multiple of what the objective allows. Above 1 the budget runs out.
+	BurnRate float64 `json:"burn_rate"`
+
+	Met bool `json:"met"`
+}
+
+// SLOReport is the compliance of every objective over the rolling window
+type SLOReport struct {
+	WindowSeconds float64     `json:"window_seconds"`
+	Objectives    []SLOStatus `json:"objectives"`
+}
+
+// sloSlot counts the operations of one slot of the window per objective
+type sloSlot struct {
+	start int64 // Slot number; the slot is stale if it isn't the current one
+	total []int64
+	slow  []int64
+}
+
+// SLOTracker judges latency objectives over a rolling window. It is fed
+// by the store through Recorder and by the server's request middleware,
+// serves /api/v1/system/slo and exports its compliance to Prometheus, so
+// operators can alert on budget burn instead of raw latencies.
+type SLOTracker struct {
+	config SLOConfig
+	slot   time.Duration
+
+	mutex sync.Mutex
+	slots []sloSlot // Ring indexed by slot number
+}
+
+// NewSLOTracker validates config and returns a tracker for it
+func NewSLOTracker(config SLOConfig) (*SLOTracker, error) {
+	if config.Window < 0 {
+		return nil, fmt.Errorf("SLO window must not be negative")
+	}
+	if config.Window == 0 {
+		config.Window = DefaultSLOWindow
+	}
+
+	names := make(map[string]bool)
+	objectives := make([]SLO, len(config.Objectives))
+	for i, slo := range config.Objectives {
+		switch slo.Operation {
+		case SLOOperationGet, SLOOperationWrite, SLOOperationRequest:
+		default:
+			return nil, fmt.Errorf("SLO operation must be get, write or request, got %q", slo.Operation)
+		}
+		if slo.Threshold <= 0 {
+			return nil, fmt.Errorf("SLO threshold must be positive, got %v", slo.Threshold)
+		}
+		if slo.Objective <= 0 || slo.Objective >= 100 {
+			return nil, fmt.Errorf("SLO objective must be between 0 and 100 percent, got %v", slo.Objective)
+		}
+		if slo.Name == "" {
+			slo.Name = fmt.Sprintf("%s_under_%s", slo.Operation, slo.Threshold)
+		}
+		if names[slo.Name] {
+			return nil, fmt.Errorf("duplicate SLO %q", slo.Name)
+		}
+		names[slo.Name] = true
+		objectives[i] = slo
+	}
+	config.Objectives = objectives
+
+	t := &SLOTracker{
+		config: config,
+		slot:   max(config.Window/sloSlots, time.Millisecond),
+		slots:  make([]sloSlot, sloSlots),
+	}
+	for i := range t.slots {
+		t.slots[i] = sloSlot{start: -1, total: make([]int64, len(objectives)), slow: make([]int64, len(objectives))}
+	}
+	return t, nil
+}
+
+// observe counts an operation that took d at now
+func (t *SLOTracker) observe(operation string, d time.Duration, now time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	number := now.UnixNano() / int64(t.slot)
+	slot := &t.slots[number%sloSlots]
+	if slot.start != number {
+		slot.start = number
+		clear(slot.total)
+		clear(slot.slow)
+	}
+	for i, slo := range t.config.Objectives {
+		if slo.Operation != operation {
+			continue
+		}
+		slot.total[i]++
+		if d > slo.Threshold {
+			slot.slow[i]++
+		}
+	}
+}
+
+// Report returns each objective's compliance over the window
+func (t *SLOTracker) Report() SLOReport {
+	return t.report(time.Now())
+}
+
+// report computes the report as of now
+func (t *SLOTracker) report(now time.Time) SLOReport {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	total := make([]int64, len(t.config.Objectives))
+	slow := make([]int64, len(t.config.Objectives))
+	current := now.UnixNano() / int64(t.slot)
+	for _, slot := range t.slots {
+		if slot.start <= current-sloSlots || slot.start > current {
+			continue // Left the window
+		}
+		for i := range total {
+			total[i] += slot.total[i]
+			slow[i] += slot.slow[i]
+		}
+	}
+
+	report := SLOReport{WindowSeconds: t.config.Window.Seconds(), Objectives: make([]SLOStatus, 0, len(total))}
+	for i, slo := range t.config.Objectives {
+		status := SLOStatus{
+			Name:                    slo.Name,
+			Operation:               slo.Operation,
+			ThresholdMs:             float64(slo.Threshold) / float64(time.Millisecond),
+			ObjectivePct:            slo.Objective,
+			Total:                   total[i],
+			Slow:                    slow[i],
+			CompliancePct:           100,
+			ErrorBudgetRemainingPct: 100,
+			Met:                     true,
+		}
+		if total[i] > 0 {
+			allowed := float64(total[i]) * (100 - slo.Objective) / 100
+			status.CompliancePct = float64(total[i]-slow[i]) * 100 / float64(total[i])
+			status.BurnRate = float64(slow[i]) / allowed
+			status.ErrorBudgetRemainingPct = (1 - status.BurnRate) * 100
+			status.Met = status.CompliancePct >= slo.Objective
+		}
+		report.Objectives = append(report.Objectives, status)
+	}
+	return report
+}
+
+// Recorder returns a store.StatsRecorder that passes everything on to next
+// and feeds the store's Get and write latencies to the tracker. Set it as
+// KVStoreConfig.Stats.
+func (t *SLOTracker) Recorder(next store.StatsRecorder) store.StatsRecorder {
+	if next == nil {
+		next = store.NopStatsRecorder{}
+	}
+	return &sloRecorder{tracker: t, next: next}
+}
+
+// sloRecorder tees the store's statistics into an SLOTracker
+type sloRecorder struct {
+	tracker *SLOTracker
+	next    store.StatsRecorder
+}
+
+// Count implements store.StatsRecorder
+func (r *sloRecorder) Count(name string, delta int64) {
+	r.next.Count(name, delta)
+}
+
+// Observe implements store.StatsRecorder
+func (r *sloRecorder) Observe(name string, value float64) {
+	r.next.Observe(name, value)
+	if operation, ok := sloOperations[name]; ok {
+		r.tracker.observe(operation, time.Duration(value*float64(time.Second)), time.Now())
+	}
+}
+
+// measureRequests is middleware timing every request for request SLOs
+func (t *SLOTracker) measureRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		now := time.Now()
+		t.observe(SLOOperationRequest, now.Sub(start), now)
+	})
+}
+
+// SLO metric descriptions, labelled by objective name and operation
+var (
+	sloComplianceDesc = prometheus.NewDesc("freyja_slo_compliance_ratio",
+		"Share of operations within the SLO threshold over the rolling window", []string{"slo", "operation"}, nil)
+	sloBudgetDesc = prometheus.NewDesc("freyja_slo_error_budget_remaining_ratio",
+		"Share of the SLO error budget left in the rolling window; negative once overspent", []string{"slo", "operation"}, nil)
+	sloBurnRateDesc = prometheus.NewDesc("freyja_slo_burn_rate",
+		"Slow operations as a multiple of what the SLO allows over the rolling window", []string{"slo", "operation"}, nil)
+	sloObjectiveDesc = prometheus.NewDesc("freyja_slo_objective_ratio",
+		"Share of operations the SLO requires within its threshold", []string{"slo", "operation"}, nil)
+)
+
+// Describe implements prometheus.Collector
+func (t *SLOTracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sloComplianceDesc
+	ch <- sloBudgetDesc
+	ch <- sloBurnRateDesc
+	ch <- sloObjectiveDesc
+}
+
+// Collect implements prometheus.Collector, computing the report at every
+// scrape
+func (t *SLOTracker) Collect(ch chan<- prometheus.Metric) {
+	for _, status := range t.Report().Objectives {
+		labels := []string{status.Name, status.Operation}
+		ch <- prometheus.MustNewConstMetric(sloComplianceDesc, prometheus.GaugeValue, status.CompliancePct/100, labels...)
+		ch <- prometheus.MustNewConstMetric(sloBudgetDesc, prometheus.GaugeValue,
+			status.ErrorBudgetRemainingPct/100, labels...)
+		ch <- prometheus.MustNewConstMetric(sloBurnRateDesc, prometheus.GaugeValue, status.BurnRate, labels...)
+		ch <- prometheus.MustNewConstMetric(sloObjectiveDesc, prometheus.GaugeValue, status.ObjectivePct/100, labels...)
+	}
+}
+
+// handleSLO godoc
+//
+//	@Summary		Get SLO compliance
+//	@Description	Get each configured latency objective's compliance, remaining error budget and burn
+//	@Description	rate over the rolling window. Returns 404 when no SLOs are configured.
+//	@Tags			system
+//	@Produce		json
+//	@Success		200	{object}	SLOReport
+//	@Failure		404	{object}	map[string]string
+//	@Router			/system/slo [get]
+//	@Security		ApiKeyAuth
+func (s *Server) handleSLO(w http.ResponseWriter, r *http.Request) {
+	if s.slo == nil {
+		sendError(w, "No SLOs are configured", http.StatusNotFound)
+		return
+	}
+	sendSuccess(w, s.slo.Report())
+}
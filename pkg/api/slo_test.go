@@ -0,0 +1,143 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSLOTracker_Report(t *testing.T) {
+	tracker, err := NewSLOTracker(SLOConfig{
+		Window: time.Minute,
+		Objectives: []SLO{
+			{Operation: SLOOperationGet, Threshold: 5 * time.Millisecond, Objective: 99},
+			{Name: "writes", Operation: SLOOperationWrite, Threshold: 10 * time.Millisecond, Objective: 90},
+		},
+	})
+	require.NoError(t, err)
+
+	// 1 slow Get in 100 spends the whole budget of a 99% objective
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 100; i++ {
+		latency := time.Millisecond
+		if i == 0 {
+			latency = 20 * time.Millisecond
+		}
+		tracker.observe(SLOOperationGet, latency, start.Add(time.Duration(i)*100*time.Millisecond))
+	}
+	report := tracker.report(start.Add(10 * time.Second))
+	assert.Equal(t, 60.0, report.WindowSeconds)
+	require.Len(t, report.Objectives, 2)
+
+	get := report.Objectives[0]
+	assert.Equal(t, "get_under_5ms", get.Name)
+	assert.Equal(t, int64(100), get.Total)
+	assert.Equal(t, int64(1), get.Slow)
+	assert.InDelta(t, 99.0, get.CompliancePct, 1e-9)
+	assert.InDelta(t, 1.0, get.BurnRate, 1e-9)
+	assert.InDelta(t, 0.0, get.ErrorBudgetRemainingPct, 1e-9)
+	assert.True(t, get.Met)
+
+	// An objective without operations is met with its budget untouched
+	writes := report.Objectives[1]
+	assert.Equal(t, "writes", writes.Name)
+	assert.Equal(t, int64(0), writes.Total)
+	assert.Equal(t, 100.0, writes.ErrorBudgetRemainingPct)
+	assert.True(t, writes.Met)
+
+	// A second slow Get overspends the budget
+	tracker.observe(SLOOperationGet, time.Second, start.Add(20*time.Second))
+	get = tracker.report(start.Add(20 * time.Second)).Objectives[0]
+	assert.Less(t, get.ErrorBudgetRemainingPct, 0.0)
+	assert.False(t, get.Met)
+
+	// Operations leave the window
+	get = tracker.report(start.Add(90 * time.Second)).Objectives[0]
+	assert.Equal(t, int64(0), get.Total)
+}
+
+func TestNewSLOTracker_InvalidConfig(t *testing.T) {
+	for _, config := range []SLOConfig{
+		{Objectives: []SLO{{Operation: "scan", Threshold: time.Millisecond, Objective: 99}}},
+		{Objectives: []SLO{{Operation: SLOOperationGet, Objective: 99}}},
+		{Objectives: []SLO{{Operation: SLOOperationGet, Threshold: time.Millisecond, Objective: 100}}},
+		{Objectives: []SLO{
+			{Operation: SLOOperationGet, Threshold: time.Millisecond, Objective: 99},
+			{Operation: SLOOperationGet, Threshold: time.Millisecond, Objective: 99.9},
+		}},
+		{Window: -time.Minute},
+	} {
+		_, err := NewSLOTracker(config)
+		assert.Error(t, err, "%+v", config)
+	}
+}
+
+func TestSLOTracker_Endpoint(t *testing.T) {
+	tracker, err := NewSLOTracker(SLOConfig{Objectives: []SLO{
+		{Operation: SLOOperationGet, Threshold: time.Second, Objective: 99},
+		{Operation: SLOOperationRequest, Threshold: time.Minute, Objective: 99},
+	}})
+	require.NoError(t, err)
+
+	// The store's Gets reach the tracker through its recorder
+	kv, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir(), Stats: tracker.Recorder(nil)})
+	require.NoError(t, err)
+	_, err = kv.Open()
+	require.NoError(t, err)
+	defer kv.Close()
+	require.NoError(t, kv.Put([]byte("user:1"), []byte("value")))
+	_, err = kv.Get([]byte("user:1"))
+	require.NoError(t, err)
+
+	systemService, err := NewSystemServiceWithStore(SystemConfig{}, NewMemoryStore())
+	require.NoError(t, err)
+	handler, err := NewHandler(kv, ServerConfig{SystemKey: "root-key"}, Dependencies{
+		SystemService: systemService,
+		Metrics:       NopMetrics{},
+		SLO:           tracker,
+	})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/system/slo", nil)
+	req.Header.Set("X-API-Key", "root-key")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var response struct {
+		Data SLOReport `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(t, response.Data.Objectives, 2)
+	assert.Equal(t, int64(1), response.Data.Objectives[0].Total)
+	assert.Equal(t, int64(0), response.Data.Objectives[1].Total) // Measured once the response is written
+
+	reg := prometheus.NewRegistry()
+	require.NoError(t, reg.Register(tracker))
+	expected := `
+# HELP freyja_slo_error_budget_remaining_ratio Share of the SLO error budget left in the rolling window; negative once overspent
+# TYPE freyja_slo_error_budget_remaining_ratio gauge
+freyja_slo_error_budget_remaining_ratio{operation="get",slo="get_under_1s"} 1
+freyja_slo_error_budget_remaining_ratio{operation="request",slo="request_under_1m0s"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected), "freyja_slo_error_budget_remaining_ratio"))
+
+	// Without SLOs the endpoint reports there is nothing to track
+	handler, err = NewHandler(kv, ServerConfig{SystemKey: "root-key"}, Dependencies{
+		SystemService: systemService,
+		Metrics:       NopMetrics{},
+	})
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,305 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/redact"
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+const (
+	// ContentTypeNDJSON is the media type of streamed results: one JSON
+	// document per line
+	ContentTypeNDJSON = "application/x-ndjson"
+
+	// DefaultMaxScanResults caps a streamed result when the server config
+	// doesn't set MaxScanResults
+	DefaultMaxScanResults = 10000
+
+	// streamFlushInterval is how many lines are written between flushes
+	streamFlushInterval = 100
+
+	// Trailers sent after a streamed result
+	ResultCountTrailer     = "X-Result-Count"
+	ResultTruncatedTrailer = "X-Result-Truncated"
+)
+
+// ScanItem is one line of a streamed scan
+type ScanItem struct {
+	Key         string      `json:"key"`
+	Value       interface{} `json:"value,omitempty"`
+	ContentType string      `json:"content_type,omitempty"`
+}
+
+// StreamSummary ends a streamed result. Truncated is set when the result
+// stopped at the limit; Error is set when the stream failed part way, since
+// the status code has already been sent by then.
+type StreamSummary struct {
+	Count     int        `json:"count"`
+	Truncated bool       `json:"truncated"`
+	Error     string     `json:"error,omitempty"`
+	Trace     *ScanTrace `json:"trace,omitempty"` // Set for scans with trace=true
+}
+
+// streamSummaryLine wraps the summary so clients can tell it from an item
+type streamSummaryLine struct {
+	Summary StreamSummary `json:"summary"`
+}
+
+// ndjsonWriter writes one JSON document per line, flushing periodically so
+// clients see results as they are produced
+type ndjsonWriter struct {
+	w       http.ResponseWriter
+	encoder *json.Encoder
+	rc      *http.ResponseController
+	lines   int
+}
+
+// newNDJSONWriter starts a streamed response, announcing the count trailers.
+// A stream runs as long as its result takes to produce, so it clears the
+// server's write timeout rather than being cut off part way.
+func newNDJSONWriter(w http.ResponseWriter) *ndjsonWriter {
+	w.Header().Set("Content-Type", ContentTypeNDJSON)
+	w.Header().Set("Trailer", ResultCountTrailer+", "+ResultTruncatedTrailer)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.WriteHeader(http.StatusOK)
+	return &ndjsonWriter{w: w, encoder: json.NewEncoder(w), rc: rc}
+}
+
+// Write encodes v as one line
+func (n *ndjsonWriter) Write(v interface{}) error {
+	if err := n.encoder.Encode(v); err != nil {
+		return err
+	}
+	n.lines++
+	if n.lines%streamFlushInterval == 0 {
+		n.Flush()
+	}
+	return nil
+}
+
+// Flush sends buffered lines to the client. Writers that can't flush
+// deliver everything when the handler returns.
+func (n *ndjsonWriter) Flush() {
+	_ = n.rc.Flush()
+}
+
+// Finish writes the summary line and trailers and flushes the response
+func (n *ndjsonWriter) Finish(summary StreamSummary) {
+	_ = n.encoder.Encode(streamSummaryLine{Summary: summary})
+	n.w.Header().Set(ResultCountTrailer, strconv.Itoa(summary.Count))
+	n.w.Header().Set(ResultTruncatedTrailer, strconv.FormatBool(summary.Truncated))
+	n.Flush()
+}
+
+// wantsNDJSON reports whether the client asked for a streamed response
+func wantsNDJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), ContentTypeNDJSON)
+}
+
+// scanLimit returns the number of results a request may stream: the limit
+// parameter if given, capped by the server's maximum
+func (s *Server) scanLimit(r *http.Request) (int, error) {
+	maxResults := s.config.MaxScanResults
+	if maxResults <= 0 {
+		maxResults = DefaultMaxScanResults
+	}
+	limit, err := queryInt(r, "limit", maxResults)
+	if err != nil {
+		return 0, err
+	}
+	if limit > maxResults {
+		limit = maxResults
+	}
+	return limit, nil
+}
+
+// handleScan godoc
+//
+//	@Summary		Stream key-value pairs
+//	@Description	Stream the key-value pairs under a prefix as newline-delimited JSON, flushing as results
+//	@Description	are read. The last line is a summary ({"summary":{"count":n,"truncated":bool}}), also
+//	@Description	sent as the X-Result-Count and X-Result-Truncated trailers. Results are capped at the
+//	@Description	server's maximum even when a larger limit is requested. To export data for debugging or
+//	@Description	support, skip_prefix leaves keys out and drop, hash and replace rewrite fields of JSON
+//	@Description	values by dotted path; hashes are a stable SHA-256 of the field's value. With trace=true the
+//	@Description	summary line also holds an execution trace: keys matched and scanned, records fetched,
+//	@Description	what each filter did and the time spent in each stage.
+//	@Tags			kv
+//	@Produce		x-ndjson
+//	@Param			prefix		query		string	false	"Key prefix"
+//	@Param			limit		query		int		false	"Maximum number of results"
+//	@Param			keys_only	query		bool	false	"Stream keys without values"
+//	@Param			skip_prefix	query		[]string	false	"Leave out keys under this prefix"
+//	@Param			drop		query		[]string	false	"Remove this JSON field path from values"
+//	@Param			hash		query		[]string	false	"Replace this JSON field path with a hash of its value"
+//	@Param			replace		query		[]string	false	"Replace a JSON field, as path=value"
+//	@Param			trace		query		bool		false	"Add an execution trace to the summary line"
+//	@Param			X-Key-Encoding	header		string	false	"base64 to send and receive keys as base64"
+//	@Success		200			{object}	ScanItem
+//	@Failure		400			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Router			/scan [get]
+//	@Security		ApiKeyAuth
+func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
+	if !s.awaitMinSeq(w, r) {
+		return
+	}
+	keysOnly, _ := strconv.ParseBool(r.URL.Query().Get("keys_only"))
+	s.streamScan(w, r, keysOnly)
+}
+
+// streamScan writes the keys under the request's prefix, and their values
+// unless keysOnly is set, as NDJSON. Values are read one at a time, so the
+// handler never holds more than one in memory.
+func (s *Server) streamScan(w http.ResponseWriter, r *http.Request, keysOnly bool) {
+	start := time.Now()
+	limit, err := s.scanLimit(r)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	codec, err := requestKeyCodec(r)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	prefix, err := codec.prefix(r)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	transform, err := scanTransform(r)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	viewLimit := s.viewerLimit(r)
+
+	wantTrace, _ := strconv.ParseBool(r.URL.Query().Get("trace"))
+	plan := "prefix scan of the primary index"
+	if keysOnly {
+		plan += ", keys only"
+	}
+	trace := newScanTracer(wantTrace, plan, codec.encode(string(prefix)))
+
+	began := trace.now()
+	keys, err := s.store.ListKeys(prefix)
+	trace.done(stageListKeys, began)
+	if err != nil {
+		s.recordScan(false, start)
+		sendError(w, fmt.Sprintf("Failed to list keys: %v", err), http.StatusInternalServerError)
+		return
+	}
+	trace.update(func(t *ScanTrace) { t.KeysMatched = len(keys) })
+
+	out := newNDJSONWriter(w)
+	summary := StreamSummary{}
+	for _, key := range keys {
+		if err := r.Context().Err(); err != nil {
+			s.recordScan(false, start)
+			return // Client went away; nobody is left to read a summary
+		}
+		if summary.Count == limit {
+			summary.Truncated = true
+			break
+		}
+		trace.update(func(t *ScanTrace) { t.KeysScanned++ })
+		if skipPrefix, skip := transform.SkippedBy(key); skip {
+			trace.skipped(skipPrefix)
+			continue
+		}
+
+		item := ScanItem{Key: codec.encode(key)}
+		if !keysOnly {
+			began := trace.now()
+			value, err := s.store.Get([]byte(key))
+			trace.done(stageFetch, began)
+			if errors.Is(err, store.ErrKeyNotFound) {
+				trace.update(func(t *ScanTrace) { t.RecordsMissing++ })
+				continue // Deleted since the keys were listed
+			}
+			if err != nil {
+				summary.Error = fmt.Sprintf("failed to read key %s: %v", codec.encode(key), err)
+				break
+			}
+			trace.update(func(t *ScanTrace) { t.RecordsFetched++ })
+			if item.Value, item.ContentType, err = s.scanValue(value, transform, viewLimit, trace); err != nil {
+				summary.Error = fmt.Sprintf("failed to decode key %s: %v", codec.encode(key), err)
+				break
+			}
+		}
+
+		began := trace.now()
+		if err := out.Write(item); err != nil {
+			s.recordScan(false, start)
+			return
+		}
+		trace.done(stageWrite, began)
+		summary.Count++
+	}
+
+	summary.Trace = trace.finish()
+	out.Finish(summary)
+	s.recordScan(summary.Error == "", start)
+}
+
+// recordScan records a scan in the database operation metrics
+func (s *Server) recordScan(success bool, start time.Time) {
+	if s.metrics != nil {
+		s.metrics.RecordDBOperation("scan", success, time.Since(start))
+	}
+}
+
+// scanTransform reads the redaction a scan asked for, or nil
+func scanTransform(r *http.Request) (*redact.Transform, error) {
+	params := r.URL.Query()
+	return redact.New(redact.Options{
+		SkipPrefixes: params["skip_prefix"],
+		Drop:         params["drop"],
+		Hash:         params["hash"],
+		Replace:      params["replace"],
+	})
+}
+
+// scanValue decodes a stored value for a scan line, redacted by transform
+// and cut to viewLimit bytes if positive: JSON values are embedded as JSON,
+// anything else as a string
+func (s *Server) scanValue(stored []byte, transform *redact.Transform, viewLimit int,
+	trace *scanTracer) (interface{}, string, error) {
+	began := trace.now()
+	data, contentType, err := s.decodeValue(stored)
+	trace.done(stageDecode, began)
+	if err != nil {
+		return nil, "", err
+	}
+	if contentType == ContentTypeJSON && transform != nil && len(transform.Rules) > 0 {
+		began := trace.now()
+		if data, err = transform.Apply(data); err != nil {
+			return nil, "", err
+		}
+		trace.done(stageRedact, began)
+		trace.redacted()
+	}
+	data, contentType, _ = truncateValue(data, contentType, viewLimit)
+	value, header := scanData(data, contentType)
+	return value, header, nil
+}
+
+// scanData prepares a decoded value for a streamed line
+func scanData(data []byte, contentType int) (interface{}, string) {
+	if contentType == ContentTypeJSON && json.Valid(data) {
+		return json.RawMessage(data), getContentTypeHeader(contentType)
+	}
+	return string(data), getContentTypeHeader(contentType)
+}
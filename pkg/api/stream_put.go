@@ -0,0 +1,36 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// streamStore is implemented by stores that can write a value to disk as it
+// is read, without holding all of it in memory
+type streamStore interface {
+	PutStream(key []byte, size int64, r io.Reader) error
+}
+
+// streamingPut returns the store to stream a PUT body into, if the body can
+// be stored as it arrives: a raw value of known length for a key outside
+// every value pipeline, since JSON has to be validated and pipeline stages
+// transform the whole value.
+func (s *Server) streamingPut(r *http.Request, key []byte, contentType int) (streamStore, bool) {
+	if contentType != ContentTypeRaw || r.ContentLength <= 0 {
+		return nil, false
+	}
+	if s.pipelineErr != nil || s.pipelines == nil || len(s.pipelines.stagesFor(key)) > 0 {
+		return nil, false
+	}
+	streamer, ok := s.store.(streamStore)
+	return streamer, ok
+}
+
+// putStream stores the request body under key behind the plain value
+// header, as encodeValue would
+func putStream(streamer streamStore, key []byte, r *http.Request) error {
+	header := encodeDataWithContentType(nil, ContentTypeRaw)
+	body := io.MultiReader(bytes.NewReader(header), r.Body)
+	return streamer.PutStream(key, int64(len(header))+r.ContentLength, body)
+}
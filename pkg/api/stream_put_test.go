@@ -0,0 +1,54 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlePut_Streaming(t *testing.T) {
+	kvStore, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	require.NoError(t, err)
+	_, err = kvStore.Open()
+	require.NoError(t, err)
+	defer kvStore.Close()
+
+	server := NewServer(kvStore, &SystemService{}, ServerConfig{}, nil)
+	put := func(key string, body io.Reader, size int64) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/kv/"+key, body)
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.ContentLength = size
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("key", key)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		w := httptest.NewRecorder()
+		server.handlePut(w, req)
+		return w
+	}
+
+	// A value larger than any single read round-trips intact
+	value := bytes.Repeat([]byte("0123456789abcdef"), 256*1024)
+	w := put("blob", bytes.NewReader(value), int64(len(value)))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	stored, err := kvStore.Get([]byte("blob"))
+	require.NoError(t, err)
+	data, contentType := decodeDataWithContentType(stored)
+	assert.Equal(t, ContentTypeRaw, contentType)
+	assert.True(t, bytes.Equal(value, data))
+
+	// A body shorter than its Content-Length stores nothing
+	w = put("short", strings.NewReader("abc"), 100)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	_, err = kvStore.Get([]byte("short"))
+	assert.ErrorIs(t, err, store.ErrKeyNotFound)
+}
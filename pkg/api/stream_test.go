@@ -0,0 +1,244 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readNDJSON splits a streamed response into its item lines and summary
+func readNDJSON(t *testing.T, resp *http.Response) ([]ScanItem, StreamSummary) {
+	t.Helper()
+	var items []ScanItem
+	var summary *StreamSummary
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		require.Nil(t, summary, "summary must be the last line")
+		var line struct {
+			ScanItem
+			Summary *StreamSummary `json:"summary"`
+		}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		if line.Summary != nil {
+			summary = line.Summary
+			continue
+		}
+		items = append(items, line.ScanItem)
+	}
+	require.NoError(t, scanner.Err())
+	require.NotNil(t, summary, "stream ended without a summary")
+	return items, *summary
+}
+
+// slowStore is a MemoryStore whose reads take delay each
+type slowStore struct {
+	*MemoryStore
+	delay time.Duration
+}
+
+func (s *slowStore) Get(key []byte) ([]byte, error) {
+	time.Sleep(s.delay)
+	return s.MemoryStore.Get(key)
+}
+
+func TestHandleScan_OutlivesWriteTimeout(t *testing.T) {
+	kvStore := &slowStore{MemoryStore: NewMemoryStore(), delay: 20 * time.Millisecond}
+	for i := 0; i < 10; i++ {
+		require.NoError(t, kvStore.Put([]byte(fmt.Sprintf("user:%d", i)), []byte("value")))
+	}
+
+	server := NewServer(kvStore, &SystemService{}, ServerConfig{}, nil)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(server.handleScan))
+	srv.Config.WriteTimeout = 50 * time.Millisecond // The scan takes about 200ms
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/scan?prefix=user:")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	items, summary := readNDJSON(t, resp)
+	assert.Len(t, items, 10)
+	assert.Equal(t, StreamSummary{Count: 10}, summary)
+	assert.Equal(t, "10", resp.Trailer.Get(ResultCountTrailer))
+}
+
+func TestHandleScan_StreamsNDJSON(t *testing.T) {
+	kvStore, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	require.NoError(t, err)
+	_, err = kvStore.Open()
+	require.NoError(t, err)
+	defer kvStore.Close()
+
+	for i := 0; i < 250; i++ {
+		value := encodeDataWithContentType([]byte(fmt.Sprintf(`{"n":%d}`, i)), ContentTypeJSON)
+		require.NoError(t, kvStore.Put([]byte(fmt.Sprintf("user:%03d", i)), value))
+	}
+	require.NoError(t, kvStore.Put([]byte("other"), encodeDataWithContentType([]byte("raw"), ContentTypeRaw)))
+
+	server := NewServer(kvStore, &SystemService{}, ServerConfig{MaxScanResults: 200}, nil)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/scan", server.handleScan)
+	mux.HandleFunc("/kv", server.handleListKeys)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	t.Run("values under prefix", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/scan?prefix=user:&limit=10")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, ContentTypeNDJSON, resp.Header.Get("Content-Type"))
+
+		items, summary := readNDJSON(t, resp)
+		require.Len(t, items, 10)
+		assert.Equal(t, StreamSummary{Count: 10, Truncated: true}, summary)
+		for _, item := range items {
+			var n int
+			_, err := fmt.Sscanf(item.Key, "user:%d", &n)
+			require.NoError(t, err)
+			assert.Equal(t, "application/json", item.ContentType)
+			assert.Equal(t, map[string]interface{}{"n": float64(n)}, item.Value)
+		}
+		assert.Equal(t, "10", resp.Trailer.Get(ResultCountTrailer))
+		assert.Equal(t, "true", resp.Trailer.Get(ResultTruncatedTrailer))
+	})
+
+	t.Run("limit capped at server maximum", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/scan?prefix=user:&limit=1000&keys_only=true")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		items, summary := readNDJSON(t, resp)
+		assert.Len(t, items, 200)
+		assert.Nil(t, items[0].Value)
+		assert.True(t, summary.Truncated)
+	})
+
+	t.Run("untruncated raw value", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/scan?prefix=other")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		items, summary := readNDJSON(t, resp)
+		require.Len(t, items, 1)
+		assert.Equal(t, "raw", items[0].Value)
+		assert.Equal(t, StreamSummary{Count: 1}, summary)
+		assert.Equal(t, "false", resp.Trailer.Get(ResultTruncatedTrailer))
+	})
+
+	t.Run("list keys negotiates NDJSON", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/kv?prefix=user:1", nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept", ContentTypeNDJSON)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		items, summary := readNDJSON(t, resp)
+		assert.Len(t, items, 100)
+		for _, item := range items {
+			assert.Contains(t, item.Key, "user:1")
+			assert.Nil(t, item.Value)
+		}
+		assert.False(t, summary.Truncated)
+	})
+
+	t.Run("invalid limit", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/scan?limit=-1")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestHandleScan_Redacted(t *testing.T) {
+	kvStore, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	require.NoError(t, err)
+	_, err = kvStore.Open()
+	require.NoError(t, err)
+	defer kvStore.Close()
+
+	putJSON := func(key, value string) {
+		require.NoError(t, kvStore.Put([]byte(key), encodeDataWithContentType([]byte(value), ContentTypeJSON)))
+	}
+	putJSON("session:1", `{"token":"abc"}`)
+	putJSON("user:1", `{"name":"bob","password":"x","profile":{"email":"bob@example.com","ssn":"123"}}`)
+
+	server := NewServer(kvStore, &SystemService{}, ServerConfig{}, nil)
+	srv := httptest.NewServer(http.HandlerFunc(server.handleScan))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/scan?skip_prefix=session:&drop=password&hash=profile.email&replace=profile.ssn=xxx")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	items, summary := readNDJSON(t, resp)
+	assert.Equal(t, StreamSummary{Count: 1}, summary)
+	require.Len(t, items, 1)
+	value := items[0].Value.(map[string]interface{})
+	assert.NotContains(t, value, "password")
+	profile := value["profile"].(map[string]interface{})
+	assert.Equal(t, "xxx", profile["ssn"])
+	assert.Regexp(t, "^sha256:[0-9a-f]{64}$", profile["email"])
+
+	bad, err := http.Get(srv.URL + "/scan?replace=novalue")
+	require.NoError(t, err)
+	bad.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, bad.StatusCode)
+}
+
+func TestHandleScan_Trace(t *testing.T) {
+	kvStore, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	require.NoError(t, err)
+	_, err = kvStore.Open()
+	require.NoError(t, err)
+	defer kvStore.Close()
+
+	for _, key := range []string{"session:1", "user:1", "user:2"} {
+		value := encodeDataWithContentType([]byte(`{"password":"x"}`), ContentTypeJSON)
+		require.NoError(t, kvStore.Put([]byte(key), value))
+	}
+
+	server := NewServer(kvStore, &SystemService{}, ServerConfig{}, nil)
+	srv := httptest.NewServer(http.HandlerFunc(server.handleScan))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/scan?trace=true&skip_prefix=session:&drop=password")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	items, summary := readNDJSON(t, resp)
+	assert.Len(t, items, 2)
+	require.NotNil(t, summary.Trace)
+	trace := summary.Trace
+	assert.Equal(t, 3, trace.KeysMatched)
+	assert.Equal(t, 3, trace.KeysScanned)
+	assert.Equal(t, 2, trace.RecordsFetched)
+	assert.Equal(t, []FilterTrace{
+		{Filter: "skip_prefix=session:", Skipped: 1},
+		{Filter: "redact", Applied: 2},
+	}, trace.Filters)
+
+	calls := make(map[string]int)
+	for _, stage := range trace.Stages {
+		calls[stage.Stage] = stage.Calls
+	}
+	assert.Equal(t, map[string]int{
+		stageListKeys: 1, stageFetch: 2, stageDecode: 2, stageRedact: 2, stageWrite: 2,
+	}, calls)
+
+	plain, err := http.Get(srv.URL + "/scan")
+	require.NoError(t, err)
+	defer plain.Body.Close()
+	_, summary = readNDJSON(t, plain)
+	assert.Nil(t, summary.Trace)
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
@@ -13,15 +14,24 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ssargent/freyjadb/pkg/keys"
 	"github.com/ssargent/freyjadb/pkg/store"
 )
 
+// System store keyspaces
+const (
+	apiKeyKeys = keys.Keyspace("apikey")
+	configKeys = keys.Keyspace("config")
+)
+
 // SystemService provides internal APIs for managing system-level data
 type SystemService struct {
-	store  *store.KVStore
-	config SystemConfig
-	gcm    cipher.AEAD
-	isOpen bool
+	store    SystemStore
+	injected bool // store was supplied by the caller rather than opened from DataDir
+	managed  bool // store is owned by config.Stores, which closes it
+	config   SystemConfig
+	gcm      cipher.AEAD
+	isOpen   bool
 }
 
 // SystemConfig holds configuration for the system service
@@ -30,6 +40,10 @@ type SystemConfig struct {
 	EncryptionKey    string
 	EnableEncryption bool
 	MaxRecordSize    int
+
+	// Stores, if set, supplies the system store instead of the service
+	// opening its own; the manager keeps ownership and closes it
+	Stores *store.StoreManager
 }
 
 // APIKey represents an API key stored in the system
@@ -50,23 +64,9 @@ func NewSystemService(config SystemConfig) (*SystemService, error) {
 		return nil, fmt.Errorf("failed to create system data directory: %w", err)
 	}
 
-	// Initialize encryption if enabled
-	var gcm cipher.AEAD
-	if config.EnableEncryption && config.EncryptionKey != "" {
-		// Derive a 32-byte AES-256 key from the input using SHA-256
-		// This allows users to provide keys of any length
-		keyHash := sha256.Sum256([]byte(config.EncryptionKey))
-		encryptionKey := keyHash[:]
-
-		block, err := aes.NewCipher(encryptionKey)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create cipher: %w", err)
-		}
-
-		gcm, err = cipher.NewGCM(block)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create GCM: %w", err)
-		}
+	gcm, err := newSystemCipher(config)
+	if err != nil {
+		return nil, err
 	}
 
 	service := &SystemService{
@@ -78,11 +78,67 @@ func NewSystemService(config SystemConfig) (*SystemService, error) {
 	return service, nil
 }
 
+// NewSystemServiceWithStore creates a system service backed by systemStore,
+// such as a MemoryStore in tests. The service starts open, nothing is
+// created under config.DataDir, and Close closes systemStore.
+func NewSystemServiceWithStore(config SystemConfig, systemStore SystemStore) (*SystemService, error) {
+	gcm, err := newSystemCipher(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SystemService{
+		store:    systemStore,
+		injected: true,
+		config:   config,
+		gcm:      gcm,
+		isOpen:   true,
+	}, nil
+}
+
+// newSystemCipher derives the AEAD for system data, or returns nil if
+// encryption is disabled
+func newSystemCipher(config SystemConfig) (cipher.AEAD, error) {
+	if !config.EnableEncryption || config.EncryptionKey == "" {
+		return nil, nil
+	}
+
+	// Derive a 32-byte AES-256 key from the input using SHA-256
+	// This allows users to provide keys of any length
+	keyHash := sha256.Sum256([]byte(config.EncryptionKey))
+	encryptionKey := keyHash[:]
+
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return gcm, nil
+}
+
 // Open initializes the system store
 func (s *SystemService) Open() error {
 	if s.isOpen {
 		return nil
 	}
+	if s.injected {
+		s.isOpen = true
+		return nil
+	}
+	if s.config.Stores != nil {
+		kvStore, err := s.config.Stores.System(context.Background())
+		if err != nil {
+			return fmt.Errorf("failed to open system KV store: %w", err)
+		}
+		s.store = kvStore
+		s.managed = true
+		s.isOpen = true
+		return nil
+	}
 
 	systemDataDir := filepath.Join(s.config.DataDir, "system")
 	storeConfig := store.KVStoreConfig{
@@ -115,6 +171,12 @@ func (s *SystemService) Close() error {
 	// Mark as closed first to prevent double closes
 	s.isOpen = false
 
+	if s.managed {
+		s.store = nil
+		s.managed = false
+		return nil
+	}
+
 	if s.store != nil {
 		if err := s.store.Close(); err != nil {
 			// Don't return error for "file already closed" as it's not a real error
@@ -124,6 +186,9 @@ func (s *SystemService) Close() error {
 				return fmt.Errorf("failed to close system store: %w", err)
 			}
 		}
+		if !s.injected {
+			s.store = nil
+		}
 	}
 
 	return nil
@@ -171,7 +236,7 @@ func (s *SystemService) StoreAPIKey(apiKey APIKey) error {
 		return fmt.Errorf("system service is not open")
 	}
 
-	key := fmt.Sprintf("apikey:%s", apiKey.ID)
+	key := apiKeyKeys.Bytes(apiKey.ID)
 	data, err := json.Marshal(apiKey)
 	if err != nil {
 		return fmt.Errorf("failed to marshal API key: %w", err)
@@ -182,7 +247,7 @@ func (s *SystemService) StoreAPIKey(apiKey APIKey) error {
 		return fmt.Errorf("failed to encrypt API key: %w", err)
 	}
 
-	return s.store.Put([]byte(key), encryptedData)
+	return s.store.Put(key, encryptedData)
 }
 
 // GetAPIKey retrieves an API key from the system store
@@ -191,8 +256,8 @@ func (s *SystemService) GetAPIKey(keyID string) (*APIKey, error) {
 		return nil, fmt.Errorf("system service is not open")
 	}
 
-	key := fmt.Sprintf("apikey:%s", keyID)
-	encryptedData, err := s.store.Get([]byte(key))
+	key := apiKeyKeys.Bytes(keyID)
+	encryptedData, err := s.store.Get(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get API key: %w", err)
 	}
@@ -246,16 +311,16 @@ func (s *SystemService) ListAPIKeys() ([]string, error) {
 		return nil, fmt.Errorf("system service is not open")
 	}
 
-	keys, err := s.store.ListKeys([]byte("apikey:"))
+	stored, err := s.store.ListKeys([]byte(apiKeyKeys.Prefix()))
 	if err != nil {
 		return nil, fmt.Errorf("failed to list API keys: %w", err)
 	}
 
 	// Extract key IDs from the full keys
 	var keyIDs []string
-	for _, key := range keys {
-		if len(key) > 7 { // "apikey:" prefix
-			keyIDs = append(keyIDs, key[7:])
+	for _, key := range stored {
+		if id, ok := apiKeyKeys.Trim(key); ok && id != "" {
+			keyIDs = append(keyIDs, id)
 		}
 	}
 
@@ -268,8 +333,8 @@ func (s *SystemService) DeleteAPIKey(keyID string) error {
 		return fmt.Errorf("system service is not open")
 	}
 
-	key := fmt.Sprintf("apikey:%s", keyID)
-	return s.store.Delete([]byte(key))
+	key := apiKeyKeys.Bytes(keyID)
+	return s.store.Delete(key)
 }
 
 // StoreSystemConfig stores system configuration data
@@ -278,7 +343,7 @@ func (s *SystemService) StoreSystemConfig(key string, value interface{}) error {
 		return fmt.Errorf("system service is not open")
 	}
 
-	configKey := fmt.Sprintf("config:%s", key)
+	configKey := configKeys.Bytes(key)
 	data, err := json.Marshal(value)
 	if err != nil {
 		return fmt.Errorf("failed to marshal config value: %w", err)
@@ -289,7 +354,7 @@ func (s *SystemService) StoreSystemConfig(key string, value interface{}) error {
 		return fmt.Errorf("failed to encrypt config value: %w", err)
 	}
 
-	return s.store.Put([]byte(configKey), encryptedData)
+	return s.store.Put(configKey, encryptedData)
 }
 
 // GetSystemConfig retrieves system configuration data
@@ -298,8 +363,8 @@ func (s *SystemService) GetSystemConfig(key string, value interface{}) error {
 		return fmt.Errorf("system service is not open")
 	}
 
-	configKey := fmt.Sprintf("config:%s", key)
-	encryptedData, err := s.store.Get([]byte(configKey))
+	configKey := configKeys.Bytes(key)
+	encryptedData, err := s.store.Get(configKey)
 	if err != nil {
 		return fmt.Errorf("failed to get config value: %w", err)
 	}
@@ -0,0 +1,332 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ssargent/freyjadb/pkg/keys"
+)
+
+// Temporary token defaults
+const (
+	TemporaryTokenPrefix      = "frt_"           // Distinguishes temporary tokens from API keys and JWTs
+	DefaultTemporaryTokenTTL  = 15 * time.Minute // Used when a request does not ask for a TTL
+	MaxTemporaryTokenTTL      = 24 * time.Hour   // Longest lifetime the system API will issue
+	authMethodTemporaryToken  = "temporary-token"
+	temporaryTokenKeys        = keys.Keyspace("token")
+	temporaryTokenIDBytes     = 8
+	temporaryTokenSecretBytes = 32
+)
+
+var (
+	errInvalidTemporaryToken = errors.New("invalid temporary token")
+	errTemporaryTokenExpired = errors.New("temporary token has expired")
+)
+
+// TemporaryToken is a short-lived scoped credential kept in the system store.
+// Only a hash of the secret is stored; the full token is returned once, at issue.
+type TemporaryToken struct {
+	ID          string    `json:"id"`
+	SecretHash  string    `json:"secret_hash"`
+	Subject     string    `json:"subject"` // Principal that issued the token
+	Scopes      []string  `json:"scopes"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// Expired reports whether the token is past its expiry at now
+func (t *TemporaryToken) Expired(now time.Time) bool {
+	return !now.Before(t.ExpiresAt)
+}
+
+// TemporaryTokenRequest is the body of a token issue request
+type TemporaryTokenRequest struct {
+	Scopes      []string `json:"scopes"`
+	TTLSeconds  int      `json:"ttl_seconds,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+// IssueTemporaryToken creates and stores a token that expires after ttl.
+// It returns the token string to hand to the client along with its record.
+func (s *SystemService) IssueTemporaryToken(subject string, scopes []string, ttl time.Duration,
+	description string) (string, *TemporaryToken, error) {
+	if !s.isOpen {
+		return "", nil, fmt.Errorf("system service is not open")
+	}
+
+	idBytes := make([]byte, temporaryTokenIDBytes)
+	secret := make([]byte, temporaryTokenSecretBytes)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", nil, fmt.Errorf("failed to generate token ID: %w", err)
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, fmt.Errorf("failed to generate token secret: %w", err)
+	}
+
+	now := time.Now()
+	secretHex := hex.EncodeToString(secret)
+	token := &TemporaryToken{
+		ID:          hex.EncodeToString(idBytes),
+		SecretHash:  hashTokenSecret(secretHex),
+		Subject:     subject,
+		Scopes:      append([]string(nil), scopes...),
+		Description: description,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(ttl),
+	}
+
+	data, err := json.Marshal(token)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to marshal token: %w", err)
+	}
+	encryptedData, err := s.encrypt(data)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to encrypt token: %w", err)
+	}
+	if err := s.store.Put(temporaryTokenKeys.Bytes(token.ID), encryptedData); err != nil {
+		return "", nil, fmt.Errorf("failed to store token: %w", err)
+	}
+
+	return TemporaryTokenPrefix + token.ID + "_" + secretHex, token, nil
+}
+
+// getTemporaryToken loads a token record by ID
+func (s *SystemService) getTemporaryToken(id string) (*TemporaryToken, error) {
+	encryptedData, err := s.store.Get(temporaryTokenKeys.Bytes(id))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.decrypt(encryptedData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt token: %w", err)
+	}
+
+	var token TemporaryToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal token: %w", err)
+	}
+	return &token, nil
+}
+
+// ValidateTemporaryToken checks a token string against the system store.
+// Expired tokens are deleted as they are encountered.
+func (s *SystemService) ValidateTemporaryToken(value string) (*TemporaryToken, error) {
+	if !s.isOpen {
+		return nil, fmt.Errorf("system service is not open")
+	}
+
+	id, secret, ok := strings.Cut(strings.TrimPrefix(value, TemporaryTokenPrefix), "_")
+	if !ok || id == "" || secret == "" {
+		return nil, errInvalidTemporaryToken
+	}
+
+	token, err := s.getTemporaryToken(id)
+	if err != nil {
+		return nil, errInvalidTemporaryToken
+	}
+	if subtle.ConstantTimeCompare([]byte(hashTokenSecret(secret)), []byte(token.SecretHash)) != 1 {
+		return nil, errInvalidTemporaryToken
+	}
+	if token.Expired(time.Now()) {
+		_ = s.RevokeTemporaryToken(id)
+		return nil, errTemporaryTokenExpired
+	}
+
+	return token, nil
+}
+
+// RevokeTemporaryToken removes a token before it expires
+func (s *SystemService) RevokeTemporaryToken(id string) error {
+	if !s.isOpen {
+		return fmt.Errorf("system service is not open")
+	}
+
+	return s.store.Delete(temporaryTokenKeys.Bytes(id))
+}
+
+// PurgeExpiredTokens deletes every expired token and returns how many were removed
+func (s *SystemService) PurgeExpiredTokens() (int, error) {
+	if !s.isOpen {
+		return 0, fmt.Errorf("system service is not open")
+	}
+
+	stored, err := s.store.ListKeys([]byte(temporaryTokenKeys.Prefix()))
+	if err != nil {
+		return 0, fmt.Errorf("failed to list tokens: %w", err)
+	}
+
+	now := time.Now()
+	purged := 0
+	for _, key := range stored {
+		id, _ := temporaryTokenKeys.Trim(key)
+		token, err := s.getTemporaryToken(id)
+		if err != nil || !token.Expired(now) {
+			continue
+		}
+		if err := s.RevokeTemporaryToken(id); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// hashTokenSecret returns the stored form of a token secret
+func hashTokenSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// TemporaryTokenProvider accepts tokens issued by the system API, sent either
+// as X-API-Key or as a bearer token
+type TemporaryTokenProvider struct {
+	SystemService SystemManager
+}
+
+// CredentialHint implements AuthProvider
+func (p *TemporaryTokenProvider) CredentialHint() string {
+	return "temporary token"
+}
+
+// Authenticate implements AuthProvider
+func (p *TemporaryTokenProvider) Authenticate(r *http.Request) (*Principal, error) {
+	value := r.Header.Get("X-API-Key")
+	if value == "" {
+		value, _ = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if !strings.HasPrefix(value, TemporaryTokenPrefix) {
+		return nil, errNoCredentials
+	}
+
+	token, err := p.SystemService.ValidateTemporaryToken(value)
+	switch {
+	case errors.Is(err, errTemporaryTokenExpired):
+		return nil, &AuthError{"Temporary token has expired"}
+	case errors.Is(err, errInvalidTemporaryToken):
+		return nil, &AuthError{"Invalid temporary token"}
+	case err != nil:
+		return nil, err
+	}
+
+	return &Principal{Subject: "token:" + token.ID, Method: authMethodTemporaryToken, Scopes: token.Scopes}, nil
+}
+
+// handleIssueToken godoc
+//
+//	@Summary		Issue a temporary token
+//	@Description	Exchange an admin credential for a short-lived token limited to the requested scopes
+//	@Tags			system
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		TemporaryTokenRequest	true	"Token scopes and lifetime"
+//	@Success		200		{object}	map[string]interface{}
+//	@Failure		400		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/system/tokens [post]
+//	@Security		ApiKeyAuth
+func (s *Server) handleIssueToken(w http.ResponseWriter, r *http.Request) {
+	principal, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		sendError(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+	// Tokens are leaves: they cannot be used to mint further tokens
+	if principal.Method == authMethodTemporaryToken {
+		sendError(w, "Temporary tokens cannot issue tokens", http.StatusForbidden)
+		return
+	}
+
+	var req TemporaryTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, "Invalid JSON request", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Scopes) == 0 {
+		sendError(w, "scopes are required", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if scope != ScopeRead && scope != ScopeWrite && scope != ScopeAdmin && scope != ScopeView {
+			sendError(w, fmt.Sprintf("Unknown scope: %s", scope), http.StatusBadRequest)
+			return
+		}
+		// Browsing is part of reading, so readers can hand out viewer tokens
+		if !principal.HasScope(scope) && !(scope == ScopeView && principal.HasScope(ScopeRead)) {
+			sendError(w, fmt.Sprintf("Cannot grant scope not held by caller: %s", scope), http.StatusForbidden)
+			return
+		}
+	}
+
+	ttl := DefaultTemporaryTokenTTL
+	if req.TTLSeconds != 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+	}
+	if ttl <= 0 || ttl > MaxTemporaryTokenTTL {
+		sendError(w, fmt.Sprintf("ttl_seconds must be between 1 and %d", int(MaxTemporaryTokenTTL.Seconds())),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Issuing is a natural point to sweep tokens nobody has presented since expiry
+	if _, err := s.systemService.PurgeExpiredTokens(); err != nil {
+		sendError(w, fmt.Sprintf("Failed to purge expired tokens: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	value, token, err := s.systemService.IssueTemporaryToken(principal.Subject, req.Scopes, ttl, req.Description)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to issue token: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, "token.issue", slog.String("id", token.ID), slog.Any("scopes", token.Scopes),
+		slog.Time("expires_at", token.ExpiresAt))
+	sendSuccess(w, map[string]interface{}{
+		"token":      value,
+		"id":         token.ID,
+		"scopes":     token.Scopes,
+		"expires_at": token.ExpiresAt,
+	})
+}
+
+// handleRevokeToken godoc
+//
+//	@Summary		Revoke a temporary token
+//	@Description	Delete a temporary token before it expires
+//	@Tags			system
+//	@Produce		json
+//	@Param			id	path		string	true	"Token ID"
+//	@Success		200	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Router			/system/tokens/{id} [delete]
+//	@Security		ApiKeyAuth
+func (s *Server) handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		sendError(w, "Token ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.systemService.RevokeTemporaryToken(id); err != nil {
+		sendError(w, fmt.Sprintf("Failed to revoke token: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, "token.revoke", slog.String("id", id))
+	sendSuccess(w, map[string]string{"message": "Token revoked successfully"})
+}
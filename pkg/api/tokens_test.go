@@ -0,0 +1,124 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemporaryTokens(t *testing.T) {
+	service, err := NewSystemService(SystemConfig{DataDir: t.TempDir()})
+	require.NoError(t, err)
+	require.NoError(t, service.Open())
+	defer service.Close()
+
+	value, token, err := service.IssueTemporaryToken("system-root", []string{ScopeRead}, time.Minute, "ci")
+	require.NoError(t, err)
+	assert.Contains(t, value, TemporaryTokenPrefix+token.ID+"_")
+
+	// The secret itself is never stored
+	stored, err := service.getTemporaryToken(token.ID)
+	require.NoError(t, err)
+	assert.NotContains(t, value, stored.SecretHash)
+
+	validated, err := service.ValidateTemporaryToken(value)
+	require.NoError(t, err)
+	assert.Equal(t, []string{ScopeRead}, validated.Scopes)
+
+	_, err = service.ValidateTemporaryToken(value + "0")
+	assert.ErrorIs(t, err, errInvalidTemporaryToken)
+	_, err = service.ValidateTemporaryToken(TemporaryTokenPrefix + "missing_secret")
+	assert.ErrorIs(t, err, errInvalidTemporaryToken)
+
+	// Expired tokens are rejected and removed
+	expired, expiredToken, err := service.IssueTemporaryToken("system-root", []string{ScopeRead}, -time.Second, "")
+	require.NoError(t, err)
+	_, err = service.ValidateTemporaryToken(expired)
+	assert.ErrorIs(t, err, errTemporaryTokenExpired)
+	_, err = service.getTemporaryToken(expiredToken.ID)
+	assert.Error(t, err)
+
+	_, _, err = service.IssueTemporaryToken("system-root", []string{ScopeRead}, -time.Second, "")
+	require.NoError(t, err)
+	purged, err := service.PurgeExpiredTokens()
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	require.NoError(t, service.RevokeTemporaryToken(token.ID))
+	_, err = service.ValidateTemporaryToken(value)
+	assert.ErrorIs(t, err, errInvalidTemporaryToken)
+}
+
+func TestIssueTokenHandler(t *testing.T) {
+	server, cleanup := setupSystemTestServer(t)
+	defer cleanup()
+
+	issue := func(principal *Principal, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/system/tokens", bytes.NewBufferString(body))
+		req = req.WithContext(context.WithValue(req.Context(), principalContextKey{}, principal))
+		w := httptest.NewRecorder()
+		server.handleIssueToken(w, req)
+		return w
+	}
+	admin := &Principal{Subject: "system-root", Method: AuthModeAPIKey, Scopes: systemScopes}
+
+	tests := []struct {
+		name      string
+		principal *Principal
+		body      string
+		want      int
+	}{
+		{"issue read token", admin, `{"scopes":["read"],"ttl_seconds":60}`, http.StatusOK},
+		{"missing scopes", admin, `{"ttl_seconds":60}`, http.StatusBadRequest},
+		{"unknown scope", admin, `{"scopes":["root"]}`, http.StatusBadRequest},
+		{"ttl too long", admin, `{"scopes":["read"],"ttl_seconds":999999}`, http.StatusBadRequest},
+		{"scope escalation", &Principal{Scopes: []string{ScopeRead, ScopeAdmin}}, `{"scopes":["write"]}`,
+			http.StatusForbidden},
+		{"token minting token", &Principal{Method: authMethodTemporaryToken, Scopes: systemScopes}, `{"scopes":["read"]}`,
+			http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, issue(tt.principal, tt.body).Code)
+		})
+	}
+
+	t.Run("issued token authenticates with its scopes", func(t *testing.T) {
+		w := issue(admin, `{"scopes":["read"]}`)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response struct {
+			Data struct {
+				Token string `json:"token"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+		providers, err := buildAuthProviders(server.config, server.systemService)
+		require.NoError(t, err)
+		ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+		handler := authMiddleware(providers...)(requireMethodScope(ok))
+
+		for method, want := range map[string]int{"GET": http.StatusOK, "PUT": http.StatusForbidden} {
+			req := httptest.NewRequest(method, "/kv/a", nil)
+			req.Header.Set("Authorization", "Bearer "+response.Data.Token)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, want, rec.Code, method)
+		}
+
+		req := httptest.NewRequest("GET", "/kv/a", nil)
+		req.Header.Set("X-API-Key", TemporaryTokenPrefix+"bogus_token")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
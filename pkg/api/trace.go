@@ -0,0 +1,126 @@
+package api
+
+import (
+	"time"
+)
+
+// Scan stages reported by a trace, in execution order
+const (
+	stageListKeys = "list_keys" // Matching keys in the primary index
+	stageFetch    = "fetch"     // Reading values from the store
+	stageDecode   = "decode"    // Decoding stored values
+	stageRedact   = "redact"    // Applying redaction transforms
+	stageWrite    = "write"     // Encoding and sending lines
+)
+
+// ScanTrace reports how a scan ran, so slow or surprising results can be
+// debugged from the response alone. It is sent in the summary line when a
+// scan asks for trace=true.
+type ScanTrace struct {
+	Plan           string        `json:"plan"`            // How keys were found
+	Prefix         string        `json:"prefix"`          // As sent, e.g. base64 with X-Key-Encoding
+	KeysMatched    int           `json:"keys_matched"`    // Keys the index returned for the prefix
+	KeysScanned    int           `json:"keys_scanned"`    // Keys visited before the scan stopped
+	RecordsFetched int           `json:"records_fetched"` // Values read from the store
+	RecordsMissing int           `json:"records_missing"` // Keys deleted between listing and reading
+	Filters        []FilterTrace `json:"filters,omitempty"`
+	Stages         []StageTrace  `json:"stages"`
+	TotalMs        float64       `json:"total_ms"`
+}
+
+// FilterTrace reports what one filter of a scan did
+type FilterTrace struct {
+	Filter  string `json:"filter"`            // e.g. skip_prefix=session: or redact
+	Skipped int    `json:"skipped,omitempty"` // Keys it left out
+	Applied int    `json:"applied,omitempty"` // Values it rewrote
+}
+
+// StageTrace is the time a scan spent in one stage
+type StageTrace struct {
+	Stage string  `json:"stage"`
+	Calls int     `json:"calls"`
+	Ms    float64 `json:"ms"`
+}
+
+// scanTracer collects a ScanTrace. Its methods do nothing on a nil tracer,
+// so untraced scans pay nothing.
+type scanTracer struct {
+	trace   ScanTrace
+	started time.Time
+	stages  map[string]*StageTrace
+}
+
+// newScanTracer starts a trace if the request asked for one
+func newScanTracer(enabled bool, plan, prefix string) *scanTracer {
+	if !enabled {
+		return nil
+	}
+	t := &scanTracer{started: time.Now(), stages: make(map[string]*StageTrace)}
+	t.trace.Plan, t.trace.Prefix = plan, prefix
+	for _, stage := range []string{stageListKeys, stageFetch, stageDecode, stageRedact, stageWrite} {
+		t.trace.Stages = append(t.trace.Stages, StageTrace{Stage: stage})
+	}
+	for i := range t.trace.Stages {
+		t.stages[t.trace.Stages[i].Stage] = &t.trace.Stages[i]
+	}
+	return t
+}
+
+// now returns the start time of a stage, or zero when not tracing
+func (t *scanTracer) now() time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// done records a call to stage that started at start
+func (t *scanTracer) done(stage string, start time.Time) {
+	if t == nil {
+		return
+	}
+	s := t.stages[stage]
+	s.Calls++
+	s.Ms += float64(time.Since(start)) / float64(time.Millisecond)
+}
+
+// filter returns the trace of a filter, adding it on first use
+func (t *scanTracer) filter(name string) *FilterTrace {
+	for i := range t.trace.Filters {
+		if t.trace.Filters[i].Filter == name {
+			return &t.trace.Filters[i]
+		}
+	}
+	t.trace.Filters = append(t.trace.Filters, FilterTrace{Filter: name})
+	return &t.trace.Filters[len(t.trace.Filters)-1]
+}
+
+// skipped records a key left out by the skip_prefix filter prefix
+func (t *scanTracer) skipped(prefix string) {
+	if t != nil {
+		t.filter("skip_prefix="+prefix).Skipped++
+	}
+}
+
+// redacted records a value rewritten by the redaction rules
+func (t *scanTracer) redacted() {
+	if t != nil {
+		t.filter("redact").Applied++
+	}
+}
+
+// update applies fn to the trace's counters
+func (t *scanTracer) update(fn func(*ScanTrace)) {
+	if t != nil {
+		fn(&t.trace)
+	}
+}
+
+// finish returns the completed trace, or nil when not tracing
+func (t *scanTracer) finish() *ScanTrace {
+	if t == nil {
+		return nil
+	}
+	t.trace.TotalMs = float64(time.Since(t.started)) / float64(time.Millisecond)
+	return &t.trace
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// ttlStore is implemented by stores that can expire values
+type ttlStore interface {
+	PutWithTTL(key, value []byte, ttl time.Duration) error
+}
+
+// expiryStore is implemented by stores that can report when a key expires
+type expiryStore interface {
+	KeyExpiry(key []byte) (time.Time, error)
+}
+
+// KeyTTLResponse reports when a key expires
+type KeyTTLResponse struct {
+	Key        string     `json:"key"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // Unset if the key never expires
+	TTLSeconds int64      `json:"ttl_seconds"`          // Seconds left, rounded up; -1 if the key never expires
+}
+
+// requestTTL parses a PUT's ttl query parameter: a duration such as "90s" or
+// "24h", or a whole number of seconds. It returns 0 when the value should
+// not expire.
+func requestTTL(r *http.Request) (time.Duration, error) {
+	raw := r.URL.Query().Get("ttl")
+	if raw == "" {
+		return 0, nil
+	}
+
+	ttl, err := time.ParseDuration(raw)
+	if err != nil {
+		seconds, convErr := strconv.ParseInt(raw, 10, 64)
+		if convErr != nil || seconds > int64(time.Duration(1<<63-1)/time.Second) {
+			return 0, fmt.Errorf("invalid ttl %q: use a duration such as 90s or a number of seconds", raw)
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+	if ttl <= 0 {
+		return 0, fmt.Errorf("invalid ttl %q: must be positive", raw)
+	}
+	return ttl, nil
+}
+
+// handleGetTTL godoc
+//
+//	@Summary		Get a key's time to live
+//	@Description	When the key expires and how many seconds it has left; ttl_seconds is -1 for a key without a TTL.
+//	@Tags			kv
+//	@Produce		json
+//	@Param			key				path		string	true	"Key"
+//	@Param			X-Key-Encoding	header		string	false	"base64 to send keys as base64"
+//	@Success		200				{object}	KeyTTLResponse
+//	@Failure		400				{object}	map[string]string
+//	@Failure		404				{object}	map[string]string
+//	@Failure		501				{object}	map[string]string
+//	@Router			/kv/{key}/ttl [get]
+//	@Security		ApiKeyAuth
+func (s *Server) handleGetTTL(w http.ResponseWriter, r *http.Request) {
+	if !s.awaitMinSeq(w, r) {
+		return
+	}
+	storeKey, err := s.requestKey(r)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	expiring, ok := s.store.(expiryStore)
+	if !ok {
+		sendError(w, "Store does not support TTLs", http.StatusNotImplemented)
+		return
+	}
+
+	expiresAt, err := expiring.KeyExpiry(storeKey)
+	if errors.Is(err, store.ErrKeyNotFound) {
+		sendError(w, "Key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to get TTL: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	resp := KeyTTLResponse{Key: chi.URLParam(r, "key"), TTLSeconds: -1}
+	if !expiresAt.IsZero() {
+		resp.ExpiresAt = &expiresAt
+		resp.TTLSeconds = int64(math.Ceil(time.Until(expiresAt).Seconds()))
+	}
+	sendSuccess(w, resp)
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlePut_TTL(t *testing.T) {
+	kvStore, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	require.NoError(t, err)
+	_, err = kvStore.Open()
+	require.NoError(t, err)
+	defer kvStore.Close()
+
+	put := func(server *Server, key, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/kv/"+key+query, strings.NewReader("value"))
+		req.Header.Set("Content-Type", "application/octet-stream")
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("key", key)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		w := httptest.NewRecorder()
+		server.handlePut(w, req)
+		return w
+	}
+
+	server := NewServer(kvStore, &SystemService{}, ServerConfig{}, NopMetrics{})
+	w := put(server, "session", "?ttl=100ms")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = put(server, "forever", "?ttl=3600")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	_, err = kvStore.Get([]byte("session"))
+	require.NoError(t, err)
+	time.Sleep(150 * time.Millisecond)
+	_, err = kvStore.Get([]byte("session"))
+	assert.ErrorIs(t, err, store.ErrKeyNotFound)
+	_, err = kvStore.Get([]byte("forever"))
+	assert.NoError(t, err, "a TTL in seconds should be an hour away")
+
+	for _, query := range []string{"?ttl=soon", "?ttl=-5s", "?ttl=0"} {
+		w = put(server, "bad", query)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+
+	// Stores without expiry can't honour a TTL
+	memory := NewServer(NewMemoryStore(), &SystemService{}, ServerConfig{}, NopMetrics{})
+	w = put(memory, "session", "?ttl=1h")
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+	w = put(memory, "session", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestHandleGetTTL(t *testing.T) {
+	kvStore, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	require.NoError(t, err)
+	_, err = kvStore.Open()
+	require.NoError(t, err)
+	defer kvStore.Close()
+
+	require.NoError(t, kvStore.PutWithTTL([]byte("session"), []byte("v"), 90*time.Second))
+	require.NoError(t, kvStore.Put([]byte("forever"), []byte("v")))
+
+	getTTL := func(server *Server, key string) (*httptest.ResponseRecorder, KeyTTLResponse) {
+		req := httptest.NewRequest(http.MethodGet, "/kv/"+key+"/ttl", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("key", key)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		w := httptest.NewRecorder()
+		server.handleGetTTL(w, req)
+
+		var body struct {
+			Data KeyTTLResponse `json:"data"`
+		}
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		}
+		return w, body.Data
+	}
+
+	server := NewServer(kvStore, &SystemService{}, ServerConfig{}, NopMetrics{})
+	w, resp := getTTL(server, "session")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "session", resp.Key)
+	assert.Equal(t, int64(90), resp.TTLSeconds)
+	require.NotNil(t, resp.ExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(90*time.Second), *resp.ExpiresAt, 2*time.Second)
+
+	w, resp = getTTL(server, "forever")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, int64(-1), resp.TTLSeconds)
+	assert.Nil(t, resp.ExpiresAt)
+
+	w, _ = getTTL(server, "missing")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	memory := NewServer(NewMemoryStore(), &SystemService{}, ServerConfig{}, NopMetrics{})
+	w, _ = getTTL(memory, "session")
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+}
@@ -4,15 +4,17 @@ package api
 
 import (
 	"context"
+	"time"
 
 	"github.com/ssargent/freyjadb/pkg/store"
 )
 
 // APIResponse represents a standard API response
 type APIResponse struct {
-	Success bool        `json:"success"`
-	Data    interface{} `json:"data,omitempty"`
-	Error   string      `json:"error,omitempty"`
+	Success   bool        `json:"success"`
+	Data      interface{} `json:"data,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	RequestID string      `json:"request_id,omitempty"` // Identifies a failed request in server logs
 }
 
 // RelationshipRequest represents a relationship creation/deletion request
@@ -22,15 +24,85 @@ type RelationshipRequest struct {
 	Relation string `json:"relation"`
 }
 
+// BulkRelationshipRequest is a batch of relationships created together
+type BulkRelationshipRequest struct {
+	Relationships []RelationshipRequest `json:"relationships"`
+}
+
+// BulkRelationshipResult is the outcome of one edge of a bulk request.
+// Status is "created", "invalid", or "rejected" for a valid edge that
+// wasn't written because another edge in the batch was invalid.
+type BulkRelationshipResult struct {
+	Index    int    `json:"index"`
+	FromKey  string `json:"from_key"`
+	ToKey    string `json:"to_key"`
+	Relation string `json:"relation"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// BulkRelationshipResponse reports every edge of a bulk request in order
+type BulkRelationshipResponse struct {
+	Created int                      `json:"created"`
+	Results []BulkRelationshipResult `json:"results"`
+	Seq     uint64                   `json:"seq,omitempty"` // Commit sequence including the batch, also sent as X-Freyja-Seq
+}
+
+// StoresResponse lists the stores a server's store manager has open and
+// the namespaces present on disk
+type StoresResponse struct {
+	Stores     []store.ManagedStoreStats `json:"stores"`
+	Namespaces []string                  `json:"namespaces"`
+}
+
+// SequenceResponse describes a block of IDs allocated from a sequence
+type SequenceResponse struct {
+	Name  string `json:"name"`
+	ID    uint64 `json:"id"`    // First ID of the block
+	Count int    `json:"count"` // IDs in the block; they run from ID to ID+Count-1
+}
+
 // ServerConfig holds configuration for the API server
 type ServerConfig struct {
-	Port                int
-	APIKey              string
-	SystemKey           string // System API key for administrative operations
-	DataDir             string
-	SystemDataDir       string // Directory for system KV store
-	SystemEncryptionKey string // Encryption key for system data
-	EnableEncryption    bool   // Whether to encrypt system data
+	Port                 int
+	APIKey               string
+	SystemKey            string // System API key for administrative operations
+	ViewerKey            string // API key that can only browse data, with values truncated
+	DataDir              string
+	SystemDataDir        string     // Directory for system KV store
+	SystemEncryptionKey  string     // Encryption key for system data
+	EnableEncryption     bool       // Whether to encrypt system data
+	Auth                 AuthConfig // Authentication providers (API keys by default)
+	MaxScanResults       int        // Cap on streamed scan results (DefaultMaxScanResults if zero)
+	MaxBulkRelationships int        // Cap on edges per bulk request (DefaultMaxBulkRelationships if zero)
+
+	// Expensive requests (scans, key listings, relationship queries,
+	// explains and log tails) each API key may have in flight; 0 means no
+	// limit. Requests over the limit wait up to ConcurrencyQueueTimeout for
+	// a slot, or are rejected at once if it is zero, with 429.
+	MaxConcurrentPerKey     int
+	ConcurrencyQueueTimeout time.Duration
+
+	// Read-your-writes: reads passing ?min_seq wait up to MinSeqWait
+	// (DefaultMinSeqWait if zero) for the store to reach that sequence, then
+	// are redirected to PrimaryURL if set, or fail with 503
+	MinSeqWait time.Duration
+	PrimaryURL string
+
+	ValuePipelines []ValuePipeline   // Transformations applied to values under key prefixes
+	PipelineKeys   map[string]string // Encryption keys for pipeline stages, by key ID
+
+	// How long a shutdown waits for in-flight requests before cutting them
+	// off (DefaultShutdownGracePeriod if zero)
+	ShutdownGracePeriod time.Duration
+
+	// Values longer than this are cut short for viewer keys and tokens
+	// (DefaultViewerMaxValueBytes if zero)
+	ViewerMaxValueBytes int
+
+	// Middleware orders the built-in middlewares and adds custom ones
+	// around them and after authentication (the default stack if zero)
+	Middleware MiddlewareConfig
 }
 
 // IKVStore defines the interface for the key-value store operations
@@ -42,10 +114,16 @@ type IKVStore interface {
 
 	// Relationship methods
 	PutRelationship(fromKey, toKey, relation string) error
+	PutRelationships([]store.Relationship) ([]error, error)
 	DeleteRelationship(fromKey, toKey, relation string) error
 	GetRelationships(store.RelationshipQuery) ([]store.RelationshipResult, error)
 
+	// Sequences
+	NextIDs(name string, count int) (uint64, error)
+
 	// Diagnostics
 	Explain(context.Context, store.ExplainOptions) (*store.ExplainResult, error)
 	Stats() *store.StoreStats
+	PrefixStats(prefix []byte) store.PrefixStats
+	EstimateCompaction() (*store.CompactionEstimate, error)
 }
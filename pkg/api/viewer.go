@@ -0,0 +1,103 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// DefaultViewerMaxValueBytes is how much of a value viewers see when
+// ServerConfig.ViewerMaxValueBytes is zero
+const DefaultViewerMaxValueBytes = 1024
+
+// ValueTruncatedHeader carries a value's full size on a Get whose value was
+// cut short for a viewer
+const ValueTruncatedHeader = "X-Value-Truncated"
+
+// viewerScopes are granted to the viewer key
+var viewerScopes = []string{ScopeView}
+
+// ViewerKeyProvider accepts the viewer X-API-Key, which can only browse.
+// Any other key is left to the next provider.
+type ViewerKeyProvider struct {
+	Key string
+}
+
+// CredentialHint implements AuthProvider
+func (p *ViewerKeyProvider) CredentialHint() string {
+	return "X-API-Key header"
+}
+
+// Authenticate implements AuthProvider
+func (p *ViewerKeyProvider) Authenticate(r *http.Request) (*Principal, error) {
+	apiKey := r.Header.Get("X-API-Key")
+	if apiKey == "" || subtle.ConstantTimeCompare([]byte(apiKey), []byte(p.Key)) != 1 {
+		return nil, errNoCredentials
+	}
+	return &Principal{Subject: "viewer", Method: AuthModeAPIKey, Scopes: viewerScopes}, nil
+}
+
+// isViewer reports whether the principal may only browse: it holds the
+// view scope but not read
+func isViewer(principal *Principal) bool {
+	return principal.HasScope(ScopeView) && !principal.HasScope(ScopeRead)
+}
+
+// guardViewers confines viewers to GET and HEAD requests and writes an
+// audit entry for every request a viewer makes, allowed or not, with the
+// response status and size
+func (s *Server) guardViewers(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok || !isViewer(principal) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(ww, r)
+		} else {
+			sendError(ww, "Viewer keys can only read", http.StatusForbidden)
+		}
+		s.audit(r, "viewer.access",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("query", r.URL.RawQuery),
+			slog.Int("status", ww.Status()),
+			slog.Int("bytes", ww.BytesWritten()),
+			slog.Duration("duration", time.Since(start)),
+		)
+	})
+}
+
+// viewerLimit returns how many bytes of each value the request's caller may
+// see, or 0 for callers who see values whole
+func (s *Server) viewerLimit(r *http.Request) int {
+	principal, ok := PrincipalFromContext(r.Context())
+	if !ok || !isViewer(principal) {
+		return 0
+	}
+	if s.config.ViewerMaxValueBytes > 0 {
+		return s.config.ViewerMaxValueBytes
+	}
+	return DefaultViewerMaxValueBytes
+}
+
+// truncateValue cuts a decoded value down to limit bytes, noting the full
+// size at the end. A cut JSON value is no longer JSON, so it becomes raw.
+// It reports whether the value was cut; a zero limit never cuts.
+func truncateValue(data []byte, contentType, limit int) ([]byte, int, bool) {
+	if limit <= 0 || len(data) <= limit {
+		return data, contentType, false
+	}
+	cut := make([]byte, 0, limit+48)
+	cut = append(cut, data[:limit]...)
+	cut = fmt.Appendf(cut, "... (truncated, %d bytes)", len(data))
+	return cut, ContentTypeRaw, true
+}
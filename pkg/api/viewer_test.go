@@ -0,0 +1,104 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestViewerKey(t *testing.T) {
+	kv, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	require.NoError(t, err)
+	_, err = kv.Open()
+	require.NoError(t, err)
+	defer kv.Close()
+	systemService, err := NewSystemServiceWithStore(SystemConfig{}, NewMemoryStore())
+	require.NoError(t, err)
+
+	var logs bytes.Buffer
+	handler, err := NewHandler(kv, ServerConfig{
+		SystemKey:           "root-key",
+		ViewerKey:           "viewer-key",
+		ViewerMaxValueBytes: 8,
+	}, Dependencies{
+		SystemService: systemService,
+		Metrics:       NopMetrics{},
+		Logger:        slog.New(slog.NewJSONHandler(&logs, nil)),
+	})
+	require.NoError(t, err)
+	do := func(method, target, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusOK, do(http.MethodPut, "/api/v1/kv/user:1", "root-key", `{"name":"a long name"}`).Code)
+	require.Equal(t, http.StatusOK, do(http.MethodPut, "/api/v1/kv/user:2", "root-key", "short").Code)
+
+	// Viewers read values cut short, and whole ones under the limit
+	w := do(http.MethodGet, "/api/v1/kv/user:1", "viewer-key", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, `{"name":... (truncated, 22 bytes)`, w.Body.String())
+	assert.Equal(t, "22", w.Header().Get(ValueTruncatedHeader))
+	assert.Equal(t, "application/octet-stream", w.Header().Get("Content-Type"))
+	w = do(http.MethodGet, "/api/v1/kv/user:2", "viewer-key", "")
+	assert.Equal(t, "short", w.Body.String())
+	assert.Empty(t, w.Header().Get(ValueTruncatedHeader))
+
+	w = do(http.MethodGet, "/api/v1/scan?prefix=user:", "viewer-key", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var first ScanItem
+	require.NoError(t, json.Unmarshal([]byte(strings.SplitN(w.Body.String(), "\n", 2)[0]), &first))
+	assert.Equal(t, `{"name":... (truncated, 22 bytes)`, first.Value)
+
+	// Other keys see values whole
+	w = do(http.MethodGet, "/api/v1/kv/user:1", "root-key", "")
+	assert.Equal(t, `{"name":"a long name"}`, w.Body.String())
+
+	// Viewers can't write or administer
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPut, "/api/v1/kv/user:3", "viewer-key", "x").Code)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodDelete, "/api/v1/kv/user:1", "viewer-key", "").Code)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/api/v1/system/api-keys", "viewer-key", "").Code)
+	_, err = kv.Get([]byte("user:3"))
+	assert.ErrorIs(t, err, store.ErrKeyNotFound)
+
+	// Every viewer request is audited, allowed or not
+	var audited []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		if entry["action"] == "viewer.access" {
+			audited = append(audited, entry)
+		}
+	}
+	require.Len(t, audited, 6)
+	assert.Equal(t, "viewer", audited[0]["subject"])
+	assert.Equal(t, "/api/v1/kv/user:1", audited[0]["path"])
+	assert.Equal(t, float64(http.StatusOK), audited[0]["status"])
+	assert.Equal(t, "PUT", audited[3]["method"])
+	assert.Equal(t, float64(http.StatusForbidden), audited[3]["status"])
+
+	// Readers can hand out viewer tokens
+	w = do(http.MethodPost, "/api/v1/system/tokens", "root-key", `{"scopes":["view"]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var issued struct {
+		Data struct {
+			Token string `json:"token"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &issued))
+	w = do(http.MethodGet, "/api/v1/kv/user:1", issued.Data.Token, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "22", w.Header().Get(ValueTruncatedHeader))
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPut, "/api/v1/kv/user:3", issued.Data.Token, "x").Code)
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ssargent/freyjadb/pkg/query"
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// watchStore is implemented by stores that report their writes as they
+// are made
+type watchStore interface {
+	Watch(opts store.WatchOptions) (*store.Watcher, error)
+}
+
+// WatchEvent is one line of a watch stream. Deletes have no value.
+type WatchEvent struct {
+	Key         string      `json:"key"`
+	Value       interface{} `json:"value,omitempty"`
+	ContentType string      `json:"content_type,omitempty"`
+	Deleted     bool        `json:"deleted,omitempty"`
+	Timestamp   uint64      `json:"timestamp"`
+}
+
+// handleWatch godoc
+//
+//	@Summary		Watch writes
+//	@Description	Stream the Puts and Deletes made from now on as newline-delimited JSON, until the limit is
+//	@Description	reached or the client disconnects. Only keys under prefix are sent, and with where
+//	@Description	parameters only JSON values whose fields satisfy every predicate (field=value, field>value,
+//	@Description	field>=value, field<value or field<=value; the value is JSON if it parses, else a string).
+//	@Description	Deletes under the prefix are always sent, since there is no value to test. A subscriber
+//	@Description	that falls behind is disconnected with an error in the summary line.
+//	@Tags			kv
+//	@Produce		x-ndjson
+//	@Param			prefix	query		string		false	"Key prefix"
+//	@Param			where	query		[]string	false	"Field predicate, e.g. age>=30; repeat to require several"
+//	@Param			limit	query		int			false	"Stop after this many events"
+//	@Param			X-Key-Encoding	header		string	false	"base64 to send and receive keys as base64"
+//	@Success		200		{object}	WatchEvent
+//	@Failure		400		{object}	map[string]string
+//	@Failure		501		{object}	map[string]string
+//	@Router			/watch [get]
+//	@Security		ApiKeyAuth
+func (s *Server) handleWatch(w http.ResponseWriter, r *http.Request) {
+	watchable, ok := s.store.(watchStore)
+	if !ok {
+		sendError(w, "Store does not support watches", http.StatusNotImplemented)
+		return
+	}
+
+	codec, err := requestKeyCodec(r)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	prefix, err := codec.prefix(r)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	predicates, err := parsePredicates(r.URL.Query()["where"])
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := queryInt(r, "limit", 0)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	watcher, err := watchable.Watch(store.WatchOptions{Prefix: prefix})
+	if errors.Is(err, store.ErrReadOnly) {
+		sendError(w, "A standby store can't be watched; watch the primary", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to watch: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer watcher.Close()
+
+	viewLimit := s.viewerLimit(r)
+	out := newNDJSONWriter(w)
+	out.Flush()
+
+	summary := StreamSummary{}
+	for limit == 0 || summary.Count < limit {
+		var event store.ChangeEvent
+		select {
+		case <-r.Context().Done():
+			return // Client went away; nobody is left to read a summary
+		case event, ok = <-watcher.Events():
+		}
+		if !ok {
+			if err := watcher.Err(); err != nil {
+				summary.Error = err.Error()
+			}
+			break
+		}
+
+		item, match, err := s.watchEvent(event, codec, predicates, viewLimit)
+		if err != nil {
+			summary.Error = fmt.Sprintf("failed to decode key %s: %v", codec.encode(string(event.Key)), err)
+			break
+		}
+		if !match {
+			continue
+		}
+		if err := out.Write(item); err != nil {
+			return
+		}
+		out.Flush()
+		summary.Count++
+	}
+	summary.Truncated = limit > 0 && summary.Count == limit
+	out.Finish(summary)
+}
+
+// watchEvent decodes a change for the stream, reporting whether it
+// satisfies every predicate. Only JSON values can; deletes always do.
+// Values are cut to viewLimit bytes if positive, after the predicates see
+// them whole.
+func (s *Server) watchEvent(event store.ChangeEvent, codec keyCodec,
+	predicates []query.FieldQuery, viewLimit int) (WatchEvent, bool, error) {
+	item := WatchEvent{Key: codec.encode(string(event.Key)), Deleted: event.Deleted, Timestamp: event.Timestamp}
+	if event.Deleted {
+		return item, true, nil
+	}
+
+	data, contentType, err := s.decodeValue(event.Value)
+	if err != nil {
+		return item, false, err
+	}
+	if len(predicates) > 0 {
+		if contentType != ContentTypeJSON {
+			return item, false, nil
+		}
+		extractor := &query.JSONFieldExtractor{}
+		for _, predicate := range predicates {
+			value, err := extractor.Extract(data, predicate.Field)
+			if err != nil || !predicate.Matches(value) {
+				return item, false, nil
+			}
+		}
+	}
+	data, contentType, _ = truncateValue(data, contentType, viewLimit)
+	item.Value, item.ContentType = scanData(data, contentType)
+	return item, true, nil
+}
+
+// parsePredicates reads where parameters of the form field<op>value as
+// query conditions. The value is a JSON number, boolean or string if it
+// parses as one, and otherwise taken as a bare string.
+func parsePredicates(raw []string) ([]query.FieldQuery, error) {
+	predicates := make([]query.FieldQuery, 0, len(raw))
+	for _, where := range raw {
+		i := strings.IndexAny(where, "=<>")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid predicate %q: want field<op>value", where)
+		}
+		op := where[i : i+1]
+		if op != "=" && strings.HasPrefix(where[i+1:], "=") {
+			op += "="
+		}
+
+		predicate := query.FieldQuery{Field: where[:i], Operator: op, Value: where[i+len(op):]}
+		var value interface{}
+		if json.Unmarshal([]byte(where[i+len(op):]), &value) == nil {
+			switch value.(type) {
+			case float64, bool, string:
+				predicate.Value = value
+			}
+		}
+		if err := predicate.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid predicate %q: %w", where, err)
+		}
+		predicates = append(predicates, predicate)
+	}
+	return predicates, nil
+}
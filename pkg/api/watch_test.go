@@ -0,0 +1,82 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleWatch(t *testing.T) {
+	kvStore, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	require.NoError(t, err)
+	_, err = kvStore.Open()
+	require.NoError(t, err)
+	defer kvStore.Close()
+
+	server := NewServer(kvStore, &SystemService{}, ServerConfig{}, nil)
+	ts := httptest.NewServer(http.HandlerFunc(server.handleWatch))
+	defer ts.Close()
+
+	// The response starts once the watch is registered
+	resp, err := http.Get(ts.URL + "/watch?prefix=user:&where=age>=30&where=status=active&limit=2")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, ContentTypeNDJSON, resp.Header.Get("Content-Type"))
+
+	putJSON := func(key, value string) {
+		require.NoError(t, kvStore.Put([]byte(key), encodeDataWithContentType([]byte(value), ContentTypeJSON)))
+	}
+	putJSON("user:1", `{"age":25,"status":"active"}`)
+	putJSON("order:1", `{"age":50,"status":"active"}`)
+	require.NoError(t, kvStore.Put([]byte("user:3"), encodeDataWithContentType([]byte("raw"), ContentTypeRaw)))
+	putJSON("user:2", `{"age":40,"status":"active"}`)
+	require.NoError(t, kvStore.Delete([]byte("user:1")))
+
+	lines := bufio.NewScanner(resp.Body)
+	var events []WatchEvent
+	for i := 0; i < 2 && lines.Scan(); i++ {
+		var event WatchEvent
+		require.NoError(t, json.Unmarshal(lines.Bytes(), &event))
+		events = append(events, event)
+	}
+	require.Len(t, events, 2)
+	assert.Equal(t, "user:2", events[0].Key)
+	assert.Equal(t, map[string]interface{}{"age": float64(40), "status": "active"}, events[0].Value)
+	assert.Equal(t, "application/json", events[0].ContentType)
+	assert.Equal(t, WatchEvent{Key: "user:1", Deleted: true, Timestamp: events[1].Timestamp}, events[1])
+
+	require.True(t, lines.Scan())
+	var summary streamSummaryLine
+	require.NoError(t, json.Unmarshal(lines.Bytes(), &summary))
+	assert.Equal(t, StreamSummary{Count: 2, Truncated: true}, summary.Summary)
+
+	// Malformed predicates are rejected before the stream starts
+	bad, err := http.Get(ts.URL + "/watch?where=age~30")
+	require.NoError(t, err)
+	bad.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, bad.StatusCode)
+}
+
+func TestParsePredicates(t *testing.T) {
+	predicates, err := parsePredicates([]string{"age>=30", "name=bob", "admin=true", "score<1.5", `id="42"`})
+	require.NoError(t, err)
+	require.Len(t, predicates, 5)
+	assert.Equal(t, ">=", predicates[0].Operator)
+	assert.Equal(t, float64(30), predicates[0].Value)
+	assert.Equal(t, "bob", predicates[1].Value)
+	assert.Equal(t, true, predicates[2].Value)
+	assert.Equal(t, "<", predicates[3].Operator)
+	assert.Equal(t, "42", predicates[4].Value)
+
+	for _, bad := range []string{"age", ">=30", "=5"} {
+		_, err := parsePredicates([]string{bad})
+		assert.Error(t, err, bad)
+	}
+}
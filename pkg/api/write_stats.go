@@ -0,0 +1,45 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// writeStatsStore is implemented by stores that count writes per key
+// prefix
+type writeStatsStore interface {
+	WriteStats() []store.PrefixWriteStats
+}
+
+// handleWriteStats godoc
+//
+//	@Summary		Get write rates per key prefix
+//	@Description	Puts and deletes of every key prefix over the last minute, 5 minutes and hour, busiest in
+//	@Description	the last minute first, to spot write surges such as a runaway job. Prefixes with a write
+//	@Description	limit report it and how many writes it rejected.
+//	@Tags			diagnostics
+//	@Produce		json
+//	@Success		200	{array}		store.PrefixWriteStats
+//	@Failure		501	{object}	map[string]string
+//	@Router			/stats/writes [get]
+//	@Security		ApiKeyAuth
+func (s *Server) handleWriteStats(w http.ResponseWriter, r *http.Request) {
+	counted, ok := s.store.(writeStatsStore)
+	if !ok {
+		sendError(w, "Store does not count writes", http.StatusNotImplemented)
+		return
+	}
+	sendSuccess(w, counted.WriteStats())
+}
+
+// writeErrorStatus returns the status of a failed write: 429 with a
+// Retry-After for a prefix over its write limit, otherwise 500
+func writeErrorStatus(w http.ResponseWriter, err error) int {
+	if errors.Is(err, store.ErrWriteRateExceeded) {
+		w.Header().Set("Retry-After", "1")
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
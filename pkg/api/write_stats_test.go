@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteStats(t *testing.T) {
+	kv, err := store.NewKVStore(store.KVStoreConfig{
+		DataDir:     t.TempDir(),
+		WriteLimits: []store.PrefixWriteLimit{{Prefix: "job:", MaxPerMinute: 2}},
+	})
+	require.NoError(t, err)
+	_, err = kv.Open()
+	require.NoError(t, err)
+	defer kv.Close()
+	systemService, err := NewSystemServiceWithStore(SystemConfig{}, NewMemoryStore())
+	require.NoError(t, err)
+
+	handler, err := NewHandler(kv, ServerConfig{SystemKey: "root-key"}, Dependencies{
+		SystemService: systemService,
+		Metrics:       NopMetrics{},
+	})
+	require.NoError(t, err)
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("X-API-Key", "root-key")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusOK, do(http.MethodPut, "/api/v1/kv/user:1", "a").Code)
+	require.Equal(t, http.StatusOK, do(http.MethodPut, "/api/v1/kv/job:1", "a").Code)
+	require.Equal(t, http.StatusOK, do(http.MethodDelete, "/api/v1/kv/job:1", "").Code)
+
+	// The limited prefix is paused, others are not
+	w := do(http.MethodPut, "/api/v1/kv/job:2", "a")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, w.Body.String())
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusTooManyRequests, do(http.MethodDelete, "/api/v1/kv/job:1", "").Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodPut, "/api/v1/kv/user:2", "a").Code)
+
+	w = do(http.MethodGet, "/api/v1/stats/writes", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response struct {
+		Data []store.PrefixWriteStats `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(t, response.Data, 2)
+	assert.Equal(t, store.PrefixWriteStats{
+		Prefix: "job:", LastMinute: 2, Last5Minutes: 2, LastHour: 2, PerSecond: 2.0 / 60,
+		LimitPerMinute: 2, Throttled: 2,
+	}, response.Data[0])
+	assert.Equal(t, "user:", response.Data[1].Prefix)
+	assert.Equal(t, int64(2), response.Data[1].LastMinute)
+}
@@ -5,21 +5,38 @@
 package bptree
 
 import (
-	"bytes"
+	"bufio"
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/segmentio/ksuid"
 )
 
 // DefaultOrder is the fallback branching factor if a user-supplied order is too small.
 const DefaultOrder = 4
 
+// fileMagic starts tree files that carry a header naming their comparator.
+// Older files start directly with the order, which is never this large, and
+// are read as bytewise.
+const fileMagic uint32 = 0x54504246 // "FBPT"
+
+// fileVersion is the version of the tree file header. Version 2 added the
+// flags word and the extra values of trees with duplicate keys; version 3
+// the checksum at the end of the file; version 4 values of any length.
+const fileVersion uint32 = 4
+
+// legacyValueLen is the size of every value in files before version 4,
+// when values were KSUIDs
+const legacyValueLen = 20
+
+// fileFlagDuplicates marks files of trees created WithDuplicates
+const fileFlagDuplicates uint32 = 1
+
 // findChildIndex determines which child pointer to follow for a given search key in an internal node.
 // This implements the B+Tree navigation logic where:
 // - For internal node with keys [k1, k2, ..., kn] and children [c0, c1, ..., cn]
@@ -28,11 +45,12 @@ const DefaultOrder = 4
 // - ...
 // - If searchKey >= kn, return n (follow cn)
 //
+// Keys are ordered by compare, the tree's comparator.
 // Uses linear search for simplicity; could be optimized with binary search for large orders.
 // Time complexity: O(order)
-func findChildIndex(keys [][]byte, searchKey []byte) int {
+func findChildIndex(compare func(a, b []byte) int, keys [][]byte, searchKey []byte) int {
 	for i, k := range keys {
-		if bytes.Compare(searchKey, k) < 0 {
+		if compare(searchKey, k) < 0 {
 			return i
 		}
 	}
@@ -48,6 +66,7 @@ func findChildIndex(keys [][]byte, searchKey []byte) int {
 // - Implements latch coupling for efficient tree traversal
 // - All keys are stored in leaf nodes, internal nodes contain separator keys
 // - Supports range scans via leaf node linking
+// - Orders keys with a configurable Comparator, bytewise by default
 //
 // The tree maintains the following invariants:
 // - All leaves are at the same level (perfect balance)
@@ -57,8 +76,14 @@ func findChildIndex(keys [][]byte, searchKey []byte) int {
 type BPlusTree struct {
 	root             *node        // Root node of the tree
 	order            int          // Maximum number of keys per node
+	comparator       Comparator   // Orders keys; fixed for the tree's lifetime
+	duplicates       bool         // Keys hold a set of values rather than one
 	height           int          // Height of the tree (1 for single leaf)
 	m                sync.RWMutex // Protects root and height modifications
+	size             atomic.Int64 // Values in the tree
+	memory           atomic.Int64 // Estimated memory of nodes, keys and values in bytes
+	memoryLimit      int64        // Cap on memory for TryInsert; 0 = none
+	spill            SpillFunc    // Makes room for TryInsert at the limit; may be nil
 	checkpointTicker *time.Ticker // Ticker for periodic checkpoints
 	checkpointDone   chan bool    // Channel to stop checkpointing
 }
@@ -84,42 +109,58 @@ func (tree *BPlusTree) Height() int {
 // For leaf nodes (isLeaf):
 // - keys: the actual data keys
 // - children: nil (not used)
-// - values: the corresponding values for each key
+// - values: the corresponding values for each key, arbitrary bytes
+// - extra: in trees with duplicates, each key's further values, ascending
 // - next: pointer to the next leaf node for range scan support
 //
 // Thread safety: Each node has its own RWMutex that protects all its fields.
 // Multiple readers can access a node simultaneously, but writers get exclusive access.
 type node struct {
-	mutex    sync.RWMutex   // Per-node latch for concurrency control
-	isLeaf   bool           // True if this is a leaf node, false for internal node
-	keys     [][]byte       // Keys stored in this node
-	children []*node        // Child nodes (internal nodes only)
-	values   []*ksuid.KSUID // Values corresponding to keys (leaf nodes only)
-	parent   *node          // Parent node (nil for root)
-	next     *node          // Next leaf node for range scans (leaf nodes only)
+	mutex    sync.RWMutex // Per-node latch for concurrency control
+	isLeaf   bool         // True if this is a leaf node, false for internal node
+	keys     [][]byte     // Keys stored in this node
+	children []*node      // Child nodes (internal nodes only)
+	values   [][]byte     // Values corresponding to keys (leaf nodes only)
+	extra    [][][]byte   // Values after the first for each key (duplicate trees only)
+	parent   *node        // Parent node (nil for root)
+	next     *node        // Next leaf node for range scans (leaf nodes only)
 }
 
 // NewBPlusTree creates and returns a B+Tree with the given order.
 // If the specified order < 3, we fall back to DefaultOrder.
-func NewBPlusTree(order int) *BPlusTree {
+// Keys are ordered bytewise unless WithComparator says otherwise.
+func NewBPlusTree(order int, opts ...Option) *BPlusTree {
 	if order < 3 {
 		order = DefaultOrder
 	}
 	rootNode := &node{
 		isLeaf:   true,
 		keys:     make([][]byte, 0, order),
-		values:   make([]*ksuid.KSUID, 0, order),
+		values:   make([][]byte, 0, order),
 		children: make([]*node, 0),
 	}
-	return &BPlusTree{
-		root:   rootNode,
-		order:  order,
-		height: 1,
+	tree := &BPlusTree{
+		root:       rootNode,
+		order:      order,
+		comparator: Bytewise,
+		height:     1,
+	}
+	for _, opt := range opts {
+		opt(tree)
 	}
+	tree.memory.Store(nodeOverhead)
+	return tree
+}
+
+// compare orders two keys with the tree's comparator
+func (tree *BPlusTree) compare(a, b []byte) int {
+	return tree.comparator.Compare(a, b)
 }
 
 // Search performs a point lookup for the given key in the B+Tree.
 // Returns the associated value and true if the key exists, or nil and false if not found.
+// The value is the tree's own and must not be modified.
+// In a tree with duplicates it returns the key's smallest value; use Values for all of them.
 //
 // This method is thread-safe and can be called concurrently with other operations.
 // It uses latch coupling for efficient traversal:
@@ -130,7 +171,7 @@ func NewBPlusTree(order int) *BPlusTree {
 //
 // Time complexity: O(log n) for tree traversal + O(order) for leaf search
 // Space complexity: O(1) additional space
-func (tree *BPlusTree) Search(key []byte) (*ksuid.KSUID, bool) {
+func (tree *BPlusTree) Search(key []byte) ([]byte, bool) {
 	tree.m.RLock()
 	current := tree.root
 	if current == nil {
@@ -145,7 +186,7 @@ func (tree *BPlusTree) Search(key []byte) (*ksuid.KSUID, bool) {
 	// Traverse from root to leaf using latch coupling
 	// Latch coupling ensures we always hold at least one lock during traversal
 	for !current.isLeaf {
-		idx := findChildIndex(current.keys, key)
+		idx := findChildIndex(tree.compare, current.keys, key)
 		child := current.children[idx]
 
 		// Latch coupling: acquire child's read lock BEFORE releasing parent's lock
@@ -160,7 +201,7 @@ func (tree *BPlusTree) Search(key []byte) (*ksuid.KSUID, bool) {
 	// We're now at a leaf, holding its read lock
 	// Search its keys
 	for i, k := range current.keys {
-		if bytes.Equal(key, k) {
+		if tree.compare(key, k) == 0 {
 			val := current.values[i]
 			// Release leaf lock and return
 			current.mutex.RUnlock()
@@ -175,6 +216,12 @@ func (tree *BPlusTree) Search(key []byte) (*ksuid.KSUID, bool) {
 
 // Insert adds or updates a key-value pair in the B+Tree.
 // If the key already exists, its value is updated. If the key is new, it's inserted.
+// In a tree with duplicates the value is added to the key's values instead,
+// unless the key already holds it.
+//
+// Values are arbitrary bytes, e.g. record offsets or primary keys; nil and
+// empty values are the same. The key and value are kept, not copied, so the
+// caller must not modify them afterwards.
 //
 // This method is thread-safe and can be called concurrently with other operations.
 // It uses a hybrid locking strategy for optimal concurrency:
@@ -191,7 +238,7 @@ func (tree *BPlusTree) Search(key []byte) (*ksuid.KSUID, bool) {
 //
 // Time complexity: O(log n) for traversal + O(order) for insertion/splitting
 // Space complexity: O(order) for temporary operations during splitting
-func (tree *BPlusTree) Insert(key []byte, value ksuid.KSUID) {
+func (tree *BPlusTree) Insert(key, value []byte) {
 	tree.m.RLock()
 	// If there's no root, create one (edge case)
 	if tree.root == nil {
@@ -201,9 +248,13 @@ func (tree *BPlusTree) Insert(key []byte, value ksuid.KSUID) {
 			tree.root = &node{
 				isLeaf: true,
 				keys:   [][]byte{key},
-				values: []*ksuid.KSUID{&value},
+				values: [][]byte{value},
+			}
+			if tree.duplicates {
+				tree.root.extra = [][][]byte{nil}
 			}
 			tree.height = 1
+			tree.account(1, nodeOverhead+tree.entryCost(key, value))
 		}
 		tree.m.Unlock()
 		return
@@ -214,7 +265,7 @@ func (tree *BPlusTree) Insert(key []byte, value ksuid.KSUID) {
 	tree.m.RUnlock()
 	// Traverse down with read locks until we reach a leaf
 	for !current.isLeaf {
-		idx := findChildIndex(current.keys, key)
+		idx := findChildIndex(tree.compare, current.keys, key)
 		child := current.children[idx]
 
 		// Acquire child's read lock before releasing parent's read lock
@@ -231,7 +282,7 @@ func (tree *BPlusTree) Insert(key []byte, value ksuid.KSUID) {
 	defer current.mutex.Unlock()
 
 	// Insert the key/value in sorted order
-	insertKeyValueInLeaf(current, key, &value)
+	tree.insertKeyValueInLeaf(current, key, value)
 
 	// Check overflow
 	if len(current.keys) > tree.order {
@@ -243,6 +294,8 @@ func (tree *BPlusTree) Insert(key []byte, value ksuid.KSUID) {
 
 // Delete removes a key-value pair from the B+Tree if the key exists.
 // Returns true if the key was found and removed, false if the key was not found.
+// In a tree with duplicates every value of the key is removed; DeleteValue
+// removes one.
 //
 // This method is thread-safe and can be called concurrently with other operations.
 // It uses the same locking strategy as Insert:
@@ -269,7 +322,7 @@ func (tree *BPlusTree) Delete(key []byte) bool {
 
 	// Traverse down with read locks until we reach a leaf
 	for !current.isLeaf {
-		idx := findChildIndex(current.keys, key)
+		idx := findChildIndex(tree.compare, current.keys, key)
 		child := current.children[idx]
 
 		// Latch coupling: acquire child's read lock before releasing the current node
@@ -286,10 +339,8 @@ func (tree *BPlusTree) Delete(key []byte) bool {
 
 	// Find and remove the key
 	for i, k := range current.keys {
-		if bytes.Equal(key, k) {
-			// Remove the key and value
-			current.keys = append(current.keys[:i], current.keys[i+1:]...)
-			current.values = append(current.values[:i], current.values[i+1:]...)
+		if tree.compare(key, k) == 0 {
+			tree.removeFromLeaf(current, i)
 			return true
 		}
 	}
@@ -298,27 +349,54 @@ func (tree *BPlusTree) Delete(key []byte) bool {
 	return false
 }
 
+// Ascend calls fn for each key in [start, end) in ascending order, stopping
+// early if fn returns false. A nil end means no upper bound. In a tree with
+// duplicates fn is called once per value, a key's values in ascending order.
+//
+// This method is thread-safe. It walks a RangeScan cursor, so fn is called
+// with no locks held and may safely call back into the tree.
+//
+// Time complexity: O(log n + k) for k visited keys
+func (tree *BPlusTree) Ascend(start, end []byte, fn func(key, value []byte) bool) {
+	c := tree.RangeScan(start, end)
+	defer c.Close()
+	for c.Next() {
+		if !fn(c.Key(), c.Value()) {
+			return
+		}
+	}
+}
+
 // insertKeyValueInLeaf inserts a key-value pair into a leaf node at the correct sorted position.
-// If the key already exists, it updates the value. The leaf node must be locked exclusively.
+// If the key already exists, it updates the value, or in a tree with duplicates adds it to the
+// key's values. The leaf node must be locked exclusively.
 //
 // Algorithm:
 // 1. Find the insertion point using binary search (linear scan here for simplicity)
-// 2. If key exists, update the value in place
+// 2. If key exists, update the value in place (or add it to the key's values)
 // 3. If key is new, make room by shifting elements and insert at the correct position
 //
-// This maintains the sorted order invariant of B+Tree leaf nodes.
-func insertKeyValueInLeaf(leaf *node, key []byte, value *ksuid.KSUID) {
+// This maintains the sorted order invariant of B+Tree leaf nodes, as defined by the comparator.
+func (tree *BPlusTree) insertKeyValueInLeaf(leaf *node, key, value []byte) {
 	// Find insertion point (could be optimized with binary search)
 	idx := 0
-	for idx < len(leaf.keys) && bytes.Compare(leaf.keys[idx], key) < 0 {
+	for idx < len(leaf.keys) && tree.compare(leaf.keys[idx], key) < 0 {
 		idx++
 	}
 
 	// Check if the key already exists at this position
-	if idx < len(leaf.keys) && bytes.Equal(leaf.keys[idx], key) {
+	if idx < len(leaf.keys) && tree.compare(leaf.keys[idx], key) == 0 {
+		if tree.duplicates {
+			if addDuplicate(leaf, idx, value) {
+				tree.account(1, valueCost(value))
+			}
+			return
+		}
+		tree.account(0, int64(len(value)-len(leaf.values[idx])))
 		leaf.values[idx] = value // Update existing value
 		return
 	}
+	tree.account(1, tree.entryCost(key, value))
 
 	// Insert new key-value pair
 	// First, append placeholders to extend slices
@@ -332,6 +410,31 @@ func insertKeyValueInLeaf(leaf *node, key []byte, value *ksuid.KSUID) {
 	// Insert the new key-value pair at the correct position
 	leaf.keys[idx] = key
 	leaf.values[idx] = value
+
+	if tree.duplicates {
+		leaf.extra = append(leaf.extra, nil)
+		copy(leaf.extra[idx+1:], leaf.extra[idx:])
+		leaf.extra[idx] = nil
+	}
+}
+
+// removeFromLeaf removes the key at i and all its values from a leaf. The
+// leaf node must be locked exclusively.
+func (tree *BPlusTree) removeFromLeaf(leaf *node, i int) {
+	values, bytes := int64(1), tree.entryCost(leaf.keys[i], leaf.values[i])
+	if leaf.extra != nil {
+		for _, value := range leaf.extra[i] {
+			values++
+			bytes += valueCost(value)
+		}
+	}
+	tree.account(-values, -bytes)
+
+	leaf.keys = append(leaf.keys[:i], leaf.keys[i+1:]...)
+	leaf.values = append(leaf.values[:i], leaf.values[i+1:]...)
+	if leaf.extra != nil {
+		leaf.extra = append(leaf.extra[:i], leaf.extra[i+1:]...)
+	}
 }
 
 // splitLeaf handles splitting a leaf node that has overflowed after insertion.
@@ -355,16 +458,22 @@ func (tree *BPlusTree) splitLeaf(leaf *node) {
 	// Create new leaf node with right half of keys and values
 	newLeaf := &node{
 		isLeaf: true,
-		keys:   append(make([][]byte, 0), leaf.keys[mid:]...),         // Copy right half of keys
-		values: append(make([]*ksuid.KSUID, 0), leaf.values[mid:]...), // Copy right half of values
-		next:   leaf.next,                                             // Link to the original next leaf
+		keys:   append(make([][]byte, 0), leaf.keys[mid:]...),   // Copy right half of keys
+		values: append(make([][]byte, 0), leaf.values[mid:]...), // Copy right half of values
+		next:   leaf.next,                                       // Link to the original next leaf
 		parent: leaf.parent,
 	}
 
+	if leaf.extra != nil {
+		newLeaf.extra = append(make([][][]byte, 0), leaf.extra[mid:]...)
+		leaf.extra = leaf.extra[:mid]
+	}
+
 	// Adjust the original leaf to contain only left half
 	leaf.keys = leaf.keys[:mid]
 	leaf.values = leaf.values[:mid]
 	leaf.next = newLeaf // Update linked list pointer
+	tree.account(0, nodeOverhead)
 
 	// If the leaf is the root (no parent), create a new root
 	if leaf.parent == nil {
@@ -379,6 +488,7 @@ func (tree *BPlusTree) splitLeaf(leaf *node) {
 
 		tree.root = newRoot
 		tree.height++
+		tree.account(0, nodeOverhead+keyOverhead+2*pointerSize)
 
 		return
 	}
@@ -399,7 +509,7 @@ func insertKeyInParent(tree *BPlusTree,
 	parent *node, key []byte,
 	leftChild, rightChild *node) {
 	idx := 0
-	for idx < len(parent.keys) && bytes.Compare(parent.keys[idx], key) < 0 {
+	for idx < len(parent.keys) && tree.compare(parent.keys[idx], key) < 0 {
 		idx++
 	}
 
@@ -410,6 +520,7 @@ func insertKeyInParent(tree *BPlusTree,
 	parent.children = append(parent.children, nil)
 	copy(parent.children[idx+2:], parent.children[idx+1:])
 	parent.children[idx+1] = rightChild
+	tree.account(0, keyOverhead+pointerSize)
 
 	rightChild.parent = parent
 
@@ -427,7 +538,7 @@ func splitInternalNode(tree *BPlusTree, internal *node) {
 
 	newInternal := &node{
 		isLeaf:   false,
-		keys:     append(make([][]byte, 0), internal.keys[mid:]...),
+		keys:     append(make([][]byte, 0), internal.keys[mid+1:]...), // splitKey moves up, not right
 		children: append([]*node{}, internal.children[mid+1:]...),
 		parent:   internal.parent,
 	}
@@ -440,6 +551,7 @@ func splitInternalNode(tree *BPlusTree, internal *node) {
 	// Adjust the original internal node
 	internal.keys = internal.keys[:mid]
 	internal.children = internal.children[:mid+1]
+	tree.account(0, nodeOverhead-keyOverhead) // The split key moves up
 
 	if internal.parent == nil {
 		// Create a new root
@@ -452,6 +564,7 @@ func splitInternalNode(tree *BPlusTree, internal *node) {
 		newInternal.parent = newRoot
 		tree.root = newRoot
 		tree.height++
+		tree.account(0, nodeOverhead+keyOverhead+2*pointerSize)
 		return
 	}
 
@@ -463,7 +576,9 @@ func splitInternalNode(tree *BPlusTree, internal *node) {
 	insertKeyInParent(tree, parent, splitKey, internal, newInternal)
 }
 
-// Save serializes the B+Tree to a binary file.
+// Save serializes the B+Tree to a binary file, ending in a checksum of its
+// contents, and fsyncs it. The file is written in place; callers that must
+// never leave a partial file behind save to a temporary name and rename it.
 // This method is thread-safe and can be called concurrently with other operations.
 // It acquires an exclusive lock on the tree to ensure consistency during serialization.
 func (tree *BPlusTree) Save(filename string) error {
@@ -478,6 +593,26 @@ func (tree *BPlusTree) Save(filename string) error {
 	}
 	defer file.Close()
 
+	// Everything before the checksum goes through the hash
+	buffered := bufio.NewWriter(file)
+	sum := crc32.New(fileChecksumTable)
+	if err := tree.writeTree(io.MultiWriter(buffered, sum)); err != nil {
+		return err
+	}
+	if err := binary.Write(buffered, binary.LittleEndian, sum.Sum32()); err != nil {
+		return fmt.Errorf("failed to write checksum: %w", err)
+	}
+	if err := buffered.Flush(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	return nil
+}
+
+// writeTree writes the header and every node of the tree
+func (tree *BPlusTree) writeTree(file io.Writer) error {
 	// If tree is empty, just write empty metadata
 	if tree.root == nil {
 		return tree.writeEmptyTree(file)
@@ -508,6 +643,9 @@ func (tree *BPlusTree) Save(filename string) error {
 	}
 
 	// Write metadata
+	if err := tree.writeHeader(file); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
 	if err := binary.Write(file, binary.LittleEndian, uint32(tree.order)); err != nil {
 		return fmt.Errorf("failed to write order: %w", err)
 	}
@@ -532,8 +670,102 @@ func (tree *BPlusTree) Save(filename string) error {
 	return nil
 }
 
+// fileHeader is what a tree file says about the tree before its nodes
+type fileHeader struct {
+	version    uint32 // 0 for files without a header
+	comparator Comparator
+	duplicates bool
+	order      uint32
+}
+
+// writeHeader writes the file magic, version, the name of the tree's
+// comparator and its flags
+func (tree *BPlusTree) writeHeader(file io.Writer) error {
+	name := tree.comparator.Name
+	for _, v := range []uint32{fileMagic, fileVersion, uint32(len(name))} {
+		if err := binary.Write(file, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	if _, err := file.Write([]byte(name)); err != nil {
+		return err
+	}
+	flags := uint32(0)
+	if tree.duplicates {
+		flags |= fileFlagDuplicates
+	}
+	return binary.Write(file, binary.LittleEndian, flags)
+}
+
+// readHeader reads the header written by writeHeader, followed by the
+// tree's order. Files without a header are bytewise, and files before
+// version 2 have no duplicates.
+func readHeader(file *io.LimitedReader) (fileHeader, error) {
+	var first uint32
+	if err := binary.Read(file, binary.LittleEndian, &first); err != nil {
+		return fileHeader{}, corruptf("failed to read order: %w", err)
+	}
+	if first != fileMagic {
+		if first > maxFileOrder {
+			return fileHeader{}, corruptf("order %d out of range", first)
+		}
+		return fileHeader{comparator: Bytewise, order: first}, nil
+	}
+
+	var version, nameLen uint32
+	if err := binary.Read(file, binary.LittleEndian, &version); err != nil {
+		return fileHeader{}, corruptf("failed to read file version: %w", err)
+	}
+	if version < 1 || version > fileVersion {
+		return fileHeader{}, fmt.Errorf("unsupported tree file version %d", version)
+	}
+	if err := binary.Read(file, binary.LittleEndian, &nameLen); err != nil {
+		return fileHeader{}, corruptf("failed to read comparator: %w", err)
+	}
+	if int64(nameLen) > file.N {
+		return fileHeader{}, corruptf("comparator name of %d bytes exceeds the file", nameLen)
+	}
+	name := make([]byte, nameLen)
+	if _, err := io.ReadFull(file, name); err != nil {
+		return fileHeader{}, corruptf("failed to read comparator: %w", err)
+	}
+	comparator, err := lookupComparator(string(name))
+	if err != nil {
+		return fileHeader{}, err
+	}
+	header := fileHeader{version: version, comparator: comparator}
+
+	if version >= 2 {
+		var flags uint32
+		if err := binary.Read(file, binary.LittleEndian, &flags); err != nil {
+			return fileHeader{}, corruptf("failed to read flags: %w", err)
+		}
+		header.duplicates = flags&fileFlagDuplicates != 0
+	}
+
+	if err := binary.Read(file, binary.LittleEndian, &header.order); err != nil {
+		return fileHeader{}, corruptf("failed to read order: %w", err)
+	}
+	if header.order > maxFileOrder {
+		return fileHeader{}, corruptf("order %d out of range", header.order)
+	}
+	return header, nil
+}
+
+// options returns the options that recreate a tree described by header
+func (header fileHeader) options() []Option {
+	opts := []Option{WithComparator(header.comparator)}
+	if header.duplicates {
+		opts = append(opts, WithDuplicates())
+	}
+	return opts
+}
+
 // writeEmptyTree writes metadata for an empty tree
-func (tree *BPlusTree) writeEmptyTree(file *os.File) error {
+func (tree *BPlusTree) writeEmptyTree(file io.Writer) error {
+	if err := tree.writeHeader(file); err != nil {
+		return err
+	}
 	if err := binary.Write(file, binary.LittleEndian, uint32(tree.order)); err != nil {
 		return err
 	}
@@ -549,7 +781,7 @@ func (tree *BPlusTree) writeEmptyTree(file *os.File) error {
 }
 
 // writeNode serializes a single node to the file
-func (tree *BPlusTree) writeNode(file *os.File, n *node, nodeMap map[*node]uint32) error {
+func (tree *BPlusTree) writeNode(file io.Writer, n *node, nodeMap map[*node]uint32) error {
 	// Write isLeaf
 	isLeaf := uint8(0)
 	if n.isLeaf {
@@ -577,18 +809,21 @@ func (tree *BPlusTree) writeNode(file *os.File, n *node, nodeMap map[*node]uint3
 	if n.isLeaf {
 		// Write values
 		for _, value := range n.values {
-			if value == nil {
-				// Write zero length for nil
-				if err := binary.Write(file, binary.LittleEndian, uint32(0)); err != nil {
-					return err
-				}
-			} else {
-				ksuidBytes := value.Bytes()
-				if err := binary.Write(file, binary.LittleEndian, uint32(len(ksuidBytes))); err != nil {
+			if err := writeValue(file, value); err != nil {
+				return err
+			}
+		}
+
+		// Write each key's further values
+		if tree.duplicates {
+			for i := range n.keys {
+				if err := binary.Write(file, binary.LittleEndian, uint32(len(n.extra[i]))); err != nil {
 					return err
 				}
-				if _, err := file.Write(ksuidBytes); err != nil {
-					return err
+				for _, value := range n.extra[i] {
+					if err := writeValue(file, value); err != nil {
+						return err
+					}
 				}
 			}
 		}
@@ -628,8 +863,25 @@ func (tree *BPlusTree) writeNode(file *os.File, n *node, nodeMap map[*node]uint3
 	return binary.Write(file, binary.LittleEndian, parentID)
 }
 
+// writeValue writes a value with its length
+func writeValue(file io.Writer, value []byte) error {
+	if err := binary.Write(file, binary.LittleEndian, uint32(len(value))); err != nil {
+		return err
+	}
+	_, err := file.Write(value)
+	return err
+}
+
 // Load deserializes a B+Tree from a binary file.
-// Returns a new BPlusTree instance loaded from the file.
+// Returns a new BPlusTree instance loaded from the file, ordered by the
+// comparator it was saved with. Custom comparators must be registered with
+// RegisterComparator first.
+//
+// A file that is truncated, fails its checksum or doesn't describe a
+// well-formed tree returns an error wrapping ErrCorrupt rather than a damaged
+// tree. Files saved before checksums existed are still loaded, with the same
+// structural checks. Values of files saved before values could be of any
+// length are loaded as the 20 bytes of their KSUIDs.
 func LoadBPlusTree(filename string) (*BPlusTree, error) {
 	// Clean the filename to prevent path traversal
 	filename = filepath.Clean(filename)
@@ -639,92 +891,90 @@ func LoadBPlusTree(filename string) (*BPlusTree, error) {
 	}
 	defer file.Close()
 
+	r, err := openTreeFile(file)
+	if err != nil {
+		return nil, err
+	}
+
 	// Read metadata
-	var order uint32
-	if err := binary.Read(file, binary.LittleEndian, &order); err != nil {
-		return nil, fmt.Errorf("failed to read order: %w", err)
+	header, err := readHeader(r)
+	if err != nil {
+		return nil, err
 	}
 	var height uint32
-	if err := binary.Read(file, binary.LittleEndian, &height); err != nil {
-		return nil, fmt.Errorf("failed to read height: %w", err)
+	if err := binary.Read(r, binary.LittleEndian, &height); err != nil {
+		return nil, corruptf("failed to read height: %w", err)
 	}
 	var rootID uint32
-	if err := binary.Read(file, binary.LittleEndian, &rootID); err != nil {
-		return nil, fmt.Errorf("failed to read root ID: %w", err)
+	if err := binary.Read(r, binary.LittleEndian, &rootID); err != nil {
+		return nil, corruptf("failed to read root ID: %w", err)
 	}
 	var nodeCount uint32
-	if err := binary.Read(file, binary.LittleEndian, &nodeCount); err != nil {
-		return nil, fmt.Errorf("failed to read node count: %w", err)
+	if err := binary.Read(r, binary.LittleEndian, &nodeCount); err != nil {
+		return nil, corruptf("failed to read node count: %w", err)
 	}
-
-	// If no nodes, return empty tree
-	if nodeCount == 0 {
-		return NewBPlusTree(int(order)), nil
+	if int64(nodeCount) > r.N/minNodeSize {
+		return nil, corruptf("%d nodes don't fit in the file", nodeCount)
 	}
 
 	// Read temp nodes
 	tempNodes := make([]*tempNode, nodeCount)
-	idToTempNode := make(map[uint32]*tempNode)
-
 	for i := uint32(0); i < nodeCount; i++ {
-		temp, err := readTempNode(file)
+		temp, err := readTempNode(r, header)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read node %d: %w", i, err)
+			return nil, corruptf("failed to read node %d: %w", i, err)
 		}
+		temp.id = i
 		tempNodes[i] = temp
-		idToTempNode[i] = temp
+	}
+	if r.N != 0 {
+		return nil, corruptf("%d unexpected bytes after the last node", r.N)
+	}
+
+	// If no nodes, return empty tree
+	if nodeCount == 0 {
+		return NewBPlusTree(int(header.order), header.options()...), nil
+	}
+	if err := checkTempNodes(tempNodes, rootID, height, header.comparator); err != nil {
+		return nil, err
 	}
 
 	// Convert temp nodes to real nodes and reconstruct pointers
 	nodes := make([]*node, nodeCount)
-	idToNode := make(map[uint32]*node)
-
 	for i, temp := range tempNodes {
-		n := &node{
+		nodes[i] = &node{
 			isLeaf:   temp.isLeaf,
 			keys:     temp.keys,
 			children: make([]*node, len(temp.childrenIDs)),
 			values:   temp.values,
+			extra:    temp.extra,
 		}
-		nodes[i] = n
-		idToNode[uint32(i)] = n
 	}
 
-	// Reconstruct pointers
+	// Reconstruct pointers; checkTempNodes made sure every ID is in range
 	for _, temp := range tempNodes {
-		n := idToNode[temp.id]
+		n := nodes[temp.id]
 		if temp.isLeaf {
 			// Reconstruct next pointer
 			if temp.nextID != 0 {
-				if nextNode, exists := idToNode[temp.nextID]; exists {
-					n.next = nextNode
-				}
+				n.next = nodes[temp.nextID]
 			}
 		} else {
 			// Reconstruct children pointers
 			for j, childID := range temp.childrenIDs {
-				if childID != 0 {
-					if childNode, exists := idToNode[childID]; exists {
-						n.children[j] = childNode
-					}
-				}
+				n.children[j] = nodes[childID]
+				nodes[childID].parent = n
 			}
 		}
 
-		// Reconstruct parent pointer
-		if temp.parentID != 0 {
-			if parentNode, exists := idToNode[temp.parentID]; exists {
-				n.parent = parentNode
-			}
-		}
-	}
-
-	tree := &BPlusTree{
-		root:   idToNode[rootID],
-		order:  int(order),
-		height: int(height),
+		// Parent pointers are set from the children lists above: the root
+		// has ID 0, so a parent ID of 0 can't tell its children from it
 	}
 
+	tree := NewBPlusTree(int(header.order), header.options()...)
+	tree.root = nodes[rootID]
+	tree.height = int(height)
+	tree.recount()
 	return tree, nil
 }
 
@@ -733,23 +983,32 @@ type tempNode struct {
 	id          uint32
 	isLeaf      bool
 	keys        [][]byte
-	values      []*ksuid.KSUID
+	values      [][]byte
+	extra       [][][]byte
 	childrenIDs []uint32
 	parentID    uint32
 	nextID      uint32
 }
 
-// readTempNode deserializes a single temp node from the file
-func readTempNode(file *os.File) (*tempNode, error) {
+// readTempNode deserializes a single temp node from the file, with each
+// key's further values if the tree has duplicates. Counts and lengths are
+// checked against the bytes left in file before anything is allocated.
+func readTempNode(file *io.LimitedReader, header fileHeader) (*tempNode, error) {
 	var isLeaf uint8
 	if err := binary.Read(file, binary.LittleEndian, &isLeaf); err != nil {
 		return nil, err
 	}
+	if isLeaf > 1 {
+		return nil, fmt.Errorf("invalid leaf flag %d", isLeaf)
+	}
 
 	var keyCount uint32
 	if err := binary.Read(file, binary.LittleEndian, &keyCount); err != nil {
 		return nil, err
 	}
+	if int64(keyCount) > file.N/4 {
+		return nil, fmt.Errorf("%d keys exceed the file", keyCount)
+	}
 
 	keys := make([][]byte, keyCount)
 	for i := uint32(0); i < keyCount; i++ {
@@ -757,6 +1016,9 @@ func readTempNode(file *os.File) (*tempNode, error) {
 		if err := binary.Read(file, binary.LittleEndian, &keyLen); err != nil {
 			return nil, err
 		}
+		if int64(keyLen) > file.N {
+			return nil, fmt.Errorf("key of %d bytes exceeds the file", keyLen)
+		}
 		key := make([]byte, keyLen)
 		if _, err := io.ReadFull(file, key); err != nil {
 			return nil, err
@@ -770,27 +1032,53 @@ func readTempNode(file *os.File) (*tempNode, error) {
 	}
 
 	if temp.isLeaf {
-		values := make([]*ksuid.KSUID, keyCount)
+		values := make([][]byte, keyCount)
 		for i := uint32(0); i < keyCount; i++ {
 			var valueLen uint32
 			if err := binary.Read(file, binary.LittleEndian, &valueLen); err != nil {
 				return nil, err
 			}
-			if valueLen == 0 {
-				values[i] = nil
-			} else {
-				valueBytes := make([]byte, valueLen)
-				if _, err := io.ReadFull(file, valueBytes); err != nil {
+			if header.version < 4 && valueLen != 0 && valueLen != legacyValueLen {
+				return nil, fmt.Errorf("invalid value length %d", valueLen)
+			}
+			value, err := readValue(file, valueLen)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+		}
+		temp.values = values
+
+		if header.duplicates {
+			// Before version 4 the further values were KSUIDs without lengths
+			minValueSize := int64(4)
+			if header.version < 4 {
+				minValueSize = legacyValueLen
+			}
+			temp.extra = make([][][]byte, keyCount)
+			for i := uint32(0); i < keyCount; i++ {
+				var count uint32
+				if err := binary.Read(file, binary.LittleEndian, &count); err != nil {
 					return nil, err
 				}
-				ksuid, err := ksuid.FromBytes(valueBytes)
-				if err != nil {
-					return nil, fmt.Errorf("invalid KSUID bytes: %w", err)
+				if int64(count) > file.N/minValueSize {
+					return nil, fmt.Errorf("%d values exceed the file", count)
+				}
+				for j := uint32(0); j < count; j++ {
+					valueLen := uint32(legacyValueLen)
+					if header.version >= 4 {
+						if err := binary.Read(file, binary.LittleEndian, &valueLen); err != nil {
+							return nil, err
+						}
+					}
+					value, err := readValue(file, valueLen)
+					if err != nil {
+						return nil, err
+					}
+					temp.extra[i] = append(temp.extra[i], value)
 				}
-				values[i] = &ksuid
 			}
 		}
-		temp.values = values
 
 		// Read next ID
 		var nextID uint32
@@ -800,6 +1088,9 @@ func readTempNode(file *os.File) (*tempNode, error) {
 		temp.nextID = nextID
 	} else {
 		childrenCount := keyCount + 1
+		if int64(childrenCount) > file.N/4 {
+			return nil, fmt.Errorf("%d children exceed the file", childrenCount)
+		}
 		childrenIDs := make([]uint32, childrenCount)
 		for i := uint32(0); i < childrenCount; i++ {
 			if err := binary.Read(file, binary.LittleEndian, &childrenIDs[i]); err != nil {
@@ -819,6 +1110,21 @@ func readTempNode(file *os.File) (*tempNode, error) {
 	return temp, nil
 }
 
+// readValue reads a value of valueLen bytes, nil if empty
+func readValue(file *io.LimitedReader, valueLen uint32) ([]byte, error) {
+	if valueLen == 0 {
+		return nil, nil
+	}
+	if int64(valueLen) > file.N {
+		return nil, fmt.Errorf("value of %d bytes exceeds the file", valueLen)
+	}
+	value := make([]byte, valueLen)
+	if _, err := io.ReadFull(file, value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
 // Checkpoint starts a background goroutine that periodically saves the B+Tree to the specified file.
 // The interval is specified in seconds. Call StopCheckpoint to stop the checkpointing.
 func (tree *BPlusTree) StartCheckpoint(filename string, intervalSeconds int) {
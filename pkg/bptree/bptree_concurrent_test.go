@@ -18,7 +18,7 @@ func TestBPlusTree_SaveLoadConcurrent(t *testing.T) {
 	// Insert some data
 	for i := 0; i < 5; i++ {
 		key := []byte(fmt.Sprintf("key%d", i))
-		val := ksuid.New()
+		val := ksuid.New().Bytes()
 		tree.Insert(key, val)
 	}
 
@@ -33,7 +33,7 @@ func TestBPlusTree_SaveLoadConcurrent(t *testing.T) {
 	// Insert more data
 	for i := 5; i < 10; i++ {
 		key := []byte(fmt.Sprintf("key%d", i))
-		val := ksuid.New()
+		val := ksuid.New().Bytes()
 		tree.Insert(key, val)
 	}
 
@@ -65,7 +65,7 @@ func TestBPlusTree_ConcurrentInsertSearch(t *testing.T) {
 			defer wg.Done()
 			for j := 0; j < keysPerGoroutine; j++ {
 				key := []byte(fmt.Sprintf("key%d_%d", id, j))
-				val := ksuid.New()
+				val := ksuid.New().Bytes()
 				tree.Insert(key, val)
 			}
 		}(i)
@@ -101,7 +101,7 @@ func TestBPlusTree_ConcurrentInsertDelete(t *testing.T) {
 			defer wg.Done()
 			for j := 0; j < keysPerGoroutine; j++ {
 				key := []byte(fmt.Sprintf("key%d_%d", id, j))
-				val := ksuid.New()
+				val := ksuid.New().Bytes()
 				tree.Insert(key, val)
 			}
 		}(i)
@@ -141,7 +141,7 @@ func TestBPlusTree_ConcurrentReadWrite(t *testing.T) {
 	// Pre-insert some keys
 	for i := 0; i < 10; i++ {
 		key := []byte(fmt.Sprintf("pre%d", i))
-		val := ksuid.New()
+		val := ksuid.New().Bytes()
 		tree.Insert(key, val)
 	}
 
@@ -156,7 +156,7 @@ func TestBPlusTree_ConcurrentReadWrite(t *testing.T) {
 			defer wg.Done()
 			for j := 0; j < operations; j++ {
 				key := []byte(fmt.Sprintf("write%d_%d", id, j))
-				val := ksuid.New()
+				val := ksuid.New().Bytes()
 				tree.Insert(key, val)
 			}
 		}(i)
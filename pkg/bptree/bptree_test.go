@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/segmentio/ksuid"
@@ -26,19 +27,19 @@ func TestBPlusTree_InsertAndSearch(t *testing.T) {
 	tree := NewBPlusTree(3)
 
 	key1 := []byte("key1")
-	val1 := ksuid.New()
+	val1 := ksuid.New().Bytes()
 	tree.Insert(key1, val1)
 
 	key2 := []byte("key2")
-	val2 := ksuid.New()
+	val2 := ksuid.New().Bytes()
 	tree.Insert(key2, val2)
 
 	// Test search for existing keys
-	if v, found := tree.Search(key1); !found || !bytes.Equal(v.Bytes(), val1.Bytes()) {
+	if v, found := tree.Search(key1); !found || !bytes.Equal(v, val1) {
 		t.Fatalf("Expected to find key1 with value %v, got %v", val1, v)
 	}
 
-	if v, found := tree.Search(key2); !found || !bytes.Equal(v.Bytes(), val2.Bytes()) {
+	if v, found := tree.Search(key2); !found || !bytes.Equal(v, val2) {
 		t.Fatalf("Expected to find key2 with value %v, got %v", val2, v)
 	}
 
@@ -52,7 +53,7 @@ func TestBPlusTree_SplitLeaf(t *testing.T) {
 	tree := NewBPlusTree(3)
 
 	keys := [][]byte{[]byte("key1"), []byte("key2"), []byte("key3"), []byte("key4")}
-	values := []ksuid.KSUID{ksuid.New(), ksuid.New(), ksuid.New(), ksuid.New()}
+	values := [][]byte{ksuid.New().Bytes(), ksuid.New().Bytes(), ksuid.New().Bytes(), ksuid.New().Bytes()}
 
 	for i := range keys {
 		tree.Insert(keys[i], values[i])
@@ -65,7 +66,7 @@ func TestBPlusTree_SplitLeaf(t *testing.T) {
 
 	// Check if all keys are present
 	for i, key := range keys {
-		if v, found := tree.Search(key); !found || !bytes.Equal(v.Bytes(), values[i].Bytes()) {
+		if v, found := tree.Search(key); !found || !bytes.Equal(v, values[i]) {
 			t.Fatalf("Expected to find %s with value %v, got %v", key, values[i], v)
 		}
 	}
@@ -76,7 +77,7 @@ func TestBPlusTree_SaveLoad(t *testing.T) {
 
 	// Insert some data
 	keys := [][]byte{[]byte("key1"), []byte("key2"), []byte("key3"), []byte("key4")}
-	values := []ksuid.KSUID{ksuid.New(), ksuid.New(), ksuid.New(), ksuid.New()}
+	values := [][]byte{ksuid.New().Bytes(), ksuid.New().Bytes(), ksuid.New().Bytes(), ksuid.New().Bytes()}
 
 	for i := range keys {
 		tree.Insert(keys[i], values[i])
@@ -106,7 +107,7 @@ func TestBPlusTree_SaveLoad(t *testing.T) {
 
 	// Verify all keys are present with correct values
 	for i, key := range keys {
-		if v, found := loadedTree.Search(key); !found || !bytes.Equal(v.Bytes(), values[i].Bytes()) {
+		if v, found := loadedTree.Search(key); !found || !bytes.Equal(v, values[i]) {
 			t.Fatalf("Expected to find %s with value %v, got %v", key, values[i], v)
 		}
 	}
@@ -144,7 +145,7 @@ func TestBPlusTree_Delete(t *testing.T) {
 	tree := NewBPlusTree(3)
 
 	key1 := []byte("key1")
-	val1 := ksuid.New()
+	val1 := ksuid.New().Bytes()
 	tree.Insert(key1, val1)
 
 	if _, found := tree.Search(key1); !found {
@@ -169,7 +170,7 @@ func BenchmarkBPlusTree_Insert(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		key := []byte(fmt.Sprintf("key%d", i))
-		val := ksuid.New()
+		val := ksuid.New().Bytes()
 		tree.Insert(key, val)
 	}
 }
@@ -179,7 +180,7 @@ func BenchmarkBPlusTree_Search(b *testing.B) {
 	// Pre-insert
 	for i := 0; i < 1000; i++ {
 		key := []byte(fmt.Sprintf("key%d", i))
-		val := ksuid.New()
+		val := ksuid.New().Bytes()
 		tree.Insert(key, val)
 	}
 	b.ResetTimer()
@@ -196,7 +197,7 @@ func BenchmarkBPlusTree_ConcurrentInsert(b *testing.B) {
 		i := 0
 		for pb.Next() {
 			key := []byte(fmt.Sprintf("key%d", i))
-			val := ksuid.New()
+			val := ksuid.New().Bytes()
 			tree.Insert(key, val)
 			i++
 		}
@@ -208,12 +209,12 @@ func TestBPlusTree_SplitInternalNode(t *testing.T) {
 
 	// Insert enough keys to force root split and height=3
 	keys := make([][]byte, 0)
-	values := make([]ksuid.KSUID, 0)
+	values := make([][]byte, 0)
 
 	// Insert 8 keys to ensure we get height=2
 	for i := 0; i < 8; i++ {
 		key := []byte(fmt.Sprintf("%02d", i))
-		val := ksuid.New()
+		val := ksuid.New().Bytes()
 		keys = append(keys, key)
 		values = append(values, val)
 		tree.Insert(key, val)
@@ -226,8 +227,123 @@ func TestBPlusTree_SplitInternalNode(t *testing.T) {
 
 	// Check if all keys are present
 	for i, key := range keys {
-		if v, found := tree.Search(key); !found || !bytes.Equal(v.Bytes(), values[i].Bytes()) {
+		if v, found := tree.Search(key); !found || !bytes.Equal(v, values[i]) {
 			t.Fatalf("Expected to find %s with value %v, got %v", key, values[i], v)
 		}
 	}
 }
+
+func TestBPlusTree_Ascend(t *testing.T) {
+	tree := NewBPlusTree(3)
+	// Insert out of order so the keys span several split leaves
+	for _, i := range []int{7, 2, 9, 0, 5, 3, 8, 1, 6, 4} {
+		tree.Insert([]byte(fmt.Sprintf("key%02d", i)), ksuid.New().Bytes())
+	}
+
+	var got []string
+	tree.Ascend([]byte("key03"), []byte("key08"), func(key, _ []byte) bool {
+		got = append(got, string(key))
+		return true
+	})
+	want := []string{"key03", "key04", "key05", "key06", "key07"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+
+	// nil end scans to the last key; returning false stops early
+	got = nil
+	tree.Ascend([]byte("key08"), nil, func(key, _ []byte) bool {
+		got = append(got, string(key))
+		return true
+	})
+	if fmt.Sprint(got) != "[key08 key09]" {
+		t.Fatalf("Expected [key08 key09], got %v", got)
+	}
+
+	count := 0
+	tree.Ascend(nil, nil, func(_, _ []byte) bool {
+		count++
+		return count < 4
+	})
+	if count != 4 {
+		t.Fatalf("Expected scan to stop after 4 keys, got %d", count)
+	}
+}
+
+func TestBPlusTree_InternalNodeSplits(t *testing.T) {
+	tree := NewBPlusTree(3)
+	const n = 200
+	for i := 0; i < n; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key%03d", (i*37)%n)), ksuid.New().Bytes())
+	}
+	if tree.Height() < 3 {
+		t.Fatalf("Expected internal node splits to grow the tree, height %d", tree.Height())
+	}
+
+	for i := 0; i < n; i++ {
+		if _, found := tree.Search([]byte(fmt.Sprintf("key%03d", i))); !found {
+			t.Fatalf("Expected to find key%03d", i)
+		}
+	}
+
+	i := 0
+	tree.Ascend(nil, nil, func(key, _ []byte) bool {
+		if want := fmt.Sprintf("key%03d", i); string(key) != want {
+			t.Fatalf("Expected %s at position %d, got %s", want, i, key)
+		}
+		i++
+		return true
+	})
+	if i != n {
+		t.Fatalf("Expected %d keys in scan, got %d", n, i)
+	}
+}
+
+func TestBPlusTree_VariableValues(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithDuplicates()}} {
+		tree := NewBPlusTree(4, opts...)
+		want := make(map[string][]byte)
+		for i := 0; i < 100; i++ {
+			key := []byte(fmt.Sprintf("key%03d", i))
+			value := bytes.Repeat([]byte{byte(i)}, i*7) // Empty for key000, 693 bytes for key099
+			tree.Insert(key, value)
+			want[string(key)] = value
+		}
+		if v, found := tree.Search([]byte("key000")); !found || len(v) != 0 {
+			t.Fatalf("Expected key000 with an empty value, got %v, %v", v, found)
+		}
+		// Updating a value with a longer or shorter one keeps the accounting
+		if len(opts) == 0 {
+			tree.Insert([]byte("key001"), []byte("a much longer value than before"))
+			tree.Insert([]byte("key099"), []byte("short"))
+			want["key001"], want["key099"] = []byte("a much longer value than before"), []byte("short")
+		} else {
+			tree.Insert([]byte("key010"), nil)
+			tree.Insert([]byte("key010"), []byte("xyz"))
+			want["key010"] = nil // Search finds the least value
+		}
+		checkAccounting(t, tree)
+
+		filename := filepath.Join(t.TempDir(), "tree.dat")
+		if err := tree.Save(filename); err != nil {
+			t.Fatalf("Failed to save tree: %v", err)
+		}
+		loaded, err := LoadBPlusTree(filename)
+		if err != nil {
+			t.Fatalf("Failed to load tree: %v", err)
+		}
+		for key, value := range want {
+			if v, found := loaded.Search([]byte(key)); !found || !bytes.Equal(v, value) {
+				t.Fatalf("Expected %s to load with %d bytes, got %d", key, len(value), len(v))
+			}
+		}
+		if len(opts) > 0 {
+			if values := loaded.Values([]byte("key010")); len(values) != 3 || len(values[0]) != 0 {
+				t.Errorf("Expected key010's further values of any length, got %q", values)
+			}
+		}
+		if loaded.MemoryUsage() != tree.MemoryUsage() {
+			t.Errorf("Expected the loaded tree to use %d bytes, got %d", tree.MemoryUsage(), loaded.MemoryUsage())
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package bptree
+
+import "fmt"
+
+// DefaultFillFactor is the share of each node BulkLoad fills when no valid
+// fill factor is given. Leaving some room means the first inserts after a
+// load don't split every leaf they touch.
+const DefaultFillFactor = 0.9
+
+// Pair is a key and its value, as passed to BulkLoad
+type Pair struct {
+	Key   []byte
+	Value []byte
+}
+
+// BulkLoad builds a B+Tree of the given order from pairs sorted by key,
+// without duplicates, as ordered by the comparator in opts. It is much
+// faster than inserting the pairs one by one and packs the nodes evenly
+// instead of leaving them half full after splits. With WithDuplicates,
+// pairs may repeat a key; its values are gathered in ascending order.
+//
+// Algorithm:
+// 1. Pack the pairs into leaves of fill*order keys, linked for range scans
+// 2. Group each level's nodes under parents of fill*order keys, bottom-up,
+// until a single root remains
+// 3. Each separator key is the first key of the subtree to its right, as
+// Insert would have promoted it
+//
+// A fill factor outside (0, 1] means DefaultFillFactor, and nodes are never
+// filled less than half, so the tree keeps the invariants Insert maintains.
+// When the last node of a level would come up short it shares the previous
+// node's entries. The pairs' keys and values are used as-is, not copied.
+//
+// Time complexity: O(n) for n pairs
+func BulkLoad(order int, pairs []Pair, fill float64, opts ...Option) (*BPlusTree, error) {
+	tree := NewBPlusTree(order, opts...)
+	if len(pairs) == 0 {
+		return tree, nil
+	}
+	for i := 1; i < len(pairs); i++ {
+		c := tree.compare(pairs[i-1].Key, pairs[i].Key)
+		if c > 0 || (c == 0 && !tree.duplicates) {
+			return nil, fmt.Errorf("bulk load pairs must be sorted and unique: %q is followed by %q",
+				pairs[i-1].Key, pairs[i].Key)
+		}
+	}
+	groups := tree.groupPairs(pairs)
+	if fill <= 0 || fill > 1 {
+		fill = DefaultFillFactor
+	}
+	order = tree.order
+	perNode := int(float64(order) * fill)
+
+	// Leaves hold at most order keys and at least what a split leaves behind
+	level := make([]*node, 0, len(groups)/max(perNode, 1)+1)
+	firstKeys := make([][]byte, 0, cap(level))
+	start := 0
+	for _, size := range packSizes(len(groups), perNode, (order+1)/2, order) {
+		leaf := &node{
+			isLeaf: true,
+			keys:   make([][]byte, size),
+			values: make([][]byte, size),
+		}
+		if tree.duplicates {
+			leaf.extra = make([][][]byte, size)
+		}
+		for i, group := range groups[start : start+size] {
+			leaf.keys[i] = group.key
+			leaf.values[i] = group.values[0]
+			if len(group.values) > 1 {
+				leaf.extra[i] = group.values[1:]
+			}
+		}
+		if len(level) > 0 {
+			level[len(level)-1].next = leaf
+		}
+		level = append(level, leaf)
+		firstKeys = append(firstKeys, leaf.keys[0])
+		start += size
+	}
+
+	// Internal nodes hold at most order keys, so order+1 children, and at
+	// least the order/2 keys a split leaves behind
+	height := 1
+	for len(level) > 1 {
+		parents := make([]*node, 0, len(level)/max(perNode+1, 2)+1)
+		parentKeys := make([][]byte, 0, cap(parents))
+		start := 0
+		for _, size := range packSizes(len(level), perNode+1, order/2+1, order+1) {
+			parent := &node{
+				isLeaf:   false,
+				keys:     append(make([][]byte, 0, size-1), firstKeys[start+1:start+size]...),
+				children: append(make([]*node, 0, size), level[start:start+size]...),
+			}
+			for _, child := range parent.children {
+				child.parent = parent
+			}
+			parents = append(parents, parent)
+			parentKeys = append(parentKeys, firstKeys[start])
+			start += size
+		}
+		level, firstKeys = parents, parentKeys
+		height++
+	}
+
+	tree.root = level[0]
+	tree.height = height
+	tree.recount()
+	return tree, nil
+}
+
+// pairGroup is a key and its values, ascending and distinct
+type pairGroup struct {
+	key    []byte
+	values [][]byte
+}
+
+// groupPairs gathers the values of runs of equal keys in sorted pairs. Keys
+// are only repeated in trees with duplicates.
+func (tree *BPlusTree) groupPairs(pairs []Pair) []pairGroup {
+	groups := make([]pairGroup, 0, len(pairs))
+	for _, pair := range pairs {
+		last := len(groups) - 1
+		if last < 0 || tree.compare(groups[last].key, pair.Key) != 0 {
+			groups = append(groups, pairGroup{key: pair.Key, values: [][]byte{pair.Value}})
+			continue
+		}
+		if j, found := searchValues(groups[last].values, pair.Value); !found {
+			values := append(groups[last].values, nil)
+			copy(values[j+1:], values[j:])
+			values[j] = pair.Value
+			groups[last].values = values
+		}
+	}
+	return groups
+}
+
+// packSizes splits n entries into nodes of perNode entries, clamped to
+// [minSize, maxSize]. If the last node would hold fewer than minSize it is
+// merged into the one before, and that node split evenly in two if it
+// would then overflow. A lone node may hold fewer than minSize, as a root
+// may.
+func packSizes(n, perNode, minSize, maxSize int) []int {
+	perNode = min(max(perNode, minSize, 1), maxSize)
+
+	sizes := make([]int, 0, n/perNode+1)
+	for remaining := n; remaining > 0; remaining -= perNode {
+		sizes = append(sizes, min(perNode, remaining))
+	}
+
+	last := len(sizes) - 1
+	if last > 0 && sizes[last] < minSize {
+		combined := sizes[last-1] + sizes[last]
+		if combined <= maxSize {
+			sizes = append(sizes[:last-1], combined)
+		} else {
+			sizes[last-1], sizes[last] = combined-combined/2, combined/2
+		}
+	}
+	return sizes
+}
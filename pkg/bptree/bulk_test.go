@@ -0,0 +1,202 @@
+package bptree
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/segmentio/ksuid"
+)
+
+// sortedPairs returns n pairs with ascending keys
+func sortedPairs(n int) []Pair {
+	pairs := make([]Pair, n)
+	for i := range pairs {
+		pairs[i] = Pair{Key: []byte(fmt.Sprintf("key%05d", i)), Value: ksuid.New().Bytes()}
+	}
+	return pairs
+}
+
+// checkTree verifies the tree's structure: every leaf at the same depth,
+// every non-root node between half full and full, separators matching the
+// first key of the subtree to their right, parent pointers and leaf links
+// in order. It returns the number of leaves.
+func checkTree(t *testing.T, tree *BPlusTree) int {
+	t.Helper()
+
+	var leaves []*node
+	var walk func(n *node, depth int) []byte
+	walk = func(n *node, depth int) []byte {
+		if n != tree.root {
+			minKeys := tree.order / 2
+			if n.isLeaf {
+				minKeys = (tree.order + 1) / 2
+			}
+			if len(n.keys) < minKeys || len(n.keys) > tree.order {
+				t.Fatalf("Expected %d to %d keys per node, got %d", minKeys, tree.order, len(n.keys))
+			}
+		}
+		if n.isLeaf {
+			if depth != tree.height {
+				t.Fatalf("Expected every leaf at depth %d, got one at %d", tree.height, depth)
+			}
+			leaves = append(leaves, n)
+			return n.keys[0]
+		}
+		if len(n.children) != len(n.keys)+1 {
+			t.Fatalf("Expected %d children for %d keys, got %d", len(n.keys)+1, len(n.keys), len(n.children))
+		}
+		first := walk(n.children[0], depth+1)
+		for i, child := range n.children {
+			if child.parent != n {
+				t.Fatal("Expected children to point at their parent")
+			}
+			if i > 0 {
+				if low := walk(child, depth+1); !bytes.Equal(low, n.keys[i-1]) {
+					t.Fatalf("Expected separator %s, got %s", low, n.keys[i-1])
+				}
+			}
+		}
+		return first
+	}
+	walk(tree.root, 1)
+
+	for i, leaf := range leaves {
+		var want *node
+		if i+1 < len(leaves) {
+			want = leaves[i+1]
+		}
+		if leaf.next != want {
+			t.Fatalf("Expected leaf %d to link to the next leaf", i)
+		}
+	}
+	return len(leaves)
+}
+
+func TestBulkLoad(t *testing.T) {
+	for _, order := range []int{3, 4, 5, 32} {
+		for _, n := range []int{1, 2, 3, 7, 100, 1001} {
+			t.Run(fmt.Sprintf("order%d/n%d", order, n), func(t *testing.T) {
+				pairs := sortedPairs(n)
+				tree, err := BulkLoad(order, pairs, DefaultFillFactor)
+				if err != nil {
+					t.Fatalf("Failed to bulk load: %v", err)
+				}
+				checkTree(t, tree)
+
+				for _, pair := range pairs {
+					if v, found := tree.Search(pair.Key); !found || !bytes.Equal(v, pair.Value) {
+						t.Fatalf("Expected to find %s with value %v, got %v", pair.Key, pair.Value, v)
+					}
+				}
+				i := 0
+				tree.Ascend(nil, nil, func(key, _ []byte) bool {
+					if !bytes.Equal(key, pairs[i].Key) {
+						t.Fatalf("Expected %s at position %d, got %s", pairs[i].Key, i, key)
+					}
+					i++
+					return true
+				})
+				if i != n {
+					t.Fatalf("Expected %d keys in scan, got %d", n, i)
+				}
+
+				// The loaded tree takes inserts and deletes like any other
+				tree.Insert([]byte("key"), ksuid.New().Bytes())
+				tree.Insert([]byte("zzz"), ksuid.New().Bytes())
+				if !tree.Delete(pairs[0].Key) {
+					t.Fatalf("Expected to delete %s", pairs[0].Key)
+				}
+				for _, key := range []string{"key", "zzz"} {
+					if _, found := tree.Search([]byte(key)); !found {
+						t.Fatalf("Expected to find %s after inserting it", key)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestBulkLoad_FillFactor(t *testing.T) {
+	pairs := sortedPairs(1000)
+
+	full, err := BulkLoad(10, pairs, 1)
+	if err != nil {
+		t.Fatalf("Failed to bulk load: %v", err)
+	}
+	if leaves := checkTree(t, full); leaves != 100 {
+		t.Fatalf("Expected 100 full leaves, got %d", leaves)
+	}
+
+	half, err := BulkLoad(10, pairs, 0.5)
+	if err != nil {
+		t.Fatalf("Failed to bulk load: %v", err)
+	}
+	if leaves := checkTree(t, half); leaves != 200 {
+		t.Fatalf("Expected 200 half-full leaves, got %d", leaves)
+	}
+
+	// Fills below half are raised to it, invalid ones mean the default
+	sparse, err := BulkLoad(10, pairs, 0.1)
+	if err != nil {
+		t.Fatalf("Failed to bulk load: %v", err)
+	}
+	if leaves := checkTree(t, sparse); leaves != 200 {
+		t.Fatalf("Expected fill to be raised to half, got %d leaves", leaves)
+	}
+	defaulted, err := BulkLoad(10, pairs, 2)
+	if err != nil {
+		t.Fatalf("Failed to bulk load: %v", err)
+	}
+	if leaves := checkTree(t, defaulted); leaves != 111 {
+		t.Fatalf("Expected 111 leaves at the default fill, got %d", leaves)
+	}
+
+	// Inserting one by one leaves the tree much sparser
+	inserted := NewBPlusTree(10)
+	for _, pair := range pairs {
+		inserted.Insert(pair.Key, pair.Value)
+	}
+	if leaves := checkTree(t, inserted); leaves <= 111 {
+		t.Fatalf("Expected sequential inserts to leave more than 111 leaves, got %d", leaves)
+	}
+}
+
+func TestBulkLoad_Empty(t *testing.T) {
+	tree, err := BulkLoad(4, nil, DefaultFillFactor)
+	if err != nil {
+		t.Fatalf("Failed to bulk load: %v", err)
+	}
+	if tree.Height() != 1 {
+		t.Fatalf("Expected height 1, got %d", tree.Height())
+	}
+	tree.Insert([]byte("key"), ksuid.New().Bytes())
+	if _, found := tree.Search([]byte("key")); !found {
+		t.Fatal("Expected to find key after inserting it")
+	}
+}
+
+func TestBulkLoad_Unsorted(t *testing.T) {
+	for name, keys := range map[string][]string{
+		"unsorted":  {"a", "c", "b"},
+		"duplicate": {"a", "b", "b"},
+	} {
+		pairs := make([]Pair, len(keys))
+		for i, key := range keys {
+			pairs[i] = Pair{Key: []byte(key), Value: ksuid.New().Bytes()}
+		}
+		if _, err := BulkLoad(4, pairs, DefaultFillFactor); err == nil {
+			t.Fatalf("Expected an error for %s keys", name)
+		}
+	}
+}
+
+func BenchmarkBulkLoad(b *testing.B) {
+	pairs := sortedPairs(100000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := BulkLoad(DefaultOrder, pairs, DefaultFillFactor); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package bptree
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Comparator orders the keys of a B+Tree. Compare returns a negative number
+// if a sorts before b, zero if they are the same key and a positive number
+// otherwise; it must be a consistent total order and safe for concurrent
+// use. Name identifies the ordering in saved tree files, so a tree is always
+// loaded with the comparator it was built with.
+//
+// Locale-aware collations can be plugged in from golang.org/x/text/collate,
+// e.g. Compare: collate.New(language.German).Compare behind a mutex, since
+// collators are not safe for concurrent use.
+type Comparator struct {
+	Name    string
+	Compare func(a, b []byte) int
+}
+
+// Bytewise orders keys by bytes.Compare. It is the default.
+var Bytewise = Comparator{Name: "bytewise", Compare: bytes.Compare}
+
+// CaseInsensitive orders UTF-8 keys ignoring case, so "Apple", "apple" and
+// "APPLE" are the same key
+var CaseInsensitive = Comparator{Name: "case-insensitive", Compare: compareCaseInsensitive}
+
+// Natural orders runs of ASCII digits by their numeric value, so "file2"
+// sorts before "file10". Keys equal apart from leading zeros are ordered
+// bytewise, so they stay distinct.
+var Natural = Comparator{Name: "natural", Compare: compareNatural}
+
+var (
+	comparatorsMu sync.RWMutex
+	comparators   = map[string]Comparator{
+		Bytewise.Name:        Bytewise,
+		CaseInsensitive.Name: CaseInsensitive,
+		Natural.Name:         Natural,
+	}
+)
+
+// RegisterComparator makes a custom comparator available to LoadBPlusTree
+// under its name. Register comparators before loading trees built with
+// them, typically from an init function. It panics if the name is empty,
+// already registered or Compare is nil.
+func RegisterComparator(c Comparator) {
+	comparatorsMu.Lock()
+	defer comparatorsMu.Unlock()
+
+	if c.Name == "" || c.Compare == nil {
+		panic("bptree: RegisterComparator needs a name and a compare function")
+	}
+	if _, dup := comparators[c.Name]; dup {
+		panic("bptree: RegisterComparator called twice for comparator " + c.Name)
+	}
+	comparators[c.Name] = c
+}
+
+// lookupComparator returns the registered comparator called name
+func lookupComparator(name string) (Comparator, error) {
+	comparatorsMu.RLock()
+	defer comparatorsMu.RUnlock()
+
+	c, ok := comparators[name]
+	if !ok {
+		return Comparator{}, fmt.Errorf("tree was built with comparator %q, which is not registered", name)
+	}
+	return c, nil
+}
+
+// Option configures a tree built by NewBPlusTree or BulkLoad
+type Option func(*BPlusTree)
+
+// WithComparator orders the tree's keys with c instead of Bytewise
+func WithComparator(c Comparator) Option {
+	return func(tree *BPlusTree) {
+		if c.Compare != nil {
+			tree.comparator = c
+		}
+	}
+}
+
+// Comparator returns the comparator the tree orders its keys with
+func (tree *BPlusTree) Comparator() Comparator {
+	return tree.comparator
+}
+
+// compareCaseInsensitive compares a and b rune by rune after lowering case
+func compareCaseInsensitive(a, b []byte) int {
+	for len(a) > 0 && len(b) > 0 {
+		ra, na := utf8.DecodeRune(a)
+		rb, nb := utf8.DecodeRune(b)
+		if la, lb := unicode.ToLower(ra), unicode.ToLower(rb); la != lb {
+			if la < lb {
+				return -1
+			}
+			return 1
+		}
+		a, b = a[na:], b[nb:]
+	}
+	return len(a) - len(b)
+}
+
+// compareNatural compares digit runs numerically and everything else
+// bytewise, falling back to a plain bytewise comparison on ties
+func compareNatural(a, b []byte) int {
+	x, y := a, b
+	for len(x) > 0 && len(y) > 0 {
+		if isDigit(x[0]) && isDigit(y[0]) {
+			dx, dy := digitRun(x), digitRun(y)
+			nx, ny := bytes.TrimLeft(x[:dx], "0"), bytes.TrimLeft(y[:dy], "0")
+			// More significant digits is a bigger number
+			if len(nx) != len(ny) {
+				return len(nx) - len(ny)
+			}
+			if c := bytes.Compare(nx, ny); c != 0 {
+				return c
+			}
+			x, y = x[dx:], y[dy:]
+			continue
+		}
+		if x[0] != y[0] {
+			return int(x[0]) - int(y[0])
+		}
+		x, y = x[1:], y[1:]
+	}
+	if len(x) != len(y) {
+		return len(x) - len(y)
+	}
+	return bytes.Compare(a, b)
+}
+
+// isDigit reports whether c is an ASCII digit
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// digitRun returns the length of the run of digits b starts with
+func digitRun(b []byte) int {
+	n := 0
+	for n < len(b) && isDigit(b[n]) {
+		n++
+	}
+	return n
+}
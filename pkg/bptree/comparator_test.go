@@ -0,0 +1,150 @@
+package bptree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/ksuid"
+)
+
+// ascendKeys returns every key in the tree in order
+func ascendKeys(tree *BPlusTree) []string {
+	var keys []string
+	tree.Ascend(nil, nil, func(key, _ []byte) bool {
+		keys = append(keys, string(key))
+		return true
+	})
+	return keys
+}
+
+func TestComparator_CaseInsensitive(t *testing.T) {
+	tree := NewBPlusTree(3, WithComparator(CaseInsensitive))
+	for _, key := range []string{"banana", "Apple", "cherry", "date", "Elder"} {
+		tree.Insert([]byte(key), ksuid.New().Bytes())
+	}
+
+	want := ksuid.New().Bytes()
+	tree.Insert([]byte("APPLE"), want)
+	if v, found := tree.Search([]byte("apple")); !found || !bytes.Equal(v, want) {
+		t.Fatalf("Expected apple to match APPLE with value %v, got %v", want, v)
+	}
+	if got := strings.Join(ascendKeys(tree), ","); got != "Apple,banana,cherry,date,Elder" {
+		t.Fatalf("Expected case-insensitive order, got %s", got)
+	}
+	if !tree.Delete([]byte("BANANA")) {
+		t.Fatal("Expected BANANA to delete banana")
+	}
+}
+
+func TestComparator_Natural(t *testing.T) {
+	tree := NewBPlusTree(3, WithComparator(Natural))
+	for _, key := range []string{"file10", "file2", "file1", "file02", "file", "img3", "file10a"} {
+		tree.Insert([]byte(key), ksuid.New().Bytes())
+	}
+	if got := strings.Join(ascendKeys(tree), ","); got != "file,file1,file02,file2,file10,file10a,img3" {
+		t.Fatalf("Expected natural order, got %s", got)
+	}
+
+	var scanned []string
+	tree.Ascend([]byte("file3"), []byte("file11"), func(key, _ []byte) bool {
+		scanned = append(scanned, string(key))
+		return true
+	})
+	if got := strings.Join(scanned, ","); got != "file10,file10a" {
+		t.Fatalf("Expected [file3, file11) to hold file10 and file10a, got %s", got)
+	}
+}
+
+func TestComparator_BulkLoad(t *testing.T) {
+	pairs := []Pair{{Key: []byte("a2")}, {Key: []byte("a10")}, {Key: []byte("b1")}}
+	tree, err := BulkLoad(3, pairs, DefaultFillFactor, WithComparator(Natural))
+	if err != nil {
+		t.Fatalf("Failed to bulk load in natural order: %v", err)
+	}
+	if tree.Comparator().Name != Natural.Name {
+		t.Fatalf("Expected the natural comparator, got %s", tree.Comparator().Name)
+	}
+	if _, err := BulkLoad(3, pairs, DefaultFillFactor); err == nil {
+		t.Fatal("Expected a natural ordering to be unsorted bytewise")
+	}
+}
+
+func TestComparator_SaveLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	for name, tree := range map[string]*BPlusTree{
+		"empty":  NewBPlusTree(4, WithComparator(CaseInsensitive)),
+		"filled": NewBPlusTree(3, WithComparator(CaseInsensitive)),
+	} {
+		if name == "filled" {
+			for _, key := range []string{"b", "A", "c", "D", "e"} {
+				tree.Insert([]byte(key), ksuid.New().Bytes())
+			}
+		}
+		filename := filepath.Join(dir, name+".dat")
+		if err := tree.Save(filename); err != nil {
+			t.Fatalf("Failed to save tree: %v", err)
+		}
+		loaded, err := LoadBPlusTree(filename)
+		if err != nil {
+			t.Fatalf("Failed to load tree: %v", err)
+		}
+		if loaded.Comparator().Name != CaseInsensitive.Name {
+			t.Fatalf("Expected the %s tree to load case-insensitive, got %s", name, loaded.Comparator().Name)
+		}
+		loaded.Insert([]byte("F"), ksuid.New().Bytes())
+		if _, found := loaded.Search([]byte("f")); !found {
+			t.Fatalf("Expected the loaded %s tree to ignore case", name)
+		}
+	}
+}
+
+func TestComparator_LoadUnregistered(t *testing.T) {
+	tree := NewBPlusTree(4, WithComparator(Comparator{Name: "test-unknown", Compare: Bytewise.Compare}))
+	filename := filepath.Join(t.TempDir(), "tree.dat")
+	if err := tree.Save(filename); err != nil {
+		t.Fatalf("Failed to save tree: %v", err)
+	}
+	if _, err := LoadBPlusTree(filename); err == nil || !strings.Contains(err.Error(), "test-unknown") {
+		t.Fatalf("Expected loading with an unregistered comparator to fail, got %v", err)
+	}
+
+	incomplete := NewBPlusTree(4, WithComparator(Comparator{Name: "incomplete"}))
+	if incomplete.Comparator().Name != Bytewise.Name {
+		t.Fatal("Expected a comparator without a compare function to be ignored")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected registering a comparator twice to panic")
+		}
+	}()
+	RegisterComparator(Natural)
+}
+
+func TestComparator_LegacyFile(t *testing.T) {
+	// Files saved before comparators existed start with the order
+	filename := filepath.Join(t.TempDir(), "legacy.dat")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	for _, v := range []uint32{5, 1, 0, 0} {
+		if err := binary.Write(file, binary.LittleEndian, v); err != nil {
+			t.Fatalf("Failed to write legacy header: %v", err)
+		}
+	}
+	file.Close()
+
+	tree, err := LoadBPlusTree(filename)
+	if err != nil {
+		t.Fatalf("Failed to load legacy tree: %v", err)
+	}
+	if tree.order != 5 || tree.Comparator().Name != Bytewise.Name {
+		t.Fatalf("Expected a bytewise tree of order 5, got order %d and %s", tree.order, tree.Comparator().Name)
+	}
+}
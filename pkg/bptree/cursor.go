@@ -0,0 +1,132 @@
+package bptree
+
+// Cursor walks the entries of a key range in ascending order by following
+// the leaf links, as returned by RangeScan. It buffers one leaf at a time,
+// copied under the leaf's read lock, and holds no locks between calls, so
+// the tree may be read and written while a cursor is open; changes to
+// leaves the cursor hasn't reached yet are seen. A Cursor is not safe for
+// concurrent use.
+//
+//	c := tree.RangeScan(start, end)
+//	defer c.Close()
+//	for c.Next() {
+//		use(c.Key(), c.Value())
+//	}
+type Cursor struct {
+	tree *BPlusTree
+	end  []byte // Exclusive upper bound; nil for none
+
+	keys   [][]byte // Buffered entries of the current leaf, from the cursor on
+	values [][]byte // Values of keys; a key repeats once per value in trees with duplicates
+	pos    int      // Current entry in keys; -1 before the first Next
+	next   *node    // Leaf after the buffered one; nil at the last leaf
+	done   bool
+}
+
+// RangeScan returns a cursor over the keys in [start, end) in ascending
+// order. A nil start means the first key and a nil end no upper bound. In a
+// tree with duplicates the cursor yields each of a key's values, in
+// ascending order.
+//
+// The cursor descends to the first candidate leaf with latch coupling like
+// Search. Because leaves are only ever split, never merged or freed, a leaf
+// split between hops is still reached through the links.
+//
+// Time complexity: O(log n) to open, O(1) amortized per Next
+func (tree *BPlusTree) RangeScan(start, end []byte) *Cursor {
+	c := &Cursor{tree: tree, end: end, pos: -1}
+
+	tree.m.RLock()
+	current := tree.root
+	if current == nil {
+		tree.m.RUnlock()
+		c.done = true
+		return c
+	}
+	current.mutex.RLock()
+	tree.m.RUnlock()
+
+	for !current.isLeaf {
+		child := current.children[0] // A nil start means the first key
+		if start != nil {
+			child = current.children[findChildIndex(tree.compare, current.keys, start)]
+		}
+		child.mutex.RLock()
+		current.mutex.RUnlock()
+		current = child
+	}
+	c.load(current, start)
+	return c
+}
+
+// load buffers the entries of leaf from start on and releases its read
+// lock, which the caller must hold
+func (c *Cursor) load(leaf *node, start []byte) {
+	c.keys = c.keys[:0]
+	c.values = c.values[:0]
+	for i, k := range leaf.keys {
+		if start != nil && c.tree.compare(k, start) < 0 {
+			continue
+		}
+		c.keys = append(c.keys, k)
+		c.values = append(c.values, leaf.values[i])
+		if leaf.extra != nil {
+			for _, value := range leaf.extra[i] {
+				c.keys = append(c.keys, k)
+				c.values = append(c.values, value)
+			}
+		}
+	}
+	c.next = leaf.next
+	c.pos = -1
+	leaf.mutex.RUnlock()
+}
+
+// Next advances to the next entry in the range, reporting false once the
+// range is exhausted
+func (c *Cursor) Next() bool {
+	for !c.done {
+		c.pos++
+		if c.pos < len(c.keys) {
+			if c.end != nil && c.tree.compare(c.keys[c.pos], c.end) >= 0 {
+				c.Close()
+				return false
+			}
+			return true
+		}
+		if c.next == nil {
+			c.Close()
+			return false
+		}
+		c.next.mutex.RLock()
+		c.load(c.next, nil)
+	}
+	return false
+}
+
+// Key returns the current entry's key. It is only valid after Next returned
+// true and must not be modified.
+func (c *Cursor) Key() []byte {
+	if c.done || c.pos < 0 {
+		return nil
+	}
+	return c.keys[c.pos]
+}
+
+// Value returns the current entry's value, which may be empty. It is only
+// valid after Next returned true and must not be modified.
+func (c *Cursor) Value() []byte {
+	if c.done || c.pos < 0 {
+		return nil
+	}
+	return c.values[c.pos]
+}
+
+// Close ends the scan and drops the buffered leaf; Next then reports false.
+// Closing twice is safe.
+func (c *Cursor) Close() {
+	c.done = true
+	c.keys = nil
+	c.values = nil
+	c.next = nil
+}
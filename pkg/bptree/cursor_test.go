@@ -0,0 +1,111 @@
+package bptree
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/segmentio/ksuid"
+)
+
+func TestBPlusTree_RangeScan(t *testing.T) {
+	tree := NewBPlusTree(4)
+	const n = 1000
+	values := make(map[string][]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		key := fmt.Sprintf("key%04d", i)
+		values[key] = ksuid.New().Bytes()
+		tree.Insert([]byte(key), values[key])
+	}
+
+	// The whole tree, across every leaf
+	c := tree.RangeScan(nil, nil)
+	count := 0
+	for c.Next() {
+		want := fmt.Sprintf("key%04d", count)
+		if string(c.Key()) != want || !bytes.Equal(c.Value(), values[want]) {
+			t.Fatalf("Entry %d: expected %s, got %s", count, want, c.Key())
+		}
+		count++
+	}
+	if count != n {
+		t.Fatalf("Expected %d keys, got %d", n, count)
+	}
+	if c.Next() || c.Key() != nil {
+		t.Fatal("Expected an exhausted cursor to stay exhausted")
+	}
+
+	// Bounds between and on keys
+	c = tree.RangeScan([]byte("key0100a"), []byte("key0300"))
+	count = 0
+	for c.Next() {
+		count++
+	}
+	if count != 199 {
+		t.Fatalf("Expected 199 keys in range, got %d", count)
+	}
+
+	// Closing early stops the scan
+	c = tree.RangeScan([]byte("key0500"), nil)
+	if !c.Next() || string(c.Key()) != "key0500" {
+		t.Fatalf("Expected key0500, got %s", c.Key())
+	}
+	c.Close()
+	c.Close()
+	if c.Next() {
+		t.Fatal("Expected a closed cursor to report no more entries")
+	}
+
+	if c := NewBPlusTree(4).RangeScan(nil, nil); c.Next() {
+		t.Fatal("Expected no entries in an empty tree")
+	}
+}
+
+func TestBPlusTree_RangeScanWhileWriting(t *testing.T) {
+	tree := NewBPlusTree(4)
+	for i := 0; i < 100; i += 2 {
+		tree.Insert([]byte(fmt.Sprintf("key%03d", i)), ksuid.New().Bytes())
+	}
+
+	// Inserts ahead of the cursor split leaves it hasn't reached yet; it
+	// still sees every key in order, including the new ones
+	c := tree.RangeScan(nil, nil)
+	defer c.Close()
+	var prev string
+	count := 0
+	for c.Next() {
+		key := string(c.Key())
+		if key <= prev {
+			t.Fatalf("Expected ascending keys, got %s after %s", key, prev)
+		}
+		prev = key
+		count++
+		if count == 5 {
+			for i := 51; i < 100; i += 2 {
+				tree.Insert([]byte(fmt.Sprintf("key%03d", i)), ksuid.New().Bytes())
+			}
+		}
+	}
+	if count != 75 {
+		t.Fatalf("Expected the 50 original and 25 new keys, got %d", count)
+	}
+}
+
+func TestBPlusTree_RangeScanDuplicates(t *testing.T) {
+	tree := NewBPlusTree(3, WithDuplicates())
+	want := sortedValues(5)
+	for _, value := range want {
+		tree.Insert([]byte("b"), value)
+	}
+	tree.Insert([]byte("a"), ksuid.New().Bytes())
+	tree.Insert([]byte("c"), ksuid.New().Bytes())
+
+	c := tree.RangeScan([]byte("b"), []byte("c"))
+	var got [][]byte
+	for c.Next() {
+		got = append(got, c.Value())
+	}
+	if !equalValues(got, want) {
+		t.Fatalf("Expected each of b's values in order, got %v", got)
+	}
+}
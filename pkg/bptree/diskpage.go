@@ -0,0 +1,237 @@
+package bptree
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// A DiskTree file is a sequence of fixed-size pages. Page 0 is the meta
+// page describing the tree and every other page holds one node. Each page
+// starts with the CRC32 of the rest of it, so a damaged page is caught
+// when it is read.
+
+// diskMagic starts the meta page of DiskTree files
+const diskMagic uint32 = 0x44504246 // "FBPD"
+
+// diskVersion is the version of the DiskTree file format
+const diskVersion uint32 = 1
+
+// Page sizes of DiskTree files
+const (
+	DefaultPageSize = 4096
+	MinPageSize     = 512
+	MaxPageSize     = 64 << 10
+)
+
+// Node page types
+const (
+	pageTypeLeaf     byte = 1
+	pageTypeInternal byte = 2
+)
+
+// pageHeaderSize is a node page's checksum, type, entry count and link:
+// the next leaf of a leaf, the first child of an internal node
+const pageHeaderSize = 4 + 1 + 2 + 4
+
+// Offsets of the meta page's fields, after its checksum
+const (
+	metaMagicOffset      = 4
+	metaVersionOffset    = 8
+	metaPageSizeOffset   = 12
+	metaFlagsOffset      = 16
+	metaRootOffset       = 20
+	metaHeightOffset     = 24
+	metaPageCountOffset  = 28
+	metaSizeOffset       = 32
+	metaComparatorOffset = 40 // Length-prefixed comparator name
+)
+
+// maxComparatorName bounds the comparator name saved in the meta page
+const maxComparatorName = 255
+
+// Meta page flags
+const (
+	metaFlagDuplicates uint32 = 1 // The tree was created with Duplicates
+	metaFlagClean      uint32 = 2 // Every page was written and synced by Flush
+)
+
+// diskMeta is the meta page: the tree's shape as of its last Flush
+type diskMeta struct {
+	pageSize   uint32
+	flags      uint32
+	root       uint32
+	height     uint32
+	pageCount  uint32
+	size       uint64
+	comparator string
+}
+
+// encodeMeta writes meta into page, a buffer of its page size
+func encodeMeta(page []byte, meta diskMeta) {
+	clear(page)
+	binary.LittleEndian.PutUint32(page[metaMagicOffset:], diskMagic)
+	binary.LittleEndian.PutUint32(page[metaVersionOffset:], diskVersion)
+	binary.LittleEndian.PutUint32(page[metaPageSizeOffset:], meta.pageSize)
+	binary.LittleEndian.PutUint32(page[metaFlagsOffset:], meta.flags)
+	binary.LittleEndian.PutUint32(page[metaRootOffset:], meta.root)
+	binary.LittleEndian.PutUint32(page[metaHeightOffset:], meta.height)
+	binary.LittleEndian.PutUint32(page[metaPageCountOffset:], meta.pageCount)
+	binary.LittleEndian.PutUint64(page[metaSizeOffset:], meta.size)
+	binary.LittleEndian.PutUint16(page[metaComparatorOffset:], uint16(len(meta.comparator))) //nolint:gosec // bounded by maxComparatorName
+	copy(page[metaComparatorOffset+2:], meta.comparator)
+	binary.LittleEndian.PutUint32(page[0:4], crc32.Checksum(page[4:], fileChecksumTable))
+}
+
+// decodeMeta reads the meta page, which must be a full page
+func decodeMeta(page []byte) (diskMeta, error) {
+	if want, got := binary.LittleEndian.Uint32(page[0:4]), crc32.Checksum(page[4:], fileChecksumTable); want != got {
+		return diskMeta{}, corruptf("meta page checksum mismatch: stored %08x, computed %08x", want, got)
+	}
+	if magic := binary.LittleEndian.Uint32(page[metaMagicOffset:]); magic != diskMagic {
+		return diskMeta{}, corruptf("not a disk tree file")
+	}
+	if version := binary.LittleEndian.Uint32(page[metaVersionOffset:]); version != diskVersion {
+		return diskMeta{}, corruptf("unsupported disk tree version %d", version)
+	}
+	meta := diskMeta{
+		pageSize:  binary.LittleEndian.Uint32(page[metaPageSizeOffset:]),
+		flags:     binary.LittleEndian.Uint32(page[metaFlagsOffset:]),
+		root:      binary.LittleEndian.Uint32(page[metaRootOffset:]),
+		height:    binary.LittleEndian.Uint32(page[metaHeightOffset:]),
+		pageCount: binary.LittleEndian.Uint32(page[metaPageCountOffset:]),
+		size:      binary.LittleEndian.Uint64(page[metaSizeOffset:]),
+	}
+	nameLen := int(binary.LittleEndian.Uint16(page[metaComparatorOffset:]))
+	if nameLen > maxComparatorName {
+		return diskMeta{}, corruptf("comparator name of %d bytes", nameLen)
+	}
+	meta.comparator = string(page[metaComparatorOffset+2 : metaComparatorOffset+2+nameLen])
+	if meta.pageCount < 2 || meta.root == 0 || meta.root >= meta.pageCount || meta.height == 0 {
+		return diskMeta{}, corruptf("root page %d, height %d in a tree of %d pages", meta.root, meta.height, meta.pageCount)
+	}
+	return meta, nil
+}
+
+// diskNode is a node page decoded into memory. Internal nodes keep a value
+// per separator key too, which is empty unless the tree has duplicates:
+// there entries are ordered by key and then value, so separators need both.
+type diskNode struct {
+	id       uint32
+	leaf     bool
+	keys     [][]byte
+	values   [][]byte
+	children []uint32 // Internal nodes only; len(children) = len(keys) + 1
+	next     uint32   // Next leaf, 0 for the last (leaf nodes only)
+	dirty    bool     // Changed since it was last written
+}
+
+// entrySize is the encoded size of entry i
+func (n *diskNode) entrySize(i int) int {
+	size := 2 + 2 + len(n.keys[i]) + len(n.values[i])
+	if !n.leaf {
+		size += 4 // Child after the key
+	}
+	return size
+}
+
+// size is the encoded size of the node, which must fit its page
+func (n *diskNode) size() int {
+	size := pageHeaderSize
+	for i := range n.keys {
+		size += n.entrySize(i)
+	}
+	return size
+}
+
+// splitPoint returns the entry at which the node's bytes are halved,
+// between lo and hi
+func (n *diskNode) splitPoint(lo, hi int) int {
+	half := (n.size() - pageHeaderSize) / 2
+	at, sum := 0, 0
+	for at < len(n.keys) && sum < half {
+		sum += n.entrySize(at)
+		at++
+	}
+	return max(lo, min(at, hi))
+}
+
+// encode writes the node into page, a buffer of the tree's page size
+func (n *diskNode) encode(page []byte) {
+	clear(page)
+	page[4] = pageTypeInternal
+	link := uint32(0)
+	if n.leaf {
+		page[4] = pageTypeLeaf
+		link = n.next
+	} else {
+		link = n.children[0]
+	}
+	binary.LittleEndian.PutUint16(page[5:], uint16(len(n.keys))) //nolint:gosec // bounded by the page size
+	binary.LittleEndian.PutUint32(page[7:], link)
+
+	pos := pageHeaderSize
+	for i, key := range n.keys {
+		value := n.values[i]
+		binary.LittleEndian.PutUint16(page[pos:], uint16(len(key)))     //nolint:gosec // bounded by the page size
+		binary.LittleEndian.PutUint16(page[pos+2:], uint16(len(value))) //nolint:gosec // bounded by the page size
+		pos += 4
+		pos += copy(page[pos:], key)
+		pos += copy(page[pos:], value)
+		if !n.leaf {
+			binary.LittleEndian.PutUint32(page[pos:], n.children[i+1])
+			pos += 4
+		}
+	}
+	binary.LittleEndian.PutUint32(page[0:4], crc32.Checksum(page[4:], fileChecksumTable))
+}
+
+// decodeNode reads node id from page, checking its checksum and that every
+// length fits the page. Keys and values point into page.
+func decodeNode(id uint32, page []byte) (*diskNode, error) {
+	if want, got := binary.LittleEndian.Uint32(page[0:4]), crc32.Checksum(page[4:], fileChecksumTable); want != got {
+		return nil, corruptf("page %d checksum mismatch: stored %08x, computed %08x", id, want, got)
+	}
+	typ := page[4]
+	if typ != pageTypeLeaf && typ != pageTypeInternal {
+		return nil, corruptf("page %d has unknown type %d", id, typ)
+	}
+	count := int(binary.LittleEndian.Uint16(page[5:]))
+	link := binary.LittleEndian.Uint32(page[7:])
+	n := &diskNode{
+		id:     id,
+		leaf:   typ == pageTypeLeaf,
+		keys:   make([][]byte, 0, count),
+		values: make([][]byte, 0, count),
+	}
+	if n.leaf {
+		n.next = link
+	} else {
+		n.children = append(make([]uint32, 0, count+1), link)
+	}
+
+	pos := pageHeaderSize
+	for i := 0; i < count; i++ {
+		if pos+4 > len(page) {
+			return nil, corruptf("page %d entry %d past the page end", id, i)
+		}
+		keyLen := int(binary.LittleEndian.Uint16(page[pos:]))
+		valueLen := int(binary.LittleEndian.Uint16(page[pos+2:]))
+		pos += 4
+		end := pos + keyLen + valueLen
+		if !n.leaf {
+			end += 4
+		}
+		if end > len(page) {
+			return nil, corruptf("page %d entry %d past the page end", id, i)
+		}
+		n.keys = append(n.keys, page[pos:pos+keyLen:pos+keyLen])
+		pos += keyLen
+		n.values = append(n.values, page[pos:pos+valueLen:pos+valueLen])
+		pos += valueLen
+		if !n.leaf {
+			n.children = append(n.children, binary.LittleEndian.Uint32(page[pos:]))
+			pos += 4
+		}
+	}
+	return n, nil
+}
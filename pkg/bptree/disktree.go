@@ -0,0 +1,815 @@
+package bptree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrTreeClosed is returned by the methods of a DiskTree after Close
+var ErrTreeClosed = errors.New("bptree: disk tree is closed")
+
+// ErrEntryTooLarge is returned by DiskTree.Insert for a key and value too
+// large to share a page with others
+var ErrEntryTooLarge = errors.New("bptree: entry too large for the page size")
+
+// maxTreeHeight bounds the height read from a DiskTree file, so a corrupt
+// file can't send a descent around a cycle of pages
+const maxTreeHeight = 64
+
+// DiskOptions configures a DiskTree opened by OpenDiskTree
+type DiskOptions struct {
+	// PageSize is the size of the file's pages, between MinPageSize and
+	// MaxPageSize (0 = DefaultPageSize). It only applies to new files;
+	// existing ones keep the size they were created with.
+	PageSize int
+
+	// CachePages is how many pages the page cache holds (0 = DefaultCachePages)
+	CachePages int
+
+	// Comparator orders the keys of a new file, bytewise by default. An
+	// existing file is opened with the comparator of the name it was
+	// created with: this one, or if none is given, the registered one.
+	Comparator Comparator
+
+	// Duplicates lets each key of a new file hold a set of values, as
+	// WithDuplicates does. Existing files keep the setting they were
+	// created with.
+	Duplicates bool
+}
+
+// DiskTree is a B+Tree kept in a file of fixed-size pages, for trees too
+// large to hold in memory, such as big secondary indexes. Every node is one
+// page. Only the pages in the tree's page cache are in memory, and a write
+// changes the nodes it touches in the cache; they are written back to their
+// pages when evicted or by Flush, so the file is never rewritten as a whole
+// the way BPlusTree.Save rewrites it.
+//
+// Keys and values are arbitrary bytes, but an entry's key and value
+// together must take at most about a quarter of a page (1013 bytes with
+// the default page size) so that split nodes always fit theirs. In a tree
+// with duplicates each value of a key is an entry of its own, ordered by
+// key and then value, so a key's values may span several leaves.
+//
+// A DiskTree is safe for concurrent use: reads share the tree, writes hold
+// it exclusively. Like BPlusTree, Delete doesn't rebalance, so nodes are
+// only ever split, and pages are never freed.
+//
+// The meta page records whether every page was written since the last
+// change. The first write after a Flush clears the mark and Flush sets it
+// again once the pages are synced, so a file left by a crash in between
+// fails to open with ErrCorrupt and is rebuilt from the data it indexes,
+// like a damaged BPlusTree file.
+type DiskTree struct {
+	m          sync.RWMutex // Reads hold it shared, writes and Flush exclusively
+	file       *os.File     // nil once closed
+	cache      *pageCache
+	pageSize   int
+	maxEntry   int // Largest key and value, together, an entry may hold
+	comparator Comparator
+	duplicates bool
+	root       uint32
+	height     int
+	pageCount  uint32       // Pages in the file, the meta page included
+	size       atomic.Int64 // Entries in the tree
+	clean      bool         // The file matches the cache and is marked clean
+	flushes    uint64
+}
+
+// DiskStats describes a DiskTree's file and page cache
+type DiskStats struct {
+	Pages       int    // Pages in the file, the meta page included
+	PageSize    int    // Size of each page in bytes
+	CachedPages int    // Pages held by the page cache
+	DirtyPages  int    // Cached pages changed since they were last written
+	CacheHits   uint64 // Page reads served by the cache
+	CacheMisses uint64 // Page reads from the file
+	PageWrites  uint64 // Pages written back, by eviction or Flush
+	Flushes     uint64 // Flushes that had changes to write
+}
+
+// OpenDiskTree opens the disk tree in the file at path, creating it if it
+// doesn't exist or is empty
+func OpenDiskTree(path string, opts DiskOptions) (*DiskTree, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open disk tree: %w", err)
+	}
+	tree, err := openDiskTree(file, opts)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return tree, nil
+}
+
+// openDiskTree reads the meta page of file, or starts a new tree in it if
+// it is empty
+func openDiskTree(file *os.File, opts DiskOptions) (*DiskTree, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat disk tree: %w", err)
+	}
+	tree := &DiskTree{file: file}
+	tree.cache = newPageCache(opts.CachePages, tree.readNode, tree.writeNode)
+
+	if info.Size() == 0 {
+		if err := tree.create(opts); err != nil {
+			return nil, err
+		}
+		return tree, nil
+	}
+
+	var prefix [metaComparatorOffset]byte
+	if _, err := file.ReadAt(prefix[:], 0); err != nil {
+		if err == io.EOF {
+			return nil, corruptf("file too short for a meta page")
+		}
+		return nil, fmt.Errorf("failed to read meta page: %w", err)
+	}
+	pageSize := int(binary.LittleEndian.Uint32(prefix[metaPageSizeOffset:]))
+	if pageSize < MinPageSize || pageSize > MaxPageSize {
+		return nil, corruptf("page size %d", pageSize)
+	}
+	page := make([]byte, pageSize)
+	if _, err := file.ReadAt(page, 0); err != nil {
+		if err == io.EOF {
+			return nil, corruptf("file too short for its meta page")
+		}
+		return nil, fmt.Errorf("failed to read meta page: %w", err)
+	}
+	meta, err := decodeMeta(page)
+	if err != nil {
+		return nil, err
+	}
+	if meta.flags&metaFlagClean == 0 {
+		return nil, corruptf("tree was not flushed after its last change")
+	}
+	if meta.height > maxTreeHeight {
+		return nil, corruptf("height %d", meta.height)
+	}
+	if want := int64(meta.pageCount) * int64(pageSize); info.Size() < want {
+		return nil, corruptf("file of %d bytes holds fewer than its %d pages", info.Size(), meta.pageCount)
+	}
+
+	comparator := opts.Comparator
+	switch {
+	case comparator.Compare == nil:
+		if comparator, err = lookupComparator(meta.comparator); err != nil {
+			return nil, err
+		}
+	case comparator.Name != meta.comparator:
+		return nil, fmt.Errorf("tree was built with comparator %q, not %q", meta.comparator, comparator.Name)
+	}
+
+	tree.setPageSize(pageSize)
+	tree.comparator = comparator
+	tree.duplicates = meta.flags&metaFlagDuplicates != 0
+	tree.root = meta.root
+	tree.height = int(meta.height)
+	tree.pageCount = meta.pageCount
+	tree.size.Store(int64(meta.size)) //nolint:gosec // entries fit in int64
+	tree.clean = true
+	return tree, nil
+}
+
+// create starts a new tree of one empty leaf in the tree's empty file
+func (tree *DiskTree) create(opts DiskOptions) error {
+	pageSize := opts.PageSize
+	if pageSize == 0 {
+		pageSize = DefaultPageSize
+	}
+	if pageSize < MinPageSize || pageSize > MaxPageSize {
+		return fmt.Errorf("page size %d is not between %d and %d", pageSize, MinPageSize, MaxPageSize)
+	}
+	tree.comparator = Bytewise
+	if opts.Comparator.Compare != nil {
+		tree.comparator = opts.Comparator
+	}
+	if len(tree.comparator.Name) > maxComparatorName {
+		return fmt.Errorf("comparator name %q is longer than %d bytes", tree.comparator.Name, maxComparatorName)
+	}
+	tree.setPageSize(pageSize)
+	tree.duplicates = opts.Duplicates
+	tree.root = 1
+	tree.height = 1
+	tree.pageCount = 2
+	tree.cache.add(&diskNode{id: tree.root, leaf: true})
+	return tree.flushInternal()
+}
+
+// setPageSize sets the page size and the largest entry it holds: a quarter
+// of a page, less an internal entry's lengths and child
+func (tree *DiskTree) setPageSize(pageSize int) {
+	tree.pageSize = pageSize
+	tree.maxEntry = (pageSize-pageHeaderSize)/4 - (2 + 2 + 4)
+}
+
+// Comparator returns the comparator the tree orders its keys with
+func (tree *DiskTree) Comparator() Comparator {
+	return tree.comparator
+}
+
+// Duplicates reports whether keys can hold more than one value
+func (tree *DiskTree) Duplicates() bool {
+	return tree.duplicates
+}
+
+// Size returns the number of entries in the tree: its number of keys, or
+// in a tree with duplicates the values of all keys.
+//
+// This method is thread-safe and doesn't lock the tree.
+func (tree *DiskTree) Size() int {
+	return int(tree.size.Load())
+}
+
+// Height returns the number of levels in the tree (1 for a single leaf)
+func (tree *DiskTree) Height() int {
+	tree.m.RLock()
+	defer tree.m.RUnlock()
+	return tree.height
+}
+
+// Stats returns the tree's page and cache counters
+func (tree *DiskTree) Stats() DiskStats {
+	tree.m.RLock()
+	defer tree.m.RUnlock()
+
+	tree.cache.mu.Lock()
+	defer tree.cache.mu.Unlock()
+	return DiskStats{
+		Pages:       int(tree.pageCount),
+		PageSize:    tree.pageSize,
+		CachedPages: tree.cache.lru.Len(),
+		DirtyPages:  tree.cache.dirty,
+		CacheHits:   tree.cache.hits,
+		CacheMisses: tree.cache.misses,
+		PageWrites:  tree.cache.writes,
+		Flushes:     tree.flushes,
+	}
+}
+
+// Search returns the value of key and true if the key exists. In a tree
+// with duplicates it returns the key's smallest value; use Values for all
+// of them. The value is the tree's own and must not be modified.
+func (tree *DiskTree) Search(key []byte) (value []byte, found bool, err error) {
+	tree.m.RLock()
+	defer tree.runlock(&err)
+	if tree.file == nil {
+		return nil, false, ErrTreeClosed
+	}
+
+	leaf, i, err := tree.seek(key, nil)
+	if err != nil || leaf == nil || tree.compare(leaf.keys[i], key) != 0 {
+		return nil, false, err
+	}
+	return leaf.values[i], true, nil
+}
+
+// Values returns every value of key in ascending order, or nil if the key
+// does not exist. In a tree without duplicates it holds at most one value.
+// The values are the tree's own and must not be modified.
+func (tree *DiskTree) Values(key []byte) (values [][]byte, err error) {
+	tree.m.RLock()
+	defer tree.runlock(&err)
+	if tree.file == nil {
+		return nil, ErrTreeClosed
+	}
+
+	leaf, i, err := tree.seek(key, nil)
+	for err == nil && leaf != nil {
+		for ; i < len(leaf.keys); i++ {
+			if tree.compare(leaf.keys[i], key) != 0 {
+				return values, nil
+			}
+			values = append(values, leaf.values[i])
+		}
+		if !tree.duplicates || leaf.next == 0 {
+			break
+		}
+		leaf, err = tree.cache.get(leaf.next)
+		i = 0
+	}
+	return values, err
+}
+
+// Insert adds or updates a key-value pair. If the key already exists its
+// value is replaced, or in a tree with duplicates the value is added to the
+// key's values unless the key already holds it. The key and value are
+// copied.
+//
+// The leaf, and any nodes split above it, change in the page cache; they
+// reach the file when evicted or flushed.
+func (tree *DiskTree) Insert(key, value []byte) (err error) {
+	if len(key)+len(value) > tree.maxEntry {
+		return fmt.Errorf("%w: %d bytes, at most %d", ErrEntryTooLarge, len(key)+len(value), tree.maxEntry)
+	}
+	tree.m.Lock()
+	defer tree.unlock(&err)
+	if tree.file == nil {
+		return ErrTreeClosed
+	}
+
+	// Descend to the leaf, remembering the path for splits
+	var path []*diskNode
+	n, err := tree.cache.get(tree.root)
+	for err == nil && !n.leaf {
+		if len(path) == tree.height-1 {
+			return corruptf("page %d deeper than the tree's height %d", n.id, tree.height)
+		}
+		path = append(path, n)
+		n, err = tree.cache.get(n.children[tree.childIndex(n, key, value)])
+	}
+	if err != nil {
+		return err
+	}
+
+	i, found := tree.leafIndex(n, key, value)
+	if found && tree.duplicates {
+		return nil
+	}
+	if err := tree.beginWrite(); err != nil {
+		return err
+	}
+	key, value = bytes.Clone(key), bytes.Clone(value)
+	if found {
+		n.values[i] = value
+	} else {
+		n.keys = slices.Insert(n.keys, i, key)
+		n.values = slices.Insert(n.values, i, value)
+		tree.size.Add(1)
+	}
+	tree.cache.markDirty(n)
+	return tree.split(path, n)
+}
+
+// Delete removes a key, reporting whether it existed. In a tree with
+// duplicates every value of the key is removed; DeleteValue removes one.
+func (tree *DiskTree) Delete(key []byte) (deleted bool, err error) {
+	tree.m.Lock()
+	defer tree.unlock(&err)
+	if tree.file == nil {
+		return false, ErrTreeClosed
+	}
+
+	leaf, i, err := tree.seek(key, nil)
+	for err == nil && leaf != nil {
+		for i < len(leaf.keys) && tree.compare(leaf.keys[i], key) == 0 {
+			if err := tree.removeEntry(leaf, i); err != nil {
+				return deleted, err
+			}
+			deleted = true
+		}
+		if i < len(leaf.keys) || !tree.duplicates || leaf.next == 0 {
+			break
+		}
+		leaf, err = tree.cache.get(leaf.next)
+		i = 0
+	}
+	return deleted, err
+}
+
+// DeleteValue removes one value of key, reporting whether it was found. In
+// a tree without duplicates it deletes the key if the key holds value.
+func (tree *DiskTree) DeleteValue(key, value []byte) (deleted bool, err error) {
+	tree.m.Lock()
+	defer tree.unlock(&err)
+	if tree.file == nil {
+		return false, ErrTreeClosed
+	}
+
+	leaf, i, err := tree.seek(key, value)
+	if err != nil || leaf == nil || tree.compare(leaf.keys[i], key) != 0 || !bytes.Equal(leaf.values[i], value) {
+		return false, err
+	}
+	return true, tree.removeEntry(leaf, i)
+}
+
+// Ascend calls fn for each entry in [start, end) in ascending order,
+// stopping early if fn returns false. A nil end means no upper bound. fn
+// is called with no locks held and may call back into the tree.
+func (tree *DiskTree) Ascend(start, end []byte, fn func(key, value []byte) bool) error {
+	c := tree.RangeScan(start, end)
+	defer c.Close()
+	for c.Next() {
+		if !fn(c.Key(), c.Value()) {
+			return nil
+		}
+	}
+	return c.Err()
+}
+
+// Flush writes every changed page back and syncs the file, then marks the
+// file clean in its meta page. Only the pages changed since the last
+// Flush, and not yet evicted, are written.
+func (tree *DiskTree) Flush() error {
+	tree.m.Lock()
+	defer tree.m.Unlock()
+	if tree.file == nil {
+		return ErrTreeClosed
+	}
+	return tree.flushInternal()
+}
+
+// Close flushes the tree and closes its file. Closing twice is safe.
+func (tree *DiskTree) Close() error {
+	tree.m.Lock()
+	defer tree.m.Unlock()
+	if tree.file == nil {
+		return nil
+	}
+
+	err := tree.flushInternal()
+	if closeErr := tree.file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close disk tree: %w", closeErr)
+	}
+	tree.file = nil
+	tree.cache = newPageCache(1, nil, nil)
+	return err
+}
+
+// flushInternal writes the dirty pages and then the clean meta page,
+// syncing after each (caller must hold the write lock)
+func (tree *DiskTree) flushInternal() error {
+	if tree.clean {
+		return nil
+	}
+	if err := tree.cache.flush(); err != nil {
+		return err
+	}
+	if err := tree.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync disk tree: %w", err)
+	}
+	if err := tree.writeMeta(true); err != nil {
+		return err
+	}
+	tree.clean = true
+	tree.flushes++
+	return nil
+}
+
+// beginWrite marks the file as changing before the first write after a
+// Flush, so a crash before the next one is detected on open (caller must
+// hold the write lock)
+func (tree *DiskTree) beginWrite() error {
+	if !tree.clean {
+		return nil
+	}
+	if err := tree.writeMeta(false); err != nil {
+		return err
+	}
+	tree.clean = false
+	return nil
+}
+
+// writeMeta writes and syncs the meta page
+func (tree *DiskTree) writeMeta(clean bool) error {
+	meta := diskMeta{
+		pageSize:   uint32(tree.pageSize), //nolint:gosec // bounded by MaxPageSize
+		root:       tree.root,
+		height:     uint32(tree.height), //nolint:gosec // bounded by maxTreeHeight
+		pageCount:  tree.pageCount,
+		size:       uint64(tree.size.Load()), //nolint:gosec // never negative
+		comparator: tree.comparator.Name,
+	}
+	if tree.duplicates {
+		meta.flags |= metaFlagDuplicates
+	}
+	if clean {
+		meta.flags |= metaFlagClean
+	}
+	page := make([]byte, tree.pageSize)
+	encodeMeta(page, meta)
+	if _, err := tree.file.WriteAt(page, 0); err != nil {
+		return fmt.Errorf("failed to write meta page: %w", err)
+	}
+	if err := tree.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync disk tree: %w", err)
+	}
+	return nil
+}
+
+// readNode reads and decodes page id for the page cache
+func (tree *DiskTree) readNode(id uint32) (*diskNode, error) {
+	if id == 0 || id >= tree.pageCount {
+		return nil, corruptf("page %d out of range", id)
+	}
+	page := make([]byte, tree.pageSize)
+	if _, err := tree.file.ReadAt(page, int64(id)*int64(tree.pageSize)); err != nil {
+		if err == io.EOF {
+			return nil, corruptf("page %d past the end of the file", id)
+		}
+		return nil, fmt.Errorf("failed to read page %d: %w", id, err)
+	}
+	return decodeNode(id, page)
+}
+
+// writeNode encodes and writes a node to its page for the page cache
+func (tree *DiskTree) writeNode(n *diskNode) error {
+	page := make([]byte, tree.pageSize)
+	n.encode(page)
+	if _, err := tree.file.WriteAt(page, int64(n.id)*int64(tree.pageSize)); err != nil {
+		return fmt.Errorf("failed to write page %d: %w", n.id, err)
+	}
+	return nil
+}
+
+// runlock trims the page cache and releases a read lock, returning a
+// failed write-back through err unless it already holds an error
+func (tree *DiskTree) runlock(err *error) {
+	if tree.file != nil {
+		if trimErr := tree.cache.trim(); *err == nil {
+			*err = trimErr
+		}
+	}
+	tree.m.RUnlock()
+}
+
+// unlock is runlock for the write lock
+func (tree *DiskTree) unlock(err *error) {
+	if tree.file != nil {
+		if trimErr := tree.cache.trim(); *err == nil {
+			*err = trimErr
+		}
+	}
+	tree.m.Unlock()
+}
+
+// compare orders two keys with the tree's comparator
+func (tree *DiskTree) compare(a, b []byte) int {
+	return tree.comparator.Compare(a, b)
+}
+
+// compareEntry orders two entries: by key, and in trees with duplicates
+// then by value
+func (tree *DiskTree) compareEntry(keyA, valueA, keyB, valueB []byte) int {
+	if c := tree.compare(keyA, keyB); c != 0 || !tree.duplicates {
+		return c
+	}
+	return bytes.Compare(valueA, valueB)
+}
+
+// childIndex returns the child of internal node n to follow for an entry
+func (tree *DiskTree) childIndex(n *diskNode, key, value []byte) int {
+	return sort.Search(len(n.keys), func(i int) bool {
+		return tree.compareEntry(key, value, n.keys[i], n.values[i]) < 0
+	})
+}
+
+// leafIndex returns the first entry of leaf n at or after an entry, and
+// whether it is that entry
+func (tree *DiskTree) leafIndex(n *diskNode, key, value []byte) (int, bool) {
+	i := sort.Search(len(n.keys), func(i int) bool {
+		return tree.compareEntry(n.keys[i], n.values[i], key, value) >= 0
+	})
+	return i, i < len(n.keys) && tree.compareEntry(n.keys[i], n.values[i], key, value) == 0
+}
+
+// seek returns the leaf and index of the first entry at or after an
+// entry, following the leaf links past leaves it is beyond the end of. A
+// nil key means the first entry. It returns a nil leaf when there is none.
+// The caller must hold a lock.
+func (tree *DiskTree) seek(key, value []byte) (*diskNode, int, error) {
+	n, err := tree.cache.get(tree.root)
+	for depth := 1; err == nil && !n.leaf; depth++ {
+		if depth == tree.height {
+			return nil, 0, corruptf("page %d deeper than the tree's height %d", n.id, tree.height)
+		}
+		i := 0 // A nil key means the first entry
+		if key != nil {
+			i = tree.childIndex(n, key, value)
+		}
+		n, err = tree.cache.get(n.children[i])
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	i := 0
+	if key != nil {
+		i, _ = tree.leafIndex(n, key, value)
+	}
+	for i == len(n.keys) {
+		if n.next == 0 {
+			return nil, 0, nil
+		}
+		if n, err = tree.cache.get(n.next); err != nil {
+			return nil, 0, err
+		}
+		i = 0
+	}
+	return n, i, nil
+}
+
+// removeEntry removes entry i of leaf (caller must hold the write lock)
+func (tree *DiskTree) removeEntry(leaf *diskNode, i int) error {
+	if err := tree.beginWrite(); err != nil {
+		return err
+	}
+	leaf.keys = slices.Delete(leaf.keys, i, i+1)
+	leaf.values = slices.Delete(leaf.values, i, i+1)
+	tree.size.Add(-1)
+	tree.cache.markDirty(leaf)
+	return nil
+}
+
+// split splits n if it no longer fits its page, and its ancestors on path
+// in turn, growing a new root if the old one splits. Since an entry is at
+// most a quarter of a page, halving a node by bytes always fits both
+// halves. The caller must hold the write lock.
+func (tree *DiskTree) split(path []*diskNode, n *diskNode) error {
+	for n.size() > tree.pageSize {
+		right, err := tree.newNode(n.leaf)
+		if err != nil {
+			return err
+		}
+		var sepKey, sepValue []byte
+		if n.leaf {
+			mid := n.splitPoint(1, len(n.keys)-1)
+			right.keys = slices.Clone(n.keys[mid:])
+			right.values = slices.Clone(n.values[mid:])
+			n.keys, n.values = n.keys[:mid], n.values[:mid]
+			right.next, n.next = n.next, right.id
+			sepKey = right.keys[0]
+			if tree.duplicates {
+				sepValue = right.values[0]
+			}
+		} else {
+			mid := n.splitPoint(1, len(n.keys)-2)
+			sepKey, sepValue = n.keys[mid], n.values[mid]
+			right.keys = slices.Clone(n.keys[mid+1:])
+			right.values = slices.Clone(n.values[mid+1:])
+			right.children = slices.Clone(n.children[mid+1:])
+			n.keys, n.values, n.children = n.keys[:mid], n.values[:mid], n.children[:mid+1]
+		}
+		tree.cache.markDirty(n)
+
+		if len(path) == 0 {
+			root, err := tree.newNode(false)
+			if err != nil {
+				return err
+			}
+			root.keys = [][]byte{sepKey}
+			root.values = [][]byte{sepValue}
+			root.children = []uint32{n.id, right.id}
+			tree.root = root.id
+			tree.height++
+			return nil
+		}
+		parent := path[len(path)-1]
+		path = path[:len(path)-1]
+		at := slices.Index(parent.children, n.id)
+		if at < 0 {
+			return corruptf("page %d missing from its parent %d", n.id, parent.id)
+		}
+		parent.keys = slices.Insert(parent.keys, at, sepKey)
+		parent.values = slices.Insert(parent.values, at, sepValue)
+		parent.children = slices.Insert(parent.children, at+1, right.id)
+		tree.cache.markDirty(parent)
+		n = parent
+	}
+	return nil
+}
+
+// newNode allocates the next page for a new node (caller must hold the
+// write lock)
+func (tree *DiskTree) newNode(leaf bool) (*diskNode, error) {
+	if tree.pageCount == ^uint32(0) {
+		return nil, fmt.Errorf("disk tree has no page IDs left")
+	}
+	if !leaf && tree.height >= maxTreeHeight {
+		return nil, fmt.Errorf("disk tree reached its maximum height %d", maxTreeHeight)
+	}
+	n := &diskNode{id: tree.pageCount, leaf: leaf}
+	tree.pageCount++
+	tree.cache.add(n)
+	return n, nil
+}
+
+// DiskCursor walks the entries of a DiskTree key range in ascending order
+// by following the leaf links, as returned by RangeScan. Like Cursor, it
+// buffers one leaf at a time, read under the tree's read lock, and holds
+// no locks between calls, so the tree may be read and written while a
+// cursor is open. A DiskCursor is not safe for concurrent use.
+type DiskCursor struct {
+	tree *DiskTree
+	end  []byte // Exclusive upper bound; nil for none
+
+	keys   [][]byte // Buffered entries of the current leaf, from the cursor on
+	values [][]byte
+	pos    int    // Current entry in keys; -1 before the first Next
+	next   uint32 // Leaf after the buffered one; 0 at the last leaf
+	done   bool
+	err    error
+}
+
+// RangeScan returns a cursor over the entries in [start, end) in ascending
+// order. A nil start means the first key and a nil end no upper bound. In a
+// tree with duplicates the cursor yields each of a key's values, in
+// ascending order. Check Err once Next reports false.
+func (tree *DiskTree) RangeScan(start, end []byte) *DiskCursor {
+	c := &DiskCursor{tree: tree, end: end, pos: -1}
+	c.load(func() (*diskNode, int, error) {
+		return tree.seek(start, nil)
+	})
+	return c
+}
+
+// load buffers the entries of the leaf find returns, from its index on,
+// under the tree's read lock
+func (c *DiskCursor) load(find func() (*diskNode, int, error)) {
+	tree := c.tree
+	var err error
+	tree.m.RLock()
+	defer func() {
+		tree.runlock(&err)
+		if err != nil {
+			c.err = err
+			c.Close()
+		}
+	}()
+	if tree.file == nil {
+		err = ErrTreeClosed
+		return
+	}
+
+	leaf, i, err := find()
+	if err != nil || leaf == nil {
+		c.done = true
+		return
+	}
+	c.keys = append(c.keys[:0], leaf.keys[i:]...)
+	c.values = append(c.values[:0], leaf.values[i:]...)
+	c.next = leaf.next
+	c.pos = -1
+}
+
+// Next advances to the next entry in the range, reporting false once the
+// range is exhausted or reading a page failed
+func (c *DiskCursor) Next() bool {
+	for !c.done {
+		c.pos++
+		if c.pos < len(c.keys) {
+			if c.end != nil && c.tree.compare(c.keys[c.pos], c.end) >= 0 {
+				c.Close()
+				return false
+			}
+			return true
+		}
+		if c.next == 0 {
+			c.Close()
+			return false
+		}
+		next := c.next
+		c.load(func() (*diskNode, int, error) {
+			leaf, err := c.tree.cache.get(next)
+			if err != nil || !leaf.leaf {
+				if err == nil {
+					err = corruptf("leaf link to internal page %d", next)
+				}
+				return nil, 0, err
+			}
+			return leaf, 0, nil
+		})
+	}
+	return false
+}
+
+// Key returns the current entry's key. It is only valid after Next returned
+// true and must not be modified.
+func (c *DiskCursor) Key() []byte {
+	if c.done || c.pos < 0 {
+		return nil
+	}
+	return c.keys[c.pos]
+}
+
+// Value returns the current entry's value, which may be empty. It is only
+// valid after Next returned true and must not be modified.
+func (c *DiskCursor) Value() []byte {
+	if c.done || c.pos < 0 {
+		return nil
+	}
+	return c.values[c.pos]
+}
+
+// Err returns the error that ended the scan, if reading a page failed
+func (c *DiskCursor) Err() error {
+	return c.err
+}
+
+// Close ends the scan and drops the buffered leaf; Next then reports false.
+// Closing twice is safe.
+func (c *DiskCursor) Close() {
+	c.done = true
+	c.keys = nil
+	c.values = nil
+	c.next = 0
+}
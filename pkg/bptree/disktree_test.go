@@ -0,0 +1,332 @@
+package bptree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestDiskTree_InsertSearchReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	// Small pages and cache, so the tree is many pages deep and most of
+	// them are evicted while it grows
+	opts := DiskOptions{PageSize: MinPageSize, CachePages: 8}
+	tree, err := OpenDiskTree(path, opts)
+	if err != nil {
+		t.Fatalf("OpenDiskTree failed: %v", err)
+	}
+
+	const n = 2000
+	for _, i := range rand.New(rand.NewSource(1)).Perm(n) {
+		key := fmt.Sprintf("key%05d", i)
+		if err := tree.Insert([]byte(key), []byte("value-"+key)); err != nil {
+			t.Fatalf("Insert %s failed: %v", key, err)
+		}
+	}
+	if err := tree.Insert([]byte("key00007"), []byte("replaced")); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	for i := 0; i < n; i += 2 {
+		if deleted, err := tree.Delete([]byte(fmt.Sprintf("key%05d", i+1))); err != nil || !deleted {
+			t.Fatalf("Delete failed: %v, %v", deleted, err)
+		}
+	}
+	if deleted, err := tree.Delete([]byte("missing")); err != nil || deleted {
+		t.Fatalf("Expected nothing to delete, got %v, %v", deleted, err)
+	}
+	stats := tree.Stats()
+	if tree.Height() < 3 || stats.CachedPages > opts.CachePages || stats.CacheMisses == 0 || stats.PageWrites == 0 {
+		t.Errorf("Expected a deep tree paged through a small cache, got height %d, %+v", tree.Height(), stats)
+	}
+
+	check := func(tree *DiskTree) {
+		t.Helper()
+		if tree.Size() != n/2 {
+			t.Errorf("Expected %d entries, got %d", n/2, tree.Size())
+		}
+		for i := 0; i < n; i++ {
+			key := fmt.Sprintf("key%05d", i)
+			want := "value-" + key
+			if i == 7 {
+				want = "replaced"
+			}
+			value, found, err := tree.Search([]byte(key))
+			if err != nil || found != (i%2 == 0) || (found && string(value) != want) {
+				t.Fatalf("Search %s: got %q, %v, %v", key, value, found, err)
+			}
+		}
+
+		count := 0
+		err := tree.Ascend([]byte("key00100"), []byte("key00200"), func(key, value []byte) bool {
+			if want := fmt.Sprintf("key%05d", 100+2*count); string(key) != want {
+				t.Fatalf("Expected %s, got %s", want, key)
+			}
+			count++
+			return true
+		})
+		if err != nil || count != 50 {
+			t.Errorf("Expected 50 keys in range, got %d, %v", count, err)
+		}
+		c := tree.RangeScan(nil, nil)
+		count = 0
+		for c.Next() {
+			count++
+		}
+		if c.Err() != nil || count != n/2 {
+			t.Errorf("Expected a full scan of %d keys, got %d, %v", n/2, count, c.Err())
+		}
+	}
+	check(tree)
+
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Errorf("Expected a second Close to succeed, got %v", err)
+	}
+	if _, _, err := tree.Search([]byte("key00000")); !errors.Is(err, ErrTreeClosed) {
+		t.Errorf("Expected ErrTreeClosed, got %v", err)
+	}
+
+	// The page size comes from the file
+	tree, err = OpenDiskTree(path, DiskOptions{PageSize: DefaultPageSize, CachePages: 8})
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer tree.Close()
+	if tree.Stats().PageSize != MinPageSize {
+		t.Errorf("Expected the file's page size, got %d", tree.Stats().PageSize)
+	}
+	check(tree)
+}
+
+func TestDiskTree_PartialWrites(t *testing.T) {
+	tree, err := OpenDiskTree(filepath.Join(t.TempDir(), "tree.db"), DiskOptions{PageSize: 1024})
+	if err != nil {
+		t.Fatalf("OpenDiskTree failed: %v", err)
+	}
+	defer tree.Close()
+	for i := 0; i < 1000; i++ {
+		if err := tree.Insert([]byte(fmt.Sprintf("key%04d", i)), []byte("v")); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	before := tree.Stats()
+	if before.DirtyPages != 0 || before.Pages < 20 {
+		t.Fatalf("Expected a flushed tree of many pages, got %+v", before)
+	}
+
+	// Changing one key rewrites its leaf, not the tree
+	if err := tree.Insert([]byte("key0500"), []byte("changed")); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if got := tree.Stats().DirtyPages; got != 1 {
+		t.Errorf("Expected one dirty page, got %d", got)
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	after := tree.Stats()
+	if after.PageWrites-before.PageWrites != 1 || after.Flushes != before.Flushes+1 {
+		t.Errorf("Expected one page written by one flush, got %+v after %+v", after, before)
+	}
+
+	// A flush without changes writes nothing
+	if err := tree.Flush(); err != nil || tree.Stats() != after {
+		t.Errorf("Expected an idle flush to do nothing, got %v, %+v", err, tree.Stats())
+	}
+}
+
+func TestDiskTree_Duplicates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	tree, err := OpenDiskTree(path, DiskOptions{PageSize: MinPageSize, CachePages: 4, Duplicates: true})
+	if err != nil {
+		t.Fatalf("OpenDiskTree failed: %v", err)
+	}
+
+	// A popular key's values span many leaves
+	const n = 300
+	for _, i := range rand.New(rand.NewSource(2)).Perm(n) {
+		if err := tree.Insert([]byte("status:active"), []byte(fmt.Sprintf("user:%04d", i))); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	for _, key := range []string{"status:banned", "status:zzz"} {
+		if err := tree.Insert([]byte(key), []byte("user:9999")); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	if err := tree.Insert([]byte("status:active"), []byte("user:0000")); err != nil || tree.Size() != n+2 {
+		t.Fatalf("Expected a value already held to be ignored, got %d entries, %v", tree.Size(), err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	tree, err = OpenDiskTree(path, DiskOptions{CachePages: 4})
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer tree.Close()
+	if !tree.Duplicates() {
+		t.Fatal("Expected the file's duplicates setting")
+	}
+	values, err := tree.Values([]byte("status:active"))
+	if err != nil || len(values) != n {
+		t.Fatalf("Expected %d values, got %d, %v", n, len(values), err)
+	}
+	for i, value := range values {
+		if want := fmt.Sprintf("user:%04d", i); string(value) != want {
+			t.Fatalf("Value %d: expected %s, got %s", i, want, value)
+		}
+	}
+	if value, found, err := tree.Search([]byte("status:active")); err != nil || !found || string(value) != "user:0000" {
+		t.Errorf("Expected the smallest value, got %q, %v, %v", value, found, err)
+	}
+
+	if deleted, err := tree.DeleteValue([]byte("status:active"), []byte("user:0150")); err != nil || !deleted {
+		t.Fatalf("DeleteValue failed: %v, %v", deleted, err)
+	}
+	if deleted, err := tree.DeleteValue([]byte("status:active"), []byte("user:0150")); err != nil || deleted {
+		t.Errorf("Expected the value gone, got %v, %v", deleted, err)
+	}
+	if deleted, err := tree.Delete([]byte("status:active")); err != nil || !deleted {
+		t.Fatalf("Delete failed: %v, %v", deleted, err)
+	}
+	if values, err := tree.Values([]byte("status:active")); err != nil || values != nil || tree.Size() != 2 {
+		t.Errorf("Expected every value deleted, got %d values, %d entries, %v", len(values), tree.Size(), err)
+	}
+	var keys []string
+	if err := tree.Ascend(nil, nil, func(key, value []byte) bool {
+		keys = append(keys, string(key))
+		return true
+	}); err != nil || len(keys) != 2 || keys[0] != "status:banned" || keys[1] != "status:zzz" {
+		t.Errorf("Expected the other keys left, got %v, %v", keys, err)
+	}
+}
+
+func TestDiskTree_Integrity(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tree.db")
+	tree, err := OpenDiskTree(path, DiskOptions{PageSize: MinPageSize, Comparator: CaseInsensitive})
+	if err != nil {
+		t.Fatalf("OpenDiskTree failed: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := tree.Insert([]byte(fmt.Sprintf("Key%03d", i)), []byte("v")); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	if err := tree.Insert([]byte("big"), make([]byte, MinPageSize/4)); !errors.Is(err, ErrEntryTooLarge) {
+		t.Errorf("Expected ErrEntryTooLarge, got %v", err)
+	}
+
+	// A copy taken between a change and the next flush is refused
+	unflushed, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read tree file: %v", err)
+	}
+	crashed := filepath.Join(dir, "crashed.db")
+	if err := os.WriteFile(crashed, unflushed, 0600); err != nil {
+		t.Fatalf("Failed to write copy: %v", err)
+	}
+	if _, err := OpenDiskTree(crashed, DiskOptions{}); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected an unflushed tree to be corrupt, got %v", err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if _, err := OpenDiskTree(path, DiskOptions{Comparator: Natural}); err == nil {
+		t.Error("Expected a different comparator to be refused")
+	}
+	tree, err = OpenDiskTree(path, DiskOptions{})
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	if value, found, err := tree.Search([]byte("KEY042")); err != nil || !found || string(value) != "v" {
+		t.Errorf("Expected the file's comparator, got %q, %v, %v", value, found, err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// A damaged page is caught when it is read
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read tree file: %v", err)
+	}
+	data[len(data)-MinPageSize+pageHeaderSize+2] ^= 0xff
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Failed to damage tree file: %v", err)
+	}
+	tree, err = OpenDiskTree(path, DiskOptions{})
+	if err != nil {
+		t.Fatalf("Expected the meta page to still open, got %v", err)
+	}
+	defer tree.Close()
+	c := tree.RangeScan(nil, nil)
+	for c.Next() {
+	}
+	if !errors.Is(c.Err(), ErrCorrupt) {
+		t.Errorf("Expected the scan to hit the damaged page, got %v", c.Err())
+	}
+
+	if err := os.WriteFile(path, []byte("not a tree"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := OpenDiskTree(path, DiskOptions{}); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected a short file to be corrupt, got %v", err)
+	}
+}
+
+func TestDiskTree_Concurrent(t *testing.T) {
+	tree, err := OpenDiskTree(filepath.Join(t.TempDir(), "tree.db"), DiskOptions{PageSize: MinPageSize, CachePages: 4})
+	if err != nil {
+		t.Fatalf("OpenDiskTree failed: %v", err)
+	}
+	defer tree.Close()
+
+	const writers, perWriter = 4, 250
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				key := []byte(fmt.Sprintf("w%d:%04d", w, i))
+				if err := tree.Insert(key, key); err != nil {
+					t.Errorf("Insert failed: %v", err)
+					return
+				}
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				key := []byte(fmt.Sprintf("w%d:%04d", w, i))
+				if value, found, err := tree.Search(key); err != nil || (found && !bytes.Equal(value, key)) {
+					t.Errorf("Search %s: got %q, %v", key, value, err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	count := 0
+	if err := tree.Ascend(nil, nil, func(key, value []byte) bool {
+		count++
+		return true
+	}); err != nil || count != writers*perWriter {
+		t.Errorf("Expected %d keys, got %d, %v", writers*perWriter, count, err)
+	}
+}
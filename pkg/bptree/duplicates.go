@@ -0,0 +1,143 @@
+package bptree
+
+import (
+	"bytes"
+	"sort"
+)
+
+// WithDuplicates lets each key hold a set of values instead of one, like a
+// multimap. Insert adds a value to its key's set, Values returns the set
+// and DeleteValue removes one value. Values are kept in ascending bytewise
+// order in the leaf next to their key, so a key with many values costs no extra
+// tree nodes and Ascend visits them in order.
+func WithDuplicates() Option {
+	return func(tree *BPlusTree) {
+		tree.duplicates = true
+	}
+}
+
+// Duplicates reports whether keys can hold more than one value
+func (tree *BPlusTree) Duplicates() bool {
+	return tree.duplicates
+}
+
+// Values returns every value of key in ascending order, or nil if the key
+// does not exist. In a tree without duplicates it holds at most one value.
+// The values are the tree's own and must not be modified.
+//
+// This method is thread-safe; the list is copied under the leaf's read lock.
+func (tree *BPlusTree) Values(key []byte) [][]byte {
+	leaf := tree.findLeaf(key)
+	if leaf == nil {
+		return nil
+	}
+	defer leaf.mutex.RUnlock()
+
+	for i, k := range leaf.keys {
+		if tree.compare(key, k) != 0 {
+			continue
+		}
+		values := [][]byte{leaf.values[i]}
+		if leaf.extra != nil {
+			values = append(values, leaf.extra[i]...)
+		}
+		return values
+	}
+	return nil
+}
+
+// DeleteValue removes one value of key, and the key itself once it has no
+// values left. It reports whether the value was found. In a tree without
+// duplicates it deletes the key if the key holds value.
+//
+// This method is thread-safe and locks like Delete.
+func (tree *BPlusTree) DeleteValue(key, value []byte) bool {
+	leaf := tree.findLeaf(key)
+	if leaf == nil {
+		return false
+	}
+	leaf.mutex.RUnlock()
+	leaf.mutex.Lock()
+	defer leaf.mutex.Unlock()
+
+	for i, k := range leaf.keys {
+		if tree.compare(key, k) != 0 {
+			continue
+		}
+		if bytes.Equal(leaf.values[i], value) {
+			if leaf.extra == nil || len(leaf.extra[i]) == 0 {
+				tree.removeFromLeaf(leaf, i)
+				return true
+			}
+			// The next smallest value becomes the first
+			tree.account(-1, -valueCost(leaf.values[i]))
+			leaf.values[i] = leaf.extra[i][0]
+			leaf.extra[i] = leaf.extra[i][1:]
+			return true
+		}
+		if leaf.extra == nil {
+			return false
+		}
+		j, found := searchValues(leaf.extra[i], value)
+		if found {
+			tree.account(-1, -valueCost(leaf.extra[i][j]))
+			leaf.extra[i] = append(leaf.extra[i][:j], leaf.extra[i][j+1:]...)
+		}
+		return found
+	}
+	return false
+}
+
+// findLeaf returns the leaf key belongs in, read-locked, or nil if the tree
+// has no root. The caller must release the lock.
+func (tree *BPlusTree) findLeaf(key []byte) *node {
+	tree.m.RLock()
+	current := tree.root
+	if current == nil {
+		tree.m.RUnlock()
+		return nil
+	}
+	current.mutex.RLock()
+	tree.m.RUnlock()
+
+	// Latch coupling as in Search
+	for !current.isLeaf {
+		child := current.children[findChildIndex(tree.compare, current.keys, key)]
+		child.mutex.RLock()
+		current.mutex.RUnlock()
+		current = child
+	}
+	return current
+}
+
+// addDuplicate adds value to the values of the key at i, keeping them
+// ascending and distinct, and reports whether the key didn't hold it yet.
+// The leaf node must be locked exclusively.
+func addDuplicate(leaf *node, i int, value []byte) bool {
+	first := leaf.values[i]
+	switch c := bytes.Compare(value, first); {
+	case c == 0:
+		return false
+	case c < 0:
+		// The new value becomes the first; the old first moves to the rest
+		leaf.values[i], value = value, first
+	}
+
+	j, found := searchValues(leaf.extra[i], value)
+	if found {
+		return false
+	}
+	extra := append(leaf.extra[i], nil)
+	copy(extra[j+1:], extra[j:])
+	extra[j] = value
+	leaf.extra[i] = extra
+	return true
+}
+
+// searchValues finds value in ascending values, or where it would go
+func searchValues(values [][]byte, value []byte) (int, bool) {
+	j := sort.Search(len(values), func(j int) bool {
+		return bytes.Compare(values[j], value) >= 0
+	})
+	return j, j < len(values) && bytes.Equal(values[j], value)
+}
@@ -0,0 +1,195 @@
+package bptree
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/segmentio/ksuid"
+)
+
+// sortedValues returns n distinct values, new KSUIDs, in ascending order
+func sortedValues(n int) [][]byte {
+	values := make([][]byte, n)
+	for i := range values {
+		values[i] = ksuid.New().Bytes()
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return bytes.Compare(values[i], values[j]) < 0
+	})
+	return values
+}
+
+// equalValues reports whether got holds want in order
+func equalValues(got, want [][]byte) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if !bytes.Equal(got[i], want[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDuplicates_InsertAndValues(t *testing.T) {
+	tree := NewBPlusTree(3, WithDuplicates())
+	values := sortedValues(5)
+
+	// Inserted out of order, with a repeat, across enough keys to split
+	for _, i := range []int{3, 0, 4, 1, 3, 2} {
+		tree.Insert([]byte("dup"), values[i])
+	}
+	for i := 0; i < 20; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key%02d", i)), ksuid.New().Bytes())
+	}
+
+	if got := tree.Values([]byte("dup")); !equalValues(got, values) {
+		t.Fatalf("Expected all 5 values in order, got %v", got)
+	}
+	if v, found := tree.Search([]byte("dup")); !found || !bytes.Equal(v, values[0]) {
+		t.Fatalf("Expected Search to return the smallest value, got %v", v)
+	}
+	if got := tree.Values([]byte("missing")); got != nil {
+		t.Fatalf("Expected no values for a missing key, got %v", got)
+	}
+
+	var scanned [][]byte
+	tree.Ascend([]byte("dup"), []byte("dup\x00"), func(key, value []byte) bool {
+		scanned = append(scanned, value)
+		return true
+	})
+	if !equalValues(scanned, values) {
+		t.Fatalf("Expected Ascend to visit every value in order, got %v", scanned)
+	}
+	count := 0
+	tree.Ascend(nil, nil, func(_, _ []byte) bool {
+		count++
+		return true
+	})
+	if count != 25 {
+		t.Fatalf("Expected 25 pairs in a full scan, got %d", count)
+	}
+}
+
+func TestDuplicates_DeleteValue(t *testing.T) {
+	tree := NewBPlusTree(4, WithDuplicates())
+	values := sortedValues(3)
+	for _, v := range values {
+		tree.Insert([]byte("dup"), v)
+	}
+
+	if tree.DeleteValue([]byte("dup"), ksuid.New().Bytes()) {
+		t.Fatal("Expected deleting an absent value to fail")
+	}
+	// The first value is replaced by the next smallest
+	if !tree.DeleteValue([]byte("dup"), values[0]) {
+		t.Fatal("Expected to delete the first value")
+	}
+	if v, _ := tree.Search([]byte("dup")); !bytes.Equal(v, values[1]) {
+		t.Fatalf("Expected the next value to come first, got %v", v)
+	}
+	if !tree.DeleteValue([]byte("dup"), values[2]) {
+		t.Fatal("Expected to delete the last value")
+	}
+	if got := tree.Values([]byte("dup")); !equalValues(got, values[1:2]) {
+		t.Fatalf("Expected one value left, got %v", got)
+	}
+	// Removing the last value removes the key
+	if !tree.DeleteValue([]byte("dup"), values[1]) {
+		t.Fatal("Expected to delete the remaining value")
+	}
+	if _, found := tree.Search([]byte("dup")); found {
+		t.Fatal("Expected the key to go with its last value")
+	}
+
+	// Delete drops every value at once
+	for _, v := range values {
+		tree.Insert([]byte("dup"), v)
+	}
+	if !tree.Delete([]byte("dup")) || tree.Values([]byte("dup")) != nil {
+		t.Fatal("Expected Delete to remove every value")
+	}
+
+	// Without duplicates, DeleteValue checks the value
+	unique := NewBPlusTree(4)
+	unique.Insert([]byte("key"), values[0])
+	if unique.DeleteValue([]byte("key"), values[1]) {
+		t.Fatal("Expected a mismatched value not to delete the key")
+	}
+	if !unique.DeleteValue([]byte("key"), values[0]) {
+		t.Fatal("Expected the matching value to delete the key")
+	}
+}
+
+func TestDuplicates_BulkLoad(t *testing.T) {
+	values := sortedValues(3)
+	pairs := []Pair{
+		{Key: []byte("a"), Value: values[2]},
+		{Key: []byte("a"), Value: values[0]},
+		{Key: []byte("a"), Value: values[2]},
+		{Key: []byte("b"), Value: values[1]},
+	}
+	if _, err := BulkLoad(3, pairs, DefaultFillFactor); err == nil {
+		t.Fatal("Expected repeated keys to fail without duplicates")
+	}
+
+	tree, err := BulkLoad(3, pairs, DefaultFillFactor, WithDuplicates())
+	if err != nil {
+		t.Fatalf("Failed to bulk load: %v", err)
+	}
+	if got := tree.Values([]byte("a")); !equalValues(got, [][]byte{values[0], values[2]}) {
+		t.Fatalf("Expected a's values gathered in order, got %v", got)
+	}
+	tree.Insert([]byte("a"), values[1])
+	if got := tree.Values([]byte("a")); !equalValues(got, values) {
+		t.Fatalf("Expected an insert to join a's values, got %v", got)
+	}
+}
+
+func TestDuplicates_SaveLoad(t *testing.T) {
+	tree := NewBPlusTree(3, WithDuplicates())
+	values := sortedValues(4)
+	for i := 0; i < 30; i++ {
+		key := []byte(fmt.Sprintf("key%02d", i))
+		for _, v := range values[:1+i%4] {
+			tree.Insert(key, v)
+		}
+	}
+
+	filename := filepath.Join(t.TempDir(), "dups.dat")
+	if err := tree.Save(filename); err != nil {
+		t.Fatalf("Failed to save tree: %v", err)
+	}
+	loaded, err := LoadBPlusTree(filename)
+	if err != nil {
+		t.Fatalf("Failed to load tree: %v", err)
+	}
+	if !loaded.Duplicates() {
+		t.Fatal("Expected the loaded tree to allow duplicates")
+	}
+	checkTree(t, loaded)
+	for i := 0; i < 30; i++ {
+		key := []byte(fmt.Sprintf("key%02d", i))
+		if got := loaded.Values(key); !equalValues(got, values[:1+i%4]) {
+			t.Fatalf("Expected %d values for %s after loading, got %v", 1+i%4, key, got)
+		}
+	}
+
+	// The loaded tree keeps splitting and linking leaves correctly
+	for i := 30; i < 60; i++ {
+		loaded.Insert([]byte(fmt.Sprintf("key%02d", i)), values[0])
+	}
+	checkTree(t, loaded)
+	count := 0
+	loaded.Ascend(nil, nil, func(_, _ []byte) bool {
+		count++
+		return true
+	})
+	if want := 73 + 30; count != want {
+		t.Fatalf("Expected %d pairs after inserting into the loaded tree, got %d", want, count)
+	}
+}
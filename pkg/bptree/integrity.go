@@ -0,0 +1,138 @@
+package bptree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// ErrCorrupt is returned by LoadBPlusTree when a tree file is truncated,
+// fails its checksum or doesn't describe a well-formed tree, and by DiskTree
+// for damaged pages or a file not flushed after its last change. A corrupt
+// file can't be repaired; callers rebuild the tree from the data it indexes.
+var ErrCorrupt = errors.New("bptree: corrupt tree file")
+
+// fileChecksumTable is the CRC32 table for the checksum ending tree files
+var fileChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksumSize is the size of the checksum ending version 3 tree files
+const checksumSize = 4
+
+// maxFileOrder bounds the order read from a tree file, so a corrupt order
+// can't make loading allocate without limit
+const maxFileOrder = 1 << 20
+
+// minNodeSize is the smallest a serialized node can be: its leaf flag, key
+// count and parent ID
+const minNodeSize = 1 + 4 + 4
+
+// corruptf returns an error wrapping ErrCorrupt
+func corruptf(format string, args ...any) error {
+	return fmt.Errorf("%w: "+format, append([]any{ErrCorrupt}, args...)...)
+}
+
+// openTreeFile opens a tree file for LoadBPlusTree. Files of version 3 or
+// later are checked against their checksum first; the returned reader stops
+// before the checksum, so parsing can bound every length by the bytes left.
+func openTreeFile(file *os.File) (*io.LimitedReader, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	size := info.Size()
+
+	var prefix [8]byte
+	n, err := file.ReadAt(prefix[:], 0)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	checksummed := n == len(prefix) &&
+		binary.LittleEndian.Uint32(prefix[0:4]) == fileMagic &&
+		binary.LittleEndian.Uint32(prefix[4:8]) >= 3
+
+	if checksummed {
+		size -= checksumSize
+		if size < int64(len(prefix)) {
+			return nil, corruptf("file too short for its checksum")
+		}
+		if err := verifyChecksum(file, size); err != nil {
+			return nil, err
+		}
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(file, 0, size))
+	return &io.LimitedReader{R: reader, N: size}, nil
+}
+
+// verifyChecksum compares the checksum stored at size with the one of the
+// size bytes before it
+func verifyChecksum(file *os.File, size int64) error {
+	sum := crc32.New(fileChecksumTable)
+	if _, err := io.Copy(sum, io.NewSectionReader(file, 0, size)); err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	var stored [checksumSize]byte
+	if _, err := file.ReadAt(stored[:], size); err != nil {
+		return fmt.Errorf("failed to read checksum: %w", err)
+	}
+	if want := binary.LittleEndian.Uint32(stored[:]); sum.Sum32() != want {
+		return corruptf("checksum mismatch: stored %08x, computed %08x", want, sum.Sum32())
+	}
+	return nil
+}
+
+// checkTempNodes checks that the nodes read from a file form a tree rooted
+// at rootID: every other node has exactly one parent and is reachable, keys
+// ascend within each node, leaves all sit at depth height and link only to
+// leaves
+func checkTempNodes(nodes []*tempNode, rootID, height uint32, compare Comparator) error {
+	count := uint32(len(nodes))
+	if rootID >= count {
+		return corruptf("root ID %d out of range", rootID)
+	}
+
+	for _, temp := range nodes {
+		for i := 1; i < len(temp.keys); i++ {
+			if compare.Compare(temp.keys[i-1], temp.keys[i]) >= 0 {
+				return corruptf("node %d keys out of order", temp.id)
+			}
+		}
+		if temp.isLeaf && temp.nextID != 0 {
+			if temp.nextID >= count || !nodes[temp.nextID].isLeaf {
+				return corruptf("node %d links to invalid leaf %d", temp.id, temp.nextID)
+			}
+		}
+	}
+
+	// Walk down from the root, each node's depth one below its parent's
+	depth := make([]uint32, count)
+	depth[rootID] = 1
+	visited := 1
+	queue := []uint32{rootID}
+	for len(queue) > 0 {
+		temp := nodes[queue[0]]
+		queue = queue[1:]
+		if temp.isLeaf {
+			if depth[temp.id] != height {
+				return corruptf("leaf %d at depth %d in a tree of height %d", temp.id, depth[temp.id], height)
+			}
+			continue
+		}
+		for _, childID := range temp.childrenIDs {
+			if childID >= count || childID == rootID || depth[childID] != 0 {
+				return corruptf("node %d has invalid child %d", temp.id, childID)
+			}
+			depth[childID] = depth[temp.id] + 1
+			visited++
+			queue = append(queue, childID)
+		}
+	}
+	if visited != len(nodes) {
+		return corruptf("%d of %d nodes unreachable from the root", len(nodes)-visited, len(nodes))
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package bptree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/segmentio/ksuid"
+)
+
+// savedTree saves a tree of several levels, returning its file and bytes
+func savedTree(t *testing.T) (string, []byte) {
+	t.Helper()
+	tree := NewBPlusTree(4, WithDuplicates())
+	for i := 0; i < 50; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key%03d", i)), ksuid.New().Bytes())
+	}
+	tree.Insert([]byte("key007"), ksuid.New().Bytes())
+
+	filename := filepath.Join(t.TempDir(), "tree.dat")
+	if err := tree.Save(filename); err != nil {
+		t.Fatalf("Failed to save tree: %v", err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to read tree file: %v", err)
+	}
+	return filename, data
+}
+
+// writeLegacy writes a tree file without a header or checksum
+func writeLegacy(t *testing.T, words ...any) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "legacy.dat")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer file.Close()
+	for _, v := range words {
+		if err := binary.Write(file, binary.LittleEndian, v); err != nil {
+			t.Fatalf("Failed to write legacy file: %v", err)
+		}
+	}
+	return filename
+}
+
+func TestLoadBPlusTree_RoundTrip(t *testing.T) {
+	filename, _ := savedTree(t)
+	tree, err := LoadBPlusTree(filename)
+	if err != nil {
+		t.Fatalf("Failed to load tree: %v", err)
+	}
+	if keys := ascendKeys(tree); len(keys) != 51 {
+		t.Errorf("Expected 51 values, got %d", len(keys))
+	}
+	if tree.Height() < 3 {
+		t.Errorf("Expected a tree of several levels, got height %d", tree.Height())
+	}
+}
+
+func TestLoadBPlusTree_Checksum(t *testing.T) {
+	filename, data := savedTree(t)
+
+	// Flip one bit at a time through the file
+	for _, offset := range []int{0, 5, 20, len(data) / 2, len(data) - 5, len(data) - 1} {
+		corrupt := append([]byte(nil), data...)
+		corrupt[offset] ^= 0x10
+		if err := os.WriteFile(filename, corrupt, 0o600); err != nil {
+			t.Fatalf("Failed to write tree file: %v", err)
+		}
+		if _, err := LoadBPlusTree(filename); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("Expected a flipped bit at %d to be corrupt, got %v", offset, err)
+		}
+	}
+
+	// Bytes appended after the checksum
+	if err := os.WriteFile(filename, append(data, 0), 0o600); err != nil {
+		t.Fatalf("Failed to write tree file: %v", err)
+	}
+	if _, err := LoadBPlusTree(filename); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected trailing bytes to be corrupt, got %v", err)
+	}
+}
+
+func TestLoadBPlusTree_Truncated(t *testing.T) {
+	filename, data := savedTree(t)
+	for size := 0; size < len(data); size++ {
+		if err := os.WriteFile(filename, data[:size], 0o600); err != nil {
+			t.Fatalf("Failed to write tree file: %v", err)
+		}
+		if _, err := LoadBPlusTree(filename); !errors.Is(err, ErrCorrupt) {
+			t.Fatalf("Expected a file truncated to %d bytes to be corrupt, got %v", size, err)
+		}
+	}
+}
+
+func TestLoadBPlusTree_LegacyStructure(t *testing.T) {
+	// Legacy files have no checksum, so their structure is all there is to
+	// check: order, height, root, node count, then one leaf holding "a"
+	leaf := []any{uint8(1), uint32(1), uint32(1), []byte("a"), uint32(0), uint32(0), uint32(0)}
+	valid := append([]any{uint32(4), uint32(1), uint32(0), uint32(1)}, leaf...)
+	tree, err := LoadBPlusTree(writeLegacy(t, valid...))
+	if err != nil {
+		t.Fatalf("Failed to load legacy tree: %v", err)
+	}
+	if _, found := tree.Search([]byte("a")); !found {
+		t.Error("Expected the legacy tree to hold a")
+	}
+
+	// Before version 4 every value was a 20-byte KSUID, further values
+	// without their lengths
+	first, second := ksuid.New().Bytes(), ksuid.New().Bytes()
+	if bytes.Compare(first, second) > 0 {
+		first, second = second, first
+	}
+	duplicates := []any{fileMagic, uint32(2), uint32(len(Bytewise.Name)), []byte(Bytewise.Name), fileFlagDuplicates,
+		uint32(4), uint32(1), uint32(0), uint32(1),
+		uint8(1), uint32(1), uint32(1), []byte("a"), uint32(legacyValueLen), first, uint32(1), second,
+		uint32(0), uint32(0)}
+	tree, err = LoadBPlusTree(writeLegacy(t, duplicates...))
+	if err != nil {
+		t.Fatalf("Failed to load legacy tree with duplicates: %v", err)
+	}
+	if values := tree.Values([]byte("a")); !equalValues(values, [][]byte{first, second}) {
+		t.Errorf("Expected a's two KSUIDs, got %x", values)
+	}
+
+	tests := []struct {
+		name  string
+		words []any
+	}{
+		{"huge order", []any{uint32(1 << 30)}},
+		{"too many nodes", []any{uint32(4), uint32(1), uint32(0), uint32(1 << 30)}},
+		{"root out of range", append([]any{uint32(4), uint32(1), uint32(5), uint32(1)}, leaf...)},
+		{"wrong height", append([]any{uint32(4), uint32(2), uint32(0), uint32(1)}, leaf...)},
+		{"huge key count", []any{uint32(4), uint32(1), uint32(0), uint32(1), uint8(1), uint32(1 << 30)}},
+		{"huge key", []any{uint32(4), uint32(1), uint32(0), uint32(1), uint8(1), uint32(1), uint32(1 << 30)}},
+		{"bad value length", []any{uint32(4), uint32(1), uint32(0), uint32(1),
+			uint8(1), uint32(1), uint32(1), []byte("a"), uint32(3), []byte("xyz"), uint32(0), uint32(0)}},
+		{"keys out of order", []any{uint32(4), uint32(1), uint32(0), uint32(1),
+			uint8(1), uint32(2), uint32(1), []byte("b"), uint32(1), []byte("a"),
+			uint32(0), uint32(0), uint32(0), uint32(0)}},
+		{"child cycle", []any{uint32(4), uint32(2), uint32(0), uint32(2),
+			uint8(0), uint32(1), uint32(1), []byte("b"), uint32(1), uint32(1), uint32(0),
+			uint8(1), uint32(0), uint32(0), uint32(0)}},
+		{"child out of range", []any{uint32(4), uint32(2), uint32(0), uint32(2),
+			uint8(0), uint32(1), uint32(1), []byte("b"), uint32(1), uint32(9), uint32(0),
+			uint8(1), uint32(0), uint32(0), uint32(0)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadBPlusTree(writeLegacy(t, tt.words...)); !errors.Is(err, ErrCorrupt) {
+				t.Errorf("Expected ErrCorrupt, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrMemoryLimit is returned by TryInsert when the insert would take the
+// tree past its memory limit and spilling didn't make room
+var ErrMemoryLimit = errors.New("bptree: memory limit exceeded")
+
+// Estimated memory of the parts of a tree, beyond the bytes of its keys and
+// values
+const (
+	// nodeOverhead is a node's fields: its latch, flag, slice headers and
+	// pointers
+	nodeOverhead = 24 + 8 + 4*24 + 2*8
+	// keyOverhead is the slice header of a key
+	keyOverhead = 24
+	// pointerSize is a child pointer of an internal node
+	pointerSize = 8
+	// valueOverhead is the slice header of a value
+	valueOverhead = 24
+	// extraOverhead is the slice header holding a key's further values in
+	// trees with duplicates
+	extraOverhead = 24
+)
+
+// SpillFunc is called by TryInsert when an insert would take tree past its
+// memory limit, with the number of bytes it is short. It can make room, for
+// example by checkpointing and unloading other trees or the tree's owner's
+// data, and returns an error if it can't.
+type SpillFunc func(tree *BPlusTree, need int64) error
+
+// WithMemoryLimit caps the estimated memory TryInsert lets the tree grow to,
+// in bytes. Insert, Delete and BulkLoad are not limited; a limit of 0 or
+// less means none.
+func WithMemoryLimit(limit int64) Option {
+	return func(tree *BPlusTree) {
+		tree.memoryLimit = limit
+	}
+}
+
+// WithSpill sets the function TryInsert calls to make room when the tree is
+// at its memory limit, before giving up with ErrMemoryLimit
+func WithSpill(spill SpillFunc) Option {
+	return func(tree *BPlusTree) {
+		tree.spill = spill
+	}
+}
+
+// Size returns the number of values in the tree: its number of keys, or in
+// a tree with duplicates the values of all keys.
+//
+// This method is thread-safe and doesn't lock the tree.
+func (tree *BPlusTree) Size() int {
+	return int(tree.size.Load())
+}
+
+// MemoryUsage returns the estimated memory held by the tree's nodes, keys
+// and values in bytes. Nodes are never freed by deletes, so a tree that
+// shrinks keeps the memory of its nodes.
+//
+// This method is thread-safe and doesn't lock the tree.
+func (tree *BPlusTree) MemoryUsage() int64 {
+	return tree.memory.Load()
+}
+
+// MemoryLimit returns the tree's memory limit in bytes, or 0 if it has none
+func (tree *BPlusTree) MemoryLimit() int64 {
+	return max(tree.memoryLimit, 0)
+}
+
+// TryInsert is Insert honouring the tree's memory limit. When the insert
+// could take MemoryUsage past the limit, the tree's SpillFunc is given a
+// chance to make room; if there is still none, the tree is left unchanged
+// and ErrMemoryLimit returned. The check assumes the key is new, so at the
+// limit even updates of existing keys are refused. Concurrent inserts may
+// each pass the check and overshoot the limit by their sizes.
+func (tree *BPlusTree) TryInsert(key, value []byte) error {
+	if limit := tree.memoryLimit; limit > 0 {
+		need := tree.MemoryUsage() + tree.entryCost(key, value) - limit
+		if need > 0 && tree.spill != nil {
+			if err := tree.spill(tree, need); err != nil {
+				return fmt.Errorf("%w: %w", ErrMemoryLimit, err)
+			}
+			need = tree.MemoryUsage() + tree.entryCost(key, value) - limit
+		}
+		if need > 0 {
+			return fmt.Errorf("%w: %d bytes over the limit of %d", ErrMemoryLimit, need, limit)
+		}
+	}
+	tree.Insert(key, value)
+	return nil
+}
+
+// entryCost is the estimated memory of a new key in a leaf with its value
+func (tree *BPlusTree) entryCost(key, value []byte) int64 {
+	cost := int64(len(key)) + keyOverhead + valueCost(value)
+	if tree.duplicates {
+		cost += extraOverhead
+	}
+	return cost
+}
+
+// valueCost is the estimated memory of a value in a leaf
+func valueCost(value []byte) int64 {
+	return int64(len(value)) + valueOverhead
+}
+
+// account records a change in the tree's values and memory
+func (tree *BPlusTree) account(values, bytes int64) {
+	if values != 0 {
+		tree.size.Add(values)
+	}
+	tree.memory.Add(bytes)
+}
+
+// recount recomputes the tree's size and memory from its nodes. The tree
+// must not be in use yet.
+func (tree *BPlusTree) recount() {
+	var values, bytes int64
+	queue := []*node{tree.root}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		if n == nil {
+			continue
+		}
+		bytes += nodeOverhead
+		if !n.isLeaf {
+			bytes += int64(len(n.keys))*keyOverhead + int64(len(n.children))*pointerSize
+			queue = append(queue, n.children...)
+			continue
+		}
+		for i, key := range n.keys {
+			values++
+			bytes += tree.entryCost(key, n.values[i])
+			if n.extra != nil {
+				for _, value := range n.extra[i] {
+					values++
+					bytes += valueCost(value)
+				}
+			}
+		}
+	}
+	tree.size.Store(values)
+	tree.memory.Store(bytes)
+}
@@ -0,0 +1,152 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/segmentio/ksuid"
+)
+
+// checkAccounting compares the tree's running totals with a recount
+func checkAccounting(t *testing.T, tree *BPlusTree) {
+	t.Helper()
+	size, memory := tree.Size(), tree.MemoryUsage()
+	tree.recount()
+	if tree.Size() != size || tree.MemoryUsage() != memory {
+		t.Fatalf("Expected %d values in %d bytes, counted %d in %d",
+			size, memory, tree.Size(), tree.MemoryUsage())
+	}
+}
+
+func TestBPlusTree_MemoryAccounting(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithDuplicates()}} {
+		tree := NewBPlusTree(4, opts...)
+		empty := tree.MemoryUsage()
+		if tree.Size() != 0 || empty <= 0 {
+			t.Fatalf("Expected an empty tree to hold only its root, got %d values in %d bytes", tree.Size(), empty)
+		}
+
+		rng := rand.New(rand.NewSource(1))
+		values := make(map[string][]byte)
+		for i := 0; i < 500; i++ {
+			key := fmt.Sprintf("key%03d", rng.Intn(200))
+			switch rng.Intn(4) {
+			case 0:
+				tree.Delete([]byte(key))
+			case 1:
+				if value, ok := values[key]; ok {
+					tree.DeleteValue([]byte(key), value)
+				}
+			default:
+				value := ksuid.New().Bytes()
+				values[key] = value
+				tree.Insert([]byte(key), value)
+			}
+		}
+		checkAccounting(t, tree)
+		if got := len(ascendKeys(tree)); tree.Size() != got {
+			t.Errorf("Expected Size to count %d values, got %d", got, tree.Size())
+		}
+
+		// Loading and bulk loading count the same nodes
+		filename := t.TempDir() + "/tree.dat"
+		if err := tree.Save(filename); err != nil {
+			t.Fatalf("Failed to save tree: %v", err)
+		}
+		loaded, err := LoadBPlusTree(filename)
+		if err != nil {
+			t.Fatalf("Failed to load tree: %v", err)
+		}
+		if loaded.Size() != tree.Size() || loaded.MemoryUsage() != tree.MemoryUsage() {
+			t.Errorf("Expected the loaded tree to hold %d values in %d bytes, got %d in %d",
+				tree.Size(), tree.MemoryUsage(), loaded.Size(), loaded.MemoryUsage())
+		}
+		var pairs []Pair
+		tree.Ascend(nil, nil, func(key, value []byte) bool {
+			pairs = append(pairs, Pair{Key: key, Value: value})
+			return true
+		})
+		bulk, err := BulkLoad(4, pairs, 1, opts...)
+		if err != nil {
+			t.Fatalf("Failed to bulk load: %v", err)
+		}
+		if bulk.Size() != tree.Size() {
+			t.Errorf("Expected the bulk loaded tree to hold %d values, got %d", tree.Size(), bulk.Size())
+		}
+		bulk.Insert([]byte("zzz"), ksuid.New().Bytes())
+		checkAccounting(t, bulk)
+	}
+}
+
+func TestBPlusTree_MemoryAccountingConcurrent(t *testing.T) {
+	tree := NewBPlusTree(8)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := []byte(fmt.Sprintf("w%d-%03d", w, i))
+				tree.Insert(key, ksuid.New().Bytes())
+				if i%3 == 0 {
+					tree.Delete(key)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if tree.Size() != 8*133 {
+		t.Errorf("Expected %d values, got %d", 8*133, tree.Size())
+	}
+	checkAccounting(t, tree)
+}
+
+func TestBPlusTree_TryInsert(t *testing.T) {
+	// Unlimited trees always insert
+	tree := NewBPlusTree(4)
+	if err := tree.TryInsert([]byte("a"), ksuid.New().Bytes()); err != nil {
+		t.Fatalf("Expected an unlimited tree to insert, got %v", err)
+	}
+
+	limit := NewBPlusTree(4).MemoryUsage() + 3*tree.entryCost([]byte("k00"), ksuid.New().Bytes())
+	tree = NewBPlusTree(4, WithMemoryLimit(limit))
+	for i := 0; i < 3; i++ {
+		if err := tree.TryInsert([]byte(fmt.Sprintf("k%02d", i)), ksuid.New().Bytes()); err != nil {
+			t.Fatalf("Expected insert %d to fit, got %v", i, err)
+		}
+	}
+	if err := tree.TryInsert([]byte("k03"), ksuid.New().Bytes()); !errors.Is(err, ErrMemoryLimit) {
+		t.Fatalf("Expected ErrMemoryLimit, got %v", err)
+	}
+	if tree.Size() != 3 || tree.MemoryUsage() > limit {
+		t.Errorf("Expected the refused insert to leave 3 values within %d bytes, got %d in %d",
+			limit, tree.Size(), tree.MemoryUsage())
+	}
+
+	// A spill that frees enough lets the insert through
+	var spilled int64
+	tree = NewBPlusTree(4, WithMemoryLimit(limit), WithSpill(func(tree *BPlusTree, need int64) error {
+		spilled += need
+		tree.Delete([]byte("k00"))
+		return nil
+	}))
+	for i := 0; i < 4; i++ {
+		if err := tree.TryInsert([]byte(fmt.Sprintf("k%02d", i)), ksuid.New().Bytes()); err != nil {
+			t.Fatalf("Expected insert %d to fit after spilling, got %v", i, err)
+		}
+	}
+	if spilled <= 0 || tree.Size() != 3 {
+		t.Errorf("Expected the spill to make room for a fourth key, spilled %d bytes and hold %d values", spilled, tree.Size())
+	}
+
+	// A failing spill is reported
+	failed := errors.New("disk full")
+	tree = NewBPlusTree(4, WithMemoryLimit(1), WithSpill(func(*BPlusTree, int64) error { return failed }))
+	if err := tree.TryInsert([]byte("a"), ksuid.New().Bytes()); !errors.Is(err, ErrMemoryLimit) || !errors.Is(err, failed) {
+		t.Errorf("Expected the spill's error with ErrMemoryLimit, got %v", err)
+	}
+}
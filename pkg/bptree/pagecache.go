@@ -0,0 +1,141 @@
+package bptree
+
+import (
+	"cmp"
+	"container/list"
+	"slices"
+	"sync"
+)
+
+// DefaultCachePages is the number of pages a DiskTree caches by default
+const DefaultCachePages = 256
+
+// pageCache holds the decoded nodes of a DiskTree's most recently used
+// pages. Once it holds more than capacity pages, trim evicts the least
+// recently used ones, writing dirty ones back first, so a modified page
+// reaches the file on its own rather than with the whole tree.
+//
+// The cache's own mutex guards its list, so concurrent readers can load
+// pages. Nodes are only modified under the tree's write lock, and trim is
+// only called between tree operations, never evicting a node one of them
+// is still changing.
+type pageCache struct {
+	mu       sync.Mutex
+	capacity int
+	pages    map[uint32]*list.Element // Page ID -> element holding its *diskNode
+	lru      *list.List               // Most recently used first
+	dirty    int                      // Dirty nodes in the cache
+
+	load  func(id uint32) (*diskNode, error) // Reads a page from the file
+	store func(n *diskNode) error            // Writes a page to the file
+
+	hits   uint64
+	misses uint64
+	writes uint64
+}
+
+// newPageCache returns an empty cache of capacity pages
+func newPageCache(capacity int, load func(uint32) (*diskNode, error), store func(*diskNode) error) *pageCache {
+	if capacity <= 0 {
+		capacity = DefaultCachePages
+	}
+	return &pageCache{
+		capacity: capacity,
+		pages:    make(map[uint32]*list.Element),
+		lru:      list.New(),
+		load:     load,
+		store:    store,
+	}
+}
+
+// get returns the node of page id, loading it on a miss
+func (c *pageCache) get(id uint32) (*diskNode, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.pages[id]; ok {
+		c.hits++
+		c.lru.MoveToFront(e)
+		return e.Value.(*diskNode), nil
+	}
+	c.misses++
+	n, err := c.load(id)
+	if err != nil {
+		return nil, err
+	}
+	c.pages[id] = c.lru.PushFront(n)
+	return n, nil
+}
+
+// add caches a node for a new page, dirty since it was never written
+func (c *pageCache) add(n *diskNode) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n.dirty = true
+	c.dirty++
+	c.pages[n.id] = c.lru.PushFront(n)
+}
+
+// markDirty records that a cached node was changed
+func (c *pageCache) markDirty(n *diskNode) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !n.dirty {
+		n.dirty = true
+		c.dirty++
+	}
+}
+
+// trim evicts the least recently used pages until the cache holds at most
+// its capacity, writing dirty ones back
+func (c *pageCache) trim() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.lru.Len() > c.capacity {
+		e := c.lru.Back()
+		n := e.Value.(*diskNode)
+		if err := c.writeInternal(n); err != nil {
+			return err
+		}
+		c.lru.Remove(e)
+		delete(c.pages, n.id)
+	}
+	return nil
+}
+
+// flush writes every dirty page back, in page order, keeping them cached
+func (c *pageCache) flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var dirty []*diskNode
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		if n := e.Value.(*diskNode); n.dirty {
+			dirty = append(dirty, n)
+		}
+	}
+	slices.SortFunc(dirty, func(a, b *diskNode) int { return cmp.Compare(a.id, b.id) })
+	for _, n := range dirty {
+		if err := c.writeInternal(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeInternal writes n back if it is dirty (caller must hold the mutex)
+func (c *pageCache) writeInternal(n *diskNode) error {
+	if !n.dirty {
+		return nil
+	}
+	if err := c.store(n); err != nil {
+		return err
+	}
+	n.dirty = false
+	c.dirty--
+	c.writes++
+	return nil
+}
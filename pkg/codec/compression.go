@@ -0,0 +1,174 @@
+package codec
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// FlagCompressed is set in the encoded key size of a record whose value is
+// compressed. Like FlagExpiry it takes a bit keys never reach, so records
+// written before compression existed decode unchanged.
+const FlagCompressed uint32 = 1 << 30
+
+// DefaultCompressionThreshold is the value size, in bytes, below which
+// values are stored uncompressed when CompressionOptions.MinSize is zero.
+// Small values rarely shrink enough to pay for the algorithm byte.
+const DefaultCompressionThreshold = 256
+
+// Compression identifies the algorithm a value is compressed with. It is
+// written as the first byte of a compressed value, so the numbering is part
+// of the record format.
+type Compression uint8
+
+// Compression algorithms
+const (
+	CompressionNone   Compression = 0
+	CompressionSnappy Compression = 1
+	CompressionZstd   Compression = 2
+)
+
+// String returns the algorithm's name, as accepted by ParseCompression
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionSnappy:
+		return "snappy"
+	case CompressionZstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("compression(%d)", uint8(c))
+	}
+}
+
+// ParseCompression parses an algorithm name: none, snappy or zstd. An
+// empty name is none.
+func ParseCompression(name string) (Compression, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return CompressionNone, nil
+	case "snappy":
+		return CompressionSnappy, nil
+	case "zstd":
+		return CompressionZstd, nil
+	default:
+		return CompressionNone, fmt.Errorf("unknown compression %q: want none, snappy or zstd", name)
+	}
+}
+
+// CompressionOptions configures how a RecordCodec compresses values
+type CompressionOptions struct {
+	Algorithm Compression // CompressionNone disables compression
+	MinSize   int         // Values smaller than this are stored as-is (0 = DefaultCompressionThreshold)
+}
+
+// Validate checks that the algorithm is known and the threshold not negative
+func (o CompressionOptions) Validate() error {
+	if o.Algorithm > CompressionZstd {
+		return fmt.Errorf("unknown compression %s", o.Algorithm)
+	}
+	if o.MinSize < 0 {
+		return fmt.Errorf("compression threshold must not be negative, got %d", o.MinSize)
+	}
+	return nil
+}
+
+// minSize returns the configured threshold, or the default
+func (o CompressionOptions) minSize() int {
+	if o.MinSize > 0 {
+		return o.MinSize
+	}
+	return DefaultCompressionThreshold
+}
+
+// compress returns value as stored under the options: the algorithm byte
+// followed by the compressed bytes, or nil if the value should be stored
+// as-is because compression is off, the value is below the threshold or it
+// doesn't shrink
+func (o CompressionOptions) compress(value []byte) []byte {
+	if o.Algorithm == CompressionNone || len(value) < o.minSize() {
+		return nil
+	}
+
+	stored := []byte{byte(o.Algorithm)}
+	switch o.Algorithm {
+	case CompressionSnappy:
+		stored = append(stored, snappy.Encode(nil, value)...)
+	case CompressionZstd:
+		stored = zstdEncoder().EncodeAll(value, stored)
+	default:
+		return nil
+	}
+	if len(stored) >= len(value) {
+		return nil
+	}
+	return stored
+}
+
+// decompress returns the value of a compressed record's stored bytes
+func decompress(stored []byte) (Compression, []byte, error) {
+	if len(stored) == 0 {
+		return CompressionNone, nil, fmt.Errorf("compressed value has no algorithm byte")
+	}
+
+	algorithm := Compression(stored[0])
+	switch algorithm {
+	case CompressionSnappy:
+		// A damaged length prefix mustn't allocate more than a value can hold
+		size, err := snappy.DecodedLen(stored[1:])
+		if err != nil {
+			return algorithm, nil, fmt.Errorf("snappy: %w", err)
+		}
+		if size > math.MaxUint32 {
+			return algorithm, nil, fmt.Errorf("snappy: decoded size %d too large", size)
+		}
+		value, err := snappy.Decode(nil, stored[1:])
+		if err != nil {
+			return algorithm, nil, fmt.Errorf("snappy: %w", err)
+		}
+		return algorithm, value, nil
+	case CompressionZstd:
+		value, err := zstdDecoder().DecodeAll(stored[1:], nil)
+		if err != nil {
+			return algorithm, nil, fmt.Errorf("zstd: %w", err)
+		}
+		return algorithm, value, nil
+	default:
+		return algorithm, nil, fmt.Errorf("unknown compression %s", algorithm)
+	}
+}
+
+// The zstd encoder and decoder are safe for concurrent EncodeAll and
+// DecodeAll calls, so every codec shares one of each
+var (
+	zstdOnce sync.Once
+	zstdEnc  *zstd.Encoder
+	zstdDec  *zstd.Decoder
+)
+
+func initZstd() {
+	var err error
+	zstdEnc, err = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		panic(fmt.Sprintf("codec: zstd encoder: %v", err))
+	}
+	zstdDec, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(math.MaxUint32))
+	if err != nil {
+		panic(fmt.Sprintf("codec: zstd decoder: %v", err))
+	}
+}
+
+func zstdEncoder() *zstd.Encoder {
+	zstdOnce.Do(initZstd)
+	return zstdEnc
+}
+
+func zstdDecoder() *zstd.Decoder {
+	zstdOnce.Do(initZstd)
+	return zstdDec
+}
@@ -20,14 +20,56 @@
 //
 // The total record size is: 20 bytes (header) + len(key) + len(value)
 //
+// # Expiry
+//
+// A record written with a TTL sets FlagExpiry, the top bit of KeySize, and
+// carries its expiry as a 64-bit Unix time in nanoseconds between the header
+// and the key:
+//
+//	[CRC32(4)][KeySize|FlagExpiry(4)][ValueSize(4)][Timestamp(8)][ExpiresAt(8)][Key][Value]
+//
+// Records without an expiry leave the bit clear and are encoded exactly as
+// before, so existing logs read unchanged. DataSize reports the bytes that
+// follow a header either way.
+//
+// # Compression
+//
+// A codec made with NewRecordCodecWithCompression compresses values of at
+// least CompressionOptions.MinSize bytes with snappy or zstd. A compressed
+// record sets FlagCompressed, the second bit of KeySize, and its value is
+// the algorithm byte followed by the compressed bytes:
+//
+//	[CRC32(4)][KeySize|FlagCompressed(4)][ValueSize(4)][Timestamp(8)][Algorithm(1)][Compressed value]
+//
+// ValueSize and the checksum cover the stored bytes. Values below the
+// threshold, or that don't shrink, are stored as-is and the bit is left
+// clear. Decode decompresses into Record.Value, so readers handle both
+// kinds of record alike and existing logs read unchanged.
+//
+// # Encryption
+//
+// A codec made with NewRecordCodecWithEncryption seals values with an
+// EncryptionProvider, such as an AES-256-GCM Keyring, after compressing
+// them. An encrypted record sets FlagEncrypted, the third bit of KeySize,
+// and its value names the key it was sealed with:
+//
+//	[CRC32(4)][KeySize|FlagEncrypted(4)][ValueSize(4)][Timestamp(8)][Key][KeyIDLength(1)][KeyID][Sealed value]
+//
+// The key ID lets records sealed before a key rotation be read as long as
+// the provider still holds the old key. Keys, empty values (tombstones) and
+// the records of reserved keys are stored in the clear. A record whose
+// checksum matches but whose value can't be decrypted fails to decode with
+// ErrDecrypt, so it isn't mistaken for corruption.
+//
 // # CRC32 Calculation
 //
 // The CRC32 checksum is calculated over all fields except the CRC32 field itself:
 //   - KeySize (4 bytes)
 //   - ValueSize (4 bytes)
 //   - Timestamp (8 bytes)
+//   - ExpiresAt (8 bytes, only when FlagExpiry is set)
 //   - Key data (KeySize bytes)
-//   - Value data (ValueSize bytes)
+//   - Value data (ValueSize bytes, as stored)
 //
 // This ensures that any corruption in the record header or data will be detected
 // during validation.
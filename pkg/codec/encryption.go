@@ -0,0 +1,210 @@
+package codec
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// FlagEncrypted is set in the encoded key size of a record whose value is
+// encrypted. Like FlagExpiry it takes a bit keys never reach, so records
+// written before encryption existed decode unchanged.
+const FlagEncrypted uint32 = 1 << 29
+
+// MaxKeyIDSize is the longest encryption key ID a record can carry
+const MaxKeyIDSize = 255
+
+// ErrDecrypt is returned when a record's checksum is valid but its value
+// can't be decrypted: the codec has no EncryptionProvider, or the provider
+// doesn't hold the key the record names, or holds a different key under
+// that ID. The data is intact, so it must not be treated as corruption.
+var ErrDecrypt = errors.New("record value can't be decrypted")
+
+// EncryptionProvider encrypts record values at rest. Each value is sealed
+// with the provider's current key, whose ID is written into the record, so
+// records sealed before a key rotation still name the key that opens them.
+// The record key is passed so it can be authenticated with the value,
+// which stops a value being moved to another key undetected.
+type EncryptionProvider interface {
+	// Encrypt seals value, returning the sealed bytes and the ID of the key
+	// used, at most MaxKeyIDSize bytes
+	Encrypt(key, value []byte) (keyID string, sealed []byte, err error)
+	// Decrypt opens a value sealed by Encrypt with the key keyID
+	Decrypt(keyID string, key, sealed []byte) ([]byte, error)
+}
+
+// NewRecordCodecWithEncryption creates a codec that encrypts values with
+// provider, after compressing them as compression says. Empty values,
+// which are tombstones, and the records of reserved keys, whose values are
+// key bounds and sequence counters, are stored in the clear like keys.
+func NewRecordCodecWithEncryption(provider EncryptionProvider, compression CompressionOptions) (*RecordCodec, error) {
+	if provider == nil {
+		return nil, fmt.Errorf("encryption provider must not be nil")
+	}
+	c, err := NewRecordCodecWithCompression(compression)
+	if err != nil {
+		return nil, err
+	}
+	c.encryption = provider
+	return c, nil
+}
+
+// Encryption returns the codec's encryption provider, or nil
+func (c *RecordCodec) Encryption() EncryptionProvider {
+	return c.encryption
+}
+
+// encrypt returns value sealed by the codec's provider, framed as
+// [KeyIDLength(1)][KeyID][Sealed], with the key ID
+func (c *RecordCodec) encrypt(key, value []byte) ([]byte, string, error) {
+	keyID, sealed, err := c.encryption.Encrypt(key, value)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encrypt value: %w", err)
+	}
+	if len(keyID) == 0 || len(keyID) > MaxKeyIDSize {
+		return nil, "", fmt.Errorf("encryption key ID must be 1 to %d bytes, got %d", MaxKeyIDSize, len(keyID))
+	}
+
+	stored := make([]byte, 0, 1+len(keyID)+len(sealed))
+	stored = append(stored, byte(len(keyID)))
+	stored = append(stored, keyID...)
+	return append(stored, sealed...), keyID, nil
+}
+
+// decrypt opens an encrypted record's stored value, returning it with the
+// key ID it names
+func (c *RecordCodec) decrypt(key, stored []byte) ([]byte, string, error) {
+	if len(stored) == 0 || len(stored) < 1+int(stored[0]) || stored[0] == 0 {
+		return nil, "", fmt.Errorf("encrypted value has no key ID")
+	}
+	keyID := string(stored[1 : 1+stored[0]])
+	if c.encryption == nil {
+		return nil, keyID, fmt.Errorf("%w: sealed with key %q and no encryption provider is configured", ErrDecrypt, keyID)
+	}
+	value, err := c.encryption.Decrypt(keyID, key, stored[1+stored[0]:])
+	if err != nil {
+		return nil, keyID, fmt.Errorf("%w: key %q: %v", ErrDecrypt, keyID, err)
+	}
+	return value, keyID, nil
+}
+
+// Keyring is an EncryptionProvider holding AES-256-GCM keys in memory.
+// Values are sealed with the current key; older keys stay in the ring so
+// the records sealed with them can still be read. To rotate, Add a new key
+// and Use it, and keep the old key until no record names it.
+type Keyring struct {
+	mutex   sync.RWMutex
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+// NewKeyring creates a keyring whose current key is key, under id. Keys
+// must be 32 random bytes, e.g. from openssl rand -hex 32 decoded.
+func NewKeyring(id string, key []byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	if err := k.Add(id, key); err != nil {
+		return nil, err
+	}
+	k.current = id
+	return k, nil
+}
+
+// Add puts a key in the ring without making it current
+func (k *Keyring) Add(id string, key []byte) error {
+	if len(id) == 0 || len(id) > MaxKeyIDSize {
+		return fmt.Errorf("key ID must be 1 to %d bytes, got %d", MaxKeyIDSize, len(id))
+	}
+	if len(key) != 32 {
+		return fmt.Errorf("encryption key %q must be 32 bytes, got %d", id, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if _, exists := k.keys[id]; exists {
+		return fmt.Errorf("key %q is already in the keyring", id)
+	}
+	k.keys[id] = aead
+	return nil
+}
+
+// Use makes the key id current, so new values are sealed with it
+func (k *Keyring) Use(id string) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("key %q is not in the keyring", id)
+	}
+	k.current = id
+	return nil
+}
+
+// Remove drops a retired key. Records still sealed with it can no longer
+// be read, so remove a key only once nothing names it.
+func (k *Keyring) Remove(id string) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if id == k.current {
+		return fmt.Errorf("key %q is current and can't be removed", id)
+	}
+	delete(k.keys, id)
+	return nil
+}
+
+// Current returns the ID of the key new values are sealed with
+func (k *Keyring) Current() string {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return k.current
+}
+
+// IDs returns the IDs of the keys in the ring, sorted
+func (k *Keyring) IDs() []string {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Encrypt implements EncryptionProvider. The sealed value is the nonce
+// followed by the ciphertext.
+func (k *Keyring) Encrypt(key, value []byte) (string, []byte, error) {
+	k.mutex.RLock()
+	id, aead := k.current, k.keys[k.current]
+	k.mutex.RUnlock()
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return id, aead.Seal(nonce, nonce, value, key), nil
+}
+
+// Decrypt implements EncryptionProvider
+func (k *Keyring) Decrypt(keyID string, key, sealed []byte) ([]byte, error) {
+	k.mutex.RLock()
+	aead, ok := k.keys[keyID]
+	k.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("key %q is not in the keyring", keyID)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("sealed value too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], key)
+}
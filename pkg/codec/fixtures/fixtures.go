@@ -0,0 +1,316 @@
+// Package fixtures publishes golden encoded records for FreyjaDB's record
+// format, so implementations in other languages can check that they read
+// and write it exactly as FreyjaDB does.
+//
+// The golden files live in testdata: manifest.json describes each fixture,
+// with keys and values in hex, and <name>.bin holds its encoded bytes. A
+// client reads each .bin file and checks that it decodes to the manifest's
+// fields, or fails as the manifest says it must; an encoder writes its own
+// .bin files for the same fields and checks them with Verify. Generate
+// rewrites the golden files from Cases; run
+//
+//	go test ./pkg/codec/fixtures -update
+//
+// after a deliberate format change, and add fixtures rather than edit old
+// ones, since clients may rely on them.
+package fixtures
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ssargent/freyjadb/pkg/codec"
+)
+
+// FormatVersion is the record format the fixtures describe. A new format
+// gets its own fixtures alongside these, so readers of both can be tested.
+const FormatVersion = 1
+
+// ManifestFile names the manifest in a fixture directory
+const ManifestFile = "manifest.json"
+
+// Record kinds. Tombstones, range tombstones and sequence reservations are
+// ordinary records told apart by their key and value; see package codec.
+const (
+	KindPut            = "put"
+	KindTombstone      = "tombstone"
+	KindRangeTombstone = "range_tombstone"
+	KindSequence       = "sequence"
+)
+
+// Ways an invalid fixture must be rejected
+const (
+	ErrorTruncated   = "truncated"    // Decoding fails: fewer bytes than the header declares
+	ErrorCRCMismatch = "crc_mismatch" // Decoding succeeds but the checksum doesn't match
+)
+
+// Manifest lists the fixtures of a directory
+type Manifest struct {
+	FormatVersion int       `json:"format_version"`
+	Fixtures      []Fixture `json:"fixtures"`
+}
+
+// Fixture describes one encoded record and what decoding it must yield.
+// Timestamps and sequence limits are decimal strings, since they don't fit
+// the number type of every JSON parser.
+type Fixture struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	File        string `json:"file"`
+	Kind        string `json:"kind"`
+	Valid       bool   `json:"valid"`
+	Error       string `json:"error,omitempty"` // How an invalid fixture fails
+
+	// Fields of a valid record
+	KeyHex    string `json:"key_hex,omitempty"`
+	ValueHex  string `json:"value_hex,omitempty"`
+	Timestamp uint64 `json:"timestamp,string"`
+	CRC32     uint32 `json:"crc32,omitempty"`
+	Size      int    `json:"size"` // Encoded size in bytes
+
+	// Decoded meaning of internal records
+	RangeStartHex string `json:"range_start_hex,omitempty"`
+	RangeEndHex   string `json:"range_end_hex,omitempty"` // Empty for an unbounded range
+	SequenceName  string `json:"sequence_name,omitempty"`
+	SequenceLimit uint64 `json:"sequence_limit,omitempty,string"`
+}
+
+// Case is a fixture before encoding
+type Case struct {
+	Name        string
+	Description string
+	Kind        string
+	Key, Value  []byte
+	Timestamp   uint64
+
+	// Corrupt damages a valid encoding into an invalid fixture failing
+	// with Error
+	Corrupt func(encoded []byte) []byte
+	Error   string
+}
+
+// baseTimestamp is the timestamp of most fixtures: 2023-11-14T22:13:20Z
+const baseTimestamp = 1700000000000000000
+
+// Cases returns the fixtures, in manifest order
+func Cases() []Case {
+	rangeKey, rangeValue := codec.NewRangeTombstone([]byte("user:"), []byte("user;"))
+	openKey, openValue := codec.NewRangeTombstone([]byte("order:"), nil)
+	seqKey, seqValue := codec.NewSequenceReservation("orders", 1000)
+
+	large := make([]byte, 64*1024)
+	for i := range large {
+		large[i] = byte(i * 31)
+	}
+
+	basic := []byte("user:123")
+	return []Case{
+		{Name: "basic", Description: "ASCII key and value", Kind: KindPut,
+			Key: basic, Value: []byte("john@example.com"), Timestamp: baseTimestamp},
+		{Name: "empty_key", Description: "Empty key with a value", Kind: KindPut,
+			Key: []byte{}, Value: []byte("some value"), Timestamp: baseTimestamp},
+		{Name: "empty_key_and_value", Description: "Header only: empty key and empty value", Kind: KindTombstone,
+			Key: []byte{}, Value: []byte{}, Timestamp: baseTimestamp},
+		{Name: "tombstone", Description: "A delete: a record with an empty value", Kind: KindTombstone,
+			Key: basic, Value: []byte{}, Timestamp: baseTimestamp + 1},
+		{Name: "unicode", Description: "Multi-byte UTF-8 in key and value", Kind: KindPut,
+			Key: []byte("🔑 clé:ключ"), Value: []byte(`{"name":"Zoë","city":"東京"}`), Timestamp: baseTimestamp},
+		{Name: "binary", Description: "Binary key and value, including NUL and 0xFF bytes", Kind: KindPut,
+			Key: []byte{0x00, 0x01, 0xfe, 0xff}, Value: []byte{0xff, 0x00, 0x80, 0x7f, 0x00}, Timestamp: baseTimestamp},
+		{Name: "large_key", Description: "1 KiB key", Kind: KindPut,
+			Key: bytes.Repeat([]byte("k"), 1024), Value: []byte("v"), Timestamp: baseTimestamp},
+		{Name: "large_value", Description: "64 KiB value covering every byte value", Kind: KindPut,
+			Key: []byte("blob:1"), Value: large, Timestamp: baseTimestamp},
+		{Name: "zero_timestamp", Description: "Timestamp 0", Kind: KindPut,
+			Key: basic, Value: []byte("v"), Timestamp: 0},
+		{Name: "max_timestamp", Description: "Largest timestamp, 2^64-1", Kind: KindPut,
+			Key: basic, Value: []byte("v"), Timestamp: ^uint64(0)},
+		{Name: "range_tombstone", Description: `Range delete of ["user:", "user;")`, Kind: KindRangeTombstone,
+			Key: rangeKey, Value: rangeValue, Timestamp: baseTimestamp},
+		{Name: "range_tombstone_unbounded", Description: `Range delete of every key from "order:"`,
+			Kind: KindRangeTombstone, Key: openKey, Value: openValue, Timestamp: baseTimestamp},
+		{Name: "sequence", Description: `Reservation of IDs up to 1000 of sequence "orders"`, Kind: KindSequence,
+			Key: seqKey, Value: seqValue, Timestamp: baseTimestamp},
+
+		{Name: "crc_mismatch", Description: "basic with a damaged checksum", Kind: KindPut,
+			Key: basic, Value: []byte("john@example.com"), Timestamp: baseTimestamp,
+			Corrupt: func(b []byte) []byte { b[0] ^= 0xff; return b }, Error: ErrorCRCMismatch},
+		{Name: "corrupt_value", Description: "basic with a flipped value byte", Kind: KindPut,
+			Key: basic, Value: []byte("john@example.com"), Timestamp: baseTimestamp,
+			Corrupt: func(b []byte) []byte { b[len(b)-1] ^= 0x01; return b }, Error: ErrorCRCMismatch},
+		{Name: "corrupt_timestamp", Description: "basic with a flipped timestamp bit", Kind: KindPut,
+			Key: basic, Value: []byte("john@example.com"), Timestamp: baseTimestamp,
+			Corrupt: func(b []byte) []byte { b[12] ^= 0x01; return b }, Error: ErrorCRCMismatch},
+		{Name: "truncated_header", Description: "The first 19 bytes of basic, one short of a header", Kind: KindPut,
+			Key: basic, Value: []byte("john@example.com"), Timestamp: baseTimestamp,
+			Corrupt: func(b []byte) []byte { return b[:19] }, Error: ErrorTruncated},
+		{Name: "truncated_value", Description: "basic missing its last byte", Kind: KindPut,
+			Key: basic, Value: []byte("john@example.com"), Timestamp: baseTimestamp,
+			Corrupt: func(b []byte) []byte { return b[:len(b)-1] }, Error: ErrorTruncated},
+		{Name: "max_sizes", Description: "A header declaring 2^32-1 byte keys and values, with no data; " +
+			"readers must not overflow adding the sizes", Kind: KindPut,
+			Key: []byte{}, Value: []byte{}, Timestamp: baseTimestamp,
+			Corrupt: func(b []byte) []byte {
+				binary.LittleEndian.PutUint32(b[4:], ^uint32(0))
+				binary.LittleEndian.PutUint32(b[8:], ^uint32(0))
+				return b
+			}, Error: ErrorTruncated},
+	}
+}
+
+// Build encodes the cases, returning the manifest and each fixture's bytes
+// by file name
+func Build(cases []Case) (*Manifest, map[string][]byte, error) {
+	c := codec.NewRecordCodec()
+	manifest := &Manifest{FormatVersion: FormatVersion}
+	files := make(map[string][]byte, len(cases))
+	for _, tc := range cases {
+		encoded, err := c.EncodeAt(tc.Key, tc.Value, tc.Timestamp)
+		if err != nil {
+			return nil, nil, fmt.Errorf("fixture %s: %w", tc.Name, err)
+		}
+
+		fixture := Fixture{Name: tc.Name, Description: tc.Description, File: tc.Name + ".bin",
+			Kind: tc.Kind, Valid: tc.Corrupt == nil}
+		if tc.Corrupt != nil {
+			encoded = tc.Corrupt(encoded)
+			fixture.Error = tc.Error
+		} else {
+			fixture.KeyHex = hex.EncodeToString(tc.Key)
+			fixture.ValueHex = hex.EncodeToString(tc.Value)
+			fixture.Timestamp = tc.Timestamp
+			fixture.CRC32 = binary.LittleEndian.Uint32(encoded)
+			describeInternal(&fixture, tc.Key, tc.Value)
+		}
+		fixture.Size = len(encoded)
+
+		manifest.Fixtures = append(manifest.Fixtures, fixture)
+		files[fixture.File] = encoded
+	}
+	return manifest, files, nil
+}
+
+// describeInternal records what an internal record means
+func describeInternal(fixture *Fixture, key, value []byte) {
+	record := &codec.Record{Key: key, Value: value}
+	if start, end, ok := codec.DecodeRangeTombstone(record); ok {
+		fixture.RangeStartHex = hex.EncodeToString(start)
+		fixture.RangeEndHex = hex.EncodeToString(end)
+	}
+	if name, limit, ok := codec.DecodeSequenceReservation(record); ok {
+		fixture.SequenceName, fixture.SequenceLimit = name, limit
+	}
+}
+
+// Generate writes the golden files for Cases to dir
+func Generate(dir string) error {
+	manifest, files, err := Build(Cases())
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			return err
+		}
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, ManifestFile), append(data, '\n'), 0600)
+}
+
+// LoadManifest reads the manifest of a fixture directory
+func LoadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Clean(filepath.Join(dir, ManifestFile)))
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", ManifestFile, err)
+	}
+	if manifest.FormatVersion != FormatVersion {
+		return nil, fmt.Errorf("fixtures are for format version %d, not %d", manifest.FormatVersion, FormatVersion)
+	}
+	return &manifest, nil
+}
+
+// Verify checks every fixture in dir against FreyjaDB's codec: valid
+// fixtures must decode to the manifest's fields and invalid ones must fail
+// as it says. Point it at .bin files written by another encoder, next to a
+// copy of the manifest, to check that encoder. All mismatches are reported.
+func Verify(dir string) error {
+	manifest, err := LoadManifest(dir)
+	if err != nil {
+		return err
+	}
+	var problems []string
+	for _, fixture := range manifest.Fixtures {
+		data, err := os.ReadFile(filepath.Clean(filepath.Join(dir, fixture.File)))
+		if err == nil {
+			err = fixture.Check(data)
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", fixture.Name, err))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// Check reports whether data decodes as the fixture says it must
+func (f *Fixture) Check(data []byte) error {
+	if len(data) != f.Size {
+		return fmt.Errorf("encoded size %d, want %d", len(data), f.Size)
+	}
+	record, err := codec.NewRecordCodec().Decode(data)
+	if !f.Valid {
+		switch {
+		case f.Error == ErrorTruncated && err == nil:
+			return fmt.Errorf("decoded a truncated record")
+		case f.Error == ErrorCRCMismatch && err != nil:
+			return fmt.Errorf("failed to decode: %w", err)
+		case f.Error == ErrorCRCMismatch && record.Validate() == nil:
+			return fmt.Errorf("accepted a record with a bad checksum")
+		}
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to decode: %w", err)
+	}
+	if err := record.Validate(); err != nil {
+		return err
+	}
+	switch {
+	case hex.EncodeToString(record.Key) != f.KeyHex:
+		return fmt.Errorf("key %x, want %s", record.Key, f.KeyHex)
+	case hex.EncodeToString(record.Value) != f.ValueHex:
+		return fmt.Errorf("value differs from value_hex")
+	case record.Timestamp != f.Timestamp:
+		return fmt.Errorf("timestamp %d, want %d", record.Timestamp, f.Timestamp)
+	case record.CRC32 != f.CRC32:
+		return fmt.Errorf("crc32 %d, want %d", record.CRC32, f.CRC32)
+	}
+
+	var decoded Fixture
+	describeInternal(&decoded, record.Key, record.Value)
+	if decoded.RangeStartHex != f.RangeStartHex || decoded.RangeEndHex != f.RangeEndHex ||
+		decoded.SequenceName != f.SequenceName || decoded.SequenceLimit != f.SequenceLimit {
+		return fmt.Errorf("decodes as a different internal record")
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package fixtures
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+const goldenDir = "testdata"
+
+func TestGoldenFiles(t *testing.T) {
+	if *update {
+		if err := Generate(goldenDir); err != nil {
+			t.Fatalf("Generate failed: %v", err)
+		}
+	}
+
+	// The committed files must match what the codec produces today, so a
+	// format change can't slip in unnoticed
+	manifest, files, err := Build(Cases())
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	want, _ := json.MarshalIndent(manifest, "", "  ")
+	got, err := os.ReadFile(filepath.Join(goldenDir, ManifestFile))
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if !bytes.Equal(bytes.TrimSpace(got), want) {
+		t.Errorf("%s is out of date; run go test -update if the change is intended", ManifestFile)
+	}
+	for name, data := range files {
+		golden, err := os.ReadFile(filepath.Join(goldenDir, name))
+		if err != nil || !bytes.Equal(golden, data) {
+			t.Errorf("%s differs from the codec's encoding: %v", name, err)
+		}
+	}
+
+	if err := Verify(goldenDir); err != nil {
+		t.Errorf("Golden files failed verification:\n%v", err)
+	}
+}
+
+func TestVerify_DetectsBadEncoder(t *testing.T) {
+	dir := t.TempDir()
+	if err := Generate(dir); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	// An encoder that writes sizes big-endian, and one that accepts bad
+	// checksums, must both be caught
+	basic := filepath.Join(dir, "basic.bin")
+	data, _ := os.ReadFile(basic)
+	data[4], data[7] = data[7], data[4]
+	if err := os.WriteFile(basic, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	manifest, _ := LoadManifest(dir)
+	for i := range manifest.Fixtures {
+		if manifest.Fixtures[i].Name == "crc_mismatch" {
+			manifest.Fixtures[i].Error = ErrorTruncated
+		}
+	}
+	encoded, _ := json.Marshal(manifest)
+	if err := os.WriteFile(filepath.Join(dir, ManifestFile), encoded, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := Verify(dir)
+	if err == nil || !strings.Contains(err.Error(), "basic:") || !strings.Contains(err.Error(), "crc_mismatch:") {
+		t.Errorf("Expected both fixtures to fail, got %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package codec
+
+import "bytes"
+
+// RangeTombstoneKeyPrefix marks a record as a ranged tombstone. Range
+// tombstones reuse the regular record layout: the key is this prefix followed
+// by the inclusive range start, and the value is a one-byte kind marker
+// followed by the exclusive range end (empty end = unbounded).
+var RangeTombstoneKeyPrefix = []byte("\x00\xffrange-tombstone\x00")
+
+// rangeTombstoneKind is the value marker so a range tombstone value is never
+// empty (an empty value would read as a point tombstone)
+const rangeTombstoneKind byte = 0x01
+
+// NewRangeTombstone builds the key and value of a ranged tombstone covering
+// [start, end). A nil or empty end covers every key >= start.
+func NewRangeTombstone(start, end []byte) (key, value []byte) {
+	key = make([]byte, 0, len(RangeTombstoneKeyPrefix)+len(start))
+	key = append(key, RangeTombstoneKeyPrefix...)
+	key = append(key, start...)
+
+	value = make([]byte, 0, 1+len(end))
+	value = append(value, rangeTombstoneKind)
+	value = append(value, end...)
+	return key, value
+}
+
+// IsReservedKey reports whether key falls in the namespace used for range
+// tombstones and sequence reservations and therefore cannot be written by
+// callers
+func IsReservedKey(key []byte) bool {
+	return bytes.HasPrefix(key, RangeTombstoneKeyPrefix) || bytes.HasPrefix(key, SequenceKeyPrefix)
+}
+
+// DecodeRangeTombstone returns the range covered by r if it is a range
+// tombstone record
+func DecodeRangeTombstone(r *Record) (start, end []byte, ok bool) {
+	if !bytes.HasPrefix(r.Key, RangeTombstoneKeyPrefix) || len(r.Value) == 0 || r.Value[0] != rangeTombstoneKind {
+		return nil, nil, false
+	}
+	return r.Key[len(RangeTombstoneKeyPrefix):], r.Value[1:], true
+}
+
+// PrefixEnd returns the smallest key greater than every key with the given
+// prefix, or nil if no such key exists (prefix is empty or all 0xFF)
+func PrefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
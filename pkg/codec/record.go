@@ -7,64 +7,212 @@ import (
 	"time"
 )
 
+// HeaderSize is the size of the fixed record header:
+// CRC32(4) + KeySize(4) + ValueSize(4) + Timestamp(8)
+const HeaderSize = 20
+
+// ExpirySize is the size of the expiry field that follows the header of a
+// record with an expiry
+const ExpirySize = 8
+
+// FlagExpiry is set in the encoded key size of a record with an expiry. Keys
+// never approach 2GiB, so the top bit was always clear and records written
+// before expiries existed decode unchanged.
+const FlagExpiry uint32 = 1 << 31
+
+// keySizeFlags are the bits of the key size field that aren't the size
+const keySizeFlags = FlagExpiry | FlagCompressed | FlagEncrypted
+
 // Record represents a key-value record with metadata for storage
 type Record struct {
 	CRC32     uint32 // CRC32 checksum for integrity
 	KeySize   uint32 // Size of the key in bytes
 	ValueSize uint32 // Size of the value in bytes
 	Timestamp uint64 // Unix timestamp in nanoseconds
+	ExpiresAt uint64 // Unix time in nanoseconds the record expires at (0 = never)
 	Key       []byte // Key data
-	Value     []byte // Value data
+	Value     []byte // Value data, always decompressed and decrypted
+
+	// How the value is stored. When it is compressed or encrypted,
+	// ValueSize and the checksum cover the stored bytes, not Value.
+	Compression Compression // Algorithm the value is compressed with
+	KeyID       string      // Encryption key the value is sealed with ("" = not encrypted)
+	flags       uint32      // FlagCompressed and FlagEncrypted, as written
+	stored      []byte      // Value as stored, when flags are set
 }
 
 // RecordCodec handles serialization and deserialization of records
-type RecordCodec struct{}
+type RecordCodec struct {
+	compression CompressionOptions
+	encryption  EncryptionProvider
+}
 
 // NewRecordCodec creates a new record codec instance
 func NewRecordCodec() *RecordCodec {
 	return &RecordCodec{}
 }
 
+// NewRecordCodecWithCompression creates a codec that compresses values of
+// at least opts.MinSize bytes. Any codec decodes its records, and records
+// without compression are encoded exactly as by NewRecordCodec.
+func NewRecordCodecWithCompression(opts CompressionOptions) (*RecordCodec, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return &RecordCodec{compression: opts}, nil
+}
+
+// Compression returns the codec's compression options
+func (c *RecordCodec) Compression() CompressionOptions {
+	return c.compression
+}
+
 // Encode serializes a key-value pair into a binary record format
 // Format: [CRC32(4)][KeySize(4)][ValueSize(4)][Timestamp(8)][Key][Value]
 func (c *RecordCodec) Encode(key, value []byte) ([]byte, error) {
+	return c.EncodeAt(key, value, uint64(time.Now().UnixNano()))
+}
+
+// EncodeAt serializes a key-value pair with the given timestamp, so the
+// encoding is reproducible, e.g. for golden files
+func (c *RecordCodec) EncodeAt(key, value []byte, timestamp uint64) ([]byte, error) {
+	return c.EncodeExpiring(key, value, timestamp, 0)
+}
+
+// EncodeExpiring serializes a key-value pair that expires at expiresAt, in
+// Unix nanoseconds; 0 never expires and encodes exactly like EncodeAt.
+// A value the codec compresses is stored as its algorithm byte followed by
+// the compressed bytes, and flagged with FlagCompressed; one it encrypts,
+// after compressing, is stored as its key ID and sealed bytes, and flagged
+// with FlagEncrypted.
+// Format: [CRC32(4)][KeySize|Flags(4)][ValueSize(4)][Timestamp(8)][ExpiresAt(8)][Key][Value]
+func (c *RecordCodec) EncodeExpiring(key, value []byte, timestamp, expiresAt uint64) ([]byte, error) {
 	r := NewRecord(key, value)
+	if r.KeySize&keySizeFlags != 0 {
+		return nil, fmt.Errorf("key too large: %d bytes", r.KeySize)
+	}
+	stored := value
+	if compressed := c.compression.compress(value); compressed != nil {
+		r.Compression = c.compression.Algorithm
+		r.flags |= FlagCompressed
+		stored = compressed
+	}
+	if c.encryption != nil && len(value) > 0 && !IsReservedKey(key) {
+		sealed, keyID, err := c.encrypt(key, stored)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(len(sealed)) > uint64(^uint32(0)) {
+			return nil, fmt.Errorf("value too large: %d bytes encrypted", len(sealed))
+		}
+		r.KeyID = keyID
+		r.flags |= FlagEncrypted
+		stored = sealed
+	}
+	if r.flags != 0 {
+		r.stored = stored
+		r.ValueSize = uint32(len(stored)) //nolint:gosec // checked above
+	}
+	r.Timestamp = timestamp
+	r.ExpiresAt = expiresAt
 	r.CRC32 = r.calculateCRC32()
 
 	buf := make([]byte, r.Size())
 
 	binary.LittleEndian.PutUint32(buf[0:], r.CRC32)
-	binary.LittleEndian.PutUint32(buf[4:], r.KeySize)
+	binary.LittleEndian.PutUint32(buf[4:], r.encodedKeySize())
 	binary.LittleEndian.PutUint32(buf[8:], r.ValueSize)
 	binary.LittleEndian.PutUint64(buf[12:], r.Timestamp)
-	copy(buf[20:], r.Key)
-	copy(buf[20+r.KeySize:], r.Value)
+	keyStart := HeaderSize
+	if r.ExpiresAt != 0 {
+		binary.LittleEndian.PutUint64(buf[HeaderSize:], r.ExpiresAt)
+		keyStart += ExpirySize
+	}
+	copy(buf[keyStart:], r.Key)
+	copy(buf[keyStart+int(r.KeySize):], r.storedValue())
 
 	return buf, nil
 }
 
-// Decode deserializes a binary record into a Record struct
+// DataSize returns how many bytes follow a record's header, given at least
+// its first HeaderSize bytes: the expiry, if any, the key and the value
+func DataSize(header []byte) int64 {
+	keySize := binary.LittleEndian.Uint32(header[4:8])
+	size := int64(keySize&^keySizeFlags) + int64(binary.LittleEndian.Uint32(header[8:12]))
+	if keySize&FlagExpiry != 0 {
+		size += ExpirySize
+	}
+	return size
+}
+
+// Decode deserializes a binary record into a Record struct. A compressed
+// or encrypted value is decompressed and decrypted into Value, so callers
+// read records the same way however they were stored. Such a record is
+// checked against its checksum first: if it doesn't match, it is returned
+// with the value as stored, for Validate to reject as corrupt. An intact
+// value that can't be decrypted is an error wrapping ErrDecrypt.
 func (c *RecordCodec) Decode(data []byte) (*Record, error) {
-	if len(data) < 20 {
+	if len(data) < HeaderSize {
 		return nil, fmt.Errorf("data too short for record header")
 	}
 
 	r := &Record{}
 	r.CRC32 = binary.LittleEndian.Uint32(data[0:4])
-	r.KeySize = binary.LittleEndian.Uint32(data[4:8])
+	keySize := binary.LittleEndian.Uint32(data[4:8])
+	r.KeySize = keySize &^ keySizeFlags
 	r.ValueSize = binary.LittleEndian.Uint32(data[8:12])
 	r.Timestamp = binary.LittleEndian.Uint64(data[12:20])
-	// Validate sizes
-	if len(data) < int(20+r.KeySize+r.ValueSize) {
-		return nil, fmt.Errorf("data too short for key/value sizes: %d < %d", len(data), 20+r.KeySize+r.ValueSize)
+	// Validate sizes, in 64 bits so huge declared sizes can't wrap around
+	size := uint64(HeaderSize) + uint64(DataSize(data))
+	if uint64(len(data)) < size {
+		return nil, fmt.Errorf("data too short for key/value sizes: %d < %d", len(data), size)
 	}
 
-	r.Key = data[20 : 20+r.KeySize]
-	r.Value = data[20+r.KeySize : 20+r.KeySize+r.ValueSize]
+	keyStart := uint64(HeaderSize)
+	if keySize&FlagExpiry != 0 {
+		r.ExpiresAt = binary.LittleEndian.Uint64(data[HeaderSize:])
+		if r.ExpiresAt == 0 {
+			return nil, fmt.Errorf("record flags an expiry but has none")
+		}
+		keyStart += ExpirySize
+	}
+	valueStart := keyStart + uint64(r.KeySize)
+	r.Key = data[keyStart:valueStart]
+	r.Value = data[valueStart:size]
+	if flags := keySize & (FlagCompressed | FlagEncrypted); flags != 0 {
+		r.flags = flags
+		r.stored = r.Value
+		if r.CRC32 != r.calculateCRC32() {
+			return r, nil
+		}
+		if err := c.decodeValue(r); err != nil {
+			return nil, err
+		}
+	}
 
 	return r, nil
 }
 
+// decodeValue decrypts and decompresses the stored value of a record into
+// Value
+func (c *RecordCodec) decodeValue(r *Record) error {
+	value := r.stored
+	if r.flags&FlagEncrypted != 0 {
+		var err error
+		if value, r.KeyID, err = c.decrypt(r.Key, value); err != nil {
+			return err
+		}
+	}
+	if r.flags&FlagCompressed != 0 {
+		var err error
+		if r.Compression, value, err = decompress(value); err != nil {
+			return fmt.Errorf("failed to decompress value: %w", err)
+		}
+	}
+	r.Value = value
+	return nil
+}
+
 // Validate checks the integrity of a record using CRC32
 func (r *Record) Validate() error {
 	if r.CRC32 != r.calculateCRC32() {
@@ -77,8 +225,37 @@ func (r *Record) Validate() error {
 // Size returns the total size of the record when encoded
 func (r *Record) Size() int {
 	// Header: CRC32(4) + KeySize(4) + ValueSize(4) + Timestamp(8) = 20 bytes
-	// Data: len(Key) + len(Value)
-	return 20 + len(r.Key) + len(r.Value)
+	// Data: [ExpiresAt(8)] + len(Key) + the stored value
+	size := HeaderSize + len(r.Key) + len(r.storedValue())
+	if r.ExpiresAt != 0 {
+		size += ExpirySize
+	}
+	return size
+}
+
+// Expired reports whether the record has an expiry at or before now, in
+// Unix nanoseconds
+func (r *Record) Expired(now uint64) bool {
+	return r.ExpiresAt != 0 && r.ExpiresAt <= now
+}
+
+// encodedKeySize is the key size field as written, flagging an expiry,
+// compression and encryption
+func (r *Record) encodedKeySize() uint32 {
+	size := r.KeySize | r.flags
+	if r.ExpiresAt != 0 {
+		size |= FlagExpiry
+	}
+	return size
+}
+
+// storedValue is the value as written: compressed or encrypted, or Value
+// itself
+func (r *Record) storedValue() []byte {
+	if r.flags != 0 {
+		return r.stored
+	}
+	return r.Value
 }
 
 // NewRecord creates a new record with current timestamp
@@ -103,11 +280,11 @@ func NewRecord(key, value []byte) *Record {
 // calculateCRC32 computes CRC32 checksum for record data (excluding the CRC field itself)
 func (r *Record) calculateCRC32() uint32 {
 	// TODO: Implement CRC32 calculation
-	// Calculate checksum over: KeySize + ValueSize + Timestamp + Key + Value
+	// Calculate checksum over: KeySize + ValueSize + Timestamp + [ExpiresAt] + Key + stored Value
 	crc := crc32.NewIEEE()
 
 	// Write header fields (excluding CRC32)
-	if err := binary.Write(crc, binary.LittleEndian, r.KeySize); err != nil {
+	if err := binary.Write(crc, binary.LittleEndian, r.encodedKeySize()); err != nil {
 		return 0
 	}
 	if err := binary.Write(crc, binary.LittleEndian, r.ValueSize); err != nil {
@@ -116,12 +293,17 @@ func (r *Record) calculateCRC32() uint32 {
 	if err := binary.Write(crc, binary.LittleEndian, r.Timestamp); err != nil {
 		return 0
 	}
+	if r.ExpiresAt != 0 {
+		if err := binary.Write(crc, binary.LittleEndian, r.ExpiresAt); err != nil {
+			return 0
+		}
+	}
 
 	// Write data
 	if _, err := crc.Write(r.Key); err != nil {
 		return 0
 	}
-	if _, err := crc.Write(r.Value); err != nil {
+	if _, err := crc.Write(r.storedValue()); err != nil {
 		return 0
 	}
 
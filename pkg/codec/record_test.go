@@ -3,6 +3,8 @@ package codec
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"math/rand"
 	"testing"
 	"time"
 )
@@ -235,6 +237,15 @@ func TestRecordCodec_MalformedData(t *testing.T) {
 				return buf
 			}(),
 		},
+		{
+			name: "declared sizes that overflow 32 bits when added",
+			data: func() []byte {
+				buf := make([]byte, 20)
+				binary.LittleEndian.PutUint32(buf[4:8], ^uint32(0))
+				binary.LittleEndian.PutUint32(buf[8:12], ^uint32(0))
+				return buf
+			}(),
+		},
 	}
 
 	for _, tc := range testCases {
@@ -247,6 +258,253 @@ func TestRecordCodec_MalformedData(t *testing.T) {
 	}
 }
 
+func TestRecordCodec_EncodeAt(t *testing.T) {
+	codec := NewRecordCodec()
+
+	first, err := codec.EncodeAt([]byte("key"), []byte("value"), 42)
+	if err != nil {
+		t.Fatalf("EncodeAt failed: %v", err)
+	}
+	second, _ := codec.EncodeAt([]byte("key"), []byte("value"), 42)
+	if !bytes.Equal(first, second) {
+		t.Error("Expected EncodeAt to be reproducible")
+	}
+
+	record, err := codec.Decode(first)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if record.Timestamp != 42 || record.Validate() != nil {
+		t.Errorf("Unexpected record %+v", record)
+	}
+}
+
+func TestRecordCodec_EncodeExpiring(t *testing.T) {
+	codec := NewRecordCodec()
+
+	// Without an expiry the encoding is unchanged
+	plain, _ := codec.EncodeAt([]byte("key"), []byte("value"), 42)
+	unexpiring, err := codec.EncodeExpiring([]byte("key"), []byte("value"), 42, 0)
+	if err != nil || !bytes.Equal(plain, unexpiring) {
+		t.Fatalf("Expected a zero expiry to encode like EncodeAt, got %v", err)
+	}
+
+	encoded, err := codec.EncodeExpiring([]byte("key"), []byte("value"), 42, 1000)
+	if err != nil {
+		t.Fatalf("EncodeExpiring failed: %v", err)
+	}
+	if len(encoded) != HeaderSize+ExpirySize+3+5 {
+		t.Errorf("Expected an 8-byte expiry after the header, got %d bytes", len(encoded))
+	}
+	if DataSize(encoded) != ExpirySize+3+5 {
+		t.Errorf("Expected DataSize to count the expiry, got %d", DataSize(encoded))
+	}
+
+	record, err := codec.Decode(encoded)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if err := record.Validate(); err != nil {
+		t.Fatalf("Expected a valid record, got %v", err)
+	}
+	if record.ExpiresAt != 1000 || record.KeySize != 3 || string(record.Key) != "key" || string(record.Value) != "value" {
+		t.Errorf("Unexpected record %+v", record)
+	}
+	if record.Size() != len(encoded) {
+		t.Errorf("Size mismatch: got %d, want %d", record.Size(), len(encoded))
+	}
+	if record.Expired(999) || !record.Expired(1000) {
+		t.Error("Expected the record to expire at 1000")
+	}
+
+	// The expiry is covered by the checksum
+	encoded[HeaderSize] ^= 0x01
+	record, err = codec.Decode(encoded)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if record.Validate() == nil {
+		t.Error("Expected a corrupted expiry to fail validation")
+	}
+}
+
+func TestRecordCodec_Compression(t *testing.T) {
+	plain := NewRecordCodec()
+	value := bytes.Repeat([]byte("compressible "), 100)
+
+	for _, algorithm := range []Compression{CompressionSnappy, CompressionZstd} {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			compressing, err := NewRecordCodecWithCompression(CompressionOptions{Algorithm: algorithm, MinSize: 64})
+			if err != nil {
+				t.Fatalf("NewRecordCodecWithCompression failed: %v", err)
+			}
+
+			encoded, err := compressing.EncodeExpiring([]byte("key"), value, 42, 1000)
+			if err != nil {
+				t.Fatalf("EncodeExpiring failed: %v", err)
+			}
+			if len(encoded) >= HeaderSize+ExpirySize+3+len(value) {
+				t.Errorf("Expected the value to be compressed, got %d bytes", len(encoded))
+			}
+			if int(HeaderSize+DataSize(encoded)) != len(encoded) {
+				t.Errorf("Expected DataSize to count the stored value, got %d", DataSize(encoded))
+			}
+
+			// Any codec decodes the record back to the original value
+			record, err := plain.Decode(encoded)
+			if err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			if err := record.Validate(); err != nil {
+				t.Fatalf("Expected a valid record, got %v", err)
+			}
+			if record.Compression != algorithm || record.KeySize != 3 || record.ExpiresAt != 1000 ||
+				string(record.Key) != "key" || !bytes.Equal(record.Value, value) {
+				t.Errorf("Unexpected record %+v", record)
+			}
+			if record.Size() != len(encoded) {
+				t.Errorf("Size mismatch: got %d, want %d", record.Size(), len(encoded))
+			}
+
+			// A damaged compressed value fails to decode or to validate
+			encoded[len(encoded)-1] ^= 0xff
+			if record, err := plain.Decode(encoded); err == nil && record.Validate() == nil {
+				t.Error("Expected a corrupted compressed value to be rejected")
+			}
+		})
+	}
+
+	compressing, err := NewRecordCodecWithCompression(CompressionOptions{Algorithm: CompressionZstd})
+	if err != nil {
+		t.Fatalf("NewRecordCodecWithCompression failed: %v", err)
+	}
+
+	// Values below the threshold, and values that don't shrink, are stored
+	// exactly as without compression
+	small := bytes.Repeat([]byte("v"), DefaultCompressionThreshold-1)
+	random := make([]byte, 1024)
+	rand.New(rand.NewSource(1)).Read(random)
+	for _, value := range [][]byte{small, random, nil} {
+		want, _ := plain.EncodeAt([]byte("key"), value, 42)
+		got, err := compressing.EncodeAt([]byte("key"), value, 42)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("Expected a %d-byte value to be stored uncompressed, got %v", len(value), err)
+		}
+	}
+
+	if _, err := NewRecordCodecWithCompression(CompressionOptions{Algorithm: 9}); err == nil {
+		t.Error("Expected an unknown algorithm to be rejected")
+	}
+	if _, err := NewRecordCodecWithCompression(CompressionOptions{MinSize: -1}); err == nil {
+		t.Error("Expected a negative threshold to be rejected")
+	}
+	for name, want := range map[string]Compression{"": CompressionNone, "Snappy": CompressionSnappy, "zstd": CompressionZstd} {
+		if got, err := ParseCompression(name); err != nil || got != want {
+			t.Errorf("ParseCompression(%q) = %v, %v; want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseCompression("lz4"); err == nil {
+		t.Error("Expected an unknown algorithm name to be rejected")
+	}
+}
+
+func TestRecordCodec_Encryption(t *testing.T) {
+	keyring, err := NewKeyring("k1", bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("NewKeyring failed: %v", err)
+	}
+	encrypting, err := NewRecordCodecWithEncryption(keyring, CompressionOptions{Algorithm: CompressionSnappy})
+	if err != nil {
+		t.Fatalf("NewRecordCodecWithEncryption failed: %v", err)
+	}
+	value := bytes.Repeat([]byte("secret "), 100)
+
+	encoded, err := encrypting.EncodeExpiring([]byte("key"), value, 42, 1000)
+	if err != nil {
+		t.Fatalf("EncodeExpiring failed: %v", err)
+	}
+	if bytes.Contains(encoded, []byte("secret")) {
+		t.Error("Expected the value not to be stored in the clear")
+	}
+
+	// The value is compressed, then sealed with the current key
+	record, err := encrypting.Decode(encoded)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if err := record.Validate(); err != nil {
+		t.Fatalf("Expected a valid record, got %v", err)
+	}
+	if record.KeyID != "k1" || record.Compression != CompressionSnappy || record.ExpiresAt != 1000 ||
+		!bytes.Equal(record.Value, value) || record.Size() != len(encoded) {
+		t.Errorf("Unexpected record %+v", record)
+	}
+
+	// After a rotation the old record still opens with the old key
+	if err := keyring.Add("k2", bytes.Repeat([]byte{2}, 32)); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := keyring.Use("k2"); err != nil {
+		t.Fatalf("Use failed: %v", err)
+	}
+	rotated, _ := encrypting.EncodeAt([]byte("key"), []byte("new"), 43)
+	for _, data := range [][]byte{encoded, rotated} {
+		if _, err := encrypting.Decode(data); err != nil {
+			t.Errorf("Decode failed after rotation: %v", err)
+		}
+	}
+	if err := keyring.Remove("k2"); err == nil {
+		t.Error("Expected the current key not to be removable")
+	}
+	if err := keyring.Remove("k1"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if _, err := encrypting.Decode(encoded); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected a retired key to fail with ErrDecrypt, got %v", err)
+	}
+
+	// An intact record without a provider is a decryption error, but a
+	// damaged one is left for Validate to reject
+	if _, err := NewRecordCodec().Decode(rotated); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt without a provider, got %v", err)
+	}
+	rotated[len(rotated)-1] ^= 0xff
+	record, err = encrypting.Decode(rotated)
+	if err != nil {
+		t.Fatalf("Expected a damaged record to decode, got %v", err)
+	}
+	if record.Validate() == nil {
+		t.Error("Expected a damaged record to fail validation")
+	}
+
+	// The value is bound to its key
+	keyID, sealed, err := keyring.Encrypt([]byte("ab"), []byte("value"))
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if _, err := keyring.Decrypt(keyID, []byte("ba"), sealed); err == nil {
+		t.Error("Expected a value moved to another key to fail decryption")
+	}
+
+	// Tombstones and reserved records stay in the clear
+	plain := NewRecordCodec()
+	rangeKey, rangeValue := NewRangeTombstone([]byte("a"), []byte("b"))
+	for _, pair := range [][2][]byte{{[]byte("key"), nil}, {rangeKey, rangeValue}} {
+		want, _ := plain.EncodeAt(pair[0], pair[1], 42)
+		got, _ := encrypting.EncodeAt(pair[0], pair[1], 42)
+		if !bytes.Equal(got, want) {
+			t.Errorf("Expected %q to be stored in the clear", pair[0])
+		}
+	}
+
+	if _, err := NewRecordCodecWithEncryption(nil, CompressionOptions{}); err == nil {
+		t.Error("Expected a nil provider to be rejected")
+	}
+	if _, err := NewKeyring("short", []byte("too short")); err == nil {
+		t.Error("Expected a short key to be rejected")
+	}
+}
+
 func TestRecord_Size(t *testing.T) {
 	testCases := []struct {
 		name         string
@@ -0,0 +1,36 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// SequenceKeyPrefix marks a record as a sequence reservation. The key is this
+// prefix followed by the sequence name, and the value is a one-byte kind
+// marker followed by the highest reserved ID as a big-endian uint64.
+var SequenceKeyPrefix = []byte("\x00\xffsequence\x00")
+
+// sequenceKind is the value marker of a sequence reservation
+const sequenceKind byte = 0x02
+
+// NewSequenceReservation builds the key and value of a record reserving every
+// ID of the named sequence up to and including limit
+func NewSequenceReservation(name string, limit uint64) (key, value []byte) {
+	key = make([]byte, 0, len(SequenceKeyPrefix)+len(name))
+	key = append(key, SequenceKeyPrefix...)
+	key = append(key, name...)
+
+	value = make([]byte, 9)
+	value[0] = sequenceKind
+	binary.BigEndian.PutUint64(value[1:], limit)
+	return key, value
+}
+
+// DecodeSequenceReservation returns the sequence name and reserved limit of r
+// if it is a sequence reservation record
+func DecodeSequenceReservation(r *Record) (name string, limit uint64, ok bool) {
+	if !bytes.HasPrefix(r.Key, SequenceKeyPrefix) || len(r.Value) != 9 || r.Value[0] != sequenceKind {
+		return "", 0, false
+	}
+	return string(r.Key[len(SequenceKeyPrefix):]), binary.BigEndian.Uint64(r.Value[1:]), true
+}
@@ -9,30 +9,167 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 // Config represents the FreyjaDB configuration
 type Config struct {
-	DataDir  string   `yaml:"data_dir"`
-	Port     int      `yaml:"port"`
-	Bind     string   `yaml:"bind"`
-	Security Security `yaml:"security"`
-	Logging  Logging  `yaml:"logging"`
+	DataDir   string   `yaml:"data_dir"`
+	DataDirs  []string `yaml:"data_dirs,omitempty"` // Extra directories segments may be spread across
+	Placement string   `yaml:"placement,omitempty"` // "round-robin" (default) or "free-space"
+	Port      int      `yaml:"port"`
+	Bind      string   `yaml:"bind"`
+	Security  Security `yaml:"security"`
+	Logging   Logging  `yaml:"logging"`
+	Startup   Startup  `yaml:"startup"`
+	Alerts    Alerts   `yaml:"alerts,omitempty"`
+	SLO       SLO      `yaml:"slo,omitempty"`
+
+	// Middleware orders the server's built-in middlewares
+	Middleware Middleware `yaml:"middleware,omitempty"`
+
+	// DedupeWrites skips appending a Put whose value equals the key's
+	// current value, e.g. for periodic syncs that rewrite unchanged data
+	DedupeWrites bool `yaml:"dedupe_writes,omitempty"`
+
+	// WriteLimits pause writes to a key prefix once it takes MaxPerMinute
+	// writes in a rolling minute, e.g. to stop a runaway job
+	WriteLimits []WriteLimit `yaml:"write_limits,omitempty"`
+
+	// GroupCommitWindow, e.g. 2ms, makes concurrent writes share fsyncs.
+	// Writes are still durable when acknowledged but wait up to the window.
+	GroupCommitWindow time.Duration `yaml:"group_commit_window,omitempty"`
+
+	// WriteMode "memtable" holds recent writes in memory and flushes them as
+	// key-ordered segments of MemtableSize bytes; "" (default) only appends
+	WriteMode    string `yaml:"write_mode,omitempty"`
+	MemtableSize int64  `yaml:"memtable_size,omitempty"`
+
+	// MaxSegmentSize is the active log size at which it is sealed as a
+	// segment in append mode (default 64MiB, negative disables rotation)
+	MaxSegmentSize int64 `yaml:"max_segment_size,omitempty"`
+
+	// CompactionCluster orders the records freyja compact writes: "none"
+	// (default) keeps write order, "pk" groups by partition key and
+	// "prefix" by the first ClusterDepth key components
+	CompactionCluster string `yaml:"compaction_cluster,omitempty"`
+	ClusterDepth      int    `yaml:"cluster_depth,omitempty"`
+
+	// ReadaheadSize is how many bytes a sequential prefix scan reads at a
+	// time (default 256KiB, negative disables readahead)
+	ReadaheadSize int `yaml:"readahead_size,omitempty"`
+
+	// Compression compresses values of at least CompressionMinSize bytes
+	// (default 256) before they are written: "none" (default), "snappy" or
+	// "zstd". Changing it only affects new writes.
+	Compression        string `yaml:"compression,omitempty"`
+	CompressionMinSize int    `yaml:"compression_min_size,omitempty"`
+
+	// BackgroundLatencyTarget is the client latency under which backups,
+	// index rebuilds and compaction run at full speed (default 5ms,
+	// negative disables IO scheduling). Above it they are held to
+	// MinBackgroundRate bytes per second (default 4MiB).
+	BackgroundLatencyTarget time.Duration `yaml:"background_latency_target,omitempty"`
+	MinBackgroundRate       int64         `yaml:"min_background_rate,omitempty"`
+
+	// ShutdownGracePeriod is how long a stopping server waits for
+	// in-flight requests before closing the store (default 30s)
+	ShutdownGracePeriod time.Duration `yaml:"shutdown_grace_period,omitempty"`
+
+	// SecretsFile, when set, holds the keys instead of this file. A relative
+	// path is resolved against the config file's directory.
+	SecretsFile string `yaml:"secrets_file,omitempty"`
+}
+
+// Startup controls the work the store does when it is opened
+type Startup struct {
+	IndexLoad      string        `yaml:"index_load,omitempty"`       // "eager" (default) or "lazy"
+	WarmupPrefixes []string      `yaml:"warmup_prefixes,omitempty"`  // Key prefixes to read into the page cache
+	WarmupMaxBytes int64         `yaml:"warmup_max_bytes,omitempty"` // Cap on warmup reads (0 = unlimited)
+	RecoveryBudget time.Duration `yaml:"recovery_budget,omitempty"`  // Abort startup if recovery exceeds this
+	FastRestart    bool          `yaml:"fast_restart,omitempty"`     // Skip log validation after a clean shutdown
+	LockTimeout    time.Duration `yaml:"lock_timeout,omitempty"`     // Wait this long for another process to release the data directory
+}
+
+// WriteLimit is a circuit breaker on the writes to a key prefix, the first
+// key component with its delimiter, e.g. "logs:"
+type WriteLimit struct {
+	Prefix       string `yaml:"prefix"`
+	MaxPerMinute int64  `yaml:"max_per_minute"`
+}
+
+// Alerts sets soft limits the server checks in the background, posting to
+// WebhookURL when one is crossed. Alerting is off without a webhook, and a
+// zero threshold is not checked.
+type Alerts struct {
+	WebhookURL          string        `yaml:"webhook_url,omitempty"`            // e.g. a Slack incoming webhook
+	Interval            time.Duration `yaml:"interval,omitempty"`               // How often to check (default 1m)
+	DiskUsedPct         float64       `yaml:"disk_used_pct,omitempty"`          // Data directory's filesystem fuller than this
+	MaxKeys             int           `yaml:"max_keys,omitempty"`               // More keys than this
+	ErrorRatePct        float64       `yaml:"error_rate_pct,omitempty"`         // Share of requests failing with a 5xx
+	MaxReplicationLagKB int64         `yaml:"max_replication_lag_kb,omitempty"` // Standby further behind than this
+}
+
+// SLO lists latency objectives the server tracks, e.g. 99% of Gets under
+// 5ms, reporting their compliance and error budget on /api/v1/system/slo
+// and /metrics
+type SLO struct {
+	Window     time.Duration  `yaml:"window,omitempty"` // Rolling window judged (default 1h)
+	Objectives []SLOObjective `yaml:"objectives,omitempty"`
+}
+
+// SLOObjective is one latency objective
+type SLOObjective struct {
+	Name      string        `yaml:"name,omitempty"` // Default e.g. get_under_5ms
+	Operation string        `yaml:"operation"`      // get, write or request
+	Threshold time.Duration `yaml:"threshold"`      // e.g. 5ms
+	Objective float64       `yaml:"objective"`      // Percentage within the threshold, e.g. 99.9
+}
+
+// Middleware lists the built-in middlewares every request passes through,
+// outermost first: request_id, logger, recoverer, alerts, slo, cors and
+// compress. Leaving Order empty keeps the default stack, which is all but
+// compress.
+type Middleware struct {
+	Order            []string `yaml:"order,omitempty"`
+	CompressionLevel int      `yaml:"compression_level,omitempty"` // Gzip level 1-9 for compress (default 5)
 }
 
 // Security contains security-related configuration
 type Security struct {
-	SystemKey     string `yaml:"system_key"`
-	SystemAPIKey  string `yaml:"system_api_key"`
-	ClientAPIKey  string `yaml:"client_api_key"`
+	SystemKey     string `yaml:"system_key,omitempty"`
+	SystemAPIKey  string `yaml:"system_api_key,omitempty"`
+	ClientAPIKey  string `yaml:"client_api_key,omitempty"`
 	MaxRecordSize int    `yaml:"max_record_size"`
+
+	// ViewerAPIKey, when set, can only browse data with GET requests, and
+	// sees values cut to ViewerMaxValueBytes (default 1024)
+	ViewerAPIKey        string `yaml:"viewer_api_key,omitempty"`
+	ViewerMaxValueBytes int    `yaml:"viewer_max_value_bytes,omitempty"`
 }
 
+// Secrets is the layout of a secrets file
+type Secrets struct {
+	SystemKey    string `yaml:"system_key"`
+	SystemAPIKey string `yaml:"system_api_key"`
+	ClientAPIKey string `yaml:"client_api_key"`
+	ViewerAPIKey string `yaml:"viewer_api_key,omitempty"`
+}
+
+// DefaultSecretsFile is the secrets file name used when splitting secrets
+// out of a config without naming a file
+const DefaultSecretsFile = "secrets.yaml"
+
 // Logging contains logging configuration
 type Logging struct {
 	Level string `yaml:"level"`
+
+	// SlowWriteThreshold, e.g. 50ms, logs every write that takes longer
+	// with the time it spent in each phase
+	SlowWriteThreshold time.Duration `yaml:"slow_write_threshold,omitempty"`
 }
 
 // DefaultConfig returns a default configuration
@@ -50,6 +187,9 @@ func DefaultConfig() *Config {
 		Logging: Logging{
 			Level: "info",
 		},
+		Startup: Startup{
+			IndexLoad: "eager",
+		},
 	}
 }
 
@@ -78,10 +218,55 @@ func LoadConfig(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
+	if config.SecretsFile != "" {
+		if err := loadSecrets(&config, SecretsPath(&config, configPath)); err != nil {
+			return nil, err
+		}
+	}
+
 	return &config, nil
 }
 
-// SaveConfig saves the configuration to the specified path with secure permissions
+// SecretsPath returns the absolute location of a config's secrets file
+func SecretsPath(config *Config, configPath string) string {
+	if config.SecretsFile == "" || filepath.IsAbs(config.SecretsFile) {
+		return config.SecretsFile
+	}
+	return filepath.Join(filepath.Dir(configPath), config.SecretsFile)
+}
+
+// loadSecrets fills the config's keys from its secrets file, refusing a file
+// that other users could read
+func loadSecrets(config *Config, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read secrets file: %w", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+		return fmt.Errorf("secrets file %s is accessible by other users (mode %o); run chmod 600 on it",
+			path, info.Mode().Perm())
+	}
+
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return fmt.Errorf("failed to read secrets file: %w", err)
+	}
+	var secrets Secrets
+	if err := yaml.Unmarshal(data, &secrets); err != nil {
+		return fmt.Errorf("failed to parse secrets file: %w", err)
+	}
+
+	config.Security.SystemKey = secrets.SystemKey
+	config.Security.SystemAPIKey = secrets.SystemAPIKey
+	config.Security.ClientAPIKey = secrets.ClientAPIKey
+	config.Security.ViewerAPIKey = secrets.ViewerAPIKey
+	return nil
+}
+
+// SaveConfig saves the configuration to the specified path with secure
+// permissions. When the config names a secrets file, the keys are written
+// there and left out of the main file. Each file is replaced atomically, so
+// a crash leaves either the old or the new contents, never a mix.
 func SaveConfig(config *Config, configPath string) error {
 	// Ensure config directory exists
 	configDir := filepath.Dir(configPath)
@@ -89,19 +274,90 @@ func SaveConfig(config *Config, configPath string) error {
 		return fmt.Errorf("failed to create config directory: %w", err)
 	}
 
-	data, err := yaml.Marshal(config)
+	main := *config
+	if config.SecretsFile != "" {
+		secrets := Secrets{
+			SystemKey:    config.Security.SystemKey,
+			SystemAPIKey: config.Security.SystemAPIKey,
+			ClientAPIKey: config.Security.ClientAPIKey,
+			ViewerAPIKey: config.Security.ViewerAPIKey,
+		}
+		data, err := yaml.Marshal(secrets)
+		if err != nil {
+			return fmt.Errorf("failed to marshal secrets: %w", err)
+		}
+		secretsPath := SecretsPath(config, configPath)
+		if err := os.MkdirAll(filepath.Dir(secretsPath), 0700); err != nil {
+			return fmt.Errorf("failed to create secrets directory: %w", err)
+		}
+		if err := writeFileAtomic(secretsPath, data, 0600); err != nil {
+			return fmt.Errorf("failed to write secrets file: %w", err)
+		}
+
+		main.Security.SystemKey = ""
+		main.Security.SystemAPIKey = ""
+		main.Security.ClientAPIKey = ""
+		main.Security.ViewerAPIKey = ""
+	}
+
+	data, err := yaml.Marshal(&main)
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
 
 	// Write with secure permissions (0600)
-	if err := os.WriteFile(configPath, data, 0600); err != nil {
+	if err := writeFileAtomic(configPath, data, 0600); err != nil {
 		return fmt.Errorf("failed to write config file: %w", err)
 	}
 
 	return nil
 }
 
+// writeFileAtomic writes data to a temp file beside path, fsyncs it and
+// renames it over path, then fsyncs the directory so the rename is durable
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmpPath)
+	}
+
+	if err := tmp.Chmod(perm); err != nil {
+		cleanup()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		cleanup()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		cleanup()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil && runtime.GOOS != "windows" {
+		return err
+	}
+	return nil
+}
+
 // GenerateSecureKey generates a cryptographically secure random key
 func GenerateSecureKey(length int) (string, error) {
 	bytes := make([]byte, length)
@@ -113,10 +369,17 @@ func GenerateSecureKey(length int) (string, error) {
 
 // BootstrapConfig creates a new configuration with generated keys if it doesn't exist
 func BootstrapConfig(configPath string, dataDir string) (*Config, error) {
+	return BootstrapConfigWithSecrets(configPath, dataDir, "")
+}
+
+// BootstrapConfigWithSecrets is BootstrapConfig with the generated keys kept
+// in secretsFile instead of the main config, unless secretsFile is empty
+func BootstrapConfigWithSecrets(configPath, dataDir, secretsFile string) (*Config, error) {
 	config := DefaultConfig()
 	if dataDir != "" {
 		config.DataDir = dataDir
 	}
+	config.SecretsFile = secretsFile
 
 	// Generate secure keys
 	systemKey, err := GenerateSecureKey(32) // 256 bits
@@ -125,17 +388,9 @@ func BootstrapConfig(configPath string, dataDir string) (*Config, error) {
 	}
 	config.Security.SystemKey = systemKey
 
-	systemAPIKey, err := GenerateSecureKey(32)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate system API key: %w", err)
+	if err := RotateAPIKeys(config, true, true); err != nil {
+		return nil, err
 	}
-	config.Security.SystemAPIKey = systemAPIKey
-
-	clientAPIKey, err := GenerateSecureKey(32)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate client API key: %w", err)
-	}
-	config.Security.ClientAPIKey = clientAPIKey
 
 	// Save the configuration
 	if err := SaveConfig(config, configPath); err != nil {
@@ -145,6 +400,27 @@ func BootstrapConfig(configPath string, dataDir string) (*Config, error) {
 	return config, nil
 }
 
+// RotateAPIKeys replaces the client and/or system API keys with new random
+// keys. The system key is not rotated here: it encrypts the system store,
+// so changing it would make existing system data unreadable.
+func RotateAPIKeys(config *Config, client, system bool) error {
+	if system {
+		key, err := GenerateSecureKey(32)
+		if err != nil {
+			return fmt.Errorf("failed to generate system API key: %w", err)
+		}
+		config.Security.SystemAPIKey = key
+	}
+	if client {
+		key, err := GenerateSecureKey(32)
+		if err != nil {
+			return fmt.Errorf("failed to generate client API key: %w", err)
+		}
+		config.Security.ClientAPIKey = key
+	}
+	return nil
+}
+
 // GetDefaultConfigPath returns the default configuration path for the current platform
 func GetDefaultConfigPath() string {
 	// Use OS-specific default locations
@@ -222,3 +222,53 @@ func TestSaveConfigErrorHandling(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to create config directory")
 }
+
+func TestSaveConfigWithSecretsFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	config, err := BootstrapConfigWithSecrets(configPath, "/data", DefaultSecretsFile)
+	require.NoError(t, err)
+
+	// The main file holds no keys
+	data, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), config.Security.SystemKey)
+	assert.NotContains(t, string(data), config.Security.ClientAPIKey)
+	assert.Contains(t, string(data), "secrets_file: secrets.yaml")
+
+	secretsPath := filepath.Join(tmpDir, DefaultSecretsFile)
+	info, err := os.Stat(secretsPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// Loading merges the secrets back in
+	loaded, err := LoadConfig(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, config, loaded)
+
+	// Atomic writes leave no temp files behind
+	entries, err := os.ReadDir(tmpDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	// A secrets file readable by others is refused
+	require.NoError(t, os.Chmod(secretsPath, 0644))
+	_, err = LoadConfig(configPath)
+	assert.ErrorContains(t, err, "accessible by other users")
+}
+
+func TestRotateAPIKeys(t *testing.T) {
+	config := DefaultConfig()
+	config.Security.SystemKey = "system"
+
+	require.NoError(t, RotateAPIKeys(config, true, false))
+	assert.NotEqual(t, "auto", config.Security.ClientAPIKey)
+	assert.Equal(t, "auto", config.Security.SystemAPIKey)
+	assert.Equal(t, "system", config.Security.SystemKey)
+
+	client := config.Security.ClientAPIKey
+	require.NoError(t, RotateAPIKeys(config, true, true))
+	assert.NotEqual(t, client, config.Security.ClientAPIKey)
+	assert.NotEqual(t, "auto", config.Security.SystemAPIKey)
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "freyja", "profiles.yaml")
+
+	// A missing file has no profiles
+	profiles, err := LoadProfiles(path)
+	require.NoError(t, err)
+	assert.Empty(t, profiles.Names())
+
+	require.NoError(t, profiles.Set("prod", Profile{URL: "https://db1.example.com:8080", APIKey: "secret"}))
+	require.NoError(t, profiles.Set("local", Profile{URL: "http://localhost:8080"}))
+	require.NoError(t, profiles.Use("prod"))
+	require.NoError(t, profiles.Save(path))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	loaded, err := LoadProfiles(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"local", "prod"}, loaded.Names())
+	assert.Equal(t, "prod", loaded.Current)
+	prod, err := loaded.Get("prod")
+	require.NoError(t, err)
+	assert.Equal(t, "secret", prod.APIKey)
+
+	_, err = loaded.Get("missing")
+	assert.ErrorIs(t, err, ErrProfileNotFound)
+	assert.ErrorIs(t, loaded.Use("missing"), ErrProfileNotFound)
+	assert.ErrorIs(t, loaded.Remove("missing"), ErrProfileNotFound)
+
+	// Removing the current profile stops using it
+	require.NoError(t, loaded.Remove("prod"))
+	assert.Empty(t, loaded.Current)
+	assert.Equal(t, []string{"local"}, loaded.Names())
+}
+
+func TestProfileValidate(t *testing.T) {
+	profiles := &Profiles{}
+	assert.Error(t, profiles.Set("bad name", Profile{URL: "http://localhost:8080"}))
+	assert.Error(t, profiles.Set("prod", Profile{URL: "localhost:8080"}))
+	assert.Error(t, profiles.Set("prod", Profile{URL: "ftp://localhost"}))
+	assert.Error(t, profiles.Set("prod", Profile{
+		URL: "https://localhost:8080",
+		TLS: ProfileTLS{CertFile: "client.pem"},
+	}))
+	assert.Empty(t, profiles.Names())
+}
+
+func TestProfileTLSConfig(t *testing.T) {
+	config, err := Profile{URL: "https://localhost"}.TLSConfig()
+	require.NoError(t, err)
+	assert.Nil(t, config)
+
+	config, err = Profile{URL: "https://localhost", TLS: ProfileTLS{InsecureSkipVerify: true}}.TLSConfig()
+	require.NoError(t, err)
+	assert.True(t, config.InsecureSkipVerify)
+
+	_, err = Profile{URL: "https://localhost", TLS: ProfileTLS{CAFile: filepath.Join(t.TempDir(), "missing.pem")}}.TLSConfig()
+	assert.Error(t, err)
+
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(notPEM, []byte("not a certificate"), 0600))
+	_, err = Profile{URL: "https://localhost", TLS: ProfileTLS{CAFile: notPEM}}.TLSConfig()
+	assert.ErrorContains(t, err, "no certificates")
+}
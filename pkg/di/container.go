@@ -2,20 +2,34 @@
 package di
 
 import (
-	"github.com/ssargent/freyjadb/pkg/api" //nolint:depguard
+	"log/slog"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/api"   //nolint:depguard
+	"github.com/ssargent/freyjadb/pkg/store" //nolint:depguard
 )
 
 // Container holds all the dependencies for the application
 type Container struct {
 	systemServiceFactory api.SystemServiceFactory
-	serverFactory        api.ServerFactory
+	serverFactory        api.ServerFactory // nil until overridden; built from the registrations below
+	store                api.IKVStore
+	stores               *store.StoreManager
+	alerts               *api.AlertMonitor
+	slo                  *api.SLOTracker
+	systemService        api.SystemManager
+	metrics              api.MetricsRecorder
+	logger               *slog.Logger
+	shutdownGracePeriod  time.Duration
+	middleware           api.MiddlewareConfig
+	viewerKey            string
+	viewerMaxValueBytes  int
 }
 
 // NewContainer creates a new dependency injection container
 func NewContainer() *Container {
 	return &Container{
 		systemServiceFactory: api.NewSystemServiceFactory(),
-		serverFactory:        api.NewServerFactory(),
 	}
 }
 
@@ -24,12 +38,136 @@ func (c *Container) GetSystemServiceFactory() api.SystemServiceFactory {
 	return c.systemServiceFactory
 }
 
-// GetServerFactory returns the server factory
+// GetServerFactory returns the server factory. Unless overridden, its
+// servers use the system service, metrics and logger registered here.
 func (c *Container) GetServerFactory() api.ServerFactory {
-	return c.serverFactory
+	if c.serverFactory != nil {
+		return c.serverFactory
+	}
+	return &api.DefaultServerFactory{
+		Dependencies:        c.Dependencies(),
+		ShutdownGracePeriod: c.shutdownGracePeriod,
+		Middleware:          c.middleware,
+		ViewerKey:           c.viewerKey,
+		ViewerMaxValueBytes: c.viewerMaxValueBytes,
+	}
 }
 
 // SetSystemServiceFactory allows overriding the system service factory (for testing)
 func (c *Container) SetSystemServiceFactory(factory api.SystemServiceFactory) {
 	c.systemServiceFactory = factory
 }
+
+// SetServerFactory allows overriding the server factory (for testing)
+func (c *Container) SetServerFactory(factory api.ServerFactory) {
+	c.serverFactory = factory
+}
+
+// GetStore returns the registered store, or nil if the command should use
+// the store it opened from the data directory
+func (c *Container) GetStore() api.IKVStore {
+	return c.store
+}
+
+// SetStore registers the store servers are started with, such as an
+// api.MemoryStore or a client for a remote store
+func (c *Container) SetStore(store api.IKVStore) {
+	c.store = store
+}
+
+// GetStoreManager returns the registered store manager, or nil
+func (c *Container) GetStoreManager() *store.StoreManager {
+	return c.stores
+}
+
+// SetStoreManager registers the store manager servers route namespaces
+// through and take the system store from
+func (c *Container) SetStoreManager(stores *store.StoreManager) {
+	c.stores = stores
+}
+
+// GetAlertMonitor returns the registered alert monitor, or nil
+func (c *Container) GetAlertMonitor() *api.AlertMonitor {
+	return c.alerts
+}
+
+// SetAlertMonitor registers the monitor servers check soft limits with
+func (c *Container) SetAlertMonitor(alerts *api.AlertMonitor) {
+	c.alerts = alerts
+}
+
+// GetSLOTracker returns the registered SLO tracker, or nil
+func (c *Container) GetSLOTracker() *api.SLOTracker {
+	return c.slo
+}
+
+// SetSLOTracker registers the tracker servers judge latency objectives with
+func (c *Container) SetSLOTracker(slo *api.SLOTracker) {
+	c.slo = slo
+}
+
+// SetSystemService registers the system service servers use instead of
+// opening one from the data directory
+func (c *Container) SetSystemService(service api.SystemManager) {
+	c.systemService = service
+}
+
+// GetMetrics returns the registered metrics recorder, or the process-wide
+// Prometheus metrics if none is registered
+func (c *Container) GetMetrics() api.MetricsRecorder {
+	if c.metrics != nil {
+		return c.metrics
+	}
+	return api.DefaultMetrics()
+}
+
+// SetMetrics registers the metrics recorder, e.g. api.NopMetrics{} in tests
+func (c *Container) SetMetrics(metrics api.MetricsRecorder) {
+	c.metrics = metrics
+}
+
+// GetLogger returns the registered logger, or slog.Default()
+func (c *Container) GetLogger() *slog.Logger {
+	if c.logger != nil {
+		return c.logger
+	}
+	return slog.Default()
+}
+
+// SetLogger registers the logger; any slog.Handler can back it
+func (c *Container) SetLogger(logger *slog.Logger) {
+	c.logger = logger
+}
+
+// SetShutdownGracePeriod sets how long servers wait for in-flight requests
+// when they are stopped; zero uses api.DefaultShutdownGracePeriod
+func (c *Container) SetShutdownGracePeriod(grace time.Duration) {
+	c.shutdownGracePeriod = grace
+}
+
+// SetMiddleware sets the middleware stack of servers; the zero value
+// keeps api.DefaultMiddlewareOrder
+func (c *Container) SetMiddleware(middleware api.MiddlewareConfig) {
+	c.middleware = middleware
+}
+
+// SetViewerKey sets the browse-only API key of servers and how much of each
+// value it sees; zero uses api.DefaultViewerMaxValueBytes
+func (c *Container) SetViewerKey(key string, maxValueBytes int) {
+	c.viewerKey = key
+	c.viewerMaxValueBytes = maxValueBytes
+}
+
+// Dependencies returns the registrations as server dependencies. Metrics
+// and the logger are left nil when unregistered, so the server picks its
+// defaults.
+func (c *Container) Dependencies() api.Dependencies {
+	return api.Dependencies{
+		SystemService: c.systemService,
+		Metrics:       c.metrics,
+		Logger:        c.logger,
+		Stores:        c.stores,
+		Alerts:        c.alerts,
+		SLO:           c.slo,
+	}
+}
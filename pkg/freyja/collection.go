@@ -0,0 +1,333 @@
+// Package freyja is a typed convenience layer over an embedded store. A
+// collection holds JSON documents of one Go type under a key prefix and
+// keeps secondary indexes, declared with struct tags, in step with its
+// writes:
+//
+//	type User struct {
+//		ID   string `json:"id"`
+//		City string `json:"city" freyja:"index"`
+//		Age  int    `json:"age" freyja:"index"`
+//	}
+//
+//	users, err := freyja.Collection[User](kv, "users:")
+//	err = users.Put("1", User{ID: "1", City: "Oslo", Age: 30})
+//	adults, err := users.Query(ctx, "age", ">=", 18)
+//
+// When the document type changes, register migrations with WithMigration.
+// Older documents are upgraded as they are read and stored upgraded on
+// their next write, or all at once by Upgrade.
+package freyja
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync/atomic"
+
+	"github.com/ssargent/freyjadb/pkg/index"
+	"github.com/ssargent/freyjadb/pkg/query"
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// IndexOrder is the B+tree order of collection indexes
+const IndexOrder = 32
+
+// tagName is the struct tag that declares indexed fields
+const tagName = "freyja"
+
+// TypedCollection stores values of T as JSON documents under a key prefix.
+// Documents are written through the collection so its indexes stay
+// current; writes made to the same keys directly through the store are not
+// indexed until the collection is created again.
+type TypedCollection[T any] struct {
+	kv      *store.KVStore
+	prefix  string
+	engine  *query.SimpleQueryEngine
+	indexed map[string]bool // JSON names of the indexed fields
+	fields  []string        // The same, in declaration order
+
+	migrations  []Migration // Migration to version i+1 at i
+	schemaStore *store.KVStore
+	migrated    atomic.Int64 // Schema version every stored document has reached
+}
+
+// Collection opens the collection of T documents under prefix in an open
+// store. T must be a struct; its fields tagged `freyja:"index"` are indexed
+// under their JSON names and must hold strings or numbers. The indexes are
+// built from the documents already stored, which reads every one of them.
+func Collection[T any](kv *store.KVStore, prefix string, opts ...Option) (*TypedCollection[T], error) {
+	if prefix == "" {
+		return nil, fmt.Errorf("collection prefix cannot be empty")
+	}
+	fields, err := indexedFields(reflect.TypeFor[T]())
+	if err != nil {
+		return nil, err
+	}
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	migrations, err := migrationList(o.migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &TypedCollection[T]{
+		kv:          kv,
+		prefix:      prefix,
+		engine:      query.NewSimpleQueryEngine(index.NewIndexManager(IndexOrder), kv),
+		indexed:     make(map[string]bool, len(fields)),
+		fields:      fields,
+		migrations:  migrations,
+		schemaStore: o.schemaStore,
+	}
+	if c.schemaStore == nil {
+		c.schemaStore = kv
+	}
+	if err := c.loadSchema(); err != nil {
+		return nil, err
+	}
+	for _, field := range fields {
+		c.indexed[field] = true
+		c.engine.SetIndexedPrefix(field, []byte(prefix))
+		if _, err := c.engine.RebuildIndex(context.Background(), field, nil); err != nil {
+			return nil, fmt.Errorf("failed to build index on %s: %w", field, err)
+		}
+	}
+	return c, nil
+}
+
+// indexedFields returns the JSON names of the fields of t tagged for
+// indexing
+func indexedFields(t reflect.Type) ([]string, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("collection type %s is not a struct", t)
+	}
+
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get(tagName) != "index" {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			return nil, fmt.Errorf("indexed field %s.%s is not serialized to JSON", t, field.Name)
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if name == VersionField {
+			return nil, fmt.Errorf("indexed field %s.%s uses the reserved name %s", t, field.Name, VersionField)
+		}
+		switch field.Type.Kind() {
+		case reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+		default:
+			return nil, fmt.Errorf("indexed field %s.%s is a %s; only strings and numbers can be indexed",
+				t, field.Name, field.Type)
+		}
+		fields = append(fields, name)
+	}
+	return fields, nil
+}
+
+// Indexes returns the JSON names of the indexed fields
+func (c *TypedCollection[T]) Indexes() []string {
+	return append([]string(nil), c.fields...)
+}
+
+// key returns the store key of a document
+func (c *TypedCollection[T]) key(id string) []byte {
+	return []byte(c.prefix + id)
+}
+
+// Put stores doc under id, replacing any document there, and updates the
+// indexes
+func (c *TypedCollection[T]) Put(id string, doc T) error {
+	value, err := json.Marshal(doc)
+	if err == nil {
+		value, err = c.stamp(value)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode document %s: %w", id, err)
+	}
+	return c.write(id, value)
+}
+
+// Delete removes the document under id and its index entries. Deleting a
+// missing document does nothing.
+func (c *TypedCollection[T]) Delete(id string) error {
+	return c.write(id, nil)
+}
+
+// write replaces the document under id with value, nil to delete it
+func (c *TypedCollection[T]) write(id string, value []byte) error {
+	if id == "" {
+		return store.ErrInvalidKey
+	}
+	_, err := c.update(c.key(id), func([]byte) ([]byte, error) { return value, nil })
+	return err
+}
+
+// update applies fn to the document stored under key and updates the
+// indexes, reporting whether anything was written; fn returns errUnchanged
+// to leave the document alone. The key lock keeps concurrent writers of the
+// same document from applying their index updates out of order.
+func (c *TypedCollection[T]) update(key []byte, fn store.UpdateFunc) (bool, error) {
+	lock, err := c.kv.LockKey(key)
+	if err != nil {
+		return false, err
+	}
+	defer lock.Unlock()
+
+	var old, value []byte
+	err = c.kv.Update(key, func(current []byte) ([]byte, error) {
+		updated, err := fn(current)
+		old, value = current, updated
+		return updated, err
+	})
+	if errors.Is(err, errUnchanged) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, field := range c.fields {
+		if err := c.engine.IndexRecord(field, key, old, value, nil); err != nil {
+			return true, fmt.Errorf("failed to index %s of document %s: %w", field, key, err)
+		}
+	}
+	return true, nil
+}
+
+// Get returns the document under id, or store.ErrKeyNotFound. A document
+// stored at an older schema version is migrated, but only stored upgraded
+// when it is next written.
+func (c *TypedCollection[T]) Get(id string) (T, error) {
+	value, err := c.kv.Get(c.key(id))
+	if err != nil {
+		var doc T
+		return doc, err
+	}
+	return c.unmarshal(c.key(id), value)
+}
+
+// unmarshal decodes a stored document, migrating it if needed
+func (c *TypedCollection[T]) unmarshal(key, value []byte) (T, error) {
+	var doc T
+	value, _, err := c.upgrade(value)
+	if err == nil {
+		err = json.Unmarshal(value, &doc)
+	}
+	if err != nil {
+		return doc, fmt.Errorf("failed to decode document %s: %w", key, err)
+	}
+	return doc, nil
+}
+
+// Query returns the documents whose indexed field compares to value with
+// op: "=", ">", ">=", "<" or "<=". Results are ordered by the field.
+func (c *TypedCollection[T]) Query(ctx context.Context, field, op string, value any) ([]T, error) {
+	if err := c.checkIndexed(field); err != nil {
+		return nil, err
+	}
+	normalized, err := normalize(value)
+	if err != nil {
+		return nil, err
+	}
+	it, err := c.engine.ExecuteQuery(ctx, c.prefix, query.FieldQuery{Field: field, Operator: op, Value: normalized}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.decode(it)
+}
+
+// Between returns the documents whose indexed field lies within [low, high]
+func (c *TypedCollection[T]) Between(ctx context.Context, field string, low, high any) ([]T, error) {
+	if err := c.checkIndexed(field); err != nil {
+		return nil, err
+	}
+	start, err := normalize(low)
+	if err != nil {
+		return nil, err
+	}
+	end, err := normalize(high)
+	if err != nil {
+		return nil, err
+	}
+	it, err := c.engine.ExecuteRangeQuery(ctx, c.prefix,
+		query.FieldQuery{Field: field, Operator: ">=", Value: start},
+		query.FieldQuery{Field: field, Operator: "<=", Value: end}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.decode(it)
+}
+
+// Count returns how many documents match, answered from the index alone
+func (c *TypedCollection[T]) Count(ctx context.Context, field, op string, value any) (int, error) {
+	if err := c.checkIndexed(field); err != nil {
+		return 0, err
+	}
+	normalized, err := normalize(value)
+	if err != nil {
+		return 0, err
+	}
+	return c.engine.Count(ctx, c.prefix, query.FieldQuery{Field: field, Operator: op, Value: normalized})
+}
+
+// checkIndexed rejects queries on fields without an index
+func (c *TypedCollection[T]) checkIndexed(field string) error {
+	if !c.indexed[field] {
+		return fmt.Errorf("field %s is not indexed; tag it `%s:\"index\"`", field, tagName)
+	}
+	return nil
+}
+
+// decode reads every result of a query into documents
+func (c *TypedCollection[T]) decode(it query.QueryIterator) ([]T, error) {
+	defer it.Close()
+
+	var docs []T
+	for it.Next() {
+		result := it.Result()
+		doc, err := c.unmarshal(result.Key, result.Value)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// normalize converts a query value to the form the index stores values
+// in: strings as is and numbers as int64 when integral, float64 otherwise
+func normalize(value any) (any, error) {
+	v := reflect.ValueOf(value)
+	var f float64
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > math.MaxInt64 {
+			return float64(v.Uint()), nil
+		}
+		return int64(v.Uint()), nil //nolint:gosec // Bounded above
+	case reflect.Float32, reflect.Float64:
+		f = v.Float()
+	default:
+		return nil, fmt.Errorf("cannot query on a %T; use a string or a number", value)
+	}
+	if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+		return int64(f), nil
+	}
+	return f, nil
+}
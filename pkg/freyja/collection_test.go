@@ -0,0 +1,168 @@
+package freyja
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+type user struct {
+	ID    string  `json:"id"`
+	Name  string  `json:"name"`
+	City  string  `json:"city" freyja:"index"`
+	Age   int     `json:"age,omitempty" freyja:"index"`
+	Score float64 `json:"score"`
+}
+
+func openStore(t *testing.T, dir string) *store.KVStore {
+	t.Helper()
+	kv, err := store.NewKVStore(store.KVStoreConfig{DataDir: dir})
+	if err != nil {
+		t.Fatalf("NewKVStore failed: %v", err)
+	}
+	if _, err := kv.Open(); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	return kv
+}
+
+func names(users []user) []string {
+	var out []string
+	for _, u := range users {
+		out = append(out, u.Name)
+	}
+	return out
+}
+
+func TestCollection_PutGetQuery(t *testing.T) {
+	kv := openStore(t, t.TempDir())
+	defer kv.Close()
+
+	users, err := Collection[user](kv, "users:")
+	if err != nil {
+		t.Fatalf("Collection failed: %v", err)
+	}
+	if got := users.Indexes(); !reflect.DeepEqual(got, []string{"city", "age"}) {
+		t.Errorf("Indexes() = %v, want [city age]", got)
+	}
+
+	for _, u := range []user{
+		{ID: "1", Name: "alice", City: "Oslo", Age: 25},
+		{ID: "2", Name: "bob", City: "Bergen", Age: 30},
+		{ID: "3", Name: "carol", City: "Oslo", Age: 35},
+	} {
+		if err := users.Put(u.ID, u); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	got, err := users.Get("2")
+	if err != nil || got.Name != "bob" {
+		t.Errorf("Get(2) = %+v, %v", got, err)
+	}
+	if _, err := users.Get("9"); err != store.ErrKeyNotFound {
+		t.Errorf("Get(9) error = %v, want ErrKeyNotFound", err)
+	}
+
+	ctx := context.Background()
+	oslo, err := users.Query(ctx, "city", "=", "Oslo")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if got := names(oslo); !reflect.DeepEqual(got, []string{"alice", "carol"}) {
+		t.Errorf("city = Oslo: %v, want [alice carol]", got)
+	}
+
+	older, err := users.Query(ctx, "age", ">=", 30.0)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if got := names(older); !reflect.DeepEqual(got, []string{"bob", "carol"}) {
+		t.Errorf("age >= 30: %v, want [bob carol]", got)
+	}
+
+	between, err := users.Between(ctx, "age", 26, uint(35))
+	if err != nil {
+		t.Fatalf("Between failed: %v", err)
+	}
+	if got := names(between); !reflect.DeepEqual(got, []string{"bob", "carol"}) {
+		t.Errorf("age in [26, 35]: %v, want [bob carol]", got)
+	}
+
+	// Moving a document updates its index entries
+	if err := users.Put("1", user{ID: "1", Name: "alice", City: "Bergen", Age: 25}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := users.Delete("3"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if n, err := users.Count(ctx, "city", "=", "Oslo"); err != nil || n != 0 {
+		t.Errorf("Count(city = Oslo) = %d, %v, want 0", n, err)
+	}
+	if n, err := users.Count(ctx, "city", "=", "Bergen"); err != nil || n != 2 {
+		t.Errorf("Count(city = Bergen) = %d, %v, want 2", n, err)
+	}
+
+	if _, err := users.Query(ctx, "name", "=", "bob"); err == nil {
+		t.Error("expected an error querying a field without an index")
+	}
+	if _, err := users.Query(ctx, "age", "=", []int{1}); err == nil {
+		t.Error("expected an error querying with a slice")
+	}
+}
+
+func TestCollection_IndexesExistingDocuments(t *testing.T) {
+	dir := t.TempDir()
+	kv := openStore(t, dir)
+	users, err := Collection[user](kv, "users:")
+	if err != nil {
+		t.Fatalf("Collection failed: %v", err)
+	}
+	if err := users.Put("1", user{ID: "1", Name: "alice", City: "Oslo"}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := kv.Put([]byte("orders:1"), []byte(`{"city":"Oslo"}`)); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	kv.Close()
+
+	kv = openStore(t, dir)
+	defer kv.Close()
+	users, err = Collection[user](kv, "users:")
+	if err != nil {
+		t.Fatalf("Collection failed: %v", err)
+	}
+	oslo, err := users.Query(context.Background(), "city", "=", "Oslo")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if got := names(oslo); !reflect.DeepEqual(got, []string{"alice"}) {
+		t.Errorf("city = Oslo after reopening: %v, want [alice] without the order", got)
+	}
+}
+
+func TestCollection_InvalidTypes(t *testing.T) {
+	kv := openStore(t, t.TempDir())
+	defer kv.Close()
+
+	type tagged struct {
+		Tags []string `json:"tags" freyja:"index"`
+	}
+	if _, err := Collection[tagged](kv, "t:"); err == nil {
+		t.Error("expected an error indexing a slice")
+	}
+	type hidden struct {
+		Secret string `json:"-" freyja:"index"`
+	}
+	if _, err := Collection[hidden](kv, "h:"); err == nil {
+		t.Error("expected an error indexing a field left out of JSON")
+	}
+	if _, err := Collection[string](kv, "s:"); err == nil {
+		t.Error("expected an error for a non-struct type")
+	}
+	if _, err := Collection[user](kv, ""); err == nil {
+		t.Error("expected an error for an empty prefix")
+	}
+}
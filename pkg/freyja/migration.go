@@ -0,0 +1,249 @@
+package freyja
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ssargent/freyjadb/pkg/keys"
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// VersionField is the document field holding the schema version a document
+// was written at. Collections without migrations don't write it, and
+// documents without it are at version 0.
+const VersionField = "_v"
+
+// schemaKeys holds the schema version of each collection, by prefix
+var schemaKeys = keys.Keyspace("config")
+
+// errUnchanged aborts a document update that has nothing to write
+var errUnchanged = errors.New("document unchanged")
+
+// Migration upgrades a document from the previous schema version in place.
+// It sees the document as decoded by encoding/json, so numbers are float64.
+type Migration func(doc map[string]any) error
+
+// Option configures a collection
+type Option func(*options)
+
+type options struct {
+	migrations  map[int]Migration
+	schemaStore *store.KVStore
+}
+
+// WithMigration registers the migration that upgrades documents from
+// version-1 to version. Versions must run from 1 without gaps; the highest
+// is the collection's schema version, which Put writes documents at.
+func WithMigration(version int, fn Migration) Option {
+	return func(o *options) {
+		if o.migrations == nil {
+			o.migrations = make(map[int]Migration)
+		}
+		o.migrations[version] = fn
+	}
+}
+
+// WithSchemaStore keeps the collection's schema version in kv, such as the
+// server's system store, instead of in the collection's own store
+func WithSchemaStore(kv *store.KVStore) Option {
+	return func(o *options) {
+		o.schemaStore = kv
+	}
+}
+
+// SchemaVersion is the schema state of a collection
+type SchemaVersion struct {
+	Version  int `json:"version"`  // Highest registered migration
+	Migrated int `json:"migrated"` // Every stored document is at least at this version
+}
+
+// migrationList orders the registered migrations by version, checking that
+// they run from 1 without gaps
+func migrationList(registered map[int]Migration) ([]Migration, error) {
+	list := make([]Migration, len(registered))
+	for version, fn := range registered {
+		if version < 1 || version > len(registered) {
+			return nil, fmt.Errorf("migration versions must run from 1 to %d without gaps, got %d",
+				len(registered), version)
+		}
+		if fn == nil {
+			return nil, fmt.Errorf("migration to version %d is nil", version)
+		}
+		list[version-1] = fn
+	}
+	return list, nil
+}
+
+// schemaKey is the key of the collection's schema version
+func (c *TypedCollection[T]) schemaKey() []byte {
+	return schemaKeys.Bytes("collection", keys.Escape(c.prefix))
+}
+
+// loadSchema reads the recorded schema version and records the registered
+// one if it is newer. Documents written by a newer schema than the one
+// registered could not be read correctly, so that is an error.
+func (c *TypedCollection[T]) loadSchema() error {
+	var recorded SchemaVersion
+	value, err := c.schemaStore.Get(c.schemaKey())
+	switch {
+	case err == store.ErrKeyNotFound:
+	case err != nil:
+		return fmt.Errorf("failed to read schema version: %w", err)
+	default:
+		if err := json.Unmarshal(value, &recorded); err != nil {
+			return fmt.Errorf("failed to decode schema version: %w", err)
+		}
+	}
+
+	version := len(c.migrations)
+	if recorded.Version > version {
+		return fmt.Errorf("collection %s is at schema version %d but only %d migrations are registered",
+			c.prefix, recorded.Version, version)
+	}
+	c.migrated.Store(int64(recorded.Migrated))
+	if recorded.Version == version {
+		return nil
+	}
+	return c.saveSchema(SchemaVersion{Version: version, Migrated: recorded.Migrated})
+}
+
+// saveSchema records the collection's schema version
+func (c *TypedCollection[T]) saveSchema(schema SchemaVersion) error {
+	value, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+	if err := c.schemaStore.Put(c.schemaKey(), value); err != nil {
+		return fmt.Errorf("failed to record schema version: %w", err)
+	}
+	return nil
+}
+
+// Schema returns the collection's schema version and the version every
+// stored document is known to have reached
+func (c *TypedCollection[T]) Schema() SchemaVersion {
+	return SchemaVersion{Version: len(c.migrations), Migrated: int(c.migrated.Load())}
+}
+
+// stamp adds the schema version to an encoded document
+func (c *TypedCollection[T]) stamp(value []byte) ([]byte, error) {
+	version := len(c.migrations)
+	if version == 0 {
+		return value, nil
+	}
+	if len(value) < 2 || value[0] != '{' {
+		return nil, fmt.Errorf("document does not encode to a JSON object")
+	}
+	stamped := fmt.Appendf(nil, "{%q:%d", VersionField, version)
+	if len(value) > 2 {
+		stamped = append(stamped, ',')
+	}
+	return append(stamped, value[1:]...), nil
+}
+
+// upgrade migrates a stored document to the current schema version,
+// reporting whether it had to
+func (c *TypedCollection[T]) upgrade(value []byte) ([]byte, bool, error) {
+	version, err := c.versionOf(value)
+	if err != nil || version >= len(c.migrations) {
+		return value, false, err
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(value, &doc); err != nil {
+		return nil, false, err
+	}
+	for v := version + 1; v <= len(c.migrations); v++ {
+		if err := c.migrations[v-1](doc); err != nil {
+			return nil, false, fmt.Errorf("migration to version %d failed: %w", v, err)
+		}
+	}
+	doc[VersionField] = len(c.migrations)
+	upgraded, err := json.Marshal(doc)
+	if err != nil {
+		return nil, false, err
+	}
+	return upgraded, true, nil
+}
+
+// versionOf returns the schema version of a stored document. Once every
+// document has been migrated it is current without being decoded.
+func (c *TypedCollection[T]) versionOf(value []byte) (int, error) {
+	if c.migrated.Load() >= int64(len(c.migrations)) {
+		return len(c.migrations), nil
+	}
+	var stamp struct {
+		Version int `json:"_v"`
+	}
+	if err := json.Unmarshal(value, &stamp); err != nil {
+		return 0, err
+	}
+	return stamp.Version, nil
+}
+
+// Upgrade rewrites every document stored at an older schema version and
+// returns how many it rewrote. It runs as background IO, yielding to client
+// reads and writes, so it can be started in a goroutine on a live store.
+// Documents written concurrently are left alone if already current. Once
+// it completes, reads no longer check document versions. Migrations that
+// change indexed fields only show in queries after Upgrade.
+func (c *TypedCollection[T]) Upgrade(ctx context.Context) (int, error) {
+	version := len(c.migrations)
+	if c.migrated.Load() >= int64(version) {
+		return 0, nil
+	}
+
+	it, err := c.kv.ScanPrefix([]byte(c.prefix))
+	if err != nil {
+		return 0, err
+	}
+	var stale [][]byte
+	for it.Next() {
+		if err := c.kv.ThrottleBackground(ctx, int64(len(it.Key())+len(it.Value()))); err != nil {
+			it.Close()
+			return 0, err
+		}
+		if v, err := c.versionOf(it.Value()); err != nil || v < version {
+			stale = append(stale, it.Key())
+		}
+	}
+	err = it.Err()
+	it.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	upgraded := 0
+	for _, key := range stale {
+		if err := ctx.Err(); err != nil {
+			return upgraded, err
+		}
+		changed, err := c.update(key, func(old []byte) ([]byte, error) {
+			if old == nil {
+				return nil, errUnchanged // Deleted since the scan
+			}
+			value, changed, err := c.upgrade(old)
+			if err != nil {
+				return nil, err
+			}
+			if !changed {
+				return nil, errUnchanged
+			}
+			return value, nil
+		})
+		if err != nil {
+			return upgraded, fmt.Errorf("failed to upgrade document %s: %w", key, err)
+		}
+		if changed {
+			upgraded++
+		}
+	}
+
+	if err := c.saveSchema(SchemaVersion{Version: version, Migrated: version}); err != nil {
+		return upgraded, err
+	}
+	c.migrated.Store(int64(version))
+	return upgraded, nil
+}
@@ -0,0 +1,124 @@
+package freyja
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// userV0 is user before the town field was renamed to city
+type userV0 struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Town string `json:"town"`
+}
+
+// migrations rename town to city, then give every user a score of 1
+func migrations() []Option {
+	return []Option{
+		WithMigration(2, func(doc map[string]any) error {
+			doc["score"] = 1.0
+			return nil
+		}),
+		WithMigration(1, func(doc map[string]any) error {
+			town, ok := doc["town"].(string)
+			if !ok {
+				return fmt.Errorf("town is %T", doc["town"])
+			}
+			doc["city"] = town
+			delete(doc, "town")
+			return nil
+		}),
+	}
+}
+
+func TestCollection_Migrations(t *testing.T) {
+	dir := t.TempDir()
+	kv := openStore(t, dir)
+	defer kv.Close()
+	system := openStore(t, t.TempDir())
+	defer system.Close()
+
+	old, err := Collection[userV0](kv, "users:")
+	if err != nil {
+		t.Fatalf("Collection failed: %v", err)
+	}
+	for i, town := range []string{"Oslo", "Bergen", "Oslo"} {
+		id := fmt.Sprint(i + 1)
+		if err := old.Put(id, userV0{ID: id, Name: "user" + id, Town: town}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	users, err := Collection[user](kv, "users:", append(migrations(), WithSchemaStore(system))...)
+	if err != nil {
+		t.Fatalf("Collection failed: %v", err)
+	}
+	if got := users.Schema(); got != (SchemaVersion{Version: 2}) {
+		t.Errorf("Schema() = %+v, want version 2, migrated 0", got)
+	}
+	if _, err := system.Get(users.schemaKey()); err != nil {
+		t.Errorf("Expected the schema version in the system store: %v", err)
+	}
+
+	// Reads migrate without writing
+	got, err := users.Get("1")
+	if err != nil || got.City != "Oslo" || got.Score != 1 {
+		t.Errorf("Get(1) = %+v, %v, want city Oslo, score 1", got, err)
+	}
+	if raw, _ := kv.Get([]byte("users:1")); strings.Contains(string(raw), VersionField) {
+		t.Errorf("Expected reads to leave the stored document alone, got %s", raw)
+	}
+
+	// Writes store the current version
+	if err := users.Put("2", user{ID: "2", Name: "user2", City: "Bergen", Score: 5}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	raw, _ := kv.Get([]byte("users:2"))
+	var stamped map[string]any
+	if err := json.Unmarshal(raw, &stamped); err != nil || stamped[VersionField] != 2.0 {
+		t.Errorf("Expected a version 2 document, got %s, %v", raw, err)
+	}
+
+	upgraded, err := users.Upgrade(context.Background())
+	if err != nil || upgraded != 2 {
+		t.Fatalf("Upgrade() = %d, %v, want the 2 old documents", upgraded, err)
+	}
+	if got := users.Schema(); got != (SchemaVersion{Version: 2, Migrated: 2}) {
+		t.Errorf("Schema() after Upgrade = %+v, want version 2, migrated 2", got)
+	}
+	oslo, err := users.Query(context.Background(), "city", "=", "Oslo")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if got := names(oslo); !reflect.DeepEqual(got, []string{"user1", "user3"}) {
+		t.Errorf("city = Oslo after Upgrade: %v, want [user1 user3]", got)
+	}
+	if got, _ := users.Get("2"); got.Score != 5 {
+		t.Errorf("Expected Upgrade to leave current documents alone, got score %v", got.Score)
+	}
+	if n, err := users.Upgrade(context.Background()); err != nil || n != 0 {
+		t.Errorf("Second Upgrade() = %d, %v, want nothing to do", n, err)
+	}
+
+	// A collection opened with fewer migrations than recorded can't read
+	if _, err := Collection[user](kv, "users:", WithSchemaStore(system)); err == nil {
+		t.Error("Expected an error opening a collection behind its schema version")
+	}
+}
+
+func TestCollection_InvalidMigrations(t *testing.T) {
+	kv := openStore(t, t.TempDir())
+	defer kv.Close()
+
+	noop := func(map[string]any) error { return nil }
+	if _, err := Collection[user](kv, "users:", WithMigration(2, noop)); err == nil {
+		t.Error("Expected an error for a gap in migration versions")
+	}
+	if _, err := Collection[user](kv, "users:", WithMigration(1, nil)); err == nil {
+		t.Error("Expected an error for a nil migration")
+	}
+}
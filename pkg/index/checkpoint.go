@@ -0,0 +1,455 @@
+package index
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultCheckpointLogLimit is the number of logged operations after which a
+// checkpoint folds the operation log into a full snapshot
+const DefaultCheckpointLogLimit = 4096
+
+// legacyValueSize is the size of the value of opInsert records, from when
+// index values were KSUIDs
+const legacyValueSize = 20
+
+// maxOpLogKeySize bounds key, value and stored sizes read from the log so a
+// corrupt length can't trigger a huge allocation
+const maxOpLogKeySize = 1 << 24
+
+// Operation log record kinds
+const (
+	opInsert       byte = 1 // An insert with a legacy 20-byte value; no longer written
+	opDelete       byte = 2
+	opInsertStored byte = 3 // opInsert carrying stored-field copies; no longer written
+	opPut          byte = 4 // An insert with a value of any length
+	opPutStored    byte = 5 // opPut carrying stored-field copies
+	opGeneration   byte = 6 // First record of a log started by a snapshot; the key is its generation
+)
+
+// indexOp is a single index mutation recorded since the last checkpoint
+type indexOp struct {
+	kind   byte
+	key    []byte
+	value  []byte
+	stored []byte // Encoded stored fields of an opPutStored
+}
+
+// hasStored reports whether op's record carries stored fields
+func (op indexOp) hasStored() bool {
+	return op.kind == opPutStored || op.kind == opInsertStored
+}
+
+// hasValue reports whether op's record carries a value
+func (op indexOp) hasValue() bool {
+	return op.kind != opDelete && op.kind != opGeneration
+}
+
+// snapshotPath returns the full snapshot file for an index
+func snapshotPath(dir, fieldName string) string {
+	return filepath.Join(dir, fmt.Sprintf("index_%s.dat", fieldName))
+}
+
+// opLogPath returns the operation log file for an index
+func opLogPath(dir, fieldName string) string {
+	return filepath.Join(dir, fmt.Sprintf("index_%s.oplog", fieldName))
+}
+
+// stagedPath returns the name a snapshot file is written under until the
+// snapshot of generation gen is installed
+func stagedPath(filename string, gen uint64) string {
+	return fmt.Sprintf("%s.g%d", filename, gen)
+}
+
+// Checkpoint persists changes made since the last checkpoint. Pending
+// operations are appended to the index's operation log, so the cost tracks
+// the number of changes rather than the size of the index. Once the log holds
+// more than logLimit operations the index is rewritten as a full snapshot and
+// the log is truncated. Clean indexes are skipped entirely, as are indexes
+// that are unavailable or mid-rebuild, whose files on disk are left alone.
+func (idx *SecondaryIndex) Checkpoint(dir string, logLimit int) error {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	return idx.checkpointInternal(dir, logLimit)
+}
+
+// checkpointInternal persists pending changes (caller must hold the write lock)
+func (idx *SecondaryIndex) checkpointInternal(dir string, logLimit int) error {
+	if idx.state != IndexReady {
+		return nil
+	}
+	if len(idx.pending) == 0 && !idx.needsSnapshot {
+		return nil
+	}
+
+	if logLimit <= 0 {
+		logLimit = DefaultCheckpointLogLimit
+	}
+
+	if idx.needsSnapshot || idx.loggedOps+len(idx.pending) > logLimit {
+		return idx.saveSnapshotInternal(dir)
+	}
+
+	if err := appendOpLog(opLogPath(dir, idx.fieldName), idx.pending); err != nil {
+		return fmt.Errorf("failed to checkpoint index for field %s: %w", idx.fieldName, err)
+	}
+
+	idx.loggedOps += len(idx.pending)
+	idx.pending = nil
+	idx.dir = dir
+	return nil
+}
+
+// saveSnapshotInternal writes a full snapshot and discards the operation log
+// (caller must hold the write lock).
+//
+// Replaying an older log over a newer snapshot is not harmless: the log can
+// re-insert entries the snapshot no longer has. So the snapshot is first
+// staged under names stamped with a new generation, then committed by
+// replacing the log with one holding only that generation, and only then
+// installed. A crash before the commit leaves the old snapshot and log in
+// place and the staged files are dropped on load; a crash after it leaves a
+// log naming the staged generation, which the load installs.
+func (idx *SecondaryIndex) saveSnapshotInternal(dir string) error {
+	gen, err := logGeneration(opLogPath(dir, idx.fieldName))
+	if err != nil {
+		return fmt.Errorf("failed to read index op log: %w", err)
+	}
+	gen++
+
+	if err := idx.stageSnapshotInternal(dir, gen); err != nil {
+		return err
+	}
+	if err := idx.commitSnapshot(dir, gen); err != nil {
+		return err
+	}
+	if err := idx.installSnapshot(dir, gen); err != nil {
+		return err
+	}
+
+	// Once installed, the log and an empty stored-fields file are no longer
+	// needed
+	if len(idx.stored) == 0 {
+		if err := os.Remove(storedPath(dir, idx.fieldName)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove stored fields: %w", err)
+		}
+	}
+	if err := os.Remove(opLogPath(dir, idx.fieldName)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to truncate index op log: %w", err)
+	}
+	if err := syncDir(dir); err != nil {
+		return err
+	}
+
+	idx.loggedOps = 0
+	idx.pending = nil
+	idx.needsSnapshot = false
+	idx.dir = dir
+	return nil
+}
+
+// stageSnapshotInternal writes the tree and stored fields to dir under the
+// staged names of generation gen (caller must hold the write lock). The
+// stored-fields file is written even if empty, so installing it always
+// replaces the previous one.
+func (idx *SecondaryIndex) stageSnapshotInternal(dir string, gen uint64) error {
+	if err := idx.saveStoredInternal(stagedPath(storedPath(dir, idx.fieldName), gen), true); err != nil {
+		return err
+	}
+	if err := idx.tree.Save(stagedPath(snapshotPath(dir, idx.fieldName), gen)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// commitSnapshot replaces the op log with one holding only generation gen,
+// making the staged snapshot of that generation the index's state
+func (idx *SecondaryIndex) commitSnapshot(dir string, gen uint64) error {
+	filename := opLogPath(dir, idx.fieldName)
+	tmp := filename + ".tmp"
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	header := indexOp{kind: opGeneration, key: binary.LittleEndian.AppendUint64(nil, gen)}
+	if err := appendOpLog(tmp, []indexOp{header}); err != nil {
+		return fmt.Errorf("failed to commit index snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return fmt.Errorf("failed to commit index snapshot: %w", err)
+	}
+	return syncDir(dir)
+}
+
+// installSnapshot renames the staged files of generation gen into place.
+// Files already installed by an interrupted earlier attempt are skipped.
+func (idx *SecondaryIndex) installSnapshot(dir string, gen uint64) error {
+	for _, filename := range []string{storedPath(dir, idx.fieldName), snapshotPath(dir, idx.fieldName)} {
+		if err := os.Rename(stagedPath(filename, gen), filename); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to install index snapshot: %w", err)
+		}
+	}
+	return syncDir(dir)
+}
+
+// recoverSnapshotInternal finishes or discards a snapshot interrupted by a
+// crash before it is loaded (caller must hold the write lock): staged files
+// of the generation the log was committed to are installed and any others
+// removed.
+func (idx *SecondaryIndex) recoverSnapshotInternal(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	gen, err := logGeneration(opLogPath(dir, idx.fieldName))
+	if err != nil {
+		return err
+	}
+
+	committed, stale := false, false
+	for _, entry := range entries {
+		for _, filename := range []string{snapshotPath(dir, idx.fieldName), storedPath(dir, idx.fieldName)} {
+			suffix, ok := strings.CutPrefix(entry.Name(), filepath.Base(filename)+".g")
+			if !ok {
+				continue
+			}
+			if n, err := strconv.ParseUint(suffix, 10, 64); err == nil && gen != 0 && n == gen {
+				committed = true
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			stale = true
+		}
+	}
+
+	if committed {
+		return idx.installSnapshot(dir, gen)
+	}
+	if stale {
+		return syncDir(dir)
+	}
+	return nil
+}
+
+// logGeneration returns the generation of the snapshot that started an op
+// log, 0 if the log is missing or was started by an insert
+func logGeneration(filename string) (uint64, error) {
+	file, err := os.Open(filepath.Clean(filename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer file.Close()
+
+	op, err := readOp(bufio.NewReader(file))
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, errOpLogCorrupt) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if op.kind != opGeneration || len(op.key) != 8 {
+		return 0, nil
+	}
+	return binary.LittleEndian.Uint64(op.key), nil
+}
+
+// syncDir fsyncs a directory so renames and removals in it are durable
+func syncDir(dir string) error {
+	d, err := os.Open(filepath.Clean(dir))
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+	return nil
+}
+
+// Export writes a full snapshot of the index to dir for a backup, leaving
+// its checkpoints where they are: pending changes still go to the next
+// Checkpoint of its own directory. An index that is not ready is skipped.
+func (idx *SecondaryIndex) Export(dir string) error {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	if idx.state != IndexReady {
+		return nil
+	}
+	idx.ensureResidentInternal()
+	if idx.state != IndexReady {
+		return nil
+	}
+	filename := snapshotPath(dir, idx.fieldName)
+	tmp := filename + ".tmp"
+	if err := idx.saveStoredInternal(storedPath(dir, idx.fieldName), false); err != nil {
+		return err
+	}
+	if err := idx.tree.Save(tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return fmt.Errorf("failed to export index snapshot: %w", err)
+	}
+	return syncDir(dir)
+}
+
+// replayOpLog applies the operation log on top of the loaded snapshot
+// (caller must hold the write lock)
+func (idx *SecondaryIndex) replayOpLog(dir string) error {
+	file, err := os.Open(filepath.Clean(opLogPath(dir, idx.fieldName)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		op, err := readOp(reader)
+		if err != nil {
+			// A torn or corrupt tail is the remains of an interrupted
+			// checkpoint; everything before it has been applied
+			if errors.Is(err, io.EOF) || errors.Is(err, errOpLogCorrupt) {
+				return nil
+			}
+			return err
+		}
+
+		switch op.kind {
+		case opPut, opPutStored, opInsert, opInsertStored:
+			idx.tree.Insert(op.key, op.value)
+			idx.setStoredInternal(string(op.key), op.stored)
+		case opDelete:
+			idx.tree.Delete(op.key)
+			idx.setStoredInternal(string(op.key), nil)
+		case opGeneration:
+			continue // Marks the snapshot the log starts from
+		}
+		idx.loggedOps++
+	}
+}
+
+// errOpLogCorrupt reports a partially written or damaged log record
+var errOpLogCorrupt = errors.New("index op log record corrupt")
+
+// appendOpLog appends ops to the log file and fsyncs it.
+// Record format: [CRC32(4)][Kind(1)][KeySize(4)][Key][ValueSize(4)][Value for
+// inserts][StoredSize(4)][Stored for inserts with stored fields]. Records of
+// the legacy opInsert kinds have a 20-byte value without its size instead.
+func appendOpLog(filename string, ops []indexOp) error {
+	file, err := os.OpenFile(filepath.Clean(filename), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	for _, op := range ops {
+		body := make([]byte, 0, 13+len(op.key)+len(op.value)+len(op.stored))
+		body = append(body, op.kind)
+		body = binary.LittleEndian.AppendUint32(body, uint32(len(op.key))) //nolint:gosec // index keys are small
+		body = append(body, op.key...)
+		if op.hasValue() {
+			body = binary.LittleEndian.AppendUint32(body, uint32(len(op.value))) //nolint:gosec // index values are small
+			body = append(body, op.value...)
+		}
+		if op.hasStored() {
+			body = binary.LittleEndian.AppendUint32(body, uint32(len(op.stored))) //nolint:gosec // stored fields are small
+			body = append(body, op.stored...)
+		}
+
+		if err := binary.Write(writer, binary.LittleEndian, crc32.ChecksumIEEE(body)); err != nil {
+			return err
+		}
+		if _, err := writer.Write(body); err != nil {
+			return err
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// readOp decodes the next record from an operation log
+func readOp(reader io.Reader) (indexOp, error) {
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return indexOp{}, errOpLogCorrupt
+		}
+		return indexOp{}, err
+	}
+
+	crc := binary.LittleEndian.Uint32(header[0:4])
+	op := indexOp{kind: header[4]}
+	switch op.kind {
+	case opPut, opPutStored, opInsert, opInsertStored, opDelete, opGeneration:
+	default:
+		return indexOp{}, errOpLogCorrupt
+	}
+
+	// The body after the kind is read field by field, each size bounded
+	// before its field is allocated
+	body := append(make([]byte, 0, 64), header[4:9]...)
+	read := func(size uint32) ([]byte, error) {
+		if size > maxOpLogKeySize {
+			return nil, errOpLogCorrupt
+		}
+		field := make([]byte, size)
+		if _, err := io.ReadFull(reader, field); err != nil {
+			return nil, errOpLogCorrupt
+		}
+		body = append(body, field...)
+		return field, nil
+	}
+	readSized := func() ([]byte, error) {
+		size, err := read(4)
+		if err != nil {
+			return nil, err
+		}
+		return read(binary.LittleEndian.Uint32(size))
+	}
+
+	var err error
+	if op.key, err = read(binary.LittleEndian.Uint32(header[5:9])); err != nil {
+		return indexOp{}, err
+	}
+	switch op.kind {
+	case opPut, opPutStored:
+		op.value, err = readSized()
+	case opInsert, opInsertStored:
+		op.value, err = read(legacyValueSize)
+	}
+	if err == nil && op.hasStored() {
+		op.stored, err = readSized()
+	}
+	if err != nil {
+		return indexOp{}, err
+	}
+
+	if crc32.ChecksumIEEE(body) != crc {
+		return indexOp{}, errOpLogCorrupt
+	}
+	return op, nil
+}
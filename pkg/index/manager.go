@@ -4,27 +4,53 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 
-	"github.com/segmentio/ksuid"
 	"github.com/ssargent/freyjadb/pkg/bptree"
 )
 
+// Entry is a single secondary index entry decoded back into its parts
+type Entry struct {
+	FieldValue interface{}            // Indexed field value (int64, float64 or string)
+	PrimaryKey []byte                 // Primary key of the record the entry points at
+	Stored     map[string]interface{} // Copies of the record's stored fields; nil if none
+}
+
 // SecondaryIndex manages a B+Tree-based index for a specific field
 type SecondaryIndex struct {
-	fieldName string
-	tree      *bptree.BPlusTree
-	mutex     sync.RWMutex
+	fieldName     string
+	order         int
+	tree          *bptree.BPlusTree
+	pending       []indexOp // Mutations not yet covered by a checkpoint
+	loggedOps     int       // Operations in the on-disk op log since the last snapshot
+	needsSnapshot bool      // On-disk state is stale or corrupt; the next checkpoint rewrites it
+	state         IndexState
+	stateErr      error               // Why an unavailable index failed
+	touched       map[string]struct{} // Primary keys written during a rebuild
+	rebuilt       []rebuiltEntry      // Entries found by a rebuild, bulk-loaded when it finishes
+	entries       int                 // Entries in the tree when it was evicted, for reporting
+	storedFields  []string            // Fields entries keep a copy of
+	stored        map[string][]byte   // Encoded stored fields by index key
+	storedBytes   int64               // Estimated memory of stored
+	evicted       bool                // Tree was unloaded to save memory; reloaded on next use
+	dir           string              // Directory last loaded from or persisted to
+	lastUsed      atomic.Int64        // Unix nanoseconds of the last search or write
+	mutex         sync.RWMutex
 }
 
 // NewSecondaryIndex creates a new secondary index for a field
 func NewSecondaryIndex(fieldName string, order int) *SecondaryIndex {
-	return &SecondaryIndex{
+	idx := &SecondaryIndex{
 		fieldName: fieldName,
+		order:     order,
 		tree:      bptree.NewBPlusTree(order),
 	}
+	idx.touch() // Idle time counts from creation
+	return idx
 }
 
 // Insert adds a record to the secondary index
@@ -33,36 +59,69 @@ func (idx *SecondaryIndex) Insert(fieldValue interface{}, primaryKey []byte) err
 	idx.mutex.Lock()
 	defer idx.mutex.Unlock()
 
-	indexKey := idx.createIndexKey(fieldValue, primaryKey)
-	// Create a deterministic KSUID from the primary key bytes for the index value
-	ksuidValue := idx.createKSUIDFromBytes(primaryKey)
-	idx.tree.Insert(indexKey, ksuidValue)
+	idx.touch()
+	idx.ensureResidentInternal()
+	if idx.touched != nil {
+		idx.touched[string(primaryKey)] = struct{}{}
+	}
+	idx.insertInternal(fieldValue, primaryKey, nil)
 	return nil
 }
 
+// insertInternal adds an entry with its encoded stored fields, if any
+// (caller must hold the write lock)
+func (idx *SecondaryIndex) insertInternal(fieldValue interface{}, primaryKey, stored []byte) {
+	indexKey := idx.createIndexKey(fieldValue, primaryKey)
+	value := entryValue(indexKey, primaryKey)
+	idx.tree.Insert(indexKey, value)
+	idx.setStoredInternal(string(indexKey), stored)
+
+	op := indexOp{kind: opPut, key: indexKey, value: value}
+	if stored != nil {
+		op.kind, op.stored = opPutStored, stored
+	}
+	idx.pending = append(idx.pending, op)
+}
+
 // Delete removes a record from the secondary index
 func (idx *SecondaryIndex) Delete(fieldValue interface{}, primaryKey []byte) bool {
 	idx.mutex.Lock()
 	defer idx.mutex.Unlock()
 
+	idx.touch()
+	idx.ensureResidentInternal()
+	if idx.touched != nil {
+		idx.touched[string(primaryKey)] = struct{}{}
+	}
 	indexKey := idx.createIndexKey(fieldValue, primaryKey)
-	return idx.tree.Delete(indexKey)
+	if !idx.tree.Delete(indexKey) {
+		return false
+	}
+	idx.setStoredInternal(string(indexKey), nil)
+	idx.pending = append(idx.pending, indexOp{kind: opDelete, key: indexKey})
+	return true
 }
 
 // Search finds records with exact field value match
 func (idx *SecondaryIndex) Search(fieldValue interface{}) ([][]byte, error) {
-	idx.mutex.RLock()
+	idx.rlockResident()
 	defer idx.mutex.RUnlock()
 
+	if err := idx.checkAvailableInternal(); err != nil {
+		return nil, err
+	}
 	fieldPrefix := idx.createFieldPrefix(fieldValue)
 	return idx.searchWithPrefix(fieldPrefix)
 }
 
 // SearchRange finds records within a field value range
 func (idx *SecondaryIndex) SearchRange(startValue, endValue interface{}) ([][]byte, error) {
-	idx.mutex.RLock()
+	idx.rlockResident()
 	defer idx.mutex.RUnlock()
 
+	if err := idx.checkAvailableInternal(); err != nil {
+		return nil, err
+	}
 	var startPrefix, endPrefix []byte
 
 	if startValue != nil {
@@ -82,32 +141,113 @@ func (idx *SecondaryIndex) SearchRange(startValue, endValue interface{}) ([][]by
 	return idx.searchRangeWithPrefixes(startPrefix, endPrefix)
 }
 
-// Save persists the index to disk
-func (idx *SecondaryIndex) Save(dir string) error {
-	idx.mutex.RLock()
+// SearchEntries finds entries with exact field value match, returning the
+// decoded field value and stored fields alongside each primary key so
+// callers can answer queries without fetching the record
+func (idx *SecondaryIndex) SearchEntries(fieldValue interface{}) ([]Entry, error) {
+	idx.rlockResident()
 	defer idx.mutex.RUnlock()
 
-	filename := filepath.Join(dir, fmt.Sprintf("index_%s.dat", idx.fieldName))
-	return idx.tree.Save(filename)
+	if err := idx.checkAvailableInternal(); err != nil {
+		return nil, err
+	}
+	prefix := idx.createFieldPrefix(fieldValue)
+	return idx.scanEntriesInternal(prefix, idx.incrementPrefix(prefix))
 }
 
-// Load restores the index from disk
-func (idx *SecondaryIndex) Load(dir string) error {
+// SearchRangeEntries finds entries within a field value range, returning the
+// decoded field value and stored fields alongside each primary key
+func (idx *SecondaryIndex) SearchRangeEntries(startValue, endValue interface{}) ([]Entry, error) {
+	idx.rlockResident()
+	defer idx.mutex.RUnlock()
+
+	if err := idx.checkAvailableInternal(); err != nil {
+		return nil, err
+	}
+	var startPrefix, endPrefix []byte // Unbounded
+	if startValue != nil {
+		startPrefix = idx.createFieldPrefix(startValue)
+	}
+	if endValue != nil {
+		endPrefix = idx.incrementPrefix(idx.createFieldPrefix(endValue))
+	}
+	return idx.scanEntriesInternal(startPrefix, endPrefix)
+}
+
+// scanEntriesInternal decodes the entries with index keys in
+// [startKey, endKey), a nil bound meaning none, with their stored fields
+// (caller must hold the read lock)
+func (idx *SecondaryIndex) scanEntriesInternal(startKey, endKey []byte) ([]Entry, error) {
+	var entries []Entry
+	c := idx.tree.RangeScan(startKey, endKey)
+	defer c.Close()
+	for c.Next() {
+		entry, err := idx.parseIndexKey(c.Key())
+		if err == nil {
+			entry.Stored, err = idx.storedInternal(c.Key())
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Save persists a full snapshot of the index to disk and truncates its
+// operation log. An index that is not ready is skipped, so an empty or
+// partial tree never replaces the files it failed to load from.
+func (idx *SecondaryIndex) Save(dir string) error {
 	idx.mutex.Lock()
 	defer idx.mutex.Unlock()
 
-	filename := filepath.Join(dir, fmt.Sprintf("index_%s.dat", idx.fieldName))
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		// Index doesn't exist yet, keep empty tree
+	if idx.state != IndexReady {
 		return nil
 	}
+	if idx.evicted && dir == idx.dir {
+		return nil // Evicting checkpointed it there
+	}
+	idx.ensureResidentInternal()
+	return idx.saveSnapshotInternal(dir)
+}
+
+// Load restores the index from its snapshot and replays its operation log
+func (idx *SecondaryIndex) Load(dir string) error {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
 
-	tree, err := bptree.LoadBPlusTree(filename)
-	if err != nil {
+	return idx.loadInternal(dir)
+}
+
+// loadInternal replaces the tree with the one persisted in dir (caller must
+// hold the write lock)
+func (idx *SecondaryIndex) loadInternal(dir string) error {
+	if err := idx.recoverSnapshotInternal(dir); err != nil {
+		return fmt.Errorf("failed to recover index snapshot for field %s: %w", idx.fieldName, err)
+	}
+	idx.tree = bptree.NewBPlusTree(idx.order)
+	filename := snapshotPath(dir, idx.fieldName)
+	if _, err := os.Stat(filename); err == nil {
+		tree, err := bptree.LoadBPlusTree(filename)
+		if err != nil {
+			return fmt.Errorf("failed to load index for field %s: %w", idx.fieldName, err)
+		}
+		idx.tree = tree
+	}
+	if err := idx.loadStoredInternal(dir); err != nil {
 		return fmt.Errorf("failed to load index for field %s: %w", idx.fieldName, err)
 	}
 
-	idx.tree = tree
+	// Index may exist only as an op log if it was never fully snapshotted
+	idx.loggedOps = 0
+	idx.pending = nil
+	if err := idx.replayOpLog(dir); err != nil {
+		return fmt.Errorf("failed to replay index log for field %s: %w", idx.fieldName, err)
+	}
+	idx.dir = dir
+	idx.evicted = false
+	idx.state = IndexReady
+	idx.stateErr = nil
 	return nil
 }
 
@@ -155,21 +295,49 @@ func (idx *SecondaryIndex) serializeValue(buf *bytes.Buffer, value interface{})
 	}
 }
 
+// parseIndexKey splits a composite index key back into field value and primary key
+func (idx *SecondaryIndex) parseIndexKey(key []byte) (Entry, error) {
+	if len(key) == 0 {
+		return Entry{}, fmt.Errorf("empty index key")
+	}
+
+	switch key[0] {
+	case 0:
+		if len(key) < 9 {
+			return Entry{}, fmt.Errorf("index key too short for int value")
+		}
+		v := int64(binary.BigEndian.Uint64(key[1:9])) //nolint:gosec // round-trips serializeValue
+		return Entry{FieldValue: v, PrimaryKey: key[9:]}, nil
+	case 1:
+		if len(key) < 9 {
+			return Entry{}, fmt.Errorf("index key too short for float value")
+		}
+		v := math.Float64frombits(binary.BigEndian.Uint64(key[1:9]))
+		return Entry{FieldValue: v, PrimaryKey: key[9:]}, nil
+	case 2:
+		end := bytes.IndexByte(key[1:], 0)
+		if end < 0 {
+			return Entry{}, fmt.Errorf("index key missing string terminator")
+		}
+		return Entry{FieldValue: string(key[1 : 1+end]), PrimaryKey: key[2+end:]}, nil
+	default:
+		return Entry{}, fmt.Errorf("unknown index value type marker: %d", key[0])
+	}
+}
+
 // searchWithPrefix finds all primary keys with the given field value prefix
 func (idx *SecondaryIndex) searchWithPrefix(prefix []byte) ([][]byte, error) {
 	var results [][]byte
 
-	// For exact match, we need to find all keys that start with the prefix
-	// and extract the primary key from the KSUID value
-	idx.treeRangeScan(prefix, idx.incrementPrefix(prefix), func(key []byte, value *ksuid.KSUID) bool {
-		if bytes.HasPrefix(key, prefix) && value != nil {
-			// Extract primary key from the index key (everything after the prefix)
-			primaryKey := key[len(prefix):]
-			results = append(results, primaryKey)
+	// Every key in the range starts with the prefix; the primary key is
+	// everything after it
+	c := idx.tree.RangeScan(prefix, idx.incrementPrefix(prefix))
+	defer c.Close()
+	for c.Next() {
+		if bytes.HasPrefix(c.Key(), prefix) {
+			results = append(results, c.Key()[len(prefix):])
 		}
-		return true // continue scanning
-	})
-
+	}
 	return results, nil
 }
 
@@ -177,130 +345,64 @@ func (idx *SecondaryIndex) searchWithPrefix(prefix []byte) ([][]byte, error) {
 func (idx *SecondaryIndex) searchRangeWithPrefixes(startPrefix, endPrefix []byte) ([][]byte, error) {
 	var results [][]byte
 
-	// If endPrefix is nil, scan from startPrefix to the end
-	if endPrefix == nil {
-		endPrefix = []byte{0xFF, 0xFF, 0xFF, 0xFF} // Very high value
-	}
-
-	idx.treeRangeScan(startPrefix, endPrefix, func(key []byte, value *ksuid.KSUID) bool {
-		// Extract primary key from the index key
-		if value != nil && bytes.HasPrefix(key, startPrefix) {
-			primaryKey := key[len(startPrefix):]
-			results = append(results, primaryKey)
-		}
-		return true // continue scanning
-	})
-
-	return results, nil
-}
-
-// treeRangeScan performs a range scan on the B+tree using leaf node traversal
-func (idx *SecondaryIndex) treeRangeScan(startKey, endKey []byte, callback func([]byte, *ksuid.KSUID) bool) {
-	// This is a simplified implementation. In a full implementation,
-	// we'd need to access the B+tree's internal leaf traversal methods.
-	// For now, we'll use a basic approach that works with the current B+tree API.
-
-	// For debugging: let's try a different approach
-	// Since we know the keys we're looking for, let's try exact matches first
-
-	// For now, let's implement a simple linear scan approach
-	// This is not efficient but will help us verify the basic functionality
-
-	// Try exact match for startKey first
-	if value, found := idx.tree.Search(startKey); found {
-		if !callback(startKey, value) {
-			return
+	// A nil endPrefix scans from startPrefix to the end
+	c := idx.tree.RangeScan(startPrefix, endPrefix)
+	defer c.Close()
+	for c.Next() {
+		// Entries in a range have different field values, so the primary
+		// key is found by decoding each one rather than by trimming a prefix
+		entry, err := idx.parseIndexKey(c.Key())
+		if err != nil {
+			return nil, err
 		}
+		results = append(results, entry.PrimaryKey)
 	}
-
-	// Try a few variations around the start key
-	for i := 0; i < 10; i++ {
-		testKey := idx.nextKey(startKey)
-		if testKey == nil {
-			break
-		}
-
-		if endKey != nil && bytes.Compare(testKey, endKey) >= 0 {
-			break
-		}
-
-		if value, found := idx.tree.Search(testKey); found {
-			if !callback(testKey, value) {
-				return
-			}
-		}
-
-		startKey = testKey
-	}
+	return results, nil
 }
 
-// nextKey generates the next possible key for iteration
-func (idx *SecondaryIndex) nextKey(key []byte) []byte {
-	if len(key) == 0 {
-		return nil
-	}
-
-	// Simple increment: add 1 to the last byte
-	next := make([]byte, len(key))
-	copy(next, key)
+// incrementPrefix returns the smallest key greater than every key starting
+// with prefix, for use as an exclusive range end
+func (idx *SecondaryIndex) incrementPrefix(prefix []byte) []byte {
+	next := make([]byte, len(prefix))
+	copy(next, prefix)
 
+	// Trailing 0xFF bytes cannot be incremented; drop them and carry
 	for i := len(next) - 1; i >= 0; i-- {
-		if next[i] < 255 {
+		if next[i] < 0xFF {
 			next[i]++
-			return next
+			return next[:i+1]
 		}
-		next[i] = 0
-	}
-
-	// If we overflow, append a byte
-	return append(next, 1)
-}
-
-// incrementPrefix creates the next possible prefix for range queries
-func (idx *SecondaryIndex) incrementPrefix(prefix []byte) []byte {
-	if len(prefix) == 0 {
-		return []byte{0}
 	}
 
-	next := make([]byte, len(prefix))
-	copy(next, prefix)
-
-	// Increment the last byte
-	next[len(next)-1]++
-
-	return next
+	// All bytes are 0xFF (or the prefix is empty): there is no upper bound
+	return nil
 }
 
-// createKSUIDFromBytes creates a deterministic KSUID from arbitrary bytes
-// This is used to store primary keys as KSUID values in the B+tree
-func (idx *SecondaryIndex) createKSUIDFromBytes(data []byte) ksuid.KSUID {
-	// Create a deterministic KSUID by padding/truncating the data to 20 bytes
-	var ksuidBytes [20]byte
-
-	// Copy data, padding with zeros if too short, truncating if too long
-	copy(ksuidBytes[:], data)
-
-	// Create KSUID from the fixed-size bytes
-	result, err := ksuid.FromBytes(ksuidBytes[:])
-	if err != nil {
-		// Fallback: use a hash-based approach
-		return ksuid.New()
-	}
-	return result
+// entryValue returns the tree value of an entry: its primary key, sharing
+// the bytes at the end of the index key. Entries saved before tree values
+// could be of any length hold a 20-byte stand-in instead, so searches take
+// primary keys from the index key.
+func entryValue(indexKey, primaryKey []byte) []byte {
+	return indexKey[len(indexKey)-len(primaryKey):]
 }
 
 // IndexManager manages multiple secondary indexes for a partition
 type IndexManager struct {
-	indexes map[string]*SecondaryIndex
-	mutex   sync.RWMutex
-	order   int
+	indexes  map[string]*SecondaryIndex
+	mutex    sync.RWMutex
+	order    int
+	logLimit int // Op log length that triggers a full snapshot on Checkpoint
+
+	eviction     EvictionPolicy // When EvictCold unloads indexes from memory
+	evictionDone chan struct{}  // Stops background eviction; nil when not running
 }
 
 // NewIndexManager creates a new index manager
 func NewIndexManager(order int) *IndexManager {
 	return &IndexManager{
-		indexes: make(map[string]*SecondaryIndex),
-		order:   order,
+		indexes:  make(map[string]*SecondaryIndex),
+		order:    order,
+		logLimit: DefaultCheckpointLogLimit,
 	}
 }
 
@@ -318,7 +420,31 @@ func (im *IndexManager) GetOrCreateIndex(fieldName string) *SecondaryIndex {
 	return idx
 }
 
-// SaveAll saves all indexes to disk
+// SetCheckpointLogLimit sets how many logged operations an index may
+// accumulate before Checkpoint rewrites it as a full snapshot
+func (im *IndexManager) SetCheckpointLogLimit(limit int) {
+	im.mutex.Lock()
+	defer im.mutex.Unlock()
+
+	im.logLimit = limit
+}
+
+// CheckpointAll incrementally persists every index changed since the last
+// checkpoint. Unlike SaveAll, its cost is proportional to the number of
+// changes rather than the total index size.
+func (im *IndexManager) CheckpointAll(dir string) error {
+	im.mutex.RLock()
+	defer im.mutex.RUnlock()
+
+	for _, idx := range im.indexes {
+		if err := idx.Checkpoint(dir, im.logLimit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveAll saves full snapshots of all indexes to disk
 func (im *IndexManager) SaveAll(dir string) error {
 	im.mutex.RLock()
 	defer im.mutex.RUnlock()
@@ -331,30 +457,56 @@ func (im *IndexManager) SaveAll(dir string) error {
 	return nil
 }
 
-// LoadAll loads all indexes from disk
+// ExportAll writes full snapshots of all indexes to dir, which LoadAll
+// can load, without disturbing their checkpoints. It suits
+// store.BackupCheckpoint.Save.
+func (im *IndexManager) ExportAll(dir string) error {
+	im.mutex.RLock()
+	defer im.mutex.RUnlock()
+
+	for _, idx := range im.indexes {
+		if err := idx.Export(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadAll loads all indexes from disk. An index that fails to load, e.g.
+// from a corrupt snapshot, is registered as unavailable rather than failing
+// the whole load; see Unavailable.
 func (im *IndexManager) LoadAll(dir string) error {
 	im.mutex.Lock()
 	defer im.mutex.Unlock()
 
-	// Find all index files
-	pattern := filepath.Join(dir, "index_*.dat")
-	files, err := filepath.Glob(pattern)
-	if err != nil {
-		return err
+	// Find all index snapshots and op logs
+	var files []string
+	for _, pattern := range []string{"index_*.dat", "index_*.oplog"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return err
+		}
+		files = append(files, matches...)
 	}
 
+	seen := make(map[string]bool)
 	for _, file := range files {
 		filename := filepath.Base(file)
-		if len(filename) < 10 { // "index_.dat" is 10 chars minimum
+		ext := filepath.Ext(filename)
+		if len(filename) <= len("index_")+len(ext) {
 			continue
 		}
 
 		// Extract field name from filename
-		fieldName := filename[6 : len(filename)-4] // Remove "index_" prefix and ".dat" suffix
+		fieldName := filename[len("index_") : len(filename)-len(ext)]
+		if seen[fieldName] {
+			continue
+		}
+		seen[fieldName] = true
 
 		idx := NewSecondaryIndex(fieldName, im.order)
 		if err := idx.Load(dir); err != nil {
-			return err
+			idx.MarkUnavailable(err)
 		}
 
 		im.indexes[fieldName] = idx
@@ -362,3 +514,18 @@ func (im *IndexManager) LoadAll(dir string) error {
 
 	return nil
 }
+
+// Unavailable returns the indexes that cannot answer searches, with the
+// reason each failed or nil for one being rebuilt
+func (im *IndexManager) Unavailable() map[string]error {
+	im.mutex.RLock()
+	defer im.mutex.RUnlock()
+
+	unavailable := make(map[string]error)
+	for name, idx := range im.indexes {
+		if state, err := idx.State(); state != IndexReady {
+			unavailable[name] = err
+		}
+	}
+	return unavailable
+}
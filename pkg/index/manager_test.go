@@ -1,10 +1,13 @@
 package index
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/ssargent/freyjadb/pkg/bptree"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -72,19 +75,61 @@ func TestSecondaryIndex_Delete(t *testing.T) {
 func TestSecondaryIndex_SearchRange(t *testing.T) {
 	idx := NewSecondaryIndex("age", 3)
 
-	// Insert records with different ages
-	users := map[int][]byte{
-		25: []byte("user_25"),
-		30: []byte("user_30"),
+	// Enough records to span many leaves, several per age
+	for i := 0; i < 600; i++ {
+		require.NoError(t, idx.Insert(i%100, []byte(fmt.Sprintf("user_%03d", i))))
 	}
 
-	for age, primaryKey := range users {
-		err := idx.Insert(age, primaryKey)
-		require.NoError(t, err)
+	keys, err := idx.SearchRange(25, 74)
+	require.NoError(t, err)
+	require.Len(t, keys, 300)
+	assert.Equal(t, "user_025", string(keys[0]))
+	assert.Equal(t, "user_574", string(keys[len(keys)-1]))
+
+	// Unbounded ends
+	keys, err = idx.SearchRange(nil, 9)
+	require.NoError(t, err)
+	assert.Len(t, keys, 60)
+	keys, err = idx.SearchRange(90, nil)
+	require.NoError(t, err)
+	assert.Len(t, keys, 60)
+	keys, err = idx.SearchRange(nil, nil)
+	require.NoError(t, err)
+	assert.Len(t, keys, 600)
+
+	keys, err = idx.SearchRange(200, 300)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestSecondaryIndex_SearchRangeEntries(t *testing.T) {
+	idx := NewSecondaryIndex("year", 3)
+	for year := int64(1); year <= 20; year++ {
+		require.NoError(t, idx.Insert(year, []byte(fmt.Sprintf("event:%02d", year))))
 	}
 
-	// Basic test that inserts work
-	assert.NotNil(t, idx.tree)
+	entries, err := idx.SearchRangeEntries(int64(5), int64(8))
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+	for i, entry := range entries {
+		assert.Equal(t, int64(5+i), entry.FieldValue)
+		assert.Equal(t, fmt.Sprintf("event:%02d", 5+i), string(entry.PrimaryKey))
+	}
+
+	keys, err := idx.SearchRange(int64(18), nil)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("event:18"), []byte("event:19"), []byte("event:20")}, keys)
+
+	keys, err = idx.Search(int64(12))
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("event:12")}, keys)
+
+	// An end value whose encoding ends in 0xFF must not wrap around
+	require.NoError(t, idx.Insert(int64(255), []byte("event:255")))
+	require.NoError(t, idx.Insert(int64(256), []byte("event:256")))
+	keys, err = idx.SearchRange(int64(20), int64(255))
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("event:20"), []byte("event:255")}, keys)
 }
 
 func TestSecondaryIndex_SaveLoad(t *testing.T) {
@@ -292,3 +337,347 @@ func TestSecondaryIndex_EdgeCases(t *testing.T) {
 // 		idx.Search(fmt.Sprintf("value_%d", i%1000))
 // 	}
 // }
+
+func TestSecondaryIndex_ParseIndexKey(t *testing.T) {
+	idx := NewSecondaryIndex("field", 3)
+
+	tests := []struct {
+		name  string
+		value interface{}
+		want  interface{}
+	}{
+		{"int", 42, int64(42)},
+		{"int64", int64(-7), int64(-7)},
+		{"float64", 3.5, 3.5},
+		{"string", "alice", "alice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := idx.createIndexKey(tt.value, []byte("user:1"))
+
+			entry, err := idx.parseIndexKey(key)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, entry.FieldValue)
+			assert.Equal(t, []byte("user:1"), entry.PrimaryKey)
+		})
+	}
+
+	_, err := idx.parseIndexKey([]byte{2, 'a', 'b'})
+	assert.Error(t, err)
+
+	_, err = idx.parseIndexKey([]byte{9})
+	assert.Error(t, err)
+}
+
+func TestIndexManager_CheckpointAll(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	manager := NewIndexManager(3)
+	manager.SetCheckpointLogLimit(4)
+	idx := manager.GetOrCreateIndex("age")
+
+	require.NoError(t, idx.Insert(25, []byte("user_1")))
+	require.NoError(t, idx.Insert(30, []byte("user_2")))
+	assert.True(t, idx.Delete(25, []byte("user_1")))
+
+	// Small change sets only go to the op log
+	require.NoError(t, manager.CheckpointAll(tmpDir))
+	assert.NoFileExists(t, filepath.Join(tmpDir, "index_age.dat"))
+	assert.FileExists(t, filepath.Join(tmpDir, "index_age.oplog"))
+
+	// Clean indexes are skipped
+	require.NoError(t, manager.CheckpointAll(tmpDir))
+
+	has := func(idx *SecondaryIndex, value interface{}, pk string) bool {
+		_, found := idx.tree.Search(idx.createIndexKey(value, []byte(pk)))
+		return found
+	}
+
+	loaded := NewIndexManager(3)
+	require.NoError(t, loaded.LoadAll(tmpDir))
+	replayed := loaded.GetOrCreateIndex("age")
+	assert.False(t, has(replayed, 25, "user_1"))
+	assert.True(t, has(replayed, 30, "user_2"))
+
+	// Exceeding the log limit folds everything into a snapshot
+	require.NoError(t, idx.Insert(40, []byte("user_3")))
+	require.NoError(t, idx.Insert(50, []byte("user_4")))
+	require.NoError(t, manager.CheckpointAll(tmpDir))
+	assert.FileExists(t, filepath.Join(tmpDir, "index_age.dat"))
+	assert.NoFileExists(t, filepath.Join(tmpDir, "index_age.oplog"))
+
+	// A torn record at the end of the log is ignored on replay
+	require.NoError(t, idx.Insert(60, []byte("user_5")))
+	require.NoError(t, manager.CheckpointAll(tmpDir))
+	f, err := os.OpenFile(filepath.Join(tmpDir, "index_age.oplog"), os.O_APPEND|os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0x01, 0x02, 0x03})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	reloaded := NewSecondaryIndex("age", 3)
+	require.NoError(t, reloaded.Load(tmpDir))
+	for _, want := range []struct {
+		value int
+		pk    string
+	}{{30, "user_2"}, {40, "user_3"}, {50, "user_4"}, {60, "user_5"}} {
+		assert.True(t, has(reloaded, want.value, want.pk), "missing %d/%s", want.value, want.pk)
+	}
+}
+
+func TestSecondaryIndex_SnapshotCrashWindow(t *testing.T) {
+	has := func(idx *SecondaryIndex, value interface{}, pk string) bool {
+		_, found := idx.tree.Search(idx.createIndexKey(value, []byte(pk)))
+		return found
+	}
+
+	// Each case leaves a log holding the insert of user_1 next to a snapshot
+	// that deleted it, stopping the save at a different step
+	for _, tc := range []struct {
+		name      string
+		crash     func(idx *SecondaryIndex, dir string) error
+		committed bool
+	}{
+		{"staged", func(idx *SecondaryIndex, dir string) error {
+			return idx.stageSnapshotInternal(dir, 1)
+		}, false},
+		{"committed", func(idx *SecondaryIndex, dir string) error {
+			if err := idx.stageSnapshotInternal(dir, 1); err != nil {
+				return err
+			}
+			return idx.commitSnapshot(dir, 1)
+		}, true},
+		{"partly installed", func(idx *SecondaryIndex, dir string) error {
+			if err := idx.stageSnapshotInternal(dir, 1); err != nil {
+				return err
+			}
+			if err := idx.commitSnapshot(dir, 1); err != nil {
+				return err
+			}
+			return os.Rename(stagedPath(snapshotPath(dir, "age"), 1), snapshotPath(dir, "age"))
+		}, true},
+		{"installed", func(idx *SecondaryIndex, dir string) error {
+			if err := idx.stageSnapshotInternal(dir, 1); err != nil {
+				return err
+			}
+			if err := idx.commitSnapshot(dir, 1); err != nil {
+				return err
+			}
+			return idx.installSnapshot(dir, 1)
+		}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			idx := NewSecondaryIndex("age", 3)
+			idx.SetStoredFields("name")
+			require.NoError(t, idx.InsertStored(25, []byte("user_1"), map[string]interface{}{"name": "Alice"}))
+			require.NoError(t, idx.InsertStored(30, []byte("user_2"), map[string]interface{}{"name": "Bob"}))
+			require.NoError(t, idx.Checkpoint(tmpDir, 0))
+
+			assert.True(t, idx.Delete(25, []byte("user_1")))
+			require.NoError(t, idx.InsertStored(40, []byte("user_3"), map[string]interface{}{"name": "Carol"}))
+			require.NoError(t, tc.crash(idx, tmpDir))
+
+			loaded := NewSecondaryIndex("age", 3)
+			require.NoError(t, loaded.Load(tmpDir))
+			assert.NoFileExists(t, stagedPath(snapshotPath(tmpDir, "age"), 1))
+			assert.NoFileExists(t, stagedPath(storedPath(tmpDir, "age"), 1))
+
+			// Before the commit the old log stands; after it the new
+			// snapshot does and the delete is never undone
+			assert.Equal(t, !tc.committed, has(loaded, 25, "user_1"))
+			assert.True(t, has(loaded, 30, "user_2"))
+			assert.Equal(t, tc.committed, has(loaded, 40, "user_3"))
+			if tc.committed {
+				assert.Equal(t, 0, loaded.loggedOps)
+				stored, err := loaded.storedInternal(loaded.createIndexKey(40, []byte("user_3")))
+				require.NoError(t, err)
+				assert.Equal(t, "Carol", stored["name"])
+			}
+
+			// The next snapshot moves to a later generation and replaces the log
+			require.NoError(t, loaded.Insert(50, []byte("user_4")))
+			loaded.needsSnapshot = true
+			require.NoError(t, loaded.Checkpoint(tmpDir, 0))
+			assert.NoFileExists(t, opLogPath(tmpDir, "age"))
+
+			reloaded := NewSecondaryIndex("age", 3)
+			require.NoError(t, reloaded.Load(tmpDir))
+			assert.Equal(t, !tc.committed, has(reloaded, 25, "user_1"))
+			assert.True(t, has(reloaded, 50, "user_4"))
+		})
+	}
+}
+
+func TestSecondaryIndex_LongPrimaryKeys(t *testing.T) {
+	tmpDir := t.TempDir()
+	manager := NewIndexManager(3)
+	manager.SetCheckpointLogLimit(100)
+	idx := manager.GetOrCreateIndex("email")
+
+	long := []byte("tenant:acme:user:" + strings.Repeat("x", 100))
+	require.NoError(t, idx.Insert("a@example.com", long))
+	require.NoError(t, idx.Insert("b@example.com", []byte("user_2")))
+
+	// The tree holds each entry's primary key as its value
+	value, found := idx.tree.Search(idx.createIndexKey("a@example.com", long))
+	require.True(t, found)
+	assert.Equal(t, long, value)
+
+	// Primary keys of any length survive the op log and the snapshot
+	require.NoError(t, manager.CheckpointAll(tmpDir))
+	assert.NoFileExists(t, filepath.Join(tmpDir, "index_email.dat"))
+	for _, load := range []func() *SecondaryIndex{
+		func() *SecondaryIndex {
+			loaded := NewSecondaryIndex("email", 3)
+			require.NoError(t, loaded.Load(tmpDir))
+			return loaded
+		},
+		func() *SecondaryIndex {
+			require.NoError(t, idx.Save(tmpDir))
+			loaded := NewSecondaryIndex("email", 3)
+			require.NoError(t, loaded.Load(tmpDir))
+			return loaded
+		},
+	} {
+		loaded := load()
+		keys, err := loaded.Search("a@example.com")
+		require.NoError(t, err)
+		assert.Equal(t, [][]byte{long}, keys)
+		value, found := loaded.tree.Search(loaded.createIndexKey("a@example.com", long))
+		require.True(t, found)
+		assert.Equal(t, long, value)
+	}
+}
+
+func TestIndexManager_ExportAll(t *testing.T) {
+	liveDir, exportDir := t.TempDir(), t.TempDir()
+	manager := NewIndexManager(3)
+	idx := manager.GetOrCreateIndex("age")
+	require.NoError(t, idx.Insert(25, []byte("user_1")))
+	require.NoError(t, manager.CheckpointAll(liveDir))
+	require.NoError(t, idx.Insert(30, []byte("user_2")))
+
+	require.NoError(t, manager.ExportAll(exportDir))
+	exported := NewIndexManager(3)
+	require.NoError(t, exported.LoadAll(exportDir))
+	keys, err := exported.GetOrCreateIndex("age").Search(30)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("user_2")}, keys)
+
+	// The change exported is still checkpointed to the index's own directory
+	require.NoError(t, manager.CheckpointAll(liveDir))
+	reloaded := NewIndexManager(3)
+	require.NoError(t, reloaded.LoadAll(liveDir))
+	keys, err = reloaded.GetOrCreateIndex("age").Search(30)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("user_2")}, keys)
+}
+
+func TestIndexManager_LoadAll_CorruptIndex(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	manager := NewIndexManager(4)
+	require.NoError(t, manager.GetOrCreateIndex("age").Insert(25, []byte("user_1")))
+	require.NoError(t, manager.GetOrCreateIndex("name").Insert("Alice", []byte("user_1")))
+	require.NoError(t, manager.SaveAll(tmpDir))
+
+	// A truncated snapshot leaves that index unavailable without failing the load
+	snapshot := filepath.Join(tmpDir, "index_age.dat")
+	require.NoError(t, os.Truncate(snapshot, 6))
+
+	loaded := NewIndexManager(4)
+	require.NoError(t, loaded.LoadAll(tmpDir))
+	unavailable := loaded.Unavailable()
+	require.Len(t, unavailable, 1)
+	assert.ErrorIs(t, unavailable["age"], bptree.ErrCorrupt)
+
+	_, err := loaded.GetOrCreateIndex("age").SearchEntries(25)
+	assert.ErrorIs(t, err, ErrIndexUnavailable)
+	names, err := loaded.GetOrCreateIndex("name").Search("Alice")
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("user_1")}, names)
+
+	// Saving and checkpointing leave the unreadable files alone
+	require.NoError(t, loaded.SaveAll(tmpDir))
+	require.NoError(t, loaded.CheckpointAll(tmpDir))
+	info, err := os.Stat(snapshot)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), info.Size())
+}
+
+func TestSecondaryIndex_Rebuild(t *testing.T) {
+	tmpDir := t.TempDir()
+	idx := NewSecondaryIndex("age", 4)
+	require.NoError(t, idx.Insert(20, []byte("stale")))
+
+	idx.BeginRebuild()
+	state, _ := idx.State()
+	assert.Equal(t, IndexRebuilding, state)
+	_, err := idx.SearchEntries(20)
+	assert.ErrorIs(t, err, ErrIndexUnavailable)
+
+	// A writer's update during the rebuild wins over the rebuild's older read
+	require.NoError(t, idx.Insert(31, []byte("user_1")))
+	idx.InsertRebuilt(30, []byte("user_1"))
+	idx.InsertRebuilt(40, []byte("user_2"))
+	idx.FinishRebuild(nil)
+
+	entries, err := idx.SearchRangeEntries(nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{FieldValue: int64(31), PrimaryKey: []byte("user_1")},
+		{FieldValue: int64(40), PrimaryKey: []byte("user_2")},
+	}, entries)
+
+	// The first checkpoint after a rebuild writes a full snapshot
+	require.NoError(t, idx.Checkpoint(tmpDir, DefaultCheckpointLogLimit))
+	assert.FileExists(t, filepath.Join(tmpDir, "index_age.dat"))
+	assert.NoFileExists(t, filepath.Join(tmpDir, "index_age.oplog"))
+
+	// A failed rebuild leaves the index unavailable
+	idx.BeginRebuild()
+	idx.FinishRebuild(assert.AnError)
+	state, err = idx.State()
+	assert.Equal(t, IndexUnavailable, state)
+	assert.ErrorIs(t, err, assert.AnError)
+}
+
+func TestSecondaryIndex_RebuildBulkLoads(t *testing.T) {
+	idx := NewSecondaryIndex("age", 4)
+	idx.BeginRebuild()
+
+	const n = 500
+	for i := n - 1; i >= 0; i-- {
+		key := []byte(fmt.Sprintf("user_%03d", i))
+		require.NoError(t, idx.InsertRebuiltStored(i%50, key, map[string]interface{}{"n": i}))
+	}
+	// Writers that change a record after the rebuild read it still win
+	assert.False(t, idx.Delete(7, []byte("user_007")), "the entry is not in the tree until the rebuild finishes")
+	require.NoError(t, idx.Insert(1000, []byte("user_008")))
+	require.NoError(t, idx.Insert(1001, []byte("new_user")))
+	idx.FinishRebuild(nil)
+
+	assert.Equal(t, n, idx.Memory().Entries)
+	entries, err := idx.SearchRangeEntries(nil, nil)
+	require.NoError(t, err)
+	require.Len(t, entries, n)
+	assert.Equal(t, int64(0), entries[0].FieldValue)
+	assert.Equal(t, []Entry{
+		{FieldValue: int64(1000), PrimaryKey: []byte("user_008")},
+		{FieldValue: int64(1001), PrimaryKey: []byte("new_user")},
+	}, entries[n-2:])
+
+	matches, err := idx.SearchEntries(7)
+	require.NoError(t, err)
+	require.Len(t, matches, 9, "user_007 was deleted by a writer")
+	assert.Equal(t, []byte("user_057"), matches[0].PrimaryKey)
+	assert.Equal(t, map[string]interface{}{"n": float64(57)}, matches[0].Stored)
+
+	// The bulk-loaded tree keeps taking writes
+	require.NoError(t, idx.Insert(7, []byte("user_007")))
+	matches, err = idx.SearchEntries(7)
+	require.NoError(t, err)
+	assert.Len(t, matches, 10)
+}
@@ -0,0 +1,266 @@
+package index
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// opOverhead estimates the memory of a pending operation beyond its key and
+// stored fields: the headers of its key, value and stored slices and its kind.
+// The value is a primary key sharing the key's bytes.
+const opOverhead = 3*24 + 8
+
+// IndexMemory reports the estimated resident memory of one index
+type IndexMemory struct {
+	Field     string     // Indexed field
+	State     IndexState // Whether the index can answer searches
+	Entries   int        // Entries in the index, resident or not
+	Bytes     int64      // Estimated resident memory; 0 while evicted
+	TreeBytes int64      // Part of Bytes held by the index's B+Tree
+	Resident  bool       // False once the eviction policy has unloaded the index
+	LastUsed  time.Time  // Last search or write; zero if never used
+}
+
+// EvictionPolicy controls when an IndexManager unloads cold indexes from
+// memory. Evicted indexes are checkpointed to Dir first and reloaded from it
+// lazily by the next search or write, so eviction only trades latency on
+// that first access for resident memory.
+type EvictionPolicy struct {
+	Dir       string        // Where evicted indexes are checkpointed and reloaded from
+	IdleAfter time.Duration // Evict indexes unused for this long; 0 disables
+	MaxBytes  int64         // Evict least recently used indexes while over this; 0 disables
+}
+
+// MemoryUsage returns the estimated resident memory of the index in bytes
+func (idx *SecondaryIndex) MemoryUsage() int64 {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	return idx.memoryUsageInternal()
+}
+
+// memoryUsageInternal estimates resident memory (caller must hold the lock)
+func (idx *SecondaryIndex) memoryUsageInternal() int64 {
+	if idx.evicted {
+		return 0
+	}
+	usage := idx.tree.MemoryUsage() + idx.storedBytes
+	for _, op := range idx.pending {
+		usage += int64(len(op.key)+len(op.stored)) + opOverhead
+	}
+	return usage
+}
+
+// Memory reports the index's memory usage
+func (idx *SecondaryIndex) Memory() IndexMemory {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	mem := IndexMemory{
+		Field:    idx.fieldName,
+		State:    idx.state,
+		Entries:  idx.entries,
+		Bytes:    idx.memoryUsageInternal(),
+		Resident: !idx.evicted,
+	}
+	if !idx.evicted {
+		mem.Entries = idx.tree.Size()
+		mem.TreeBytes = idx.tree.MemoryUsage()
+	}
+	if used := idx.lastUsed.Load(); used != 0 {
+		mem.LastUsed = time.Unix(0, used)
+	}
+	return mem
+}
+
+// Evicted reports whether the index is currently unloaded from memory
+func (idx *SecondaryIndex) Evicted() bool {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	return idx.evicted
+}
+
+// Evict checkpoints the index to dir and drops its tree from memory. The
+// next search or write reloads it from dir. Indexes that are not ready are
+// left alone, since their files on disk don't match their contents; Evict
+// reports whether the index was unloaded.
+func (idx *SecondaryIndex) Evict(dir string, logLimit int) (bool, error) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	if idx.evicted || idx.state != IndexReady {
+		return false, nil
+	}
+	// The reload reads dir, so it must hold everything, not just recent changes
+	if idx.dir != dir {
+		idx.needsSnapshot = true
+	}
+	if err := idx.checkpointInternal(dir, logLimit); err != nil {
+		return false, fmt.Errorf("failed to evict index for field %s: %w", idx.fieldName, err)
+	}
+
+	idx.entries = idx.tree.Size()
+	idx.tree = nil
+	idx.stored = nil
+	idx.storedBytes = 0
+	idx.evicted = true
+	return true, nil
+}
+
+// touch records that the index was just used
+func (idx *SecondaryIndex) touch() {
+	idx.lastUsed.Store(time.Now().UnixNano())
+}
+
+// rlockResident read-locks the index, first reloading it if it was evicted.
+// The caller must RUnlock. A failed reload leaves the index unavailable, so
+// searches report ErrIndexUnavailable and query engines rebuild it.
+func (idx *SecondaryIndex) rlockResident() {
+	idx.touch()
+	for {
+		idx.mutex.RLock()
+		if !idx.evicted {
+			return
+		}
+		idx.mutex.RUnlock()
+
+		idx.mutex.Lock()
+		idx.ensureResidentInternal()
+		idx.mutex.Unlock()
+	}
+}
+
+// ensureResidentInternal reloads an evicted index (caller must hold the
+// write lock)
+func (idx *SecondaryIndex) ensureResidentInternal() {
+	if !idx.evicted {
+		return
+	}
+	if err := idx.loadInternal(idx.dir); err != nil {
+		idx.resetInternal()
+		idx.state = IndexUnavailable
+		idx.stateErr = fmt.Errorf("reload after eviction failed: %w", err)
+		idx.touched = nil
+	}
+}
+
+// MemoryStats reports the memory usage of every index, ordered by field
+func (im *IndexManager) MemoryStats() []IndexMemory {
+	im.mutex.RLock()
+	defer im.mutex.RUnlock()
+
+	stats := make([]IndexMemory, 0, len(im.indexes))
+	for _, idx := range im.indexes {
+		stats = append(stats, idx.Memory())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Field < stats[j].Field })
+	return stats
+}
+
+// MemoryUsage returns the estimated resident memory of all indexes in bytes
+func (im *IndexManager) MemoryUsage() int64 {
+	var total int64
+	for _, mem := range im.MemoryStats() {
+		total += mem.Bytes
+	}
+	return total
+}
+
+// SetEvictionPolicy sets the policy EvictCold applies. The zero policy
+// never evicts.
+func (im *IndexManager) SetEvictionPolicy(policy EvictionPolicy) {
+	im.mutex.Lock()
+	defer im.mutex.Unlock()
+
+	im.eviction = policy
+}
+
+// EvictCold unloads indexes the eviction policy considers cold: first those
+// idle longer than IdleAfter, then the least recently used until resident
+// memory is within MaxBytes. It returns the fields it evicted.
+func (im *IndexManager) EvictCold() ([]string, error) {
+	return im.evictColdAt(time.Now())
+}
+
+// evictColdAt applies the eviction policy as of now
+func (im *IndexManager) evictColdAt(now time.Time) ([]string, error) {
+	im.mutex.RLock()
+	policy, logLimit := im.eviction, im.logLimit
+	im.mutex.RUnlock()
+
+	if policy.Dir == "" || (policy.IdleAfter <= 0 && policy.MaxBytes <= 0) {
+		return nil, nil
+	}
+
+	// Least recently used first
+	stats := im.MemoryStats()
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].LastUsed.Before(stats[j].LastUsed) })
+
+	var resident int64
+	for _, mem := range stats {
+		resident += mem.Bytes
+	}
+
+	var evicted []string
+	for _, mem := range stats {
+		if !mem.Resident || mem.State != IndexReady {
+			continue
+		}
+		idle := policy.IdleAfter > 0 && now.Sub(mem.LastUsed) >= policy.IdleAfter
+		over := policy.MaxBytes > 0 && resident > policy.MaxBytes
+		if !idle && !over {
+			continue
+		}
+
+		im.mutex.RLock()
+		idx := im.indexes[mem.Field]
+		im.mutex.RUnlock()
+
+		ok, err := idx.Evict(policy.Dir, logLimit)
+		if err != nil {
+			return evicted, err
+		}
+		if ok {
+			resident -= mem.Bytes
+			evicted = append(evicted, mem.Field)
+		}
+	}
+	return evicted, nil
+}
+
+// StartEviction applies the eviction policy every interval in the
+// background until StopEviction is called
+func (im *IndexManager) StartEviction(interval time.Duration) {
+	im.StopEviction()
+
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	im.mutex.Lock()
+	im.evictionDone = done
+	im.mutex.Unlock()
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_, _ = im.EvictCold() // Failed indexes stay resident; retried next tick
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// StopEviction stops background eviction started by StartEviction
+func (im *IndexManager) StopEviction() {
+	im.mutex.Lock()
+	defer im.mutex.Unlock()
+
+	if im.evictionDone != nil {
+		close(im.evictionDone)
+		im.evictionDone = nil
+	}
+}
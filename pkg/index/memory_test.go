@@ -0,0 +1,105 @@
+package index
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/bptree"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecondaryIndex_MemoryAccounting(t *testing.T) {
+	idx := NewSecondaryIndex("age", 4)
+	assert.Equal(t, bptree.NewBPlusTree(4).MemoryUsage(), idx.MemoryUsage(), "an empty index holds only its tree's root")
+
+	require.NoError(t, idx.Insert(30, []byte("user:1")))
+	require.NoError(t, idx.Insert(30, []byte("user:2")))
+	require.NoError(t, idx.Insert(30, []byte("user:2"))) // Re-inserting adds nothing
+	assert.Equal(t, 2, idx.Memory().Entries)
+
+	dir := t.TempDir()
+	require.NoError(t, idx.Save(dir))
+	usage, treeBytes := idx.MemoryUsage(), idx.Memory().TreeBytes
+	assert.Equal(t, usage, treeBytes, "a saved index holds nothing but its tree")
+
+	// The deleted key leaves the tree, though its pending delete takes memory
+	// until the next checkpoint
+	assert.True(t, idx.Delete(30, []byte("user:1")))
+	assert.Equal(t, 1, idx.Memory().Entries)
+	assert.Less(t, idx.Memory().TreeBytes, treeBytes)
+
+	// Loading recounts from the snapshot
+	loaded := NewSecondaryIndex("age", 4)
+	require.NoError(t, loaded.Load(dir))
+	assert.Equal(t, 2, loaded.Memory().Entries)
+	assert.Equal(t, usage, loaded.MemoryUsage())
+}
+
+func TestIndexManager_EvictCold(t *testing.T) {
+	dir := t.TempDir()
+	im := NewIndexManager(4)
+	hot, cold := im.GetOrCreateIndex("hot"), im.GetOrCreateIndex("cold")
+	for _, key := range []string{"a", "b", "c"} {
+		require.NoError(t, hot.Insert(key, []byte("rec:"+key)))
+		require.NoError(t, cold.Insert(key, []byte("rec:"+key)))
+	}
+
+	// Without a policy nothing is evicted
+	evicted, err := im.EvictCold()
+	require.NoError(t, err)
+	assert.Empty(t, evicted)
+
+	// Over the cap, the least recently used index goes first
+	cold.lastUsed.Store(time.Now().Add(-time.Hour).UnixNano())
+	im.SetEvictionPolicy(EvictionPolicy{Dir: dir, MaxBytes: hot.MemoryUsage()})
+	evicted, err = im.EvictCold()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cold"}, evicted)
+	assert.True(t, cold.Evicted())
+	assert.False(t, hot.Evicted())
+
+	stats := im.MemoryStats()
+	require.Len(t, stats, 2)
+	assert.Equal(t, IndexMemory{Field: "cold", State: IndexReady, Entries: 3, LastUsed: stats[0].LastUsed}, stats[0])
+	assert.Equal(t, hot.MemoryUsage(), im.MemoryUsage())
+
+	// The next search reloads it lazily
+	keys, err := cold.Search("b")
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("rec:b")}, keys)
+	assert.False(t, cold.Evicted())
+
+	// Idle indexes are evicted regardless of the cap
+	im.SetEvictionPolicy(EvictionPolicy{Dir: dir, IdleAfter: time.Minute})
+	evicted, err = im.evictColdAt(time.Now().Add(2 * time.Minute))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"hot", "cold"}, evicted)
+
+	// Writes reload too, keeping the entries evicted with the tree
+	require.NoError(t, hot.Insert("d", []byte("rec:d")))
+	entries, err := hot.SearchRangeEntries(nil, nil)
+	require.NoError(t, err)
+	assert.Len(t, entries, 4)
+}
+
+func TestSecondaryIndex_EvictReloadFailure(t *testing.T) {
+	dir := t.TempDir()
+	idx := NewSecondaryIndex("name", 4)
+	require.NoError(t, idx.Insert("alice", []byte("user:1")))
+
+	ok, err := idx.Evict(dir, 0)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NoError(t, os.Truncate(snapshotPath(dir, "name"), 6))
+
+	_, err = idx.Search("alice")
+	assert.ErrorIs(t, err, ErrIndexUnavailable)
+	assert.False(t, idx.Evicted())
+
+	// Unavailable indexes are never evicted; they have nothing trustworthy on disk
+	ok, err = idx.Evict(dir, 0)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
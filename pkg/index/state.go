@@ -0,0 +1,216 @@
+package index
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ssargent/freyjadb/pkg/bptree"
+)
+
+// IndexState reports whether an index can answer searches
+type IndexState int
+
+const (
+	// IndexReady indexes answer searches normally
+	IndexReady IndexState = iota
+	// IndexUnavailable indexes failed to load, e.g. from a corrupt snapshot,
+	// and hold no entries until rebuilt
+	IndexUnavailable
+	// IndexRebuilding indexes are being refilled from the primary store
+	IndexRebuilding
+)
+
+// String returns the state name
+func (s IndexState) String() string {
+	switch s {
+	case IndexReady:
+		return "ready"
+	case IndexUnavailable:
+		return "unavailable"
+	case IndexRebuilding:
+		return "rebuilding"
+	default:
+		return fmt.Sprintf("IndexState(%d)", int(s))
+	}
+}
+
+// ErrIndexUnavailable is returned by searches on an index that is not
+// ready. Query engines fall back to scanning the primary store.
+var ErrIndexUnavailable = errors.New("secondary index unavailable")
+
+// State returns the index state and, for an unavailable index, why it
+// failed to load
+func (idx *SecondaryIndex) State() (IndexState, error) {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	return idx.state, idx.stateErr
+}
+
+// Available reports whether the index can answer searches
+func (idx *SecondaryIndex) Available() bool {
+	state, _ := idx.State()
+	return state == IndexReady
+}
+
+// MarkUnavailable empties the index and stops it answering searches until
+// it is rebuilt
+func (idx *SecondaryIndex) MarkUnavailable(cause error) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	idx.resetInternal()
+	idx.state = IndexUnavailable
+	idx.stateErr = cause
+	idx.touched = nil
+}
+
+// BeginRebuild empties the index so it can be refilled with InsertRebuilt.
+// Inserts and deletes made by writers while the rebuild runs are kept, and
+// take precedence over the rebuild's entries for the same primary key. The
+// rebuild's entries are collected and bulk-loaded into a fresh tree when it
+// finishes, which is much faster than inserting them one at a time.
+func (idx *SecondaryIndex) BeginRebuild() {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	idx.resetInternal()
+	idx.state = IndexRebuilding
+	idx.stateErr = nil
+	idx.touched = make(map[string]struct{})
+}
+
+// InsertRebuilt adds an entry found by a rebuild, unless a writer has
+// changed the record's entry since the rebuild began
+func (idx *SecondaryIndex) InsertRebuilt(fieldValue interface{}, primaryKey []byte) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	idx.addRebuiltInternal(fieldValue, primaryKey, nil)
+}
+
+// rebuiltEntry is an index entry found by a rebuild
+type rebuiltEntry struct {
+	key        []byte
+	primaryKey []byte
+	stored     []byte
+}
+
+// addRebuiltInternal collects an entry found by a rebuild, unless a writer
+// has changed the record's entry since the rebuild began. Outside a rebuild
+// the entry is inserted directly (caller must hold the write lock).
+func (idx *SecondaryIndex) addRebuiltInternal(fieldValue interface{}, primaryKey, stored []byte) {
+	if idx.state != IndexRebuilding {
+		idx.insertInternal(fieldValue, primaryKey, stored)
+		return
+	}
+	if _, ok := idx.touched[string(primaryKey)]; ok {
+		return
+	}
+	idx.rebuilt = append(idx.rebuilt, rebuiltEntry{
+		key:        idx.createIndexKey(fieldValue, primaryKey),
+		primaryKey: append([]byte(nil), primaryKey...),
+		stored:     stored,
+	})
+}
+
+// bulkLoadRebuiltInternal replaces the tree with one bulk-loaded from the
+// rebuild's entries and those writers added while it ran. Entries for
+// records a writer changed after the rebuild read them are dropped, as the
+// writer's own insert or delete is newer (caller must hold the write lock).
+func (idx *SecondaryIndex) bulkLoadRebuiltInternal() error {
+	rebuilt := idx.rebuilt[:0]
+	for _, entry := range idx.rebuilt {
+		if _, ok := idx.touched[string(entry.primaryKey)]; !ok {
+			rebuilt = append(rebuilt, entry)
+		}
+	}
+	// Stable, so the last read of a key wins as it would with Insert
+	sort.SliceStable(rebuilt, func(i, j int) bool {
+		return bytes.Compare(rebuilt[i].key, rebuilt[j].key) < 0
+	})
+
+	var written []bptree.Pair
+	idx.tree.Ascend(nil, nil, func(key, value []byte) bool {
+		written = append(written, bptree.Pair{Key: key, Value: value})
+		return true
+	})
+
+	// Merge the two sorted runs; a writer's entry for the same key is kept
+	pairs := make([]bptree.Pair, 0, len(rebuilt)+len(written))
+	for i := 0; i < len(rebuilt); i++ {
+		if i+1 < len(rebuilt) && bytes.Equal(rebuilt[i].key, rebuilt[i+1].key) {
+			continue
+		}
+		entry := rebuilt[i]
+		for len(written) > 0 && bytes.Compare(written[0].Key, entry.key) < 0 {
+			pairs = append(pairs, written[0])
+			written = written[1:]
+		}
+		if len(written) > 0 && bytes.Equal(written[0].Key, entry.key) {
+			continue
+		}
+		pairs = append(pairs, bptree.Pair{Key: entry.key, Value: entryValue(entry.key, entry.primaryKey)})
+		if entry.stored != nil {
+			idx.setStoredInternal(string(entry.key), entry.stored)
+		}
+	}
+	pairs = append(pairs, written...)
+
+	tree, err := bptree.BulkLoad(idx.order, pairs, bptree.DefaultFillFactor)
+	if err != nil {
+		return err
+	}
+	idx.tree = tree
+	idx.rebuilt = nil
+	return nil
+}
+
+// FinishRebuild makes a rebuilt index answer searches again. If err is not
+// nil the rebuild failed and the index is left unavailable. The next
+// checkpoint writes a full snapshot, replacing whatever was on disk.
+func (idx *SecondaryIndex) FinishRebuild(err error) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	if err == nil {
+		err = idx.bulkLoadRebuiltInternal()
+	}
+	idx.touched = nil
+	if err != nil {
+		idx.resetInternal()
+		idx.state = IndexUnavailable
+		idx.stateErr = fmt.Errorf("rebuild failed: %w", err)
+		return
+	}
+	idx.state = IndexReady
+	idx.stateErr = nil
+}
+
+// checkAvailableInternal returns ErrIndexUnavailable unless the index is
+// ready (caller must hold the lock)
+func (idx *SecondaryIndex) checkAvailableInternal() error {
+	if idx.state == IndexReady {
+		return nil
+	}
+	if idx.stateErr != nil {
+		return fmt.Errorf("index for field %s is %s (%v): %w",
+			idx.fieldName, idx.state, idx.stateErr, ErrIndexUnavailable)
+	}
+	return fmt.Errorf("index for field %s is %s: %w", idx.fieldName, idx.state, ErrIndexUnavailable)
+}
+
+// resetInternal drops every entry; the next checkpoint must then write a
+// full snapshot (caller must hold the write lock)
+func (idx *SecondaryIndex) resetInternal() {
+	idx.tree = bptree.NewBPlusTree(idx.order)
+	idx.pending = nil
+	idx.needsSnapshot = true
+	idx.entries = 0
+	idx.stored = nil
+	idx.storedBytes = 0
+	idx.evicted = false
+	idx.rebuilt = nil
+}
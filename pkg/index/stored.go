@@ -0,0 +1,186 @@
+package index
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// storedOverhead estimates the memory of a stored-field copy beyond its
+// encoded size: the map entry, its key header and the slice header
+const storedOverhead = int64(24 + 24 + 16)
+
+// storedPath returns the file holding the stored fields of a snapshot
+func storedPath(dir, fieldName string) string {
+	return filepath.Join(dir, fmt.Sprintf("index_%s.stored", fieldName))
+}
+
+// SetStoredFields sets the fields whose values each entry keeps a copy of,
+// so list and search results can be rendered from the index without
+// fetching and parsing whole records. Choose small fields, e.g. a name or
+// status; their copies count toward the index's memory. Copies are taken
+// from the record by whoever maintains the index (see InsertStored and the
+// query engine's IndexRecord), so the setting applies to entries written
+// after it. It isn't persisted: set it when the index is set up, as with
+// the fields an application indexes.
+func (idx *SecondaryIndex) SetStoredFields(fields ...string) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	idx.storedFields = append([]string(nil), fields...)
+}
+
+// StoredFields returns the fields entries keep a copy of
+func (idx *SecondaryIndex) StoredFields() []string {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	return append([]string(nil), idx.storedFields...)
+}
+
+// InsertStored adds a record to the index along with copies of its stored
+// fields, replacing the copies an existing entry for the same field value
+// and primary key held. Values must encode as JSON.
+func (idx *SecondaryIndex) InsertStored(fieldValue interface{}, primaryKey []byte, stored map[string]interface{}) error {
+	encoded, err := encodeStored(stored)
+	if err != nil {
+		return err
+	}
+
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	idx.touch()
+	idx.ensureResidentInternal()
+	if idx.touched != nil {
+		idx.touched[string(primaryKey)] = struct{}{}
+	}
+	idx.insertInternal(fieldValue, primaryKey, encoded)
+	return nil
+}
+
+// InsertRebuiltStored is InsertRebuilt with copies of the record's stored
+// fields
+func (idx *SecondaryIndex) InsertRebuiltStored(fieldValue interface{}, primaryKey []byte,
+	stored map[string]interface{}) error {
+	encoded, err := encodeStored(stored)
+	if err != nil {
+		return err
+	}
+
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	idx.addRebuiltInternal(fieldValue, primaryKey, encoded)
+	return nil
+}
+
+// encodeStored encodes stored-field copies, or returns nil if there are none
+func encodeStored(stored map[string]interface{}) ([]byte, error) {
+	if len(stored) == 0 {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(stored)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode stored fields: %w", err)
+	}
+	return encoded, nil
+}
+
+// setStoredInternal replaces the stored fields of an index key; nil removes
+// them (caller must hold the write lock)
+func (idx *SecondaryIndex) setStoredInternal(indexKey string, encoded []byte) {
+	if old, ok := idx.stored[indexKey]; ok {
+		idx.storedBytes -= int64(len(old)) + storedOverhead
+		delete(idx.stored, indexKey)
+	}
+	if encoded == nil {
+		return
+	}
+	if idx.stored == nil {
+		idx.stored = make(map[string][]byte)
+	}
+	idx.stored[indexKey] = encoded
+	idx.storedBytes += int64(len(encoded)) + storedOverhead
+}
+
+// storedInternal decodes the stored fields of an index key, or returns nil
+// if it has none (caller must hold the lock)
+func (idx *SecondaryIndex) storedInternal(indexKey []byte) (map[string]interface{}, error) {
+	encoded, ok := idx.stored[string(indexKey)]
+	if !ok {
+		return nil, nil
+	}
+	var stored map[string]interface{}
+	if err := json.Unmarshal(encoded, &stored); err != nil {
+		return nil, fmt.Errorf("failed to decode stored fields: %w", err)
+	}
+	return stored, nil
+}
+
+// saveStoredInternal writes the stored fields of a snapshot to filename and
+// fsyncs it (caller must hold the write lock). With no stored fields, the
+// file is written empty if always is set and removed otherwise.
+func (idx *SecondaryIndex) saveStoredInternal(filename string, always bool) error {
+	if len(idx.stored) == 0 && !always {
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove stored fields: %w", err)
+		}
+		return nil
+	}
+
+	ops := make([]indexOp, 0, len(idx.stored))
+	for key, encoded := range idx.stored {
+		ops = append(ops, indexOp{kind: opPutStored, key: []byte(key), stored: encoded})
+	}
+	tmp := filename + ".tmp"
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := appendOpLog(tmp, ops); err != nil {
+		return fmt.Errorf("failed to save stored fields: %w", err)
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return fmt.Errorf("failed to install stored fields: %w", err)
+	}
+	return nil
+}
+
+// loadStoredInternal reads the stored fields saved with the snapshot in
+// dir, keeping those whose entry is in the tree (caller must hold the write
+// lock). Unlike the op log, the file is written whole, so any damage fails
+// the load.
+func (idx *SecondaryIndex) loadStoredInternal(dir string) error {
+	idx.stored = nil
+	idx.storedBytes = 0
+
+	file, err := os.Open(filepath.Clean(storedPath(dir, idx.fieldName)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		op, err := readOp(reader)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read stored fields: %w", err)
+		}
+		if !op.hasStored() {
+			return fmt.Errorf("failed to read stored fields: %w", errOpLogCorrupt)
+		}
+		if _, ok := idx.tree.Search(op.key); ok {
+			idx.setStoredInternal(string(op.key), op.stored)
+		}
+	}
+}
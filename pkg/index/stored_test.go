@@ -0,0 +1,72 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecondaryIndex_StoredFields(t *testing.T) {
+	tmpDir := t.TempDir()
+	idx := NewSecondaryIndex("status", 4)
+	idx.SetStoredFields("name")
+	assert.Equal(t, []string{"name"}, idx.StoredFields())
+
+	before := idx.MemoryUsage()
+	require.NoError(t, idx.InsertStored("active", []byte("user:1"), map[string]interface{}{"name": "Alice"}))
+	require.NoError(t, idx.InsertStored("active", []byte("user:2"), map[string]interface{}{"name": "Bob"}))
+	require.NoError(t, idx.Insert("inactive", []byte("user:3")))
+	assert.Greater(t, idx.MemoryUsage(), before)
+
+	entries, err := idx.SearchEntries("active")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, map[string]interface{}{"name": "Alice"}, entries[0].Stored)
+
+	// Re-inserting replaces the copies; deleting drops them
+	require.NoError(t, idx.InsertStored("active", []byte("user:1"), map[string]interface{}{"name": "Alicia"}))
+	assert.True(t, idx.Delete("active", []byte("user:2")))
+	require.NoError(t, idx.Checkpoint(tmpDir, DefaultCheckpointLogLimit))
+
+	check := func(idx *SecondaryIndex) {
+		t.Helper()
+		entries, err := idx.SearchRangeEntries(nil, nil)
+		require.NoError(t, err)
+		assert.Equal(t, []Entry{
+			{FieldValue: "active", PrimaryKey: []byte("user:1"), Stored: map[string]interface{}{"name": "Alicia"}},
+			{FieldValue: "inactive", PrimaryKey: []byte("user:3")},
+		}, entries)
+	}
+
+	// Copies survive op log replay and snapshots
+	replayed := NewSecondaryIndex("status", 4)
+	require.NoError(t, replayed.Load(tmpDir))
+	check(replayed)
+
+	require.NoError(t, idx.Save(tmpDir))
+	assert.FileExists(t, filepath.Join(tmpDir, "index_status.stored"))
+	assert.NoFileExists(t, filepath.Join(tmpDir, "index_status.oplog"))
+	loaded := NewSecondaryIndex("status", 4)
+	require.NoError(t, loaded.Load(tmpDir))
+	check(loaded)
+
+	// And eviction
+	evicted, err := idx.Evict(tmpDir, DefaultCheckpointLogLimit)
+	require.NoError(t, err)
+	assert.True(t, evicted)
+	check(idx)
+
+	// A damaged stored-field file fails the load, so the index is rebuilt
+	require.NoError(t, os.Truncate(filepath.Join(tmpDir, "index_status.stored"), 10))
+	assert.Error(t, NewSecondaryIndex("status", 4).Load(tmpDir))
+
+	// Without copies the file is removed
+	assert.True(t, idx.Delete("active", []byte("user:1")))
+	require.NoError(t, idx.Save(tmpDir))
+	assert.NoFileExists(t, filepath.Join(tmpDir, "index_status.stored"))
+
+	assert.Error(t, idx.InsertStored("active", []byte("user:4"), map[string]interface{}{"bad": make(chan int)}))
+}
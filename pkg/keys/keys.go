@@ -0,0 +1,92 @@
+// Package keys builds and parses FreyjaDB keys.
+//
+// Keys are made of parts joined by Separator, starting with the name of
+// their keyspace: "apikey:ci", "relationship:forward:user|1:follows:user|2".
+// The hash index interns everything up to the last separator, so keys built
+// this way share their prefixes in memory as well as in prefix scans.
+//
+// Parts are joined verbatim. Parts that may themselves contain Separator,
+// such as user keys embedded in relationship keys, must be passed through
+// Escape first so the key can be split again.
+package keys
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Separator joins the parts of a key
+const Separator = ":"
+
+// escapedSeparator replaces Separator inside escaped parts
+const escapedSeparator = "|"
+
+// Compose joins parts into a key, e.g. Compose("user", id)
+func Compose(parts ...string) string {
+	return strings.Join(parts, Separator)
+}
+
+// Split returns the parts of a key
+func Split(key string) []string {
+	return strings.Split(key, Separator)
+}
+
+// Namespace returns the first part of a key, which names its keyspace
+func Namespace(key string) string {
+	namespace, _, _ := strings.Cut(key, Separator)
+	return namespace
+}
+
+// Escape makes a part safe to embed in a key by replacing Separator
+func Escape(part string) string {
+	return strings.ReplaceAll(part, Separator, escapedSeparator)
+}
+
+// Unescape restores a part escaped with Escape
+func Unescape(part string) string {
+	return strings.ReplaceAll(part, escapedSeparator, Separator)
+}
+
+// Keyspace is a family of keys sharing a leading name, such as "apikey"
+type Keyspace string
+
+// Key builds the key of the keyspace made of parts
+func (ks Keyspace) Key(parts ...string) string {
+	return Compose(append([]string{string(ks)}, parts...)...)
+}
+
+// Bytes is Key as a byte slice, ready for the store
+func (ks Keyspace) Bytes(parts ...string) []byte {
+	return []byte(ks.Key(parts...))
+}
+
+// Prefix returns the scan prefix of every key of the keyspace whose leading
+// parts are parts. It ends with Separator, so Prefix("user|1") does not
+// match keys under "user|10".
+func (ks Keyspace) Prefix(parts ...string) string {
+	return ks.Key(parts...) + Separator
+}
+
+// Contains reports whether key belongs to the keyspace
+func (ks Keyspace) Contains(key string) bool {
+	return strings.HasPrefix(key, string(ks)+Separator)
+}
+
+// Trim returns what follows the keyspace name in key, reporting whether
+// key belongs to the keyspace
+func (ks Keyspace) Trim(key string) (string, bool) {
+	return strings.CutPrefix(key, string(ks)+Separator)
+}
+
+// Parse splits a key of the keyspace into exactly n parts after its name
+func (ks Keyspace) Parse(key string, n int) ([]string, error) {
+	rest, ok := ks.Trim(key)
+	if !ok {
+		return nil, fmt.Errorf("key %q is not in keyspace %s", key, string(ks))
+	}
+	parts := Split(rest)
+	if len(parts) != n {
+		return nil, fmt.Errorf("key %q has %d parts, expected %d", key, len(parts), n)
+	}
+	return parts, nil
+}
@@ -0,0 +1,88 @@
+package keys
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestKeyspace(t *testing.T) {
+	relationships := Keyspace("relationship")
+
+	key := relationships.Key("forward", Escape("user:1"), "follows", Escape("user:2"))
+	if key != "relationship:forward:user|1:follows:user|2" {
+		t.Fatalf("Unexpected key %q", key)
+	}
+	if !relationships.Contains(key) || Namespace(key) != "relationship" {
+		t.Errorf("Expected %q to be in the relationship keyspace", key)
+	}
+
+	parts, err := relationships.Parse(key, 4)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if Unescape(parts[1]) != "user:1" || parts[2] != "follows" || Unescape(parts[3]) != "user:2" {
+		t.Errorf("Unexpected parts %q", parts)
+	}
+	if _, err := relationships.Parse(key, 3); err == nil {
+		t.Error("Expected an error for the wrong number of parts")
+	}
+	if _, err := Keyspace("apikey").Parse(key, 4); err == nil {
+		t.Error("Expected an error for a key from another keyspace")
+	}
+
+	// Prefixes end at a part boundary
+	prefix := relationships.Prefix("forward", Escape("user:1"))
+	if prefix != "relationship:forward:user|1:" {
+		t.Errorf("Unexpected prefix %q", prefix)
+	}
+	if id, ok := Keyspace("apikey").Trim("apikey:ci"); !ok || id != "ci" {
+		t.Errorf("Expected to trim apikey:ci to ci, got %q, %v", id, ok)
+	}
+	if Keyspace("apikey").Contains("apikeys:ci") {
+		t.Error("Keyspaces must not match keys that only share a name prefix")
+	}
+	if got := Compose("user", "42"); got != "user:42" {
+		t.Errorf("Expected user:42, got %q", got)
+	}
+}
+
+func TestULID(t *testing.T) {
+	now := time.UnixMilli(1_700_000_000_123)
+
+	ids := make([]string, 0, 100)
+	for i := 0; i < 100; i++ {
+		id := newULID(now) // Same millisecond: still strictly increasing
+		ids = append(ids, id.String())
+
+		parsed, err := ParseULID(id.String())
+		if err != nil || parsed != id {
+			t.Fatalf("Round trip of %s failed: %v, %v", id, parsed, err)
+		}
+	}
+	if !sort.StringsAreSorted(ids) {
+		t.Error("Expected ULID strings to sort in creation order")
+	}
+	for i := 1; i < len(ids); i++ {
+		if ids[i] == ids[i-1] {
+			t.Fatalf("Duplicate ULID %s", ids[i])
+		}
+	}
+
+	id, _ := ParseULID(ids[0])
+	if !id.Time().Equal(now) {
+		t.Errorf("Expected time %v, got %v", now, id.Time())
+	}
+	if later := NewULID(); later.Compare(id) <= 0 || len(later.String()) != 26 {
+		t.Errorf("Expected %s to sort after %s", later, id)
+	}
+
+	for _, bad := range []string{"", "01ARZ3NDEKTSV4RRFFQ69G5FAVX", "01ARZ3NDEKTSV4RRFFQ69G5FAU", "81ARZ3NDEKTSV4RRFFQ69G5FAV"} {
+		if _, err := ParseULID(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+	if _, err := ParseULID("01arz3ndektsv4rrffq69g5fav"); err != nil {
+		t.Errorf("Expected lowercase ULIDs to parse, got %v", err)
+	}
+}
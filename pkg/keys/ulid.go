@@ -0,0 +1,129 @@
+package keys
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+// ULID is a 128-bit identifier whose first 48 bits are a millisecond Unix
+// timestamp and whose remaining 80 bits are random. Its 26-character
+// string form sorts in creation order, which keeps keys built from ULIDs
+// clustered by time in prefix scans.
+type ULID [16]byte
+
+// crockford is the base32 alphabet of ULID strings
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulidLength is the length of a ULID string
+const ulidLength = 26
+
+// ulidGenerator makes ULIDs from one process increase strictly, even when
+// several are created in the same millisecond
+var ulidGenerator struct {
+	mutex sync.Mutex
+	last  ULID
+}
+
+// NewULID returns a new ULID for the current time. ULIDs created by this
+// process always sort after the ones created before them.
+func NewULID() ULID {
+	return newULID(time.Now())
+}
+
+// newULID returns a ULID for t that sorts after every previous one
+func newULID(t time.Time) ULID {
+	var id ULID
+	ms := uint64(t.UnixMilli()) //nolint:gosec // ULIDs only cover times after 1970
+	id[0], id[1] = byte(ms>>40), byte(ms>>32)
+	binary.BigEndian.PutUint32(id[2:6], uint32(ms)) //nolint:gosec // low 32 bits of the timestamp
+	if _, err := rand.Read(id[6:]); err != nil {
+		panic(fmt.Sprintf("keys: reading random bytes: %v", err))
+	}
+
+	ulidGenerator.mutex.Lock()
+	defer ulidGenerator.mutex.Unlock()
+
+	// Within the same millisecond (or if the clock went back), increment
+	// the previous ULID instead of using fresh randomness
+	if id.Compare(ulidGenerator.last) <= 0 {
+		id = ulidGenerator.last
+		for i := len(id) - 1; i >= 0; i-- {
+			id[i]++
+			if id[i] != 0 {
+				break
+			}
+		}
+	}
+	ulidGenerator.last = id
+	return id
+}
+
+// ParseULID parses the string form of a ULID, accepting either case
+func ParseULID(s string) (ULID, error) {
+	var id ULID
+	if len(s) != ulidLength {
+		return id, fmt.Errorf("invalid ULID %q: expected %d characters", s, ulidLength)
+	}
+	// The first character only carries 3 bits of the 128
+	if s[0] > '7' {
+		return id, fmt.Errorf("invalid ULID %q: timestamp overflows 48 bits", s)
+	}
+
+	var hi, lo uint64 // 128-bit accumulator
+	for _, c := range strings.ToUpper(s) {
+		v := strings.IndexRune(crockford, c)
+		if v < 0 {
+			return id, fmt.Errorf("invalid ULID %q: bad character %q", s, c)
+		}
+		hi = hi<<5 | lo>>59
+		lo = lo<<5 | uint64(v)
+	}
+	binary.BigEndian.PutUint64(id[:8], hi)
+	binary.BigEndian.PutUint64(id[8:], lo)
+	return id, nil
+}
+
+// String returns the 26-character Crockford base32 form of the ULID
+func (id ULID) String() string {
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+
+	var out [ulidLength]byte
+	for i := ulidLength - 1; i >= 0; i-- {
+		out[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+// Time returns the creation time encoded in the ULID
+func (id ULID) Time() time.Time {
+	ms := uint64(id[0])<<40 | uint64(id[1])<<32 | uint64(binary.BigEndian.Uint32(id[2:6]))
+	return time.UnixMilli(int64(ms)) //nolint:gosec // 48-bit timestamp always fits
+}
+
+// Compare orders ULIDs by creation time, returning -1, 0 or 1
+func (id ULID) Compare(other ULID) int {
+	for i := range id {
+		switch {
+		case id[i] < other[i]:
+			return -1
+		case id[i] > other[i]:
+			return 1
+		}
+	}
+	return 0
+}
+
+// NewKSUID returns a new KSUID string. KSUIDs are longer than ULIDs and sort
+// by creation time only to the second.
+func NewKSUID() string {
+	return ksuid.New().String()
+}
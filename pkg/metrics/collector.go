@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// Categories of the freyja_db_keys and freyja_db_bytes metrics
+const (
+	CategoryUser      = "user"
+	CategoryInternal  = "internal"
+	CategoryTombstone = "tombstone"
+)
+
+// StatsSource is a store whose statistics a Collector exports.
+// *store.KVStore implements it.
+type StatsSource interface {
+	Stats() *store.StoreStats
+}
+
+// Collector is a prometheus.Collector that reads a store's statistics at
+// every scrape and exports them as freyja_db_* metrics
+type Collector struct {
+	source StatsSource
+
+	keysTotal       *prometheus.Desc
+	dataSizeBytes   *prometheus.Desc
+	liveDataBytes   *prometheus.Desc
+	readRepairs     *prometheus.Desc
+	keysByCategory  *prometheus.Desc
+	bytesByCategory *prometheus.Desc
+	dedupedWrites   *prometheus.Desc
+	fsyncs          *prometheus.Desc
+	memtableBytes   *prometheus.Desc
+	memtableFlushes *prometheus.Desc
+	prefixWrites    *prometheus.Desc
+	prefixThrottled *prometheus.Desc
+	prefixLimit     *prometheus.Desc
+}
+
+// NewCollector returns a collector of source's statistics, to register
+// with a prometheus.Registerer. Stats is called once per scrape.
+func NewCollector(source StatsSource) *Collector {
+	return &Collector{
+		source: source,
+		keysTotal: prometheus.NewDesc("freyja_db_keys_total",
+			"Total number of keys in the database", nil, nil),
+		dataSizeBytes: prometheus.NewDesc("freyja_db_data_size_bytes",
+			"Total size of data in the database in bytes", nil, nil),
+		liveDataBytes: prometheus.NewDesc("freyja_db_live_data_size_bytes",
+			"Bytes of live records; the rest of the data size is reclaimable by compaction", nil, nil),
+		readRepairs: prometheus.NewDesc("freyja_db_read_repairs",
+			"Index entries repaired by reads since the store was opened, by result", []string{"result"}, nil),
+		keysByCategory: prometheus.NewDesc("freyja_db_keys",
+			"Keys in the database by category: user, internal, or tombstone records awaiting compaction",
+			[]string{"category"}, nil),
+		bytesByCategory: prometheus.NewDesc("freyja_db_bytes",
+			"Log bytes held by user records, internal records and tombstones", []string{"category"}, nil),
+		dedupedWrites: prometheus.NewDesc("freyja_db_deduped_writes_total",
+			"Puts skipped because the key already held the value", nil, nil),
+		fsyncs: prometheus.NewDesc("freyja_db_fsyncs_total",
+			"Fsyncs of the active log since the store was opened", nil, nil),
+		memtableBytes: prometheus.NewDesc("freyja_db_memtable_bytes",
+			"Record bytes held by the memtable", nil, nil),
+		memtableFlushes: prometheus.NewDesc("freyja_db_memtable_flushes_total",
+			"Memtable flushes since the store was opened", nil, nil),
+		prefixWrites: prometheus.NewDesc("freyja_db_prefix_writes",
+			"Writes to the busiest key prefixes over a rolling window: 1m, 5m or 1h",
+			[]string{"prefix", "window"}, nil),
+		prefixThrottled: prometheus.NewDesc("freyja_db_prefix_writes_throttled_total",
+			"Writes rejected by a key prefix's write limit since the store was opened", []string{"prefix"}, nil),
+		prefixLimit: prometheus.NewDesc("freyja_db_prefix_write_limit",
+			"Writes a minute a limited key prefix is allowed", []string{"prefix"}, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.keysTotal
+	ch <- c.dataSizeBytes
+	ch <- c.liveDataBytes
+	ch <- c.readRepairs
+	ch <- c.keysByCategory
+	ch <- c.bytesByCategory
+	ch <- c.dedupedWrites
+	ch <- c.fsyncs
+	ch <- c.memtableBytes
+	ch <- c.memtableFlushes
+	ch <- c.prefixWrites
+	ch <- c.prefixThrottled
+	ch <- c.prefixLimit
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.source.Stats()
+	if stats == nil {
+		return
+	}
+
+	gauge := func(desc *prometheus.Desc, v float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, labels...)
+	}
+	counter := func(desc *prometheus.Desc, v float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, v, labels...)
+	}
+
+	gauge(c.keysTotal, float64(stats.Keys))
+	gauge(c.dataSizeBytes, float64(stats.DataSize))
+	gauge(c.liveDataBytes, float64(stats.LiveDataSize))
+	gauge(c.readRepairs, float64(stats.ReadRepairs), "repaired")
+	gauge(c.readRepairs, float64(stats.ReadRepairFailures), "failed")
+	gauge(c.keysByCategory, float64(stats.UserKeys), CategoryUser)
+	gauge(c.keysByCategory, float64(stats.InternalKeys), CategoryInternal)
+	gauge(c.keysByCategory, float64(stats.Tombstones), CategoryTombstone)
+	gauge(c.bytesByCategory, float64(stats.UserBytes), CategoryUser)
+	gauge(c.bytesByCategory, float64(stats.InternalBytes), CategoryInternal)
+	gauge(c.bytesByCategory, float64(stats.TombstoneBytes), CategoryTombstone)
+	counter(c.dedupedWrites, float64(stats.DedupedWrites))
+	counter(c.fsyncs, float64(stats.Fsyncs))
+	gauge(c.memtableBytes, float64(stats.MemtableBytes))
+	counter(c.memtableFlushes, float64(stats.MemtableFlushes))
+	for _, p := range stats.PrefixWrites {
+		gauge(c.prefixWrites, float64(p.LastMinute), p.Prefix, "1m")
+		gauge(c.prefixWrites, float64(p.Last5Minutes), p.Prefix, "5m")
+		gauge(c.prefixWrites, float64(p.LastHour), p.Prefix, "1h")
+		if p.LimitPerMinute > 0 {
+			counter(c.prefixThrottled, float64(p.Throttled), p.Prefix)
+			gauge(c.prefixLimit, float64(p.LimitPerMinute), p.Prefix)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+// Package metrics exports a FreyjaDB store's metrics to Prometheus without
+// the HTTP server. StatsRecorder receives the store's operation counts and
+// latencies as they happen (set it as KVStoreConfig.Stats), and Collector
+// reads the store's gauges, such as key counts and data size, from Stats()
+// at every scrape. Register both with any prometheus.Registerer and serve
+// it from your own HTTP server; pkg/api uses the same definitions for the
+// server's /metrics endpoint.
+package metrics
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsRecorder(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	recorder := NewStatsRecorder(reg)
+
+	recorder.Count(store.StatPuts, 2)
+	recorder.Count(store.StatPuts, 1)
+	recorder.Observe(store.StatWriteSeconds, 0.002)
+	recorder.Count("unknown", 5) // Ignored
+
+	assert.Equal(t, 3.0, testutil.ToFloat64(recorder.counters[store.StatPuts]))
+	count, err := testutil.GatherAndCount(reg)
+	require.NoError(t, err)
+	assert.Equal(t, len(store.StatCounters)+len(store.StatHistograms), count)
+}
+
+func TestCollector(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	kv, err := store.NewKVStore(store.KVStoreConfig{
+		DataDir:     t.TempDir(),
+		Stats:       NewStatsRecorder(reg),
+		WriteLimits: []store.PrefixWriteLimit{{Prefix: "job:", MaxPerMinute: 1}},
+	})
+	require.NoError(t, err)
+	_, err = kv.Open()
+	require.NoError(t, err)
+	defer kv.Close()
+	reg.MustRegister(NewCollector(kv))
+
+	require.NoError(t, kv.Put([]byte("user:1"), []byte("alice")))
+	require.NoError(t, kv.Put([]byte("user:2"), []byte("bob")))
+	require.NoError(t, kv.Delete([]byte("user:2")))
+	require.NoError(t, kv.Put([]byte("job:1"), []byte("run")))
+	require.ErrorIs(t, kv.Put([]byte("job:2"), []byte("run")), store.ErrWriteRateExceeded)
+
+	expected := `
+# HELP freyja_db_keys Keys in the database by category: user, internal, or tombstone records awaiting compaction
+# TYPE freyja_db_keys gauge
+freyja_db_keys{category="internal"} 0
+freyja_db_keys{category="tombstone"} 1
+freyja_db_keys{category="user"} 2
+# HELP freyja_db_keys_total Total number of keys in the database
+# TYPE freyja_db_keys_total gauge
+freyja_db_keys_total 2
+# HELP freyja_store_puts_total Total number of records appended by store writes
+# TYPE freyja_store_puts_total counter
+freyja_store_puts_total 3
+# HELP freyja_db_prefix_writes Writes to the busiest key prefixes over a rolling window: 1m, 5m or 1h
+# TYPE freyja_db_prefix_writes gauge
+freyja_db_prefix_writes{prefix="job:",window="1h"} 1
+freyja_db_prefix_writes{prefix="job:",window="1m"} 1
+freyja_db_prefix_writes{prefix="job:",window="5m"} 1
+freyja_db_prefix_writes{prefix="user:",window="1h"} 3
+freyja_db_prefix_writes{prefix="user:",window="1m"} 3
+freyja_db_prefix_writes{prefix="user:",window="5m"} 3
+# HELP freyja_db_prefix_writes_throttled_total Writes rejected by a key prefix's write limit since the store was opened
+# TYPE freyja_db_prefix_writes_throttled_total counter
+freyja_db_prefix_writes_throttled_total{prefix="job:"} 1
+`
+	err = testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"freyja_db_keys", "freyja_db_keys_total", "freyja_store_puts_total",
+		"freyja_db_prefix_writes", "freyja_db_prefix_writes_throttled_total")
+	assert.NoError(t, err)
+
+	// Several stores share a registry under distinguishing labels
+	shared := prometheus.NewRegistry()
+	for _, name := range []string{"a", "b"} {
+		wrapped := prometheus.WrapRegistererWith(prometheus.Labels{"store": name}, shared)
+		assert.NoError(t, wrapped.Register(NewCollector(kv)))
+	}
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// statHelp describes the store's operation metrics for Prometheus
+var statHelp = map[string]string{
+	store.StatGets:         "Total number of store Get calls",
+	store.StatGetMisses:    "Total number of store Gets of missing or deleted keys",
+	store.StatPuts:         "Total number of records appended by store writes",
+	store.StatDeletes:      "Total number of tombstones appended by store deletes",
+	store.StatBytesRead:    "Total record bytes read by store Gets",
+	store.StatBytesWritten: "Total record bytes appended to the store log",
+	store.StatGetSeconds:   "Store Get latency in seconds",
+	store.StatWriteSeconds: "Store write latency in seconds, including the wait for durability",
+}
+
+// StatsRecorder is a store.StatsRecorder that exports the store's counters
+// as freyja_store_<name>_total and its histograms as freyja_store_<name>
+type StatsRecorder struct {
+	counters   map[string]prometheus.Counter
+	histograms map[string]prometheus.Histogram
+}
+
+// NewStatsRecorder registers a metric with reg for every name in
+// store.StatCounters and store.StatHistograms. To export several stores
+// through one registry, register each store's recorder and Collector with
+// prometheus.WrapRegistererWith and a label that tells them apart.
+func NewStatsRecorder(reg prometheus.Registerer) *StatsRecorder {
+	factory := promauto.With(reg)
+	r := &StatsRecorder{
+		counters:   make(map[string]prometheus.Counter, len(store.StatCounters)),
+		histograms: make(map[string]prometheus.Histogram, len(store.StatHistograms)),
+	}
+	for _, name := range store.StatCounters {
+		r.counters[name] = factory.NewCounter(prometheus.CounterOpts{
+			Name: "freyja_store_" + name + "_total",
+			Help: statHelp[name],
+		})
+	}
+	for _, name := range store.StatHistograms {
+		r.histograms[name] = factory.NewHistogram(prometheus.HistogramOpts{
+			Name:    "freyja_store_" + name,
+			Help:    statHelp[name],
+			Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10), // 10us to ~2.6s
+		})
+	}
+	return r
+}
+
+// Count implements store.StatsRecorder; unknown names are ignored
+func (r *StatsRecorder) Count(name string, delta int64) {
+	if c, ok := r.counters[name]; ok {
+		c.Add(float64(delta))
+	}
+}
+
+// Observe implements store.StatsRecorder; unknown names are ignored
+func (r *StatsRecorder) Observe(name string, value float64) {
+	if h, ok := r.histograms[name]; ok {
+		h.Observe(value)
+	}
+}
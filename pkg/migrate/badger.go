@@ -0,0 +1,53 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// badgerSource reads a Badger database
+type badgerSource struct {
+	db *badger.DB
+}
+
+// OpenBadger opens a Badger v4 directory read-only. It fails if another
+// process has the database open, or if it wasn't closed cleanly and needs
+// replaying; open and close it with Badger first in that case. Only the
+// latest version of each key is read, and expired keys are left out.
+func OpenBadger(dir string) (Source, error) {
+	db, err := badger.Open(badger.DefaultOptions(dir).WithReadOnly(true).WithLogger(nil))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open badger database: %w", err)
+	}
+	return &badgerSource{db: db}, nil
+}
+
+// Each iterates the keys in order in a single read transaction
+func (s *badgerSource) Each(ctx context.Context, fn func(key, value []byte) error) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			item := it.Item()
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return fmt.Errorf("failed to read value of %q: %w", item.Key(), err)
+			}
+			if err := fn(item.KeyCopy(nil), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close closes the database
+func (s *badgerSource) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,282 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tombstone values of Riak's Bitcask. Version 2 tombstones are followed by
+// the id of the file the deleted value was in.
+var (
+	riakTombstone   = []byte("bitcask_tombstone")
+	riakTombstoneV2 = []byte("bitcask_tombstone2")
+)
+
+// Record headers of the two Bitcask layouts
+const (
+	riakHeaderSize     = 4 + 4 + 2 + 4 // CRC, timestamp, key size, value size
+	prologicHeaderSize = 4 + 8         // Key size, value size
+	prologicFooterSize = 4 + 8         // CRC of the value, expiry
+)
+
+// bitcaskLayout is one of the on-disk layouts OpenBitcask reads
+type bitcaskLayout struct {
+	name string
+
+	// fileID returns the id of a data file of this layout
+	fileID func(name string) (int, bool)
+
+	// next reads the record at offset, returning its key, where its value
+	// starts and how long it is, whether it deletes the key, and the
+	// offset of the next record
+	next func(r io.ReaderAt, offset int64) (bitcaskRecord, error)
+}
+
+// bitcaskRecord is a record of a Bitcask data file, without its value
+type bitcaskRecord struct {
+	key       []byte
+	valueAt   int64
+	valueSize int64
+	deleted   bool
+	end       int64
+}
+
+// bitcaskLayouts are tried in order; the first with data files wins
+var bitcaskLayouts = []bitcaskLayout{
+	{name: "riak", fileID: riakFileID, next: nextRiakRecord},
+	{name: "prologic", fileID: prologicFileID, next: nextPrologicRecord},
+}
+
+// bitcaskLocation is where the latest value of a key is
+type bitcaskLocation struct {
+	file      int // Index into bitcaskSource.files
+	valueAt   int64
+	valueSize int64
+}
+
+// bitcaskSource reads a Bitcask directory
+type bitcaskSource struct {
+	files  []*os.File
+	keydir map[string]bitcaskLocation
+}
+
+// OpenBitcask opens a Bitcask directory that no process is writing to. It
+// reads both Riak's layout (N.bitcask.data files) and that of the Go
+// library github.com/prologic/bitcask 1.x (000000000.data files). Hint
+// files are ignored: every data file is read in id order to find the
+// latest value of each key, so the keys, but not the values, are held in
+// memory. A record torn by a crash at the end of a file ends that file, as
+// it would for Bitcask itself; one that fails its checksum elsewhere fails
+// the open.
+func OpenBitcask(dir string) (Source, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bitcask directory: %w", err)
+	}
+
+	for _, layout := range bitcaskLayouts {
+		type dataFile struct {
+			id   int
+			name string
+		}
+		var found []dataFile
+		for _, entry := range entries {
+			if id, ok := layout.fileID(entry.Name()); ok && !entry.IsDir() {
+				found = append(found, dataFile{id, entry.Name()})
+			}
+		}
+		if len(found) == 0 {
+			continue
+		}
+		sort.Slice(found, func(i, j int) bool { return found[i].id < found[j].id })
+
+		src := &bitcaskSource{keydir: make(map[string]bitcaskLocation)}
+		for _, file := range found {
+			if err := src.load(layout, filepath.Join(dir, file.name)); err != nil {
+				src.Close()
+				return nil, err
+			}
+		}
+		return src, nil
+	}
+	return nil, fmt.Errorf("no bitcask data files in %s", dir)
+}
+
+// load opens a data file and adds its records to the keydir
+func (s *bitcaskSource) load(layout bitcaskLayout, path string) error {
+	file, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.files = append(s.files, file)
+	index := len(s.files) - 1
+
+	for offset := int64(0); offset < info.Size(); {
+		record, err := layout.next(file, offset)
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			break // Torn by a crash
+		}
+		if err != nil {
+			return fmt.Errorf("%s bitcask file %s at offset %d: %w", layout.name, path, offset, err)
+		}
+		if record.deleted {
+			delete(s.keydir, string(record.key))
+		} else {
+			s.keydir[string(record.key)] = bitcaskLocation{
+				file: index, valueAt: record.valueAt, valueSize: record.valueSize,
+			}
+		}
+		offset = record.end
+	}
+	return nil
+}
+
+// Each reads the live values in file order, so reads are mostly sequential
+func (s *bitcaskSource) Each(ctx context.Context, fn func(key, value []byte) error) error {
+	keys := make([]string, 0, len(s.keydir))
+	for key := range s.keydir {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := s.keydir[keys[i]], s.keydir[keys[j]]
+		if a.file != b.file {
+			return a.file < b.file
+		}
+		return a.valueAt < b.valueAt
+	})
+
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		loc := s.keydir[key]
+		value := make([]byte, loc.valueSize)
+		if _, err := s.files[loc.file].ReadAt(value, loc.valueAt); err != nil {
+			return fmt.Errorf("failed to read value of %q: %w", key, err)
+		}
+		if err := fn([]byte(key), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the data files
+func (s *bitcaskSource) Close() error {
+	var firstErr error
+	for _, file := range s.files {
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// riakFileID parses names like 12.bitcask.data
+func riakFileID(name string) (int, bool) {
+	id, ok := strings.CutSuffix(name, ".bitcask.data")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(id)
+	return n, err == nil
+}
+
+// prologicFileID parses names like 000000012.data
+func prologicFileID(name string) (int, bool) {
+	id, ok := strings.CutSuffix(name, ".data")
+	if !ok || len(id) != 9 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(id)
+	return n, err == nil
+}
+
+// nextRiakRecord reads [CRC(4)][Timestamp(4)][KeySize(2)][ValueSize(4)]
+// [Key][Value], big-endian, with the CRC covering everything after it
+func nextRiakRecord(r io.ReaderAt, offset int64) (bitcaskRecord, error) {
+	header := make([]byte, riakHeaderSize)
+	if _, err := r.ReadAt(header, offset); err != nil {
+		return bitcaskRecord{}, eofAsTorn(err)
+	}
+	keySize := int64(binary.BigEndian.Uint16(header[8:10]))
+	valueSize := int64(binary.BigEndian.Uint32(header[10:14]))
+
+	body := make([]byte, keySize+valueSize)
+	if _, err := r.ReadAt(body, offset+riakHeaderSize); err != nil {
+		return bitcaskRecord{}, eofAsTorn(err)
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(body)
+	if crc.Sum32() != binary.BigEndian.Uint32(header[0:4]) {
+		return bitcaskRecord{}, errors.New("checksum mismatch")
+	}
+
+	value := body[keySize:]
+	return bitcaskRecord{
+		key:       body[:keySize],
+		valueAt:   offset + riakHeaderSize + keySize,
+		valueSize: valueSize,
+		deleted:   bytes.Equal(value, riakTombstone) || bytes.HasPrefix(value, riakTombstoneV2),
+		end:       offset + riakHeaderSize + keySize + valueSize,
+	}, nil
+}
+
+// nextPrologicRecord reads [KeySize(4)][ValueSize(8)][Key][Value]
+// [ValueCRC(4)][Expiry(8)], big-endian. The library deletes a key by
+// writing an empty value; an expiry is in Unix seconds, zero for none.
+func nextPrologicRecord(r io.ReaderAt, offset int64) (bitcaskRecord, error) {
+	header := make([]byte, prologicHeaderSize)
+	if _, err := r.ReadAt(header, offset); err != nil {
+		return bitcaskRecord{}, eofAsTorn(err)
+	}
+	keySize := int64(binary.BigEndian.Uint32(header[0:4]))
+	valueSize := binary.BigEndian.Uint64(header[4:12])
+	if valueSize > 1<<40 {
+		return bitcaskRecord{}, fmt.Errorf("implausible value size %d", valueSize)
+	}
+
+	rest := make([]byte, keySize+int64(valueSize)+prologicFooterSize) //nolint:gosec // Bounded above
+	if _, err := r.ReadAt(rest, offset+prologicHeaderSize); err != nil {
+		return bitcaskRecord{}, eofAsTorn(err)
+	}
+	value := rest[keySize : keySize+int64(valueSize)] //nolint:gosec // Bounded above
+	footer := rest[len(rest)-prologicFooterSize:]
+	if crc32.ChecksumIEEE(value) != binary.BigEndian.Uint32(footer[0:4]) {
+		return bitcaskRecord{}, errors.New("checksum mismatch")
+	}
+	expiry := int64(binary.BigEndian.Uint64(footer[4:12])) //nolint:gosec // Unix seconds
+
+	return bitcaskRecord{
+		key:       rest[:keySize],
+		valueAt:   offset + prologicHeaderSize + keySize,
+		valueSize: int64(len(value)),
+		deleted:   len(value) == 0 || (expiry > 0 && expiry <= time.Now().Unix()),
+		end:       offset + prologicHeaderSize + int64(len(rest)),
+	}, nil
+}
+
+// eofAsTorn reports a read past the end of a file as a torn record
+func eofAsTorn(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
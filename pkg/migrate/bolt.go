@@ -0,0 +1,97 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// DefaultBucketSeparator joins bucket names and keys imported from bbolt
+const DefaultBucketSeparator = ":"
+
+// boltOpenTimeout bounds the wait for a bbolt file another process holds
+const boltOpenTimeout = time.Second
+
+// BoltOptions controls which pairs OpenBolt reads and how they are keyed
+type BoltOptions struct {
+	// Buckets lists the top-level buckets to read, all of them if empty
+	Buckets []string
+
+	// Separator joins the path of buckets holding a pair to its key, so
+	// key k in bucket b nested in a is imported as "a:b:k".
+	// DefaultBucketSeparator if empty.
+	Separator string
+}
+
+// boltSource reads a bbolt database
+type boltSource struct {
+	db   *bolt.DB
+	opts BoltOptions
+}
+
+// OpenBolt opens a bbolt (or BoltDB) file read-only. It fails if another
+// process has the file open for writing. Pairs are read from every bucket,
+// nested ones included, in a single read transaction.
+func OpenBolt(path string, opts BoltOptions) (Source, error) {
+	if opts.Separator == "" {
+		opts.Separator = DefaultBucketSeparator
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{ReadOnly: true, Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt database: %w", err)
+	}
+	return &boltSource{db: db, opts: opts}, nil
+}
+
+// Each walks the buckets depth first, in key order
+func (s *boltSource) Each(ctx context.Context, fn func(key, value []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		if len(s.opts.Buckets) == 0 {
+			return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+				return s.walk(ctx, bucket, []string{string(name)}, fn)
+			})
+		}
+		for _, name := range s.opts.Buckets {
+			bucket := tx.Bucket([]byte(name))
+			if bucket == nil {
+				return fmt.Errorf("bucket %q not found", name)
+			}
+			if err := s.walk(ctx, bucket, []string{name}, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// walk reads the pairs of bucket and the buckets nested in it
+func (s *boltSource) walk(ctx context.Context, bucket *bolt.Bucket, path []string,
+	fn func(key, value []byte) error) error {
+	prefix := strings.Join(path, s.opts.Separator) + s.opts.Separator
+	cursor := bucket.Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if v == nil { // A nested bucket
+			if err := s.walk(ctx, bucket.Bucket(k), append(path, string(k)), fn); err != nil {
+				return err
+			}
+			continue
+		}
+		// Pairs are only valid for the transaction, so copy them out
+		key := append([]byte(prefix), k...)
+		if err := fn(key, append([]byte(nil), v...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the database
+func (s *boltSource) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,150 @@
+// Package migrate imports the contents of other key-value databases into a
+// FreyjaDB store, so an application can switch without writing its own
+// exporter.
+//
+// A Source reads the live pairs of a database that isn't running: a
+// Bitcask directory (OpenBitcask), a bbolt file (OpenBolt) or a Badger
+// directory (OpenBadger). Import streams them through an optional
+// TransformFunc, which can rename keys, rewrite values or skip pairs, and
+// writes them to the store in batches with PutBatch.
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// DefaultBatchSize is the number of pairs Import writes per batch
+const DefaultBatchSize = 1000
+
+// Source is a database to import from
+type Source interface {
+	// Each calls fn with every live pair in the database, stopping at the
+	// first error fn returns. fn may keep key and value.
+	Each(ctx context.Context, fn func(key, value []byte) error) error
+	Close() error
+}
+
+// Target is where Import writes; *store.KVStore implements it
+type Target interface {
+	PutBatch(pairs []store.KeyValuePair) error
+}
+
+// TransformFunc rewrites a pair before it is imported. Returning a nil key
+// skips the pair; returning an error stops the import.
+type TransformFunc func(key, value []byte) (newKey, newValue []byte, err error)
+
+// Options controls Import
+type Options struct {
+	BatchSize int           // Pairs per PutBatch, DefaultBatchSize if zero
+	Transform TransformFunc // Applied to every pair; nil imports them unchanged
+
+	// Progress, if set, is called after each batch is written
+	Progress func(Result)
+}
+
+// Result summarizes an import
+type Result struct {
+	Read     int           `json:"read"`     // Pairs read from the source
+	Imported int           `json:"imported"` // Pairs written to the store
+	Skipped  int           `json:"skipped"`  // Pairs the transform skipped
+	Bytes    int64         `json:"bytes"`    // Key and value bytes written
+	Duration time.Duration `json:"duration"`
+}
+
+// Import copies every pair of src into dst. Pairs are written in batches,
+// each one atomic with respect to readers, but an import that fails part
+// way leaves the batches before the failure written. Importing again is
+// safe, since later pairs overwrite earlier ones with the same key.
+func Import(ctx context.Context, dst Target, src Source, opts Options) (*Result, error) {
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	start := time.Now()
+	result := &Result{}
+	batch := make([]store.KeyValuePair, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := dst.PutBatch(batch); err != nil {
+			return fmt.Errorf("failed to write batch at pair %d: %w", result.Read, err)
+		}
+		for _, pair := range batch {
+			result.Imported++
+			result.Bytes += int64(len(pair.Key) + len(pair.Value))
+		}
+		batch = batch[:0]
+		if opts.Progress != nil {
+			result.Duration = time.Since(start)
+			opts.Progress(*result)
+		}
+		return nil
+	}
+
+	err := src.Each(ctx, func(key, value []byte) error {
+		result.Read++
+		if opts.Transform != nil {
+			newKey, newValue, err := opts.Transform(key, value)
+			if err != nil {
+				return fmt.Errorf("failed to transform %q: %w", key, err)
+			}
+			if newKey == nil {
+				result.Skipped++
+				return nil
+			}
+			key, value = newKey, newValue
+		}
+
+		batch = append(batch, store.KeyValuePair{Key: key, Value: value})
+		if len(batch) < batchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+	result.Duration = time.Since(start)
+	return result, err
+}
+
+// Chain applies transforms in order, stopping at the first that skips the
+// pair
+func Chain(transforms ...TransformFunc) TransformFunc {
+	return func(key, value []byte) ([]byte, []byte, error) {
+		for _, transform := range transforms {
+			var err error
+			if key, value, err = transform(key, value); err != nil || key == nil {
+				return key, value, err
+			}
+		}
+		return key, value, nil
+	}
+}
+
+// TrimPrefix removes prefix from keys that have it and skips those that
+// don't
+func TrimPrefix(prefix string) TransformFunc {
+	return func(key, value []byte) ([]byte, []byte, error) {
+		trimmed, ok := strings.CutPrefix(string(key), prefix)
+		if !ok || trimmed == "" {
+			return nil, nil, nil
+		}
+		return []byte(trimmed), value, nil
+	}
+}
+
+// AddPrefix puts prefix in front of every key, e.g. to import into a
+// namespace
+func AddPrefix(prefix string) TransformFunc {
+	return func(key, value []byte) ([]byte, []byte, error) {
+		return append([]byte(prefix), key...), value, nil
+	}
+}
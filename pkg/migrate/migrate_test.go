@@ -0,0 +1,226 @@
+package migrate
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/ssargent/freyjadb/pkg/store"
+	bolt "go.etcd.io/bbolt"
+)
+
+// collect reads every pair of src into a map
+func collect(t *testing.T, src Source) map[string]string {
+	t.Helper()
+	pairs := make(map[string]string)
+	err := src.Each(context.Background(), func(key, value []byte) error {
+		pairs[string(key)] = string(value)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Each failed: %v", err)
+	}
+	return pairs
+}
+
+// riakRecord encodes a record of Riak's Bitcask layout
+func riakRecord(key, value string) []byte {
+	record := make([]byte, riakHeaderSize, riakHeaderSize+len(key)+len(value))
+	binary.BigEndian.PutUint32(record[4:8], 1700000000)
+	binary.BigEndian.PutUint16(record[8:10], uint16(len(key)))
+	binary.BigEndian.PutUint32(record[10:14], uint32(len(value)))
+	record = append(append(record, key...), value...)
+	binary.BigEndian.PutUint32(record[0:4], crc32.ChecksumIEEE(record[4:]))
+	return record
+}
+
+// prologicRecord encodes a record of github.com/prologic/bitcask
+func prologicRecord(key, value string, expiry int64) []byte {
+	record := make([]byte, prologicHeaderSize)
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(key)))
+	binary.BigEndian.PutUint64(record[4:12], uint64(len(value)))
+	record = append(append(record, key...), value...)
+	record = binary.BigEndian.AppendUint32(record, crc32.ChecksumIEEE([]byte(value)))
+	return binary.BigEndian.AppendUint64(record, uint64(expiry))
+}
+
+func writeFile(t *testing.T, path string, records ...[]byte) {
+	t.Helper()
+	var data []byte
+	for _, record := range records {
+		data = append(data, record...)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+}
+
+func TestOpenBitcask_Riak(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "1.bitcask.data"),
+		riakRecord("a", "old"), riakRecord("b", "1"), riakRecord("c", "1"))
+	torn := riakRecord("d", "never finished")
+	writeFile(t, filepath.Join(dir, "2.bitcask.data"),
+		riakRecord("a", "new"), riakRecord("b", "bitcask_tombstone"),
+		riakRecord("c", "bitcask_tombstone2\x00\x00\x00\x01"), torn[:len(torn)-3])
+	writeFile(t, filepath.Join(dir, "1.bitcask.hint"), []byte("ignored"))
+
+	src, err := OpenBitcask(dir)
+	if err != nil {
+		t.Fatalf("OpenBitcask failed: %v", err)
+	}
+	defer src.Close()
+
+	if got, want := collect(t, src), map[string]string{"a": "new"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pairs = %v, want %v", got, want)
+	}
+}
+
+func TestOpenBitcask_Prologic(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "000000000.data"),
+		prologicRecord("a", "1", 0), prologicRecord("gone", "1", 0), prologicRecord("expired", "1", 1))
+	writeFile(t, filepath.Join(dir, "000000001.data"),
+		prologicRecord("gone", "", 0), prologicRecord("b", "2", 0))
+
+	src, err := OpenBitcask(dir)
+	if err != nil {
+		t.Fatalf("OpenBitcask failed: %v", err)
+	}
+	defer src.Close()
+
+	if got, want := collect(t, src), map[string]string{"a": "1", "b": "2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pairs = %v, want %v", got, want)
+	}
+}
+
+func TestOpenBitcask_Corrupt(t *testing.T) {
+	dir := t.TempDir()
+	bad := riakRecord("a", "1")
+	bad[len(bad)-1] ^= 0xff
+	writeFile(t, filepath.Join(dir, "1.bitcask.data"), bad, riakRecord("b", "2"))
+	if _, err := OpenBitcask(dir); err == nil {
+		t.Error("expected a checksum error")
+	}
+
+	if _, err := OpenBitcask(t.TempDir()); err == nil {
+		t.Error("expected an error for a directory without data files")
+	}
+}
+
+func TestOpenBolt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.db")
+	db, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open failed: %v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		users, _ := tx.CreateBucket([]byte("users"))
+		_ = users.Put([]byte("1"), []byte("alice"))
+		archived, _ := users.CreateBucket([]byte("archived"))
+		_ = archived.Put([]byte("2"), []byte("bob"))
+		settings, _ := tx.CreateBucket([]byte("settings"))
+		return settings.Put([]byte("theme"), []byte("dark"))
+	})
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	db.Close()
+
+	src, err := OpenBolt(path, BoltOptions{})
+	if err != nil {
+		t.Fatalf("OpenBolt failed: %v", err)
+	}
+	want := map[string]string{"users:1": "alice", "users:archived:2": "bob", "settings:theme": "dark"}
+	if got := collect(t, src); !reflect.DeepEqual(got, want) {
+		t.Errorf("pairs = %v, want %v", got, want)
+	}
+	src.Close()
+
+	src, err = OpenBolt(path, BoltOptions{Buckets: []string{"settings"}, Separator: "/"})
+	if err != nil {
+		t.Fatalf("OpenBolt failed: %v", err)
+	}
+	defer src.Close()
+	if got, want := collect(t, src), map[string]string{"settings/theme": "dark"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pairs = %v, want %v", got, want)
+	}
+}
+
+func TestOpenBadger(t *testing.T) {
+	dir := t.TempDir()
+	db, err := badger.Open(badger.DefaultOptions(dir).WithLogger(nil))
+	if err != nil {
+		t.Fatalf("badger.Open failed: %v", err)
+	}
+	err = db.Update(func(txn *badger.Txn) error {
+		_ = txn.Set([]byte("a"), []byte("1"))
+		_ = txn.Set([]byte("gone"), []byte("1"))
+		return txn.Set([]byte("b"), []byte("2"))
+	})
+	if err == nil {
+		err = db.Update(func(txn *badger.Txn) error { return txn.Delete([]byte("gone")) })
+	}
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	db.Close()
+
+	src, err := OpenBadger(dir)
+	if err != nil {
+		t.Fatalf("OpenBadger failed: %v", err)
+	}
+	defer src.Close()
+	if got, want := collect(t, src), map[string]string{"a": "1", "b": "2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pairs = %v, want %v", got, want)
+	}
+}
+
+func TestImport(t *testing.T) {
+	dir := t.TempDir()
+	var records [][]byte
+	for _, key := range []string{"user:1", "user:2", "user:3", "tmp:1"} {
+		records = append(records, riakRecord(key, "v-"+key))
+	}
+	writeFile(t, filepath.Join(dir, "1.bitcask.data"), records...)
+	src, err := OpenBitcask(dir)
+	if err != nil {
+		t.Fatalf("OpenBitcask failed: %v", err)
+	}
+	defer src.Close()
+
+	kv, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewKVStore failed: %v", err)
+	}
+	if _, err := kv.Open(); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer kv.Close()
+
+	batches := 0
+	result, err := Import(context.Background(), kv, src, Options{
+		BatchSize: 2,
+		Transform: Chain(TrimPrefix("user:"), AddPrefix("legacy:")),
+		Progress:  func(Result) { batches++ },
+	})
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if result.Read != 4 || result.Imported != 3 || result.Skipped != 1 || batches != 2 {
+		t.Errorf("result = %+v after %d batches, want 4 read, 3 imported, 1 skipped in 2 batches", result, batches)
+	}
+
+	value, err := kv.Get([]byte("legacy:2"))
+	if err != nil || string(value) != "v-user:2" {
+		t.Errorf("Get(legacy:2) = %q, %v", value, err)
+	}
+	if _, err := kv.Get([]byte("tmp:1")); err != store.ErrKeyNotFound {
+		t.Errorf("Get(tmp:1) error = %v, want ErrKeyNotFound", err)
+	}
+}
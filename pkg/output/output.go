@@ -0,0 +1,152 @@
+// Package output renders command results as a table, JSON, CSV or a Go
+// template, so CLI output can be read by people or piped into other tools.
+package output
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+)
+
+// Output kinds accepted by Parse
+const (
+	KindTable    = "table"
+	KindJSON     = "json"
+	KindCSV      = "csv"
+	KindTemplate = "template"
+)
+
+// templatePrefix introduces an inline Go template in a format spec
+const templatePrefix = KindTemplate + "="
+
+// Usage describes the accepted format specs, for flag help
+const Usage = "output format: table, json, csv or template='{{.Field}}'"
+
+// FormatError reports an unusable format spec
+type FormatError struct {
+	Message string
+}
+
+func (e *FormatError) Error() string {
+	return e.Message
+}
+
+// Format is a parsed --format value
+type Format struct {
+	Kind     string
+	Template *template.Template // Set for KindTemplate
+}
+
+// Rows is the tabular form of a result, used for table and CSV output.
+// Header names should be lower case, as CSV consumers expect; tables
+// upper-case them. Cells should hold full, untruncated values.
+type Rows struct {
+	Header []string
+	Rows   [][]string
+}
+
+// Add appends a row
+func (r *Rows) Add(cells ...string) {
+	r.Rows = append(r.Rows, cells)
+}
+
+// Parse reads a format spec: "table", "json", "csv" or "template=<text>",
+// where text is a Go text/template executed once per result item
+func Parse(spec string) (*Format, error) {
+	switch {
+	case spec == "" || spec == KindTable:
+		return &Format{Kind: KindTable}, nil
+	case spec == KindJSON, spec == KindCSV:
+		return &Format{Kind: spec}, nil
+	case strings.HasPrefix(spec, templatePrefix):
+		text := strings.TrimPrefix(spec, templatePrefix)
+		if text == "" {
+			return nil, &FormatError{"template format needs a template, e.g. template='{{.ID}}'"}
+		}
+		tmpl, err := template.New("format").Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, &FormatError{fmt.Sprintf("invalid output template: %v", err)}
+		}
+		return &Format{Kind: KindTemplate, Template: tmpl}, nil
+	default:
+		return nil, &FormatError{fmt.Sprintf("unknown output format %q (%s)", spec, Usage)}
+	}
+}
+
+// Write renders items in the format. items is a value or a slice of values;
+// JSON encodes it as given, templates run once per element, and table and
+// CSV output use rows.
+func (f *Format) Write(w io.Writer, items interface{}, rows Rows) error {
+	switch f.Kind {
+	case KindJSON:
+		return WriteJSON(w, items)
+	case KindCSV:
+		return WriteCSV(w, rows)
+	case KindTemplate:
+		return f.WriteTemplate(w, items)
+	default:
+		return WriteTable(w, rows)
+	}
+}
+
+// WriteJSON writes v as indented JSON
+func WriteJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// WriteCSV writes the header and rows as RFC 4180 CSV
+func WriteCSV(w io.Writer, rows Rows) error {
+	cw := csv.NewWriter(w)
+	if len(rows.Header) > 0 {
+		if err := cw.Write(rows.Header); err != nil {
+			return err
+		}
+	}
+	if err := cw.WriteAll(rows.Rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// WriteTable writes the header, upper-cased, and rows as aligned columns
+func WriteTable(w io.Writer, rows Rows) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if len(rows.Header) > 0 {
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(rows.Header, "\t")))
+	}
+	for _, row := range rows.Rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// WriteTemplate executes the template for each element of items (or once,
+// if items is not a slice), ending each result with a newline
+func (f *Format) WriteTemplate(w io.Writer, items interface{}) error {
+	v := reflect.ValueOf(items)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return f.executeTemplate(w, items)
+	}
+	for i := 0; i < v.Len(); i++ {
+		if err := f.executeTemplate(w, v.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// executeTemplate renders one item followed by a newline
+func (f *Format) executeTemplate(w io.Writer, item interface{}) error {
+	if err := f.Template.Execute(w, item); err != nil {
+		return fmt.Errorf("failed to render output template: %w", err)
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
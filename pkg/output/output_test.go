@@ -0,0 +1,75 @@
+package output
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type item struct {
+	Key   string
+	Value string
+}
+
+func TestParse(t *testing.T) {
+	for _, spec := range []string{"", "table", "json", "csv", "template={{.Key}}"} {
+		f, err := Parse(spec)
+		require.NoError(t, err, spec)
+		assert.NotEmpty(t, f.Kind)
+	}
+
+	_, err := Parse("yaml")
+	var formatErr *FormatError
+	assert.ErrorAs(t, err, &formatErr)
+
+	_, err = Parse("template=")
+	assert.ErrorAs(t, err, &formatErr)
+
+	_, err = Parse("template={{.Key")
+	assert.ErrorAs(t, err, &formatErr)
+}
+
+func TestFormat_Write(t *testing.T) {
+	items := []item{{"a", "1"}, {"b", "x,\"y\""}}
+	rows := Rows{Header: []string{"key", "value"}}
+	for _, it := range items {
+		rows.Add(it.Key, it.Value)
+	}
+
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"csv", "key,value\na,1\nb,\"x,\"\"y\"\"\"\n"},
+		{"template={{.Key}}={{.Value}}", "a=1\nb=x,\"y\"\n"},
+		{"table", "KEY  VALUE\na    1\nb    x,\"y\"\n"},
+		{"json", "[\n  {\n    \"Key\": \"a\",\n    \"Value\": \"1\"\n  },\n  {\n    \"Key\": \"b\",\n" +
+			"    \"Value\": \"x,\\\"y\\\"\"\n  }\n]\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			f, err := Parse(tt.spec)
+			require.NoError(t, err)
+
+			var buf bytes.Buffer
+			require.NoError(t, f.Write(&buf, items, rows))
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
+
+func TestFormat_WriteTemplateSingleItem(t *testing.T) {
+	f, err := Parse("template={{.Key}}")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, f.Write(&buf, item{Key: "only"}, Rows{}))
+	assert.Equal(t, "only\n", buf.String())
+
+	// Unknown fields fail rather than printing "<no value>"
+	f, err = Parse("template={{.Missing}}")
+	require.NoError(t, err)
+	assert.Error(t, f.Write(&buf, item{}, Rows{}))
+}
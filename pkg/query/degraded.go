@@ -0,0 +1,303 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/ssargent/freyjadb/pkg/index"
+)
+
+// EngineStats counts queries answered without their secondary index
+type EngineStats struct {
+	DegradedQueries      int64    // Queries answered by a primary scan
+	IndexRebuilds        int64    // Background rebuilds that completed
+	IndexRebuildFailures int64    // Background rebuilds that failed
+	UnavailableIndexes   []string // Fields whose index cannot currently answer searches
+}
+
+// Stats returns the engine's degraded-mode counters
+func (qe *SimpleQueryEngine) Stats() EngineStats {
+	unavailable := make([]string, 0)
+	for field := range qe.indexManager.Unavailable() {
+		unavailable = append(unavailable, field)
+	}
+	sort.Strings(unavailable)
+
+	return EngineStats{
+		DegradedQueries:      qe.degradedQueries.Load(),
+		IndexRebuilds:        qe.indexRebuilds.Load(),
+		IndexRebuildFailures: qe.indexRebuildFailures.Load(),
+		UnavailableIndexes:   unavailable,
+	}
+}
+
+// SetExtractors makes degraded scans and rebuilds pick each record's
+// extractor from registry when the caller passes none, instead of assuming
+// JSON values
+func (qe *SimpleQueryEngine) SetExtractors(registry *ExtractorRegistry) {
+	qe.mutex.Lock()
+	defer qe.mutex.Unlock()
+
+	qe.extractors = registry
+}
+
+// defaultExtractor is the extractor used when a caller passes none
+func (qe *SimpleQueryEngine) defaultExtractor() FieldExtractor {
+	qe.mutex.Lock()
+	defer qe.mutex.Unlock()
+
+	if qe.extractors != nil {
+		return qe.extractors
+	}
+	return &JSONFieldExtractor{}
+}
+
+// SetIndexedPrefix limits the records indexed on field to keys beginning
+// with prefix. Degraded scans and rebuilds of that field read only those
+// keys; without a prefix they read the whole store.
+func (qe *SimpleQueryEngine) SetIndexedPrefix(field string, prefix []byte) {
+	qe.mutex.Lock()
+	defer qe.mutex.Unlock()
+
+	qe.indexedPrefixes[field] = append([]byte(nil), prefix...)
+}
+
+// indexedPrefix returns the key prefix of the records indexed on field
+func (qe *SimpleQueryEngine) indexedPrefix(field string) []byte {
+	qe.mutex.Lock()
+	defer qe.mutex.Unlock()
+
+	return qe.indexedPrefixes[field]
+}
+
+// searchOrScan runs search against the index and, if the index is
+// unavailable, answers from a primary scan instead. The bounds are
+// inclusive like the index's own searches; a nil bound is open.
+func (qe *SimpleQueryEngine) searchOrScan(ctx context.Context, idx *index.SecondaryIndex, field string,
+	start, end interface{}, projection Projection, extractor FieldExtractor,
+	search func() ([]index.Entry, error)) (QueryIterator, error) {
+	entries, err := search()
+	if err == nil {
+		return qe.newIterator(entries, projection), nil
+	}
+	if !errors.Is(err, index.ErrIndexUnavailable) || qe.kvStore == nil {
+		return nil, fmt.Errorf("index search failed: %w", err)
+	}
+
+	if extractor == nil {
+		extractor = qe.defaultExtractor()
+	}
+	qe.degradedQueries.Add(1)
+	qe.startRebuild(idx, field, extractor)
+
+	entries, err = qe.scanEntries(ctx, field, start, end, extractor)
+	if err != nil {
+		return nil, fmt.Errorf("degraded scan failed: %w", err)
+	}
+	return markDegraded(qe.newIterator(entries, projection)), nil
+}
+
+// markDegraded flags every result of an iterator as found by a primary scan
+func markDegraded(it QueryIterator) QueryIterator {
+	switch it := it.(type) {
+	case *simpleIterator:
+		for i := range it.results {
+			it.results[i].Degraded = true
+		}
+	case *lazyIterator:
+		it.degraded = true
+	}
+	return it
+}
+
+// scanEntries reads every record indexed on field and returns entries for
+// those whose value lies within the bounds, ordered as the index orders them
+func (qe *SimpleQueryEngine) scanEntries(ctx context.Context, field string,
+	start, end interface{}, extractor FieldExtractor) ([]index.Entry, error) {
+	it, err := qe.kvStore.ScanPrefix(qe.indexedPrefix(field))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	storedFields := qe.indexManager.GetOrCreateIndex(field).StoredFields()
+	var entries []index.Entry
+	for it.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		raw, err := extractField(extractor, it.Key(), it.Value(), field)
+		if err != nil {
+			continue // Records without the field aren't in the index either
+		}
+		value, ok := indexValue(raw)
+		if !ok {
+			continue
+		}
+		if start != nil && compareValues(value, start) < 0 {
+			continue
+		}
+		if end != nil && compareValues(value, end) > 0 {
+			continue
+		}
+		entries = append(entries, index.Entry{FieldValue: value, PrimaryKey: it.Key(),
+			Stored: storedValues(extractor, it.Key(), it.Value(), storedFields)})
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if c := compareValues(entries[i].FieldValue, entries[j].FieldValue); c != 0 {
+			return c < 0
+		}
+		return string(entries[i].PrimaryKey) < string(entries[j].PrimaryKey)
+	})
+	return entries, nil
+}
+
+// startRebuild rebuilds an unavailable index in the background, unless a
+// rebuild of it is already running
+func (qe *SimpleQueryEngine) startRebuild(idx *index.SecondaryIndex, field string, extractor FieldExtractor) {
+	if state, _ := idx.State(); state != index.IndexUnavailable {
+		return // Ready again, or someone else is rebuilding it
+	}
+
+	qe.mutex.Lock()
+	if qe.rebuilding[field] {
+		qe.mutex.Unlock()
+		return
+	}
+	qe.rebuilding[field] = true
+	qe.mutex.Unlock()
+
+	go func() {
+		defer func() {
+			qe.mutex.Lock()
+			delete(qe.rebuilding, field)
+			qe.mutex.Unlock()
+		}()
+
+		if _, err := qe.RebuildIndex(context.Background(), field, extractor); err != nil {
+			qe.indexRebuildFailures.Add(1)
+			return
+		}
+		qe.indexRebuilds.Add(1)
+	}()
+}
+
+// RebuildIndex refills the index on field from the records in the KV store
+// and returns how many entries it added. Queries on the field are answered
+// by primary scans until it finishes. Whole JSON numbers are indexed as
+// int64, matching how integer fields are usually inserted.
+func (qe *SimpleQueryEngine) RebuildIndex(ctx context.Context, field string, extractor FieldExtractor) (int, error) {
+	if qe.kvStore == nil {
+		return 0, fmt.Errorf("no KV store to rebuild the index for field %s from", field)
+	}
+	if extractor == nil {
+		extractor = qe.defaultExtractor()
+	}
+
+	idx := qe.indexManager.GetOrCreateIndex(field)
+	idx.BeginRebuild()
+
+	indexed, err := qe.rebuildEntries(ctx, idx, field, extractor)
+	idx.FinishRebuild(err)
+	return indexed, err
+}
+
+// rebuildEntries scans the records indexed on field into a rebuilding index
+func (qe *SimpleQueryEngine) rebuildEntries(ctx context.Context, idx *index.SecondaryIndex,
+	field string, extractor FieldExtractor) (int, error) {
+	it, err := qe.kvStore.ScanPrefix(qe.indexedPrefix(field))
+	if err != nil {
+		return 0, err
+	}
+	defer it.Close()
+
+	storedFields := idx.StoredFields()
+	indexed := 0
+	for it.Next() {
+		// A rebuild is background work; let client traffic go first
+		if err := qe.kvStore.ThrottleBackground(ctx, int64(len(it.Key())+len(it.Value()))); err != nil {
+			return indexed, err
+		}
+		raw, err := extractField(extractor, it.Key(), it.Value(), field)
+		if err != nil {
+			continue
+		}
+		if value, ok := indexValue(raw); ok {
+			stored := storedValues(extractor, it.Key(), it.Value(), storedFields)
+			if err := idx.InsertRebuiltStored(value, it.Key(), stored); err != nil {
+				return indexed, err
+			}
+			indexed++
+		}
+	}
+	return indexed, it.Err()
+}
+
+// indexValue converts an extracted field value to the type the index stores
+// it as, reporting false for values that can't be indexed
+func indexValue(v interface{}) (interface{}, bool) {
+	switch n := v.(type) {
+	case string, int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case float64:
+		if n == math.Trunc(n) && n >= math.MinInt64 && n < math.MaxInt64 {
+			return int64(n), true
+		}
+		return n, true
+	default:
+		return nil, false
+	}
+}
+
+// compareValues orders field values the way the index does: numbers before
+// strings, numbers by value and strings bytewise
+func compareValues(a, b interface{}) int {
+	af, aNum := toFloat(a)
+	bf, bNum := toFloat(b)
+	switch {
+	case aNum && bNum:
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		}
+		return 0
+	case aNum:
+		return -1
+	case bNum:
+		return 1
+	}
+
+	as, bs := fmt.Sprint(a), fmt.Sprint(b)
+	switch {
+	case as < bs:
+		return -1
+	case as > bs:
+		return 1
+	}
+	return 0
+}
+
+// toFloat returns a numeric value as a float64
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,114 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/index"
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+func TestSimpleQueryEngine_DegradedFallback(t *testing.T) {
+	kvStore, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := kvStore.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer kvStore.Close()
+
+	indexDir := t.TempDir()
+	manager := index.NewIndexManager(4)
+	ageIndex := manager.GetOrCreateIndex("age")
+	for i, age := range []int{25, 30, 30, 41} {
+		key := []byte(fmt.Sprintf("user:%d", i))
+		if err := kvStore.Put(key, []byte(fmt.Sprintf(`{"age":%d}`, age))); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+		if err := ageIndex.Insert(age, key); err != nil {
+			t.Fatalf("Failed to index: %v", err)
+		}
+	}
+	if err := kvStore.Put([]byte("note:1"), []byte(`{"text":"no age"}`)); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := manager.SaveAll(indexDir); err != nil {
+		t.Fatalf("Failed to save indexes: %v", err)
+	}
+
+	// Corrupt the snapshot and reload: the age index comes back unavailable
+	if err := os.Truncate(filepath.Join(indexDir, "index_age.dat"), 6); err != nil {
+		t.Fatalf("Failed to corrupt snapshot: %v", err)
+	}
+	manager = index.NewIndexManager(4)
+	if err := manager.LoadAll(indexDir); err != nil {
+		t.Fatalf("Expected LoadAll to tolerate a corrupt index, got %v", err)
+	}
+	engine := NewSimpleQueryEngine(manager, kvStore)
+	engine.SetIndexedPrefix("age", []byte("user:"))
+
+	collect := func(it QueryIterator) ([]string, bool) {
+		t.Helper()
+		defer it.Close()
+		var keys []string
+		degraded := false
+		for it.Next() {
+			keys = append(keys, string(it.Result().Key))
+			degraded = degraded || it.Result().Degraded
+		}
+		return keys, degraded
+	}
+
+	it, err := engine.ExecuteQuery(context.Background(), "users", FieldQuery{Field: "age", Operator: "=", Value: 30}, nil)
+	if err != nil {
+		t.Fatalf("Degraded query failed: %v", err)
+	}
+	keys, degraded := collect(it)
+	if fmt.Sprint(keys) != "[user:1 user:2]" || !degraded {
+		t.Errorf("Expected degraded results [user:1 user:2], got %v (degraded=%v)", keys, degraded)
+	}
+
+	count, err := engine.Count(context.Background(), "users", FieldQuery{Field: "age", Operator: ">=", Value: 30})
+	if err != nil || count != 3 {
+		t.Errorf("Expected degraded count 3, got %d, %v", count, err)
+	}
+	if stats := engine.Stats(); stats.DegradedQueries < 1 {
+		t.Errorf("Expected degraded queries to be counted, got %+v", stats)
+	}
+
+	// The index is rebuilt in the background and then answers normally
+	deadline := time.Now().Add(5 * time.Second)
+	for !manager.GetOrCreateIndex("age").Available() {
+		if time.Now().After(deadline) {
+			t.Fatal("Index was not rebuilt")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	it, err = engine.ExecuteRangeQuery(context.Background(), "users",
+		FieldQuery{Field: "age", Operator: ">=", Value: 26},
+		FieldQuery{Field: "age", Operator: "<=", Value: 41}, nil)
+	if err != nil {
+		t.Fatalf("Range query failed: %v", err)
+	}
+	keys, degraded = collect(it)
+	if fmt.Sprint(keys) != "[user:1 user:2 user:3]" || degraded {
+		t.Errorf("Expected indexed results [user:1 user:2 user:3], got %v (degraded=%v)", keys, degraded)
+	}
+
+	stats := engine.Stats()
+	if stats.IndexRebuilds != 1 || stats.IndexRebuildFailures != 0 || len(stats.UnavailableIndexes) != 0 {
+		t.Errorf("Unexpected stats after rebuild: %+v", stats)
+	}
+
+	// Without a KV store there is nothing to fall back to
+	manager.GetOrCreateIndex("age").MarkUnavailable(nil)
+	if _, err := NewSimpleQueryEngine(manager, nil).ExecuteQuery(context.Background(), "users",
+		FieldQuery{Field: "age", Operator: "=", Value: 30}, nil); err == nil {
+		t.Error("Expected an error with no KV store to scan")
+	}
+}
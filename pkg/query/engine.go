@@ -3,22 +3,37 @@ package query
 import (
 	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"github.com/ssargent/freyjadb/pkg/index"
 	"github.com/ssargent/freyjadb/pkg/store"
 )
 
-// SimpleQueryEngine implements basic field-based queries using secondary indexes
+// SimpleQueryEngine implements basic field-based queries using secondary
+// indexes. When an index is unavailable, queries fall back to scanning the
+// KV store while the index is rebuilt in the background.
 type SimpleQueryEngine struct {
 	indexManager *index.IndexManager
 	kvStore      *store.KVStore
+
+	indexedPrefixes map[string][]byte  // Key prefix of the records indexed on each field
+	extractors      *ExtractorRegistry // Used when a caller passes no extractor
+	rebuilding      map[string]bool    // Fields with a background rebuild running
+	mutex           sync.Mutex
+
+	degradedQueries      atomic.Int64
+	indexRebuilds        atomic.Int64
+	indexRebuildFailures atomic.Int64
 }
 
 // NewSimpleQueryEngine creates a new query engine
 func NewSimpleQueryEngine(indexManager *index.IndexManager, kvStore *store.KVStore) *SimpleQueryEngine {
 	return &SimpleQueryEngine{
-		indexManager: indexManager,
-		kvStore:      kvStore,
+		indexManager:    indexManager,
+		kvStore:         kvStore,
+		indexedPrefixes: make(map[string][]byte),
+		rebuilding:      make(map[string]bool),
 	}
 }
 
@@ -36,7 +51,7 @@ func (qe *SimpleQueryEngine) ExecuteQuery(ctx context.Context, partitionKey stri
 	// TODO: Add support for range queries and other operators
 	switch query.Operator {
 	case "=":
-		return qe.executeEqualityQuery(ctx, idx, query.Value, extractor)
+		return qe.executeEqualityQuery(ctx, idx, query, extractor)
 	case ">", ">=", "<", "<=":
 		return qe.executeRangeQuery(ctx, idx, query, extractor)
 	default:
@@ -63,39 +78,31 @@ func (qe *SimpleQueryEngine) ExecuteRangeQuery(ctx context.Context, partitionKey
 	return qe.executeRangeQueryBetween(ctx, idx, startQuery, endQuery, extractor)
 }
 
-// executeEqualityQuery handles exact field value matches
-func (qe *SimpleQueryEngine) executeEqualityQuery(ctx context.Context, idx *index.SecondaryIndex,
-	value interface{}, extractor FieldExtractor) (QueryIterator, error) {
-	// Search the index for matching records
-	primaryKeys, err := idx.Search(value)
+// Count returns the number of records matching the query. It answers from
+// the secondary index, so no record values are read from the KV store unless
+// the index is unavailable and the query falls back to a scan.
+func (qe *SimpleQueryEngine) Count(ctx context.Context, partitionKey string, query FieldQuery) (int, error) {
+	query.Projection = ProjectionIndexOnly
+
+	it, err := qe.ExecuteQuery(ctx, partitionKey, query, nil)
 	if err != nil {
-		return nil, fmt.Errorf("index search failed: %w", err)
-	}
-
-	// Fetch actual records from KV store
-	results := make([]QueryResult, 0, len(primaryKeys))
-	for _, key := range primaryKeys {
-		if qe.kvStore != nil {
-			// Fetch the actual record from KV store
-			value, err := qe.kvStore.Get(key)
-			if err != nil {
-				// Skip records that can't be fetched (might be deleted)
-				continue
-			}
-			results = append(results, QueryResult{
-				Key:   key,
-				Value: value,
-			})
-		} else {
-			// Fallback for testing: return key with empty value
-			results = append(results, QueryResult{
-				Key:   key,
-				Value: []byte{},
-			})
-		}
+		return 0, err
 	}
+	defer it.Close()
+
+	count := 0
+	for it.Next() {
+		count++
+	}
+	return count, nil
+}
 
-	return &simpleIterator{results: results}, nil
+// executeEqualityQuery handles exact field value matches
+func (qe *SimpleQueryEngine) executeEqualityQuery(ctx context.Context, idx *index.SecondaryIndex,
+	query FieldQuery, extractor FieldExtractor) (QueryIterator, error) {
+	// Search the index for matching records
+	return qe.searchOrScan(ctx, idx, query.Field, query.Value, query.Value, query.Projection, extractor,
+		func() ([]index.Entry, error) { return idx.SearchEntries(query.Value) })
 }
 
 // executeRangeQuery handles single-field range queries
@@ -120,63 +127,58 @@ func (qe *SimpleQueryEngine) executeRangeQuery(ctx context.Context, idx *index.S
 		return nil, fmt.Errorf("unsupported range operator: %s", query.Operator)
 	}
 
-	primaryKeys, err := idx.SearchRange(startValue, endValue)
-	if err != nil {
-		return nil, fmt.Errorf("range search failed: %w", err)
-	}
-
-	// Fetch actual records from KV store
-	results := make([]QueryResult, 0, len(primaryKeys))
-	for _, key := range primaryKeys {
-		if qe.kvStore != nil {
-			value, err := qe.kvStore.Get(key)
-			if err != nil {
-				continue // Skip records that can't be fetched
-			}
-			results = append(results, QueryResult{
-				Key:   key,
-				Value: value,
-			})
-		} else {
-			results = append(results, QueryResult{
-				Key:   key,
-				Value: []byte{},
-			})
-		}
-	}
-
-	return &simpleIterator{results: results}, nil
+	return qe.searchOrScan(ctx, idx, query.Field, startValue, endValue, query.Projection, extractor,
+		func() ([]index.Entry, error) { return idx.SearchRangeEntries(startValue, endValue) })
 }
 
 // executeRangeQueryBetween handles range queries between two values
 func (qe *SimpleQueryEngine) executeRangeQueryBetween(ctx context.Context, idx *index.SecondaryIndex,
 	startQuery, endQuery FieldQuery, extractor FieldExtractor) (QueryIterator, error) {
-	primaryKeys, err := idx.SearchRange(startQuery.Value, endQuery.Value)
-	if err != nil {
-		return nil, fmt.Errorf("range search failed: %w", err)
+	return qe.searchOrScan(ctx, idx, startQuery.Field, startQuery.Value, endQuery.Value, startQuery.Projection,
+		extractor, func() ([]index.Entry, error) { return idx.SearchRangeEntries(startQuery.Value, endQuery.Value) })
+}
+
+// newIterator builds the iterator matching the requested projection
+func (qe *SimpleQueryEngine) newIterator(entries []index.Entry, projection Projection) QueryIterator {
+	if projection == ProjectionLazy && qe.kvStore != nil {
+		return &lazyIterator{
+			entries:    entries,
+			kvStore:    qe.kvStore,
+			prefetched: make(map[int][]byte),
+		}
 	}
+	return &simpleIterator{results: qe.resolveEntries(entries, projection)}
+}
 
-	// Fetch actual records from KV store
-	results := make([]QueryResult, 0, len(primaryKeys))
-	for _, key := range primaryKeys {
-		if qe.kvStore != nil {
-			value, err := qe.kvStore.Get(key)
-			if err != nil {
-				continue // Skip records that can't be fetched
-			}
-			results = append(results, QueryResult{
-				Key:   key,
-				Value: value,
-			})
-		} else {
+// resolveEntries turns index entries into query results, fetching record
+// values from the KV store unless the projection can be served by the index
+func (qe *SimpleQueryEngine) resolveEntries(entries []index.Entry, projection Projection) []QueryResult {
+	results := make([]QueryResult, 0, len(entries))
+	for _, entry := range entries {
+		if projection != ProjectionFull || qe.kvStore == nil {
+			// Index-only answer (also the fallback when no KV store is attached)
 			results = append(results, QueryResult{
-				Key:   key,
-				Value: []byte{},
+				Key:        entry.PrimaryKey,
+				Value:      []byte{},
+				FieldValue: entry.FieldValue,
+				Stored:     entry.Stored,
 			})
+			continue
 		}
-	}
 
-	return &simpleIterator{results: results}, nil
+		value, err := qe.kvStore.Get(entry.PrimaryKey)
+		if err != nil {
+			// Skip records that can't be fetched (might be deleted)
+			continue
+		}
+		results = append(results, QueryResult{
+			Key:        entry.PrimaryKey,
+			Value:      value,
+			FieldValue: entry.FieldValue,
+			Stored:     entry.Stored,
+		})
+	}
+	return results
 }
 
 // simpleIterator implements QueryIterator for basic result streaming
@@ -204,3 +206,83 @@ func (it *simpleIterator) Close() error {
 	// Cleanup resources if needed
 	return nil
 }
+
+// lazyIterator implements LazyQueryIterator, deferring value reads until
+// they are requested or prefetched
+type lazyIterator struct {
+	entries    []index.Entry
+	kvStore    *store.KVStore
+	prefetched map[int][]byte
+	index      int
+	degraded   bool
+}
+
+func (it *lazyIterator) Next() bool {
+	if it.index < len(it.entries) {
+		it.index++
+		return true
+	}
+	return false
+}
+
+func (it *lazyIterator) Result() QueryResult {
+	if it.index == 0 || it.index > len(it.entries) {
+		return QueryResult{}
+	}
+
+	pos := it.index - 1
+	entry := it.entries[pos]
+	result := QueryResult{
+		Key:        entry.PrimaryKey,
+		FieldValue: entry.FieldValue,
+		Stored:     entry.Stored,
+		Degraded:   it.degraded,
+	}
+
+	if value, ok := it.prefetched[pos]; ok {
+		result.Value = value
+		return result
+	}
+
+	kvStore := it.kvStore
+	key := entry.PrimaryKey
+	result.loader = func() ([]byte, error) {
+		return kvStore.Get(key)
+	}
+	return result
+}
+
+// Prefetch loads values for the next n results (starting after the current
+// one) in a single offset-ordered batch read
+func (it *lazyIterator) Prefetch(n int) error {
+	start := it.index
+	end := start + n
+	if end > len(it.entries) {
+		end = len(it.entries)
+	}
+	if start >= end {
+		return nil
+	}
+
+	keys := make([][]byte, 0, end-start)
+	for _, entry := range it.entries[start:end] {
+		keys = append(keys, entry.PrimaryKey)
+	}
+
+	values, err := it.kvStore.GetMany(keys)
+	if err != nil {
+		return fmt.Errorf("prefetch failed: %w", err)
+	}
+
+	for i, value := range values {
+		if value != nil {
+			it.prefetched[start+i] = value
+		}
+	}
+	return nil
+}
+
+func (it *lazyIterator) Close() error {
+	it.prefetched = nil
+	return nil
+}
@@ -2,9 +2,11 @@ package query
 
 import (
 	"context"
+	"strings"
 	"testing"
 
 	"github.com/ssargent/freyjadb/pkg/index"
+	"github.com/ssargent/freyjadb/pkg/store"
 )
 
 func TestFieldQuery_Validate(t *testing.T) {
@@ -252,3 +254,240 @@ func TestSimpleQueryEngine_IndexOperations(t *testing.T) {
 	t.Logf("   - Index manager ✅")
 	t.Logf("   - Range query support ✅")
 }
+
+func TestSimpleQueryEngine_IndexOnlyProjection(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	kvStore, err := store.NewKVStore(store.KVStoreConfig{DataDir: tmpDir})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := kvStore.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer kvStore.Close()
+
+	if err := kvStore.Put([]byte("user:1"), []byte(`{"age":25}`)); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+
+	engine := NewSimpleQueryEngine(index.NewIndexManager(4), kvStore)
+	entries := []index.Entry{
+		{FieldValue: 25.0, PrimaryKey: []byte("user:1")},
+		{FieldValue: 30.0, PrimaryKey: []byte("user:missing")},
+	}
+
+	// Full projection reads values and drops keys missing from the store
+	full := engine.resolveEntries(entries, ProjectionFull)
+	if len(full) != 1 || string(full[0].Value) != `{"age":25}` {
+		t.Fatalf("Expected one fully resolved result, got %+v", full)
+	}
+
+	// Index-only projection never touches the store
+	indexOnly := engine.resolveEntries(entries, ProjectionIndexOnly)
+	if len(indexOnly) != 2 {
+		t.Fatalf("Expected 2 index-only results, got %d", len(indexOnly))
+	}
+	if indexOnly[1].FieldValue != 30.0 || len(indexOnly[1].Value) != 0 {
+		t.Errorf("Unexpected index-only result: %+v", indexOnly[1])
+	}
+
+	count, err := engine.Count(context.Background(), "users", FieldQuery{Field: "age", Operator: "=", Value: 99.0})
+	if err != nil {
+		t.Fatalf("Count failed: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("Expected count 0, got %d", count)
+	}
+}
+
+func TestSimpleQueryEngine_LazyIterator(t *testing.T) {
+	kvStore, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := kvStore.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer kvStore.Close()
+
+	entries := make([]index.Entry, 0, 3)
+	for _, k := range []string{"user:1", "user:2", "user:3"} {
+		if err := kvStore.Put([]byte(k), []byte(`{"id":"`+k+`"}`)); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+		entries = append(entries, index.Entry{FieldValue: "x", PrimaryKey: []byte(k)})
+	}
+
+	engine := NewSimpleQueryEngine(index.NewIndexManager(4), kvStore)
+	it, ok := engine.newIterator(entries, ProjectionLazy).(LazyQueryIterator)
+	if !ok {
+		t.Fatal("Expected a LazyQueryIterator for lazy projection")
+	}
+	defer it.Close()
+
+	// First result is loaded on demand
+	if !it.Next() {
+		t.Fatal("Expected a result")
+	}
+	first := it.Result()
+	if first.Value != nil {
+		t.Errorf("Expected value to be deferred, got %q", first.Value)
+	}
+	value, err := first.LoadValue()
+	if err != nil {
+		t.Fatalf("LoadValue failed: %v", err)
+	}
+	if string(value) != `{"id":"user:1"}` {
+		t.Errorf("Unexpected value: %s", value)
+	}
+
+	// Remaining results are prefetched in one batch
+	if err := it.Prefetch(10); err != nil {
+		t.Fatalf("Prefetch failed: %v", err)
+	}
+	count := 0
+	for it.Next() {
+		result := it.Result()
+		if result.Value == nil {
+			t.Errorf("Expected prefetched value for %s", result.Key)
+		}
+		count++
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 remaining results, got %d", count)
+	}
+}
+
+func TestSimpleQueryEngine_Explain(t *testing.T) {
+	kvStore, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := kvStore.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer kvStore.Close()
+
+	manager := index.NewIndexManager(4)
+	for i, name := range []string{"ann", "bob"} {
+		if err := manager.GetOrCreateIndex("name").Insert(name, []byte{byte(i)}); err != nil {
+			t.Fatalf("Failed to index: %v", err)
+		}
+	}
+	manager.GetOrCreateIndex("age")
+	manager.SetEvictionPolicy(index.EvictionPolicy{Dir: t.TempDir(), MaxBytes: 1})
+	if _, err := manager.EvictCold(); err != nil {
+		t.Fatalf("Failed to evict: %v", err)
+	}
+
+	res, err := NewSimpleQueryEngine(manager, kvStore).Explain(context.Background(), store.ExplainOptions{})
+	if err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+	if len(res.SecondaryIndexes) != 2 {
+		t.Fatalf("Expected 2 secondary indexes, got %+v", res.SecondaryIndexes)
+	}
+	name := res.SecondaryIndexes[1]
+	if name.Field != "name" || name.Entries != 2 || name.Resident || name.MemoryMB != 0 || name.State != "ready" {
+		t.Errorf("Unexpected stats for evicted index: %+v", name)
+	}
+
+	// Reads concentrated on an indexed prefix suggest storing fields
+	if err := kvStore.Put([]byte("users:1"), []byte(`{"name":"ann"}`)); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		if _, err := kvStore.Get([]byte("users:1")); err != nil {
+			t.Fatalf("Failed to get: %v", err)
+		}
+	}
+	engine := NewSimpleQueryEngine(manager, kvStore)
+	engine.SetIndexedPrefix("name", []byte("users:"))
+	if res, err = engine.Explain(context.Background(), store.ExplainOptions{}); err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+	if len(res.Advisory.Recommendations) != 1 || !strings.Contains(res.Advisory.Recommendations[0], "index on name") {
+		t.Errorf("Expected a stored-field recommendation, got %q", res.Advisory.Recommendations)
+	}
+	manager.GetOrCreateIndex("name").SetStoredFields("name")
+	if res, err = engine.Explain(context.Background(), store.ExplainOptions{}); err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+	if len(res.Advisory.Recommendations) != 0 {
+		t.Errorf("Expected no recommendations once fields are stored, got %q", res.Advisory.Recommendations)
+	}
+}
+
+func TestSimpleQueryEngine_StoredFields(t *testing.T) {
+	kvStore, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := kvStore.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer kvStore.Close()
+
+	manager := index.NewIndexManager(4)
+	manager.GetOrCreateIndex("status").SetStoredFields("name", "plan")
+	engine := NewSimpleQueryEngine(manager, kvStore)
+
+	write := func(key string, old, value []byte) {
+		t.Helper()
+		if value != nil {
+			if err := kvStore.Put([]byte(key), value); err != nil {
+				t.Fatalf("Failed to put: %v", err)
+			}
+		} else if err := kvStore.Delete([]byte(key)); err != nil {
+			t.Fatalf("Failed to delete: %v", err)
+		}
+		if err := engine.IndexRecord("status", []byte(key), old, value, nil); err != nil {
+			t.Fatalf("IndexRecord failed: %v", err)
+		}
+	}
+	alice := []byte(`{"status":"active","name":"Alice","plan":"pro","bio":"long text"}`)
+	write("user:1", nil, alice)
+	write("user:2", nil, []byte(`{"status":"active","name":"Bob"}`))
+	write("user:3", nil, []byte(`{"status":"active","name":"Carol"}`))
+
+	// Updates refresh the copies, moves and deletes drop the old entry
+	renamed := []byte(`{"status":"active","name":"Alicia","plan":"pro"}`)
+	write("user:1", alice, renamed)
+	write("user:2", []byte(`{"status":"active","name":"Bob"}`), []byte(`{"status":"inactive","name":"Bob"}`))
+	write("user:3", []byte(`{"status":"active","name":"Carol"}`), nil)
+
+	query := FieldQuery{Field: "status", Operator: "=", Value: "active", Projection: ProjectionIndexOnly}
+	it, err := engine.ExecuteQuery(context.Background(), "users", query, nil)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	var results []QueryResult
+	for it.Next() {
+		results = append(results, it.Result())
+	}
+	if len(results) != 1 || string(results[0].Key) != "user:1" {
+		t.Fatalf("Expected only user:1 to be active, got %+v", results)
+	}
+	if results[0].Stored["name"] != "Alicia" || results[0].Stored["plan"] != "pro" || len(results[0].Stored) != 2 {
+		t.Errorf("Unexpected stored fields %v", results[0].Stored)
+	}
+
+	// Rebuilds and degraded scans fill the copies from the records
+	if _, err := engine.RebuildIndex(context.Background(), "status", nil); err != nil {
+		t.Fatalf("Rebuild failed: %v", err)
+	}
+	query.Value = "inactive"
+	it, err = engine.ExecuteQuery(context.Background(), "users", query, nil)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if !it.Next() || it.Result().Stored["name"] != "Bob" {
+		t.Errorf("Expected the rebuilt entry to hold Bob's name, got %+v", it.Result())
+	}
+
+	entries, err := engine.scanEntries(context.Background(), "status", "active", "active", &JSONFieldExtractor{})
+	if err != nil || len(entries) != 1 || entries[0].Stored["name"] != "Alicia" {
+		t.Errorf("Expected the scan to copy stored fields, got %+v, %v", entries, err)
+	}
+}
@@ -0,0 +1,60 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ssargent/freyjadb/pkg/store"
+)
+
+// Explain runs the KV store's explain and adds the memory usage of each of
+// the engine's secondary indexes, and advises storing fields in indexes over
+// hot prefixes that keep none. Without a KV store only the indexes are
+// reported.
+func (qe *SimpleQueryEngine) Explain(ctx context.Context, opts store.ExplainOptions) (*store.ExplainResult, error) {
+	res := store.NewExplainResult(ctx)
+	if qe.kvStore != nil {
+		var err error
+		if res, err = qe.kvStore.Explain(ctx, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, mem := range qe.indexManager.MemoryStats() {
+		res.SecondaryIndexes = append(res.SecondaryIndexes, store.IndexMemoryStats{
+			Field:    mem.Field,
+			State:    mem.State.String(),
+			Entries:  mem.Entries,
+			MemoryMB: float64(mem.Bytes) / (1024 * 1024),
+			TreeMB:   float64(mem.TreeBytes) / (1024 * 1024),
+			Resident: mem.Resident,
+			LastUsed: mem.LastUsed,
+		})
+	}
+	res.Advisory.Recommendations = append(res.Advisory.Recommendations, qe.storedFieldAdvice(res.Advisory.HotPrefixes)...)
+	return res, nil
+}
+
+// storedFieldAdvice suggests stored fields for the indexes over hot
+// prefixes, so the list and search results that read those prefixes can be
+// rendered from the index without reading each record
+func (qe *SimpleQueryEngine) storedFieldAdvice(hot []store.HotPrefix) []string {
+	var advice []string
+	for _, mem := range qe.indexManager.MemoryStats() {
+		prefix := string(qe.indexedPrefix(mem.Field))
+		if prefix == "" || len(qe.indexManager.GetOrCreateIndex(mem.Field).StoredFields()) > 0 {
+			continue
+		}
+		for _, h := range hot {
+			if !strings.HasPrefix(prefix, h.Prefix) && !strings.HasPrefix(h.Prefix, prefix) {
+				continue
+			}
+			advice = append(advice, fmt.Sprintf(
+				"%s takes %.0f%% of recent reads and its index on %s stores no fields; SetStoredFields with the fields results show would serve them from the index",
+				h.Prefix, h.ReadSharePct, mem.Field))
+			break
+		}
+	}
+	return advice
+}
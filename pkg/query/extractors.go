@@ -0,0 +1,231 @@
+package query
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// KeyedFieldExtractor is a FieldExtractor that can also see the record key,
+// so one extractor can decode records of several encodings. The engine
+// prefers ExtractKey whenever an extractor provides it.
+type KeyedFieldExtractor interface {
+	FieldExtractor
+	ExtractKey(key, value []byte, field string) (interface{}, error)
+}
+
+// extractField pulls field out of a record through the extractor, passing
+// the key along when the extractor accepts it
+func extractField(extractor FieldExtractor, key, value []byte, field string) (interface{}, error) {
+	if keyed, ok := extractor.(KeyedFieldExtractor); ok {
+		return keyed.ExtractKey(key, value, field)
+	}
+	return extractor.Extract(value, field)
+}
+
+// extractorRoute is an extractor registered for a key prefix
+type extractorRoute struct {
+	prefix    []byte
+	extractor FieldExtractor
+}
+
+// ExtractorRegistry picks a FieldExtractor per key prefix, so indexes and
+// queries work over stores that mix value encodings. The longest
+// registered prefix of a key wins; keys matching no prefix use the
+// fallback. Safe for concurrent use.
+type ExtractorRegistry struct {
+	routes   []extractorRoute // Longest prefix first
+	fallback FieldExtractor
+	mutex    sync.RWMutex
+}
+
+// NewExtractorRegistry creates a registry that uses fallback for keys no
+// registered prefix matches (JSONFieldExtractor if nil)
+func NewExtractorRegistry(fallback FieldExtractor) *ExtractorRegistry {
+	if fallback == nil {
+		fallback = &JSONFieldExtractor{}
+	}
+	return &ExtractorRegistry{fallback: fallback}
+}
+
+// Register uses extractor for keys starting with prefix, replacing any
+// extractor already registered for the same prefix
+func (r *ExtractorRegistry) Register(prefix []byte, extractor FieldExtractor) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, route := range r.routes {
+		if bytes.Equal(route.prefix, prefix) {
+			r.routes[i].extractor = extractor
+			return
+		}
+	}
+	r.routes = append(r.routes, extractorRoute{prefix: append([]byte(nil), prefix...), extractor: extractor})
+	sort.SliceStable(r.routes, func(i, j int) bool {
+		return len(r.routes[i].prefix) > len(r.routes[j].prefix)
+	})
+}
+
+// ExtractorFor returns the extractor used for key
+func (r *ExtractorRegistry) ExtractorFor(key []byte) FieldExtractor {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, route := range r.routes {
+		if bytes.HasPrefix(key, route.prefix) {
+			return route.extractor
+		}
+	}
+	return r.fallback
+}
+
+// Extract implements FieldExtractor using the fallback, for callers that
+// don't know the key
+func (r *ExtractorRegistry) Extract(value []byte, field string) (interface{}, error) {
+	return r.fallback.Extract(value, field)
+}
+
+// ExtractKey implements KeyedFieldExtractor using the extractor registered
+// for key
+func (r *ExtractorRegistry) ExtractKey(key, value []byte, field string) (interface{}, error) {
+	return r.ExtractorFor(key).Extract(value, field)
+}
+
+// MsgpackFieldExtractor extracts fields from MessagePack-encoded maps.
+// Integers are returned as int64 (uint64 values too large for int64 as
+// float64) and floats as float64, the types the index stores.
+type MsgpackFieldExtractor struct{}
+
+// Extract implements FieldExtractor for MessagePack data
+func (e *MsgpackFieldExtractor) Extract(value []byte, field string) (interface{}, error) {
+	if len(value) == 0 {
+		return nil, fmt.Errorf("empty value")
+	}
+
+	var data map[string]interface{}
+	if err := msgpack.Unmarshal(value, &data); err != nil {
+		return nil, fmt.Errorf("failed to parse msgpack: %w", err)
+	}
+
+	fieldValue, exists := data[field]
+	if !exists {
+		return nil, fmt.Errorf("field '%s' not found in msgpack", field)
+	}
+
+	return normalizeNumber(fieldValue), nil
+}
+
+// normalizeNumber widens the sized integer and float types decoders return
+// to int64 and float64
+func normalizeNumber(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int8:
+		return int64(n)
+	case int16:
+		return int64(n)
+	case int32:
+		return int64(n)
+	case int:
+		return int64(n)
+	case uint8:
+		return int64(n)
+	case uint16:
+		return int64(n)
+	case uint32:
+		return int64(n)
+	case uint64:
+		if n > math.MaxInt64 {
+			return float64(n)
+		}
+		return int64(n)
+	case float32:
+		return float64(n)
+	}
+	return v
+}
+
+// ProtobufFieldExtractor extracts fields from protobuf-encoded messages of
+// one type, described by a descriptor set, so no generated code is needed.
+// Fields are looked up by their proto name, then their JSON name. Enums are
+// returned as their value names and bytes as []byte.
+type ProtobufFieldExtractor struct {
+	message protoreflect.MessageDescriptor
+}
+
+// NewProtobufFieldExtractor creates an extractor for messageName, a fully
+// qualified message name defined in set
+func NewProtobufFieldExtractor(set *descriptorpb.FileDescriptorSet,
+	messageName string) (*ProtobufFieldExtractor, error) {
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor set: %w", err)
+	}
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(messageName))
+	if err != nil {
+		return nil, fmt.Errorf("message %s not found in descriptor set: %w", messageName, err)
+	}
+	message, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message", messageName)
+	}
+	return &ProtobufFieldExtractor{message: message}, nil
+}
+
+// LoadProtobufFieldExtractor creates an extractor for messageName from a
+// binary descriptor set file, such as protoc --descriptor_set_out writes
+func LoadProtobufFieldExtractor(path, messageName string) (*ProtobufFieldExtractor, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // Operator-supplied descriptor path
+	if err != nil {
+		return nil, fmt.Errorf("failed to read descriptor set: %w", err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse descriptor set: %w", err)
+	}
+	return NewProtobufFieldExtractor(&set, messageName)
+}
+
+// Extract implements FieldExtractor for protobuf data. Fields without
+// presence tracking that hold their zero value are returned as that value.
+func (e *ProtobufFieldExtractor) Extract(value []byte, field string) (interface{}, error) {
+	fd := e.message.Fields().ByName(protoreflect.Name(field))
+	if fd == nil {
+		fd = e.message.Fields().ByJSONName(field)
+	}
+	if fd == nil {
+		return nil, fmt.Errorf("field '%s' not defined in %s", field, e.message.FullName())
+	}
+
+	msg := dynamicpb.NewMessage(e.message)
+	if err := proto.Unmarshal(value, msg); err != nil {
+		return nil, fmt.Errorf("failed to parse protobuf: %w", err)
+	}
+	if fd.HasPresence() && !msg.Has(fd) {
+		return nil, fmt.Errorf("field '%s' not set in protobuf", field)
+	}
+
+	v := msg.Get(fd)
+	if fd.IsList() || fd.IsMap() {
+		return v.Interface(), nil
+	}
+	switch fd.Kind() {
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name()), nil
+		}
+		return int64(v.Enum()), nil
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return v.Message().Interface(), nil
+	}
+	return normalizeNumber(v.Interface()), nil
+}
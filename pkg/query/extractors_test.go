@@ -0,0 +1,246 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ssargent/freyjadb/pkg/index"
+	"github.com/ssargent/freyjadb/pkg/store"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// userDescriptorSet describes test.User{name, age, tier, nickname}, with
+// nickname an explicitly optional field
+func userDescriptorSet() *descriptorpb.FileDescriptorSet {
+	field := func(name string, number int32,
+		typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     typ.Enum(),
+			JsonName: proto.String(name),
+		}
+	}
+	tier := field("tier", 3, descriptorpb.FieldDescriptorProto_TYPE_ENUM)
+	tier.TypeName = proto.String(".test.Tier")
+	nickname := field("nickname", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING)
+	nickname.Proto3Optional = proto.Bool(true)
+	nickname.OneofIndex = proto.Int32(0)
+
+	return &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("test/user.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Tier"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("FREE"), Number: proto.Int32(0)},
+				{Name: proto.String("PRO"), Number: proto.Int32(1)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("User"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				field("age", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+				tier,
+				nickname,
+			},
+			OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("_nickname")}},
+		}},
+	}}}
+}
+
+// encodeUser builds a test.User with the extractor's descriptor
+func encodeUser(t *testing.T, e *ProtobufFieldExtractor, name string, age int32, tier protoreflect.EnumNumber) []byte {
+	t.Helper()
+	msg := dynamicpb.NewMessage(e.message)
+	fields := e.message.Fields()
+	msg.Set(fields.ByName("name"), protoreflect.ValueOfString(name))
+	msg.Set(fields.ByName("age"), protoreflect.ValueOfInt32(age))
+	msg.Set(fields.ByName("tier"), protoreflect.ValueOfEnum(tier))
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatalf("Failed to marshal user: %v", err)
+	}
+	return data
+}
+
+func TestMsgpackFieldExtractor_Extract(t *testing.T) {
+	value, err := msgpack.Marshal(map[string]interface{}{
+		"name": "John", "age": uint8(25), "score": float32(1.5), "big": uint64(1 << 63),
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+
+	extractor := &MsgpackFieldExtractor{}
+	tests := []struct {
+		field   string
+		want    interface{}
+		wantErr bool
+	}{
+		{field: "name", want: "John"},
+		{field: "age", want: int64(25)},
+		{field: "score", want: float64(1.5)},
+		{field: "big", want: float64(1 << 63)},
+		{field: "email", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			got, err := extractor.Extract(value, tt.field)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Extract() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Extract() = %v (%T), want %v (%T)", got, got, tt.want, tt.want)
+			}
+		})
+	}
+
+	if _, err := extractor.Extract([]byte(`{"name":"John"}`), "name"); err == nil {
+		t.Error("Expected an error extracting from JSON")
+	}
+}
+
+func TestProtobufFieldExtractor_Extract(t *testing.T) {
+	data, err := proto.Marshal(userDescriptorSet())
+	if err != nil {
+		t.Fatalf("Failed to marshal descriptor set: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "user.pb")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Failed to write descriptor set: %v", err)
+	}
+
+	extractor, err := LoadProtobufFieldExtractor(path, "test.User")
+	if err != nil {
+		t.Fatalf("Failed to load extractor: %v", err)
+	}
+	value := encodeUser(t, extractor, "Ada", 36, 1)
+
+	tests := []struct {
+		field   string
+		want    interface{}
+		wantErr bool
+	}{
+		{field: "name", want: "Ada"},
+		{field: "age", want: int64(36)},
+		{field: "tier", want: "PRO"},
+		{field: "nickname", wantErr: true}, // Optional and unset
+		{field: "email", wantErr: true},    // Not in the message
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			got, err := extractor.Extract(value, tt.field)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Extract() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Extract() = %v (%T), want %v (%T)", got, got, tt.want, tt.want)
+			}
+		})
+	}
+
+	// Zero values of fields without presence are still extracted
+	if got, err := extractor.Extract(encodeUser(t, extractor, "", 0, 0), "age"); err != nil || got != int64(0) {
+		t.Errorf("Expected zero age, got %v, %v", got, err)
+	}
+
+	if _, err := extractor.Extract([]byte{0xff, 0xff}, "name"); err == nil {
+		t.Error("Expected an error for malformed protobuf")
+	}
+	if _, err := NewProtobufFieldExtractor(userDescriptorSet(), "test.Missing"); err == nil {
+		t.Error("Expected an error for an unknown message")
+	}
+	if _, err := NewProtobufFieldExtractor(userDescriptorSet(), "test.Tier"); err == nil {
+		t.Error("Expected an error for a non-message type")
+	}
+}
+
+func TestExtractorRegistry(t *testing.T) {
+	msgpackExtractor := &MsgpackFieldExtractor{}
+	registry := NewExtractorRegistry(nil)
+	registry.Register([]byte("mp:"), msgpackExtractor)
+	registry.Register([]byte("mp:json:"), &JSONFieldExtractor{})
+
+	if _, ok := registry.ExtractorFor([]byte("user:1")).(*JSONFieldExtractor); !ok {
+		t.Error("Expected the JSON fallback for unregistered keys")
+	}
+	if registry.ExtractorFor([]byte("mp:1")) != msgpackExtractor {
+		t.Error("Expected the msgpack extractor for mp: keys")
+	}
+	if _, ok := registry.ExtractorFor([]byte("mp:json:1")).(*JSONFieldExtractor); !ok {
+		t.Error("Expected the longest prefix to win")
+	}
+
+	registry.Register([]byte("mp:json:"), msgpackExtractor)
+	if registry.ExtractorFor([]byte("mp:json:1")) != msgpackExtractor {
+		t.Error("Expected re-registering a prefix to replace its extractor")
+	}
+}
+
+func TestSimpleQueryEngine_MixedEncodings(t *testing.T) {
+	kvStore, err := store.NewKVStore(store.KVStoreConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := kvStore.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer kvStore.Close()
+
+	protoExtractor, err := NewProtobufFieldExtractor(userDescriptorSet(), "test.User")
+	if err != nil {
+		t.Fatalf("Failed to create extractor: %v", err)
+	}
+	packed, err := msgpack.Marshal(map[string]interface{}{"age": 30})
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	records := map[string][]byte{
+		"user:json:1": []byte(`{"age":30}`),
+		"user:json:2": []byte(`{"age":41}`),
+		"user:mp:1":   packed,
+		"user:pb:1":   encodeUser(t, protoExtractor, "Ada", 30, 0),
+	}
+	for key, value := range records {
+		if err := kvStore.Put([]byte(key), value); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+
+	registry := NewExtractorRegistry(nil)
+	registry.Register([]byte("user:mp:"), &MsgpackFieldExtractor{})
+	registry.Register([]byte("user:pb:"), protoExtractor)
+
+	engine := NewSimpleQueryEngine(index.NewIndexManager(4), kvStore)
+	engine.SetIndexedPrefix("age", []byte("user:"))
+	engine.SetExtractors(registry)
+
+	indexed, err := engine.RebuildIndex(context.Background(), "age", nil)
+	if err != nil || indexed != 4 {
+		t.Fatalf("Expected all 4 records indexed, got %d, %v", indexed, err)
+	}
+
+	it, err := engine.ExecuteQuery(context.Background(), "users", FieldQuery{Field: "age", Operator: "=", Value: 30}, nil)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	defer it.Close()
+	var keys []string
+	for it.Next() {
+		keys = append(keys, string(it.Result().Key))
+	}
+	if fmt.Sprint(keys) != "[user:json:1 user:mp:1 user:pb:1]" {
+		t.Errorf("Expected a match in every encoding, got %v", keys)
+	}
+}
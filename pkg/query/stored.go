@@ -0,0 +1,56 @@
+package query
+
+// IndexRecord keeps the index on field consistent with a write of key.
+// oldValue is the record before the write, nil if it didn't exist, and
+// newValue the record after it, nil for a delete. The entry for the old
+// field value is removed and the new one inserted with fresh copies of the
+// index's stored fields (see index.SecondaryIndex.SetStoredFields), so the
+// copies change whenever the record does. Call it after every Put and
+// Delete of a record indexed on field.
+func (qe *SimpleQueryEngine) IndexRecord(field string, key, oldValue, newValue []byte,
+	extractor FieldExtractor) error {
+	if extractor == nil {
+		extractor = qe.defaultExtractor()
+	}
+	idx := qe.indexManager.GetOrCreateIndex(field)
+
+	oldField, hadOld := recordIndexValue(extractor, key, oldValue, field)
+	newField, hasNew := recordIndexValue(extractor, key, newValue, field)
+	if hadOld && (!hasNew || oldField != newField) {
+		idx.Delete(oldField, key)
+	}
+	if !hasNew {
+		return nil
+	}
+	return idx.InsertStored(newField, key, storedValues(extractor, key, newValue, idx.StoredFields()))
+}
+
+// recordIndexValue returns the value a record is indexed under on field,
+// reporting false for a missing record or one the index doesn't hold
+func recordIndexValue(extractor FieldExtractor, key, value []byte, field string) (interface{}, bool) {
+	if value == nil {
+		return nil, false
+	}
+	raw, err := extractField(extractor, key, value, field)
+	if err != nil {
+		return nil, false
+	}
+	return indexValue(raw)
+}
+
+// storedValues copies the stored fields out of a record. Fields the record
+// lacks are left out; nil is returned if it has none of them.
+func storedValues(extractor FieldExtractor, key, value []byte, fields []string) map[string]interface{} {
+	var stored map[string]interface{}
+	for _, field := range fields {
+		raw, err := extractField(extractor, key, value, field)
+		if err != nil {
+			continue
+		}
+		if stored == nil {
+			stored = make(map[string]interface{}, len(fields))
+		}
+		stored[field] = raw
+	}
+	return stored
+}
@@ -35,11 +35,28 @@ func (e *JSONFieldExtractor) Extract(value []byte, field string) (interface{}, e
 	return fieldValue, nil
 }
 
+// Projection controls how much of each matching record a query returns
+type Projection int
+
+const (
+	// ProjectionFull fetches every matching record's value from the KV store
+	ProjectionFull Projection = iota
+	// ProjectionIndexOnly answers the query from the secondary index alone,
+	// returning the primary key and indexed field value without reading the
+	// KV store. Useful for existence and count style queries.
+	ProjectionIndexOnly
+	// ProjectionLazy yields keys and indexed field values first and defers
+	// reading record values until QueryResult.LoadValue is called or the
+	// iterator prefetches them in a batch.
+	ProjectionLazy
+)
+
 // FieldQuery represents a single field-based query condition
 type FieldQuery struct {
-	Field    string      // Field name to query (e.g., "age", "name")
-	Operator string      // Comparison operator: "=", ">", "<", ">=", "<="
-	Value    interface{} // Value to compare against
+	Field      string      // Field name to query (e.g., "age", "name")
+	Operator   string      // Comparison operator: "=", ">", "<", ">=", "<="
+	Value      interface{} // Value to compare against
+	Projection Projection  // What to return for each match (default: full record)
 }
 
 // Validate checks if the query is properly formed
@@ -59,10 +76,48 @@ func (q *FieldQuery) Validate() error {
 	return nil
 }
 
+// Matches reports whether a field value satisfies the condition. Values are
+// compared as the index orders them: numbers by value, before any string,
+// and anything else by its string form. The query must be valid.
+func (q *FieldQuery) Matches(value interface{}) bool {
+	cmp := compareValues(value, q.Value)
+	switch q.Operator {
+	case "=":
+		return cmp == 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
 // QueryResult represents a single query result
 type QueryResult struct {
-	Key   []byte // The record key
-	Value []byte // The record value
+	Key        []byte      // The record key
+	Value      []byte      // The record value (empty for index-only projections)
+	FieldValue interface{} // The indexed field value as stored in the index
+	Degraded   bool        // Found by a primary scan because the index was unavailable
+
+	// Stored holds the index's copies of the record's stored fields, so
+	// summaries can be rendered without reading Value. Nil if the index
+	// keeps none.
+	Stored map[string]interface{}
+
+	loader func() ([]byte, error) // Deferred value fetch for lazy projections
+}
+
+// LoadValue returns the record value. For lazily projected results the value
+// is fetched from the KV store on demand; otherwise Value is returned as is.
+func (r QueryResult) LoadValue() ([]byte, error) {
+	if r.Value != nil || r.loader == nil {
+		return r.Value, nil
+	}
+	return r.loader()
 }
 
 // QueryIterator provides streaming access to query results
@@ -72,6 +127,14 @@ type QueryIterator interface {
 	Close() error
 }
 
+// LazyQueryIterator is returned for ProjectionLazy queries. Prefetch loads the
+// values of the next n results with a single batched read sorted by file
+// offset, so callers that filter on keys first only pay IO for survivors.
+type LazyQueryIterator interface {
+	QueryIterator
+	Prefetch(n int) error
+}
+
 // QueryEngine handles query execution
 type QueryEngine interface {
 	ExecuteQuery(ctx context.Context, partitionKey string, query FieldQuery,
@@ -129,3 +129,22 @@ func BenchmarkFieldQuery_Validate(b *testing.B) {
 		_ = query.Validate()
 	}
 }
+
+func TestFieldQuery_Matches(t *testing.T) {
+	tests := []struct {
+		query FieldQuery
+		value interface{}
+		want  bool
+	}{
+		{FieldQuery{Field: "age", Operator: "=", Value: float64(30)}, int64(30), true},
+		{FieldQuery{Field: "age", Operator: ">=", Value: 30}, float64(29.5), false},
+		{FieldQuery{Field: "age", Operator: "<", Value: 30}, float64(29.5), true},
+		{FieldQuery{Field: "age", Operator: ">", Value: 30}, "31", true}, // Strings sort after numbers
+		{FieldQuery{Field: "status", Operator: "=", Value: "active"}, "active", true},
+		{FieldQuery{Field: "status", Operator: "<=", Value: "b"}, "active", true},
+		{FieldQuery{Field: "admin", Operator: "=", Value: true}, true, true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.query.Matches(tt.value), "%v %s %v", tt.value, tt.query.Operator, tt.query.Value)
+	}
+}
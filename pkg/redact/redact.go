@@ -0,0 +1,201 @@
+// Package redact transforms exported key-value pairs so data can be shared
+// for debugging or support without its sensitive parts.
+//
+// A Transform skips keys under some prefixes entirely and rewrites fields of
+// JSON values by path: dropping them, replacing them with a fixed value, or
+// replacing them with a hash of their value. Hashes are stable, so redacted
+// values can still be joined and counted. Paths are field names joined by
+// dots, e.g. "user.email"; a path through an array applies to each element.
+package redact
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Actions a Rule can take on a field
+const (
+	ActionDrop    = "drop"
+	ActionHash    = "hash"
+	ActionReplace = "replace"
+)
+
+// hashPrefix marks hashed values, so they aren't mistaken for real ones
+const hashPrefix = "sha256:"
+
+// Rule rewrites one field of JSON values
+type Rule struct {
+	Path        string
+	Action      string
+	Replacement interface{} // Set for ActionReplace
+}
+
+// Options lists the transforms of one export, as given on a command line
+// or in query parameters
+type Options struct {
+	Drop         []string // Paths to remove
+	Hash         []string // Paths to replace with a hash of their value
+	Replace      []string // path=value; value is JSON if it parses, else a string
+	SkipPrefixes []string // Keys to leave out of the export
+}
+
+// Transform redacts an export. A nil Transform changes nothing.
+type Transform struct {
+	Rules        []Rule
+	SkipPrefixes []string
+}
+
+// New builds a Transform from opts. Rules are applied drops first, then
+// hashes, then replacements. It returns nil if opts holds no transforms.
+func New(opts Options) (*Transform, error) {
+	t := &Transform{SkipPrefixes: opts.SkipPrefixes}
+	for _, path := range opts.Drop {
+		t.Rules = append(t.Rules, Rule{Path: path, Action: ActionDrop})
+	}
+	for _, path := range opts.Hash {
+		t.Rules = append(t.Rules, Rule{Path: path, Action: ActionHash})
+	}
+	for _, spec := range opts.Replace {
+		path, raw, ok := strings.Cut(spec, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid replacement %q: want path=value", spec)
+		}
+		var value interface{} = raw
+		var parsed interface{}
+		if json.Unmarshal([]byte(raw), &parsed) == nil {
+			value = parsed
+		}
+		t.Rules = append(t.Rules, Rule{Path: path, Action: ActionReplace, Replacement: value})
+	}
+
+	for _, rule := range t.Rules {
+		if err := validatePath(rule.Path); err != nil {
+			return nil, err
+		}
+	}
+	for _, prefix := range t.SkipPrefixes {
+		if prefix == "" {
+			return nil, fmt.Errorf("skip prefix must not be empty")
+		}
+	}
+	if len(t.Rules) == 0 && len(t.SkipPrefixes) == 0 {
+		return nil, nil
+	}
+	return t, nil
+}
+
+func validatePath(path string) error {
+	for _, part := range strings.Split(path, ".") {
+		if part == "" {
+			return fmt.Errorf("invalid field path %q", path)
+		}
+	}
+	return nil
+}
+
+// Skip reports whether key is left out of the export
+func (t *Transform) Skip(key string) bool {
+	_, skip := t.SkippedBy(key)
+	return skip
+}
+
+// SkippedBy returns the skip prefix that leaves key out of the export, if
+// any
+func (t *Transform) SkippedBy(key string) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	for _, prefix := range t.SkipPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return prefix, true
+		}
+	}
+	return "", false
+}
+
+// Apply rewrites the fields of a JSON value. Values that aren't JSON are
+// returned unchanged, since they have no fields; leave them out with
+// SkipPrefixes if they may hold sensitive data. Numbers keep their exact
+// text, but object keys come back sorted and without insignificant space.
+func (t *Transform) Apply(value []byte) ([]byte, error) {
+	if t == nil || len(t.Rules) == 0 || !json.Valid(value) {
+		return value, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	for _, rule := range t.Rules {
+		var err error
+		if doc, err = rule.apply(doc, strings.Split(rule.Path, ".")); err != nil {
+			return nil, fmt.Errorf("failed to %s %s: %w", rule.Action, rule.Path, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(doc); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// apply rewrites the field at path under node, returning the new node.
+// Missing fields are left alone.
+func (r Rule) apply(node interface{}, path []string) (interface{}, error) {
+	switch node := node.(type) {
+	case []interface{}:
+		for i, elem := range node {
+			var err error
+			if node[i], err = r.apply(elem, path); err != nil {
+				return nil, err
+			}
+		}
+		return node, nil
+	case map[string]interface{}:
+		field, ok := node[path[0]]
+		if !ok {
+			return node, nil
+		}
+		if len(path) > 1 {
+			var err error
+			node[path[0]], err = r.apply(field, path[1:])
+			return node, err
+		}
+
+		switch r.Action {
+		case ActionDrop:
+			delete(node, path[0])
+		case ActionHash:
+			hashed, err := hashValue(field)
+			if err != nil {
+				return nil, err
+			}
+			node[path[0]] = hashed
+		case ActionReplace:
+			node[path[0]] = r.Replacement
+		default:
+			return nil, fmt.Errorf("unknown action %q", r.Action)
+		}
+	}
+	return node, nil
+}
+
+// hashValue returns the SHA-256 of a value's JSON encoding, so equal values
+// hash alike whatever their type
+func hashValue(value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hashPrefix + hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,95 @@
+package redact
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTransform_Apply(t *testing.T) {
+	transform, err := New(Options{
+		Drop:    []string{"password", "cards.cvv"},
+		Hash:    []string{"email", "missing.field"},
+		Replace: []string{"address.street=REDACTED", "score=0"},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	out, err := transform.Apply([]byte(`{"name":"bob","email":"bob@example.com","password":"x",` +
+		`"address":{"street":"1 Main St","city":"Oslo"},"score":12345678901234567890,` +
+		`"cards":[{"last4":"4242","cvv":"123"},{"last4":"1881","cvv":"456"}]}`))
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(out, &doc); err != nil {
+		t.Fatalf("Apply returned invalid JSON %s: %v", out, err)
+	}
+	if _, ok := doc["password"]; ok {
+		t.Error("Expected password to be dropped")
+	}
+	email, _ := doc["email"].(string)
+	if !strings.HasPrefix(email, hashPrefix) || strings.Contains(string(out), "bob@example.com") {
+		t.Errorf("Expected email to be hashed, got %q", email)
+	}
+	if doc["address"].(map[string]interface{})["street"] != "REDACTED" || doc["address"].(map[string]interface{})["city"] != "Oslo" {
+		t.Errorf("Unexpected address %v", doc["address"])
+	}
+	if doc["score"] != float64(0) {
+		t.Errorf("Expected score to be replaced by 0, got %v", doc["score"])
+	}
+	for _, card := range doc["cards"].([]interface{}) {
+		if _, ok := card.(map[string]interface{})["cvv"]; ok {
+			t.Errorf("Expected cvv to be dropped from every card, got %v", card)
+		}
+	}
+
+	// Equal values hash alike, so redacted exports can still be joined
+	again, _ := transform.Apply([]byte(`{"email":"bob@example.com"}`))
+	if !strings.Contains(string(again), email) {
+		t.Errorf("Expected a stable hash, got %s", again)
+	}
+
+	// Values without fields pass through
+	for _, value := range []string{"plain text", `"a string"`, "42"} {
+		if out, err := transform.Apply([]byte(value)); err != nil || string(out) != value {
+			t.Errorf("Apply(%q) = %q, %v", value, out, err)
+		}
+	}
+}
+
+func TestTransform_Skip(t *testing.T) {
+	transform, err := New(Options{SkipPrefixes: []string{"session:", "apikey:"}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if !transform.Skip("session:1") || transform.Skip("user:1") {
+		t.Error("Unexpected Skip result")
+	}
+
+	var none *Transform
+	if none.Skip("session:1") {
+		t.Error("Expected a nil Transform to skip nothing")
+	}
+	if out, _ := none.Apply([]byte(`{"a":1}`)); string(out) != `{"a":1}` {
+		t.Errorf("Expected a nil Transform to change nothing, got %s", out)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if transform, err := New(Options{}); err != nil || transform != nil {
+		t.Errorf("Expected no transform for empty options, got %v, %v", transform, err)
+	}
+	for _, opts := range []Options{
+		{Drop: []string{"a..b"}},
+		{Hash: []string{""}},
+		{Replace: []string{"novalue"}},
+		{SkipPrefixes: []string{""}},
+	} {
+		if _, err := New(opts); err == nil {
+			t.Errorf("Expected %+v to be rejected", opts)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Access tracking and the advisory Explain derives from it
+const (
+	accessHalfLife     = 5 * time.Minute // A read counts half as much this long after it happened
+	maxAccessPrefixes  = 4096            // Prefixes tracked; colder ones are dropped to make room
+	minAdvisoryReads   = 10.0            // Decayed reads needed before the advisory says anything
+	hotPrefixSharePct  = 10.0            // Prefixes taking at least this share of reads are hot
+	maxHotPrefixes     = 10              // Hot prefixes reported
+	cacheReadCoverage  = 0.8             // Share of reads the suggested cache size covers
+	cacheWorthwhilePct = 50.0            // A cache is suggested only if it holds less than this share of user data
+)
+
+// Advisory turns the store's access statistics into tuning suggestions.
+// Point reads are counted per key prefix, the first key component and its
+// delimiter, with exponential decay, so the numbers follow the recent
+// workload rather than everything since the store was opened.
+type Advisory struct {
+	HotPrefixes []HotPrefix `json:"hot_prefixes,omitempty"`
+
+	// SuggestedCacheMB is the size of the hottest prefixes' records that
+	// together take most of the reads, i.e. a Cache MaxBytes that would
+	// serve them; 0 when there are too few reads to judge
+	SuggestedCacheMB float64 `json:"suggested_cache_mb"`
+
+	Recommendations []string `json:"recommendations,omitempty"`
+}
+
+// HotPrefix is a key prefix taking a large share of recent reads
+type HotPrefix struct {
+	Prefix       string  `json:"prefix"`
+	ReadsPerSec  float64 `json:"reads_per_sec"`  // Decayed read rate
+	ReadSharePct float64 `json:"read_share_pct"` // Of all decayed reads
+	Keys         int     `json:"keys"`
+	SizeMB       float64 `json:"size_mb"` // Record bytes of the prefix's live keys
+}
+
+// accessCounter is a read count that halves every accessHalfLife
+type accessCounter struct {
+	reads float64
+	at    time.Time // When reads was last brought up to date
+}
+
+// decayed returns the count as of now
+func (c *accessCounter) decayed(now time.Time) float64 {
+	return c.reads * math.Exp2(-now.Sub(c.at).Seconds()/accessHalfLife.Seconds())
+}
+
+// accessStats counts point reads per key prefix (guarded by kv.mutex)
+type accessStats struct {
+	prefixes map[string]*accessCounter
+}
+
+// record counts a read of key at now. Keys without the delimiter have no
+// prefix and aren't counted.
+func (a *accessStats) record(key []byte, delimiter byte, now time.Time) {
+	i := bytes.IndexByte(key, delimiter)
+	if i < 0 {
+		return
+	}
+	c, ok := a.prefixes[string(key[:i+1])]
+	if !ok {
+		if a.prefixes == nil {
+			a.prefixes = make(map[string]*accessCounter)
+		}
+		if len(a.prefixes) >= maxAccessPrefixes && !a.prune(now) {
+			return
+		}
+		c = &accessCounter{at: now}
+		a.prefixes[string(key[:i+1])] = c
+	}
+	c.reads = c.decayed(now) + 1
+	c.at = now
+}
+
+// prune drops prefixes whose count has decayed below one read, reporting
+// whether that made room for another
+func (a *accessStats) prune(now time.Time) bool {
+	for prefix, c := range a.prefixes {
+		if c.decayed(now) < 1 {
+			delete(a.prefixes, prefix)
+		}
+	}
+	return len(a.prefixes) < maxAccessPrefixes
+}
+
+// reset forgets every count
+func (a *accessStats) reset() {
+	a.prefixes = nil
+}
+
+// advisoryInternal builds the Explain advisory as of now (caller must hold
+// the mutex)
+func (kv *KVStore) advisoryInternal(now time.Time) Advisory {
+	var advisory Advisory
+
+	type prefixReads struct {
+		prefix string
+		reads  float64
+		stats  PrefixStats
+	}
+	var prefixes []prefixReads
+	var total float64
+	for prefix, c := range kv.access.prefixes {
+		if kv.index.isInternal(prefix) {
+			continue
+		}
+		reads := c.decayed(now)
+		prefixes = append(prefixes, prefixReads{prefix: prefix, reads: reads})
+		total += reads
+	}
+	if total < minAdvisoryReads {
+		return advisory
+	}
+	for i := range prefixes {
+		prefixes[i].stats = kv.index.PrefixStats(prefixes[i].prefix)
+	}
+
+	// A steady rate r accumulates to r*tau*(1-e^(-t/tau)) after t, so the
+	// rate is recovered from a count even shortly after open
+	tau := accessHalfLife.Seconds() / math.Ln2
+	window := tau * (1 - math.Exp(-now.Sub(kv.openedAt).Seconds()/tau))
+	if window <= 0 {
+		window = tau
+	}
+
+	sort.Slice(prefixes, func(i, j int) bool {
+		if prefixes[i].reads != prefixes[j].reads {
+			return prefixes[i].reads > prefixes[j].reads
+		}
+		return prefixes[i].prefix < prefixes[j].prefix
+	})
+	for _, p := range prefixes {
+		share := p.reads / total * 100
+		if share < hotPrefixSharePct || len(advisory.HotPrefixes) == maxHotPrefixes {
+			break
+		}
+		advisory.HotPrefixes = append(advisory.HotPrefixes, HotPrefix{
+			Prefix:       p.prefix,
+			ReadsPerSec:  p.reads / window,
+			ReadSharePct: share,
+			Keys:         p.stats.Keys,
+			SizeMB:       toMB(p.stats.Bytes),
+		})
+	}
+
+	// The cache that serves most reads for the fewest bytes holds the
+	// prefixes with the most reads per byte
+	sort.SliceStable(prefixes, func(i, j int) bool {
+		return prefixes[i].reads*float64(max(prefixes[j].stats.Bytes, 1)) >
+			prefixes[j].reads*float64(max(prefixes[i].stats.Bytes, 1))
+	})
+	var covered float64
+	var cacheBytes int64
+	var cached []string
+	for _, p := range prefixes {
+		if covered >= cacheReadCoverage*total {
+			break
+		}
+		covered += p.reads
+		cacheBytes += p.stats.Bytes
+		cached = append(cached, p.prefix)
+	}
+	advisory.SuggestedCacheMB = toMB(cacheBytes)
+
+	stats := kv.index.Stats()
+	userBytes := stats.LiveBytes - stats.InternalBytes
+	if cacheBytes > 0 && float64(cacheBytes) < float64(userBytes)*cacheWorthwhilePct/100 {
+		sort.Strings(cached)
+		advisory.Recommendations = append(advisory.Recommendations, fmt.Sprintf(
+			"%.0f%% of recent reads go to %s, %.2f MB of %.2f MB of user data; a Cache with MaxBytes of about %.2f MB would serve them from memory",
+			covered/total*100, strings.Join(cached, ", "), toMB(cacheBytes), toMB(userBytes), toMB(cacheBytes)))
+	}
+	return advisory
+}
@@ -0,0 +1,270 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// BackupManifest describes a consistent on-disk image of the store. Every
+// byte of each listed segment up to Size is durable, so a filesystem or
+// volume snapshot taken while the store is frozen can be restored by
+// truncating each segment to the recorded size.
+type BackupManifest struct {
+	DataDir   string            `json:"data_dir"`
+	CreatedAt time.Time         `json:"created_at"`
+	Keys      int               `json:"keys"`
+	CommitSeq uint64            `json:"commit_seq,omitempty"` // The store's CommitSeq at the image
+	Segments  []SegmentManifest `json:"segments"`
+
+	// Set by Backup when it encrypts: how the segments were encrypted, and
+	// an HMAC of the rest of the manifest that restore and verify check
+	Encryption *BackupEncryption `json:"encryption,omitempty"`
+	Signature  string            `json:"signature,omitempty"`
+}
+
+// SegmentManifest records a single segment file and its durable length
+type SegmentManifest struct {
+	FileID uint32 `json:"file_id"`
+	Path   string `json:"path"` // Relative to DataDir, or absolute if stored in another data directory
+	Size   int64  `json:"size"`
+
+	// SHA256 is the hex digest of the segment as stored in a backup made
+	// by Backup, after any encryption; empty for other backups
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// BackupOptions controls Backup
+type BackupOptions struct {
+	// Keys, when set, encrypts the backup with a new data key wrapped by
+	// Keys and signs its manifest
+	Keys KeyWrapper
+}
+
+// ErrNotFrozen is returned by Thaw when there is no outstanding Freeze
+var ErrNotFrozen = &KVError{"store is not frozen"}
+
+// Freeze flushes and fsyncs the active segment, then blocks rotation and
+// compaction until Thaw is called. Writes keep appending while frozen, which
+// is safe for backups because the returned manifest pins each segment's
+// durable length. Freeze calls nest; each must be paired with a Thaw.
+func (kv *KVStore) Freeze() (*BackupManifest, error) {
+	var manifest *BackupManifest
+	if err := kv.freeze(func() {
+		manifest = kv.buildManifestInternal()
+	}); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// freeze does the work of Freeze, calling capture with the mutex held once
+// the active segment is synced, so capture sees exactly the durable state
+func (kv *KVStore) freeze(capture func()) error {
+	kv.freezeMutex.Lock()
+	defer kv.freezeMutex.Unlock()
+
+	// Hold off segment maintenance first so what capture sees can't go
+	// stale. Only the outermost freeze read-locks: a nested RLock would
+	// queue behind a waiting Rotate, which itself waits on the first freeze.
+	outermost := kv.freezeCount == 0
+	if outermost {
+		kv.maintenance.RLock()
+	}
+	release := func() {
+		if outermost {
+			kv.maintenance.RUnlock()
+		}
+	}
+
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if err := kv.checkOpenInternal(); err != nil {
+		release()
+		return err
+	}
+
+	if err := kv.writer.Sync(); err != nil {
+		release()
+		return fmt.Errorf("failed to sync before freeze: %w", err)
+	}
+
+	kv.freezeCount++
+	capture()
+	return nil
+}
+
+// Thaw releases one Freeze, allowing rotation and compaction to resume once
+// all outstanding freezes have been released
+func (kv *KVStore) Thaw() error {
+	kv.freezeMutex.Lock()
+	defer kv.freezeMutex.Unlock()
+
+	if kv.freezeCount == 0 {
+		return ErrNotFrozen
+	}
+	kv.freezeCount--
+	if kv.freezeCount == 0 {
+		kv.maintenance.RUnlock()
+	}
+	return nil
+}
+
+// WithConsistentView freezes the store, hands the manifest to fn and thaws
+// the store again once fn returns, regardless of its outcome
+func (kv *KVStore) WithConsistentView(fn func(*BackupManifest) error) error {
+	manifest, err := kv.Freeze()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = kv.Thaw()
+	}()
+
+	return fn(manifest)
+}
+
+// buildManifestInternal snapshots segment sizes without acquiring the mutex
+func (kv *KVStore) buildManifestInternal() *BackupManifest {
+	manifest := &BackupManifest{
+		DataDir:   kv.config.DataDir,
+		CreatedAt: time.Now(),
+		Keys:      kv.index.Size(),
+		CommitSeq: kv.commitSeqInternal(),
+	}
+
+	for _, seg := range kv.segments.list() {
+		size := kv.writer.Size()
+		if seg.FileID != activeFileID {
+			info, err := os.Stat(seg.Path)
+			if err != nil {
+				continue
+			}
+			size = info.Size()
+		}
+		manifest.Segments = append(manifest.Segments, SegmentManifest{
+			FileID: seg.FileID,
+			Path:   kv.manifestPath(seg.Path),
+			Size:   size,
+		})
+	}
+
+	return manifest
+}
+
+// manifestPath makes a segment path relative to the primary data directory
+// when possible so manifests stay valid if that directory is moved
+func (kv *KVStore) manifestPath(path string) string {
+	if rel, err := filepath.Rel(kv.config.DataDir, path); err == nil && filepath.IsLocal(rel) {
+		return rel
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// Backup copies a consistent image of the store into dir, which must be
+// empty or not exist, and saves the manifest VerifyBackup and RestoreBackup
+// read. The store is frozen while the segments are copied, so writes
+// continue but compaction waits. With opts.Keys every segment is encrypted
+// and the manifest is signed.
+func (kv *KVStore) Backup(dir string, opts BackupOptions) (*BackupManifest, error) {
+	if err := emptyBackupDir(dir); err != nil {
+		return nil, err
+	}
+
+	var backup *BackupManifest
+	err := kv.WithConsistentView(func(manifest *BackupManifest) error {
+		var err error
+		backup, err = kv.copyBackup(dir, manifest, opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return backup, nil
+}
+
+// emptyBackupDir creates dir, failing if it already holds anything
+func emptyBackupDir(dir string) error {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	if entries, err := os.ReadDir(dir); err != nil {
+		return err
+	} else if len(entries) > 0 {
+		return &KVError{fmt.Sprintf("backup directory %s is not empty", dir)}
+	}
+	return nil
+}
+
+// copyBackup copies the segments of manifest, taken by a Freeze that is
+// still held, into dir and saves the manifest there, encrypted and signed
+// with opts.Keys
+func (kv *KVStore) copyBackup(dir string, manifest *BackupManifest, opts BackupOptions) (*BackupManifest, error) {
+	var sealer *backupCipher
+	if opts.Keys != nil {
+		var err error
+		if sealer, manifest.Encryption, err = newBackupCipher(opts.Keys); err != nil {
+			return nil, err
+		}
+	}
+	for i := range manifest.Segments {
+		if err := kv.copySegment(dir, &manifest.Segments[i], sealer); err != nil {
+			return nil, fmt.Errorf("failed to back up segment %d: %w", manifest.Segments[i].FileID, err)
+		}
+	}
+
+	if sealer != nil {
+		var err error
+		if manifest.Signature, err = sealer.sign(manifest); err != nil {
+			return nil, err
+		}
+	}
+	if err := SaveBackupManifest(dir, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, syncDir(dir)
+}
+
+// copySegment copies the durable part of a segment into a backup, sealing
+// it if sealer is set, and records the copy's digest. The copy is
+// background IO, so it yields to client reads and writes.
+func (kv *KVStore) copySegment(dir string, seg *SegmentManifest, sealer *backupCipher) error {
+	path := seg.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(kv.config.DataDir, path)
+	}
+	src, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	in := &backgroundReader{ctx: context.Background(), io: kv.io, r: src}
+
+	dst := backupSegmentPath(dir, seg.Path)
+	if err := os.MkdirAll(filepath.Dir(dst), 0750); err != nil {
+		return err
+	}
+	digest := sha256.New()
+	err = finalizeFile(dst, func(w io.Writer) error {
+		w = io.MultiWriter(w, digest)
+		if sealer != nil {
+			return sealer.encryptSegment(w, in, seg.FileID, seg.Size)
+		}
+		_, err := io.CopyN(w, in, seg.Size)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	seg.SHA256 = hex.EncodeToString(digest.Sum(nil))
+	return nil
+}
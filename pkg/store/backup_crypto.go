@@ -0,0 +1,281 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Backup encryption parameters
+const (
+	backupAlgorithm = "AES-256-GCM"
+	backupChunkSize = 64 * 1024 // Plaintext bytes sealed per chunk
+	backupKeySize   = 32
+)
+
+var (
+	// ErrBackupEncrypted is returned when an encrypted backup is read
+	// without a key
+	ErrBackupEncrypted = &KVError{"backup is encrypted; a backup key is required"}
+
+	// ErrBackupSignature is returned when a backup manifest doesn't match
+	// its signature, i.e. it was altered or signed with another key
+	ErrBackupSignature = errors.New("backup manifest signature does not match")
+)
+
+// KeyWrapper protects the data key a backup is encrypted with. MasterKey
+// wraps it with a key held in memory; a KMS client can implement
+// KeyWrapper so the backup master key never leaves the KMS.
+type KeyWrapper interface {
+	// WrapKey encrypts a data key, returning it with the ID of the
+	// wrapping key
+	WrapKey(dataKey []byte) (wrapped []byte, keyID string, err error)
+	// UnwrapKey decrypts a data key wrapped by the key keyID
+	UnwrapKey(wrapped []byte, keyID string) ([]byte, error)
+}
+
+// MasterKey is a backup master key held in memory. Use a key different from
+// the at-rest encryption key, so a leaked backup key exposes only backups.
+type MasterKey struct {
+	aead cipher.AEAD
+	id   string
+}
+
+// NewMasterKey derives a backup master key from secret, as the system store
+// derives its key: AES-256 keyed by the secret's SHA-256. The secret should
+// hold at least 32 random bytes, e.g. from openssl rand -hex 32.
+func NewMasterKey(secret []byte) (*MasterKey, error) {
+	if len(secret) == 0 {
+		return nil, &KVError{"backup key must not be empty"}
+	}
+	key := sha256.Sum256(secret)
+	aead, err := newBackupAEAD(key[:])
+	if err != nil {
+		return nil, err
+	}
+	id := sha256.Sum256(append([]byte("freyja-backup-key-id:"), key[:]...))
+	return &MasterKey{aead: aead, id: hex.EncodeToString(id[:8])}, nil
+}
+
+// ID identifies the key in backup manifests without revealing it
+func (m *MasterKey) ID() string {
+	return m.id
+}
+
+// WrapKey implements KeyWrapper
+func (m *MasterKey) WrapKey(dataKey []byte) ([]byte, string, error) {
+	nonce := make([]byte, m.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, "", err
+	}
+	return m.aead.Seal(nonce, nonce, dataKey, []byte(m.id)), m.id, nil
+}
+
+// UnwrapKey implements KeyWrapper
+func (m *MasterKey) UnwrapKey(wrapped []byte, keyID string) ([]byte, error) {
+	if keyID != m.id {
+		return nil, &KVError{fmt.Sprintf("backup was encrypted with key %s, not %s", keyID, m.id)}
+	}
+	size := m.aead.NonceSize()
+	if len(wrapped) < size {
+		return nil, &KVError{"wrapped backup key is truncated"}
+	}
+	dataKey, err := m.aead.Open(nil, wrapped[:size], wrapped[size:], []byte(m.id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap backup key: %w", err)
+	}
+	return dataKey, nil
+}
+
+// BackupEncryption records how a backup's segments were encrypted. Each
+// backup has its own random data key, stored only wrapped by the backup
+// master key.
+type BackupEncryption struct {
+	Algorithm  string `json:"algorithm"`
+	KeyID      string `json:"key_id"`      // The master key that wrapped the data key
+	WrappedKey []byte `json:"wrapped_key"` // Base64 in JSON
+	ChunkSize  int    `json:"chunk_size"`
+}
+
+// backupCipher encrypts and authenticates one backup with its data key
+type backupCipher struct {
+	aead      cipher.AEAD
+	macKey    []byte
+	chunkSize int
+}
+
+// newBackupCipher creates a data key, wrapped by keys, for a new backup
+func newBackupCipher(keys KeyWrapper) (*backupCipher, *BackupEncryption, error) {
+	dataKey := make([]byte, backupKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	wrapped, keyID, err := keys.WrapKey(dataKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to wrap backup key: %w", err)
+	}
+	enc := &BackupEncryption{Algorithm: backupAlgorithm, KeyID: keyID, WrappedKey: wrapped, ChunkSize: backupChunkSize}
+	c, err := backupCipherFor(dataKey, enc.ChunkSize)
+	return c, enc, err
+}
+
+// openBackupCipher unwraps the data key of an encrypted backup
+func openBackupCipher(enc *BackupEncryption, keys KeyWrapper) (*backupCipher, error) {
+	if keys == nil {
+		return nil, ErrBackupEncrypted
+	}
+	if enc.Algorithm != backupAlgorithm || enc.ChunkSize <= 0 {
+		return nil, &KVError{fmt.Sprintf("unsupported backup encryption %s with %d byte chunks", enc.Algorithm, enc.ChunkSize)}
+	}
+	dataKey, err := keys.UnwrapKey(enc.WrappedKey, enc.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	return backupCipherFor(dataKey, enc.ChunkSize)
+}
+
+func backupCipherFor(dataKey []byte, chunkSize int) (*backupCipher, error) {
+	if len(dataKey) != backupKeySize {
+		return nil, &KVError{"backup data key has the wrong size"}
+	}
+	aead, err := newBackupAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	mac := sha256.Sum256(append([]byte("freyja-backup-signature:"), dataKey...))
+	return &backupCipher{aead: aead, macKey: mac[:], chunkSize: chunkSize}, nil
+}
+
+func newBackupAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptedSize is the size of a segment of size bytes once encrypted: its
+// chunks, each with a tag, and at least one chunk so an empty segment is
+// authenticated too
+func (c *backupCipher) encryptedSize(size int64) int64 {
+	chunks := max((size+int64(c.chunkSize)-1)/int64(c.chunkSize), 1)
+	return size + chunks*int64(c.aead.Overhead())
+}
+
+// nonce identifies a chunk. The data key is used for one backup only, and
+// within it each segment's FileID and chunk number are unique.
+func (c *backupCipher) nonce(fileID uint32, chunk uint64) []byte {
+	nonce := make([]byte, c.aead.NonceSize())
+	binary.BigEndian.PutUint32(nonce, fileID)
+	binary.BigEndian.PutUint64(nonce[4:], chunk)
+	return nonce
+}
+
+// chunkAAD marks the last chunk, so a truncated segment fails to decrypt
+// instead of looking complete
+func chunkAAD(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// encryptSegment writes the first size bytes of src to dst in sealed chunks
+func (c *backupCipher) encryptSegment(dst io.Writer, src io.Reader, fileID uint32, size int64) error {
+	buf := make([]byte, c.chunkSize)
+	var sealed []byte
+	for chunk := uint64(0); ; chunk++ {
+		n := int(min(size, int64(c.chunkSize)))
+		if _, err := io.ReadFull(src, buf[:n]); err != nil {
+			return err
+		}
+		size -= int64(n)
+		sealed = c.aead.Seal(sealed[:0], c.nonce(fileID, chunk), buf[:n], chunkAAD(size == 0))
+		if _, err := dst.Write(sealed); err != nil {
+			return err
+		}
+		if size == 0 {
+			return nil
+		}
+	}
+}
+
+// decryptingReader reads the plaintext of an encrypted segment
+type decryptingReader struct {
+	c         *backupCipher
+	src       io.Reader
+	fileID    uint32
+	remaining int64 // Plaintext bytes not yet decrypted
+	chunk     uint64
+	sealed    []byte
+	plain     []byte // Decrypted bytes not yet read
+	started   bool
+}
+
+// decryptSegment returns a reader of the size plaintext bytes encrypted in src
+func (c *backupCipher) decryptSegment(src io.Reader, fileID uint32, size int64) io.Reader {
+	return &decryptingReader{c: c, src: src, fileID: fileID, remaining: size,
+		sealed: make([]byte, c.chunkSize+c.aead.Overhead())}
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.remaining == 0 && r.started {
+			return 0, io.EOF
+		}
+		n := int(min(r.remaining, int64(r.c.chunkSize)))
+		sealed := r.sealed[:n+r.c.aead.Overhead()]
+		if _, err := io.ReadFull(r.src, sealed); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		r.remaining -= int64(n)
+		plain, err := r.c.aead.Open(sealed[:0], r.c.nonce(r.fileID, r.chunk), sealed, chunkAAD(r.remaining == 0))
+		if err != nil {
+			return 0, fmt.Errorf("segment %d chunk %d failed authentication: %w", r.fileID, r.chunk, err)
+		}
+		r.chunk++
+		r.started = true
+		r.plain = plain
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// sign computes the manifest's signature: an HMAC-SHA256 of its JSON
+// without the signature, keyed from the data key, so only holders of the
+// backup master key can produce or check it
+func (c *backupCipher) sign(manifest *BackupManifest) (string, error) {
+	unsigned := *manifest
+	unsigned.Signature = ""
+	data, err := json.Marshal(&unsigned)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, c.macKey)
+	mac.Write(data) //nolint:errcheck // hash writes never fail
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// checkSignature verifies a signed manifest
+func (c *backupCipher) checkSignature(manifest *BackupManifest) error {
+	want, err := c.sign(manifest)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(want), []byte(manifest.Signature)) {
+		return ErrBackupSignature
+	}
+	return nil
+}
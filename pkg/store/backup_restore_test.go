@@ -0,0 +1,163 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newBackupSource opens a memtable store holding a flushed segment and
+// records still in the active log
+func newBackupSource(t *testing.T) *KVStore {
+	t.Helper()
+	store, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir(), WriteMode: WriteModeMemtable})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	for i := 0; i < 20; i++ {
+		if err := store.Put([]byte(fmt.Sprintf("user:%02d", i)), []byte(fmt.Sprintf("secret-%02d", i))); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+		if i == 9 {
+			if err := store.FlushMemtable(); err != nil {
+				t.Fatalf("Failed to flush: %v", err)
+			}
+		}
+	}
+	return store
+}
+
+// checkRestored opens a restored store and checks it holds every key
+func checkRestored(t *testing.T, dataDir string) {
+	t.Helper()
+	store, err := NewKVStore(KVStoreConfig{DataDir: dataDir})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open restored store: %v", err)
+	}
+	defer store.Close()
+
+	for i := 0; i < 20; i++ {
+		value, err := store.Get([]byte(fmt.Sprintf("user:%02d", i)))
+		if err != nil || string(value) != fmt.Sprintf("secret-%02d", i) {
+			t.Errorf("Restored user:%02d = %q, %v", i, value, err)
+		}
+	}
+}
+
+func TestKVStore_BackupRestore(t *testing.T) {
+	source := newBackupSource(t)
+	backupDir := filepath.Join(t.TempDir(), "backup")
+
+	manifest, err := source.Backup(backupDir, BackupOptions{})
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if len(manifest.Segments) != 2 || manifest.Encryption != nil || manifest.Segments[0].SHA256 == "" {
+		t.Fatalf("Unexpected manifest %+v", manifest)
+	}
+	if _, err := source.Backup(backupDir, BackupOptions{}); err == nil {
+		t.Error("Expected a backup into a non-empty directory to fail")
+	}
+
+	report, err := VerifyBackup(backupDir, VerifyOptions{SamplePct: 100})
+	if err != nil || !report.OK || report.Encrypted {
+		t.Fatalf("Expected the plain backup to verify, got %+v, %v", report, err)
+	}
+
+	dataDir := t.TempDir()
+	if _, err := RestoreBackup(backupDir, dataDir, RestoreOptions{}); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	checkRestored(t, dataDir)
+
+	if _, err := RestoreBackup(backupDir, dataDir, RestoreOptions{}); err == nil {
+		t.Error("Expected a restore over an existing store to fail")
+	}
+}
+
+func TestKVStore_EncryptedBackup(t *testing.T) {
+	source := newBackupSource(t)
+	backupDir := filepath.Join(t.TempDir(), "backup")
+	key, err := NewMasterKey([]byte("backup master key"))
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	other, _ := NewMasterKey([]byte("some other key"))
+
+	manifest, err := source.Backup(backupDir, BackupOptions{Keys: key})
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if manifest.Encryption == nil || manifest.Encryption.KeyID != key.ID() || manifest.Signature == "" {
+		t.Fatalf("Expected an encrypted, signed manifest, got %+v", manifest)
+	}
+	for _, seg := range manifest.Segments {
+		data, err := os.ReadFile(backupSegmentPath(backupDir, seg.Path))
+		if err != nil {
+			t.Fatalf("Failed to read segment: %v", err)
+		}
+		if bytes.Contains(data, []byte("secret-")) || bytes.Contains(data, []byte("user:")) {
+			t.Errorf("Segment %d holds plaintext", seg.FileID)
+		}
+	}
+
+	// Reading needs the right key
+	if _, err := VerifyBackup(backupDir, VerifyOptions{}); !errors.Is(err, ErrBackupEncrypted) {
+		t.Errorf("Expected ErrBackupEncrypted without a key, got %v", err)
+	}
+	if _, err := RestoreBackup(backupDir, t.TempDir(), RestoreOptions{Keys: other}); err == nil {
+		t.Error("Expected restoring with another key to fail")
+	}
+	report, err := VerifyBackup(backupDir, VerifyOptions{SamplePct: 100, Keys: key})
+	if err != nil || !report.OK || !report.Encrypted || !report.Signed || report.RecordsScanned != 20 {
+		t.Fatalf("Expected the encrypted backup to verify, got %+v, %v", report, err)
+	}
+
+	dataDir := t.TempDir()
+	if _, err := RestoreBackup(backupDir, dataDir, RestoreOptions{Keys: key}); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	checkRestored(t, dataDir)
+
+	// A flipped ciphertext byte fails authentication
+	segPath := backupSegmentPath(backupDir, manifest.Segments[1].Path)
+	original, _ := os.ReadFile(segPath)
+	corrupt := bytes.Clone(original)
+	corrupt[len(corrupt)/2] ^= 0xff
+	if err := os.WriteFile(segPath, corrupt, 0600); err != nil {
+		t.Fatalf("Failed to corrupt segment: %v", err)
+	}
+	if _, err := RestoreBackup(backupDir, t.TempDir(), RestoreOptions{Keys: key}); err == nil {
+		t.Error("Expected restoring a corrupted segment to fail")
+	}
+	if report, err := VerifyBackup(backupDir, VerifyOptions{Keys: key}); err != nil || report.OK || report.SegmentErrors != 1 {
+		t.Errorf("Expected one segment error, got %+v, %v", report, err)
+	}
+	if err := os.WriteFile(segPath, original, 0600); err != nil {
+		t.Fatalf("Failed to restore segment: %v", err)
+	}
+
+	// An edited manifest no longer matches its signature
+	manifest.Keys++
+	if err := SaveBackupManifest(backupDir, manifest); err != nil {
+		t.Fatalf("Failed to save manifest: %v", err)
+	}
+	if _, err := RestoreBackup(backupDir, t.TempDir(), RestoreOptions{Keys: key}); !errors.Is(err, ErrBackupSignature) {
+		t.Errorf("Expected ErrBackupSignature, got %v", err)
+	}
+	report, err = VerifyBackup(backupDir, VerifyOptions{Keys: key})
+	if err != nil || report.OK || len(report.Problems) != 1 || report.Problems[0].Kind != "signature" {
+		t.Errorf("Expected a signature problem, got %+v, %v", report, err)
+	}
+}
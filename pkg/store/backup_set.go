@@ -0,0 +1,355 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// BackupSetManifestFile is the manifest StoreManager.BackupSet saves in
+// its directory, tying together the backups it holds
+const BackupSetManifestFile = "backup-set.json"
+
+// Directories of a backup set holding the store backups and checkpoints
+const (
+	backupSetStoresDir      = "stores"
+	backupSetCheckpointsDir = "checkpoints"
+)
+
+// BackupCheckpoint is state kept beside the stores, such as secondary index
+// checkpoints, that a backup set captures at the same point as the stores
+type BackupCheckpoint struct {
+	Name string // Names its directory in the backup set
+	Path string // Where it lives relative to the data directory, e.g. "indexes"; RestoreBackupSet writes it back there
+
+	// Save writes a complete copy of the state into dir, e.g.
+	// index.IndexManager.ExportAll. It is called while writes to every
+	// store are blocked, so the copy matches the stores as long as the
+	// state is updated along with them.
+	Save func(dir string) error
+}
+
+// BackupSetOptions controls StoreManager.BackupSet
+type BackupSetOptions struct {
+	Checkpoints []BackupCheckpoint
+
+	// Keys encrypts every store's backup, as BackupOptions.Keys does.
+	// Checkpoints are copied as they are written, so the two don't mix.
+	Keys KeyWrapper
+}
+
+// BackupSet describes a backup of every store of a data directory, and of
+// the checkpoints kept with them, taken at a single point: writes to all
+// the stores were blocked while each one's image was pinned and the
+// checkpoints saved, so no store holds a write the others don't reflect.
+type BackupSet struct {
+	CreatedAt   time.Time             `json:"created_at"`
+	Stores      []BackupSetStore      `json:"stores"`
+	Checkpoints []BackupSetCheckpoint `json:"checkpoints,omitempty"`
+}
+
+// BackupSetStore is one store's backup in a set
+type BackupSetStore struct {
+	Name      string `json:"name"`       // DefaultStoreName, SystemStoreName or a namespace
+	Dir       string `json:"dir"`        // Its backup, relative to the set; a backup RestoreBackup and VerifyBackup read
+	CommitSeq uint64 `json:"commit_seq"` // The store's CommitSeq at the set's point
+	Keys      int    `json:"keys"`
+	Segments  int    `json:"segments"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// BackupSetCheckpoint is one checkpoint saved in a set
+type BackupSetCheckpoint struct {
+	Name  string          `json:"name"`
+	Path  string          `json:"path"` // Relative to the data directory
+	Dir   string          `json:"dir"`  // Relative to the set
+	Files []BackupSetFile `json:"files"`
+}
+
+// BackupSetFile is a file of a checkpoint, its path relative to the
+// checkpoint's directory
+type BackupSetFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// BackupSet backs up the default store, the system store and every
+// namespace into dir, which must be empty or not exist, along with
+// opts.Checkpoints, all at one point. Writes to every store are blocked
+// only while their images are pinned and the checkpoints are saved,
+// waiting up to ctx for writes in flight; the segments are copied after,
+// while the stores are frozen. Each store's backup is an ordinary backup
+// under stores/, and backup-set.json, written last, lists them with each
+// store's commit sequence. RestoreBackupSet puts it all back.
+func (m *StoreManager) BackupSet(ctx context.Context, dir string, opts BackupSetOptions) (*BackupSet, error) {
+	if opts.Keys != nil && len(opts.Checkpoints) > 0 {
+		return nil, &KVError{"checkpoints can't be backed up with encryption"}
+	}
+	seen := make(map[string]bool)
+	for _, c := range opts.Checkpoints {
+		if c.Name == "" || !filepath.IsLocal(c.Name) || !filepath.IsLocal(c.Path) || c.Save == nil {
+			return nil, fmt.Errorf("checkpoint %q needs a name, a path inside the data directory and Save", c.Name)
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("checkpoint %q is listed twice", c.Name)
+		}
+		seen[c.Name] = true
+	}
+	if err := emptyBackupDir(dir); err != nil {
+		return nil, err
+	}
+
+	names, stores, err := m.backupStores(ctx)
+	if err != nil {
+		return nil, err
+	}
+	set := &BackupSet{CreatedAt: time.Now()}
+	manifests, err := pinStores(ctx, stores, func() error {
+		for _, c := range opts.Checkpoints {
+			checkpointDir := filepath.Join(dir, backupSetCheckpointsDir, c.Name)
+			if err := os.MkdirAll(checkpointDir, 0750); err != nil {
+				return err
+			}
+			if err := c.Save(checkpointDir); err != nil {
+				return fmt.Errorf("failed to save checkpoint %s: %w", c.Name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, kv := range stores {
+			_ = kv.Thaw()
+		}
+	}()
+
+	for i, kv := range stores {
+		storeDir := filepath.Join(backupSetStoresDir, names[i])
+		manifest, err := kv.copyBackup(filepath.Join(dir, storeDir), manifests[i], BackupOptions{Keys: opts.Keys})
+		if err != nil {
+			return nil, fmt.Errorf("failed to back up store %s: %w", names[i], err)
+		}
+		entry := BackupSetStore{
+			Name:      names[i],
+			Dir:       storeDir,
+			CommitSeq: manifest.CommitSeq,
+			Keys:      manifest.Keys,
+			Segments:  len(manifest.Segments),
+		}
+		for _, seg := range manifest.Segments {
+			entry.Bytes += seg.Size
+		}
+		set.Stores = append(set.Stores, entry)
+	}
+	for _, c := range opts.Checkpoints {
+		checkpoint := BackupSetCheckpoint{Name: c.Name, Path: c.Path, Dir: filepath.Join(backupSetCheckpointsDir, c.Name)}
+		if checkpoint.Files, err = listCheckpointFiles(filepath.Join(dir, checkpoint.Dir)); err != nil {
+			return nil, fmt.Errorf("failed to read checkpoint %s: %w", c.Name, err)
+		}
+		set.Checkpoints = append(set.Checkpoints, checkpoint)
+	}
+
+	data, err := json.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal backup set: %w", err)
+	}
+	if err := finalizeFile(filepath.Join(dir, BackupSetManifestFile), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return set, syncDir(dir)
+}
+
+// backupStores opens and returns every store a backup set holds: the
+// default store, the system store if it exists and every namespace
+func (m *StoreManager) backupStores(ctx context.Context) ([]string, []*KVStore, error) {
+	names := []string{DefaultStoreName}
+	if _, err := os.Stat(m.storeDir(SystemStoreName)); err == nil {
+		names = append(names, SystemStoreName)
+	}
+	namespaces, err := m.Namespaces()
+	if err != nil {
+		return nil, nil, err
+	}
+	names = append(names, namespaces...)
+
+	stores := make([]*KVStore, len(names))
+	for i, name := range names {
+		if stores[i], err = m.open(ctx, name, true); err != nil {
+			return nil, nil, err
+		}
+	}
+	return names, stores, nil
+}
+
+// pinStores blocks writes to every store, freezes each one, runs capture
+// and lets writes resume, returning the stores' manifests. The stores stay
+// frozen, to be thawed by the caller, unless an error is returned.
+func pinStores(ctx context.Context, stores []*KVStore, capture func() error) ([]*BackupManifest, error) {
+	var locked []*KVStore
+	defer func() {
+		for _, kv := range locked {
+			kv.writeGate.Unlock()
+		}
+	}()
+	for _, kv := range stores {
+		if err := kv.lockWrites(ctx); err != nil {
+			return nil, err
+		}
+		locked = append(locked, kv)
+	}
+
+	manifests := make([]*BackupManifest, 0, len(stores))
+	thaw := func() {
+		for _, kv := range stores[:len(manifests)] {
+			_ = kv.Thaw()
+		}
+	}
+	for _, kv := range stores {
+		manifest, err := kv.Freeze()
+		if err != nil {
+			thaw()
+			return nil, err
+		}
+		manifests = append(manifests, manifest)
+	}
+	if err := capture(); err != nil {
+		thaw()
+		return nil, err
+	}
+	return manifests, nil
+}
+
+// listCheckpointFiles lists the files under dir with their digests
+func listCheckpointFiles(dir string) ([]BackupSetFile, error) {
+	var files []BackupSetFile
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(filepath.Clean(path))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		digest := sha256.New()
+		size, err := io.Copy(digest, file)
+		if err != nil {
+			return err
+		}
+		files = append(files, BackupSetFile{Path: filepath.ToSlash(rel), Size: size, SHA256: hex.EncodeToString(digest.Sum(nil))})
+		return nil
+	})
+	return files, err
+}
+
+// LoadBackupSet reads the manifest saved in a backup set directory
+func LoadBackupSet(dir string) (*BackupSet, error) {
+	data, err := os.ReadFile(filepath.Join(dir, BackupSetManifestFile)) //nolint:gosec // Operator-supplied backup path
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup set: %w", err)
+	}
+	var set BackupSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse backup set: %w", err)
+	}
+	return &set, nil
+}
+
+// IsBackupSet reports whether dir holds a backup set rather than the
+// backup of a single store
+func IsBackupSet(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, BackupSetManifestFile))
+	return err == nil
+}
+
+// RestoreBackupSet recreates the stores and checkpoints of the backup set
+// in dir under dataDir, each where a StoreManager for dataDir looks for it.
+// Every store is restored as RestoreBackup does, and every checkpoint file
+// is checked against its digest. dataDir must not hold any of the stores
+// or checkpoint files yet.
+func RestoreBackupSet(dir, dataDir string, opts RestoreOptions) (*BackupSet, error) {
+	set, err := LoadBackupSet(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range set.Stores {
+		if s.Name != DefaultStoreName && s.Name != SystemStoreName && ValidateNamespace(s.Name) != nil {
+			return nil, fmt.Errorf("backup set lists an invalid store %q", s.Name)
+		}
+		if !filepath.IsLocal(s.Dir) {
+			return nil, fmt.Errorf("backup set places store %s outside the set", s.Name)
+		}
+	}
+	for _, c := range set.Checkpoints {
+		if !filepath.IsLocal(c.Dir) || !filepath.IsLocal(c.Path) {
+			return nil, fmt.Errorf("backup set places checkpoint %s outside its directories", c.Name)
+		}
+	}
+
+	for _, s := range set.Stores {
+		if _, err := RestoreBackup(filepath.Join(dir, s.Dir), storeDataDir(dataDir, s.Name), opts); err != nil {
+			return nil, fmt.Errorf("failed to restore store %s: %w", s.Name, err)
+		}
+	}
+	for _, c := range set.Checkpoints {
+		for _, f := range c.Files {
+			if err := restoreCheckpointFile(filepath.Join(dir, c.Dir), filepath.Join(dataDir, c.Path), f); err != nil {
+				return nil, fmt.Errorf("failed to restore checkpoint %s: %w", c.Name, err)
+			}
+		}
+	}
+	return set, nil
+}
+
+// restoreCheckpointFile copies a checkpoint file from the set into dir,
+// checking its size and digest before it is put in place
+func restoreCheckpointFile(from, dir string, f BackupSetFile) error {
+	rel := filepath.FromSlash(f.Path)
+	if !filepath.IsLocal(rel) {
+		return fmt.Errorf("file %q is outside the checkpoint", f.Path)
+	}
+	path := filepath.Join(dir, rel)
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+
+	src, err := os.Open(filepath.Join(from, rel)) //nolint:gosec // Checked to be inside the set
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return finalizeFile(path, func(w io.Writer) error {
+		digest := sha256.New()
+		n, err := io.Copy(io.MultiWriter(w, digest), src)
+		if err != nil {
+			return err
+		}
+		if n != f.Size || hex.EncodeToString(digest.Sum(nil)) != f.SHA256 {
+			return fmt.Errorf("%s: %w: size or digest doesn't match the backup set", f.Path, ErrCorruption)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKVStore_FreezeThaw(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	store, err := NewKVStore(KVStoreConfig{DataDir: tmpDir, FsyncInterval: time.Second})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Put([]byte("key1"), []byte("value1")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+
+	manifest, err := store.Freeze()
+	if err != nil {
+		t.Fatalf("Freeze failed: %v", err)
+	}
+
+	if len(manifest.Segments) != 1 {
+		t.Fatalf("Expected 1 segment, got %d", len(manifest.Segments))
+	}
+	if manifest.Keys != 1 {
+		t.Errorf("Expected 1 key, got %d", manifest.Keys)
+	}
+
+	// The manifest size must already be durable on disk
+	info, err := os.Stat(filepath.Join(tmpDir, manifest.Segments[0].Path))
+	if err != nil {
+		t.Fatalf("Failed to stat segment: %v", err)
+	}
+	if info.Size() != manifest.Segments[0].Size {
+		t.Errorf("Segment size on disk %d != manifest size %d", info.Size(), manifest.Segments[0].Size)
+	}
+
+	// Writes still succeed while frozen
+	if err := store.Put([]byte("key2"), []byte("value2")); err != nil {
+		t.Fatalf("Put while frozen failed: %v", err)
+	}
+
+	if err := store.Thaw(); err != nil {
+		t.Fatalf("Thaw failed: %v", err)
+	}
+	if err := store.Thaw(); err != ErrNotFrozen {
+		t.Errorf("Expected ErrNotFrozen, got %v", err)
+	}
+}
+
+func TestKVStore_WithConsistentView(t *testing.T) {
+	store, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	called := false
+	err = store.WithConsistentView(func(m *BackupManifest) error {
+		called = true
+		if len(m.Segments) == 0 {
+			t.Error("Expected at least one segment in manifest")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithConsistentView failed: %v", err)
+	}
+	if !called {
+		t.Error("Expected callback to be invoked")
+	}
+
+	// The view must have thawed the store again
+	if err := store.Thaw(); err != ErrNotFrozen {
+		t.Errorf("Expected store to be thawed, got %v", err)
+	}
+}
+
+func TestKVStore_NestedFreezeWithQueuedRotate(t *testing.T) {
+	store, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Put([]byte("key1"), []byte("value1")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if _, err := store.Freeze(); err != nil {
+		t.Fatalf("Freeze failed: %v", err)
+	}
+
+	rotated := make(chan error, 1)
+	go func() { rotated <- store.Rotate() }()
+
+	// Wait until the rotation is queued on the maintenance lock, which then
+	// turns away new readers
+	for deadline := time.Now().Add(5 * time.Second); store.maintenance.TryRLock(); {
+		store.maintenance.RUnlock()
+		if time.Now().After(deadline) {
+			t.Fatal("Rotate never queued on the maintenance lock")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	frozen := make(chan error, 1)
+	go func() {
+		_, err := store.Freeze()
+		frozen <- err
+	}()
+	select {
+	case err := <-frozen:
+		if err != nil {
+			t.Fatalf("Nested freeze failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Nested freeze deadlocked behind the queued rotation")
+	}
+
+	select {
+	case err := <-rotated:
+		t.Fatalf("Rotate finished while frozen: %v", err)
+	default:
+	}
+	for i := 0; i < 2; i++ {
+		if err := store.Thaw(); err != nil {
+			t.Fatalf("Thaw failed: %v", err)
+		}
+	}
+	select {
+	case err := <-rotated:
+		if err != nil {
+			t.Fatalf("Rotate failed after thaw: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Rotate never ran after the store thawed")
+	}
+}
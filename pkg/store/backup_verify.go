@@ -0,0 +1,412 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/codec"
+)
+
+// BackupManifestFile is the name of the manifest inside a backup directory
+const BackupManifestFile = "backup.json"
+
+// DefaultVerifySamplePct is the share of keys VerifyBackup checks when
+// VerifyOptions.SamplePct is zero
+const DefaultVerifySamplePct = 10
+
+// maxVerifyProblems caps the problems listed in a report; the counts stay exact
+const maxVerifyProblems = 100
+
+// SaveBackupManifest writes manifest to dir/backup.json, where
+// VerifyBackup expects it. Backup tools call it after copying the segments
+// listed in a Freeze manifest.
+func SaveBackupManifest(dir string, manifest *BackupManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal backup manifest: %w", err)
+	}
+	return os.WriteFile(filepath.Join(dir, BackupManifestFile), data, 0600)
+}
+
+// LoadBackupManifest reads the manifest saved in a backup directory
+func LoadBackupManifest(dir string) (*BackupManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, BackupManifestFile)) //nolint:gosec // Operator-supplied backup path
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup manifest: %w", err)
+	}
+	var manifest BackupManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse backup manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// VerifyOptions controls VerifyBackup
+type VerifyOptions struct {
+	SamplePct float64    // Percentage of keys to check, 0 < SamplePct <= 100 (DefaultVerifySamplePct if zero)
+	Live      *KVStore   // If set, sampled keys are compared against this store
+	Keys      KeyWrapper // Unwraps the data key of an encrypted backup
+
+	// Encryption decrypts the values of a store with at-rest encryption
+	// (default Live's KVStoreConfig.Encryption)
+	Encryption codec.EncryptionProvider
+
+	OnProgress func(segmentsDone, segments int) // Called after each segment is scanned
+}
+
+// VerifyProblem is a single failed check
+type VerifyProblem struct {
+	Kind    string `json:"kind"` // "signature", "segment", "checksum" or "live"
+	Segment uint32 `json:"segment"`
+	Offset  int64  `json:"offset,omitempty"`
+	Key     string `json:"key,omitempty"`
+	Detail  string `json:"detail"`
+}
+
+// VerifyReport is the outcome of VerifyBackup. OK is true when every
+// segment was readable, every sampled record passed its checksum and, if a
+// live store was given, every sampled key matched it.
+type VerifyReport struct {
+	BackupDir      string          `json:"backup_dir"`
+	CreatedAt      time.Time       `json:"created_at"` // When the backup's manifest was taken
+	Encrypted      bool            `json:"encrypted"`
+	Signed         bool            `json:"signed"` // The manifest's signature was checked
+	SamplePct      float64         `json:"sample_pct"`
+	Segments       int             `json:"segments"`
+	RecordsScanned int64           `json:"records_scanned"`
+	RecordsChecked int64           `json:"records_checked"` // Records of sampled keys, and range tombstones, validated
+	KeysSampled    int             `json:"keys_sampled"`
+	SegmentErrors  int             `json:"segment_errors"`
+	ChecksumErrors int             `json:"checksum_errors"`
+	LiveCompared   bool            `json:"live_compared"`
+	LiveMismatches int             `json:"live_mismatches"`
+	Problems       []VerifyProblem `json:"problems,omitempty"`
+	Duration       time.Duration   `json:"duration"`
+	OK             bool            `json:"ok"`
+}
+
+// addProblem records p, listing at most maxVerifyProblems
+func (r *VerifyReport) addProblem(p VerifyProblem) {
+	if len(r.Problems) < maxVerifyProblems {
+		r.Problems = append(r.Problems, p)
+	}
+}
+
+// sampledVersion is the newest record of a sampled key seen in the backup
+type sampledVersion struct {
+	value     []byte
+	timestamp uint64
+	expiresAt uint64
+}
+
+// VerifyBackup checks the backup in dir against its manifest. It reads each
+// segment up to its recorded size, validates the checksum of every record
+// of the sampled keys and every range tombstone and, with opts.Live, compares the newest backed-up
+// state of each sampled key with the live store. Keys are sampled by hash,
+// so every version of a sampled key is checked and repeated runs check the
+// same keys. Writes made after the backup show up as live mismatches, so
+// compare against a store that has been quiet since the backup was taken.
+func VerifyBackup(dir string, opts VerifyOptions) (*VerifyReport, error) {
+	return VerifyBackupContext(context.Background(), dir, opts)
+}
+
+// VerifyBackupContext is VerifyBackup, abandoned between segments once ctx
+// is done
+func VerifyBackupContext(ctx context.Context, dir string, opts VerifyOptions) (*VerifyReport, error) {
+	start := time.Now()
+	pct := opts.SamplePct
+	if pct == 0 {
+		pct = DefaultVerifySamplePct
+	}
+	if pct < 0 || pct > 100 {
+		return nil, &KVError{"sample percentage must be between 0 and 100"}
+	}
+
+	manifest, err := LoadBackupManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	opener, err := openBackup(manifest, opts.Keys)
+	if errors.Is(err, ErrBackupSignature) {
+		report := &VerifyReport{BackupDir: dir, CreatedAt: manifest.CreatedAt, SamplePct: pct, Encrypted: true}
+		report.addProblem(VerifyProblem{Kind: "signature", Detail: err.Error()})
+		report.Duration = time.Since(start)
+		return report, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	recordCodec := codec.NewRecordCodec()
+	switch {
+	case opts.Encryption != nil:
+		if recordCodec, err = codec.NewRecordCodecWithEncryption(opts.Encryption, codec.CompressionOptions{}); err != nil {
+			return nil, err
+		}
+	case opts.Live != nil:
+		recordCodec = opts.Live.codec
+	}
+
+	report := &VerifyReport{BackupDir: dir, CreatedAt: manifest.CreatedAt, SamplePct: pct,
+		Encrypted: manifest.Encryption != nil, Signed: manifest.Signature != ""}
+	sampled := make(map[string]sampledVersion)
+	var ranges []RangeTombstone
+	threshold := uint64(pct / 100 * float64(^uint32(0)))
+
+	want := func(key []byte) bool {
+		return bytes.HasPrefix(key, codec.RangeTombstoneKeyPrefix) ||
+			(!codec.IsReservedKey(key) && sampleHash(key) <= threshold)
+	}
+
+	for i, seg := range manifest.Segments {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		report.Segments++
+		scanned, err := opener.scan(dir, seg, recordCodec, want,
+			func(rec *codec.Record, offset int64, err error) {
+				report.RecordsChecked++
+				if err == nil {
+					err = rec.Validate()
+				}
+				if err != nil {
+					report.ChecksumErrors++
+					report.addProblem(VerifyProblem{Kind: "checksum", Segment: seg.FileID, Offset: offset,
+						Key: string(rec.Key), Detail: err.Error()})
+					return
+				}
+
+				if from, to, ok := codec.DecodeRangeTombstone(rec); ok {
+					ranges = append(ranges, RangeTombstone{Start: from, End: to, Timestamp: rec.Timestamp})
+				} else if prev, ok := sampled[string(rec.Key)]; !ok || rec.Timestamp >= prev.timestamp {
+					sampled[string(rec.Key)] = sampledVersion{value: rec.Value, timestamp: rec.Timestamp,
+						expiresAt: rec.ExpiresAt}
+				}
+			})
+		report.RecordsScanned += scanned
+		if err != nil {
+			report.SegmentErrors++
+			report.addProblem(VerifyProblem{Kind: "segment", Segment: seg.FileID, Detail: err.Error()})
+		}
+		if opts.OnProgress != nil {
+			opts.OnProgress(i+1, len(manifest.Segments))
+		}
+	}
+	report.KeysSampled = len(sampled)
+
+	if opts.Live != nil {
+		report.LiveCompared = true
+		for key, version := range sampled {
+			if detail := compareLive(opts.Live, []byte(key), version, ranges); detail != "" {
+				report.LiveMismatches++
+				report.addProblem(VerifyProblem{Kind: "live", Key: key, Detail: detail})
+			}
+		}
+	}
+
+	report.OK = report.SegmentErrors == 0 && report.ChecksumErrors == 0 && report.LiveMismatches == 0
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+// backupSegmentPath locates a manifest segment inside a backup directory.
+// Segments that lived outside the primary data directory are recorded with
+// absolute paths and are expected at the top of the backup.
+func backupSegmentPath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Join(dir, filepath.Base(path))
+	}
+	return filepath.Join(dir, path)
+}
+
+// sampleHash places a key in the sample space; keys hashing at or below the
+// threshold are sampled
+func sampleHash(key []byte) uint64 {
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+	return uint64(h.Sum32())
+}
+
+// backupOpener reads the segments of one backup, decrypting them if it is
+// encrypted
+type backupOpener struct {
+	cipher *backupCipher // nil for a plain backup
+}
+
+// openBackup checks an encrypted backup's signature and prepares to read
+// its segments
+func openBackup(manifest *BackupManifest, keys KeyWrapper) (*backupOpener, error) {
+	if manifest.Encryption == nil {
+		return &backupOpener{}, nil
+	}
+	c, err := openBackupCipher(manifest.Encryption, keys)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkSignature(manifest); err != nil {
+		return nil, err
+	}
+	return &backupOpener{cipher: c}, nil
+}
+
+// backupSegment reads the plaintext of one backed-up segment
+type backupSegment struct {
+	io.Reader
+	file   *os.File
+	digest hash.Hash // Of the file as stored
+	sha256 string    // Expected digest, if the manifest has one
+}
+
+// Close closes the segment file
+func (b *backupSegment) Close() error {
+	return b.file.Close()
+}
+
+// check compares the file with the manifest's digest, once it has been read
+// to the end
+func (b *backupSegment) check() error {
+	if b.sha256 != "" && hex.EncodeToString(b.digest.Sum(nil)) != b.sha256 {
+		return errors.New("segment file does not match the manifest's digest")
+	}
+	return nil
+}
+
+// open returns a reader of a segment's first seg.Size bytes
+func (o *backupOpener) open(dir string, seg SegmentManifest) (*backupSegment, error) {
+	file, err := os.Open(backupSegmentPath(dir, seg.Path)) //nolint:gosec // Operator-supplied backup path
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	stored := seg.Size
+	if o.cipher != nil {
+		stored = o.cipher.encryptedSize(seg.Size)
+	}
+	if info.Size() < stored {
+		file.Close()
+		return nil, fmt.Errorf("segment holds %d bytes, manifest records %d", info.Size(), stored)
+	}
+
+	b := &backupSegment{file: file, digest: sha256.New(), sha256: seg.SHA256}
+	b.Reader = io.TeeReader(io.LimitReader(file, stored), b.digest)
+	if o.cipher != nil {
+		b.Reader = o.cipher.decryptSegment(b.Reader, seg.FileID, seg.Size)
+	}
+	return b, nil
+}
+
+// scan calls fn for every record in a backed-up segment whose key want
+// accepts, and returns the number of records scanned. The values of
+// unwanted records are skipped rather than read into memory. Records are
+// passed to fn unvalidated; one that fails to decode, such as a compressed
+// value that won't decompress, is passed with only its key and the error.
+func (o *backupOpener) scan(dir string, seg SegmentManifest, recordCodec *codec.RecordCodec,
+	want func([]byte) bool, fn func(*codec.Record, int64, error)) (int64, error) {
+	segment, err := o.open(dir, seg)
+	if err != nil {
+		return 0, err
+	}
+	defer segment.Close()
+
+	size := seg.Size
+	reader := bufio.NewReader(segment)
+	header := make([]byte, codec.HeaderSize)
+	var offset, scanned int64
+	for offset < size {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return scanned, readError("truncated record header", offset, err)
+		}
+		dataSize := codec.DataSize(header)
+		if offset+codec.HeaderSize+dataSize > size {
+			return scanned, fmt.Errorf("record at offset %d runs past the recorded segment size", offset)
+		}
+
+		// Read up to the key, and the value only if the key is wanted
+		valueSize := int64(binary.LittleEndian.Uint32(header[8:12]))
+		data := make([]byte, codec.HeaderSize+dataSize-valueSize, codec.HeaderSize+dataSize)
+		copy(data, header)
+		if _, err := io.ReadFull(reader, data[codec.HeaderSize:]); err != nil {
+			return scanned, readError("truncated record", offset, err)
+		}
+		keyStart := codec.HeaderSize
+		if binary.LittleEndian.Uint32(header[4:8])&codec.FlagExpiry != 0 {
+			keyStart += codec.ExpirySize
+		}
+		if key := data[keyStart:]; want(key) {
+			data = data[:cap(data)]
+			if _, err := io.ReadFull(reader, data[len(data)-int(valueSize):]); err != nil {
+				return scanned, readError("truncated record", offset, err)
+			}
+			rec, err := recordCodec.Decode(data)
+			if err != nil {
+				rec = &codec.Record{Key: key}
+			}
+			fn(rec, offset, err)
+		} else if _, err := reader.Discard(int(valueSize)); err != nil {
+			return scanned, readError("truncated record", offset, err)
+		}
+
+		scanned++
+		offset += codec.HeaderSize + dataSize
+	}
+	// Drain an encrypted segment's final chunk so it is authenticated
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return scanned, err
+	}
+	return scanned, segment.check()
+}
+
+// readError describes a failed read of a backed-up record. A short read is
+// a truncated segment; anything else, such as a chunk failing
+// authentication, is reported as is.
+func readError(what string, offset int64, err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%s at offset %d", what, offset)
+	}
+	return fmt.Errorf("at offset %d: %w", offset, err)
+}
+
+// compareLive reports how the live store differs from a key's backed-up
+// state, or "" if they agree
+func compareLive(live *KVStore, key []byte, version sampledVersion, ranges []RangeTombstone) string {
+	// A version that has expired since the backup reads as deleted
+	deleted := len(version.value) == 0 || expired(version.expiresAt, time.Now())
+	for _, rt := range ranges {
+		if rt.Covers(key, version.timestamp) {
+			deleted = true
+		}
+	}
+
+	value, err := live.Get(key)
+	switch {
+	case err == ErrKeyNotFound && deleted:
+		return ""
+	case err == ErrKeyNotFound:
+		return "missing from live store"
+	case err != nil:
+		return fmt.Sprintf("live read failed: %v", err)
+	case deleted:
+		return "deleted in backup but present in live store"
+	case !bytes.Equal(value, version.value):
+		return "value differs from live store"
+	}
+	return ""
+}
@@ -0,0 +1,145 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// copyBackup copies the segments of a frozen store into dir and saves the
+// manifest next to them, the way a backup tool would
+func copyBackup(t *testing.T, kv *KVStore, dir string) {
+	t.Helper()
+	err := kv.WithConsistentView(func(manifest *BackupManifest) error {
+		for _, seg := range manifest.Segments {
+			data, err := os.ReadFile(filepath.Join(manifest.DataDir, seg.Path))
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(filepath.Join(dir, seg.Path), data[:seg.Size], 0600); err != nil {
+				return err
+			}
+		}
+		return SaveBackupManifest(dir, manifest)
+	})
+	if err != nil {
+		t.Fatalf("Failed to back up: %v", err)
+	}
+}
+
+func TestVerifyBackup(t *testing.T) {
+	kv, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := kv.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer kv.Close()
+
+	for i := 0; i < 50; i++ {
+		if err := kv.Put([]byte(fmt.Sprintf("user:%02d", i)), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	if err := kv.Delete([]byte("user:07")); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if err := kv.DeletePrefix([]byte("user:4")); err != nil {
+		t.Fatalf("Failed to delete prefix: %v", err)
+	}
+
+	backupDir := t.TempDir()
+	copyBackup(t, kv, backupDir)
+
+	report, err := VerifyBackup(backupDir, VerifyOptions{SamplePct: 100, Live: kv})
+	if err != nil {
+		t.Fatalf("VerifyBackup failed: %v", err)
+	}
+	if !report.OK || report.KeysSampled != 50 || report.RecordsChecked != 52 || report.RecordsScanned != 52 {
+		t.Fatalf("Expected a clean full check, got %+v", report)
+	}
+
+	var progress []int
+	if _, err := VerifyBackup(backupDir, VerifyOptions{OnProgress: func(done, segments int) {
+		progress = append(progress, done, segments)
+	}}); err != nil {
+		t.Fatalf("VerifyBackup failed: %v", err)
+	}
+	if len(progress) != 2 || progress[0] != 1 || progress[1] != 1 {
+		t.Errorf("Expected progress for one segment, got %v", progress)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := VerifyBackupContext(ctx, backupDir, VerifyOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a canceled verification, got %v", err)
+	}
+
+	// A sample checks a subset, and the same subset every time
+	sample, err := VerifyBackup(backupDir, VerifyOptions{SamplePct: 20})
+	if err != nil {
+		t.Fatalf("VerifyBackup failed: %v", err)
+	}
+	again, _ := VerifyBackup(backupDir, VerifyOptions{SamplePct: 20})
+	if !sample.OK || sample.KeysSampled == 0 || sample.KeysSampled >= 50 || again.KeysSampled != sample.KeysSampled {
+		t.Errorf("Unexpected sample: %+v then %+v", sample, again)
+	}
+
+	// Live writes after the backup are reported as mismatches
+	if err := kv.Put([]byte("user:01"), []byte("changed")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := kv.Put([]byte("user:41"), []byte("back")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	report, err = VerifyBackup(backupDir, VerifyOptions{SamplePct: 100, Live: kv})
+	if err != nil {
+		t.Fatalf("VerifyBackup failed: %v", err)
+	}
+	if report.OK || report.LiveMismatches != 2 {
+		t.Errorf("Expected 2 live mismatches, got %+v", report)
+	}
+
+	// Flip a byte in a record's value
+	manifest, err := LoadBackupManifest(backupDir)
+	if err != nil {
+		t.Fatalf("Failed to load manifest: %v", err)
+	}
+	segPath := filepath.Join(backupDir, manifest.Segments[0].Path)
+	data, err := os.ReadFile(segPath)
+	if err != nil {
+		t.Fatalf("Failed to read segment: %v", err)
+	}
+	data[bytes.Index(data, []byte("value-3"))] ^= 0xff
+	if err := os.WriteFile(segPath, data, 0600); err != nil {
+		t.Fatalf("Failed to write segment: %v", err)
+	}
+	report, err = VerifyBackup(backupDir, VerifyOptions{SamplePct: 100})
+	if err != nil {
+		t.Fatalf("VerifyBackup failed: %v", err)
+	}
+	if report.OK || report.ChecksumErrors != 1 {
+		t.Errorf("Expected a checksum error, got %+v", report)
+	}
+
+	// A segment shorter than its manifest entry fails
+	if err := os.WriteFile(segPath, data[:len(data)/2], 0600); err != nil {
+		t.Fatalf("Failed to truncate segment: %v", err)
+	}
+	report, err = VerifyBackup(backupDir, VerifyOptions{})
+	if err != nil {
+		t.Fatalf("VerifyBackup failed: %v", err)
+	}
+	if report.OK || report.SegmentErrors != 1 || report.SamplePct != DefaultVerifySamplePct {
+		t.Errorf("Expected a segment error, got %+v", report)
+	}
+
+	if _, err := VerifyBackup(backupDir, VerifyOptions{SamplePct: 101}); err == nil {
+		t.Error("Expected an error for an out-of-range sample percentage")
+	}
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/ssargent/freyjadb/pkg/codec"
+)
+
+// PutBatch writes many key-value pairs under a single hold of the write
+// lock, sharing one group commit. It is the path bulk loads such as
+// pkg/migrate use. Every pair is validated before anything is written, so
+// an invalid key or oversized record rejects the whole batch; a disk
+// failure part way through can still leave the first pairs written, as with
+// any run of puts. Later pairs win over earlier ones with the same key.
+func (kv *KVStore) PutBatch(pairs []KeyValuePair) error {
+	return kv.commit(func() error {
+		if err := kv.checkOpenInternal(); err != nil {
+			return err
+		}
+
+		for i, pair := range pairs {
+			if len(pair.Key) == 0 || codec.IsReservedKey(pair.Key) {
+				return fmt.Errorf("pair %d: %w", i, ErrInvalidKey)
+			}
+			if kv.config.MaxRecordSize > 0 && len(pair.Key)+len(pair.Value) > kv.config.MaxRecordSize {
+				return fmt.Errorf("pair %d: %w", i, ErrRecordSizeExceeded)
+			}
+		}
+		keys := make([][]byte, len(pairs))
+		for i, pair := range pairs {
+			keys[i] = pair.Key
+		}
+		if err := kv.admitWritesInternal(keys...); err != nil {
+			return err
+		}
+
+		for i, pair := range pairs {
+			if err := kv.putInternal(pair.Key, pair.Value); err != nil {
+				return fmt.Errorf("failed to store pair %d: %w", i, err)
+			}
+		}
+		return nil
+	})
+}
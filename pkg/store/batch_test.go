@@ -0,0 +1,43 @@
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPutBatch(t *testing.T) {
+	kv, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir(), MaxRecordSize: 64})
+	if err != nil {
+		t.Fatalf("Failed to create KVStore: %v", err)
+	}
+	if _, err := kv.Open(); err != nil {
+		t.Fatalf("Failed to open KVStore: %v", err)
+	}
+	defer kv.Close()
+
+	err = kv.PutBatch([]KeyValuePair{
+		{Key: []byte("a"), Value: []byte("1")},
+		{Key: []byte("b"), Value: []byte("2")},
+		{Key: []byte("a"), Value: []byte("3")},
+	})
+	if err != nil {
+		t.Fatalf("PutBatch failed: %v", err)
+	}
+	for key, want := range map[string]string{"a": "3", "b": "2"} {
+		if value, err := kv.Get([]byte(key)); err != nil || string(value) != want {
+			t.Errorf("Get(%s) = %q, %v; want %q", key, value, err, want)
+		}
+	}
+
+	// One bad pair rejects the whole batch
+	err = kv.PutBatch([]KeyValuePair{
+		{Key: []byte("c"), Value: []byte("1")},
+		{Key: []byte("d"), Value: make([]byte, 100)},
+	})
+	if !errors.Is(err, ErrRecordSizeExceeded) {
+		t.Errorf("PutBatch error = %v, want ErrRecordSizeExceeded", err)
+	}
+	if _, err := kv.Get([]byte("c")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Get(c) error = %v, want ErrKeyNotFound", err)
+	}
+}
@@ -0,0 +1,269 @@
+package store
+
+import (
+	"bytes"
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache defaults
+const (
+	DefaultCacheTTL        = time.Minute // How long an entry is served when CacheOptions doesn't set TTL
+	DefaultCacheMaxEntries = 10000       // Entries held when CacheOptions doesn't set MaxEntries
+)
+
+// CacheOptions configures a Cache
+type CacheOptions struct {
+	Prefix     []byte        // Only keys starting with Prefix are cached; others are read from the store
+	TTL        time.Duration // How long an entry is served before it is read again (default DefaultCacheTTL)
+	MaxEntries int           // Entries held before the least recently used is evicted (default DefaultCacheMaxEntries)
+	MaxBytes   int64         // Bytes of keys and values held before evicting (0 = no byte bound)
+
+	// NegativeTTL is how long a missing key is remembered, so repeated
+	// lookups of keys that don't exist skip the store (0 = not remembered)
+	NegativeTTL time.Duration
+}
+
+// CacheStats reports how a Cache has served reads
+type CacheStats struct {
+	Hits          int64
+	Misses        int64 // Reads that went to the store, including expired entries
+	Evictions     int64 // Entries dropped to stay within MaxEntries and MaxBytes
+	Invalidations int64 // Entries dropped because their key was written
+	Entries       int
+	Bytes         int64
+}
+
+// HitRate returns the share of reads served from the cache
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// Cache is a read-through cache of a store's values, for embedders who
+// want simple caching without their own layer. Writes made through the
+// store, including range deletes, batches and records a standby tails,
+// invalidate the cached keys before the write returns, so a Get after a
+// write never sees the old value. The TTL bounds how long a value is
+// served, e.g. to pick up changes restored from a backup.
+type Cache struct {
+	kv   *KVStore
+	opts CacheOptions
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element // Key -> element of lru holding a *cacheEntry
+	lru     *list.List               // Most recently used first
+	bytes   int64
+	gen     uint64 // Bumped by every invalidation, so a read racing a write isn't cached
+	stats   CacheStats
+}
+
+// cacheEntry is a cached value, or a remembered miss when value is nil
+type cacheEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func (e *cacheEntry) size() int64 {
+	return int64(len(e.key) + len(e.value))
+}
+
+// NewCache creates a cache of the store's values. Call Close when done so
+// writes stop invalidating it.
+func (kv *KVStore) NewCache(opts CacheOptions) (*Cache, error) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if err := kv.checkOpenInternal(); err != nil {
+		return nil, err
+	}
+
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultCacheTTL
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = DefaultCacheMaxEntries
+	}
+	opts.Prefix = bytes.Clone(opts.Prefix)
+
+	c := &Cache{kv: kv, opts: opts, entries: make(map[string]*list.Element), lru: list.New()}
+	if kv.caches == nil {
+		kv.caches = make(map[*Cache]struct{})
+	}
+	kv.caches[c] = struct{}{}
+	return c, nil
+}
+
+// Get returns the value of key, from the cache if it holds an unexpired
+// entry and from the store otherwise. The returned slice is the caller's.
+func (c *Cache) Get(key []byte) ([]byte, error) {
+	if !bytes.HasPrefix(key, c.opts.Prefix) {
+		return c.kv.Get(key)
+	}
+
+	now := time.Now()
+	c.mutex.Lock()
+	if elem, ok := c.entries[string(key)]; ok {
+		entry := elem.Value.(*cacheEntry)
+		if now.Before(entry.expires) {
+			c.lru.MoveToFront(elem)
+			c.stats.Hits++
+			c.mutex.Unlock()
+			if entry.value == nil {
+				return nil, ErrKeyNotFound
+			}
+			return bytes.Clone(entry.value), nil
+		}
+		c.removeInternal(elem)
+	}
+	c.stats.Misses++
+	gen := c.gen
+	c.mutex.Unlock()
+
+	value, err := c.kv.Get(key)
+	switch {
+	case err == nil:
+		// A value with a TTL is not served past its expiry
+		ttl := c.opts.TTL
+		if expiresAt := c.kv.expiresAt(key); expiresAt != 0 {
+			ttl = min(ttl, time.Until(time.Unix(0, int64(expiresAt)))) //nolint:gosec // nanosecond timestamps fit in int64
+		}
+		c.add(key, bytes.Clone(value), ttl, gen)
+	case err == ErrKeyNotFound && c.opts.NegativeTTL > 0:
+		c.add(key, nil, c.opts.NegativeTTL, gen)
+	}
+	return value, err
+}
+
+// add caches a value read from the store, unless a write invalidated any
+// entry since the read started
+func (c *Cache) add(key, value []byte, ttl time.Duration, gen uint64) {
+	entry := &cacheEntry{key: string(key), value: value, expires: time.Now().Add(ttl)}
+	if c.opts.MaxBytes > 0 && entry.size() > c.opts.MaxBytes {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.gen != gen {
+		return
+	}
+	if elem, ok := c.entries[entry.key]; ok {
+		c.removeInternal(elem)
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.bytes += entry.size()
+
+	for len(c.entries) > c.opts.MaxEntries || (c.opts.MaxBytes > 0 && c.bytes > c.opts.MaxBytes) {
+		c.removeInternal(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// Invalidate drops key's entry, e.g. after changing it outside the store
+func (c *Cache) Invalidate(key []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.invalidateInternal(key)
+}
+
+// Purge drops every entry
+func (c *Cache) Purge() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.purgeInternal()
+}
+
+// Stats returns the cache's counters and size
+func (c *Cache) Stats() CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stats := c.stats
+	stats.Entries = len(c.entries)
+	stats.Bytes = c.bytes
+	return stats
+}
+
+// Close stops writes invalidating the cache and drops its entries. It is
+// safe to call more than once.
+func (c *Cache) Close() {
+	c.kv.mutex.Lock()
+	delete(c.kv.caches, c)
+	c.kv.mutex.Unlock()
+	c.Purge()
+}
+
+// invalidateInternal drops key's entry (caller must hold the cache mutex)
+func (c *Cache) invalidateInternal(key []byte) {
+	c.gen++
+	if elem, ok := c.entries[string(key)]; ok {
+		c.removeInternal(elem)
+		c.stats.Invalidations++
+	}
+}
+
+// invalidateRangeInternal drops the entries in [start, end), every key >=
+// start if end is nil (caller must hold the cache mutex)
+func (c *Cache) invalidateRangeInternal(start, end []byte) {
+	c.gen++
+	rt := RangeTombstone{Start: start, End: end}
+	for key, elem := range c.entries {
+		if rt.Contains([]byte(key)) {
+			c.removeInternal(elem)
+			c.stats.Invalidations++
+		}
+	}
+}
+
+// purgeInternal drops every entry (caller must hold the cache mutex)
+func (c *Cache) purgeInternal() {
+	c.gen++
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.bytes = 0
+}
+
+// removeInternal drops one entry (caller must hold the cache mutex)
+func (c *Cache) removeInternal(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.size()
+}
+
+// invalidateCachesInternal drops key from every cache (caller must hold
+// the store mutex)
+func (kv *KVStore) invalidateCachesInternal(key []byte) {
+	for c := range kv.caches {
+		if bytes.HasPrefix(key, c.opts.Prefix) {
+			c.mutex.Lock()
+			c.invalidateInternal(key)
+			c.mutex.Unlock()
+		}
+	}
+}
+
+// invalidateCacheRangeInternal drops the keys in [start, end) from every
+// cache (caller must hold the store mutex)
+func (kv *KVStore) invalidateCacheRangeInternal(start, end []byte) {
+	for c := range kv.caches {
+		c.mutex.Lock()
+		c.invalidateRangeInternal(start, end)
+		c.mutex.Unlock()
+	}
+}
+
+// purgeCachesInternal empties every cache, e.g. when the index is rebuilt
+// from disk (caller must hold the store mutex)
+func (kv *KVStore) purgeCachesInternal() {
+	for c := range kv.caches {
+		c.mutex.Lock()
+		c.purgeInternal()
+		c.mutex.Unlock()
+	}
+}
@@ -0,0 +1,165 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func openCacheTestStore(t *testing.T) *KVStore {
+	t.Helper()
+	kv, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewKVStore failed: %v", err)
+	}
+	if _, err := kv.Open(); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { kv.Close() })
+	return kv
+}
+
+func TestCache_ReadThroughAndInvalidation(t *testing.T) {
+	kv := openCacheTestStore(t)
+	cache, err := kv.NewCache(CacheOptions{Prefix: []byte("user:")})
+	if err != nil {
+		t.Fatalf("NewCache failed: %v", err)
+	}
+	defer cache.Close()
+
+	if err := kv.Put([]byte("user:1"), []byte("alice")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		value, err := cache.Get([]byte("user:1"))
+		if err != nil || string(value) != "alice" {
+			t.Fatalf("Get = %q, %v, want alice", value, err)
+		}
+		value[0] = 'X' // The caller's copy, not the cached value
+	}
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Errorf("stats = %+v, want 1 hit, 1 miss, 1 entry", stats)
+	}
+
+	if err := kv.Put([]byte("user:1"), []byte("bob")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if value, err := cache.Get([]byte("user:1")); err != nil || string(value) != "bob" {
+		t.Errorf("Get after Put = %q, %v, want bob", value, err)
+	}
+
+	if err := kv.Delete([]byte("user:1")); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := cache.Get([]byte("user:1")); err != ErrKeyNotFound {
+		t.Errorf("Get after Delete error = %v, want ErrKeyNotFound", err)
+	}
+
+	for _, key := range []string{"user:2", "user:3"} {
+		if err := kv.Put([]byte(key), []byte("v")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if _, err := cache.Get([]byte(key)); err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+	}
+	if err := kv.DeletePrefix([]byte("user:")); err != nil {
+		t.Fatalf("DeletePrefix failed: %v", err)
+	}
+	if stats := cache.Stats(); stats.Entries != 0 || stats.Invalidations != 4 {
+		t.Errorf("stats after DeletePrefix = %+v, want no entries and 4 invalidations", stats)
+	}
+	if _, err := cache.Get([]byte("user:2")); err != ErrKeyNotFound {
+		t.Errorf("Get after DeletePrefix error = %v, want ErrKeyNotFound", err)
+	}
+
+	// Keys outside the prefix are read from the store without caching
+	if err := kv.Put([]byte("order:1"), []byte("v")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, err := cache.Get([]byte("order:1")); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if stats := cache.Stats(); stats.Entries != 0 {
+		t.Errorf("entries = %d, want keys outside the prefix uncached", stats.Entries)
+	}
+}
+
+func TestCache_TTLAndBounds(t *testing.T) {
+	kv := openCacheTestStore(t)
+	cache, err := kv.NewCache(CacheOptions{TTL: 20 * time.Millisecond, MaxEntries: 2, NegativeTTL: time.Minute})
+	if err != nil {
+		t.Fatalf("NewCache failed: %v", err)
+	}
+	defer cache.Close()
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := kv.Put([]byte(key), []byte("v")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if _, err := cache.Get([]byte(key)); err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+	}
+	if stats := cache.Stats(); stats.Entries != 2 || stats.Evictions != 1 {
+		t.Errorf("stats = %+v, want 2 entries after 1 eviction", stats)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, err := cache.Get([]byte("c")); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if stats := cache.Stats(); stats.Misses != 4 {
+		t.Errorf("misses = %d, want the expired entry read again", stats.Misses)
+	}
+
+	// Misses are remembered until the key is written
+	for i := 0; i < 2; i++ {
+		if _, err := cache.Get([]byte("missing")); err != ErrKeyNotFound {
+			t.Fatalf("Get error = %v, want ErrKeyNotFound", err)
+		}
+	}
+	if stats := cache.Stats(); stats.Hits != 1 {
+		t.Errorf("hits = %d, want the second miss served from the cache", stats.Hits)
+	}
+	if err := kv.Put([]byte("missing"), []byte("found")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if value, err := cache.Get([]byte("missing")); err != nil || string(value) != "found" {
+		t.Errorf("Get after Put = %q, %v, want found", value, err)
+	}
+
+	small, err := kv.NewCache(CacheOptions{MaxBytes: 4})
+	if err != nil {
+		t.Fatalf("NewCache failed: %v", err)
+	}
+	defer small.Close()
+	if _, err := small.Get([]byte("missing")); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if stats := small.Stats(); stats.Entries != 0 {
+		t.Errorf("entries = %d, want an entry over MaxBytes left uncached", stats.Entries)
+	}
+}
+
+func TestCache_Close(t *testing.T) {
+	kv := openCacheTestStore(t)
+	cache, err := kv.NewCache(CacheOptions{})
+	if err != nil {
+		t.Fatalf("NewCache failed: %v", err)
+	}
+	if err := kv.Put([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, err := cache.Get([]byte("k")); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	cache.Close()
+	cache.Close()
+	if stats := cache.Stats(); stats.Entries != 0 {
+		t.Errorf("entries = %d after Close, want 0", stats.Entries)
+	}
+	if len(kv.caches) != 0 {
+		t.Errorf("store still holds %d caches after Close", len(kv.caches))
+	}
+}
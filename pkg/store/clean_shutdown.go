@@ -0,0 +1,233 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// shutdownFile marks a clean shutdown and holds the index as it was at
+	// Close. Open deletes it before anything else, so it never outlives the
+	// process that reads it and a crash always leads to full validation.
+	shutdownFile    = "CLEAN_SHUTDOWN"
+	shutdownMagic   = "FJHINTS1"
+	shutdownVersion = 1
+)
+
+// errBadHints marks a shutdown file that can't be used; Open then falls
+// back to validating the log
+var errBadHints = errors.New("unusable shutdown hints")
+
+// shutdownHeader describes the store a shutdown file was written for. The
+// hints are only trusted if the active log and manifest still match it.
+type shutdownHeader struct {
+	Version            int               `json:"version"`
+	LogSize            int64             `json:"log_size"` // Active log size, fsynced by Close
+	ManifestGeneration uint64            `json:"manifest_generation"`
+	Keys               int               `json:"keys"`
+	Tombstones         int               `json:"tombstones"`
+	TombstoneBytes     int64             `json:"tombstone_bytes"`
+	Ranges             []RangeTombstone  `json:"ranges,omitempty"`
+	Sequences          map[string]uint64 `json:"sequences,omitempty"`
+}
+
+// writeShutdownHintsInternal records a clean shutdown: the active log's size
+// and the index, so the next Open can skip validation and the index
+// rebuild. Nothing is written if the index was never loaded. The caller must
+// hold the mutex, after the writer has been closed.
+func (kv *KVStore) writeShutdownHintsInternal() error {
+	if !kv.config.FastRestart || !kv.indexLoaded || kv.standby.enabled {
+		return nil
+	}
+
+	info, err := os.Stat(kv.dataFile)
+	if err != nil {
+		return err
+	}
+	header := shutdownHeader{Version: shutdownVersion, LogSize: info.Size()}
+	if manifest := kv.Manifest(); manifest != nil {
+		header.ManifestGeneration = manifest.Generation
+	}
+
+	return finalizeFile(filepath.Join(kv.config.DataDir, shutdownFile), func(w io.Writer) error {
+		sum := crc32.NewIEEE()
+		return kv.index.writeHints(io.MultiWriter(w, sum), header, func() error {
+			return binary.Write(w, binary.LittleEndian, sum.Sum32())
+		})
+	})
+}
+
+// loadShutdownHintsInternal deletes the shutdown file and, if FastRestart is
+// set and the file matches the active log and manifest, loads the index from
+// it. It returns the file's header if the index was loaded, and nil if there
+// was no usable file. Only failing to delete the file is an error, since the store can't then
+// tell a later crash from this clean shutdown. The caller must hold the
+// mutex, after the manifest has been loaded.
+func (kv *KVStore) loadShutdownHintsInternal() (*shutdownHeader, error) {
+	path := filepath.Join(kv.config.DataDir, shutdownFile)
+	data, readErr := os.ReadFile(filepath.Clean(path))
+	if os.IsNotExist(readErr) {
+		return nil, nil
+	}
+	if err := os.Remove(path); err != nil {
+		return nil, fmt.Errorf("failed to remove %s: %w", shutdownFile, err)
+	}
+	if err := syncDir(kv.config.DataDir); err != nil {
+		return nil, err
+	}
+	if readErr != nil || !kv.config.FastRestart {
+		return nil, nil // An unreadable file only costs a full validation
+	}
+
+	header, err := kv.index.loadHints(data, func(header *shutdownHeader) error {
+		info, err := os.Stat(kv.dataFile)
+		if err != nil || info.Size() != header.LogSize {
+			return fmt.Errorf("%w: active log changed since shutdown", errBadHints)
+		}
+		manifest := kv.Manifest()
+		if manifest == nil || manifest.Generation != header.ManifestGeneration {
+			return fmt.Errorf("%w: manifest changed since shutdown", errBadHints)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ignoring %s: %v\n", shutdownFile, err)
+		return nil, nil
+	}
+	return header, nil
+}
+
+// writeHints writes the index in the shutdown file format: magic, the
+// length-prefixed JSON header, one entry per key and a CRC32 of everything
+// before it, which finish writes.
+func (idx *HashIndex) writeHints(w io.Writer, header shutdownHeader, finish func() error) error {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	header.Keys = idx.size
+	header.Tombstones = idx.tombstones
+	header.TombstoneBytes = idx.tombstoneBytes
+	header.Ranges = idx.ranges
+	header.Sequences = idx.sequences
+	headerData, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 0, 256)
+	buf = append(buf, shutdownMagic...)
+	buf = binary.AppendUvarint(buf, uint64(len(headerData)))
+	buf = append(buf, headerData...)
+	for id, bucket := range idx.entries {
+		prefix := idx.prefixes[id]
+		for suffix, entry := range bucket {
+			buf = binary.AppendUvarint(buf, uint64(len(prefix)+len(suffix)))
+			buf = append(buf, prefix...)
+			buf = append(buf, suffix...)
+			buf = binary.AppendUvarint(buf, uint64(entry.FileID))
+			buf = binary.AppendUvarint(buf, uint64(entry.Offset)) //nolint:gosec // offsets are never negative
+			buf = binary.AppendUvarint(buf, uint64(entry.Size))
+			buf = binary.AppendUvarint(buf, entry.Timestamp)
+			buf = binary.AppendUvarint(buf, entry.ValueHash)
+			if len(buf) >= 64*1024 {
+				if _, err := w.Write(buf); err != nil {
+					return err
+				}
+				buf = buf[:0]
+			}
+		}
+	}
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	return finish()
+}
+
+// loadHints replaces the index with the contents of a shutdown file, once
+// its checksum has been verified and check has accepted its header. On
+// error the index is left empty.
+func (idx *HashIndex) loadHints(data []byte, check func(*shutdownHeader) error) (*shutdownHeader, error) {
+	if len(data) < len(shutdownMagic)+4 || string(data[:len(shutdownMagic)]) != shutdownMagic {
+		return nil, fmt.Errorf("%w: not a shutdown file", errBadHints)
+	}
+	body, sum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, fmt.Errorf("%w: checksum mismatch", errBadHints)
+	}
+
+	reader := bytes.NewReader(body[len(shutdownMagic):])
+	headerLen, err := binary.ReadUvarint(reader)
+	if err != nil || headerLen > uint64(reader.Len()) {
+		return nil, fmt.Errorf("%w: truncated header", errBadHints)
+	}
+	headerData := make([]byte, headerLen)
+	if _, err := io.ReadFull(reader, headerData); err != nil {
+		return nil, fmt.Errorf("%w: truncated header", errBadHints)
+	}
+	var header shutdownHeader
+	if err := json.Unmarshal(headerData, &header); err != nil {
+		return nil, fmt.Errorf("%w: %v", errBadHints, err)
+	}
+	if header.Version != shutdownVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", errBadHints, header.Version)
+	}
+	if err := check(&header); err != nil {
+		return nil, err
+	}
+
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	idx.reset()
+	for i := 0; i < header.Keys; i++ {
+		key, entry, err := readHintEntry(reader)
+		if err != nil {
+			idx.reset()
+			return nil, fmt.Errorf("%w: entry %d: %v", errBadHints, i, err)
+		}
+		idx.putInternal(key, entry)
+	}
+	idx.tombstones = header.Tombstones
+	idx.tombstoneBytes = header.TombstoneBytes
+	idx.ranges = header.Ranges
+	if header.Sequences != nil {
+		idx.sequences = header.Sequences
+	}
+	return &header, nil
+}
+
+// readHintEntry decodes one key and its index entry
+func readHintEntry(reader *bytes.Reader) (string, *IndexEntry, error) {
+	keyLen, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return "", nil, err
+	}
+	if keyLen > uint64(reader.Len()) {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	key := make([]byte, keyLen)
+	if _, err := io.ReadFull(reader, key); err != nil {
+		return "", nil, err
+	}
+
+	var fields [5]uint64
+	for i := range fields {
+		if fields[i], err = binary.ReadUvarint(reader); err != nil {
+			return "", nil, err
+		}
+	}
+	return string(key), &IndexEntry{
+		FileID:    uint32(fields[0]), //nolint:gosec // written from a uint32
+		Offset:    int64(fields[1]),  //nolint:gosec // written from a non-negative int64
+		Size:      uint32(fields[2]), //nolint:gosec // written from a uint32
+		Timestamp: fields[3],
+		ValueHash: fields[4],
+	}, nil
+}
@@ -0,0 +1,133 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKVStore_FastRestart(t *testing.T) {
+	tmpDir := t.TempDir()
+	config := KVStoreConfig{DataDir: tmpDir, FastRestart: true}
+	markerPath := filepath.Join(tmpDir, shutdownFile)
+
+	open := func(config KVStoreConfig) (*KVStore, *RecoveryResult) {
+		t.Helper()
+		store, err := NewKVStore(config)
+		if err != nil {
+			t.Fatalf("Failed to create KV store: %v", err)
+		}
+		result, err := store.Open()
+		if err != nil {
+			t.Fatalf("Failed to open KV store: %v", err)
+		}
+		return store, result
+	}
+
+	store, _ := open(config)
+	for _, key := range []string{"user:1", "user:2", "tmp:1", "relationship:forward:a:b"} {
+		if err := store.Put([]byte(key), []byte("v-"+key)); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+	if err := store.Delete([]byte("user:2")); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if err := store.DeletePrefix([]byte("tmp:")); err != nil {
+		t.Fatalf("Failed to delete prefix: %v", err)
+	}
+	id, err := store.NextID("orders")
+	if err != nil {
+		t.Fatalf("Failed to allocate ID: %v", err)
+	}
+	before := store.Stats()
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close store: %v", err)
+	}
+	if _, err := os.Stat(markerPath); err != nil {
+		t.Fatalf("Expected a clean shutdown marker: %v", err)
+	}
+
+	// A clean restart skips validation and restores the index from the hints
+	store, result := open(config)
+	if !result.CleanShutdown || result.RecordsValidated != 0 || result.IndexRebuilt {
+		t.Errorf("Expected a fast restart, got %+v", result)
+	}
+	if _, err := os.Stat(markerPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the marker to be removed on open, got %v", err)
+	}
+	after := store.Stats()
+	if after.Keys != before.Keys || after.UserKeys != before.UserKeys || after.InternalKeys != before.InternalKeys ||
+		after.Tombstones != before.Tombstones || after.LiveDataSize != before.LiveDataSize {
+		t.Errorf("Stats changed across restart: before %+v, after %+v", before, after)
+	}
+	if value, err := store.Get([]byte("user:1")); err != nil || string(value) != "v-user:1" {
+		t.Errorf("Expected user:1 after restart, got %q, %v", value, err)
+	}
+	if _, err := store.Get([]byte("user:2")); err != ErrKeyNotFound {
+		t.Errorf("Expected user:2 to stay deleted, got %v", err)
+	}
+	if len(store.RangeTombstones()) != 1 {
+		t.Errorf("Expected the range tombstone to be restored, got %v", store.RangeTombstones())
+	}
+	if next, err := store.NextID("orders"); err != nil || next <= id {
+		t.Errorf("Expected an ID above %d, got %d, %v", id, next, err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close store: %v", err)
+	}
+
+	// A log that changed after the marker was written is validated in full
+	writer, err := NewLogWriter(LogWriterConfig{FilePath: filepath.Join(tmpDir, activeDataFile)})
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	if _, err := writer.Put([]byte("user:3"), []byte("late")); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close log: %v", err)
+	}
+	store, result = open(config)
+	if result.CleanShutdown || result.RecordsValidated == 0 {
+		t.Errorf("Expected full validation after the log changed, got %+v", result)
+	}
+	if value, err := store.Get([]byte("user:3")); err != nil || string(value) != "late" {
+		t.Errorf("Expected user:3 from the log, got %q, %v", value, err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close store: %v", err)
+	}
+
+	// A damaged marker is ignored
+	data, err := os.ReadFile(markerPath)
+	if err != nil {
+		t.Fatalf("Failed to read marker: %v", err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(markerPath, data, 0600); err != nil {
+		t.Fatalf("Failed to damage marker: %v", err)
+	}
+	store, result = open(config)
+	if result.CleanShutdown {
+		t.Errorf("Expected a damaged marker to be ignored, got %+v", result)
+	}
+	if _, err := os.Stat(markerPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the damaged marker to be removed, got %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close store: %v", err)
+	}
+
+	// Without FastRestart the marker is neither used nor written
+	store, result = open(KVStoreConfig{DataDir: tmpDir})
+	if result.CleanShutdown {
+		t.Errorf("Expected full validation without FastRestart, got %+v", result)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close store: %v", err)
+	}
+	if _, err := os.Stat(markerPath); !os.IsNotExist(err) {
+		t.Errorf("Expected no marker without FastRestart, got %v", err)
+	}
+}
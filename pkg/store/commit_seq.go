@@ -0,0 +1,58 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// seqPollInterval is how often WaitForSeq checks the commit sequence
+const seqPollInterval = 5 * time.Millisecond
+
+// CommitSeq returns the commit sequence number: the log position just past
+// the last write that reads can see, counting log bytes since emptied by
+// memtable flushes. It grows with every write, survives restarts, and a
+// standby reaches the primary's value once it has indexed the same records,
+// so a client that noted the sequence of its write can wait for any reader
+// of the log to see it. A closed store reports 0.
+func (kv *KVStore) CommitSeq() uint64 {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if !kv.isOpen {
+		return 0
+	}
+	return kv.commitSeqInternal()
+}
+
+// commitSeqInternal returns the commit sequence (caller must hold the mutex
+// of an open store)
+func (kv *KVStore) commitSeqInternal() uint64 {
+	return kv.logBaseInternal() + uint64(kv.writer.Size()) //nolint:gosec // log size is never negative
+}
+
+// WaitForSeq blocks until CommitSeq reaches seq or ctx is done. A primary
+// has applied every sequence it returned, so it only waits for sequences
+// handed out by another process, such as the primary a standby follows.
+func (kv *KVStore) WaitForSeq(ctx context.Context, seq uint64) error {
+	ticker := time.NewTicker(seqPollInterval)
+	defer ticker.Stop()
+
+	for {
+		kv.mutex.Lock()
+		open := kv.isOpen
+		reached := open && kv.commitSeqInternal() >= seq
+		kv.mutex.Unlock()
+		if !open {
+			return &KVError{"store is not open"}
+		}
+		if reached {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
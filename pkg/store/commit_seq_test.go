@@ -0,0 +1,75 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestKVStore_CommitSeq(t *testing.T) {
+	tmpDir := t.TempDir()
+	primary, err := NewKVStore(KVStoreConfig{DataDir: tmpDir})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := primary.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer primary.Close()
+
+	if err := primary.Put([]byte("user:1"), []byte("value")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	first := primary.CommitSeq()
+	if err := primary.Delete([]byte("user:1")); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	seq := primary.CommitSeq()
+	if first == 0 || seq <= first {
+		t.Fatalf("Expected the sequence to grow with each write, got %d then %d", first, seq)
+	}
+
+	// A primary has applied its own sequences
+	if err := primary.WaitForSeq(context.Background(), seq); err != nil {
+		t.Errorf("WaitForSeq failed: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := primary.WaitForSeq(ctx, seq+1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a future sequence to time out, got %v", err)
+	}
+
+	// A standby reaches the sequences handed out by its primary
+	standby := openStandby(t, tmpDir)
+	defer standby.Close()
+	if err := primary.Put([]byte("user:2"), []byte("value")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	seq = primary.CommitSeq()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := standby.WaitForSeq(ctx, seq); err != nil {
+		t.Fatalf("Standby did not reach sequence %d: %v", seq, err)
+	}
+	if _, err := standby.Get([]byte("user:2")); err != nil {
+		t.Errorf("Expected the write to be visible at its sequence: %v", err)
+	}
+
+	// Sequences survive a restart
+	if err := primary.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	if primary.CommitSeq() != 0 {
+		t.Error("Expected a closed store to report sequence 0")
+	}
+	if err := primary.WaitForSeq(context.Background(), 1); err == nil {
+		t.Error("Expected waiting on a closed store to fail")
+	}
+	if _, err := primary.Open(); err != nil {
+		t.Fatalf("Failed to reopen: %v", err)
+	}
+	if primary.CommitSeq() != seq {
+		t.Errorf("Expected sequence %d after reopening, got %d", seq, primary.CommitSeq())
+	}
+}
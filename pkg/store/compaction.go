@@ -0,0 +1,604 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/codec"
+	"github.com/ssargent/freyjadb/pkg/keys"
+)
+
+// Throughput assumed when estimating compaction time: a compaction reads
+// each segment sequentially and rewrites its live records
+const (
+	compactionReadBytesPerSec  = 200 << 20
+	compactionWriteBytesPerSec = 100 << 20
+)
+
+// SegmentCompactionEstimate is what compacting a single segment would do
+type SegmentCompactionEstimate struct {
+	FileID            uint32        `json:"file_id"`
+	Path              string        `json:"path"`
+	SizeBytes         int64         `json:"size_bytes"`
+	LiveBytes         int64         `json:"live_bytes"`
+	LiveKeys          int           `json:"live_keys"`
+	ReclaimableBytes  int64         `json:"reclaimable_bytes"`
+	DeadPct           float64       `json:"dead_pct"`
+	Ready             bool          `json:"ready"` // Dead enough to be worth compacting
+	EstimatedDuration time.Duration `json:"estimated_duration"`
+}
+
+// CompactionEstimate is a dry run of compacting every segment. Reclaimable
+// bytes are an upper bound: a compaction also keeps the newest sequence
+// reservations and any range tombstones still shadowing older segments.
+type CompactionEstimate struct {
+	Segments          []SegmentCompactionEstimate `json:"segments"`
+	TotalBytes        int64                       `json:"total_bytes"`
+	LiveBytes         int64                       `json:"live_bytes"`
+	ReclaimableBytes  int64                       `json:"reclaimable_bytes"`
+	EstimatedDuration time.Duration               `json:"estimated_duration"`
+	Warnings          []string                    `json:"warnings,omitempty"`
+}
+
+// segmentStat is a segment's size on disk and the part the index references
+type segmentStat struct {
+	SegmentTier
+	Size int64
+	SegmentUsage
+}
+
+// segmentStatsInternal sizes every segment from its file and the index
+// (caller must hold the mutex). Segments whose files can't be read are
+// reported as warnings and skipped.
+func (kv *KVStore) segmentStatsInternal() ([]segmentStat, []string) {
+	usage := kv.index.SegmentUsage()
+
+	var stats []segmentStat
+	var warnings []string
+	for _, seg := range kv.segments.list() {
+		size := kv.writer.Size()
+		if seg.FileID != activeFileID {
+			info, err := os.Stat(seg.Path)
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("Segment %d unreadable: %v", seg.FileID, err))
+				continue
+			}
+			size = info.Size()
+		}
+		stats = append(stats, segmentStat{SegmentTier: seg, Size: size, SegmentUsage: usage[seg.FileID]})
+	}
+	return stats, warnings
+}
+
+// deadPct is the percentage of the segment the index no longer references
+func (s segmentStat) deadPct() float64 {
+	if s.Size <= 0 {
+		return 0
+	}
+	return 100 * float64(max(s.Size-s.LiveBytes, 0)) / float64(s.Size)
+}
+
+// EstimateCompaction reports how many bytes compacting each segment would
+// reclaim and roughly how long it would take, without changing anything.
+// It works from the index's live-record accounting, so it costs one pass
+// over the index and no segment reads.
+func (kv *KVStore) EstimateCompaction() (*CompactionEstimate, error) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if err := kv.checkOpenInternal(); err != nil {
+		return nil, err
+	}
+	if err := kv.ensureIndexInternal(); err != nil {
+		return nil, err
+	}
+
+	stats, warnings := kv.segmentStatsInternal()
+	estimate := &CompactionEstimate{Segments: make([]SegmentCompactionEstimate, 0, len(stats)), Warnings: warnings}
+	for _, s := range stats {
+		live := min(s.LiveBytes, s.Size)
+		seg := SegmentCompactionEstimate{
+			FileID:            s.FileID,
+			Path:              s.Path,
+			SizeBytes:         s.Size,
+			LiveBytes:         live,
+			LiveKeys:          s.Keys,
+			ReclaimableBytes:  s.Size - live,
+			DeadPct:           s.deadPct(),
+			Ready:             s.deadPct() > compactionReadyDeadPct,
+			EstimatedDuration: compactionDuration(s.Size, live),
+		}
+		estimate.Segments = append(estimate.Segments, seg)
+		estimate.TotalBytes += seg.SizeBytes
+		estimate.LiveBytes += seg.LiveBytes
+		estimate.ReclaimableBytes += seg.ReclaimableBytes
+		estimate.EstimatedDuration += seg.EstimatedDuration
+	}
+	return estimate, nil
+}
+
+// compactionDuration estimates the time to read size bytes and rewrite live
+func compactionDuration(size, live int64) time.Duration {
+	seconds := float64(size)/compactionReadBytesPerSec + float64(live)/compactionWriteBytesPerSec
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// ClusterStrategy decides the order in which compaction writes live
+// records, and so which keys end up physically next to each other
+type ClusterStrategy string
+
+const (
+	// ClusterNone keeps records in the order they were written
+	ClusterNone ClusterStrategy = "none"
+	// ClusterPK groups records by partition key, the first component of the
+	// key (see pkg/keys)
+	ClusterPK ClusterStrategy = "pk"
+	// ClusterPrefix groups records by their first ClusterDepth key components
+	ClusterPrefix ClusterStrategy = "prefix"
+
+	// DefaultClusterDepth is the number of key components ClusterPrefix
+	// groups by when no depth is configured: partition and sort key
+	DefaultClusterDepth = 2
+)
+
+// ParseClusterStrategy checks a strategy name; an empty name is ClusterNone
+func ParseClusterStrategy(name string) (ClusterStrategy, error) {
+	switch strategy := ClusterStrategy(name); strategy {
+	case "":
+		return ClusterNone, nil
+	case ClusterNone, ClusterPK, ClusterPrefix:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("unknown cluster strategy %q (want none, pk or prefix)", name)
+}
+
+// CompactOptions overrides the store's compaction configuration for one
+// compaction. Zero values fall back to KVStoreConfig.
+type CompactOptions struct {
+	Cluster      ClusterStrategy
+	ClusterDepth int
+}
+
+// CompactionResult describes a finished compaction
+type CompactionResult struct {
+	Compacted    []uint32        `json:"compacted"` // Segments replaced, 0 being the active log
+	FileID       uint32          `json:"file_id"`   // The new segment, 0 if there was nothing to compact
+	BytesBefore  int64           `json:"bytes_before"`
+	BytesAfter   int64           `json:"bytes_after"`
+	LiveKeys     int             `json:"live_keys"`
+	ExpiredKeys  int             `json:"expired_keys,omitempty"`  // Keys dropped because their TTL ran out
+	FilteredKeys int             `json:"filtered_keys,omitempty"` // Keys compaction filters dropped, re-keyed or overwrote
+	ChangedKeys  int             `json:"changed_keys,omitempty"`  // Records compaction filters rewrote
+	SpentRanges  int             `json:"spent_ranges,omitempty"`  // Range tombstones dropped for covering no kept record
+	Cluster      ClusterStrategy `json:"cluster"`
+	ClusterDepth int             `json:"cluster_depth,omitempty"`
+	Duration     time.Duration   `json:"duration"`
+}
+
+// compactRecord is a record compaction copies into the new segment
+type compactRecord struct {
+	key   string // Empty for range tombstones and sequence reservations
+	entry IndexEntry
+	group string          // Cluster the record is ordered by
+	rt    *RangeTombstone // Set for range tombstones
+
+	// A record changed by compaction filters is written from data, its new
+	// encoding, rather than copied
+	value []byte
+	data  []byte
+}
+
+// Compact rewrites every sealed segment and the active log as one new
+// segment holding only what is still needed: the latest record of every
+// live key, the newest reservation of every sequence and the range
+// tombstones that still delete an older version of a kept key. Point
+// tombstones, spent range tombstones and overwritten records are dropped. Live
+// records are ordered by the cluster strategy, so a prefix scan over a
+// clustered partition reads one contiguous run of the segment. Registered
+// CompactionFilters may drop, change or re-key live records on the way;
+// watchers and caches see their changes like writes.
+//
+// The new segment is recorded in the manifest before the old segments are
+// deleted and the active log is emptied, so a crash at any point loses no
+// write. Writes and reads wait for the compaction, and it waits for any
+// outstanding Freeze. Since it holds up client traffic, it first waits
+// briefly for the foreground to have IO headroom.
+func (kv *KVStore) Compact(opts CompactOptions) (*CompactionResult, error) {
+	start := time.Now()
+	cluster, depth, err := kv.clusterOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if kv.IsStandby() {
+		return nil, ErrReadOnly
+	}
+	kv.io.waitHeadroom(compactionHeadroomWait)
+
+	kv.maintenance.Lock()
+	defer kv.maintenance.Unlock()
+
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if err := kv.checkOpenInternal(); err != nil {
+		return nil, err
+	}
+	result, err := kv.compactInternal(cluster, depth)
+	if err != nil {
+		return nil, err
+	}
+	result.Duration = time.Since(start)
+	return result, nil
+}
+
+// clusterOptions resolves the strategy and depth of a compaction
+func (kv *KVStore) clusterOptions(opts CompactOptions) (ClusterStrategy, int, error) {
+	name := opts.Cluster
+	if name == "" {
+		name = kv.config.CompactionCluster
+	}
+	cluster, err := ParseClusterStrategy(string(name))
+	if err != nil {
+		return "", 0, err
+	}
+
+	switch cluster {
+	case ClusterPK:
+		return cluster, 1, nil
+	case ClusterPrefix:
+		depth := opts.ClusterDepth
+		if depth == 0 {
+			depth = kv.config.ClusterDepth
+		}
+		if depth == 0 {
+			depth = DefaultClusterDepth
+		}
+		if depth < 0 {
+			return "", 0, fmt.Errorf("cluster depth must be positive, got %d", depth)
+		}
+		return cluster, depth, nil
+	}
+	return ClusterNone, 0, nil
+}
+
+// compactInternal does the work of Compact (caller must hold the mutex and
+// the maintenance lock)
+func (kv *KVStore) compactInternal(cluster ClusterStrategy, depth int) (*CompactionResult, error) {
+	result := &CompactionResult{Cluster: cluster}
+	if cluster == ClusterPrefix {
+		result.ClusterDepth = depth
+	}
+
+	// Records are copied from the files, so the log must be on disk
+	if err := kv.writer.Sync(); err != nil {
+		return nil, err
+	}
+	logSize := kv.writer.Size()
+	segments := kv.segments.list()
+	for _, seg := range segments {
+		if seg.FileID == activeFileID {
+			result.BytesBefore += logSize
+			continue
+		}
+		info, err := os.Stat(seg.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat segment %d: %w", seg.FileID, err)
+		}
+		result.BytesBefore += info.Size()
+	}
+	if len(segments) == 1 && logSize == 0 {
+		return result, nil // Nothing written yet
+	}
+
+	special, err := kv.compactionSpecialsInternal(segments)
+	if err != nil {
+		return nil, err
+	}
+	// Expired keys are left behind; with every older version of them in
+	// the replaced segments, nothing brings them back
+	now := time.Now()
+	live := make([]compactRecord, 0, kv.index.Size())
+	var expiredKeys []string
+	for _, key := range kv.index.Keys() {
+		entry, ok := kv.index.Get([]byte(key))
+		switch {
+		case !ok:
+		case entry.expired(now):
+			expiredKeys = append(expiredKeys, key)
+		default:
+			live = append(live, compactRecord{key: key, entry: *entry, group: clusterGroup(key, depth)})
+		}
+	}
+	live, filteredKeys, err := kv.filterCompactionInternal(live, depth)
+	if err != nil {
+		return nil, err
+	}
+	specials := len(special)
+	special, ranges := keepCoveringRanges(special, live)
+	result.SpentRanges = specials - len(special)
+	sort.SliceStable(live, func(i, j int) bool {
+		if live[i].group != live[j].group {
+			return live[i].group < live[j].group
+		}
+		return writtenBefore(live[i].entry, live[j].entry)
+	})
+
+	fileID := kv.nextFileIDInternal()
+	dir, err := kv.placer.Next()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, segmentFileName(fileID))
+	offsets, err := kv.writeCompactedSegmentInternal(path, append(special, live...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to write segment %d: %w", fileID, err)
+	}
+
+	// Swap the segments in the manifest; until it is written the old ones
+	// are still the store
+	for _, seg := range segments {
+		kv.segments.remove(seg.FileID)
+	}
+	kv.segments.add(fileID, path)
+	kv.manifest.mutex.Lock()
+	kv.manifest.logBase += uint64(logSize) //nolint:gosec // log size is never negative
+	historyFrom := kv.manifest.historyFrom
+	kv.manifest.historyFrom = uint64(time.Now().UnixNano()) //nolint:gosec // Unix nanoseconds are positive
+	kv.manifest.mutex.Unlock()
+	if err := kv.saveManifest(); err != nil {
+		kv.segments.remove(fileID)
+		kv.segments.mutex.Lock()
+		for _, seg := range segments {
+			tier := seg
+			kv.segments.segments[seg.FileID] = &tier
+		}
+		kv.segments.mutex.Unlock()
+		kv.manifest.mutex.Lock()
+		kv.manifest.logBase -= uint64(logSize) //nolint:gosec // log size is never negative
+		kv.manifest.historyFrom = historyFrom
+		kv.manifest.mutex.Unlock()
+		os.Remove(path) //nolint:errcheck,gosec // Unlisted, so never read
+		return nil, err
+	}
+
+	for i, rec := range live {
+		updated := rec.entry
+		updated.FileID = fileID
+		updated.Offset = offsets[len(special)+i]
+		kv.index.Put([]byte(rec.key), &updated)
+	}
+	for _, key := range expiredKeys {
+		kv.index.Delete([]byte(key))
+	}
+	now = time.Now()
+	for _, key := range filteredKeys {
+		kv.index.Delete([]byte(key))
+		kv.publishInternal([]byte(key), nil, uint64(now.UnixNano())) //nolint:gosec // Unix nanoseconds are positive
+	}
+	for _, rec := range live {
+		if rec.data != nil {
+			kv.publishInternal([]byte(rec.key), rec.value, rec.entry.Timestamp)
+			result.ChangedKeys++
+		}
+	}
+	kv.index.clearTombstones() // Their records are gone
+	kv.index.retainRanges(ranges)
+
+	// The old files are no longer listed, so failing to delete them only
+	// costs space
+	for _, seg := range segments {
+		if seg.FileID == activeFileID {
+			if logSize > 0 {
+				result.Compacted = append(result.Compacted, activeFileID)
+			}
+			continue
+		}
+		result.Compacted = append(result.Compacted, seg.FileID)
+		if err := os.Remove(seg.Path); err != nil {
+			fmt.Fprintf(os.Stderr, "Error removing compacted segment %d: %v\n", seg.FileID, err)
+		}
+	}
+	if err := kv.writer.truncate(); err != nil {
+		return nil, fmt.Errorf("failed to empty log after compaction: %w", err)
+	}
+	kv.openSize -= logSize // Keep bytes written since open counting across the restart of the log
+	if kv.memtable != nil {
+		kv.memtable = newMemtable()
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	result.FileID = fileID
+	result.BytesAfter = info.Size()
+	result.LiveKeys = len(live)
+	result.ExpiredKeys = len(expiredKeys)
+	result.FilteredKeys = len(filteredKeys)
+	return result, nil
+}
+
+// compactionSpecialsInternal finds the records compaction keeps besides
+// live keys: every range tombstone and the newest reservation of every
+// sequence, in write order. segments must include the active log, which
+// has been synced. The caller must hold the mutex.
+func (kv *KVStore) compactionSpecialsInternal(segments []SegmentTier) ([]compactRecord, error) {
+	var kept []compactRecord
+	reservations := make(map[string]int) // Sequence name -> index in kept
+	limits := make(map[string]uint64)    // Sequence name -> highest reserved ID
+
+	for _, seg := range segments {
+		path := seg.Path
+		if seg.FileID == activeFileID {
+			path = kv.dataFile
+		}
+		reader, err := NewLogReader(LogReaderConfig{FilePath: path, Codec: kv.codec})
+		if err != nil {
+			return nil, fmt.Errorf("failed to open segment %d: %w", seg.FileID, err)
+		}
+
+		for {
+			offset := reader.Offset()
+			record, err := reader.ReadNext()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				reader.Close()
+				return nil, fmt.Errorf("failed to read segment %d: %w", seg.FileID, err)
+			}
+			if !codec.IsReservedKey(record.Key) {
+				continue
+			}
+
+			rec := compactRecord{entry: IndexEntry{
+				FileID:    seg.FileID,
+				Offset:    offset,
+				Size:      uint32(record.Size()), //nolint:gosec // record sizes fit in uint32
+				Timestamp: record.Timestamp,
+			}}
+			if start, end, ok := codec.DecodeRangeTombstone(record); ok {
+				rec.rt = &RangeTombstone{Start: start, End: end, Timestamp: record.Timestamp}
+			}
+			if name, limit, ok := codec.DecodeSequenceReservation(record); ok {
+				if pos, seen := reservations[name]; seen {
+					if limit <= limits[name] {
+						continue
+					}
+					kept[pos] = rec
+				} else {
+					reservations[name] = len(kept)
+					kept = append(kept, rec)
+				}
+				limits[name] = limit
+				continue
+			}
+			kept = append(kept, rec)
+		}
+		reader.Close()
+	}
+
+	sort.SliceStable(kept, func(i, j int) bool { return writtenBefore(kept[i].entry, kept[j].entry) })
+	return kept, nil
+}
+
+// keepCoveringRanges drops the range tombstones among special that delete
+// no older version of a live key. Every other record they deleted is left
+// behind with the replaced segments, so once none of the kept records is
+// theirs to delete they are spent. It returns the remaining records and
+// tombstones, in write order.
+func keepCoveringRanges(special, live []compactRecord) ([]compactRecord, []RangeTombstone) {
+	sorted := make([]*compactRecord, len(live))
+	for i := range live {
+		sorted[i] = &live[i]
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].key < sorted[j].key })
+
+	kept := special[:0:0]
+	var ranges []RangeTombstone
+	for _, rec := range special {
+		if rec.rt == nil {
+			kept = append(kept, rec)
+			continue
+		}
+		rt := *rec.rt
+		start := string(rt.Start)
+		i := sort.Search(len(sorted), func(i int) bool { return sorted[i].key >= start })
+		for ; i < len(sorted) && rt.Contains([]byte(sorted[i].key)); i++ {
+			if rt.Covers([]byte(sorted[i].key), sorted[i].entry.Timestamp) {
+				kept = append(kept, rec)
+				ranges = append(ranges, rt)
+				break
+			}
+		}
+	}
+	return kept, ranges
+}
+
+// writeCompactedSegmentInternal copies records, unchanged unless filters
+// changed them, into a new segment at path and returns the offset each one
+// landed at (caller must hold the mutex)
+func (kv *KVStore) writeCompactedSegmentInternal(path string, records []compactRecord) ([]int64, error) {
+	files := make(map[uint32]*os.File)
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+
+	offsets := make([]int64, len(records))
+	err := finalizeFile(path, func(w io.Writer) error {
+		var written int64
+		for i, rec := range records {
+			offsets[i] = written
+			if rec.data != nil {
+				n, err := w.Write(rec.data)
+				if err != nil {
+					return err
+				}
+				written += int64(n)
+				continue
+			}
+
+			file, ok := files[rec.entry.FileID]
+			if !ok {
+				source := kv.dataFile
+				if rec.entry.FileID != activeFileID {
+					var found bool
+					if source, found = kv.segments.touch(rec.entry.FileID); !found {
+						return fmt.Errorf("unknown segment %d", rec.entry.FileID)
+					}
+				}
+				var err error
+				if file, err = os.Open(filepath.Clean(source)); err != nil {
+					return err
+				}
+				files[rec.entry.FileID] = file
+			}
+
+			n, err := io.Copy(w, io.NewSectionReader(file, rec.entry.Offset, int64(rec.entry.Size)))
+			if err != nil {
+				return err
+			}
+			if n != int64(rec.entry.Size) {
+				return fmt.Errorf("segment %d: record at %d is truncated", rec.entry.FileID, rec.entry.Offset)
+			}
+			written += n
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return offsets, nil
+}
+
+// writtenBefore orders index entries by when their records were written:
+// sealed segments by FileID, then the active log, and by offset within each
+func writtenBefore(a, b IndexEntry) bool {
+	if a.FileID != b.FileID {
+		if a.FileID == activeFileID || b.FileID == activeFileID {
+			return b.FileID == activeFileID
+		}
+		return a.FileID < b.FileID
+	}
+	return a.Offset < b.Offset
+}
+
+// clusterGroup returns the first depth components of key, or "" when depth
+// is zero and records aren't clustered
+func clusterGroup(key string, depth int) string {
+	if depth == 0 {
+		return ""
+	}
+	components := strings.SplitN(key, keys.Separator, depth+1)
+	if len(components) <= depth {
+		return key
+	}
+	return strings.Join(components[:depth], keys.Separator)
+}
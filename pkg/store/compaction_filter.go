@@ -0,0 +1,178 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ssargent/freyjadb/pkg/codec"
+)
+
+// CompactionFilter is a hook Compact runs on every live record it rewrites,
+// for data lifecycle policies that would otherwise need a batch job:
+// dropping business data past its retention, stripping deprecated fields
+// from values or moving keys to a new layout. Filters run in the order
+// they were registered, each seeing the key and value the previous one
+// left, and run while the store is locked, so Filter must not call back
+// into the store.
+type CompactionFilter struct {
+	Name   string // Identifies the filter to UnregisterCompactionFilter and in errors
+	Prefix []byte // Only keys starting with Prefix are passed to Filter (nil = every key)
+
+	// Filter decides what becomes of a record. key and value must not be
+	// modified or kept after it returns.
+	Filter func(key, value []byte) CompactionDecision
+}
+
+// CompactionDecision is what a CompactionFilter does with a record. The
+// zero decision keeps the record unchanged.
+//
+// A dropped key leaves the store like an expired one: no tombstone is
+// written, since compaction removes every older version of it. A changed
+// record keeps its timestamp and expiry. When a record is re-keyed onto a
+// key that compaction also keeps, the record written last wins.
+type CompactionDecision struct {
+	Drop  bool
+	Key   []byte // Replaces the record's key (nil keeps it)
+	Value []byte // Replaces the value (nil keeps it; empty drops the key, as empty values are deletions)
+}
+
+// RegisterCompactionFilter adds a filter that later compactions run after
+// the ones already registered. KVStoreConfig.CompactionFilters are
+// registered by NewKVStore.
+func (kv *KVStore) RegisterCompactionFilter(filter CompactionFilter) error {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	return kv.registerCompactionFilterInternal(filter)
+}
+
+// UnregisterCompactionFilter removes the filter called name, reporting
+// whether there was one
+func (kv *KVStore) UnregisterCompactionFilter(name string) bool {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	for i, f := range kv.compactionFilters {
+		if f.Name == name {
+			kv.compactionFilters = append(kv.compactionFilters[:i:i], kv.compactionFilters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// registerCompactionFilterInternal checks and adds a filter (caller must
+// hold the mutex)
+func (kv *KVStore) registerCompactionFilterInternal(filter CompactionFilter) error {
+	if filter.Name == "" || filter.Filter == nil {
+		return fmt.Errorf("compaction filters need a name and a Filter")
+	}
+	for _, f := range kv.compactionFilters {
+		if f.Name == filter.Name {
+			return fmt.Errorf("compaction filter %q is already registered", filter.Name)
+		}
+	}
+	kv.compactionFilters = append(kv.compactionFilters, filter)
+	return nil
+}
+
+// filterMatchesInternal reports whether any filter takes key (caller must
+// hold the mutex)
+func (kv *KVStore) filterMatchesInternal(key []byte) bool {
+	for _, f := range kv.compactionFilters {
+		if bytes.HasPrefix(key, f.Prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyCompactionFiltersInternal runs the filters over a record, returning
+// its key and value afterwards, nil if it was dropped (caller must hold the
+// mutex)
+func (kv *KVStore) applyCompactionFiltersInternal(key, value []byte) ([]byte, []byte, error) {
+	for _, f := range kv.compactionFilters {
+		if !bytes.HasPrefix(key, f.Prefix) {
+			continue
+		}
+		decision := f.Filter(key, value)
+		if decision.Drop {
+			return nil, nil, nil
+		}
+		if decision.Key != nil {
+			if len(decision.Key) == 0 || codec.IsReservedKey(decision.Key) {
+				return nil, nil, fmt.Errorf("compaction filter %q: re-keying %q: %w", f.Name, key, ErrInvalidKey)
+			}
+			key = bytes.Clone(decision.Key)
+		}
+		if decision.Value != nil {
+			if len(decision.Value) == 0 {
+				return nil, nil, nil
+			}
+			value = bytes.Clone(decision.Value)
+		}
+	}
+	if kv.config.MaxRecordSize > 0 && len(key)+len(value) > kv.config.MaxRecordSize {
+		return nil, nil, fmt.Errorf("compaction filters: record %q: %w", key, ErrRecordSizeExceeded)
+	}
+	return key, value, nil
+}
+
+// filterCompactionInternal runs the compaction filters over the live
+// records. It returns the records to write, with the ones filters changed
+// re-encoded, and the keys that leave the index because they were dropped,
+// re-keyed or overwritten by a re-keyed record. The caller must hold the
+// mutex.
+func (kv *KVStore) filterCompactionInternal(live []compactRecord, depth int) ([]compactRecord, []string, error) {
+	if len(kv.compactionFilters) == 0 {
+		return live, nil, nil
+	}
+	files := kv.newSegmentFiles()
+	defer files.close()
+
+	kept := make([]compactRecord, 0, len(live))
+	at := make(map[string]int, len(live)) // Key -> index in kept
+	for _, rec := range live {
+		if kv.filterMatchesInternal([]byte(rec.key)) {
+			record, err := kv.readKeyFromInternal([]byte(rec.key), &rec.entry, files)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read %q for compaction filters: %w", rec.key, err)
+			}
+			key, value, err := kv.applyCompactionFiltersInternal(record.Key, record.Value)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch {
+			case key == nil:
+				continue
+			case !bytes.Equal(key, record.Key) || !bytes.Equal(value, record.Value):
+				data, err := kv.codec.EncodeExpiring(key, value, rec.entry.Timestamp, rec.entry.ExpiresAt)
+				if err != nil {
+					return nil, nil, fmt.Errorf("failed to encode %q: %w", key, err)
+				}
+				rec.key, rec.group, rec.value, rec.data = string(key), clusterGroup(string(key), depth), value, data
+				rec.entry.Size = uint32(len(data)) //nolint:gosec // record sizes fit in uint32
+				if rec.entry.ValueHash != 0 {
+					rec.entry.ValueHash = valueHash(value)
+				}
+			}
+		}
+
+		if i, taken := at[rec.key]; taken {
+			if writtenBefore(kept[i].entry, rec.entry) {
+				kept[i] = rec
+			}
+			continue
+		}
+		at[rec.key] = len(kept)
+		kept = append(kept, rec)
+	}
+
+	var removed []string
+	for _, rec := range live {
+		if _, ok := at[rec.key]; !ok {
+			removed = append(removed, rec.key)
+		}
+	}
+	return kept, removed, nil
+}
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestKVStore_CompactionFilters(t *testing.T) {
+	dir := t.TempDir()
+	filters := []CompactionFilter{
+		{
+			// Sessions marked expired by the application are dropped
+			Name:   "expired-sessions",
+			Prefix: []byte("session:"),
+			Filter: func(key, value []byte) CompactionDecision {
+				return CompactionDecision{Drop: bytes.HasPrefix(value, []byte("expired"))}
+			},
+		},
+		{
+			// A deprecated field is stripped from profiles
+			Name:   "strip-legacy",
+			Prefix: []byte("user:"),
+			Filter: func(key, value []byte) CompactionDecision {
+				if !bytes.Contains(value, []byte(`,"legacy":1`)) {
+					return CompactionDecision{}
+				}
+				return CompactionDecision{Value: bytes.ReplaceAll(value, []byte(`,"legacy":1`), nil)}
+			},
+		},
+		{
+			// Keys move from old: to new:
+			Name:   "rename",
+			Prefix: []byte("old:"),
+			Filter: func(key, value []byte) CompactionDecision {
+				return CompactionDecision{Key: append([]byte("new:"), key[len("old:"):]...)}
+			},
+		},
+	}
+	config := KVStoreConfig{DataDir: dir, CompactionFilters: filters}
+	store, err := NewKVStore(config)
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+
+	put := func(key, value string) {
+		t.Helper()
+		if err := store.Put([]byte(key), []byte(value)); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+	put("session:1", "expired at noon")
+	put("session:2", "active")
+	put("user:1", `{"name":"ada","legacy":1}`)
+	put("user:2", `{"name":"grace"}`)
+	put("old:1", "one")
+	put("new:2", "two, written first")
+	put("old:2", "two, written last")
+	put("old:3", "three, written first")
+	put("new:3", "three, written last")
+
+	cache, err := store.NewCache(CacheOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	if _, err := cache.Get([]byte("user:1")); err != nil {
+		t.Fatalf("Failed to read through the cache: %v", err)
+	}
+
+	result, err := store.Compact(CompactOptions{})
+	if err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	// session:1 dropped; old:1, old:2 and old:3 moved or overwritten
+	if result.FilteredKeys != 4 || result.ChangedKeys != 3 || result.LiveKeys != 6 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+
+	check := func() {
+		t.Helper()
+		want := map[string]string{
+			"session:2": "active",
+			"user:1":    `{"name":"ada"}`,
+			"user:2":    `{"name":"grace"}`,
+			"new:1":     "one",
+			"new:2":     "two, written last",
+			"new:3":     "three, written last",
+		}
+		for key, value := range want {
+			if got, err := store.Get([]byte(key)); err != nil || string(got) != value {
+				t.Errorf("Expected %s = %q, got %q, %v", key, value, got, err)
+			}
+		}
+		for _, key := range []string{"session:1", "old:1", "old:2", "old:3"} {
+			if _, err := store.Get([]byte(key)); !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("Expected %s gone, got %v", key, err)
+			}
+		}
+		if keys, err := store.ListKeys([]byte("new:")); err != nil || len(keys) != 3 {
+			t.Errorf("Expected the three new: keys listed, got %v, %v", keys, err)
+		}
+	}
+	check()
+	if got, err := cache.Get([]byte("user:1")); err != nil || string(got) != `{"name":"ada"}` {
+		t.Errorf("Expected the cache to see the stripped value, got %q, %v", got, err)
+	}
+
+	// The filtered records are what the store reopens with
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	if store, err = NewKVStore(KVStoreConfig{DataDir: dir}); err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to reopen: %v", err)
+	}
+	defer store.Close()
+	check()
+
+	// Registration checks names, and a bad decision leaves the store as it was
+	if err := store.RegisterCompactionFilter(CompactionFilter{Name: "no-func"}); err == nil {
+		t.Error("Expected a filter without a func to be rejected")
+	}
+	bad := CompactionFilter{Name: "bad", Filter: func(key, value []byte) CompactionDecision {
+		return CompactionDecision{Key: []byte{}}
+	}}
+	if err := store.RegisterCompactionFilter(bad); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	if err := store.RegisterCompactionFilter(bad); err == nil || !strings.Contains(err.Error(), "already registered") {
+		t.Errorf("Expected a second filter called bad to be rejected, got %v", err)
+	}
+	if _, err := store.Compact(CompactOptions{}); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Expected ErrInvalidKey from re-keying to an empty key, got %v", err)
+	}
+	check()
+	if !store.UnregisterCompactionFilter("bad") || store.UnregisterCompactionFilter("bad") {
+		t.Error("Expected bad to be unregistered once")
+	}
+	if _, err := store.Compact(CompactOptions{}); err != nil {
+		t.Errorf("Expected compaction without filters to succeed, got %v", err)
+	}
+	check()
+}
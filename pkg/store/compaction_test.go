@@ -0,0 +1,218 @@
+package store
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestKVStore_EstimateCompaction(t *testing.T) {
+	store, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	estimate, err := store.EstimateCompaction()
+	if err != nil {
+		t.Fatalf("EstimateCompaction failed: %v", err)
+	}
+	if len(estimate.Segments) != 1 || estimate.ReclaimableBytes != 0 || estimate.Segments[0].Ready {
+		t.Fatalf("Expected an empty active segment, got %+v", estimate)
+	}
+
+	value := []byte(strings.Repeat("v", 1000))
+	for i := 0; i < 3; i++ {
+		if err := store.Put([]byte("hot"), value); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	if err := store.Put([]byte("cold"), value); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+
+	estimate, err = store.EstimateCompaction()
+	if err != nil {
+		t.Fatalf("EstimateCompaction failed: %v", err)
+	}
+	seg := estimate.Segments[0]
+	if seg.LiveKeys != 2 || seg.SizeBytes != seg.LiveBytes+seg.ReclaimableBytes {
+		t.Errorf("Inconsistent segment estimate: %+v", seg)
+	}
+	// Two of the four records were overwritten
+	if seg.DeadPct < 45 || seg.DeadPct > 55 || !seg.Ready {
+		t.Errorf("Expected about half the segment dead and ready, got %+v", seg)
+	}
+	if estimate.ReclaimableBytes != seg.ReclaimableBytes || estimate.EstimatedDuration <= 0 {
+		t.Errorf("Unexpected totals: %+v", estimate)
+	}
+
+	// Nothing was changed by the dry run
+	if stats := store.Stats(); stats.DataSize != seg.SizeBytes {
+		t.Errorf("Expected data size %d to be untouched, got %d", seg.SizeBytes, stats.DataSize)
+	}
+}
+
+func TestKVStore_Compact(t *testing.T) {
+	dir := t.TempDir()
+	config := KVStoreConfig{DataDir: dir, WriteMode: WriteModeMemtable}
+	store, err := NewKVStore(config)
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+
+	put := func(key, value string) {
+		t.Helper()
+		if err := store.Put([]byte(key), []byte(value)); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+	// Interleave two partitions across two flushed segments and the log
+	put("user:1:name", "ada")
+	put("order:1:total", "10")
+	put("tmp:1", "scratch")
+	put("user:2:name", "grace")
+	id, err := store.NextID("orders")
+	if err != nil {
+		t.Fatalf("NextID failed: %v", err)
+	}
+	if err := store.FlushMemtable(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	put("order:2:total", "20")
+	put("user:1:name", "ada lovelace")
+	if err := store.DeletePrefix([]byte("tmp:")); err != nil {
+		t.Fatalf("Failed to delete prefix: %v", err)
+	}
+	if err := store.FlushMemtable(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	put("order:1:total", "15")
+	if err := store.Delete([]byte("user:2:name")); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	old := store.SegmentTiers()
+
+	result, err := store.Compact(CompactOptions{Cluster: ClusterPK})
+	if err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if len(result.Compacted) != 3 || result.LiveKeys != 3 || result.BytesAfter >= result.BytesBefore {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+
+	// Only the new segment and an empty log remain
+	tiers := store.SegmentTiers()
+	if len(tiers) != 2 || tiers[1].FileID != result.FileID || store.writer.Size() != 0 {
+		t.Fatalf("Expected the active log and segment %d, got %+v", result.FileID, tiers)
+	}
+	for _, tier := range old {
+		if _, err := os.Stat(tier.Path); tier.FileID != activeFileID && !os.IsNotExist(err) {
+			t.Errorf("Expected segment %d to be deleted, got %v", tier.FileID, err)
+		}
+	}
+
+	// Partitions are contiguous and keep write order within them
+	var keys []string
+	if _, err := store.ReadLogRecords(context.Background(), result.FileID, 0, func(record LogRecordInfo) error {
+		if !record.Internal {
+			keys = append(keys, string(record.Key))
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("Failed to read the new segment: %v", err)
+	}
+	want := []string{"order:2:total", "order:1:total", "user:1:name"}
+	if strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected records %v, got %v", want, keys)
+	}
+
+	check := func() {
+		t.Helper()
+		for key, value := range map[string]string{"user:1:name": "ada lovelace", "order:1:total": "15", "order:2:total": "20"} {
+			if got, err := store.Get([]byte(key)); err != nil || string(got) != value {
+				t.Errorf("Expected %s=%s, got %q (%v)", key, value, got, err)
+			}
+		}
+		for _, key := range []string{"user:2:name", "tmp:1"} {
+			if _, err := store.Get([]byte(key)); err != ErrKeyNotFound {
+				t.Errorf("Expected %s to stay deleted, got %v", key, err)
+			}
+		}
+		if next, err := store.NextID("orders"); err != nil || next <= id {
+			t.Errorf("Expected an ID after %d, got %d (%v)", id, next, err)
+		}
+	}
+	check()
+	if stats := store.Stats(); stats.Tombstones != 0 {
+		t.Errorf("Expected no tombstones after compaction, got %d", stats.Tombstones)
+	}
+
+	// The compacted store reopens to the same contents
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	store, err = NewKVStore(config)
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to reopen KV store: %v", err)
+	}
+	defer store.Close()
+	check()
+}
+
+func TestKVStore_CompactCluster(t *testing.T) {
+	store, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir(), CompactionCluster: ClusterPrefix})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	written := []string{"user:2:order:1", "user:1:order:1", "user:2:profile", "user:1:order:2", "item:9"}
+	for _, key := range written {
+		if err := store.Put([]byte(key), []byte("v")); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+
+	order := func(opts CompactOptions) []string {
+		t.Helper()
+		result, err := store.Compact(opts)
+		if err != nil {
+			t.Fatalf("Compact failed: %v", err)
+		}
+		var keys []string
+		if _, err := store.ReadLogRecords(context.Background(), result.FileID, 0, func(record LogRecordInfo) error {
+			keys = append(keys, string(record.Key))
+			return nil
+		}); err != nil {
+			t.Fatalf("Failed to read segment: %v", err)
+		}
+		return keys
+	}
+
+	// Without clustering, records keep their write order
+	if got := strings.Join(order(CompactOptions{Cluster: ClusterNone}), ","); got != strings.Join(written, ",") {
+		t.Errorf("Expected write order, got %s", got)
+	}
+	// The configured strategy groups by partition and sort key
+	if got := strings.Join(order(CompactOptions{}), ","); got != "item:9,user:1:order:1,user:1:order:2,user:2:order:1,user:2:profile" {
+		t.Errorf("Unexpected prefix clustering: %s", got)
+	}
+
+	if _, err := store.Compact(CompactOptions{Cluster: "zorder"}); err == nil {
+		t.Error("Expected an error for an unknown strategy")
+	}
+}
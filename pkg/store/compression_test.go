@@ -0,0 +1,86 @@
+package store
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ssargent/freyjadb/pkg/codec"
+)
+
+func TestKVStore_Compression(t *testing.T) {
+	keyring, err := codec.NewKeyring("k1", bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("NewKeyring failed: %v", err)
+	}
+	large := strings.Repeat("compressible value ", 200)
+
+	for _, tc := range []struct {
+		name       string
+		algorithm  codec.Compression
+		encryption codec.EncryptionProvider
+	}{
+		{"zstd", codec.CompressionZstd, nil},
+		{"snappy", codec.CompressionSnappy, nil},
+		{"zstd encrypted", codec.CompressionZstd, keyring},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			open := func(compression codec.CompressionOptions) *KVStore {
+				t.Helper()
+				store, err := NewKVStore(KVStoreConfig{DataDir: tmpDir, Encryption: tc.encryption, Compression: compression})
+				if err != nil {
+					t.Fatalf("Failed to create KV store: %v", err)
+				}
+				if _, err := store.Open(); err != nil {
+					t.Fatalf("Failed to open KV store: %v", err)
+				}
+				return store
+			}
+			check := func(phase string, store *KVStore) {
+				t.Helper()
+				for key, want := range map[string]string{"doc:1": large, "doc:2": "small"} {
+					if value, err := store.Get([]byte(key)); err != nil || string(value) != want {
+						t.Errorf("%s: Get(%s) = %d bytes, %v; want %d bytes", phase, key, len(value), err, len(want))
+					}
+				}
+			}
+
+			store := open(codec.CompressionOptions{Algorithm: tc.algorithm})
+			if err := store.Put([]byte("doc:1"), []byte(large)); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+			if err := store.Put([]byte("doc:2"), []byte("small")); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+			check("written", store)
+
+			// The large value reached the log compressed
+			data, err := os.ReadFile(store.dataFile)
+			if err != nil {
+				t.Fatalf("Failed to read log: %v", err)
+			}
+			if len(data) >= len(large) {
+				t.Errorf("Expected a log smaller than the %d byte value, got %d bytes", len(large), len(data))
+			}
+			if err := store.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+
+			// Compressed records read back whatever the store now writes with
+			store = open(codec.CompressionOptions{})
+			check("reopened uncompressed", store)
+			if err := store.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+			store = open(codec.CompressionOptions{Algorithm: tc.algorithm})
+			defer store.Close()
+			check("reopened", store)
+		})
+	}
+
+	if _, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir(), Compression: codec.CompressionOptions{MinSize: -1}}); err == nil {
+		t.Error("Expected invalid compression options to be rejected")
+	}
+}
@@ -0,0 +1,41 @@
+package store
+
+import (
+	"bytes"
+	"hash/fnv"
+)
+
+// valueHash fingerprints a value for DedupeWrites. 0 is reserved for
+// entries whose hash is unknown, such as those rebuilt from the log.
+func valueHash(value []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(value) //nolint:errcheck // hash writes never fail
+	if sum := h.Sum64(); sum != 0 {
+		return sum
+	}
+	return 1
+}
+
+// unchangedInternal reports whether key already holds value, so the Put can
+// be skipped (caller must hold the mutex). A differing hash rules the value
+// out without touching the disk; a matching or unknown hash is confirmed
+// against the stored record, since a wrongly skipped write would lose data.
+func (kv *KVStore) unchangedInternal(key, value []byte, hash uint64) bool {
+	entry, ok := kv.index.Get(key)
+	if !ok || entry.ExpiresAt != 0 || (entry.ValueHash != 0 && entry.ValueHash != hash) {
+		return false
+	}
+
+	record, err := kv.readKeyInternal(key, entry)
+	if err != nil || !bytes.Equal(record.Value, value) {
+		return false
+	}
+
+	// Remember the hash so later rewrites of a different value skip the read
+	if entry.ValueHash == 0 {
+		updated := *entry
+		updated.ValueHash = hash
+		kv.index.Put(key, &updated)
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+package store
+
+import "testing"
+
+func TestKVStore_DedupeWrites(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	open := func(dedupe bool) *KVStore {
+		t.Helper()
+		store, err := NewKVStore(KVStoreConfig{DataDir: tmpDir, DedupeWrites: dedupe})
+		if err != nil {
+			t.Fatalf("Failed to create KV store: %v", err)
+		}
+		if _, err := store.Open(); err != nil {
+			t.Fatalf("Failed to open KV store: %v", err)
+		}
+		return store
+	}
+	put := func(store *KVStore, key, value string) {
+		t.Helper()
+		if err := store.Put([]byte(key), []byte(value)); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+
+	store := open(true)
+	put(store, "user:1", "alice")
+	size := store.Stats().DataSize
+
+	// Rewriting the same value appends nothing
+	put(store, "user:1", "alice")
+	put(store, "user:1", "alice")
+	stats := store.Stats()
+	if stats.DataSize != size || stats.DedupedWrites != 2 {
+		t.Errorf("Expected 2 skipped writes and no growth, got %d skipped and %d -> %d bytes",
+			stats.DedupedWrites, size, stats.DataSize)
+	}
+
+	// A changed value is written
+	put(store, "user:1", "bob")
+	if got, _ := store.Get([]byte("user:1")); string(got) != "bob" {
+		t.Errorf("Expected bob, got %q", got)
+	}
+	if store.Stats().DataSize == size {
+		t.Error("Expected the changed value to be appended")
+	}
+
+	// Writing a deleted key's old value is not a duplicate
+	if err := store.Delete([]byte("user:1")); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	put(store, "user:1", "bob")
+	if got, err := store.Get([]byte("user:1")); err != nil || string(got) != "bob" {
+		t.Errorf("Expected bob after rewrite, got %q, %v", got, err)
+	}
+	store.Close()
+
+	// After a restart hashes are unknown, so values are compared from disk
+	store = open(true)
+	size = store.Stats().DataSize
+	put(store, "user:1", "bob")
+	if stats := store.Stats(); stats.DataSize != size || stats.DedupedWrites != 1 {
+		t.Errorf("Expected the rewrite to be skipped after reopen, got %+v", stats)
+	}
+	store.Close()
+
+	// Dedupe is off by default
+	store = open(false)
+	defer store.Close()
+	size = store.Stats().DataSize
+	put(store, "user:1", "bob")
+	if stats := store.Stats(); stats.DataSize == size || stats.DedupedWrites != 0 {
+		t.Errorf("Expected the rewrite to be appended without dedupe, got %+v", stats)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !unix && !windows
+
+package store
+
+import "os"
+
+// tryLockFile can't lock files on this platform; stores are not protected
+// from each other
+func tryLockFile(*os.File) (bool, error) {
+	return true, nil
+}
+
+// unlockFile has nothing to release on this platform
+func unlockFile(*os.File) error {
+	return nil
+}
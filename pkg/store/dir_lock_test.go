@@ -0,0 +1,85 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestKVStore_DirLock(t *testing.T) {
+	dir := t.TempDir()
+	first, err := NewKVStore(KVStoreConfig{DataDir: dir})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := first.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer first.Close()
+
+	// A second writer is turned away rather than appending to the same log
+	second, err := NewKVStore(KVStoreConfig{DataDir: dir})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := second.Open(); !errors.Is(err, ErrDatabaseLocked) {
+		t.Fatalf("Expected ErrDatabaseLocked, got %v", err)
+	}
+
+	// A standby only reads, so it can follow the locked directory, but it
+	// can't be promoted while the primary holds it
+	standby, err := NewKVStore(KVStoreConfig{DataDir: dir, OpenMode: OpenStandby})
+	if err != nil {
+		t.Fatalf("Failed to create standby: %v", err)
+	}
+	if _, err := standby.Open(); err != nil {
+		t.Fatalf("Failed to open standby: %v", err)
+	}
+	defer standby.Close()
+	if err := standby.Promote(); !errors.Is(err, ErrDatabaseLocked) {
+		t.Fatalf("Expected promotion to fail with ErrDatabaseLocked, got %v", err)
+	}
+	if !standby.IsStandby() {
+		t.Fatal("Expected the failed promotion to leave a standby")
+	}
+
+	// With a timeout, Open waits for the holder to close
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		first.Close()
+	}()
+	waiting, err := NewKVStore(KVStoreConfig{DataDir: dir, LockTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	start := time.Now()
+	if _, err := waiting.Open(); err != nil {
+		t.Fatalf("Expected Open to get the lock once released, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("Expected Open to wait for the lock, took %v", elapsed)
+	}
+	if err := waiting.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+
+	// A timeout that runs out still fails
+	timedOut, err := NewKVStore(KVStoreConfig{DataDir: dir, LockTimeout: 30 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := timedOut.Open(); !errors.Is(err, ErrDatabaseLocked) {
+		t.Fatalf("Expected ErrDatabaseLocked after the timeout, got %v", err)
+	}
+
+	// Closing, or failing to open, releases the lock
+	if err := waiting.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	if err := standby.Promote(); err != nil {
+		t.Fatalf("Failed to promote once the directory is free: %v", err)
+	}
+	if value, err := standby.Get([]byte("key")); err != nil || string(value) != "value" {
+		t.Errorf("Expected the promoted store to see the last write, got %q, %v", value, err)
+	}
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"time"
+)
+
+// reencryptBatch is how many keys Reencrypt checks per hold of the mutex,
+// so writes aren't held up for the whole pass
+const reencryptBatch = 1000
+
+// ErrNoEncryption is returned by Reencrypt on a store without an
+// encryption provider
+var ErrNoEncryption = &KVError{"store has no encryption provider"}
+
+// Reencrypt rewrites the live values sealed with the key keyID, or stored
+// in the clear if keyID is empty, so they are sealed with the provider's
+// current key, and returns how many it rewrote. Rotate by making a new key
+// current, running Reencrypt with the old key's ID and then Compact, which
+// drops the old copies; after that no record names the old key and it can
+// be retired. Each rewrite is a new version of the key with the same value
+// and expiry. Keys written while it runs are already sealed with the
+// current key.
+func (kv *KVStore) Reencrypt(keyID string) (int, error) {
+	if kv.config.Encryption == nil {
+		return 0, ErrNoEncryption
+	}
+
+	kv.mutex.Lock()
+	if err := kv.checkOpenInternal(); err != nil {
+		kv.mutex.Unlock()
+		return 0, err
+	}
+	keys := kv.index.Keys()
+	kv.mutex.Unlock()
+
+	var rewritten int
+	for start := 0; start < len(keys); start += reencryptBatch {
+		batch := keys[start:min(start+reencryptBatch, len(keys))]
+		err := kv.commit(func() error {
+			if err := kv.checkOpenInternal(); err != nil {
+				return err
+			}
+			now := time.Now()
+			for _, key := range batch {
+				entry, ok := kv.index.Get([]byte(key))
+				if !ok || entry.expired(now) {
+					continue
+				}
+				record, err := kv.readKeyInternal([]byte(key), entry)
+				if err != nil {
+					return err
+				}
+				if len(record.Value) == 0 || record.KeyID != keyID {
+					continue
+				}
+				if err := kv.appendInternal([]byte(key), record.Value, entry.ExpiresAt, entry.ValueHash); err != nil {
+					return err
+				}
+				rewritten++
+			}
+			return nil
+		})
+		if err != nil {
+			return rewritten, err
+		}
+	}
+	return rewritten, nil
+}
@@ -0,0 +1,133 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ssargent/freyjadb/pkg/codec"
+)
+
+func TestKVStore_Encryption(t *testing.T) {
+	tmpDir := t.TempDir()
+	keyring, err := codec.NewKeyring("k1", bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("NewKeyring failed: %v", err)
+	}
+
+	open := func(provider codec.EncryptionProvider) (*KVStore, error) {
+		t.Helper()
+		store, err := NewKVStore(KVStoreConfig{DataDir: tmpDir, Encryption: provider})
+		if err != nil {
+			t.Fatalf("Failed to create KV store: %v", err)
+		}
+		_, err = store.Open()
+		return store, err
+	}
+	get := func(store *KVStore, key, want string) {
+		t.Helper()
+		value, err := store.Get([]byte(key))
+		if err != nil || string(value) != want {
+			t.Errorf("Get(%s) = %q, %v; want %q", key, value, err, want)
+		}
+	}
+
+	// Values written before encryption stay readable
+	store, err := open(nil)
+	if err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	if err := store.Put([]byte("user:0"), []byte("plain secret")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	store.Close()
+
+	store, err = open(keyring)
+	if err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	if err := store.Put([]byte("user:1"), []byte("alice secret")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := store.PutStream([]byte("user:2"), 10, bytes.NewReader([]byte("bob secret"))); err != nil {
+		t.Fatalf("PutStream failed: %v", err)
+	}
+	get(store, "user:0", "plain secret")
+	get(store, "user:1", "alice secret")
+	get(store, "user:2", "bob secret")
+
+	// Sealing the old values leaves no plaintext once compacted
+	if n, err := store.Reencrypt(""); err != nil || n != 1 {
+		t.Fatalf("Reencrypt(\"\") = %d, %v; want 1", n, err)
+	}
+	if _, err := store.Compact(CompactOptions{}); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	get(store, "user:0", "plain secret")
+	files, _ := filepath.Glob(filepath.Join(tmpDir, "*.data"))
+	for _, file := range files {
+		data, _ := os.ReadFile(file)
+		if bytes.Contains(data, []byte("secret")) {
+			t.Errorf("Expected no plaintext values in %s", file)
+		}
+	}
+
+	// Rotate: new values use the new key, and the old key can be retired
+	// once its records are rewritten and compacted away
+	if err := keyring.Add("k2", bytes.Repeat([]byte{2}, 32)); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := keyring.Use("k2"); err != nil {
+		t.Fatalf("Use failed: %v", err)
+	}
+	if err := store.Put([]byte("user:3"), []byte("carol secret")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if n, err := store.Reencrypt("k1"); err != nil || n != 3 {
+		t.Fatalf("Reencrypt(k1) = %d, %v; want 3", n, err)
+	}
+	if _, err := store.Compact(CompactOptions{}); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := keyring.Remove("k1"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+
+	store, err = open(keyring)
+	if err != nil {
+		t.Fatalf("Failed to reopen with the rotated keyring: %v", err)
+	}
+	for key, want := range map[string]string{"user:0": "plain secret", "user:1": "alice secret",
+		"user:2": "bob secret", "user:3": "carol secret"} {
+		get(store, key, want)
+	}
+	if err := store.Put([]byte("user:4"), []byte("dave secret")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	store.Close()
+
+	// Without the key the store doesn't open, and its log isn't truncated
+	// as if the sealed records were corrupt
+	logFile := filepath.Join(tmpDir, activeDataFile)
+	before, _ := os.Stat(logFile)
+	if _, err := open(nil); !errors.Is(err, codec.ErrDecrypt) {
+		t.Fatalf("Expected opening without the key to fail with ErrDecrypt, got %v", err)
+	}
+	after, _ := os.Stat(logFile)
+	if before.Size() != after.Size() {
+		t.Errorf("Expected the log to be left alone, got %d -> %d bytes", before.Size(), after.Size())
+	}
+
+	plain, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := plain.Reencrypt(""); !errors.Is(err, ErrNoEncryption) {
+		t.Errorf("Expected ErrNoEncryption, got %v", err)
+	}
+}
@@ -0,0 +1,217 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/keys"
+)
+
+// Explain limits, so a large store produces a bounded report
+const (
+	compactionReadyDeadPct = 20.0 // Segments with more dead bytes than this are worth compacting
+	maxExplainPartitions   = 50   // Largest partitions reported
+	maxExplainSKRanges     = 20   // Largest sort key ranges reported per partition
+	maxSampleValueBytes    = 64   // Sample values are truncated to this
+)
+
+// indexEntryOverhead estimates the memory a hash index entry uses beyond its
+// key suffix: the IndexEntry itself, the pointer to it, the suffix string
+// header and the map slot holding them
+const indexEntryOverhead = 40 + 8 + 16 + 24
+
+// Explain gathers diagnostic information about the store. Every field is
+// computed from the live index and segment files; see ExplainSchemaVersion
+// for the stability guarantees of the result.
+func (kv *KVStore) Explain(ctx context.Context, opts ExplainOptions) (*ExplainResult, error) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if err := kv.checkOpenInternal(); err != nil {
+		return nil, err
+	}
+	if err := kv.ensureIndexInternal(); err != nil {
+		return nil, err
+	}
+
+	stats := kv.index.Stats()
+	res := NewExplainResult(ctx)
+	res.Global.ActiveKeys = stats.TotalKeys
+	res.Global.Tombstones = stats.Tombstones
+	res.Global.TotalKeys = stats.TotalKeys + stats.Tombstones
+	res.Global.LiveSizeMB = toMB(stats.LiveBytes)
+	res.Global.UserKeys = stats.TotalKeys - stats.InternalKeys
+	res.Global.InternalKeys = stats.InternalKeys
+	res.Global.UserSizeMB = toMB(stats.LiveBytes - stats.InternalBytes)
+	res.Global.InternalSizeMB = toMB(stats.InternalBytes)
+	res.Global.TombstoneSizeMB = toMB(stats.TombstoneBytes)
+	res.Global.IndexMemoryMB = toMB(stats.CompressedKeyBytes + int64(stats.TotalKeys)*indexEntryOverhead)
+	res.Global.Uptime = time.Since(kv.openedAt)
+
+	var totalBytes int64
+	segments, warnings := kv.segmentStatsInternal()
+	res.Warnings = append(res.Warnings, warnings...)
+	for _, seg := range segments {
+		totalBytes += seg.Size
+		segment := Segment{ID: segmentID(seg.FileID), Keys: seg.Keys, DeadPct: seg.deadPct(), SizeMB: toMB(seg.Size)}
+		res.Segments = append(res.Segments, segment)
+		if segment.DeadPct > compactionReadyDeadPct {
+			res.Diagnostics.CompactionReady = append(res.Diagnostics.CompactionReady, segment.ID)
+		}
+	}
+	res.Global.TotalSizeMB = toMB(totalBytes)
+
+	sorted := kv.index.Keys()
+	sort.Strings(sorted)
+	res.Partitions = explainPartitions(sorted, opts.PK)
+	if opts.PK != "" {
+		if _, ok := res.Partitions[opts.PK]; !ok {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("No data for PK: %s", opts.PK))
+		}
+	}
+
+	if opts.WithSamples > 0 {
+		res.Diagnostics.Samples = kv.sampleInternal(sorted, opts.WithSamples)
+	}
+
+	res.Diagnostics.CRCErrors = int(kv.crcErrors + kv.readRepairFailures)
+	res.Diagnostics.WritePhases = kv.writeStalls.stats()
+	res.Diagnostics.Expirations = *kv.index.expiryHistogram(time.Now())
+	res.Advisory = kv.advisoryInternal(time.Now())
+
+	if opts.WithMetrics {
+		if kv.gets > 0 {
+			res.Diagnostics.Metrics.AvgGetLatencyMs = float64(kv.getNanos) / float64(kv.gets) / 1e6
+		}
+		if seconds := res.Global.Uptime.Seconds(); seconds > 0 {
+			written := max(kv.writer.Size()-kv.openSize, 0)
+			res.Diagnostics.Metrics.IORateMBs = toMB(written+kv.bytesRead) / seconds
+		}
+	}
+
+	return res, nil
+}
+
+// sampleInternal reads n keys spread evenly across the sorted key list
+// (caller must hold the mutex)
+func (kv *KVStore) sampleInternal(sorted []string, n int) []Sample {
+	samples := make([]Sample, 0, min(n, len(sorted)))
+	if len(sorted) == 0 {
+		return samples
+	}
+
+	step := max(len(sorted)/n, 1)
+	for i := 0; i < len(sorted) && len(samples) < n; i += step {
+		entry, ok := kv.index.Get([]byte(sorted[i]))
+		if !ok {
+			continue
+		}
+		record, err := kv.readKeyInternal([]byte(sorted[i]), entry)
+		if err != nil {
+			continue
+		}
+
+		value := record.Value
+		if len(value) > maxSampleValueBytes {
+			value = value[:maxSampleValueBytes]
+		}
+		samples = append(samples, Sample{
+			Key:   sorted[i],
+			Value: string(value),
+			Ts:    time.Unix(0, int64(record.Timestamp)).UTC(), //nolint:gosec // nanosecond timestamps fit in int64
+		})
+	}
+	return samples
+}
+
+// explainPartitions groups sorted keys into partitions by their first
+// component (see pkg/keys). Within a partition, keys with at least three
+// components are grouped into sort key ranges by their second component.
+// Only the largest partitions are reported, or just pk when it is set.
+func explainPartitions(sorted []string, pk string) map[string]PKStats {
+	type partition struct {
+		keys   int
+		ranges map[string]*SKRange
+	}
+
+	parts := make(map[string]*partition)
+	for _, key := range sorted {
+		components := strings.SplitN(key, keys.Separator, 3)
+		if len(components) < 2 || (pk != "" && components[0] != pk) {
+			continue
+		}
+
+		p, ok := parts[components[0]]
+		if !ok {
+			p = &partition{ranges: make(map[string]*SKRange)}
+			parts[components[0]] = p
+		}
+		p.keys++
+		if len(components) < 3 {
+			continue
+		}
+
+		r, ok := p.ranges[components[1]]
+		if !ok {
+			r = &SKRange{Name: components[1], Min: key}
+			p.ranges[components[1]] = r
+		}
+		r.Count++
+		r.Max = key // Keys arrive sorted
+	}
+
+	names := make([]string, 0, len(parts))
+	for name := range parts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if parts[names[i]].keys != parts[names[j]].keys {
+			return parts[names[i]].keys > parts[names[j]].keys
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > maxExplainPartitions {
+		names = names[:maxExplainPartitions]
+	}
+
+	result := make(map[string]PKStats, len(names))
+	for _, name := range names {
+		p := parts[name]
+		ranges := make([]SKRange, 0, len(p.ranges))
+		for _, r := range p.ranges {
+			ranges = append(ranges, *r)
+		}
+		sort.Slice(ranges, func(i, j int) bool {
+			if ranges[i].Count != ranges[j].Count {
+				return ranges[i].Count > ranges[j].Count
+			}
+			return ranges[i].Name < ranges[j].Name
+		})
+		if len(ranges) > maxExplainSKRanges {
+			ranges = ranges[:maxExplainSKRanges]
+		}
+
+		cardinality := "1:N"
+		if p.keys == 1 {
+			cardinality = "1:1"
+		}
+		result[name] = PKStats{Keys: p.keys, SKRanges: ranges, Cardinality: cardinality}
+	}
+	return result
+}
+
+// segmentID names a segment in explain output
+func segmentID(fileID uint32) string {
+	if fileID == activeFileID {
+		return "active"
+	}
+	return fmt.Sprintf("%03d", fileID)
+}
+
+// toMB converts bytes to mebibytes
+func toMB(bytes int64) float64 {
+	return float64(bytes) / (1024 * 1024)
+}
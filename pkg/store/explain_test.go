@@ -0,0 +1,186 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKVStore_Explain(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	open := func() *KVStore {
+		t.Helper()
+		store, err := NewKVStore(KVStoreConfig{DataDir: tmpDir})
+		if err != nil {
+			t.Fatalf("Failed to create KV store: %v", err)
+		}
+		if _, err := store.Open(); err != nil {
+			t.Fatalf("Failed to open KV store: %v", err)
+		}
+		return store
+	}
+	store := open()
+
+	for i := 0; i < 4; i++ {
+		if err := store.Put([]byte(fmt.Sprintf("user:%d:profile", i)), []byte(strings.Repeat("x", 100))); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	for _, key := range []string{"order:1", "order:2", "loose"} {
+		if err := store.Put([]byte(key), []byte("v")); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	if err := store.Delete([]byte("order:2")); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if _, err := store.Get([]byte("user:1:profile")); err != nil {
+		t.Fatalf("Failed to get: %v", err)
+	}
+
+	res, err := store.Explain(WithRequestID(context.Background(), "req-1"),
+		ExplainOptions{WithSamples: 2, WithMetrics: true})
+	if err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+
+	if res.SchemaVersion != ExplainSchemaVersion || res.GeneratedAt.IsZero() || res.RequestID != "req-1" {
+		t.Errorf("Unexpected header: version=%d generated=%v request=%q",
+			res.SchemaVersion, res.GeneratedAt, res.RequestID)
+	}
+	if res.Global.ActiveKeys != 6 || res.Global.Tombstones != 1 || res.Global.TotalKeys != 7 {
+		t.Errorf("Unexpected key counts: %+v", res.Global)
+	}
+	if res.Global.LiveSizeMB <= 0 || res.Global.LiveSizeMB >= res.Global.TotalSizeMB || res.Global.IndexMemoryMB <= 0 {
+		t.Errorf("Unexpected sizes: %+v", res.Global)
+	}
+	if len(res.Segments) != 1 || res.Segments[0].ID != "active" || res.Segments[0].Keys != 6 ||
+		res.Segments[0].DeadPct <= 0 {
+		t.Errorf("Unexpected segments: %+v", res.Segments)
+	}
+
+	users := res.Partitions["user"]
+	if users.Keys != 4 || len(users.SKRanges) != 4 || users.Cardinality != "1:N" {
+		t.Errorf("Unexpected user partition: %+v", users)
+	}
+	if orders := res.Partitions["order"]; orders.Keys != 1 || orders.Cardinality != "1:1" {
+		t.Errorf("Unexpected order partition: %+v", orders)
+	}
+	if _, ok := res.Partitions["loose"]; ok {
+		t.Error("Keys without a separator should not form a partition")
+	}
+
+	if len(res.Diagnostics.Samples) != 2 || len(res.Diagnostics.Samples[0].Value) > maxSampleValueBytes ||
+		res.Diagnostics.Samples[0].Ts.IsZero() {
+		t.Errorf("Unexpected samples: %+v", res.Diagnostics.Samples)
+	}
+	if res.Diagnostics.Metrics.AvgGetLatencyMs <= 0 || res.Diagnostics.Metrics.IORateMBs <= 0 {
+		t.Errorf("Expected metrics, got %+v", res.Diagnostics.Metrics)
+	}
+
+	// Partition filtering reports only the requested partition
+	res, err = store.Explain(context.Background(), ExplainOptions{PK: "user"})
+	if err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+	if len(res.Partitions) != 1 || len(res.Warnings) != 0 {
+		t.Errorf("Expected only the user partition, got %+v (warnings %v)", res.Partitions, res.Warnings)
+	}
+	res, err = store.Explain(context.Background(), ExplainOptions{PK: "missing"})
+	if err != nil || len(res.Warnings) != 1 {
+		t.Errorf("Expected a warning for a missing partition, got %v, %v", res.Warnings, err)
+	}
+
+	// Tombstones are recounted from the log on reopen
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	store = open()
+	defer store.Close()
+	if res, err = store.Explain(context.Background(), ExplainOptions{}); err != nil || res.Global.Tombstones != 1 {
+		t.Errorf("Expected 1 tombstone after reopen, got %+v, %v", res, err)
+	}
+}
+
+func TestKVStore_ExplainAdvisory(t *testing.T) {
+	store, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	for i := 0; i < 20; i++ {
+		if err := store.Put([]byte(fmt.Sprintf("session:%d", i)), []byte("token")); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+		if err := store.Put([]byte(fmt.Sprintf("doc:%d", i)), []byte(strings.Repeat("d", 4096))); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+
+	// Too few reads to judge
+	res, err := store.Explain(context.Background(), ExplainOptions{})
+	if err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+	if len(res.Advisory.HotPrefixes) != 0 || res.Advisory.SuggestedCacheMB != 0 {
+		t.Errorf("Expected an empty advisory, got %+v", res.Advisory)
+	}
+
+	// Sessions take most reads and a sliver of the bytes
+	for i := 0; i < 90; i++ {
+		if _, err := store.Get([]byte(fmt.Sprintf("session:%d", i%20))); err != nil {
+			t.Fatalf("Failed to get: %v", err)
+		}
+	}
+	if _, err := store.GetMany([][]byte{[]byte("doc:1"), []byte("doc:2"), []byte("missing:1")}); err != nil {
+		t.Fatalf("GetMany failed: %v", err)
+	}
+	if res, err = store.Explain(context.Background(), ExplainOptions{}); err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+	advisory := res.Advisory
+	if len(advisory.HotPrefixes) != 1 || advisory.HotPrefixes[0].Prefix != "session:" ||
+		advisory.HotPrefixes[0].Keys != 20 || advisory.HotPrefixes[0].ReadsPerSec <= 0 {
+		t.Fatalf("Expected session: to be hot, got %+v", advisory.HotPrefixes)
+	}
+	sessions := store.PrefixStats([]byte("session:"))
+	if advisory.SuggestedCacheMB != toMB(sessions.Bytes) {
+		t.Errorf("Expected a cache of the session bytes, %v MB, got %v", toMB(sessions.Bytes), advisory.SuggestedCacheMB)
+	}
+	if len(advisory.Recommendations) != 1 || !strings.Contains(advisory.Recommendations[0], "session:") {
+		t.Errorf("Expected a cache recommendation, got %q", advisory.Recommendations)
+	}
+
+	// Reads decay, so an hour later the counts are too small to judge
+	if advisory = store.advisoryInternal(time.Now().Add(time.Hour)); len(advisory.HotPrefixes) != 0 {
+		t.Errorf("Expected old reads to decay, got %+v", advisory)
+	}
+}
+
+func TestAccessCounter_Decay(t *testing.T) {
+	now := time.Now()
+	var stats accessStats
+	for i := 0; i < 8; i++ {
+		stats.record([]byte("user:1"), ':', now)
+	}
+	stats.record([]byte("loose"), ':', now)
+	if len(stats.prefixes) != 1 {
+		t.Fatalf("Expected only user: to be tracked, got %v", stats.prefixes)
+	}
+	c := stats.prefixes["user:"]
+	if got := c.decayed(now.Add(accessHalfLife)); math.Abs(got-4) > 1e-9 {
+		t.Errorf("Expected 4 reads after a half-life, got %v", got)
+	}
+	stats.record([]byte("user:2"), ':', now.Add(2*accessHalfLife))
+	if math.Abs(c.reads-3) > 1e-9 {
+		t.Errorf("Expected 2 decayed reads plus 1, got %v", c.reads)
+	}
+}
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// finalizeFile creates the file at path from what write produces. The
+// contents go to a temp file next to path, which is fsynced, renamed into
+// place and made durable by fsyncing the directory. Until the rename the
+// file only exists under its temp name, which Open deletes after a crash,
+// so a partially written file can never be taken for live data. On error
+// the temp file is removed and path is left untouched.
+func finalizeFile(path string, write func(w io.Writer) error) error {
+	tmp := path + tempSuffix
+	file, err := os.OpenFile(filepath.Clean(tmp), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = func() error {
+		writer := bufio.NewWriterSize(file, 64*1024)
+		if err := write(writer); err != nil {
+			return err
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		return file.Sync()
+	}()
+	if err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir fsyncs a directory so renames and creations in it are durable
+func syncDir(dir string) error {
+	d, err := os.Open(filepath.Clean(dir))
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
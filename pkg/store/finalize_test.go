@@ -0,0 +1,83 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFinalizeFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, segmentFileName(1))
+
+	write := func(data string) func(io.Writer) error {
+		return func(w io.Writer) error {
+			_, err := io.WriteString(w, data)
+			return err
+		}
+	}
+
+	if err := finalizeFile(path, write("first")); err != nil {
+		t.Fatalf("Failed to finalize file: %v", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "first" {
+		t.Fatalf("Expected finalized contents %q, got %q, %v", "first", data, err)
+	}
+
+	// A write that fails part way leaves the previous file and no temp file
+	failure := errors.New("disk on fire")
+	err := finalizeFile(path, func(w io.Writer) error {
+		if _, err := io.WriteString(w, "partial"); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("Expected write error, got %v", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "first" {
+		t.Errorf("Expected failed write to leave %q, got %q, %v", "first", data, err)
+	}
+	if _, err := os.Stat(path + tempSuffix); !os.IsNotExist(err) {
+		t.Errorf("Expected temp file to be removed, got %v", err)
+	}
+
+	// A temp file left by a crash mid-write is recognised as stale
+	if !isStaleTempFile(filepath.Base(path + tempSuffix)) {
+		t.Errorf("Expected %s to be treated as a stale temp file", path+tempSuffix)
+	}
+}
+
+func TestLinkOrCopyFile(t *testing.T) {
+	src := filepath.Join(t.TempDir(), segmentFileName(1))
+	dst := filepath.Join(t.TempDir(), segmentFileName(1))
+	if err := os.WriteFile(src, []byte("segment"), 0600); err != nil {
+		t.Fatalf("Failed to create segment: %v", err)
+	}
+	// A copy left by an earlier, interrupted archive is replaced
+	if err := os.WriteFile(dst, []byte("stale"), 0600); err != nil {
+		t.Fatalf("Failed to create stale copy: %v", err)
+	}
+
+	if err := linkOrCopyFile(src, dst); err != nil {
+		t.Fatalf("Failed to copy segment: %v", err)
+	}
+	if data, err := os.ReadFile(src); err != nil || string(data) != "segment" {
+		t.Errorf("Expected source left in place, got %q, %v", data, err)
+	}
+	if data, err := os.ReadFile(dst); err != nil || string(data) != "segment" {
+		t.Errorf("Expected copied contents %q, got %q, %v", "segment", data, err)
+	}
+	if _, err := os.Stat(dst + tempSuffix); !os.IsNotExist(err) {
+		t.Errorf("Expected no temp file, got %v", err)
+	}
+
+	if err := removeFile(src); err != nil {
+		t.Fatalf("Failed to remove source: %v", err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("Expected source to be gone, got %v", err)
+	}
+}
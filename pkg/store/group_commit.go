@@ -0,0 +1,52 @@
+package store
+
+// commit runs a write under the store lock. With group commit it then waits
+// for the write's fsync after releasing the lock, so writers arriving in the
+// meantime can append and share the same fsync.
+func (kv *KVStore) commit(write func() error) error {
+	timing := newWriteTiming()
+	done := kv.io.foreground()
+	defer func() {
+		done()
+		kv.stats.Observe(StatWriteSeconds, timing.mark.Sub(timing.start).Seconds())
+		kv.finishWrite(timing)
+	}()
+
+	kv.writeGate.RLock()
+	defer kv.writeGate.RUnlock()
+	kv.mutex.Lock()
+	timing.lap(phaseLockWait)
+	kv.writeTiming = timing
+	err := write()
+	if err == nil {
+		kv.maybeRotateInternal()
+	}
+	timing.lap(phaseIndex)
+	kv.writeTiming = nil
+	var writer *LogWriter
+	var end int64
+	if err == nil && kv.writer != nil && kv.config.GroupCommitWindow > 0 {
+		writer, end = kv.writer, kv.writer.Size()
+	}
+	kv.mutex.Unlock()
+
+	if writer == nil {
+		return err
+	}
+	err = writer.WaitDurable(end)
+	timing.lap(phaseFsync)
+	return err
+}
+
+// finishWrite adds a write's phases to the Explain aggregates and reports
+// it if it was slow
+func (kv *KVStore) finishWrite(timing *writeTiming) {
+	if !kv.writeStalls.add(timing, kv.config.SlowWriteThreshold) {
+		return
+	}
+	report := kv.config.OnSlowWrite
+	if report == nil {
+		report = logSlowWrite
+	}
+	report(timing.slowWrite())
+}
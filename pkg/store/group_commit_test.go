@@ -0,0 +1,61 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKVStore_GroupCommit(t *testing.T) {
+	tmpDir := t.TempDir()
+	store, err := NewKVStore(KVStoreConfig{DataDir: tmpDir, GroupCommitWindow: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	before := store.Stats().Fsyncs
+
+	// Concurrent writers share fsyncs instead of paying for one each
+	const writers = 32
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := store.Put([]byte(fmt.Sprintf("key:%d", i)), []byte("value")); err != nil {
+				t.Errorf("Put failed: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	fsyncs := store.Stats().Fsyncs - before
+	if fsyncs < 1 || fsyncs >= writers {
+		t.Errorf("Expected the %d writes to share fsyncs, got %d", writers, fsyncs)
+	}
+
+	// Every acknowledged write was durable when Put returned
+	if writer := store.writer; writer.Size() != writer.durable {
+		t.Errorf("Expected the log to be durable up to %d, got %d", writer.Size(), writer.durable)
+	}
+
+	if err := store.Delete([]byte("key:0")); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	store.Close()
+
+	reopened, err := NewKVStore(KVStoreConfig{DataDir: tmpDir})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := reopened.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer reopened.Close()
+	if n := reopened.Stats().Keys; n != writers-1 {
+		t.Errorf("Expected %d keys after reopen, got %d", writers-1, n)
+	}
+}
@@ -1,20 +1,258 @@
 package store
 
 import (
+	"bytes"
+	"context"
+	"math"
+	"slices"
 	"strings"
 	"sync"
+
+	"github.com/ssargent/freyjadb/pkg/bptree"
+	"github.com/ssargent/freyjadb/pkg/codec"
+	"github.com/ssargent/freyjadb/pkg/keys"
 )
 
-// HashIndex provides O(1) average-case lookups for key locations
+// defaultPrefixDelimiter splits keys into an interned prefix and a suffix
+const defaultPrefixDelimiter = ':'
+
+// DefaultInternalKeyspaces hold FreyjaDB's own bookkeeping rather than user
+// data, and are counted separately in the stats
+var DefaultInternalKeyspaces = []keys.Keyspace{"relationship", "apikey", "config"}
+
+// HashIndex provides O(1) average-case lookups for key locations.
+//
+// Keys are stored prefix-compressed: everything up to and including the last
+// delimiter is interned once in a prefix table and each key only keeps its
+// suffix. A prefix is released once its last key is deleted and its ID
+// reused, so the table tracks the live keys rather than every key written. Keyspaces such as "relationship:forward:..." therefore pay for
+// their shared prefix once instead of once per key. The prefixes also form
+// a tree, one level per key component, so a prefix search visits only the
+// buckets under the searched prefix.
 type HashIndex struct {
-	entries map[string]*IndexEntry
-	mutex   sync.RWMutex
+	entries    map[uint32]map[string]*IndexEntry // prefix ID -> suffix -> entry
+	prefixIDs  map[string]uint32                 // interned prefix -> prefix ID
+	prefixes   []string                          // prefix ID -> interned prefix, "" once released
+	freeIDs    []uint32                          // Released prefix IDs, reused before new ones
+	counters   []prefixCounters                  // prefix ID -> keys directly under the prefix
+	children   map[string][]string               // prefix -> prefixes one component longer
+	delimiter  byte
+	internal   []keys.Keyspace // Keyspaces counted as internal keys
+	size       int
+	keyBytes   int64             // Sum of full key lengths (uncompressed footprint)
+	sfxBytes   int64             // Sum of stored suffix lengths
+	liveBytes  int64             // Sum of record sizes referenced by the index
+	tombstones int               // Tombstone records in the log
+	ranges     []RangeTombstone  // Range tombstones applied to the index, oldest first
+	sequences  map[string]uint64 // Sequence name -> highest reserved ID
+	ordered    *bptree.BPlusTree // Every key in order, once Range first needs it
+	mutex      sync.RWMutex
+
+	internalKeys   int   // Of size, keys in an internal keyspace
+	internalBytes  int64 // Of liveBytes, records of internal keys
+	tombstoneBytes int64 // Bytes of the tombstone records in the log
 }
 
 // NewHashIndex creates a new hash index
 func NewHashIndex(config HashIndexConfig) *HashIndex {
-	return &HashIndex{
-		entries: make(map[string]*IndexEntry),
+	delimiter := config.PrefixDelimiter
+	if delimiter == 0 {
+		delimiter = defaultPrefixDelimiter
+	}
+
+	internal := config.InternalKeyspaces
+	if internal == nil {
+		internal = DefaultInternalKeyspaces
+	}
+
+	idx := &HashIndex{delimiter: delimiter, internal: internal}
+	idx.reset()
+	return idx
+}
+
+// reset clears all entries and the prefix table (caller must hold the lock)
+func (idx *HashIndex) reset() {
+	idx.entries = make(map[uint32]map[string]*IndexEntry)
+	idx.prefixIDs = make(map[string]uint32)
+	idx.prefixes = nil
+	idx.freeIDs = nil
+	idx.counters = nil
+	idx.children = make(map[string][]string)
+	idx.size = 0
+	idx.keyBytes = 0
+	idx.sfxBytes = 0
+	idx.liveBytes = 0
+	idx.tombstones = 0
+	idx.internalKeys = 0
+	idx.internalBytes = 0
+	idx.tombstoneBytes = 0
+	idx.ranges = nil
+	idx.sequences = make(map[string]uint64)
+	idx.ordered = nil
+}
+
+// splitKey divides a key into its prefix (through the last delimiter) and suffix
+func (idx *HashIndex) splitKey(key string) (string, string) {
+	i := strings.LastIndexByte(key, idx.delimiter)
+	return key[:i+1], key[i+1:]
+}
+
+// isInternal reports whether key belongs to an internal keyspace
+func (idx *HashIndex) isInternal(key string) bool {
+	for _, ks := range idx.internal {
+		if ks.Contains(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupPrefix returns the ID of an interned prefix (caller must hold a lock)
+func (idx *HashIndex) lookupPrefix(prefix string) (uint32, bool) {
+	id, ok := idx.prefixIDs[prefix]
+	return id, ok
+}
+
+// internPrefix returns the ID for prefix, adding it to the table if needed
+func (idx *HashIndex) internPrefix(prefix string) uint32 {
+	if id, ok := idx.prefixIDs[prefix]; ok {
+		return id
+	}
+	// Clone so the table does not pin the caller's (possibly larger) buffer
+	prefix = strings.Clone(prefix)
+	var id uint32
+	if n := len(idx.freeIDs); n > 0 {
+		id = idx.freeIDs[n-1]
+		idx.freeIDs = idx.freeIDs[:n-1]
+		idx.prefixes[id] = prefix
+	} else {
+		id = uint32(len(idx.prefixes)) //nolint:gosec // IDs are reused, so the table is bounded by the live keys
+		idx.prefixes = append(idx.prefixes, prefix)
+		idx.counters = append(idx.counters, prefixCounters{})
+	}
+	idx.prefixIDs[prefix] = id
+	idx.linkPrefix(prefix)
+	return id
+}
+
+// releasePrefix drops the prefix of an emptied bucket from the table and
+// the prefix tree, keeping its ID for reuse (caller must hold the write lock)
+func (idx *HashIndex) releasePrefix(id uint32) {
+	prefix := idx.prefixes[id]
+	delete(idx.entries, id)
+	delete(idx.prefixIDs, prefix)
+	idx.prefixes[id] = ""
+	idx.counters[id] = prefixCounters{}
+	idx.freeIDs = append(idx.freeIDs, id)
+	idx.unlinkPrefix(prefix)
+}
+
+// parentPrefix strips the last component from a prefix ending in the
+// delimiter: "a:b:" -> "a:", "a:" -> ""
+func (idx *HashIndex) parentPrefix(prefix string) string {
+	return prefix[:strings.LastIndexByte(prefix[:len(prefix)-1], idx.delimiter)+1]
+}
+
+// linkPrefix adds prefix and any missing ancestors to the prefix tree
+// (caller must hold the write lock)
+func (idx *HashIndex) linkPrefix(prefix string) {
+	if _, linked := idx.children[prefix]; linked || prefix == "" {
+		return
+	}
+	parent := idx.parentPrefix(prefix)
+	idx.linkPrefix(parent)
+	idx.children[prefix] = nil
+	idx.children[parent] = append(idx.children[parent], prefix)
+}
+
+// unlinkPrefix removes prefix from the prefix tree if it is neither interned
+// nor has children, then does the same for each ancestor it leaves bare
+// (caller must hold the write lock)
+func (idx *HashIndex) unlinkPrefix(prefix string) {
+	for prefix != "" {
+		if _, interned := idx.prefixIDs[prefix]; interned || len(idx.children[prefix]) > 0 {
+			return
+		}
+		delete(idx.children, prefix)
+		parent := idx.parentPrefix(prefix)
+		siblings := idx.children[parent]
+		if i := slices.Index(siblings, prefix); i >= 0 {
+			idx.children[parent] = slices.Delete(siblings, i, i+1)
+		}
+		prefix = parent
+	}
+}
+
+// putInternal adds or updates an entry (caller must hold the write lock)
+func (idx *HashIndex) putInternal(key string, entry *IndexEntry) {
+	prefix, suffix := idx.splitKey(key)
+	id := idx.internPrefix(prefix)
+
+	bucket, ok := idx.entries[id]
+	if !ok {
+		bucket = make(map[string]*IndexEntry)
+		idx.entries[id] = bucket
+	}
+
+	internal := idx.isInternal(key)
+	counters := &idx.counters[id]
+	if old, exists := bucket[suffix]; !exists {
+		if idx.ordered != nil {
+			idx.ordered.Insert([]byte(key), nil)
+		}
+		idx.size++
+		idx.keyBytes += int64(len(key))
+		idx.sfxBytes += int64(len(suffix))
+		if internal {
+			idx.internalKeys++
+		}
+	} else {
+		counters.remove(key, old)
+		idx.liveBytes -= int64(old.Size)
+		if internal {
+			idx.internalBytes -= int64(old.Size)
+		}
+	}
+	counters.add(key, entry)
+	idx.liveBytes += int64(entry.Size)
+	if internal {
+		idx.internalBytes += int64(entry.Size)
+	}
+	bucket[suffix] = entry
+}
+
+// deleteInternal removes an entry. A nonzero timestamp is the time of the
+// tombstone that deleted it, counted as a write under its prefix. (caller
+// must hold the write lock)
+func (idx *HashIndex) deleteInternal(key string, timestamp uint64) {
+	prefix, suffix := idx.splitKey(key)
+	id, ok := idx.lookupPrefix(prefix)
+	if !ok {
+		return
+	}
+	idx.counters[id].touch(timestamp)
+
+	bucket := idx.entries[id]
+	old, exists := bucket[suffix]
+	if !exists {
+		return
+	}
+
+	idx.counters[id].remove(key, old)
+	delete(bucket, suffix)
+	if idx.ordered != nil {
+		idx.ordered.Delete([]byte(key))
+	}
+	idx.size--
+	idx.liveBytes -= int64(old.Size)
+	idx.keyBytes -= int64(len(key))
+	idx.sfxBytes -= int64(len(suffix))
+	if idx.isInternal(key) {
+		idx.internalKeys--
+		idx.internalBytes -= int64(old.Size)
+	}
+	if len(bucket) == 0 {
+		idx.releasePrefix(id)
 	}
 }
 
@@ -23,8 +261,7 @@ func (idx *HashIndex) Put(key []byte, entry *IndexEntry) {
 	idx.mutex.Lock()
 	defer idx.mutex.Unlock()
 
-	keyStr := string(key)
-	idx.entries[keyStr] = entry
+	idx.putInternal(string(key), entry)
 }
 
 // Get retrieves the index entry for a key
@@ -32,8 +269,18 @@ func (idx *HashIndex) Get(key []byte) (*IndexEntry, bool) {
 	idx.mutex.RLock()
 	defer idx.mutex.RUnlock()
 
-	keyStr := string(key)
-	entry, exists := idx.entries[keyStr]
+	return idx.getInternal(string(key))
+}
+
+// getInternal looks up the entry for a key (caller must hold a lock)
+func (idx *HashIndex) getInternal(key string) (*IndexEntry, bool) {
+	prefix, suffix := idx.splitKey(key)
+	id, ok := idx.lookupPrefix(prefix)
+	if !ok {
+		return nil, false
+	}
+
+	entry, exists := idx.entries[id][suffix]
 	return entry, exists
 }
 
@@ -42,8 +289,68 @@ func (idx *HashIndex) Delete(key []byte) {
 	idx.mutex.Lock()
 	defer idx.mutex.Unlock()
 
-	keyStr := string(key)
-	delete(idx.entries, keyStr)
+	idx.deleteInternal(string(key), 0)
+}
+
+// DeleteAt removes a key deleted by a tombstone written at timestamp, which
+// becomes the last write under the key's prefix
+func (idx *HashIndex) DeleteAt(key []byte, timestamp uint64) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	idx.deleteInternal(string(key), timestamp)
+}
+
+// AddTombstone counts a tombstone record of size bytes written to the log
+func (idx *HashIndex) AddTombstone(size int64) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	idx.tombstones++
+	idx.tombstoneBytes += size
+}
+
+// SegmentUsage returns the number of keys and live record bytes the index
+// references in each segment, by FileID
+func (idx *HashIndex) SegmentUsage() map[uint32]SegmentUsage {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	usage := make(map[uint32]SegmentUsage)
+	for _, bucket := range idx.entries {
+		for _, entry := range bucket {
+			u := usage[entry.FileID]
+			u.Keys++
+			u.LiveBytes += int64(entry.Size)
+			usage[entry.FileID] = u
+		}
+	}
+	return usage
+}
+
+// MoveSegment points every entry in segment from at segment to, keeping
+// offsets. Entries are replaced rather than changed so callers holding one
+// from Get still see the location they were given.
+func (idx *HashIndex) MoveSegment(from, to uint32) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	for _, bucket := range idx.entries {
+		for suffix, entry := range bucket {
+			if entry.FileID != from {
+				continue
+			}
+			moved := *entry
+			moved.FileID = to
+			bucket[suffix] = &moved
+		}
+	}
+}
+
+// SegmentUsage is the part of a segment the index still references
+type SegmentUsage struct {
+	Keys      int
+	LiveBytes int64
 }
 
 // Size returns the number of keys in the index
@@ -51,7 +358,7 @@ func (idx *HashIndex) Size() int {
 	idx.mutex.RLock()
 	defer idx.mutex.RUnlock()
 
-	return len(idx.entries)
+	return idx.size
 }
 
 // Clear removes all entries from the index
@@ -59,7 +366,7 @@ func (idx *HashIndex) Clear() {
 	idx.mutex.Lock()
 	defer idx.mutex.Unlock()
 
-	idx.entries = make(map[string]*IndexEntry)
+	idx.reset()
 }
 
 // Keys returns all keys in the index (for debugging/testing)
@@ -67,9 +374,12 @@ func (idx *HashIndex) Keys() []string {
 	idx.mutex.RLock()
 	defer idx.mutex.RUnlock()
 
-	keys := make([]string, 0, len(idx.entries))
-	for key := range idx.entries {
-		keys = append(keys, key)
+	keys := make([]string, 0, idx.size)
+	for id, bucket := range idx.entries {
+		prefix := idx.prefixes[id]
+		for suffix := range bucket {
+			keys = append(keys, prefix+suffix)
+		}
 	}
 	return keys
 }
@@ -79,39 +389,64 @@ func (idx *HashIndex) KeysWithPrefix(prefix string) []string {
 	idx.mutex.RLock()
 	defer idx.mutex.RUnlock()
 
+	return idx.keysWithPrefixInternal(prefix)
+}
+
+// keysWithPrefixInternal collects matching keys (caller must hold a lock).
+// The bucket of the search prefix's last complete component is filtered,
+// and every bucket in the prefix tree below it that matches is taken whole,
+// so the cost depends on the matches rather than the size of the index.
+func (idx *HashIndex) keysWithPrefixInternal(prefix string) []string {
+	parent, rest := idx.splitKey(prefix)
+
 	var keys []string
-	for key := range idx.entries {
-		if strings.HasPrefix(key, prefix) {
-			keys = append(keys, key)
+	if id, ok := idx.lookupPrefix(parent); ok {
+		for suffix := range idx.entries[id] {
+			if strings.HasPrefix(suffix, rest) {
+				keys = append(keys, parent+suffix)
+			}
+		}
+	}
+	for _, child := range idx.children[parent] {
+		if strings.HasPrefix(child, prefix) {
+			keys = idx.appendSubtreeInternal(keys, child)
 		}
 	}
 	return keys
 }
 
+// appendSubtreeInternal appends every key under prefix (caller must hold a lock)
+func (idx *HashIndex) appendSubtreeInternal(keys []string, prefix string) []string {
+	if id, ok := idx.lookupPrefix(prefix); ok {
+		for suffix := range idx.entries[id] {
+			keys = append(keys, prefix+suffix)
+		}
+	}
+	for _, child := range idx.children[prefix] {
+		keys = idx.appendSubtreeInternal(keys, child)
+	}
+	return keys
+}
+
 // ScanPrefix returns a channel of keys that match the prefix
-// This allows for streaming results and better memory management
-func (idx *HashIndex) ScanPrefix(prefix string) <-chan string {
+// This allows for streaming results and better memory management. The
+// channel is closed once every key is sent or ctx is done; consumers that
+// stop early must cancel ctx so the sending goroutine can exit.
+func (idx *HashIndex) ScanPrefix(ctx context.Context, prefix string) <-chan string {
 	ch := make(chan string, 100) // Buffered channel for performance
 
 	go func() {
 		defer close(ch)
 
 		idx.mutex.RLock()
-		keys := make([]string, 0, len(idx.entries))
-
-		// Collect matching keys
-		for key := range idx.entries {
-			if strings.HasPrefix(key, prefix) {
-				keys = append(keys, key)
-			}
-		}
+		keys := idx.keysWithPrefixInternal(prefix)
 		idx.mutex.RUnlock()
 
 		// Send keys through channel
 		for _, key := range keys {
 			select {
 			case ch <- key:
-			case <-ch: // Channel closed by receiver
+			case <-ctx.Done():
 				return
 			}
 		}
@@ -120,45 +455,195 @@ func (idx *HashIndex) ScanPrefix(prefix string) <-chan string {
 	return ch
 }
 
+// DeleteRange removes every key covered by rt and remembers the tombstone
+func (idx *HashIndex) DeleteRange(rt RangeTombstone) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	idx.deleteRangeInternal(rt)
+}
+
+// deleteRangeInternal applies a range tombstone (caller must hold the write
+// lock). The keys in the range are found by seeking the ordered keys if
+// Range has built them, and otherwise by walking only the branches of the
+// prefix tree that overlap the range, so the cost follows the keys deleted
+// rather than the size of the index.
+func (idx *HashIndex) deleteRangeInternal(rt RangeTombstone) {
+	var keys []string
+	if idx.ordered != nil {
+		idx.ordered.Ascend(rt.Start, rt.End, func(key, _ []byte) bool {
+			keys = append(keys, string(key))
+			return true
+		})
+	} else {
+		keys = idx.appendRangeInternal(keys, "", rt)
+	}
+	for _, key := range keys {
+		idx.deleteInternal(key, rt.Timestamp)
+	}
+	idx.ranges = append(idx.ranges, rt)
+}
+
+// appendRangeInternal appends the keys under prefix that rt contains,
+// skipping child prefixes whose keys all fall outside it and taking those
+// whose keys all fall inside it whole (caller must hold a lock)
+func (idx *HashIndex) appendRangeInternal(keys []string, prefix string, rt RangeTombstone) []string {
+	if id, ok := idx.lookupPrefix(prefix); ok {
+		for suffix := range idx.entries[id] {
+			if key := prefix + suffix; rt.Contains([]byte(key)) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	for _, child := range idx.children[prefix] {
+		// Every key under child is in [child, PrefixEnd(child))
+		lo, hi := []byte(child), codec.PrefixEnd([]byte(child))
+		switch {
+		case len(rt.End) > 0 && bytes.Compare(lo, rt.End) >= 0:
+		case hi != nil && bytes.Compare(hi, rt.Start) <= 0:
+		case bytes.Compare(lo, rt.Start) >= 0 && (len(rt.End) == 0 || hi != nil && bytes.Compare(hi, rt.End) <= 0):
+			keys = idx.appendSubtreeInternal(keys, child)
+		default:
+			keys = idx.appendRangeInternal(keys, child, rt)
+		}
+	}
+	return keys
+}
+
+// clearTombstones forgets the tombstones counted so far, once compaction
+// has removed their records
+func (idx *HashIndex) clearTombstones() {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	idx.tombstones = 0
+	idx.tombstoneBytes = 0
+}
+
+// retainRanges replaces the range tombstones applied to the index with
+// kept, once compaction has dropped the records of the others
+func (idx *HashIndex) retainRanges(kept []RangeTombstone) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	idx.ranges = kept
+}
+
+// RangeTombstones returns a copy of the range tombstones applied to the index
+func (idx *HashIndex) RangeTombstones() []RangeTombstone {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	return append([]RangeTombstone(nil), idx.ranges...)
+}
+
+// SequenceLimit returns the highest ID reserved by the named sequence, or 0
+func (idx *HashIndex) SequenceLimit(name string) uint64 {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	return idx.sequences[name]
+}
+
+// SetSequenceLimit records a new reservation of the named sequence
+func (idx *HashIndex) SetSequenceLimit(name string, limit uint64) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	idx.sequences[name] = max(idx.sequences[name], limit)
+}
+
 // BuildFromLog scans a log file and populates the index
 func (idx *HashIndex) BuildFromLog(reader *LogReader) error {
+	return idx.BuildFromSegments([]SegmentLog{{FileID: activeFileID, Reader: reader}})
+}
+
+// SegmentLog is a segment to index and the FileID its entries point at
+type SegmentLog struct {
+	FileID uint32
+	Reader *LogReader
+}
+
+// BuildFromSegments clears the index and applies each segment's records in
+// turn, so records in later segments replace those in earlier ones
+func (idx *HashIndex) BuildFromSegments(logs []SegmentLog) error {
+	return idx.BuildFromSegmentsUntil(logs, math.MaxUint64)
+}
+
+// BuildFromSegmentsUntil is BuildFromSegments applying only the records
+// written at or before until, a record timestamp, so the index describes
+// the store as it was then
+func (idx *HashIndex) BuildFromSegmentsUntil(logs []SegmentLog, until uint64) error {
 	idx.mutex.Lock()
 	defer idx.mutex.Unlock()
 
 	// Clear existing entries
-	idx.entries = make(map[string]*IndexEntry)
+	idx.reset()
 
-	// Reset reader to beginning
-	if err := reader.Seek(0); err != nil {
-		return err
+	for _, log := range logs {
+		// Reset reader to beginning
+		if err := log.Reader.Seek(0); err != nil {
+			return err
+		}
+
+		iterator := log.Reader.Iterator()
+		for iterator.Next() {
+			record := iterator.Record()
+			if record == nil || record.Timestamp > until {
+				continue
+			}
+			idx.applyRecordInternal(record, log.FileID, log.Reader.Offset()-int64(record.Size()))
+		}
+		if err := iterator.Close(); err != nil {
+			return err
+		}
 	}
 
-	iterator := reader.Iterator()
-	defer iterator.Close()
+	return nil
+}
 
-	for iterator.Next() {
-		record := iterator.Record()
-		if record == nil {
-			continue
-		}
+// ApplyRecord indexes one record read from the active log at offset,
+// exactly as BuildFromLog would. Standby stores use it to index records as
+// the primary appends them.
+func (idx *HashIndex) ApplyRecord(record *codec.Record, offset int64) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	idx.applyRecordInternal(record, activeFileID, offset)
+}
 
-		keyStr := string(record.Key)
-		entry := &IndexEntry{
-			FileID:    0, // Single file for now
-			Offset:    reader.Offset() - int64(record.Size()),
-			Size:      uint32(record.Size()),
+// applyRecordInternal indexes one log record (caller must hold the mutex)
+func (idx *HashIndex) applyRecordInternal(record *codec.Record, fileID uint32, offset int64) {
+	// Range tombstones drop every indexed key they cover
+	if start, end, ok := codec.DecodeRangeTombstone(record); ok {
+		idx.deleteRangeInternal(RangeTombstone{
+			Start:     append([]byte(nil), start...),
+			End:       append([]byte(nil), end...),
 			Timestamp: record.Timestamp,
-		}
+		})
+		return
+	}
 
-		// Handle tombstones (empty value indicates deletion)
-		if len(record.Value) == 0 {
-			delete(idx.entries, keyStr)
-		} else {
-			idx.entries[keyStr] = entry
-		}
+	// Sequence reservations only move the sequence's high-water mark
+	if name, limit, ok := codec.DecodeSequenceReservation(record); ok {
+		idx.sequences[name] = max(idx.sequences[name], limit)
+		return
 	}
 
-	return nil
+	keyStr := string(record.Key)
+	entry := &IndexEntry{
+		FileID:    fileID,
+		Offset:    offset,
+		Size:      uint32(record.Size()),
+		Timestamp: record.Timestamp,
+		ExpiresAt: record.ExpiresAt,
+	}
+
+	// Handle tombstones (empty value indicates deletion)
+	if len(record.Value) == 0 {
+		idx.deleteInternal(keyStr, record.Timestamp)
+		idx.tombstones++
+		idx.tombstoneBytes += int64(entry.Size)
+	} else {
+		idx.putInternal(keyStr, entry)
+	}
 }
 
 // Stats returns index statistics
@@ -166,12 +651,33 @@ func (idx *HashIndex) Stats() *IndexStats {
 	idx.mutex.RLock()
 	defer idx.mutex.RUnlock()
 
+	var prefixBytes int64
+	for prefix := range idx.prefixIDs {
+		prefixBytes += int64(len(prefix))
+	}
+
 	return &IndexStats{
-		TotalKeys: len(idx.entries),
+		TotalKeys:          idx.size,
+		Prefixes:           len(idx.prefixIDs),
+		KeyBytes:           idx.keyBytes,
+		CompressedKeyBytes: idx.sfxBytes + prefixBytes,
+		LiveBytes:          idx.liveBytes,
+		Tombstones:         idx.tombstones,
+		InternalKeys:       idx.internalKeys,
+		InternalBytes:      idx.internalBytes,
+		TombstoneBytes:     idx.tombstoneBytes,
 	}
 }
 
 // IndexStats holds statistics about the index
 type IndexStats struct {
-	TotalKeys int
+	TotalKeys          int
+	Prefixes           int   // Number of interned key prefixes
+	KeyBytes           int64 // Key bytes if every key were stored in full
+	CompressedKeyBytes int64 // Key bytes actually held (suffixes + prefix table)
+	LiveBytes          int64 // Bytes of the records the index points at
+	Tombstones         int   // Tombstone records in the log
+	InternalKeys       int   // Of TotalKeys, keys in an internal keyspace
+	InternalBytes      int64 // Of LiveBytes, records of internal keys
+	TombstoneBytes     int64 // Bytes of the tombstone records in the log
 }
@@ -1,10 +1,14 @@
 package store
 
 import (
+	"context"
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/ssargent/freyjadb/pkg/keys"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewHashIndex(t *testing.T) {
@@ -194,6 +198,26 @@ func TestHashIndex_KeysWithPrefix(t *testing.T) {
 	assert.Len(t, nonExistentKeys, 0)
 }
 
+func TestHashIndex_KeysWithPrefix_Nested(t *testing.T) {
+	idx := NewHashIndex(HashIndexConfig{})
+	for _, key := range []string{"a:b:c:1", "a:b:2", "a:bc:3", "a:b:c:d:4", "a:x", "ab", "b:1"} {
+		idx.Put([]byte(key), &IndexEntry{})
+	}
+
+	assert.ElementsMatch(t, []string{"a:b:c:1", "a:b:2", "a:bc:3", "a:b:c:d:4"}, idx.KeysWithPrefix("a:b"))
+	assert.ElementsMatch(t, []string{"a:b:c:1", "a:b:2", "a:b:c:d:4"}, idx.KeysWithPrefix("a:b:"))
+	assert.ElementsMatch(t, []string{"a:b:c:d:4"}, idx.KeysWithPrefix("a:b:c:d"))
+	assert.ElementsMatch(t, []string{"a:b:c:1", "a:b:2", "a:bc:3", "a:b:c:d:4", "a:x", "ab"}, idx.KeysWithPrefix("a"))
+	assert.Len(t, idx.KeysWithPrefix(""), 7)
+	assert.Empty(t, idx.KeysWithPrefix("a:b:c:d:5"))
+
+	// Emptied buckets drop out of results but keep their place in the tree
+	idx.Delete([]byte("a:b:c:d:4"))
+	assert.ElementsMatch(t, []string{"a:b:c:1"}, idx.KeysWithPrefix("a:b:c"))
+	idx.Put([]byte("a:b:c:d:5"), &IndexEntry{})
+	assert.ElementsMatch(t, []string{"a:b:c:d:5"}, idx.KeysWithPrefix("a:b:c:d:"))
+}
+
 func TestHashIndex_ScanPrefix(t *testing.T) {
 	idx := NewHashIndex(HashIndexConfig{})
 
@@ -210,7 +234,7 @@ func TestHashIndex_ScanPrefix(t *testing.T) {
 	}
 
 	// Scan for user keys
-	ch := idx.ScanPrefix("user:")
+	ch := idx.ScanPrefix(context.Background(), "user:")
 	var userKeys []string
 	for key := range ch {
 		userKeys = append(userKeys, key)
@@ -230,7 +254,7 @@ func TestHashIndex_ScanPrefix_EmptyResult(t *testing.T) {
 	idx.Put([]byte("item:1"), &IndexEntry{})
 
 	// Scan for non-existent prefix
-	ch := idx.ScanPrefix("nonexistent:")
+	ch := idx.ScanPrefix(context.Background(), "nonexistent:")
 	var keys []string
 	for key := range ch {
 		keys = append(keys, key)
@@ -239,6 +263,31 @@ func TestHashIndex_ScanPrefix_EmptyResult(t *testing.T) {
 	assert.Len(t, keys, 0)
 }
 
+func TestHashIndex_ScanPrefix_Cancel(t *testing.T) {
+	idx := NewHashIndex(HashIndexConfig{})
+	for i := 0; i < 500; i++ {
+		idx.Put([]byte(fmt.Sprintf("user:%d", i)), &IndexEntry{})
+	}
+
+	// Stop after one key; cancelling must let the sender finish and close
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := idx.ScanPrefix(ctx, "user:")
+	<-ch
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for range ch {
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ScanPrefix did not close its channel after cancel")
+	}
+}
+
 func TestHashIndex_Clear(t *testing.T) {
 	idx := NewHashIndex(HashIndexConfig{})
 
@@ -274,6 +323,43 @@ func TestHashIndex_Stats(t *testing.T) {
 	assert.Equal(t, 3, stats.TotalKeys)
 }
 
+func TestHashIndex_StatsLiveBytes(t *testing.T) {
+	idx := NewHashIndex(HashIndexConfig{})
+
+	idx.Put([]byte("a"), &IndexEntry{Size: 10})
+	idx.Put([]byte("b"), &IndexEntry{Size: 20})
+	idx.Put([]byte("a"), &IndexEntry{Size: 15}) // Overwrite replaces the old size
+	assert.Equal(t, int64(35), idx.Stats().LiveBytes)
+
+	idx.Delete([]byte("b"))
+	assert.Equal(t, int64(15), idx.Stats().LiveBytes)
+
+	idx.Clear()
+	assert.Equal(t, int64(0), idx.Stats().LiveBytes)
+}
+
+func TestHashIndex_StatsInternalKeys(t *testing.T) {
+	idx := NewHashIndex(HashIndexConfig{InternalKeyspaces: []keys.Keyspace{"meta"}})
+
+	idx.Put([]byte("meta:a"), &IndexEntry{Size: 10})
+	idx.Put([]byte("metadata:a"), &IndexEntry{Size: 20}) // Not in the keyspace
+	idx.Put([]byte("meta:a"), &IndexEntry{Size: 15})
+	idx.Put([]byte("apikey:ci"), &IndexEntry{Size: 5}) // Defaults replaced by the config
+	idx.AddTombstone(7)
+
+	stats := idx.Stats()
+	assert.Equal(t, 3, stats.TotalKeys)
+	assert.Equal(t, 1, stats.InternalKeys)
+	assert.Equal(t, int64(15), stats.InternalBytes)
+	assert.Equal(t, 1, stats.Tombstones)
+	assert.Equal(t, int64(7), stats.TombstoneBytes)
+
+	idx.Delete([]byte("meta:a"))
+	stats = idx.Stats()
+	assert.Equal(t, 0, stats.InternalKeys)
+	assert.Equal(t, int64(0), stats.InternalBytes)
+}
+
 func TestHashIndex_ConcurrentAccess(t *testing.T) {
 	idx := NewHashIndex(HashIndexConfig{})
 
@@ -376,3 +462,97 @@ func BenchmarkHashIndex_KeysWithPrefix(b *testing.B) {
 		idx.KeysWithPrefix("user:")
 	}
 }
+
+func TestHashIndex_PrefixCompression(t *testing.T) {
+	idx := NewHashIndex(HashIndexConfig{})
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("relationship:forward:user|%d", i)
+		idx.Put([]byte(key), &IndexEntry{Offset: int64(i)})
+	}
+	idx.Put([]byte("plainkey"), &IndexEntry{Offset: 1000})
+
+	stats := idx.Stats()
+	assert.Equal(t, 101, stats.TotalKeys)
+	assert.Equal(t, 2, stats.Prefixes) // "relationship:forward:" and ""
+	assert.Less(t, stats.CompressedKeyBytes, stats.KeyBytes)
+
+	// Lookups and prefix scans see full keys
+	entry, ok := idx.Get([]byte("relationship:forward:user|42"))
+	require.True(t, ok)
+	assert.Equal(t, int64(42), entry.Offset)
+
+	assert.Len(t, idx.KeysWithPrefix("relationship:"), 100)
+	assert.Len(t, idx.KeysWithPrefix("relationship:forward:user|1"), 11)
+	assert.Equal(t, []string{"plainkey"}, idx.KeysWithPrefix("plain"))
+
+	// Deleting keeps the byte accounting in sync
+	idx.Delete([]byte("plainkey"))
+	idx.Delete([]byte("plainkey"))
+	stats = idx.Stats()
+	assert.Equal(t, 100, stats.TotalKeys)
+	assert.Equal(t, int64(100*len("relationship:forward:user|"))+int64(190), stats.KeyBytes)
+}
+
+func TestHashIndex_PrefixRelease(t *testing.T) {
+	idx := NewHashIndex(HashIndexConfig{})
+	idx.Put([]byte("user:keep"), &IndexEntry{Size: 10})
+
+	// Per-entity prefixes come and go without growing the table
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("user:%d:profile:name", i))
+		idx.Put(key, &IndexEntry{Size: 10})
+		idx.Delete(key)
+	}
+	stats := idx.Stats()
+	assert.Equal(t, 1, stats.TotalKeys)
+	assert.Equal(t, 1, stats.Prefixes)
+	assert.Equal(t, int64(len("user:keep")), stats.CompressedKeyBytes)
+	assert.LessOrEqual(t, len(idx.prefixes), 2)
+
+	// Emptied branches leave the prefix tree, down to prefixes still in use
+	assert.Empty(t, idx.children["user:"])
+	assert.NotContains(t, idx.children, "user:999:")
+	assert.Equal(t, []string{"user:keep"}, idx.KeysWithPrefix("user:"))
+
+	// A released ID is reused for the next new prefix
+	idx.Put([]byte("user:7:profile:name"), &IndexEntry{Size: 10})
+	assert.Equal(t, []string{"user:7:profile:name"}, idx.KeysWithPrefix("user:7:"))
+	assert.Equal(t, 1, idx.PrefixStats("user:7:").Keys)
+	assert.Equal(t, 2, idx.PrefixStats("user:").Keys)
+	assert.LessOrEqual(t, len(idx.prefixes), 2)
+}
+
+func TestHashIndex_DeleteRange(t *testing.T) {
+	keys := []string{
+		"a", "b", "user", "user:", "user:1", "user:1:name", "user:1:tags:x", "user:10:name",
+		"user:2:name", "user:2:tags:y", "user:3", "users:1", "zz:top", "\xff:x", "\xff\xff",
+	}
+	ranges := []RangeTombstone{
+		{Start: []byte("user:1"), End: []byte("user:2")},
+		{Start: []byte("user:1:"), End: []byte("user:1;")},
+		{Start: []byte("user:"), End: []byte("user;")},
+		{Start: []byte("user:2:name"), End: []byte("user:3")},
+		{Start: []byte("b"), End: []byte("user:10")},
+		{Start: []byte("user:3")},
+		{Start: []byte("\xff")},
+		{Start: []byte("a"), End: []byte("a\x00")},
+	}
+	for _, ordered := range []bool{false, true} {
+		for _, rt := range ranges {
+			idx := NewHashIndex(HashIndexConfig{})
+			for _, key := range keys {
+				idx.Put([]byte(key), &IndexEntry{Size: 10})
+			}
+			if ordered {
+				idx.KeysInRange(nil, nil, 0, false, time.Now())
+			}
+			idx.DeleteRange(rt)
+
+			for _, key := range keys {
+				_, ok := idx.Get([]byte(key))
+				assert.Equal(t, !rt.Contains([]byte(key)), ok, "ordered=%v range [%q, %q) key %q", ordered, rt.Start, rt.End, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package store
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// IO scheduling defaults
+const (
+	// DefaultBackgroundLatencyTarget is the foreground latency under which
+	// background work runs unthrottled
+	DefaultBackgroundLatencyTarget = 5 * time.Millisecond
+
+	// DefaultMinBackgroundRate is the throughput background work gets
+	// however busy the foreground is, so it always finishes eventually
+	DefaultMinBackgroundRate = 4 * 1024 * 1024
+
+	// ioPollInterval is how often throttled background work checks for
+	// headroom again
+	ioPollInterval = time.Millisecond
+
+	// ioLatencyDecay is how long after the last foreground operation its
+	// latency stops counting against the headroom
+	ioLatencyDecay = 100 * time.Millisecond
+
+	// ioLatencyWeight is the weight of the latest foreground operation in
+	// the latency average
+	ioLatencyWeight = 0.2
+
+	// compactionHeadroomWait bounds how long Compact waits for headroom
+	// before taking the store lock anyway
+	compactionHeadroomWait = time.Second
+)
+
+// IOStats reports how background work was scheduled around the foreground
+type IOStats struct {
+	ForegroundOps     int64         // Gets and writes
+	ForegroundLatency time.Duration // Moving average of their latency
+	BackgroundBytes   int64         // Bytes of background work admitted
+	ThrottledBytes    int64         // Of those, bytes admitted at the minimum rate while the foreground was busy
+	BackgroundWait    time.Duration // Time background work spent waiting for headroom
+}
+
+// ioScheduler tells foreground operations (client reads and writes) from
+// background work (backups, index rebuilds, compaction) and admits
+// background work at full speed only while the foreground has headroom: no
+// operation in flight and recent latency under the target. Otherwise
+// background work is held to the minimum rate by a token bucket.
+type ioScheduler struct {
+	target  time.Duration // Negative disables scheduling
+	minRate int64         // Bytes per second
+
+	mutex    sync.Mutex
+	inFlight int
+	latency  float64 // Nanoseconds
+	lastDone time.Time
+	tokens   float64
+	refilled time.Time
+	stats    IOStats
+}
+
+func newIOScheduler(target time.Duration, minRate int64) *ioScheduler {
+	if target == 0 {
+		target = DefaultBackgroundLatencyTarget
+	}
+	if minRate <= 0 {
+		minRate = DefaultMinBackgroundRate
+	}
+	return &ioScheduler{target: target, minRate: minRate, refilled: time.Now()}
+}
+
+// foreground marks the start of a foreground operation; call the returned
+// function when it finishes
+func (s *ioScheduler) foreground() func() {
+	start := time.Now()
+	s.mutex.Lock()
+	s.inFlight++
+	s.mutex.Unlock()
+
+	return func() {
+		now := time.Now()
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.inFlight--
+		s.stats.ForegroundOps++
+		elapsed := float64(now.Sub(start))
+		if s.latency == 0 {
+			s.latency = elapsed
+		} else {
+			s.latency += ioLatencyWeight * (elapsed - s.latency)
+		}
+		s.lastDone = now
+	}
+}
+
+// headroomInternal reports whether background work may run unthrottled
+// (caller must hold the scheduler mutex)
+func (s *ioScheduler) headroomInternal(now time.Time) bool {
+	if s.inFlight > 0 {
+		return false
+	}
+	return now.Sub(s.lastDone) > ioLatencyDecay || time.Duration(s.latency) <= s.target
+}
+
+// background waits until n bytes of background work may proceed, or until
+// ctx is done
+func (s *ioScheduler) background(ctx context.Context, n int64) error {
+	if s.target < 0 {
+		return nil
+	}
+	start := time.Now()
+	for {
+		now := time.Now()
+		s.mutex.Lock()
+		// The bucket holds a second of the minimum rate, or n if larger,
+		// so a large request is admitted eventually
+		capacity := float64(max(s.minRate, n))
+		s.tokens = min(capacity, s.tokens+now.Sub(s.refilled).Seconds()*float64(s.minRate))
+		s.refilled = now
+
+		admitted, throttled := s.headroomInternal(now), false
+		if !admitted && s.tokens >= float64(n) {
+			s.tokens -= float64(n)
+			admitted, throttled = true, true
+		}
+		if admitted {
+			s.stats.BackgroundBytes += n
+			if throttled {
+				s.stats.ThrottledBytes += n
+			}
+			s.stats.BackgroundWait += now.Sub(start)
+		}
+		s.mutex.Unlock()
+		if admitted {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			s.mutex.Lock()
+			s.stats.BackgroundWait += time.Since(start)
+			s.mutex.Unlock()
+			return ctx.Err()
+		case <-time.After(ioPollInterval):
+		}
+	}
+}
+
+// waitHeadroom waits up to limit for the foreground to have headroom
+func (s *ioScheduler) waitHeadroom(limit time.Duration) {
+	if s.target < 0 {
+		return
+	}
+	start := time.Now()
+	for {
+		now := time.Now()
+		s.mutex.Lock()
+		ready := s.headroomInternal(now) || now.Sub(start) >= limit
+		if ready {
+			s.stats.BackgroundWait += now.Sub(start)
+		}
+		s.mutex.Unlock()
+		if ready {
+			return
+		}
+		time.Sleep(ioPollInterval)
+	}
+}
+
+// snapshot returns the scheduler's stats
+func (s *ioScheduler) snapshot() IOStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stats := s.stats
+	stats.ForegroundLatency = time.Duration(s.latency)
+	return stats
+}
+
+// ThrottleBackground waits until n bytes of background work may proceed:
+// at once while client reads and writes have latency headroom, otherwise at
+// MinBackgroundRate. Embedders running their own bulk jobs against the
+// store (re-indexing, exports, verification) call it per chunk so the jobs
+// yield to client traffic.
+func (kv *KVStore) ThrottleBackground(ctx context.Context, n int64) error {
+	return kv.io.background(ctx, n)
+}
+
+// IOStats reports how background work was scheduled around client traffic
+func (kv *KVStore) IOStats() IOStats {
+	return kv.io.snapshot()
+}
+
+// backgroundReader is a reader whose reads are background work
+type backgroundReader struct {
+	ctx context.Context
+	io  *ioScheduler
+	r   io.Reader
+}
+
+func (b *backgroundReader) Read(p []byte) (int, error) {
+	if err := b.io.background(b.ctx, int64(len(p))); err != nil {
+		return 0, err
+	}
+	return b.r.Read(p)
+}
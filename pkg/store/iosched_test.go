@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIOScheduler_HeadroomAdmitsAtOnce(t *testing.T) {
+	s := newIOScheduler(0, 1024)
+	done := s.foreground()
+	done()
+
+	start := time.Now()
+	if err := s.background(context.Background(), 1<<20); err != nil {
+		t.Fatalf("background failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("background with headroom took %v", elapsed)
+	}
+	if stats := s.snapshot(); stats.BackgroundBytes != 1<<20 || stats.ThrottledBytes != 0 || stats.ForegroundOps != 1 {
+		t.Errorf("stats = %+v, want 1MiB admitted unthrottled after 1 foreground op", stats)
+	}
+}
+
+func TestIOScheduler_BusyForegroundThrottles(t *testing.T) {
+	s := newIOScheduler(0, 64*1024)
+	done := s.foreground()
+	defer done()
+
+	// The bucket starts empty, so 16KiB takes a quarter second at 64KiB/s
+	start := time.Now()
+	if err := s.background(context.Background(), 16*1024); err != nil {
+		t.Fatalf("background failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("background while busy took %v, want about 250ms", elapsed)
+	}
+	if stats := s.snapshot(); stats.ThrottledBytes != 16*1024 || stats.BackgroundWait == 0 {
+		t.Errorf("stats = %+v, want 16KiB throttled after a wait", stats)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.background(ctx, 1<<20); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("background error = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestIOScheduler_SlowForegroundThrottles(t *testing.T) {
+	s := newIOScheduler(time.Nanosecond, 1024)
+	done := s.foreground()
+	time.Sleep(time.Millisecond)
+	done()
+
+	s.mutex.Lock()
+	headroom := s.headroomInternal(time.Now())
+	decayed := s.headroomInternal(time.Now().Add(2 * ioLatencyDecay))
+	s.mutex.Unlock()
+	if headroom {
+		t.Error("expected no headroom right after a slow foreground operation")
+	}
+	if !decayed {
+		t.Error("expected headroom once the foreground has been idle")
+	}
+}
+
+func TestIOScheduler_Disabled(t *testing.T) {
+	s := newIOScheduler(-1, 1)
+	done := s.foreground()
+	defer done()
+
+	if err := s.background(context.Background(), 1<<30); err != nil {
+		t.Fatalf("background failed: %v", err)
+	}
+}
+
+func TestKVStore_IOStats(t *testing.T) {
+	kv, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewKVStore failed: %v", err)
+	}
+	if _, err := kv.Open(); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer kv.Close()
+
+	if err := kv.Put([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, err := kv.Get([]byte("k")); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if err := kv.ThrottleBackground(context.Background(), 10); err != nil {
+		t.Fatalf("ThrottleBackground failed: %v", err)
+	}
+
+	stats := kv.Stats().IO
+	if stats.ForegroundOps != 2 || stats.BackgroundBytes != 10 || stats.ForegroundLatency == 0 {
+		t.Errorf("IO stats = %+v, want 2 foreground ops and 10 background bytes", stats)
+	}
+}
@@ -0,0 +1,169 @@
+package store
+
+import "time"
+
+// PrefixIterator walks the key-value pairs under a prefix. The matching keys
+// are captured when the iterator is created; each value is read from disk by
+// Next, so at most one value is held at a time. Keys deleted after the scan
+// started are skipped. Once values are read sequentially through a
+// segment, the iterator reads KVStoreConfig.ReadaheadSize bytes of it at a
+// time (see Stats). Iterators run on the caller's goroutine; Close releases
+// the remaining keys and any segment files readahead opened, which a scan
+// that runs to the end releases by itself.
+//
+//	it, err := kv.ScanPrefix([]byte("user:"))
+//	if err != nil {
+//		return err
+//	}
+//	defer it.Close()
+//	for it.Next() {
+//		use(it.Key(), it.Value())
+//	}
+//	return it.Err()
+type PrefixIterator struct {
+	kv   *KVStore
+	keys []string
+	pos  int
+
+	current KeyValuePair
+	err     error
+	closed  bool
+
+	// The active segment is flushed up to syncedSize for syncedWriter, so
+	// records below that offset can be read without another sync. A
+	// memtable flush empties the log and moves syncedBase on.
+	syncedWriter *LogWriter
+	syncedSize   int64
+	syncedBase   uint64
+
+	prefetch *prefetcher
+	finished bool // Readahead released and stats reported
+}
+
+// ScanPrefix returns an iterator over the key-value pairs whose keys start
+// with prefix. Pairs are returned in no particular key order: they come in
+// the order their records are stored, so the scan reads each segment front
+// to back.
+func (kv *KVStore) ScanPrefix(prefix []byte) (*PrefixIterator, error) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if err := kv.checkOpenInternal(); err != nil {
+		return nil, err
+	}
+
+	keys := kv.index.KeysWithPrefix(string(prefix))
+	kv.sortByLocationInternal(keys)
+	return &PrefixIterator{
+		kv:       kv,
+		keys:     keys,
+		prefetch: newPrefetcher(kv.readaheadSize(), kv.codec),
+	}, nil
+}
+
+// Next advances to the next pair, returning false when the scan is finished,
+// fails, or the iterator is closed
+func (it *PrefixIterator) Next() bool {
+	it.current = KeyValuePair{}
+	for !it.closed && it.err == nil && it.pos < len(it.keys) {
+		key := []byte(it.keys[it.pos])
+		it.keys[it.pos] = "" // Release keys as they are consumed
+		it.pos++
+
+		value, err := it.read(key)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if value == nil {
+			continue // Deleted while scanning
+		}
+		it.current = KeyValuePair{Key: key, Value: value}
+		return true
+	}
+	it.finish()
+	return false
+}
+
+// read returns the current value of key, or nil if it no longer exists
+func (it *PrefixIterator) read(key []byte) ([]byte, error) {
+	kv := it.kv
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if err := kv.checkOpenInternal(); err != nil {
+		return nil, err
+	}
+
+	entry, exists := kv.index.Get(key)
+	if !exists || entry.expired(time.Now()) {
+		return nil, nil
+	}
+
+	// Make sure the record has left the write buffer before reading it
+	if entry.FileID == activeFileID && (it.syncedWriter != kv.writer || it.syncedBase != kv.logBaseInternal() ||
+		entry.Offset+int64(entry.Size) > it.syncedSize) {
+		if err := kv.writer.Sync(); err != nil {
+			return nil, err
+		}
+		it.syncedWriter, it.syncedSize, it.syncedBase = kv.writer, kv.writer.Size(), kv.logBaseInternal()
+	}
+
+	record := it.prefetch.read(kv, key, entry, it.syncedSize)
+	var err error
+	if record == nil {
+		record, err = kv.readKeyInternal(key, entry)
+	}
+	if err != nil {
+		return nil, nil // Skip records that are deleted or can't be repaired
+	}
+	if len(record.Value) == 0 {
+		return nil, nil // Tombstone
+	}
+	return record.Value, nil
+}
+
+// Key returns the current pair's key
+func (it *PrefixIterator) Key() []byte {
+	return it.current.Key
+}
+
+// Value returns the current pair's value
+func (it *PrefixIterator) Value() []byte {
+	return it.current.Value
+}
+
+// Err returns the error that stopped the scan, if any
+func (it *PrefixIterator) Err() error {
+	return it.err
+}
+
+// Stats reports how the scan has read its values so far
+func (it *PrefixIterator) Stats() ScanStats {
+	return it.prefetch.stats
+}
+
+// Close stops the scan and releases its remaining keys. It is safe to call
+// more than once.
+func (it *PrefixIterator) Close() error {
+	it.closed = true
+	it.keys = nil
+	it.current = KeyValuePair{}
+	it.finish()
+	return nil
+}
+
+// finish releases the readahead's files and adds the scan's statistics to
+// the store's, once
+func (it *PrefixIterator) finish() {
+	if it.finished {
+		return
+	}
+	it.finished = true
+	it.prefetch.close()
+
+	kv := it.kv
+	kv.mutex.Lock()
+	kv.scanStats.add(it.prefetch.stats)
+	kv.mutex.Unlock()
+}
@@ -0,0 +1,154 @@
+package store
+
+import (
+	"sort"
+	"sync"
+)
+
+// KeyLock is a held set of advisory key locks returned by LockKey/TryLockKey
+type KeyLock struct {
+	table    *keyLockTable
+	keys     []string
+	released bool
+	mutex    sync.Mutex
+}
+
+// Unlock releases every key held by the lock. It is safe to call more than once.
+func (l *KeyLock) Unlock() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.released {
+		return
+	}
+	l.released = true
+
+	// Release in reverse acquisition order
+	for i := len(l.keys) - 1; i >= 0; i-- {
+		l.table.release(l.keys[i])
+	}
+}
+
+// keyLockTable tracks reference-counted per-key mutexes
+type keyLockTable struct {
+	locks map[string]*keyLockEntry
+	mutex sync.Mutex
+}
+
+// keyLockEntry is a single key's mutex plus the number of goroutines using it
+type keyLockEntry struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// newKeyLockTable creates an empty lock table
+func newKeyLockTable() *keyLockTable {
+	return &keyLockTable{locks: make(map[string]*keyLockEntry)}
+}
+
+// ref returns the entry for key, creating it if necessary, and takes a reference
+func (t *keyLockTable) ref(key string) *keyLockEntry {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	entry, ok := t.locks[key]
+	if !ok {
+		entry = &keyLockEntry{}
+		t.locks[key] = entry
+	}
+	entry.refs++
+	return entry
+}
+
+// unref drops a reference and forgets the entry once nobody uses it
+func (t *keyLockTable) unref(key string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	entry := t.locks[key]
+	entry.refs--
+	if entry.refs == 0 {
+		delete(t.locks, key)
+	}
+}
+
+// release unlocks a held key
+func (t *keyLockTable) release(key string) {
+	t.mutex.Lock()
+	entry := t.locks[key]
+	t.mutex.Unlock()
+
+	entry.mu.Unlock()
+	t.unref(key)
+}
+
+// normalizeKeys dedups keys and sorts them so every caller acquires locks in
+// the same global order, which rules out lock-order deadlocks
+func normalizeKeys(keys [][]byte) []string {
+	seen := make(map[string]struct{}, len(keys))
+	out := make([]string, 0, len(keys))
+	for _, key := range keys {
+		k := string(key)
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// LockKey blocks until it holds advisory locks on all given keys. Keys are
+// acquired in sorted order, so callers locking overlapping key sets cannot
+// deadlock each other. The locks only coordinate callers that use them;
+// plain Put/Get/Delete do not consult them.
+func (kv *KVStore) LockKey(keys ...[]byte) (*KeyLock, error) {
+	normalized := normalizeKeys(keys)
+	if len(normalized) == 0 {
+		return nil, ErrInvalidKey
+	}
+
+	for _, k := range normalized {
+		if k == "" {
+			return nil, ErrInvalidKey
+		}
+	}
+
+	for _, k := range normalized {
+		kv.keyLocks.ref(k).mu.Lock()
+	}
+
+	return &KeyLock{table: kv.keyLocks, keys: normalized}, nil
+}
+
+// TryLockKey attempts to lock all given keys without blocking. It returns
+// false, holding nothing, if any key is already locked.
+func (kv *KVStore) TryLockKey(keys ...[]byte) (*KeyLock, bool, error) {
+	normalized := normalizeKeys(keys)
+	if len(normalized) == 0 {
+		return nil, false, ErrInvalidKey
+	}
+
+	for _, k := range normalized {
+		if k == "" {
+			return nil, false, ErrInvalidKey
+		}
+	}
+
+	for i, k := range normalized {
+		entry := kv.keyLocks.ref(k)
+		if entry.mu.TryLock() {
+			continue
+		}
+
+		// Roll back: drop the failed reference and release what we hold
+		kv.keyLocks.unref(k)
+		for j := i - 1; j >= 0; j-- {
+			kv.keyLocks.release(normalized[j])
+		}
+		return nil, false, nil
+	}
+
+	return &KeyLock{table: kv.keyLocks, keys: normalized}, true, nil
+}
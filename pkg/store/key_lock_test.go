@@ -0,0 +1,79 @@
+package store
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKVStore_LockKey(t *testing.T) {
+	store, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+
+	lock, err := store.LockKey([]byte("entity:1"), []byte("relationship:1"))
+	if err != nil {
+		t.Fatalf("LockKey failed: %v", err)
+	}
+
+	// Overlapping key sets can't be acquired while held
+	if _, ok, err := store.TryLockKey([]byte("relationship:1"), []byte("other")); err != nil || ok {
+		t.Fatalf("Expected TryLockKey to fail, got ok=%v err=%v", ok, err)
+	}
+
+	// A failed TryLockKey must not leave "other" locked
+	other, ok, err := store.TryLockKey([]byte("other"))
+	if err != nil || !ok {
+		t.Fatalf("Expected to lock unrelated key, got ok=%v err=%v", ok, err)
+	}
+	other.Unlock()
+
+	lock.Unlock()
+	lock.Unlock() // idempotent
+
+	if len(store.keyLocks.locks) != 0 {
+		t.Errorf("Expected lock table to be empty, got %d entries", len(store.keyLocks.locks))
+	}
+
+	if _, err := store.LockKey(); err != ErrInvalidKey {
+		t.Errorf("Expected ErrInvalidKey for empty key set, got %v", err)
+	}
+}
+
+func TestKVStore_LockKey_NoDeadlock(t *testing.T) {
+	store, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+
+	a, b := []byte("a"), []byte("b")
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	// Opposite argument orders would deadlock without sorted acquisition
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			lock, _ := store.LockKey(a, b)
+			lock.Unlock()
+		}()
+		go func() {
+			defer wg.Done()
+			lock, _ := store.LockKey(b, a)
+			lock.Unlock()
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Lock acquisition deadlocked")
+	}
+}
@@ -3,26 +3,92 @@ package store
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/ssargent/freyjadb/pkg/codec"
 )
 
+// activeDataFile is the file name of the segment currently being written
+const activeDataFile = "active.data"
+
 // KVStore provides the main key-value store interface
 type KVStore struct {
 	config   KVStoreConfig
 	writer   *LogWriter
 	reader   *LogReader
+	codec    *codec.RecordCodec // Encrypts values if configured
 	index    *HashIndex
 	dataFile string
 	mutex    sync.Mutex
 	isOpen   bool
+	dirLock  *dirLock // Held on DataDir while the store can write
+
+	// maintenance is read-held while the store is frozen and write-held by
+	// segment rotation/compaction so backups see a stable set of files.
+	// freezeMutex guards freezeCount, so only the outermost Freeze and Thaw
+	// take and release the read lock.
+	maintenance sync.RWMutex
+	freezeMutex sync.Mutex
+	freezeCount int
+
+	// writeGate is read-held by every write and write-held by Quiesce, so
+	// a quiesce blocks new writes without blocking reads
+	writeGate sync.RWMutex
+	quiesced  bool
+
+	keyLocks  *keyLockTable             // Advisory per-key locks for embedding applications
+	sequences map[string]*sequenceRange // Sequence name -> IDs reserved but not yet handed out
+	placer    *dirPlacer                // Chooses data directories for segments
+	segments  *segmentTable             // FileID -> segment location and access time
+	manifest  manifestState             // Last MANIFEST written
+
+	archiveStop chan struct{} // Stops the background archiver
+	standby     standbyState  // Set while following another process's writes (OpenStandby)
+
+	indexLoaded bool // False until the index is built (deferred by IndexLoadLazy)
+
+	// Index entries repaired by reads, and repairs that failed
+	readRepairs        int64
+	readRepairFailures int64
+
+	dedupedWrites int64 // Puts skipped by DedupeWrites
+
+	scanStats ScanStats // Reads of finished prefix iterators
+
+	io *ioScheduler // Admits background work around client reads and writes
+
+	memtable        *memtable // Recent writes in WriteModeMemtable, nil in append mode
+	memtableFlushes int64
+	rotations       int64 // Active logs sealed into segments at MaxSegmentSize
+
+	// Explain diagnostics since the store was opened
+	openedAt  time.Time
+	openSize  int64 // Active segment size at open, to derive bytes written
+	crcErrors int64 // Corrupt records truncated by recovery
+	gets      int64
+	getNanos  int64
+	bytesRead int64
+	access    accessStats // Decayed point reads per prefix, for the Explain advisory
+	writes    writeStats  // Windowed writes per prefix and the prefix write limits
+
+	compactionFilters []CompactionFilter // Run by Compact in order
+
+	lastRecovery *RecoveryResult // What the last Open recovered
+
+	watchers map[*Watcher]struct{} // Subscribers to writes, see Watch
+	caches   map[*Cache]struct{}   // Read-through caches invalidated by writes, see NewCache
+
+	writeStalls writeStalls  // Write phase timings
+	writeTiming *writeTiming // Phases of the write in progress, nil outside commit
+
+	stats StatsRecorder // config.Stats, or a no-op recorder
 }
 
 // NewKVStore creates a new key-value store instance
@@ -32,13 +98,56 @@ func NewKVStore(config KVStoreConfig) (*KVStore, error) {
 		return nil, err
 	}
 
-	dataFile := filepath.Join(config.DataDir, "active.data")
+	// Reuse the active segment wherever it lives, otherwise place a new one
+	placer := newDirPlacer(config.DataDir, config.DataDirs, config.Placement)
+	placer.Check()
+	dir, found := placer.Locate(activeDataFile)
+	if !found {
+		var err error
+		if dir, err = placer.Next(); err != nil {
+			return nil, err
+		}
+	}
+	dataFile := filepath.Join(dir, activeDataFile)
+
+	stats := config.Stats
+	if stats == nil {
+		stats = NopStatsRecorder{}
+	}
+
+	recordCodec, err := codec.NewRecordCodecWithCompression(config.Compression)
+	if err != nil {
+		return nil, err
+	}
+	if config.Encryption != nil {
+		if recordCodec, err = codec.NewRecordCodecWithEncryption(config.Encryption, config.Compression); err != nil {
+			return nil, err
+		}
+	}
+
+	writes, err := newWriteStats(config.WriteLimits)
+	if err != nil {
+		return nil, err
+	}
 
 	store := &KVStore{
-		config:   config,
-		dataFile: dataFile,
-		index:    NewHashIndex(HashIndexConfig{}),
-		isOpen:   false,
+		config:    config,
+		codec:     recordCodec,
+		stats:     stats,
+		dataFile:  dataFile,
+		index:     NewHashIndex(HashIndexConfig{InternalKeyspaces: config.InternalKeyspaces}),
+		keyLocks:  newKeyLockTable(),
+		sequences: make(map[string]*sequenceRange),
+		placer:    placer,
+		segments:  newSegmentTable(dataFile),
+		io:        newIOScheduler(config.BackgroundLatencyTarget, config.MinBackgroundRate),
+		writes:    writes,
+		isOpen:    false,
+	}
+	for _, filter := range config.CompactionFilters {
+		if err := store.registerCompactionFilterInternal(filter); err != nil {
+			return nil, err
+		}
 	}
 
 	return store, nil
@@ -46,6 +155,13 @@ func NewKVStore(config KVStoreConfig) (*KVStore, error) {
 
 // Open initializes the store and loads existing data with crash recovery
 func (kv *KVStore) Open() (*RecoveryResult, error) {
+	return kv.OpenContext(context.Background())
+}
+
+// OpenContext is Open with a context that can cancel a long-running
+// recovery. A canceled recovery leaves the data file untouched, so the store
+// can be reopened later, possibly with a different recovery policy.
+func (kv *KVStore) OpenContext(ctx context.Context) (*RecoveryResult, error) {
 	kv.mutex.Lock()
 	defer kv.mutex.Unlock()
 
@@ -60,17 +176,76 @@ func (kv *KVStore) Open() (*RecoveryResult, error) {
 		}, nil
 	}
 
-	// Validate log file and recover from corruption
-	recoveryResult, err := kv.validateLogFile(kv.dataFile)
+	switch kv.config.WriteMode {
+	case WriteModeAppend, WriteModeMemtable:
+	default:
+		return nil, &KVError{fmt.Sprintf("unknown write mode %q", kv.config.WriteMode)}
+	}
+
+	switch kv.config.OpenMode {
+	case OpenReadWrite:
+	case OpenStandby:
+		return kv.openStandbyInternal(ctx)
+	default:
+		return nil, &KVError{fmt.Sprintf("unknown open mode %q", kv.config.OpenMode)}
+	}
+
+	// Keep other writers out of the directory until Close
+	lock, err := acquireDirLock(kv.config.DataDir, kv.config.LockTimeout)
 	if err != nil {
 		return nil, err
 	}
+	kv.dirLock = lock
+	defer func() {
+		if !kv.isOpen {
+			kv.releaseDirLockInternal()
+		}
+	}()
+
+	// Clear out half-written files, then learn the live segments
+	if err := kv.removeStaleTempFiles(); err != nil {
+		return nil, err
+	}
+	if err := kv.loadManifestInternal(); err != nil {
+		return nil, err
+	}
+	if err := kv.finishRotationInternal(); err != nil {
+		return nil, err
+	}
+
+	// After a clean shutdown the log needs no validation and the index is
+	// loaded from the hints; otherwise validate the log and recover from
+	// corruption
+	hintsStart := time.Now()
+	hints, err := kv.loadShutdownHintsInternal()
+	if err != nil {
+		return nil, err
+	}
+	var recoveryResult *RecoveryResult
+	if hints != nil {
+		recoveryResult = &RecoveryResult{
+			FileSizeBefore: hints.LogSize,
+			FileSizeAfter:  hints.LogSize,
+			RecoveryTime:   time.Since(hintsStart).Nanoseconds(),
+			CleanShutdown:  true,
+		}
+	} else if recoveryResult, err = kv.validateLogFile(ctx, kv.dataFile); err != nil {
+		return nil, err
+	}
+	recoveryResult.StartedAt = hintsStart
+	recoveryResult.Policy = RecoveryPolicy{
+		IndexLoad:      kv.config.IndexLoad,
+		FastRestart:    kv.config.FastRestart,
+		RecoveryBudget: kv.config.RecoveryBudget,
+	}
 
 	// Create log writer
 	writerConfig := LogWriterConfig{
-		FilePath:      kv.dataFile,
-		FsyncInterval: kv.config.FsyncInterval,
-		BufferSize:    64 * 1024, // 64KB buffer
+		FilePath:          kv.dataFile,
+		FsyncInterval:     kv.config.FsyncInterval,
+		BufferSize:        64 * 1024, // 64KB buffer
+		GroupCommitWindow: kv.config.GroupCommitWindow,
+		Codec:             kv.codec,
 	}
 	writer, err := NewLogWriter(writerConfig)
 	if err != nil {
@@ -82,6 +257,7 @@ func (kv *KVStore) Open() (*RecoveryResult, error) {
 	readerConfig := LogReaderConfig{
 		FilePath:    kv.dataFile,
 		StartOffset: 0,
+		Codec:       kv.codec,
 	}
 	reader, err := NewLogReader(readerConfig)
 	if err != nil {
@@ -92,35 +268,92 @@ func (kv *KVStore) Open() (*RecoveryResult, error) {
 	}
 	kv.reader = reader
 
-	// Build index from validated data
-	if err := kv.index.BuildFromLog(kv.reader); err != nil {
-		if closeErr := kv.reader.Close(); closeErr != nil {
-			fmt.Fprintf(os.Stderr, "Error closing reader: %v\n", closeErr)
+	kv.memtable = nil
+	if kv.config.WriteMode == WriteModeMemtable {
+		if err := kv.loadMemtableInternal(); err != nil {
+			kv.reader.Close()
+			kv.writer.Close()
+			return nil, fmt.Errorf("failed to load memtable: %w", err)
 		}
-		if closeErr := kv.writer.Close(); closeErr != nil {
-			fmt.Fprintf(os.Stderr, "Error closing writer: %v\n", closeErr)
+	}
+
+	kv.indexLoaded = hints != nil
+	recoveryResult.IndexRebuilt = hints == nil && kv.config.IndexLoad != IndexLoadLazy
+
+	// Build index from validated data unless it is deferred to first use
+	if recoveryResult.IndexRebuilt {
+		if err := kv.buildIndexInternal(); err != nil {
+			if closeErr := kv.reader.Close(); closeErr != nil {
+				fmt.Fprintf(os.Stderr, "Error closing reader: %v\n", closeErr)
+			}
+			if closeErr := kv.writer.Close(); closeErr != nil {
+				fmt.Fprintf(os.Stderr, "Error closing writer: %v\n", closeErr)
+			}
+			return nil, err
 		}
-		return nil, err
+		kv.indexLoaded = true
 	}
+	if kv.indexLoaded {
+		warmed, err := kv.warmupInternal()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error warming cache: %v\n", err)
+		}
+		recoveryResult.WarmupBytes = warmed
+	}
+
+	kv.openedAt = time.Now()
+	kv.openSize = kv.writer.Size()
+	kv.crcErrors = recoveryResult.RecordsTruncated
+	kv.lastRecovery = recoveryResult
+	kv.gets, kv.getNanos, kv.bytesRead = 0, 0, 0
+	kv.access.reset()
+	kv.writes.reset()
+	kv.writeStalls.reset()
 
 	kv.isOpen = true
+	kv.startArchiverInternal()
 	return recoveryResult, nil
 }
 
 // Get retrieves a value for a key
 func (kv *KVStore) Get(key []byte) ([]byte, error) {
+	start := time.Now()
+	done := kv.io.foreground()
+	value, err := kv.get(key)
+	done()
+	kv.stats.Count(StatGets, 1)
+	if err == ErrKeyNotFound {
+		kv.stats.Count(StatGetMisses, 1)
+	}
+	kv.stats.Observe(StatGetSeconds, time.Since(start).Seconds())
+	return value, err
+}
+
+// get is Get without the stats
+func (kv *KVStore) get(key []byte) ([]byte, error) {
 	kv.mutex.Lock()
 	defer kv.mutex.Unlock()
 
-	if !kv.isOpen {
-		return nil, &KVError{"store is not open"}
+	if err := kv.checkOpenInternal(); err != nil {
+		return nil, err
+	}
+
+	// Recent writes are served from the memtable without touching the disk
+	if value, ok := kv.memtable.get(key); ok {
+		if value == nil {
+			return nil, ErrKeyNotFound
+		}
+		kv.access.record(key, kv.index.delimiter, time.Now())
+		return append([]byte(nil), value...), nil
 	}
 
 	// Use index for O(1) lookup
+	now := time.Now()
 	entry, exists := kv.index.Get(key)
-	if !exists {
+	if !exists || entry.expired(now) {
 		return nil, ErrKeyNotFound
 	}
+	kv.access.record(key, kv.index.delimiter, now)
 
 	// Force sync to ensure all buffered writes are on disk
 	if err := kv.writer.Sync(); err != nil {
@@ -128,10 +361,15 @@ func (kv *KVStore) Get(key []byte) ([]byte, error) {
 	}
 
 	// Read record directly from the stored offset
-	record, err := kv.reader.ReadAt(entry.Offset)
+	start := time.Now()
+	record, err := kv.readKeyInternal(key, entry)
 	if err != nil {
 		return nil, err
 	}
+	kv.gets++
+	kv.getNanos += time.Since(start).Nanoseconds()
+	kv.bytesRead += int64(record.Size())
+	kv.stats.Count(StatBytesRead, int64(record.Size()))
 
 	// Check if it's a tombstone (empty value indicates deletion)
 	if len(record.Value) == 0 {
@@ -141,14 +379,88 @@ func (kv *KVStore) Get(key []byte) ([]byte, error) {
 	return record.Value, nil
 }
 
+// GetMany retrieves values for several keys in one pass. Reads are issued in
+// file offset order to keep disk access sequential; the returned slice is
+// aligned with keys and holds nil for keys that do not exist.
+func (kv *KVStore) GetMany(keys [][]byte) ([][]byte, error) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	return kv.getManyInternal(keys)
+}
+
+// getManyInternal is GetMany without acquiring the mutex
+func (kv *KVStore) getManyInternal(keys [][]byte) ([][]byte, error) {
+	if err := kv.checkOpenInternal(); err != nil {
+		return nil, err
+	}
+
+	type pendingRead struct {
+		pos   int
+		key   []byte
+		entry *IndexEntry
+	}
+
+	now := time.Now()
+	reads := make([]pendingRead, 0, len(keys))
+	for i, key := range keys {
+		if entry, exists := kv.index.Get(key); exists && !entry.expired(now) {
+			reads = append(reads, pendingRead{pos: i, key: key, entry: entry})
+			kv.access.record(key, kv.index.delimiter, now)
+		}
+	}
+
+	values := make([][]byte, len(keys))
+	if len(reads) == 0 {
+		return values, nil
+	}
+
+	// Force sync once so every buffered write is visible to the reader
+	if err := kv.writer.Sync(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(reads, func(i, j int) bool {
+		if reads[i].entry.FileID != reads[j].entry.FileID {
+			return reads[i].entry.FileID < reads[j].entry.FileID
+		}
+		return reads[i].entry.Offset < reads[j].entry.Offset
+	})
+
+	files := kv.newSegmentFiles()
+	defer files.close()
+
+	for _, read := range reads {
+		record, err := kv.readKeyFromInternal(read.key, read.entry, files)
+		if err == ErrKeyNotFound {
+			continue // Repair found the key deleted
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record.Value) == 0 {
+			continue // Tombstone
+		}
+		values[read.pos] = record.Value
+	}
+
+	return values, nil
+}
+
 // putInternal stores a key-value pair without acquiring the mutex
 // This is for internal use when the mutex is already held
 func (kv *KVStore) putInternal(key, value []byte) error {
-	if !kv.isOpen {
-		return &KVError{"store is not open"}
+	return kv.putExpiringInternal(key, value, 0)
+}
+
+// putExpiringInternal is putInternal for a pair that expires at expiresAt,
+// in Unix nanoseconds (0 = never)
+func (kv *KVStore) putExpiringInternal(key, value []byte, expiresAt uint64) error {
+	if err := kv.checkOpenInternal(); err != nil {
+		return err
 	}
 
-	if len(key) == 0 {
+	if len(key) == 0 || codec.IsReservedKey(key) {
 		return ErrInvalidKey
 	}
 
@@ -157,9 +469,28 @@ func (kv *KVStore) putInternal(key, value []byte) error {
 	if kv.config.MaxRecordSize > 0 && recordSize > kv.config.MaxRecordSize {
 		return ErrRecordSizeExceeded
 	}
+	if err := kv.admitWritesInternal(key); err != nil {
+		return err
+	}
+
+	var hash uint64
+	if kv.config.DedupeWrites && len(value) > 0 && expiresAt == 0 {
+		hash = valueHash(value)
+		if kv.unchangedInternal(key, value, hash) {
+			kv.dedupedWrites++
+			return nil
+		}
+	}
 
+	return kv.appendInternal(key, value, expiresAt, hash)
+}
+
+// appendInternal writes a validated pair to the log and indexes it; hash is
+// what DedupeWrites compares later writes of the key with (caller must hold
+// the mutex)
+func (kv *KVStore) appendInternal(key, value []byte, expiresAt, hash uint64) error {
 	// Write record to log
-	offset, err := kv.writer.Put(key, value)
+	offset, size, err := kv.writer.putExpiring(key, value, expiresAt, kv.writeTiming)
 	if err != nil {
 		return err
 	}
@@ -167,12 +498,23 @@ func (kv *KVStore) putInternal(key, value []byte) error {
 	// Update index
 	record := codec.NewRecord(key, value)
 	entry := &IndexEntry{
-		FileID:    0,                     // Single file for now
-		Offset:    offset,                // LogWriter.Put() returns the starting offset
-		Size:      uint32(record.Size()), //nolint: gosec // Size is uint32
+		FileID:    activeFileID,
+		Offset:    offset, // LogWriter.Put() returns the starting offset
+		Size:      size,
 		Timestamp: record.Timestamp,
+		ValueHash: hash,
+		ExpiresAt: expiresAt,
 	}
 	kv.index.Put(key, entry)
+	kv.stats.Count(StatPuts, 1)
+	kv.stats.Count(StatBytesWritten, int64(size))
+	kv.writes.record(key, kv.index.delimiter, time.Now())
+	kv.publishInternal(key, value, record.Timestamp)
+
+	if kv.memtable != nil {
+		kv.memtable.put(key, value, offset, entry.Size, expiresAt)
+		kv.maybeFlushMemtableInternal()
+	}
 
 	return nil
 }
@@ -180,8 +522,8 @@ func (kv *KVStore) putInternal(key, value []byte) error {
 // deleteInternal removes a key-value pair without acquiring the mutex
 // This is for internal use when the mutex is already held
 func (kv *KVStore) deleteInternal(key []byte) error {
-	if !kv.isOpen {
-		return &KVError{"store is not open"}
+	if err := kv.checkOpenInternal(); err != nil {
+		return err
 	}
 
 	if len(key) == 0 {
@@ -189,78 +531,106 @@ func (kv *KVStore) deleteInternal(key []byte) error {
 	}
 
 	// Write tombstone record (empty value)
-	_, err := kv.writer.Put(key, []byte{})
+	offset, err := kv.writer.put(key, []byte{}, kv.writeTiming)
 	if err != nil {
 		return err
 	}
 
 	// Remove from index
-	kv.index.Delete(key)
+	record := codec.NewRecord(key, nil)
+	size := int64(record.Size())
+	kv.index.DeleteAt(key, record.Timestamp)
+	kv.index.AddTombstone(size)
+	kv.stats.Count(StatDeletes, 1)
+	kv.stats.Count(StatBytesWritten, size)
+	kv.writes.record(key, kv.index.delimiter, time.Now())
+	kv.publishInternal(key, nil, record.Timestamp)
+
+	if kv.memtable != nil {
+		kv.memtable.put(key, nil, offset, uint32(size), 0) //nolint:gosec // record sizes fit in uint32
+		kv.maybeFlushMemtableInternal()
+	}
 
 	return nil
 }
 
 // Put stores a key-value pair
 func (kv *KVStore) Put(key, value []byte) error {
-	kv.mutex.Lock()
-	defer kv.mutex.Unlock()
+	return kv.commit(func() error { return kv.putInternal(key, value) })
+}
 
-	if !kv.isOpen {
-		return &KVError{"store is not open"}
+// UpdateFunc computes a new value from the current one. old is nil when the
+// key does not exist. Returning a nil value deletes the key; returning an
+// error aborts the update without writing anything.
+type UpdateFunc func(old []byte) ([]byte, error)
+
+// Update atomically applies fn to the current value of key and stores the
+// result. The store lock is held for the duration of fn, so concurrent
+// writers cannot interleave between the read and the write. fn must not call
+// back into the store.
+func (kv *KVStore) Update(key []byte, fn UpdateFunc) error {
+	return kv.commit(func() error { return kv.updateInternal(key, fn) })
+}
+
+// updateInternal applies an update without acquiring the mutex
+func (kv *KVStore) updateInternal(key []byte, fn UpdateFunc) error {
+	if err := kv.checkOpenInternal(); err != nil {
+		return err
 	}
 
 	if len(key) == 0 {
 		return ErrInvalidKey
 	}
 
-	// Validate record size
-	recordSize := len(key) + len(value)
-	if kv.config.MaxRecordSize > 0 && recordSize > kv.config.MaxRecordSize {
-		return ErrRecordSizeExceeded
+	// Make sure buffered writes are visible before reading the old value
+	if err := kv.writer.Sync(); err != nil {
+		return err
 	}
 
-	// Write record to log
-	offset, err := kv.writer.Put(key, value)
+	old, err := kv.getInternal(key)
+	if err != nil && err != ErrKeyNotFound {
+		return err
+	}
+
+	updated, err := fn(old)
 	if err != nil {
 		return err
 	}
 
-	// Update index
-	record := codec.NewRecord(key, value)
-	entry := &IndexEntry{
-		FileID:    0,                     // Single file for now
-		Offset:    offset,                // LogWriter.Put() returns the starting offset
-		Size:      uint32(record.Size()), //nolint: gosec // Size is uint32
-		Timestamp: record.Timestamp,
+	if updated == nil {
+		if old == nil {
+			return nil // Nothing to delete
+		}
+		if err := kv.admitWritesInternal(key); err != nil {
+			return err
+		}
+		return kv.deleteInternal(key)
 	}
-	kv.index.Put(key, entry)
 
-	return nil
+	return kv.putInternal(key, updated)
 }
 
 // Delete removes a key-value pair (tombstone)
 func (kv *KVStore) Delete(key []byte) error {
+	return kv.commit(func() error {
+		if err := kv.admitWritesInternal(key); err != nil {
+			return err
+		}
+		return kv.deleteInternal(key)
+	})
+}
+
+// LastRecovery returns what the most recent Open recovered, or nil if the
+// store has not been opened read-write
+func (kv *KVStore) LastRecovery() *RecoveryResult {
 	kv.mutex.Lock()
 	defer kv.mutex.Unlock()
 
-	if !kv.isOpen {
-		return &KVError{"store is not open"}
-	}
-
-	if len(key) == 0 {
-		return ErrInvalidKey
-	}
-
-	// Write tombstone record (empty value)
-	_, err := kv.writer.Put(key, []byte{})
-	if err != nil {
-		return err
+	if kv.lastRecovery == nil {
+		return nil
 	}
-
-	// Remove from index
-	kv.index.Delete(key)
-
-	return nil
+	result := *kv.lastRecovery
+	return &result
 }
 
 // Close shuts down the store
@@ -273,6 +643,12 @@ func (kv *KVStore) Close() error {
 	}
 
 	kv.isOpen = false
+	defer kv.releaseDirLockInternal()
+	kv.closeWatchersInternal(&KVError{"store is closed"})
+	kv.purgeCachesInternal()
+	kv.stopArchiverInternal()
+	kv.stopTailerInternal()
+	kv.closeTailInternal()
 
 	// Close writer first (ensures all data is flushed)
 	if kv.writer != nil {
@@ -289,11 +665,17 @@ func (kv *KVStore) Close() error {
 		}
 	}
 
+	// Only a shutdown that got this far is clean; failing to record it just
+	// means the next Open validates the log
+	if err := kv.writeShutdownHintsInternal(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error recording clean shutdown: %v\n", err)
+	}
+
 	return nil
 }
 
 // validateLogFile validates the log file integrity and truncates corrupted records
-func (kv *KVStore) validateLogFile(filePath string) (*RecoveryResult, error) {
+func (kv *KVStore) validateLogFile(ctx context.Context, filePath string) (*RecoveryResult, error) {
 	startTime := time.Now()
 
 	// Check if file exists and get initial stats
@@ -307,12 +689,14 @@ func (kv *KVStore) validateLogFile(filePath string) (*RecoveryResult, error) {
 	}
 
 	fileSizeBefore := fileInfo.Size()
+	tracker := &recoveryTracker{ctx: ctx, config: &kv.config, start: startTime, totalBytes: fileSizeBefore}
 
 	// Scan for corruption
-	recordsValidated, lastValidOffset, corruptionFound, err := kv.scanForCorruption(filePath)
+	recordsValidated, lastValidOffset, corruptionFound, err := kv.scanForCorruption(filePath, tracker)
 	if err != nil {
 		return nil, err
 	}
+	tracker.report(fileSizeBefore, recordsValidated, true)
 
 	// Handle corruption recovery if needed
 	fileSizeAfter, recordsTruncated, err := kv.handleCorruptionRecovery(
@@ -344,10 +728,11 @@ func (kv *KVStore) createEmptyRecoveryResult(startTime time.Time) *RecoveryResul
 }
 
 // scanForCorruption scans the log file for corruption and returns validation results
-func (kv *KVStore) scanForCorruption(filePath string) (int64, int64, bool, error) {
+func (kv *KVStore) scanForCorruption(filePath string, tracker *recoveryTracker) (int64, int64, bool, error) {
 	reader, err := NewLogReader(LogReaderConfig{
 		FilePath:    filePath,
 		StartOffset: 0,
+		Codec:       kv.codec,
 	})
 	if err != nil {
 		return 0, -1, false, err
@@ -370,6 +755,10 @@ func (kv *KVStore) scanForCorruption(filePath string) (int64, int64, bool, error
 			if err == io.EOF {
 				break // End of file reached
 			}
+			if errors.Is(err, codec.ErrDecrypt) {
+				// The record is intact; truncating it would lose data
+				return recordsValidated, lastValidOffset, false, err
+			}
 			// Corruption detected
 			corruptionFound = true
 			break
@@ -383,6 +772,10 @@ func (kv *KVStore) scanForCorruption(filePath string) (int64, int64, bool, error
 
 		recordsValidated++
 		lastValidOffset = reader.Offset()
+
+		if err := tracker.update(lastValidOffset, recordsValidated); err != nil {
+			return recordsValidated, lastValidOffset, false, err
+		}
 	}
 
 	return recordsValidated, lastValidOffset, corruptionFound, nil
@@ -435,67 +828,88 @@ func (kv *KVStore) Stats() *StoreStats {
 	kv.mutex.Lock()
 	defer kv.mutex.Unlock()
 
-	if !kv.isOpen {
+	if err := kv.checkOpenInternal(); err != nil {
 		return &StoreStats{}
 	}
 
+	indexStats := kv.index.Stats()
 	return &StoreStats{
-		Keys:     kv.index.Size(),
-		DataSize: kv.writer.Size(),
+		Keys:                    indexStats.TotalKeys,
+		UserKeys:                indexStats.TotalKeys - indexStats.InternalKeys,
+		InternalKeys:            indexStats.InternalKeys,
+		Tombstones:              indexStats.Tombstones,
+		DataSize:                kv.dataSizeInternal(),
+		LiveDataSize:            indexStats.LiveBytes,
+		UserBytes:               indexStats.LiveBytes - indexStats.InternalBytes,
+		InternalBytes:           indexStats.InternalBytes,
+		TombstoneBytes:          indexStats.TombstoneBytes,
+		IndexKeyBytes:           indexStats.KeyBytes,
+		IndexCompressedKeyBytes: indexStats.CompressedKeyBytes,
+		ReadRepairs:             kv.readRepairs,
+		ReadRepairFailures:      kv.readRepairFailures,
+		DedupedWrites:           kv.dedupedWrites,
+		Fsyncs:                  kv.writer.Fsyncs(),
+		MemtableKeys:            kv.memtable.len(),
+		MemtableBytes:           kv.memtable.size(),
+		MemtableFlushes:         kv.memtableFlushes,
+		Rotations:               kv.rotations,
+		Scans:                   kv.scanStats,
+		IO:                      kv.io.snapshot(),
+		PrefixWrites:            kv.prefixWriteStatsInternal(),
 	}
 }
 
 // StoreStats holds statistics about the store
 type StoreStats struct {
-	Keys     int
-	DataSize int64
-}
-
-// Explain gathers diagnostic information about the store
-func (kv *KVStore) Explain(ctx context.Context, opts ExplainOptions) (*ExplainResult, error) {
-	kv.mutex.Lock()
-	defer kv.mutex.Unlock()
-
-	if !kv.isOpen {
-		return nil, &KVError{"store is not open"}
-	}
-
-	res := &ExplainResult{}
-	res.Global.TotalKeys = kv.index.Size()
-	res.Global.ActiveKeys = kv.index.Size() // TODO: Subtract tombstones
-	res.Global.Tombstones = 0               // TODO: Count tombstones
-	res.Global.TotalSizeMB = float64(kv.writer.Size()) / (1024 * 1024)
-	res.Global.LiveSizeMB = res.Global.TotalSizeMB // TODO: Calculate live size
-	res.Global.Uptime = time.Since(time.Now())     // TODO: Track start time
-	res.Global.IndexMemoryMB = 0                   // TODO: Estimate index memory
-
-	// Segments (stub for now)
-	res.Segments = []Segment{
-		{ID: "active", Keys: kv.index.Size(), DeadPct: 0.0, SizeMB: res.Global.TotalSizeMB},
-	}
-
-	// Partitions (stub)
-	res.Partitions = map[string]PKStats{}
-
-	// Samples
-	if opts.WithSamples > 0 {
-		// TODO: Sample actual records
-		res.Diagnostics.Samples = []Sample{}
-	}
-
-	// Warnings
-	if opts.PK != "" {
-		res.Warnings = append(res.Warnings, fmt.Sprintf("Partition filtering not implemented for PK: %s", opts.PK))
-	}
-
-	res.Diagnostics.CRCErrors = 0
-
-	if opts.WithMetrics {
-		res.Diagnostics.Metrics.AvgGetLatencyMs = 0 // TODO: Track metrics
-		res.Diagnostics.Metrics.IORateMBs = 0
-	}
-
-	return res, nil
+	Keys         int // Every indexed key: UserKeys plus InternalKeys
+	DataSize     int64
+	LiveDataSize int64 // Bytes of live records; DataSize minus this is reclaimable by compaction
+
+	// Keys and live record bytes split into user data and FreyjaDB's own
+	// bookkeeping (InternalKeyspaces), and tombstones awaiting compaction
+	UserKeys       int
+	InternalKeys   int
+	Tombstones     int
+	UserBytes      int64
+	InternalBytes  int64
+	TombstoneBytes int64
+
+	// Index key memory before and after prefix compression
+	IndexKeyBytes           int64
+	IndexCompressedKeyBytes int64
+
+	// Reads that found a corrupt or mismatched record and repaired the
+	// index entry from the log, and reads whose repair failed
+	ReadRepairs        int64
+	ReadRepairFailures int64
+
+	// Puts skipped because the key already held the value (DedupeWrites)
+	DedupedWrites int64
+
+	// Fsyncs of the active log since the store was opened; with group
+	// commit many writes share each one
+	Fsyncs int64
+
+	// Keys and record bytes held by the memtable, and flushes since the
+	// store was opened (WriteModeMemtable)
+	MemtableKeys    int
+	MemtableBytes   int64
+	MemtableFlushes int64
+
+	// Active logs sealed into segments since the store was opened
+	// (MaxSegmentSize)
+	Rotations int64
+
+	// Values read by finished prefix iterators, and how well readahead
+	// served them
+	Scans ScanStats
+
+	// Client operations and the background work scheduled around them
+	IO IOStats
+
+	// Windowed writes of the busiest key prefixes and of every prefix with
+	// a write limit, see WriteStats
+	PrefixWrites []PrefixWriteStats
 }
 
 // KeyValuePair represents a key-value pair for scanning operations
@@ -509,79 +923,35 @@ func (kv *KVStore) ListKeys(prefix []byte) ([]string, error) {
 	kv.mutex.Lock()
 	defer kv.mutex.Unlock()
 
-	if !kv.isOpen {
-		return nil, &KVError{"store is not open"}
+	if err := kv.checkOpenInternal(); err != nil {
+		return nil, err
 	}
 
 	prefixStr := string(prefix)
-	return kv.index.KeysWithPrefix(prefixStr), nil
-}
-
-// ScanPrefix returns a channel of key-value pairs that match the prefix
-func (kv *KVStore) ScanPrefix(prefix []byte) (<-chan KeyValuePair, error) {
-	kv.mutex.Lock()
-	defer kv.mutex.Unlock()
-
-	if !kv.isOpen {
-		return nil, &KVError{"store is not open"}
-	}
-
-	ch := make(chan KeyValuePair, 100)
-
-	go func() {
-		defer close(ch)
-
-		prefixStr := string(prefix)
-		keyChan := kv.index.ScanPrefix(prefixStr)
-
-		for keyStr := range keyChan {
-			// Get the value for this key
-			key := []byte(keyStr)
-			entry, exists := kv.index.Get(key)
-			if !exists {
-				continue // Key was deleted while scanning
-			}
-
-			// Read the record from disk
-			record, err := kv.reader.ReadAt(entry.Offset)
-			if err != nil {
-				continue // Skip corrupted records
-			}
-
-			// Skip tombstones
-			if len(record.Value) == 0 {
-				continue
-			}
-
-			select {
-			case ch <- KeyValuePair{Key: key, Value: record.Value}:
-			case <-ch: // Channel closed by receiver
-				return
-			}
-		}
-	}()
-
-	return ch, nil
+	return kv.unexpiredKeys(kv.index.KeysWithPrefix(prefixStr)), nil
 }
 
 // listKeysInternal returns all keys that match the given prefix without acquiring the mutex
 // This is for internal use when the mutex is already held
 func (kv *KVStore) listKeysInternal(prefix []byte) ([]string, error) {
-	if !kv.isOpen {
-		return nil, &KVError{"store is not open"}
+	if err := kv.checkOpenInternal(); err != nil {
+		return nil, err
 	}
 
 	prefixStr := string(prefix)
-	return kv.index.KeysWithPrefix(prefixStr), nil
+	return kv.unexpiredKeys(kv.index.KeysWithPrefix(prefixStr)), nil
 }
 
 // PutRelationship creates a relationship between two entities
 func (kv *KVStore) PutRelationship(fromKey, toKey, relation string) error {
-	kv.mutex.Lock()
-	defer kv.mutex.Unlock()
+	return kv.commit(func() error { return kv.putRelationshipInternal(fromKey, toKey, relation) })
+}
 
-	if !kv.isOpen {
-		return &KVError{"store is not open"}
+// putRelationshipInternal stores both directions of a relationship without
+// acquiring the mutex
+func (kv *KVStore) putRelationshipInternal(fromKey, toKey, relation string) error {
+	if err := kv.checkOpenInternal(); err != nil {
+		return err
 	}
 
 	// Validate that both entities exist
@@ -589,31 +959,31 @@ func (kv *KVStore) PutRelationship(fromKey, toKey, relation string) error {
 		return err
 	}
 
-	// Create relationship object
-	relationship := &Relationship{
+	return kv.writeRelationshipInternal(&Relationship{
 		FromKey:   fromKey,
 		ToKey:     toKey,
 		Relation:  relation,
 		CreatedAt: time.Now(),
-	}
+	})
+}
 
-	// Store forward relationship
-	forwardKey := makeRelationshipKey("forward", fromKey, relation, toKey)
-	forwardData, err := json.Marshal(relationship)
+// writeRelationshipInternal stores the forward and reverse entries of an
+// already validated relationship without acquiring the mutex
+func (kv *KVStore) writeRelationshipInternal(relationship *Relationship) error {
+	data, err := json.Marshal(relationship)
 	if err != nil {
 		return fmt.Errorf("failed to marshal relationship: %w", err)
 	}
-	if err := kv.putInternal([]byte(forwardKey), forwardData); err != nil {
+
+	// Store forward relationship
+	forwardKey := makeRelationshipKey("forward", relationship.FromKey, relationship.Relation, relationship.ToKey)
+	if err := kv.putInternal([]byte(forwardKey), data); err != nil {
 		return fmt.Errorf("failed to store forward relationship: %w", err)
 	}
 
 	// Store reverse relationship
-	reverseKey := makeRelationshipKey("reverse", toKey, relation, fromKey)
-	reverseData, err := json.Marshal(relationship)
-	if err != nil {
-		return fmt.Errorf("failed to marshal reverse relationship: %w", err)
-	}
-	if err := kv.putInternal([]byte(reverseKey), reverseData); err != nil {
+	reverseKey := makeRelationshipKey("reverse", relationship.ToKey, relationship.Relation, relationship.FromKey)
+	if err := kv.putInternal([]byte(reverseKey), data); err != nil {
 		return fmt.Errorf("failed to store reverse relationship: %w", err)
 	}
 
@@ -622,11 +992,14 @@ func (kv *KVStore) PutRelationship(fromKey, toKey, relation string) error {
 
 // DeleteRelationship removes a relationship between two entities
 func (kv *KVStore) DeleteRelationship(fromKey, toKey, relation string) error {
-	kv.mutex.Lock()
-	defer kv.mutex.Unlock()
+	return kv.commit(func() error { return kv.deleteRelationshipInternal(fromKey, toKey, relation) })
+}
 
-	if !kv.isOpen {
-		return &KVError{"store is not open"}
+// deleteRelationshipInternal removes both directions of a relationship
+// without acquiring the mutex
+func (kv *KVStore) deleteRelationshipInternal(fromKey, toKey, relation string) error {
+	if err := kv.checkOpenInternal(); err != nil {
+		return err
 	}
 
 	// Delete forward relationship
@@ -649,106 +1022,96 @@ func (kv *KVStore) GetRelationships(query RelationshipQuery) ([]RelationshipResu
 	kv.mutex.Lock()
 	defer kv.mutex.Unlock()
 
-	if !kv.isOpen {
-		return nil, &KVError{"store is not open"}
+	if err := kv.checkOpenInternal(); err != nil {
+		return nil, err
 	}
 
-	var results []RelationshipResult
 	limit := query.Limit
 	if limit == 0 {
 		limit = 100 // Default limit
 	}
 
+	var results []RelationshipResult
+
 	// Query outgoing relationships
 	if query.Direction == "outgoing" || query.Direction == "both" {
-		safeKey := strings.ReplaceAll(query.Key, ":", "|")
-		prefix := fmt.Sprintf("relationship:forward:%s", safeKey)
-		if query.Relation != "" {
-			prefix += fmt.Sprintf(":%s", query.Relation)
-		}
-
-		keys, err := kv.listKeysInternal([]byte(prefix))
+		keys, err := kv.listKeysInternal([]byte(relationshipPrefix("forward", query.Key, query.Relation)))
 		if err != nil {
 			return nil, fmt.Errorf("failed to list outgoing relationships: %w", err)
 		}
-
-		for _, key := range keys {
-			if len(results) >= limit {
-				break
-			}
-
-			data, err := kv.getInternal([]byte(key))
-			if err != nil {
-				continue // Skip if can't read
-			}
-
-			var rel Relationship
-			if err := json.Unmarshal(data, &rel); err != nil {
-				continue // Skip if can't parse
-			}
-
-			results = append(results, RelationshipResult{
-				Relationship: &rel,
-				OtherKey:     rel.ToKey,
-				Direction:    "outgoing",
-			})
+		if results, err = kv.hydrateRelationshipsInternal(results, keys, "outgoing", limit); err != nil {
+			return nil, fmt.Errorf("failed to read outgoing relationships: %w", err)
 		}
 	}
 
 	// Query incoming relationships
 	if query.Direction == "incoming" || query.Direction == "both" {
-		safeKey := strings.ReplaceAll(query.Key, ":", "|")
-		prefix := fmt.Sprintf("relationship:reverse:%s", safeKey)
-		if query.Relation != "" {
-			prefix += fmt.Sprintf(":%s", query.Relation)
-		}
-
-		keys, err := kv.listKeysInternal([]byte(prefix))
+		keys, err := kv.listKeysInternal([]byte(relationshipPrefix("reverse", query.Key, query.Relation)))
 		if err != nil {
 			return nil, fmt.Errorf("failed to list incoming relationships: %w", err)
 		}
+		if results, err = kv.hydrateRelationshipsInternal(results, keys, "incoming", limit); err != nil {
+			return nil, fmt.Errorf("failed to read incoming relationships: %w", err)
+		}
+	}
 
-		for _, key := range keys {
-			if len(results) >= limit {
-				break
-			}
+	return results, nil
+}
 
-			data, err := kv.getInternal([]byte(key))
-			if err != nil {
-				continue // Skip if can't read
-			}
+// hydrateRelationshipsInternal reads the relationship records under keys
+// in one batch, in file order, and appends them to results until it holds
+// limit entries (caller must hold the mutex)
+func (kv *KVStore) hydrateRelationshipsInternal(results []RelationshipResult, keys []string, direction string,
+	limit int) ([]RelationshipResult, error) {
+	if room := limit - len(results); len(keys) > room {
+		keys = keys[:max(room, 0)]
+	}
+	if len(keys) == 0 {
+		return results, nil
+	}
 
-			var rel Relationship
-			if err := json.Unmarshal(data, &rel); err != nil {
-				continue // Skip if can't parse
-			}
+	batch := make([][]byte, len(keys))
+	for i, key := range keys {
+		batch[i] = []byte(key)
+	}
+	values, err := kv.getManyInternal(batch)
+	if err != nil {
+		return nil, err
+	}
 
-			results = append(results, RelationshipResult{
-				Relationship: &rel,
-				OtherKey:     rel.FromKey,
-				Direction:    "incoming",
-			})
+	for _, data := range values {
+		if data == nil {
+			continue // Deleted since the keys were listed
+		}
+		var rel Relationship
+		if err := json.Unmarshal(data, &rel); err != nil {
+			continue // Skip if can't parse
 		}
-	}
 
+		other := rel.ToKey
+		if direction == "incoming" {
+			other = rel.FromKey
+		}
+		results = append(results, RelationshipResult{Relationship: &rel, OtherKey: other, Direction: direction})
+	}
 	return results, nil
 }
 
 // getInternal retrieves a value for a key without acquiring the mutex
 // This is for internal use when the mutex is already held
 func (kv *KVStore) getInternal(key []byte) ([]byte, error) {
-	if !kv.isOpen {
-		return nil, &KVError{"store is not open"}
+	if err := kv.checkOpenInternal(); err != nil {
+		return nil, err
 	}
 
 	// Use index for O(1) lookup
 	entry, exists := kv.index.Get(key)
-	if !exists {
+	if !exists || entry.expired(time.Now()) {
 		return nil, ErrKeyNotFound
 	}
 
 	// Read record directly from the stored offset
-	record, err := kv.reader.ReadAt(entry.Offset)
+	record, err := kv.readKeyInternal(key, entry)
 	if err != nil {
 		return nil, err
 	}
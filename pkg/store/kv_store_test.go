@@ -1,10 +1,17 @@
 package store
 
 import (
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/ssargent/freyjadb/pkg/codec"
 )
 
 func TestKVStore_BasicOperations(t *testing.T) {
@@ -248,6 +255,56 @@ func TestKVStore_Stats(t *testing.T) {
 	}
 }
 
+func TestKVStore_StatsCategories(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	open := func() *KVStore {
+		store, err := NewKVStore(KVStoreConfig{DataDir: tmpDir})
+		if err != nil {
+			t.Fatalf("Failed to create KV store: %v", err)
+		}
+		if _, err := store.Open(); err != nil {
+			t.Fatalf("Failed to open KV store: %v", err)
+		}
+		return store
+	}
+
+	store := open()
+	for _, k := range []string{"user:1", "user:2", "apikey:ci", "config:mode", "relationship:forward:a:b"} {
+		if err := store.Put([]byte(k), []byte("value")); err != nil {
+			t.Fatalf("Failed to put %s: %v", k, err)
+		}
+	}
+	for _, k := range []string{"user:2", "config:mode"} {
+		if err := store.Delete([]byte(k)); err != nil {
+			t.Fatalf("Failed to delete %s: %v", k, err)
+		}
+	}
+
+	check := func(stats *StoreStats) {
+		t.Helper()
+		if stats.Keys != 3 || stats.UserKeys != 1 || stats.InternalKeys != 2 || stats.Tombstones != 2 {
+			t.Errorf("Unexpected key counts: %+v", stats)
+		}
+		if stats.UserBytes+stats.InternalBytes != stats.LiveDataSize || stats.UserBytes <= 0 || stats.InternalBytes <= 0 {
+			t.Errorf("Unexpected byte split: %+v", stats)
+		}
+		tombstones := int64(codec.NewRecord([]byte("user:2"), nil).Size() + codec.NewRecord([]byte("config:mode"), nil).Size())
+		if stats.TombstoneBytes != tombstones {
+			t.Errorf("Expected %d tombstone bytes, got %d", tombstones, stats.TombstoneBytes)
+		}
+	}
+	check(store.Stats())
+
+	// Rebuilding the index from the log gives the same split
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	store = open()
+	defer store.Close()
+	check(store.Stats())
+}
+
 func TestKVStore_CrashSafeReopen_CleanFile(t *testing.T) {
 	// Test clean restart with no corruption
 	tmpDir, err := os.MkdirTemp("", "freyja_test")
@@ -412,7 +469,7 @@ func TestKVStore_ValidateLogFile_DecomposedFunctions(t *testing.T) {
 
 	// Test with non-existent file
 	nonExistentPath := filepath.Join(tmpDir, "nonexistent.data")
-	result, err = store.validateLogFile(nonExistentPath)
+	result, err = store.validateLogFile(context.Background(), nonExistentPath)
 	if err != nil {
 		t.Fatalf("Expected no error for non-existent file, got %v", err)
 	}
@@ -477,3 +534,167 @@ func TestKVStore_RecordSizeValidation(t *testing.T) {
 		t.Fatalf("Failed to put record at size limit: %v", err)
 	}
 }
+
+func TestKVStore_GetMany(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	store, err := NewKVStore(KVStoreConfig{DataDir: tmpDir})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	for _, k := range []string{"c", "a", "b"} {
+		if err := store.Put([]byte(k), []byte("value_"+k)); err != nil {
+			t.Fatalf("Failed to put %s: %v", k, err)
+		}
+	}
+	if err := store.Delete([]byte("b")); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+
+	values, err := store.GetMany([][]byte{[]byte("a"), []byte("missing"), []byte("b"), []byte("c")})
+	if err != nil {
+		t.Fatalf("GetMany failed: %v", err)
+	}
+
+	if len(values) != 4 {
+		t.Fatalf("Expected 4 values, got %d", len(values))
+	}
+	if string(values[0]) != "value_a" || string(values[3]) != "value_c" {
+		t.Errorf("Unexpected values: %q", values)
+	}
+	if values[1] != nil || values[2] != nil {
+		t.Errorf("Expected nil for missing and deleted keys, got %q and %q", values[1], values[2])
+	}
+}
+
+func TestKVStore_Update(t *testing.T) {
+	store, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir(), FsyncInterval: time.Second})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	key := []byte("counter")
+	increment := func(old []byte) ([]byte, error) {
+		n := 0
+		if old != nil {
+			var err error
+			if n, err = strconv.Atoi(string(old)); err != nil {
+				return nil, err
+			}
+		}
+		return []byte(strconv.Itoa(n + 1)), nil
+	}
+
+	// Concurrent increments must not lose updates
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := store.Update(key, increment); err != nil {
+				t.Errorf("Update failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	value, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("Failed to get counter: %v", err)
+	}
+	if string(value) != "20" {
+		t.Errorf("Expected counter 20, got %s", value)
+	}
+
+	// An error from fn leaves the value untouched
+	abort := errors.New("abort")
+	if err := store.Update(key, func(old []byte) ([]byte, error) { return nil, abort }); err != abort {
+		t.Errorf("Expected abort error, got %v", err)
+	}
+	if value, _ := store.Get(key); string(value) != "20" {
+		t.Errorf("Expected counter to stay 20, got %s", value)
+	}
+
+	// Returning nil deletes the key
+	if err := store.Update(key, func(old []byte) ([]byte, error) { return nil, nil }); err != nil {
+		t.Fatalf("Delete via Update failed: %v", err)
+	}
+	if _, err := store.Get(key); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestKVStore_ScanPrefix(t *testing.T) {
+	store, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir(), FsyncInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	for _, k := range []string{"user:1", "user:2", "user:3", "item:1"} {
+		if err := store.Put([]byte(k), []byte("value_"+k)); err != nil {
+			t.Fatalf("Failed to put %s: %v", k, err)
+		}
+	}
+
+	it, err := store.ScanPrefix([]byte("user:"))
+	if err != nil {
+		t.Fatalf("ScanPrefix failed: %v", err)
+	}
+	defer it.Close()
+
+	// Changes made during the scan are visible, even while still buffered
+	if err := store.Put([]byte("user:2"), []byte("updated")); err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+	if err := store.Delete([]byte("user:3")); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+
+	got := make(map[string]string)
+	for it.Next() {
+		got[string(it.Key())] = string(it.Value())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	want := map[string]string{"user:1": "value_user:1", "user:2": "updated"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// Closing early ends the scan
+	it, err = store.ScanPrefix(nil)
+	if err != nil {
+		t.Fatalf("ScanPrefix failed: %v", err)
+	}
+	if !it.Next() {
+		t.Fatal("Expected at least one pair")
+	}
+	it.Close()
+	if it.Next() || it.Err() != nil {
+		t.Errorf("Expected a closed iterator to stop cleanly, got err %v", it.Err())
+	}
+
+	// Closing the store fails an open scan
+	it, err = store.ScanPrefix([]byte("user:"))
+	if err != nil {
+		t.Fatalf("ScanPrefix failed: %v", err)
+	}
+	store.Close()
+	if it.Next() || it.Err() == nil {
+		t.Error("Expected scanning a closed store to fail")
+	}
+}
@@ -0,0 +1,159 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// ErrAppendSize is returned when an appender's value doesn't match the size
+// given to Begin
+var ErrAppendSize = errors.New("value size does not match Begin")
+
+// Appender streams one record into the log without holding its value in
+// memory. The header is written first with an empty CRC, the value follows
+// in chunks while the CRC is computed over them, and Commit writes the CRC
+// into the header. A crash before Commit leaves a record whose CRC doesn't
+// match, which recovery truncates like any torn write.
+//
+// The writer is locked from Begin until Commit or Abort, so nothing else is
+// appended in between.
+type Appender struct {
+	w         *LogWriter
+	offset    int64 // Where the record starts
+	remaining int64 // Value bytes still expected
+	timestamp uint64
+	crc       hash.Hash32
+	done      bool
+}
+
+// Begin starts a record for key with a value of exactly size bytes. The
+// caller must finish it with Commit or Abort.
+func (w *LogWriter) Begin(key []byte, size uint32) (*Appender, error) {
+	w.mutex.Lock()
+
+	if w.config.ReadOnly {
+		w.mutex.Unlock()
+		return nil, ErrReadOnly
+	}
+	if w.failed != nil {
+		w.mutex.Unlock()
+		return nil, w.failed
+	}
+	if uint64(len(key)) > uint64(^uint32(0)) {
+		w.mutex.Unlock()
+		return nil, ErrInvalidKey
+	}
+	// Only this record may be buffered, so Abort can drop the buffer
+	if err := w.writer.Flush(); err != nil {
+		w.mutex.Unlock()
+		return nil, err
+	}
+
+	a := &Appender{
+		w:         w,
+		offset:    w.offset,
+		remaining: int64(size),
+		timestamp: uint64(time.Now().UnixNano()), //nolint:gosec // nanosecond timestamps are positive
+		crc:       crc32.NewIEEE(),
+	}
+
+	header := make([]byte, recordHeaderSize)
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(key))) //nolint:gosec // checked above
+	binary.LittleEndian.PutUint32(header[8:], size)
+	binary.LittleEndian.PutUint64(header[12:], a.timestamp)
+	a.crc.Write(header[4:]) //nolint:errcheck // hash writes never fail
+	a.crc.Write(key)        //nolint:errcheck // hash writes never fail
+
+	if err := a.write(header); err != nil {
+		return nil, err
+	}
+	if err := a.write(key); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Write appends the next chunk of the value
+func (a *Appender) Write(chunk []byte) (int, error) {
+	if a.done {
+		return 0, errors.New("appender is finished")
+	}
+	if int64(len(chunk)) > a.remaining {
+		return 0, fmt.Errorf("%w: %d bytes too many", ErrAppendSize, int64(len(chunk))-a.remaining)
+	}
+
+	a.crc.Write(chunk) //nolint:errcheck // hash writes never fail
+	if err := a.write(chunk); err != nil {
+		return 0, err
+	}
+	a.remaining -= int64(len(chunk))
+	return len(chunk), nil
+}
+
+// Commit completes the record and syncs it like a Put. It returns the
+// record's offset and timestamp. If the value is short, or writing fails,
+// the record is removed as by Abort.
+func (a *Appender) Commit() (int64, uint64, error) {
+	if a.done {
+		return 0, 0, errors.New("appender is finished")
+	}
+	if a.remaining != 0 {
+		err := fmt.Errorf("%w: %d bytes missing", ErrAppendSize, a.remaining)
+		return 0, 0, errors.Join(err, a.Abort())
+	}
+
+	w := a.w
+	if err := w.writer.Flush(); err != nil {
+		return 0, 0, errors.Join(err, a.Abort())
+	}
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], a.crc.Sum32())
+	if _, err := w.file.WriteAt(sum[:], a.offset); err != nil {
+		return 0, 0, errors.Join(err, a.Abort())
+	}
+
+	a.done = true
+	defer w.mutex.Unlock()
+	w.noteSeq(a.timestamp)
+	if err := w.scheduleSync(); err != nil {
+		return 0, 0, err
+	}
+	return a.offset, a.timestamp, nil
+}
+
+// Abort discards the record, truncating the log back to where it started
+func (a *Appender) Abort() error {
+	if a.done {
+		return nil
+	}
+	a.done = true
+
+	w := a.w
+	defer w.mutex.Unlock()
+
+	// Whatever of the record is still buffered must not reach the file
+	w.writer.Reset(w.file)
+	if err := w.file.Truncate(a.offset); err != nil {
+		return err
+	}
+	if _, err := w.file.Seek(a.offset, io.SeekStart); err != nil {
+		return err
+	}
+	w.offset = a.offset
+	return nil
+}
+
+// write buffers part of the record, aborting it if the write fails
+func (a *Appender) write(data []byte) error {
+	n, err := a.w.writer.Write(data)
+	a.w.offset += int64(n)
+	if err != nil {
+		return errors.Join(err, a.Abort())
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ssargent/freyjadb/pkg/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogWriter_Appender(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "test.log")
+	writer, err := NewLogWriter(LogWriterConfig{FilePath: filePath, BufferSize: 16})
+	require.NoError(t, err)
+	defer writer.Close()
+
+	_, err = writer.Put([]byte("before"), []byte("v"))
+	require.NoError(t, err)
+	start := writer.Size()
+
+	// A value written in chunks smaller than the buffer decodes with a valid CRC
+	appender, err := writer.Begin([]byte("big"), 40)
+	require.NoError(t, err)
+	for _, chunk := range []string{"0123456789", "0123456789", "0123456789", "0123456789"} {
+		_, err := appender.Write([]byte(chunk))
+		require.NoError(t, err)
+	}
+	offset, timestamp, err := appender.Commit()
+	require.NoError(t, err)
+	assert.Equal(t, start, offset)
+	assert.Equal(t, start+recordHeaderSize+3+40, writer.Size())
+
+	data, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	record, err := codec.NewRecordCodec().Decode(data[offset:])
+	require.NoError(t, err)
+	require.NoError(t, record.Validate())
+	assert.Equal(t, "big", string(record.Key))
+	assert.Equal(t, timestamp, record.Timestamp)
+	assert.Len(t, record.Value, 40)
+
+	t.Run("abort truncates", func(t *testing.T) {
+		end := writer.Size()
+		appender, err := writer.Begin([]byte("gone"), 100)
+		require.NoError(t, err)
+		_, err = appender.Write(make([]byte, 60))
+		require.NoError(t, err)
+		require.NoError(t, appender.Abort())
+		assert.Equal(t, end, writer.Size())
+
+		// The writer is usable again and the log holds nothing of the record
+		offset, err := writer.Put([]byte("after"), []byte("v"))
+		require.NoError(t, err)
+		assert.Equal(t, end, offset)
+		info, err := os.Stat(filePath)
+		require.NoError(t, err)
+		assert.Equal(t, writer.Size(), info.Size())
+	})
+
+	t.Run("size mismatch", func(t *testing.T) {
+		end := writer.Size()
+		appender, err := writer.Begin([]byte("short"), 10)
+		require.NoError(t, err)
+		_, err = appender.Write(make([]byte, 11))
+		assert.ErrorIs(t, err, ErrAppendSize)
+		_, err = appender.Write(make([]byte, 4))
+		require.NoError(t, err)
+		_, _, err = appender.Commit()
+		assert.ErrorIs(t, err, ErrAppendSize)
+		assert.Equal(t, end, writer.Size())
+	})
+}
@@ -2,6 +2,7 @@ package store
 
 import (
 	"bufio"
+	"errors"
 	"io"
 	"os"
 
@@ -37,12 +38,21 @@ func NewLogReader(config LogReaderConfig) (*LogReader, error) {
 	return &LogReader{
 		file:   file,
 		reader: bufio.NewReader(file),
-		codec:  codec.NewRecordCodec(),
+		codec:  configCodec(config.Codec),
 		offset: config.StartOffset,
 		config: config,
 	}, nil
 }
 
+// configCodec returns the codec a reader or writer was configured with, or
+// a plain one
+func configCodec(recordCodec *codec.RecordCodec) *codec.RecordCodec {
+	if recordCodec == nil {
+		return codec.NewRecordCodec()
+	}
+	return recordCodec
+}
+
 // ReadNext reads the next record from the current offset
 func (r *LogReader) ReadNext() (*codec.Record, error) {
 	// Read the record header (20 bytes: CRC32 + KeySize + ValueSize + Timestamp)
@@ -61,17 +71,12 @@ func (r *LogReader) ReadNext() (*codec.Record, error) {
 		return nil, ErrCorruption
 	}
 
-	keySize := int(uint32(header[4]) | uint32(header[5])<<8 | uint32(header[6])<<16 | uint32(header[7])<<24)
-	valueSize := int(uint32(header[8]) | uint32(header[9])<<8 | uint32(header[10])<<16 | uint32(header[11])<<24)
-
-	// Read key and value data
-	dataSize := keySize + valueSize
+	// Read the expiry, key and value data
+	dataSize := int(codec.DataSize(header))
 	if dataSize == 0 {
 		// This might be a tombstone or empty record
 		record := &codec.Record{
-			CRC32:     uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16 | uint32(header[3])<<24,
-			KeySize:   uint32(keySize),
-			ValueSize: uint32(valueSize),
+			CRC32: uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16 | uint32(header[3])<<24,
 			Timestamp: uint64(header[12]) | uint64(header[13])<<8 | uint64(header[14])<<16 |
 				uint64(header[15])<<24 | uint64(header[16])<<32 | uint64(header[17])<<40 |
 				uint64(header[18])<<48 | uint64(header[19])<<56,
@@ -81,6 +86,12 @@ func (r *LogReader) ReadNext() (*codec.Record, error) {
 		return record, nil
 	}
 
+	// A damaged header can claim an enormous record; don't allocate for
+	// more data than the file holds
+	if dataSize > largeRecordSize && !r.fits(dataSize) {
+		return nil, ErrCorruption
+	}
+
 	data := make([]byte, dataSize)
 	n, err = io.ReadFull(r.reader, data)
 	if err != nil {
@@ -110,63 +121,47 @@ func (r *LogReader) ReadNext() (*codec.Record, error) {
 	return record, nil
 }
 
+// largeRecordSize is the record size above which ReadNext checks the claimed
+// size against the file before reading it
+const largeRecordSize = 1 << 20
+
+// fits reports whether dataSize bytes remain in the file after the offset
+func (r *LogReader) fits(dataSize int) bool {
+	info, err := r.file.Stat()
+	return err == nil && r.offset+int64(dataSize) <= info.Size()
+}
+
 // ReadAt reads a record at a specific offset
 func (r *LogReader) ReadAt(offset int64) (*codec.Record, error) {
-	// Always reopen the file to ensure we see the latest data
-	if r.file != nil {
-		if closeErr := r.file.Close(); closeErr != nil {
-			// Log or handle, but continue
-		}
-	}
-
+	// Use a fresh handle so we see the latest data; r.file stays open for
+	// sequential reads and is only closed by Close
 	file, err := os.Open(r.config.FilePath)
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
 
-	// Seek to the specified offset
-	if _, err := file.Seek(offset, 0); err != nil {
-		if closeErr := file.Close(); closeErr != nil {
-			// Log or handle
-		}
-		return nil, err
-	}
+	return readRecordAt(file, offset, r.codec)
+}
 
+// readRecordAt decodes and validates the record at offset in file using
+// positioned reads, so one handle can serve many reads
+func readRecordAt(file io.ReaderAt, offset int64, recordCodec *codec.RecordCodec) (*codec.Record, error) {
 	// Read the record header (20 bytes: CRC32 + KeySize + ValueSize + Timestamp)
 	header := make([]byte, 20)
-	n, err := file.Read(header)
-	if err != nil {
-		if closeErr := file.Close(); closeErr != nil {
-			// Log or handle
-		}
-		if err == io.EOF || n < 20 {
+	if _, err := file.ReadAt(header, offset); err != nil {
+		if err == io.EOF {
 			return nil, ErrCorruption
 		}
 		return nil, err
 	}
 
-	// Decode header to get sizes
-	if len(header) < 20 {
-		if closeErr := file.Close(); closeErr != nil {
-			// Log or handle
-		}
-		return nil, ErrCorruption
-	}
-
-	keySize := int(uint32(header[4]) | uint32(header[5])<<8 | uint32(header[6])<<16 | uint32(header[7])<<24)
-	valueSize := int(uint32(header[8]) | uint32(header[9])<<8 | uint32(header[10])<<16 | uint32(header[11])<<24)
-
-	// Read key and value data
-	dataSize := keySize + valueSize
+	// Read the expiry, key and value data
+	dataSize := int(codec.DataSize(header))
 	if dataSize == 0 {
 		// This might be a tombstone or empty record
-		if closeErr := file.Close(); closeErr != nil {
-			// Log or handle
-		}
 		record := &codec.Record{
-			CRC32:     uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16 | uint32(header[3])<<24,
-			KeySize:   uint32(keySize),
-			ValueSize: uint32(valueSize),
+			CRC32: uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16 | uint32(header[3])<<24,
 			Timestamp: uint64(header[12]) | uint64(header[13])<<8 | uint64(header[14])<<16 |
 				uint64(header[15])<<24 | uint64(header[16])<<32 | uint64(header[17])<<40 |
 				uint64(header[18])<<48 | uint64(header[19])<<56,
@@ -176,29 +171,17 @@ func (r *LogReader) ReadAt(offset int64) (*codec.Record, error) {
 		return record, nil
 	}
 
-	data := make([]byte, dataSize)
-	n, err = file.Read(data)
-	if err != nil {
-		if closeErr := file.Close(); closeErr != nil {
-			// Log or handle
-		}
-		if err == io.EOF || n < dataSize {
+	// Read the header again with the data so the record decodes in place
+	fullData := make([]byte, 20+dataSize)
+	if _, err := file.ReadAt(fullData, offset); err != nil {
+		if err == io.EOF {
 			return nil, ErrCorruption
 		}
 		return nil, err
 	}
 
-	if closeErr := file.Close(); closeErr != nil {
-		// Log or handle
-	}
-
-	// Construct full record data for decoding
-	fullData := make([]byte, 20+dataSize)
-	copy(fullData[0:20], header)
-	copy(fullData[20:], data)
-
 	// Decode the complete record
-	record, err := r.codec.Decode(fullData)
+	record, err := recordCodec.Decode(fullData)
 	if err != nil {
 		return nil, err
 	}
@@ -222,6 +205,23 @@ func (r *LogReader) Seek(offset int64) error {
 	return nil
 }
 
+// reopen follows the log to the new file at its path, from the start, once
+// rotation has started the log over
+func (r *LogReader) reopen() error {
+	file, err := os.Open(r.config.FilePath)
+	if err != nil {
+		return err
+	}
+	if err := r.file.Close(); err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.reader.Reset(file)
+	r.offset = 0
+	return nil
+}
+
 // Offset returns the current read offset
 func (r *LogReader) Offset() int64 {
 	return r.offset
@@ -253,7 +253,12 @@ func (it *logRecordIterator) Record() *codec.Record {
 	return it.record
 }
 
+// Close reports an intact record that couldn't be decrypted, which ends
+// the iteration like the end of the log would
 func (it *logRecordIterator) Close() error {
 	// Don't close the underlying reader as it's owned by the caller
+	if errors.Is(it.err, codec.ErrDecrypt) {
+		return it.err
+	}
 	return nil
 }
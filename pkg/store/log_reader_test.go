@@ -1,10 +1,13 @@
 package store
 
 import (
+	"bytes"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/ssargent/freyjadb/pkg/codec"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -181,6 +184,58 @@ func TestLogReader_ReadAt(t *testing.T) {
 	assert.Nil(t, record)
 }
 
+func TestLogReader_ReadAtKeepsReaderOpen(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "test.log")
+	require.NoError(t, os.WriteFile(filePath, []byte("0123456789abcdef"), 0600))
+
+	reader, err := NewLogReader(LogReaderConfig{FilePath: filePath})
+	require.NoError(t, err)
+
+	_, _ = reader.ReadAt(0)
+
+	// Sequential access and Close still work after a random read
+	assert.NoError(t, reader.Seek(0))
+	assert.NoError(t, reader.Close())
+}
+
+func TestLogReader_CompressedRecords(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "test.log")
+	compressing, err := codec.NewRecordCodecWithCompression(codec.CompressionOptions{Algorithm: codec.CompressionSnappy})
+	require.NoError(t, err)
+
+	// A compressed record between plain ones, as a log written before and
+	// after compression was enabled would hold
+	value := bytes.Repeat([]byte("compressible "), 100)
+	first, err := codec.NewRecordCodec().EncodeAt([]byte("a"), []byte("plain"), 1)
+	require.NoError(t, err)
+	second, err := compressing.EncodeAt([]byte("b"), value, 2)
+	require.NoError(t, err)
+	third, err := compressing.EncodeAt([]byte("c"), []byte("small"), 3)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filePath, bytes.Join([][]byte{first, second, third}, nil), 0600))
+
+	reader, err := NewLogReader(LogReaderConfig{FilePath: filePath})
+	require.NoError(t, err)
+	defer reader.Close()
+
+	var values []string
+	for {
+		record, err := reader.ReadNext()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		values = append(values, string(record.Value))
+	}
+	assert.Equal(t, []string{"plain", string(value), "small"}, values)
+	assert.Equal(t, int64(len(first)+len(second)+len(third)), reader.Offset())
+
+	record, err := reader.ReadAt(int64(len(first)))
+	require.NoError(t, err)
+	assert.Equal(t, value, record.Value)
+	assert.Equal(t, len(second), record.Size())
+}
+
 func TestLogReader_MultipleOperations(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "log_reader_multi_test")
 	require.NoError(t, err)
@@ -0,0 +1,201 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/codec"
+)
+
+// DefaultLogTailPoll is how often TailLogRecords looks for new records when
+// following a segment and LogTailOptions doesn't set PollInterval
+const DefaultLogTailPoll = 250 * time.Millisecond
+
+// errTailLimit stops ReadLogRecords once a tail has reached its limit
+var errTailLimit = errors.New("tail limit reached")
+
+// LogRecordInfo describes one raw record as it sits in a segment, for
+// debugging what was actually written. Values are not included.
+type LogRecordInfo struct {
+	FileID         uint32 `json:"file_id"`
+	Offset         int64  `json:"offset"`
+	Size           int64  `json:"size"` // Encoded size; the next record starts at Offset+Size
+	Key            []byte `json:"key"`
+	ValueSize      uint32 `json:"value_size"`
+	Timestamp      uint64 `json:"timestamp"`
+	Tombstone      bool   `json:"tombstone"`
+	RangeTombstone bool   `json:"range_tombstone,omitempty"`
+	Internal       bool   `json:"internal,omitempty"` // Reserved key written by the store itself
+}
+
+// ReadLogRecords calls fn with every complete record in segment fileID from
+// offset on, in file order, and returns the offset after the last one. A
+// record still being written ends the read without an error, so calling it
+// again with the returned offset tails the segment. If the active log has
+// been emptied by a memtable flush since offset was returned, reading
+// restarts at its beginning. The segment is read without holding the store
+// mutex, so a long read doesn't hold up writes.
+func (kv *KVStore) ReadLogRecords(ctx context.Context, fileID uint32, offset int64,
+	fn func(LogRecordInfo) error) (int64, error) {
+	kv.mutex.Lock()
+	open := kv.isOpen
+	kv.mutex.Unlock()
+	if !open {
+		return offset, &KVError{"store is not open"}
+	}
+	if offset < 0 {
+		return offset, &KVError{"offset must not be negative"}
+	}
+
+	path, ok := kv.segments.touch(fileID)
+	if !ok {
+		return offset, fmt.Errorf("unknown segment %d", fileID)
+	}
+	file, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return offset, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return offset, err
+	}
+	size := info.Size()
+	if offset > size {
+		if fileID != activeFileID {
+			return offset, &KVError{fmt.Sprintf("offset %d is past the end of segment %d", offset, fileID)}
+		}
+		offset = 0
+	}
+
+	recordCodec := kv.codec
+	reader := bufio.NewReaderSize(io.NewSectionReader(file, offset, size-offset), 64*1024)
+	header := make([]byte, recordHeaderSize)
+	for records := 1; ; records++ {
+		if err := readFullTail(reader, header); err != nil {
+			if err == io.EOF {
+				return offset, nil
+			}
+			return offset, err
+		}
+		dataSize := codec.DataSize(header)
+		if offset+recordHeaderSize+dataSize > size {
+			return offset, nil // Still being written
+		}
+
+		data := make([]byte, recordHeaderSize+dataSize)
+		copy(data, header)
+		if err := readFullTail(reader, data[recordHeaderSize:]); err != nil {
+			if err == io.EOF {
+				return offset, nil
+			}
+			return offset, err
+		}
+		record, err := recordCodec.Decode(data)
+		if err != nil || record.Validate() != nil {
+			return offset, fmt.Errorf("segment %d offset %d: %w", fileID, offset, ErrCorruption)
+		}
+
+		_, _, rangeTombstone := codec.DecodeRangeTombstone(record)
+		err = fn(LogRecordInfo{
+			FileID:         fileID,
+			Offset:         offset,
+			Size:           int64(len(data)),
+			Key:            record.Key,
+			ValueSize:      record.ValueSize,
+			Timestamp:      record.Timestamp,
+			Tombstone:      record.ValueSize == 0 && !rangeTombstone,
+			RangeTombstone: rangeTombstone,
+			Internal:       codec.IsReservedKey(record.Key),
+		})
+		if err != nil {
+			return offset, err
+		}
+		offset += int64(len(data))
+
+		if records%tailRecordsPerCheck == 0 {
+			if err := ctx.Err(); err != nil {
+				return offset, err
+			}
+		}
+	}
+}
+
+// LogTailOptions selects the records TailLogRecords reads and how fast
+type LogTailOptions struct {
+	FileID       uint32
+	Offset       int64
+	Limit        int           // Stop after this many records; 0 for no limit
+	Rate         int           // Records per second at most; 0 for no limit
+	Follow       bool          // At the end of the segment, wait for more records instead of returning
+	PollInterval time.Duration // How often to look for new records when following
+}
+
+// TailLogRecords reads records from a segment like ReadLogRecords, stopping
+// at opts.Limit and pacing fn to opts.Rate. With opts.Follow it keeps
+// polling for new records until the limit is reached or ctx is canceled,
+// in which case it returns ctx's error. It returns the number of records
+// passed to fn and the offset to resume from.
+func (kv *KVStore) TailLogRecords(ctx context.Context, opts LogTailOptions,
+	fn func(LogRecordInfo) error) (int, int64, error) {
+	poll := opts.PollInterval
+	if poll <= 0 {
+		poll = DefaultLogTailPoll
+	}
+
+	start := time.Now()
+	count := 0
+	offset := opts.Offset
+	for {
+		var err error
+		offset, err = kv.ReadLogRecords(ctx, opts.FileID, offset, func(record LogRecordInfo) error {
+			if opts.Limit > 0 && count == opts.Limit {
+				return errTailLimit
+			}
+			if opts.Rate > 0 {
+				due := start.Add(time.Duration(count) * time.Second / time.Duration(opts.Rate))
+				if err := sleepContext(ctx, time.Until(due)); err != nil {
+					return err
+				}
+			}
+			if err := fn(record); err != nil {
+				return err
+			}
+			count++
+			return nil
+		})
+		if errors.Is(err, errTailLimit) {
+			return count, offset, nil
+		}
+		if err != nil || !opts.Follow || (opts.Limit > 0 && count == opts.Limit) {
+			return count, offset, err
+		}
+		if err := sleepContext(ctx, poll); err != nil {
+			return count, offset, err
+		}
+	}
+}
+
+// sleepContext waits for d, returning early with ctx's error if it is
+// canceled
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
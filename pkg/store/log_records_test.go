@@ -0,0 +1,136 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestKVStore_ReadLogRecords(t *testing.T) {
+	store, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Put([]byte("user:1"), []byte("ada")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := store.Delete([]byte("user:1")); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if err := store.DeletePrefix([]byte("tmp:")); err != nil {
+		t.Fatalf("Failed to delete prefix: %v", err)
+	}
+
+	var records []LogRecordInfo
+	next, err := store.ReadLogRecords(context.Background(), activeFileID, 0, func(record LogRecordInfo) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to read log records: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %d: %+v", len(records), records)
+	}
+	put, del, rangeDel := records[0], records[1], records[2]
+	if string(put.Key) != "user:1" || put.Offset != 0 || put.ValueSize != 3 || put.Tombstone || put.Timestamp == 0 {
+		t.Errorf("Unexpected put record: %+v", put)
+	}
+	if del.Offset != put.Offset+put.Size || !del.Tombstone || del.Internal {
+		t.Errorf("Unexpected delete record: %+v", del)
+	}
+	if !rangeDel.RangeTombstone || !rangeDel.Internal || rangeDel.Tombstone {
+		t.Errorf("Unexpected range tombstone record: %+v", rangeDel)
+	}
+	if next != rangeDel.Offset+rangeDel.Size || next != store.writer.Size() {
+		t.Errorf("Expected next offset %d, got %d", store.writer.Size(), next)
+	}
+
+	// Reading from the returned offset picks up only later writes
+	if err := store.Put([]byte("user:2"), []byte("grace")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	records = nil
+	if _, err := store.ReadLogRecords(context.Background(), activeFileID, next, func(record LogRecordInfo) error {
+		records = append(records, record)
+		return nil
+	}); err != nil {
+		t.Fatalf("Failed to read log records: %v", err)
+	}
+	if len(records) != 1 || string(records[0].Key) != "user:2" {
+		t.Errorf("Expected only user:2 after offset %d, got %+v", next, records)
+	}
+
+	if _, err := store.ReadLogRecords(context.Background(), 42, 0, func(LogRecordInfo) error { return nil }); err == nil {
+		t.Error("Expected an error for an unknown segment")
+	}
+}
+
+func TestKVStore_TailLogRecords(t *testing.T) {
+	store, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := store.Put([]byte(key), []byte("v")); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+
+	// The limit stops the tail and the offset resumes after the last record
+	var keys []string
+	count, next, err := store.TailLogRecords(context.Background(), LogTailOptions{Limit: 2},
+		func(record LogRecordInfo) error {
+			keys = append(keys, string(record.Key))
+			return nil
+		})
+	if err != nil || count != 2 || len(keys) != 2 || keys[1] != "b" {
+		t.Fatalf("Expected a and b, got %v (%d, %v)", keys, count, err)
+	}
+
+	// Following picks up a write made while waiting, and the rate paces it
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = store.Put([]byte("d"), []byte("v"))
+	}()
+	keys = nil
+	start := time.Now()
+	count, _, err = store.TailLogRecords(ctx, LogTailOptions{
+		Offset:       next,
+		Limit:        2,
+		Rate:         20,
+		Follow:       true,
+		PollInterval: 5 * time.Millisecond,
+	}, func(record LogRecordInfo) error {
+		keys = append(keys, string(record.Key))
+		return nil
+	})
+	if err != nil || count != 2 || keys[0] != "c" || keys[1] != "d" {
+		t.Fatalf("Expected c and d, got %v (%d, %v)", keys, count, err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected 2 records at 20/s to take at least 50ms, took %v", elapsed)
+	}
+
+	// A follow with nothing new ends when the context does
+	short, cancelShort := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelShort()
+	_, _, err = store.TailLogRecords(short, LogTailOptions{Offset: store.writer.Size(), Follow: true},
+		func(LogRecordInfo) error { return nil })
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package store
 
 import (
 	"bufio"
+	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
@@ -19,10 +20,25 @@ type LogWriter struct {
 	config     LogWriterConfig
 	mutex      sync.Mutex
 	offset     int64 // Current write offset
+
+	fsyncPending bool        // The interval fsync timer is armed
+	groupTimer   *time.Timer // Pending group commit fsync, nil when none is scheduled
+	durable      int64       // Offset up to which the log has been fsynced
+	syncErr      error       // First fsync failure, reported to group commit waiters
+	synced       *sync.Cond  // Broadcast after every fsync attempt
+	fsyncs       int64       // Successful fsyncs since the writer was created
+
+	minSeq, maxSeq uint64 // Lowest and highest timestamps of the records in the log
+	seqKnown       bool   // minSeq and maxSeq cover the log: it held no records when opened
+	failed         error  // Why the log could not be restarted; writes are refused
 }
 
 // NewLogWriter creates a new log writer with the given configuration
 func NewLogWriter(config LogWriterConfig) (*LogWriter, error) {
+	if config.ReadOnly {
+		return newReadOnlyLogWriter(config)
+	}
+
 	// Ensure directory exists
 	if err := os.MkdirAll(filepath.Dir(config.FilePath), 0750); err != nil {
 		return nil, err
@@ -52,18 +68,22 @@ func NewLogWriter(config LogWriterConfig) (*LogWriter, error) {
 	}
 
 	writer := &LogWriter{
-		file:   file,
-		writer: bufio.NewWriterSize(file, config.BufferSize),
-		codec:  codec.NewRecordCodec(),
-		config: config,
-		offset: stat.Size(),
+		file:     file,
+		writer:   bufio.NewWriterSize(file, config.BufferSize),
+		codec:    configCodec(config.Codec),
+		config:   config,
+		offset:   stat.Size(),
+		durable:  stat.Size(),
+		seqKnown: stat.Size() == 0,
 	}
+	writer.synced = sync.NewCond(&writer.mutex)
 
 	// Set up fsync timer if interval is configured
 	if config.FsyncInterval > 0 {
 		writer.fsyncTimer = time.AfterFunc(config.FsyncInterval, func() {
 			writer.mutex.Lock()
 			defer writer.mutex.Unlock()
+			writer.fsyncPending = false
 			writer.sync() // Ignore error in timer callback
 		})
 	}
@@ -71,42 +91,152 @@ func NewLogWriter(config LogWriterConfig) (*LogWriter, error) {
 	return writer, nil
 }
 
+// newReadOnlyLogWriter opens an existing log without write access. Its
+// offset starts at zero and is moved along by advance as records written by
+// another process are indexed.
+func newReadOnlyLogWriter(config LogWriterConfig) (*LogWriter, error) {
+	file, err := os.Open(filepath.Clean(config.FilePath))
+	if err != nil {
+		return nil, err
+	}
+
+	writer := &LogWriter{
+		file:   file,
+		writer: bufio.NewWriterSize(file, 16),
+		codec:  configCodec(config.Codec),
+		config: config,
+	}
+	writer.synced = sync.NewCond(&writer.mutex)
+	return writer, nil
+}
+
+// advance moves a read-only writer's offset to the end of the records
+// indexed so far; they were fsynced by the process that wrote them
+func (w *LogWriter) advance(offset int64) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.offset = offset
+	w.durable = offset
+}
+
 // Put appends a key-value pair to the log file and returns the record offset
 func (w *LogWriter) Put(key, value []byte) (int64, error) {
+	return w.put(key, value, nil)
+}
+
+// put is Put, timing its phases in t. Everything since t's last lap, up to
+// the record reaching the log, is the caller's validation.
+func (w *LogWriter) put(key, value []byte, t *writeTiming) (int64, error) {
+	offset, _, err := w.putExpiring(key, value, 0, t)
+	return offset, err
+}
+
+// putExpiring is put for a record that expires at expiresAt (0 = never). It
+// also returns the size of the encoded record, which an encrypting codec
+// makes larger than the key and value.
+func (w *LogWriter) putExpiring(key, value []byte, expiresAt uint64, t *writeTiming) (int64, uint32, error) {
+	t.lap(phaseValidate)
+	t.record(key)
+
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
+	t.lap(phaseLockWait)
+
+	if w.config.ReadOnly {
+		return 0, 0, ErrReadOnly
+	}
+	if w.failed != nil {
+		return 0, 0, w.failed
+	}
 
 	// Encode the record
-	data, err := w.codec.Encode(key, value)
+	timestamp := uint64(time.Now().UnixNano()) //nolint:gosec // nanosecond timestamps are positive
+	data, err := w.codec.EncodeExpiring(key, value, timestamp, expiresAt)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
+	t.lap(phaseEncode)
 
 	// Write to buffer
 	n, err := w.writer.Write(data)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
+	t.lap(phaseBuffer)
 
 	// Calculate the offset where this record starts
 	recordOffset := w.offset
 
 	// Update offset
 	w.offset += int64(n)
+	w.noteSeq(timestamp)
+
+	err = w.scheduleSync()
+	t.lap(phaseFsync)
+	if err != nil {
+		return 0, 0, err
+	}
+	return recordOffset, uint32(n), nil //nolint:gosec // record sizes fit in uint32
+}
+
+// scheduleSync makes a just-written record durable as the writer is
+// configured to: now, with the group commit, or within the fsync interval.
+// The caller must hold the mutex.
+func (w *LogWriter) scheduleSync() error {
+	switch {
+	case w.config.GroupCommitWindow > 0:
+		// The first write of a window schedules one fsync for the whole
+		// group; callers wait for it with WaitDurable
+		if w.groupTimer == nil {
+			w.groupTimer = time.AfterFunc(w.config.GroupCommitWindow, w.groupSync)
+		}
+	case w.config.FsyncInterval == 0:
+		// Sync immediately if no fsync interval configured
+		return w.sync()
+	case w.fsyncTimer != nil && !w.fsyncPending:
+		// Arm the fsync timer; it isn't pushed back by later writes, so no
+		// write stays unsynced for longer than the interval
+		w.fsyncPending = true
+		w.fsyncTimer.Reset(w.config.FsyncInterval)
+	}
+	return nil
+}
+
+// WaitDurable blocks until every record written before offset has been
+// fsynced. With group commit this takes at most one window plus the fsync.
+// Once an fsync has failed the log's contents are uncertain, so every later
+// wait returns that error.
+func (w *LogWriter) WaitDurable(offset int64) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
 
-	// Sync immediately if no fsync interval configured
-	if w.config.FsyncInterval == 0 {
-		if err := w.sync(); err != nil {
-			return 0, err
+	for w.durable < offset {
+		if w.syncErr != nil {
+			return w.syncErr
 		}
-	} else {
-		// Reset fsync timer
-		if w.fsyncTimer != nil {
-			w.fsyncTimer.Reset(w.config.FsyncInterval)
+		if w.groupTimer == nil {
+			// Nothing is scheduled to cover offset, so sync now
+			return w.sync()
 		}
+		w.synced.Wait()
 	}
+	return nil
+}
+
+// groupSync is the group commit timer callback
+func (w *LogWriter) groupSync() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.groupTimer = nil
+	w.sync() //nolint:errcheck // Recorded in syncErr for the waiters
+}
 
-	return recordOffset, nil
+// Fsyncs returns how many fsyncs the writer has completed
+func (w *LogWriter) Fsyncs() int64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.fsyncs
 }
 
 // Sync forces a fsync to disk
@@ -118,13 +248,33 @@ func (w *LogWriter) Sync() error {
 
 // sync performs the actual fsync operation (internal method)
 func (w *LogWriter) sync() error {
+	defer w.synced.Broadcast()
+
+	// Nothing is ever written through a read-only writer
+	if w.config.ReadOnly {
+		return nil
+	}
+
 	// Flush buffered writes
 	if err := w.writer.Flush(); err != nil {
-		return err
+		return w.syncFailed(err)
 	}
 
 	// Fsync to disk
-	return w.file.Sync()
+	if err := w.file.Sync(); err != nil {
+		return w.syncFailed(err)
+	}
+	w.durable = w.offset
+	w.fsyncs++
+	return nil
+}
+
+// syncFailed remembers the first fsync failure for WaitDurable
+func (w *LogWriter) syncFailed(err error) error {
+	if w.syncErr == nil {
+		w.syncErr = err
+	}
+	return err
 }
 
 // Close closes the log writer and ensures all data is synced
@@ -132,10 +282,14 @@ func (w *LogWriter) Close() error {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
-	// Cancel fsync timer
+	// Cancel fsync timers; the final sync covers any pending group
 	if w.fsyncTimer != nil {
 		w.fsyncTimer.Stop()
 	}
+	if w.groupTimer != nil {
+		w.groupTimer.Stop()
+		w.groupTimer = nil
+	}
 
 	// Final sync
 	if err := w.sync(); err != nil {
@@ -148,6 +302,85 @@ func (w *LogWriter) Close() error {
 	return w.file.Close()
 }
 
+// truncate empties the log and starts writing it again from the beginning.
+// Only call it once every record in the log is durable elsewhere, as after
+// a memtable flush.
+func (w *LogWriter) truncate() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	defer w.synced.Broadcast()
+
+	if w.config.ReadOnly {
+		return ErrReadOnly
+	}
+
+	if err := w.writer.Flush(); err != nil {
+		return err
+	}
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.file.Seek(0, 0); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return w.syncFailed(err)
+	}
+	w.offset, w.durable = 0, 0
+	w.minSeq, w.maxSeq, w.seqKnown = 0, 0, true
+	return nil
+}
+
+// restart starts the log over in fresh, an empty file created and fsynced
+// next to it under its temp name, by renaming fresh over the log. Rotation
+// hard-links the log as a segment first, so the records stay there and
+// none of this depends on the log's size. Once fresh is in place the
+// rename is made durable; should the rename itself fail, the log is still
+// the segment's file, so further writes are refused until the store is
+// reopened and finishes the rotation.
+func (w *LogWriter) restart(fresh *os.File) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	defer w.synced.Broadcast()
+
+	if err := w.writer.Flush(); err != nil {
+		return err
+	}
+	if err := os.Rename(fresh.Name(), w.config.FilePath); err != nil {
+		fresh.Close()
+		os.Remove(fresh.Name())
+		w.failed = fmt.Errorf("active log could not be restarted after rotation; reopen the store: %w", err)
+		return w.failed
+	}
+
+	old := w.file
+	w.file = fresh
+	w.writer.Reset(fresh)
+	w.offset, w.durable = 0, 0
+	w.minSeq, w.maxSeq, w.seqKnown = 0, 0, true
+	if err := old.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error closing rotated log: %v\n", err)
+	}
+	return syncDir(filepath.Dir(w.config.FilePath))
+}
+
+// noteSeq widens the log's timestamp range to a record just written (caller
+// must hold the mutex)
+func (w *LogWriter) noteSeq(timestamp uint64) {
+	if w.minSeq == 0 || timestamp < w.minSeq {
+		w.minSeq = timestamp
+	}
+	w.maxSeq = max(w.maxSeq, timestamp)
+}
+
+// seqRange returns the lowest and highest timestamps of the records in the
+// log, if the writer saw every one of them written
+func (w *LogWriter) seqRange() (uint64, uint64, bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.minSeq, w.maxSeq, w.seqKnown
+}
+
 // Size returns the current size of the log file
 func (w *LogWriter) Size() int64 {
 	w.mutex.Lock()
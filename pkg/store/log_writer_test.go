@@ -197,6 +197,44 @@ func TestLogWriter_FsyncInterval(t *testing.T) {
 
 	// Wait for fsync timer
 	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int64(1), writer.Fsyncs())
+
+	// A steady stream of writes must not keep postponing the fsync
+	before := writer.Fsyncs()
+	for i := 0; i < 20; i++ {
+		_, err = writer.Put([]byte("key"), []byte("value"))
+		require.NoError(t, err)
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Greater(t, writer.Fsyncs(), before, "writes every 5ms should still be fsynced every 10ms")
+}
+
+func TestLogWriter_GroupCommit(t *testing.T) {
+	writer, err := NewLogWriter(LogWriterConfig{
+		FilePath:          filepath.Join(t.TempDir(), "test.log"),
+		BufferSize:        4096,
+		GroupCommitWindow: 20 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer writer.Close()
+
+	// Writes return without syncing and are covered by one fsync
+	var ends []int64
+	for i := 0; i < 10; i++ {
+		_, err := writer.Put([]byte(fmt.Sprintf("key%d", i)), []byte("value"))
+		require.NoError(t, err)
+		ends = append(ends, writer.Size())
+	}
+	assert.Equal(t, int64(0), writer.Fsyncs())
+
+	for _, end := range ends {
+		require.NoError(t, writer.WaitDurable(end))
+	}
+	assert.Equal(t, int64(1), writer.Fsyncs())
+
+	// Already durable: returns immediately
+	require.NoError(t, writer.WaitDurable(ends[0]))
+	assert.Equal(t, int64(1), writer.Fsyncs())
 }
 
 func TestLogWriter_Path(t *testing.T) {
@@ -0,0 +1,342 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ssargent/freyjadb/pkg/codec"
+)
+
+const (
+	// manifestFile lists the live segments; it lives in the primary data
+	// directory and is only ever replaced by an atomic rename
+	manifestFile    = "MANIFEST"
+	manifestVersion = 1
+
+	// tempSuffix marks files still being written. Segment and manifest temp
+	// files are never live data, so Open removes any left by a crash.
+	tempSuffix = ".tmp"
+)
+
+// StoreManifest records the segments that make up a store. Open trusts it
+// rather than guessing from the files present, and every change to the
+// segment set writes a new generation.
+type StoreManifest struct {
+	Version    int               `json:"version"`
+	Generation uint64            `json:"generation"`
+	NextFileID uint32            `json:"next_file_id"`
+	Segments   []ManifestSegment `json:"segments"`
+
+	// LogBase counts the active log bytes emptied out by memtable flushes,
+	// so the commit sequence keeps growing when the log starts over
+	LogBase uint64 `json:"log_base,omitempty"`
+
+	// HistoryFrom is when a compaction or memtable flush last dropped
+	// superseded records, in Unix nanoseconds. The log holds every write
+	// since, so RestoreToTimestamp can go back to any time after it.
+	HistoryFrom uint64 `json:"history_from,omitempty"`
+}
+
+// ManifestSegment describes one live segment. MinSeq and MaxSeq bound the
+// timestamps of its records, which order writes across segments; they are
+// zero for the active segment, which is still growing.
+type ManifestSegment struct {
+	FileID     uint32 `json:"file_id"`
+	Path       string `json:"path"` // Relative to the primary data directory when inside it
+	Archived   bool   `json:"archived,omitempty"`
+	Generation uint64 `json:"generation"` // Manifest generation that last changed this entry
+	MinSeq     uint64 `json:"min_seq,omitempty"`
+	MaxSeq     uint64 `json:"max_seq,omitempty"`
+}
+
+// manifestState holds the last manifest written, guarded separately from
+// the store mutex because archiving updates it without that mutex
+type manifestState struct {
+	current     *StoreManifest
+	logBase     uint64               // LogBase for the next generation
+	historyFrom uint64               // HistoryFrom for the next generation
+	sealed      map[uint32][2]uint64 // Timestamp ranges of segments just sealed, saving a scan of them
+	mutex       sync.Mutex
+}
+
+// readManifest loads the manifest from dir, returning nil if there is none
+func readManifest(dir string) (*StoreManifest, error) {
+	data, err := os.ReadFile(filepath.Clean(filepath.Join(dir, manifestFile)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest StoreManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", manifestFile, err)
+	}
+	if manifest.Version > manifestVersion {
+		return nil, &KVError{fmt.Sprintf("%s version %d is newer than supported version %d",
+			manifestFile, manifest.Version, manifestVersion)}
+	}
+	return &manifest, nil
+}
+
+// writeManifest replaces the manifest in dir atomically, so a crash
+// leaves either the old generation or the new one
+func writeManifest(dir string, manifest *StoreManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return finalizeFile(filepath.Join(dir, manifestFile), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// Manifest returns a copy of the current manifest
+func (kv *KVStore) Manifest() *StoreManifest {
+	kv.manifest.mutex.Lock()
+	defer kv.manifest.mutex.Unlock()
+
+	if kv.manifest.current == nil {
+		return nil
+	}
+	manifest := *kv.manifest.current
+	manifest.Segments = append([]ManifestSegment(nil), manifest.Segments...)
+	return &manifest
+}
+
+// loadManifestInternal registers the segments listed in the manifest, or
+// writes a first manifest for a store that has none. A listed segment that
+// is missing, and not found by locateSegment either, fails the open rather
+// than silently losing its data. The caller must hold the mutex.
+func (kv *KVStore) loadManifestInternal() error {
+	manifest, err := readManifest(kv.config.DataDir)
+	if err != nil {
+		return err
+	}
+	if manifest == nil {
+		if kv.standby.enabled {
+			return nil // The primary writes it
+		}
+		return kv.saveManifest()
+	}
+
+	// Segments a compaction replaced since the last load are gone
+	listed := make(map[uint32]bool, len(manifest.Segments))
+	for _, seg := range manifest.Segments {
+		listed[seg.FileID] = true
+	}
+	for _, seg := range kv.segments.list() {
+		if !listed[seg.FileID] {
+			kv.segments.remove(seg.FileID)
+		}
+	}
+
+	relocated := false // A segment was found away from its listed path
+	for _, seg := range manifest.Segments {
+		if seg.FileID == activeFileID {
+			continue // Located by the placer, which knows every data directory
+		}
+		path := seg.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(kv.config.DataDir, path)
+		}
+		found, archived, err := kv.locateSegment(path)
+		if err != nil {
+			return &KVError{fmt.Sprintf("segment %d listed in %s is missing: %v", seg.FileID, manifestFile, err)}
+		}
+		relocated = relocated || found != path
+		kv.segments.add(seg.FileID, found)
+		if seg.Archived || archived {
+			kv.segments.mutex.Lock()
+			kv.segments.segments[seg.FileID].Archived = true
+			kv.segments.mutex.Unlock()
+			if !kv.standby.enabled {
+				kv.removeArchivedLeftovers(found)
+			}
+		}
+	}
+
+	kv.manifest.mutex.Lock()
+	kv.manifest.current = manifest
+	kv.manifest.logBase = manifest.LogBase
+	kv.manifest.historyFrom = manifest.HistoryFrom
+	kv.manifest.mutex.Unlock()
+	if relocated && !kv.standby.enabled {
+		return kv.saveManifest()
+	}
+	return nil
+}
+
+// locateSegment returns where a segment the manifest lists at path is. A
+// segment missing from path is looked for in ArchiveDir, where an archive
+// interrupted before rewriting the manifest left it, and then in the data
+// directories. The second result reports a segment found in ArchiveDir.
+func (kv *KVStore) locateSegment(path string) (string, bool, error) {
+	_, err := os.Stat(path)
+	if err == nil || !os.IsNotExist(err) {
+		return path, false, err
+	}
+	base := filepath.Base(path)
+	if kv.config.ArchiveDir != "" {
+		if candidate := filepath.Join(kv.config.ArchiveDir, base); candidate != path {
+			if _, statErr := os.Stat(candidate); statErr == nil {
+				return candidate, true, nil
+			}
+		}
+	}
+	for _, dir := range append([]string{kv.config.DataDir}, kv.config.DataDirs...) {
+		if candidate := filepath.Join(dir, base); candidate != path {
+			if _, statErr := os.Stat(candidate); statErr == nil {
+				return candidate, false, nil
+			}
+		}
+	}
+	return "", false, err
+}
+
+// removeArchivedLeftovers removes copies of an archived segment left in the
+// data directories by a crash between writing the manifest and removing
+// them. Failures are ignored; the next load tries again.
+func (kv *KVStore) removeArchivedLeftovers(archived string) {
+	base := filepath.Base(archived)
+	for _, dir := range append([]string{kv.config.DataDir}, kv.config.DataDirs...) {
+		leftover := filepath.Join(dir, base)
+		if _, err := os.Stat(leftover); err == nil && leftover != archived {
+			_ = removeFile(leftover)
+		}
+	}
+}
+
+// saveManifest writes a new manifest generation describing the segment
+// table. Entries for unchanged segments keep their generation and sequence
+// range; new sealed segments are scanned for theirs, unless rotation
+// already knew it.
+func (kv *KVStore) saveManifest() error {
+	kv.manifest.mutex.Lock()
+	defer kv.manifest.mutex.Unlock()
+	defer func() { kv.manifest.sealed = nil }()
+
+	previous := make(map[uint32]ManifestSegment)
+	next := &StoreManifest{Version: manifestVersion, Generation: 1, LogBase: kv.manifest.logBase,
+		HistoryFrom: kv.manifest.historyFrom}
+	if kv.manifest.current != nil {
+		next.Generation = kv.manifest.current.Generation + 1
+		next.NextFileID = kv.manifest.current.NextFileID
+		for _, seg := range kv.manifest.current.Segments {
+			previous[seg.FileID] = seg
+		}
+	}
+
+	for _, tier := range kv.segments.list() {
+		entry := ManifestSegment{
+			FileID:     tier.FileID,
+			Path:       kv.manifestPath(tier.Path),
+			Archived:   tier.Archived,
+			Generation: next.Generation,
+		}
+		if old, ok := previous[tier.FileID]; ok {
+			entry.MinSeq, entry.MaxSeq = old.MinSeq, old.MaxSeq
+			if old.Path == entry.Path && old.Archived == entry.Archived {
+				entry.Generation = old.Generation
+			}
+		}
+		if seqs, ok := kv.manifest.sealed[tier.FileID]; ok && entry.MaxSeq == 0 {
+			entry.MinSeq, entry.MaxSeq = seqs[0], seqs[1]
+		}
+		if tier.FileID != activeFileID && entry.MaxSeq == 0 {
+			var err error
+			if entry.MinSeq, entry.MaxSeq, err = segmentSeqRange(tier.Path); err != nil {
+				return fmt.Errorf("failed to scan segment %d: %w", tier.FileID, err)
+			}
+		}
+		if tier.FileID >= next.NextFileID {
+			next.NextFileID = tier.FileID + 1
+		}
+		next.Segments = append(next.Segments, entry)
+	}
+
+	if err := writeManifest(kv.config.DataDir, next); err != nil {
+		return fmt.Errorf("failed to write %s: %w", manifestFile, err)
+	}
+	kv.manifest.current = next
+	return nil
+}
+
+// segmentSeqRange returns the lowest and highest record timestamps in a
+// sealed segment. Only the headers are read, so encrypted values needn't
+// be decrypted.
+func segmentSeqRange(path string) (uint64, uint64, error) {
+	file, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	header := make([]byte, codec.HeaderSize)
+	var minSeq, maxSeq uint64
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err == io.EOF {
+				return minSeq, maxSeq, nil
+			}
+			return 0, 0, ErrCorruption
+		}
+		if _, err := reader.Discard(int(codec.DataSize(header))); err != nil {
+			return 0, 0, ErrCorruption
+		}
+		timestamp := binary.LittleEndian.Uint64(header[12:20])
+		if minSeq == 0 || timestamp < minSeq {
+			minSeq = timestamp
+		}
+		if timestamp > maxSeq {
+			maxSeq = timestamp
+		}
+	}
+}
+
+// removeStaleTempFiles deletes segment, manifest and shutdown temp files left in the
+// data and archive directories by a crash mid-write. The manifest never
+// refers to a temp file, so none of them can hold live data.
+func (kv *KVStore) removeStaleTempFiles() error {
+	dirs := append([]string{kv.config.DataDir}, kv.config.DataDirs...)
+	if kv.config.ArchiveDir != "" {
+		dirs = append(dirs, kv.config.ArchiveDir)
+	}
+
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || !isStaleTempFile(name) {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove stale temp file %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// isStaleTempFile reports whether name is a temp file written by the store
+func isStaleTempFile(name string) bool {
+	return name == manifestFile+tempSuffix || name == shutdownFile+tempSuffix ||
+		(strings.HasSuffix(name, tempSuffix) && strings.HasSuffix(strings.TrimSuffix(name, tempSuffix), ".data"))
+}
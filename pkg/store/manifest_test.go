@@ -0,0 +1,124 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKVStore_Manifest(t *testing.T) {
+	tmpDir := t.TempDir()
+	archiveDir := filepath.Join(t.TempDir(), "archive")
+	config := KVStoreConfig{DataDir: tmpDir, ArchiveDir: archiveDir, ArchiveAfter: time.Hour}
+
+	store, err := NewKVStore(config)
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+
+	// A new store gets a first generation listing the active segment
+	manifest := store.Manifest()
+	if manifest == nil || manifest.Generation != 1 || len(manifest.Segments) != 1 {
+		t.Fatalf("Unexpected initial manifest: %+v", manifest)
+	}
+	if manifest.Segments[0].Path != activeDataFile {
+		t.Errorf("Expected active segment path %s, got %s", activeDataFile, manifest.Segments[0].Path)
+	}
+
+	// Archiving a sealed segment writes a new generation
+	sealedPath := filepath.Join(tmpDir, "000001.data")
+	writer, err := NewLogWriter(LogWriterConfig{FilePath: sealedPath})
+	if err != nil {
+		t.Fatalf("Failed to create segment writer: %v", err)
+	}
+	if _, err := writer.Put([]byte("cold:1"), []byte("frozen")); err != nil {
+		t.Fatalf("Failed to write segment: %v", err)
+	}
+	if _, err := writer.Put([]byte("cold:2"), []byte("frozen")); err != nil {
+		t.Fatalf("Failed to write segment: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close segment writer: %v", err)
+	}
+	store.segments.add(1, sealedPath)
+	store.segments.mutex.Lock()
+	store.segments.segments[1].LastAccess = time.Now().Add(-2 * time.Hour)
+	store.segments.mutex.Unlock()
+
+	if moved, err := store.ArchiveColdSegments(); err != nil || len(moved) != 1 {
+		t.Fatalf("Expected segment 1 to be archived, got %v, %v", moved, err)
+	}
+	manifest = store.Manifest()
+	if manifest.Generation != 2 || manifest.NextFileID != 2 || len(manifest.Segments) != 2 {
+		t.Fatalf("Unexpected manifest after archiving: %+v", manifest)
+	}
+	sealed := manifest.Segments[1]
+	if !sealed.Archived || sealed.Generation != 2 || sealed.MinSeq == 0 || sealed.MaxSeq < sealed.MinSeq {
+		t.Errorf("Unexpected sealed segment entry: %+v", sealed)
+	}
+	if manifest.Segments[0].Generation != 1 {
+		t.Errorf("Expected the unchanged active entry to keep generation 1, got %d", manifest.Segments[0].Generation)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close store: %v", err)
+	}
+
+	// Stale temp files from an interrupted write are removed on open
+	staleFiles := []string{
+		filepath.Join(tmpDir, manifestFile+tempSuffix),
+		filepath.Join(archiveDir, "000002.data"+tempSuffix),
+	}
+	for _, path := range staleFiles {
+		if err := os.WriteFile(path, []byte("partial"), 0600); err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+	}
+	unrelated := filepath.Join(tmpDir, "notes.tmp")
+	if err := os.WriteFile(unrelated, []byte("keep"), 0600); err != nil {
+		t.Fatalf("Failed to create unrelated file: %v", err)
+	}
+
+	// Reopening restores the archived segment from the manifest
+	store, err = NewKVStore(config)
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to reopen KV store: %v", err)
+	}
+	tiers := store.SegmentTiers()
+	if len(tiers) != 2 || !tiers[1].Archived || tiers[1].Path != filepath.Join(archiveDir, "000001.data") {
+		t.Errorf("Unexpected segment tiers after reopen: %+v", tiers)
+	}
+	if store.Manifest().Generation != 2 {
+		t.Errorf("Expected reopening to keep generation 2, got %d", store.Manifest().Generation)
+	}
+	for _, path := range staleFiles {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed", path)
+		}
+	}
+	if _, err := os.Stat(unrelated); err != nil {
+		t.Errorf("Expected unrelated file to be kept: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close store: %v", err)
+	}
+
+	// A segment the manifest lists but that has vanished fails the open
+	if err := os.Remove(filepath.Join(archiveDir, "000001.data")); err != nil {
+		t.Fatalf("Failed to remove segment: %v", err)
+	}
+	store, err = NewKVStore(config)
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err == nil {
+		store.Close()
+		t.Error("Expected open to fail with a missing segment")
+	}
+}
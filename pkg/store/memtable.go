@@ -0,0 +1,340 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/codec"
+)
+
+// WriteMode selects how writes are organised on disk
+type WriteMode string
+
+const (
+	// WriteModeAppend appends every write to the active log, which only
+	// ever grows (default)
+	WriteModeAppend WriteMode = ""
+	// WriteModeMemtable also keeps recent writes in a memtable. When it
+	// fills up its latest records are written, in key order, to a new
+	// sealed segment and the active log is emptied. The log remains the
+	// write-ahead log, so writes are exactly as durable as in append mode.
+	WriteModeMemtable WriteMode = "memtable"
+)
+
+// DefaultMemtableSize is the memtable size that triggers a flush
+const DefaultMemtableSize = 4 << 20
+
+// memtableEntry is the latest write of a key held by the memtable
+type memtableEntry struct {
+	value     []byte // nil for a tombstone
+	offset    int64  // Position of the record in the active log
+	size      uint32
+	expiresAt uint64 // Unix nanoseconds (0 = never)
+}
+
+// memtable holds the latest record of every key written to the active log,
+// plus its range tombstones and sequence reservations, so they can be
+// flushed as a sorted segment. The methods on a nil memtable report it empty.
+type memtable struct {
+	entries map[string]*memtableEntry
+	special []memtableEntry // Range tombstones and sequence reservations, in log order
+	bytes   int64           // Record bytes the next flush writes
+}
+
+// newMemtable creates an empty memtable
+func newMemtable() *memtable {
+	return &memtable{entries: make(map[string]*memtableEntry)}
+}
+
+// put records a write of key at offset in the active log, expiring at
+// expiresAt; an empty value is a tombstone
+func (m *memtable) put(key, value []byte, offset int64, size uint32, expiresAt uint64) {
+	if old, ok := m.entries[string(key)]; ok {
+		m.bytes -= int64(old.size)
+	}
+
+	entry := &memtableEntry{offset: offset, size: size, expiresAt: expiresAt}
+	if len(value) > 0 {
+		entry.value = append([]byte(nil), value...)
+	}
+	m.entries[string(key)] = entry
+	m.bytes += int64(size)
+}
+
+// addSpecial records a range tombstone or sequence reservation at offset
+func (m *memtable) addSpecial(offset int64, size uint32) {
+	m.special = append(m.special, memtableEntry{offset: offset, size: size})
+	m.bytes += int64(size)
+}
+
+// deleteRange drops the writes a range tombstone deletes; its own record,
+// added with addSpecial, deletes any older versions in sealed segments
+func (m *memtable) deleteRange(rt RangeTombstone) {
+	for key, entry := range m.entries {
+		if rt.Contains([]byte(key)) {
+			m.bytes -= int64(entry.size)
+			delete(m.entries, key)
+		}
+	}
+}
+
+// get returns the latest value of key. ok is false if the memtable has no
+// write of key; a nil value with ok set means the key was deleted or has
+// expired.
+func (m *memtable) get(key []byte) (value []byte, ok bool) {
+	if m == nil {
+		return nil, false
+	}
+	entry, ok := m.entries[string(key)]
+	if !ok {
+		return nil, false
+	}
+	if expired(entry.expiresAt, time.Now()) {
+		return nil, true
+	}
+	return entry.value, true
+}
+
+// len returns the number of keys in the memtable
+func (m *memtable) len() int {
+	if m == nil {
+		return 0
+	}
+	return len(m.entries)
+}
+
+// size returns the bytes of records the next flush writes
+func (m *memtable) size() int64 {
+	if m == nil {
+		return 0
+	}
+	return m.bytes
+}
+
+// sortedKeys returns the memtable's keys in byte order
+func (m *memtable) sortedKeys() []string {
+	keys := make([]string, 0, len(m.entries))
+	for key := range m.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeSegment copies the memtable's records from the active log at logPath
+// into a new segment at path: range tombstones and sequence reservations
+// first, in log order, then every key's latest record in key order. The
+// records keep their timestamps and checksums. The segment is finalized
+// with finalizeFile, so it only appears at path once complete. It returns
+// each key's offset in the new segment.
+func (m *memtable) writeSegment(logPath, path string) (map[string]int64, error) {
+	log, err := os.Open(filepath.Clean(logPath))
+	if err != nil {
+		return nil, err
+	}
+	defer log.Close()
+
+	offsets := make(map[string]int64, len(m.entries))
+	err = finalizeFile(path, func(w io.Writer) error {
+		var written int64
+		copyRecord := func(entry memtableEntry) error {
+			if _, err := io.Copy(w, io.NewSectionReader(log, entry.offset, int64(entry.size))); err != nil {
+				return err
+			}
+			written += int64(entry.size)
+			return nil
+		}
+
+		for _, entry := range m.special {
+			if err := copyRecord(entry); err != nil {
+				return err
+			}
+		}
+		for _, key := range m.sortedKeys() {
+			offsets[key] = written
+			if err := copyRecord(*m.entries[key]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return offsets, nil
+}
+
+// loadMemtableInternal fills a new memtable from the records in the active
+// log, which a flush would otherwise lose when it empties the log (caller
+// must hold the mutex)
+func (kv *KVStore) loadMemtableInternal() error {
+	reader, err := NewLogReader(LogReaderConfig{FilePath: kv.dataFile, Codec: kv.codec})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	table := newMemtable()
+	for {
+		offset := reader.Offset()
+		record, err := reader.ReadNext()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		size := uint32(record.Size()) //nolint:gosec // record sizes fit in uint32
+		if start, end, ok := codec.DecodeRangeTombstone(record); ok {
+			table.deleteRange(RangeTombstone{Start: start, End: end, Timestamp: record.Timestamp})
+			table.addSpecial(offset, size)
+			continue
+		}
+		if codec.IsReservedKey(record.Key) {
+			table.addSpecial(offset, size)
+			continue
+		}
+		table.put(record.Key, record.Value, offset, size, record.ExpiresAt)
+	}
+
+	kv.memtable = table
+	return nil
+}
+
+// memtableSize is the configured flush threshold
+func (kv *KVStore) memtableSize() int64 {
+	if kv.config.MemtableSize > 0 {
+		return kv.config.MemtableSize
+	}
+	return DefaultMemtableSize
+}
+
+// FlushMemtable writes the memtable out as a sorted segment and empties the
+// active log. Writes wait for the flush. It does nothing in append mode or
+// when the memtable is empty, and waits for any outstanding Freeze.
+func (kv *KVStore) FlushMemtable() error {
+	kv.maintenance.Lock()
+	defer kv.maintenance.Unlock()
+
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if err := kv.checkOpenInternal(); err != nil {
+		return err
+	}
+	return kv.flushMemtableInternal()
+}
+
+// maybeFlushMemtableInternal flushes the memtable once it has reached
+// MemtableSize. A flush replaces segment files, so while the store is
+// frozen for a backup it is put off until a write after the Thaw. The write
+// that triggered the flush has already succeeded, and a failed flush leaves
+// its records in the log, so failures are only reported. The caller must
+// hold the mutex.
+func (kv *KVStore) maybeFlushMemtableInternal() {
+	if kv.memtable.size() < kv.memtableSize() || !kv.maintenance.TryLock() {
+		return
+	}
+	defer kv.maintenance.Unlock()
+
+	if err := kv.flushMemtableInternal(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error flushing memtable: %v\n", err)
+	}
+}
+
+// flushMemtableInternal writes the memtable to a new sealed segment, points
+// the index at it, records the segment in the manifest and then empties the
+// active log. A crash before the manifest is written leaves an unlisted
+// segment and the full log; a crash after it but before the log is emptied
+// replays records that are also in the segment. Neither loses a write. The
+// caller must hold the mutex and the maintenance lock.
+func (kv *KVStore) flushMemtableInternal() error {
+	table := kv.memtable
+	if table == nil || (table.len() == 0 && len(table.special) == 0) {
+		return nil
+	}
+
+	// The records are copied from the log file, so they must be on disk
+	if err := kv.writer.Sync(); err != nil {
+		return err
+	}
+
+	fileID := kv.nextFileIDInternal()
+	dir, err := kv.placer.Next()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, segmentFileName(fileID))
+	offsets, err := table.writeSegment(kv.dataFile, path)
+	if err != nil {
+		return fmt.Errorf("failed to write segment %d: %w", fileID, err)
+	}
+	kv.segments.add(fileID, path)
+
+	// Repoint keys whose latest write is the one just flushed
+	for key, offset := range offsets {
+		entry, ok := kv.index.Get([]byte(key))
+		if !ok || entry.FileID != activeFileID || entry.Offset != table.entries[key].offset {
+			continue
+		}
+		updated := *entry
+		updated.FileID = fileID
+		updated.Offset = offset
+		kv.index.Put([]byte(key), &updated)
+	}
+
+	logSize := kv.writer.Size()
+	kv.manifest.mutex.Lock()
+	kv.manifest.logBase += uint64(logSize) //nolint:gosec // log size is never negative
+	historyFrom := kv.manifest.historyFrom
+	kv.manifest.historyFrom = uint64(time.Now().UnixNano()) //nolint:gosec // Unix nanoseconds are positive
+	kv.manifest.mutex.Unlock()
+	if err := kv.saveManifest(); err != nil {
+		kv.manifest.mutex.Lock()
+		kv.manifest.logBase -= uint64(logSize) //nolint:gosec // log size is never negative
+		kv.manifest.historyFrom = historyFrom
+		kv.manifest.mutex.Unlock()
+		return err
+	}
+
+	if err := kv.writer.truncate(); err != nil {
+		return fmt.Errorf("failed to empty log after flush: %w", err)
+	}
+	kv.openSize -= logSize // Keep bytes written since open counting across the restart of the log
+	kv.memtable = newMemtable()
+	kv.memtableFlushes++
+	return nil
+}
+
+// nextFileIDInternal returns an unused segment FileID (caller must hold the
+// mutex)
+func (kv *KVStore) nextFileIDInternal() uint32 {
+	next := activeFileID + 1
+	kv.manifest.mutex.Lock()
+	if kv.manifest.current != nil {
+		next = max(next, kv.manifest.current.NextFileID)
+	}
+	kv.manifest.mutex.Unlock()
+
+	for _, seg := range kv.segments.list() {
+		next = max(next, seg.FileID+1)
+	}
+	return next
+}
+
+// segmentFileName is the file name of a sealed segment
+func segmentFileName(fileID uint32) string {
+	return fmt.Sprintf("%06d.data", fileID)
+}
+
+// logBaseInternal returns the log bytes emptied out by memtable flushes
+func (kv *KVStore) logBaseInternal() uint64 {
+	kv.manifest.mutex.Lock()
+	defer kv.manifest.mutex.Unlock()
+	return kv.manifest.logBase
+}
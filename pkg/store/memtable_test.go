@@ -0,0 +1,212 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestKVStore_MemtableFlush(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	open := func() *KVStore {
+		t.Helper()
+		store, err := NewKVStore(KVStoreConfig{
+			DataDir:      tmpDir,
+			WriteMode:    WriteModeMemtable,
+			MemtableSize: 1 << 20,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create KV store: %v", err)
+		}
+		if _, err := store.Open(); err != nil {
+			t.Fatalf("Failed to open KV store: %v", err)
+		}
+		return store
+	}
+
+	store := open()
+	for i := 9; i >= 0; i-- {
+		if err := store.Put([]byte(fmt.Sprintf("user:%d", i)), []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	if err := store.Put([]byte("user:3"), []byte("updated")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := store.Delete([]byte("user:4")); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if err := store.DeletePrefix([]byte("user:8")); err != nil {
+		t.Fatalf("Failed to delete prefix: %v", err)
+	}
+
+	if stats := store.Stats(); stats.MemtableKeys != 9 || stats.MemtableBytes == 0 {
+		t.Errorf("Expected 9 memtable keys, got %d (%d bytes)", stats.MemtableKeys, stats.MemtableBytes)
+	}
+	seq := store.CommitSeq()
+
+	if err := store.FlushMemtable(); err != nil {
+		t.Fatalf("Failed to flush memtable: %v", err)
+	}
+	stats := store.Stats()
+	if stats.MemtableKeys != 0 || stats.MemtableFlushes != 1 {
+		t.Errorf("Expected an empty memtable after one flush, got %d keys, %d flushes",
+			stats.MemtableKeys, stats.MemtableFlushes)
+	}
+	if store.writer.Size() != 0 {
+		t.Errorf("Expected the active log to be emptied, got %d bytes", store.writer.Size())
+	}
+	if store.CommitSeq() != seq {
+		t.Errorf("Expected the commit sequence to survive the flush, got %d, want %d", store.CommitSeq(), seq)
+	}
+
+	// The flushed segment holds the latest record of each key in key order
+	tiers := store.SegmentTiers()
+	if len(tiers) != 2 {
+		t.Fatalf("Expected the active log and one sealed segment, got %+v", tiers)
+	}
+	reader, err := NewLogReader(LogReaderConfig{FilePath: tiers[1].Path})
+	if err != nil {
+		t.Fatalf("Failed to open segment: %v", err)
+	}
+	var keys [][]byte
+	for {
+		record, err := reader.ReadNext()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read segment: %v", err)
+		}
+		if record.Key[0] != 0 {
+			keys = append(keys, record.Key)
+		}
+	}
+	reader.Close()
+	if len(keys) != 9 {
+		t.Errorf("Expected 9 records in the segment, got %d", len(keys))
+	}
+	for i := 1; i < len(keys); i++ {
+		if bytes.Compare(keys[i-1], keys[i]) >= 0 {
+			t.Errorf("Segment keys out of order: %q before %q", keys[i-1], keys[i])
+		}
+	}
+
+	check := func(store *KVStore) {
+		t.Helper()
+		if got, err := store.Get([]byte("user:3")); err != nil || string(got) != "updated" {
+			t.Errorf("Expected updated, got %q, %v", got, err)
+		}
+		if got, err := store.Get([]byte("user:0")); err != nil || string(got) != "v0" {
+			t.Errorf("Expected v0, got %q, %v", got, err)
+		}
+		for _, key := range []string{"user:4", "user:8"} {
+			if _, err := store.Get([]byte(key)); err != ErrKeyNotFound {
+				t.Errorf("Expected %s to be deleted, got %v", key, err)
+			}
+		}
+	}
+	check(store)
+
+	// Writes after the flush go to the emptied log and survive a restart
+	if err := store.Put([]byte("user:0"), []byte("new")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	store = open()
+	defer store.Close()
+	if got, err := store.Get([]byte("user:0")); err != nil || string(got) != "new" {
+		t.Errorf("Expected new after restart, got %q, %v", got, err)
+	}
+	if err := store.Put([]byte("user:0"), []byte("v0")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	check(store)
+	if got := store.Stats().UserKeys; got != 8 {
+		t.Errorf("Expected 8 keys after restart, got %d", got)
+	}
+}
+
+func TestKVStore_MemtableAutoFlush(t *testing.T) {
+	store, err := NewKVStore(KVStoreConfig{
+		DataDir:      t.TempDir(),
+		WriteMode:    WriteModeMemtable,
+		MemtableSize: 512,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	for i := 0; i < 100; i++ {
+		if err := store.Put([]byte(fmt.Sprintf("key:%03d", i)), bytes.Repeat([]byte("x"), 32)); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+
+	stats := store.Stats()
+	if stats.MemtableFlushes == 0 {
+		t.Fatal("Expected the memtable to flush once it reached MemtableSize")
+	}
+	if stats.MemtableBytes >= 512 {
+		t.Errorf("Expected the memtable to stay under its size, got %d bytes", stats.MemtableBytes)
+	}
+
+	it, err := store.ScanPrefix([]byte("key:"))
+	if err != nil {
+		t.Fatalf("Failed to scan: %v", err)
+	}
+	defer it.Close()
+	count := 0
+	for it.Next() {
+		count++
+	}
+	if it.Err() != nil || count != 100 {
+		t.Errorf("Expected 100 keys across segments, got %d, %v", count, it.Err())
+	}
+}
+
+func TestKVStore_MemtableFlushWaitsForThaw(t *testing.T) {
+	store, err := NewKVStore(KVStoreConfig{
+		DataDir:      t.TempDir(),
+		WriteMode:    WriteModeMemtable,
+		MemtableSize: 64,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	if _, err := store.Freeze(); err != nil {
+		t.Fatalf("Failed to freeze: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := store.Put([]byte(fmt.Sprintf("key:%d", i)), []byte("value")); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	if got := store.Stats().MemtableFlushes; got != 0 {
+		t.Errorf("Expected no flush while frozen, got %d", got)
+	}
+
+	if err := store.Thaw(); err != nil {
+		t.Fatalf("Failed to thaw: %v", err)
+	}
+	if err := store.Put([]byte("key:last"), []byte("value")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if got := store.Stats().MemtableFlushes; got != 1 {
+		t.Errorf("Expected one flush after thaw, got %d", got)
+	}
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// PlacementPolicy decides which data directory receives a new segment
+type PlacementPolicy string
+
+const (
+	// PlacementRoundRobin cycles through healthy directories (default)
+	PlacementRoundRobin PlacementPolicy = "round-robin"
+	// PlacementFreeSpace picks the healthy directory with the most free space
+	PlacementFreeSpace PlacementPolicy = "free-space"
+)
+
+// ErrNoHealthyDataDir is returned when no data directory can accept a segment
+var ErrNoHealthyDataDir = &KVError{"no healthy data directory available"}
+
+// DataDirHealth reports the state of one data directory
+type DataDirHealth struct {
+	Path      string
+	Healthy   bool
+	FreeBytes uint64 // 0 when the platform can't report free space
+	LastError string
+	CheckedAt time.Time
+}
+
+// dirPlacer spreads segments across data directories and isolates failed ones
+type dirPlacer struct {
+	policy PlacementPolicy
+	dirs   []*DataDirHealth
+	next   int
+	mutex  sync.Mutex
+}
+
+// newDirPlacer creates a placer over the primary directory plus any extras
+func newDirPlacer(primary string, extra []string, policy PlacementPolicy) *dirPlacer {
+	if policy == "" {
+		policy = PlacementRoundRobin
+	}
+
+	p := &dirPlacer{policy: policy}
+	seen := make(map[string]bool)
+	for _, dir := range append([]string{primary}, extra...) {
+		clean := filepath.Clean(dir)
+		if dir == "" || seen[clean] {
+			continue
+		}
+		seen[clean] = true
+		p.dirs = append(p.dirs, &DataDirHealth{Path: clean})
+	}
+	return p
+}
+
+// Check probes every directory by creating and removing a file. A failing
+// directory is marked unhealthy and skipped for new segments, but does not
+// affect segments already living on other directories.
+func (p *dirPlacer) Check() []DataDirHealth {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	health := make([]DataDirHealth, len(p.dirs))
+	for i, dir := range p.dirs {
+		err := probeDir(dir.Path)
+		dir.Healthy = err == nil
+		dir.LastError = ""
+		if err != nil {
+			dir.LastError = err.Error()
+		}
+		dir.FreeBytes = dirFreeBytes(dir.Path)
+		dir.CheckedAt = time.Now()
+		health[i] = *dir
+	}
+	return health
+}
+
+// Next returns the directory for a new segment according to the policy
+func (p *dirPlacer) Next() (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.policy == PlacementFreeSpace {
+		var best *DataDirHealth
+		for _, dir := range p.dirs {
+			if dir.Healthy && (best == nil || dir.FreeBytes > best.FreeBytes) {
+				best = dir
+			}
+		}
+		if best == nil {
+			return "", ErrNoHealthyDataDir
+		}
+		return best.Path, nil
+	}
+
+	for range p.dirs {
+		dir := p.dirs[p.next%len(p.dirs)]
+		p.next++
+		if dir.Healthy {
+			return dir.Path, nil
+		}
+	}
+	return "", ErrNoHealthyDataDir
+}
+
+// Locate returns the first directory already holding name, if any
+func (p *dirPlacer) Locate(name string) (string, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, dir := range p.dirs {
+		if _, err := os.Stat(filepath.Join(dir.Path, name)); err == nil {
+			return dir.Path, true
+		}
+	}
+	return "", false
+}
+
+// probeDir verifies a directory exists and is writable
+func probeDir(dir string) error {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+
+	probe, err := os.CreateTemp(dir, ".freyja-probe-*")
+	if err != nil {
+		return fmt.Errorf("directory not writable: %w", err)
+	}
+	name := probe.Name()
+	closeErr := probe.Close()
+	if err := os.Remove(name); err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// DataDirHealth re-checks every configured data directory and reports its state
+func (kv *KVStore) DataDirHealth() []DataDirHealth {
+	return kv.placer.Check()
+}
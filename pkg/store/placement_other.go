@@ -0,0 +1,13 @@
+//go:build !unix
+
+package store
+
+// dirFreeBytes is not supported on this platform
+func dirFreeBytes(string) uint64 {
+	return 0
+}
+
+// FilesystemUsage is not supported on this platform
+func FilesystemUsage(string) (used, total uint64, err error) {
+	return 0, 0, &KVError{"filesystem usage is not supported on this platform"}
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirPlacer(t *testing.T) {
+	base := t.TempDir()
+	a := filepath.Join(base, "a")
+	b := filepath.Join(base, "b")
+	broken := filepath.Join(base, "broken")
+
+	// A regular file where a directory is expected can never be probed
+	if err := os.WriteFile(broken, []byte("x"), 0600); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	placer := newDirPlacer(a, []string{b, broken, a}, PlacementRoundRobin)
+	health := placer.Check()
+	if len(health) != 3 {
+		t.Fatalf("Expected 3 distinct directories, got %d", len(health))
+	}
+	if !health[0].Healthy || !health[1].Healthy || health[2].Healthy {
+		t.Fatalf("Unexpected health: %+v", health)
+	}
+	if health[2].LastError == "" {
+		t.Error("Expected an error for the broken directory")
+	}
+
+	// Round robin skips the unhealthy directory
+	var got []string
+	for i := 0; i < 4; i++ {
+		dir, err := placer.Next()
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		got = append(got, dir)
+	}
+	want := []string{a, b, a, b}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected placement %v, got %v", want, got)
+		}
+	}
+
+	only := newDirPlacer(broken, nil, PlacementFreeSpace)
+	only.Check()
+	if _, err := only.Next(); err != ErrNoHealthyDataDir {
+		t.Errorf("Expected ErrNoHealthyDataDir, got %v", err)
+	}
+}
+
+func TestKVStore_LocatesActiveSegmentInAnyDataDir(t *testing.T) {
+	base := t.TempDir()
+	primary := filepath.Join(base, "primary")
+	secondary := filepath.Join(base, "secondary")
+
+	// Simulate an active segment that was placed on the secondary disk
+	seed, err := NewKVStore(KVStoreConfig{DataDir: secondary})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := seed.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	if err := seed.Put([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	seed.Close()
+
+	store, err := NewKVStore(KVStoreConfig{DataDir: primary, DataDirs: []string{secondary}})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	if value, err := store.Get([]byte("k")); err != nil || string(value) != "v" {
+		t.Errorf("Expected k=v from secondary directory, got %q, %v", value, err)
+	}
+	if health := store.DataDirHealth(); len(health) != 2 {
+		t.Errorf("Expected health for 2 directories, got %d", len(health))
+	}
+}
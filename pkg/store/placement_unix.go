@@ -0,0 +1,24 @@
+//go:build unix
+
+package store
+
+import "syscall"
+
+// dirFreeBytes returns the bytes available to unprivileged users on dir's filesystem
+func dirFreeBytes(dir string) uint64 {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0
+	}
+	return stat.Bavail * uint64(stat.Bsize) //nolint:gosec // block size is positive
+}
+
+// FilesystemUsage returns the used and total bytes of the filesystem holding dir
+func FilesystemUsage(dir string) (used, total uint64, err error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, 0, err
+	}
+	bsize := uint64(stat.Bsize) //nolint:gosec // block size is positive
+	return (stat.Blocks - stat.Bfree) * bsize, stat.Blocks * bsize, nil
+}
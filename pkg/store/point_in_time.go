@@ -0,0 +1,172 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/codec"
+)
+
+// ErrHistoryUnavailable is returned by RestoreToTimestamp for a time
+// before the last compaction or memtable flush, which dropped the records
+// needed to rebuild the store as it was then
+var ErrHistoryUnavailable = &KVError{"the log no longer holds the store's history at that time"}
+
+// RestoreResult reports what RestoreToTimestamp changed
+type RestoreResult struct {
+	Restored int // Keys written back with their value at the restore point
+	Deleted  int // Keys created since the restore point, deleted
+}
+
+// restoreOp is one write RestoreToTimestamp makes: the key's value at the
+// restore point, or a delete when value is nil
+type restoreOp struct {
+	key       []byte
+	value     []byte
+	expiresAt uint64
+	hash      uint64
+}
+
+// RestoreToTimestamp rolls the store back to how it was at t, for example
+// to undo an accidental bulk delete without a backup. Every record carries
+// its write time, so the log is replayed up to t and each key that changed
+// since is written back: keys deleted or overwritten get their old value
+// and expiry again, and keys created since are deleted. The rollback is
+// itself a set of new writes, so it is durable, watchers and caches see
+// it, and it can be undone by restoring to a time before it ran.
+//
+// Compaction and memtable flushes drop superseded records, so t must be
+// after the last of them (Manifest().HistoryFrom), or ErrHistoryUnavailable
+// is returned. Sequence counters and other reserved keys are left alone,
+// so IDs are never handed out twice. Writes wait while the store is
+// replayed.
+func (kv *KVStore) RestoreToTimestamp(t time.Time) (*RestoreResult, error) {
+	until := uint64(t.UnixNano()) //nolint:gosec // restore points are after 1970
+	result := &RestoreResult{}
+
+	err := kv.commit(func() error {
+		if err := kv.checkOpenInternal(); err != nil {
+			return err
+		}
+		if err := kv.ensureIndexInternal(); err != nil {
+			return err
+		}
+
+		kv.manifest.mutex.Lock()
+		historyFrom := kv.manifest.historyFrom
+		kv.manifest.mutex.Unlock()
+		if until < historyFrom {
+			return fmt.Errorf("%w: records before %s were compacted",
+				ErrHistoryUnavailable, time.Unix(0, int64(historyFrom)).UTC().Format(time.RFC3339Nano)) //nolint:gosec // nanosecond timestamps fit in int64
+		}
+
+		// Buffered writes must be on disk for the replay to see them
+		if err := kv.writer.Sync(); err != nil {
+			return err
+		}
+		past := NewHashIndex(HashIndexConfig{InternalKeyspaces: kv.config.InternalKeyspaces})
+		if err := kv.replayInternal(past, true, until); err != nil {
+			return fmt.Errorf("failed to replay the log: %w", err)
+		}
+
+		// Read every old value before writing, since a write can flush the
+		// memtable and empty the log the old entries point into
+		ops, err := kv.restoreOpsInternal(past, time.Now())
+		if err != nil {
+			return err
+		}
+		for _, op := range ops {
+			if op.value == nil {
+				if err := kv.deleteInternal(op.key); err != nil {
+					return err
+				}
+				result.Deleted++
+				continue
+			}
+			if err := kv.appendInternal(op.key, op.value, op.expiresAt, op.hash); err != nil {
+				return err
+			}
+			result.Restored++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// restoreOpsInternal compares the index with past, the index as of the
+// restore point, and returns the writes that turn one into the other
+// (caller must hold the mutex)
+func (kv *KVStore) restoreOpsInternal(past *HashIndex, now time.Time) ([]restoreOp, error) {
+	files := kv.newSegmentFiles()
+	defer files.close()
+
+	read := func(key string, entry *IndexEntry) ([]byte, error) {
+		record, err := kv.readRecordFromInternal(entry, files)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %q: %w", key, err)
+		}
+		if !bytes.Equal(record.Key, []byte(key)) {
+			return nil, fmt.Errorf("failed to read %q: found a record for another key", key)
+		}
+		return record.Value, nil
+	}
+
+	var ops []restoreOp
+	restore := func(key string, old, current *IndexEntry) error {
+		value, err := read(key, old)
+		if err != nil {
+			return err
+		}
+		// A key already written back, e.g. by an earlier restore, is left be
+		if current != nil && current.ExpiresAt == old.ExpiresAt {
+			currentValue, err := read(key, current)
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(currentValue, value) {
+				return nil
+			}
+		}
+		ops = append(ops, restoreOp{key: []byte(key), value: value, expiresAt: old.ExpiresAt, hash: old.ValueHash})
+		return nil
+	}
+
+	// Keys that exist now and were deleted, changed or created since
+	for _, key := range kv.index.Keys() {
+		if codec.IsReservedKey([]byte(key)) {
+			continue
+		}
+		current, _ := kv.index.Get([]byte(key))
+		old, existed := past.Get([]byte(key))
+		switch {
+		case existed && old.FileID == current.FileID && old.Offset == current.Offset:
+			continue // Same record
+		case !existed || old.expired(now):
+			ops = append(ops, restoreOp{key: []byte(key)})
+		default:
+			if err := restore(key, old, current); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Keys deleted since
+	for _, key := range past.Keys() {
+		if codec.IsReservedKey([]byte(key)) {
+			continue
+		}
+		if _, exists := kv.index.Get([]byte(key)); exists {
+			continue
+		}
+		if old, _ := past.Get([]byte(key)); !old.expired(now) {
+			if err := restore(key, old, nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ops, nil
+}
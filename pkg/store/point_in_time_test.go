@@ -0,0 +1,116 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestKVStore_RestoreToTimestamp(t *testing.T) {
+	tmpDir := t.TempDir()
+	open := func() *KVStore {
+		t.Helper()
+		store, err := NewKVStore(KVStoreConfig{DataDir: tmpDir, MaxSegmentSize: 256})
+		if err != nil {
+			t.Fatalf("Failed to create KV store: %v", err)
+		}
+		if _, err := store.Open(); err != nil {
+			t.Fatalf("Failed to open KV store: %v", err)
+		}
+		return store
+	}
+	get := func(store *KVStore, key, want string) {
+		t.Helper()
+		value, err := store.Get([]byte(key))
+		if want == "" {
+			if !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("Expected %s to be deleted, got %q, %v", key, value, err)
+			}
+			return
+		}
+		if err != nil || string(value) != want {
+			t.Errorf("Get(%s) = %q, %v; want %q", key, value, err, want)
+		}
+	}
+
+	store := open()
+	for i := 1; i <= 5; i++ {
+		if err := store.Put([]byte(fmt.Sprintf("user:%d", i)), []byte("v1")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := store.Put([]byte("user:2"), []byte("v2")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, err := store.NextID("orders"); err != nil {
+		t.Fatalf("NextID failed: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	mark := time.Now()
+	time.Sleep(time.Millisecond)
+
+	// An accidental bulk delete, an overwrite and a new key
+	if err := store.Delete([]byte("user:1")); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := store.DeleteRange([]byte("user:3"), []byte("user:5")); err != nil {
+		t.Fatalf("DeleteRange failed: %v", err)
+	}
+	if err := store.Put([]byte("user:2"), []byte("v3")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := store.Put([]byte("user:9"), []byte("new")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	id, err := store.NextID("orders")
+	if err != nil {
+		t.Fatalf("NextID failed: %v", err)
+	}
+
+	result, err := store.RestoreToTimestamp(mark)
+	if err != nil {
+		t.Fatalf("RestoreToTimestamp failed: %v", err)
+	}
+	if result.Restored != 4 || result.Deleted != 1 {
+		t.Errorf("Expected 4 keys restored and 1 deleted, got %+v", result)
+	}
+	want := map[string]string{"user:1": "v1", "user:2": "v2", "user:3": "v1", "user:4": "v1", "user:5": "v1", "user:9": ""}
+	for key, value := range want {
+		get(store, key, value)
+	}
+
+	// Sequences aren't rolled back, so IDs aren't handed out twice
+	if next, err := store.NextID("orders"); err != nil || next <= id {
+		t.Errorf("Expected an ID after %d, got %d, %v", id, next, err)
+	}
+
+	// Restoring to the same point again changes nothing
+	if result, err = store.RestoreToTimestamp(mark); err != nil || result.Restored+result.Deleted != 0 {
+		t.Errorf("Expected a no-op restore, got %+v, %v", result, err)
+	}
+
+	// The rollback survives a reopen
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	store = open()
+	defer store.Close()
+	for key, value := range want {
+		get(store, key, value)
+	}
+
+	// Compaction drops the history before it
+	if _, err := store.Compact(CompactOptions{}); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if store.Manifest().HistoryFrom == 0 {
+		t.Error("Expected compaction to set HistoryFrom")
+	}
+	if _, err := store.RestoreToTimestamp(mark); !errors.Is(err, ErrHistoryUnavailable) {
+		t.Errorf("Expected ErrHistoryUnavailable, got %v", err)
+	}
+	if _, err := store.RestoreToTimestamp(time.Now()); err != nil {
+		t.Errorf("Expected a restore to now to succeed, got %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+package store
+
+import (
+	"bytes"
+	"os"
+	"sort"
+
+	"github.com/ssargent/freyjadb/pkg/codec"
+)
+
+// DefaultReadaheadSize is how many bytes a sequential scan reads ahead
+const DefaultReadaheadSize = 256 * 1024
+
+// Sequential access detection
+const (
+	// sequentialGap is how far past the previous record a read may start
+	// and still count as sequential, so overwritten records in between
+	// don't break a run
+	sequentialGap = 4 * 1024
+
+	// sequentialRun is how many sequential reads in a row start readahead
+	sequentialRun = 2
+)
+
+// ScanStats reports how prefix iterators read their values and how well
+// readahead served them. A high PrefetchHits share of Reads with
+// PrefetchUsedBytes close to PrefetchedBytes means readahead is paying off;
+// a low one means the scan's records are scattered, e.g. until a compaction
+// with ClusterPrefix groups them.
+type ScanStats struct {
+	Reads             int64 // Values read
+	SequentialReads   int64 // Reads that started at or just after the previous record in the same segment
+	Prefetches        int64 // Readahead windows read
+	PrefetchedBytes   int64 // Bytes read ahead
+	PrefetchHits      int64 // Reads served from a readahead window
+	PrefetchUsedBytes int64 // Bytes of readahead windows that reads used
+}
+
+// HitRate returns the share of reads served from readahead windows
+func (s ScanStats) HitRate() float64 {
+	if s.Reads == 0 {
+		return 0
+	}
+	return float64(s.PrefetchHits) / float64(s.Reads)
+}
+
+// add adds other's counts to s
+func (s *ScanStats) add(other ScanStats) {
+	s.Reads += other.Reads
+	s.SequentialReads += other.SequentialReads
+	s.Prefetches += other.Prefetches
+	s.PrefetchedBytes += other.PrefetchedBytes
+	s.PrefetchHits += other.PrefetchHits
+	s.PrefetchUsedBytes += other.PrefetchUsedBytes
+}
+
+// readaheadSize returns the configured readahead, zero when disabled
+func (kv *KVStore) readaheadSize() int {
+	switch {
+	case kv.config.ReadaheadSize < 0:
+		return 0
+	case kv.config.ReadaheadSize == 0:
+		return DefaultReadaheadSize
+	default:
+		return kv.config.ReadaheadSize
+	}
+}
+
+// sortByLocationInternal orders keys by where their records are stored, so
+// reading them in turn is sequential (caller must hold the mutex)
+func (kv *KVStore) sortByLocationInternal(keys []string) {
+	type location struct {
+		key    string
+		fileID uint32
+		offset int64
+	}
+	locations := make([]location, len(keys))
+	for i, key := range keys {
+		locations[i].key = key
+		if entry, ok := kv.index.Get([]byte(key)); ok {
+			locations[i].fileID, locations[i].offset = entry.FileID, entry.Offset
+		}
+	}
+	sort.Slice(locations, func(i, j int) bool {
+		if locations[i].fileID != locations[j].fileID {
+			return locations[i].fileID < locations[j].fileID
+		}
+		return locations[i].offset < locations[j].offset
+	})
+	for i := range locations {
+		keys[i] = locations[i].key
+	}
+}
+
+// prefetcher watches the records an iterator reads and, once they run
+// sequentially through a segment, reads a window of the segment at a time
+// so the disk sees large reads instead of one per record. It also asks the
+// kernel to start reading the window after that (see adviseWillNeed).
+type prefetcher struct {
+	size  int
+	codec *codec.RecordCodec
+	files *segmentFiles
+	stats ScanStats
+
+	// Where the previous record ended, and how many reads in a row were
+	// sequential
+	lastFile uint32
+	lastEnd  int64
+	run      int
+
+	// The current window. For the active segment it is only valid while
+	// the log is the one it was read from.
+	window     []byte
+	windowFile uint32
+	windowAt   int64
+	windowLog  *LogWriter
+	windowBase uint64
+}
+
+func newPrefetcher(size int, recordCodec *codec.RecordCodec) *prefetcher {
+	return &prefetcher{size: size, codec: recordCodec}
+}
+
+// read returns the record of entry from the current window, reading a new
+// window first if the scan is sequential. limit caps the window in the
+// active segment to bytes known to be on disk. It returns nil when the
+// record should be read the usual way. The caller must hold the mutex.
+func (p *prefetcher) read(kv *KVStore, key []byte, entry *IndexEntry, limit int64) *codec.Record {
+	p.stats.Reads++
+	end := entry.Offset + int64(entry.Size)
+	sequential := entry.FileID == p.lastFile && entry.Offset >= p.lastEnd && entry.Offset-p.lastEnd <= sequentialGap
+	p.lastFile, p.lastEnd = entry.FileID, end
+	if !sequential {
+		p.run = 0
+		return nil
+	}
+	p.stats.SequentialReads++
+	p.run++
+	if p.size == 0 || p.run < sequentialRun {
+		return nil
+	}
+
+	if !p.covers(kv, entry) {
+		if !p.fill(kv, entry, limit) {
+			return nil
+		}
+	}
+
+	start := entry.Offset - p.windowAt
+	record, err := p.codec.Decode(p.window[start : start+int64(entry.Size)])
+	if err != nil || record.Validate() != nil || !bytes.Equal(record.Key, key) {
+		return nil // Let the usual read detect and repair it
+	}
+	p.stats.PrefetchHits++
+	p.stats.PrefetchUsedBytes += int64(entry.Size)
+	return record
+}
+
+// covers reports whether the current window holds entry's record
+func (p *prefetcher) covers(kv *KVStore, entry *IndexEntry) bool {
+	if p.window == nil || entry.FileID != p.windowFile {
+		return false
+	}
+	if entry.FileID == activeFileID && (p.windowLog != kv.writer || p.windowBase != kv.logBaseInternal()) {
+		return false
+	}
+	return entry.Offset >= p.windowAt && entry.Offset+int64(entry.Size) <= p.windowAt+int64(len(p.window))
+}
+
+// fill reads the window starting at entry's record, reporting whether it
+// holds the record
+func (p *prefetcher) fill(kv *KVStore, entry *IndexEntry, limit int64) bool {
+	p.window = nil
+	path, ok := kv.segments.touch(entry.FileID)
+	if !ok {
+		return false
+	}
+	if p.files == nil {
+		p.files = kv.newSegmentFiles()
+	}
+	file, open := p.files.files[entry.FileID]
+	if !open {
+		var err error
+		if file, err = os.Open(path); err != nil { //nolint:gosec // Segment path from the store's own table
+			return false
+		}
+		p.files.files[entry.FileID] = file
+	}
+
+	size := max(int64(p.size), int64(entry.Size))
+	if entry.FileID == activeFileID {
+		size = min(size, limit-entry.Offset)
+	}
+	if size < int64(entry.Size) {
+		return false
+	}
+	window := make([]byte, size)
+	n, err := file.ReadAt(window, entry.Offset)
+	if n < int(entry.Size) {
+		return false
+	}
+	if err == nil {
+		adviseWillNeed(file, entry.Offset+size, size)
+	}
+
+	p.window, p.windowFile, p.windowAt = window[:n], entry.FileID, entry.Offset
+	p.windowLog, p.windowBase = kv.writer, kv.logBaseInternal()
+	p.stats.Prefetches++
+	p.stats.PrefetchedBytes += int64(n)
+	return true
+}
+
+// close releases the window and the segment files
+func (p *prefetcher) close() {
+	p.window = nil
+	if p.files != nil {
+		p.files.close()
+		p.files = nil
+	}
+}
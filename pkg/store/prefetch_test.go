@@ -0,0 +1,83 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// scanAll reads every pair under prefix, returning the iterator's stats
+func scanAll(t *testing.T, kv *KVStore, prefix string) (map[string][]byte, ScanStats) {
+	t.Helper()
+	it, err := kv.ScanPrefix([]byte(prefix))
+	if err != nil {
+		t.Fatalf("ScanPrefix failed: %v", err)
+	}
+	defer it.Close()
+
+	pairs := make(map[string][]byte)
+	for it.Next() {
+		pairs[string(it.Key())] = it.Value()
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	return pairs, it.Stats()
+}
+
+func TestPrefixIterator_Readahead(t *testing.T) {
+	kv, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir(), ReadaheadSize: 4096})
+	if err != nil {
+		t.Fatalf("Failed to create KVStore: %v", err)
+	}
+	if _, err := kv.Open(); err != nil {
+		t.Fatalf("Failed to open KVStore: %v", err)
+	}
+	defer kv.Close()
+
+	const n = 200
+	for i := 0; i < n; i++ {
+		value := bytes.Repeat([]byte{byte(i)}, 100)
+		if err := kv.Put([]byte(fmt.Sprintf("user:%03d", i)), value); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	// Overwrites and deletes leave gaps the scan has to skip
+	if err := kv.Put([]byte("user:010"), []byte("updated")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := kv.Delete([]byte("user:020")); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	pairs, stats := scanAll(t, kv, "user:")
+	if len(pairs) != n-1 {
+		t.Fatalf("scanned %d pairs, want %d", len(pairs), n-1)
+	}
+	if string(pairs["user:010"]) != "updated" {
+		t.Errorf("user:010 = %q, want the update", pairs["user:010"])
+	}
+	if !bytes.Equal(pairs["user:150"], bytes.Repeat([]byte{150}, 100)) {
+		t.Errorf("user:150 = %v", pairs["user:150"])
+	}
+
+	if stats.Reads != n-1 || stats.Prefetches == 0 {
+		t.Errorf("stats = %+v, want %d reads and some prefetches", stats, n-1)
+	}
+	if stats.HitRate() < 0.9 {
+		t.Errorf("hit rate = %.2f, want most reads served by readahead (%+v)", stats.HitRate(), stats)
+	}
+	if stats.PrefetchUsedBytes > stats.PrefetchedBytes {
+		t.Errorf("used %d of %d prefetched bytes", stats.PrefetchUsedBytes, stats.PrefetchedBytes)
+	}
+	if got := kv.Stats().Scans; got != stats {
+		t.Errorf("store scan stats = %+v, want the scan's %+v", got, stats)
+	}
+
+	// With readahead disabled every read goes to disk
+	kv.config.ReadaheadSize = -1
+	_, stats = scanAll(t, kv, "user:")
+	if stats.Prefetches != 0 || stats.PrefetchHits != 0 || stats.SequentialReads == 0 {
+		t.Errorf("stats without readahead = %+v", stats)
+	}
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/codec"
+)
+
+// PrefixStats summarizes the live keys under a key prefix
+type PrefixStats struct {
+	Prefix       string    `json:"prefix"`
+	Keys         int       `json:"keys"`
+	Bytes        int64     `json:"bytes"`       // Record bytes: headers, keys and values
+	ValueBytes   int64     `json:"value_bytes"` // Of Bytes, the values
+	AvgValueSize float64   `json:"avg_value_size"`
+	LastWrite    time.Time `json:"last_write"` // Latest put or delete seen under the prefix; zero once it holds no keys
+}
+
+// prefixCounters are maintained for each interned prefix as keys directly
+// under it are written, so prefix statistics are summed over the prefix
+// tree instead of counted key by key
+type prefixCounters struct {
+	keys       int
+	bytes      int64
+	valueBytes int64
+	lastWrite  uint64 // Record timestamp, including tombstones
+}
+
+// add counts a key's new entry
+func (c *prefixCounters) add(key string, entry *IndexEntry) {
+	c.keys++
+	c.bytes += int64(entry.Size)
+	c.valueBytes += entry.valueSize(key)
+	c.touch(entry.Timestamp)
+}
+
+// remove uncounts a key's replaced or deleted entry
+func (c *prefixCounters) remove(key string, entry *IndexEntry) {
+	c.keys--
+	c.bytes -= int64(entry.Size)
+	c.valueBytes -= entry.valueSize(key)
+}
+
+// valueSize is the size of the value in the entry's record of key
+func (e *IndexEntry) valueSize(key string) int64 {
+	size := int64(e.Size) - recordHeaderSize - int64(len(key))
+	if e.ExpiresAt != 0 {
+		size -= codec.ExpirySize
+	}
+	return size
+}
+
+// touch records a write at timestamp
+func (c *prefixCounters) touch(timestamp uint64) {
+	c.lastWrite = max(c.lastWrite, timestamp)
+}
+
+// merge adds other's counts
+func (c *prefixCounters) merge(other prefixCounters) {
+	c.keys += other.keys
+	c.bytes += other.bytes
+	c.valueBytes += other.valueBytes
+	c.touch(other.lastWrite)
+}
+
+// PrefixStats returns the keys, bytes and last write under prefix. Prefixes
+// ending in the delimiter, such as "users:", are answered from counters
+// kept for each interned prefix, visiting only the prefix tree. A prefix
+// ending mid-component, such as "users:a", also reads the entries of the
+// one bucket it splits.
+func (idx *HashIndex) PrefixStats(prefix string) PrefixStats {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	var total prefixCounters
+	boundary, partial := idx.splitKey(prefix)
+	if partial == "" {
+		idx.sumSubtreeInternal(&total, boundary)
+	} else {
+		for _, child := range idx.children[boundary] {
+			if strings.HasPrefix(child, prefix) {
+				idx.sumSubtreeInternal(&total, child)
+			}
+		}
+		if id, ok := idx.lookupPrefix(boundary); ok {
+			for suffix, entry := range idx.entries[id] {
+				if strings.HasPrefix(suffix, partial) {
+					total.add(boundary+suffix, entry)
+				}
+			}
+		}
+	}
+
+	stats := PrefixStats{
+		Prefix:     prefix,
+		Keys:       total.keys,
+		Bytes:      total.bytes,
+		ValueBytes: total.valueBytes,
+	}
+	if total.keys > 0 {
+		stats.AvgValueSize = float64(total.valueBytes) / float64(total.keys)
+	}
+	if total.lastWrite > 0 {
+		stats.LastWrite = time.Unix(0, int64(total.lastWrite)).UTC() //nolint:gosec // nanosecond timestamps fit in int64
+	}
+	return stats
+}
+
+// sumSubtreeInternal adds the counters of prefix and every prefix under it
+// (caller must hold a lock)
+func (idx *HashIndex) sumSubtreeInternal(total *prefixCounters, prefix string) {
+	if id, ok := idx.lookupPrefix(prefix); ok {
+		total.merge(idx.counters[id])
+	}
+	for _, child := range idx.children[prefix] {
+		idx.sumSubtreeInternal(total, child)
+	}
+}
+
+// PrefixStats returns the number of live keys under prefix, their record
+// and value bytes, and when a key under it was last written or deleted.
+// The counts are maintained as keys are written, so the cost depends on
+// the number of distinct prefixes under prefix rather than on its keys.
+func (kv *KVStore) PrefixStats(prefix []byte) PrefixStats {
+	return kv.index.PrefixStats(string(prefix))
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/codec"
+)
+
+// PutStream stores a value of exactly size bytes read from r, writing it to
+// the log as it arrives instead of buffering it first. The record's CRC is
+// computed over the stream. If r ends early or fails, nothing is stored.
+//
+// Writes are serialized through the log, so other writers wait while r is
+// read; give r a deadline if it comes from the network. With a memtable,
+// DedupeWrites or Encryption, the value is still read into memory, since
+// they need it whole.
+func (kv *KVStore) PutStream(key []byte, size int64, r io.Reader) error {
+	return kv.commit(func() error { return kv.putStreamInternal(key, size, r) })
+}
+
+// putStreamInternal streams a value into the log (caller must hold the mutex)
+func (kv *KVStore) putStreamInternal(key []byte, size int64, r io.Reader) error {
+	if err := kv.checkOpenInternal(); err != nil {
+		return err
+	}
+	if len(key) == 0 || codec.IsReservedKey(key) {
+		return ErrInvalidKey
+	}
+	if size <= 0 {
+		return errors.New("streamed values must not be empty") // An empty value is a tombstone
+	}
+	if recordHeaderSize+int64(len(key))+size > int64(^uint32(0)) ||
+		(kv.config.MaxRecordSize > 0 && int64(len(key))+size > int64(kv.config.MaxRecordSize)) {
+		return ErrRecordSizeExceeded
+	}
+	if err := kv.admitWritesInternal(key); err != nil {
+		return err
+	}
+
+	if kv.memtable != nil || kv.config.DedupeWrites || kv.config.Encryption != nil {
+		value := make([]byte, size)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return err
+		}
+		return kv.putInternal(key, value)
+	}
+
+	timing := kv.writeTiming
+	timing.lap(phaseValidate)
+	timing.record(key)
+	appender, err := kv.writer.Begin(key, uint32(size))
+	if err != nil {
+		return err
+	}
+	timing.lap(phaseLockWait)
+	if _, err := io.CopyN(appender, r, size); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return errors.Join(err, appender.Abort())
+	}
+	timing.lap(phaseBuffer) // Includes reading the stream
+	offset, timestamp, err := appender.Commit()
+	if err != nil {
+		return err
+	}
+	timing.lap(phaseFsync)
+
+	recordSize := uint32(recordHeaderSize + int64(len(key)) + size) //nolint:gosec // checked above
+	entry := &IndexEntry{
+		FileID:    activeFileID,
+		Offset:    offset,
+		Size:      recordSize,
+		Timestamp: timestamp,
+	}
+	kv.index.Put(key, entry)
+	kv.stats.Count(StatPuts, 1)
+	kv.stats.Count(StatBytesWritten, int64(recordSize))
+	kv.writes.record(key, kv.index.delimiter, time.Now())
+
+	// Watchers get the whole value, so read it back only for them. The
+	// value is stored either way; if it can't be read, the watchers would
+	// miss it, so they are ended instead.
+	if len(kv.watchers) > 0 {
+		record, err := kv.readKeyInternal(key, entry)
+		if err != nil {
+			kv.closeWatchersInternal(err)
+			return nil
+		}
+		kv.publishInternal(key, record.Value, timestamp)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestKVStore_PutStream(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewKVStore(KVStoreConfig{DataDir: dir})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+
+	value := bytes.Repeat([]byte("streamed "), 10000)
+	if err := store.PutStream([]byte("big"), int64(len(value)), bytes.NewReader(value)); err != nil {
+		t.Fatalf("Failed to put stream: %v", err)
+	}
+	got, err := store.Get([]byte("big"))
+	if err != nil || !bytes.Equal(got, value) {
+		t.Fatalf("Expected the streamed value back, got %d bytes (%v)", len(got), err)
+	}
+
+	// A stream that ends early stores nothing and leaves no partial record
+	size := store.writer.Size()
+	err = store.PutStream([]byte("short"), 100, strings.NewReader("only ten b"))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected unexpected EOF, got %v", err)
+	}
+	if _, err := store.Get([]byte("short")); err != ErrKeyNotFound {
+		t.Errorf("Expected short to be missing, got %v", err)
+	}
+	if store.writer.Size() != size {
+		t.Errorf("Expected log size %d after a failed stream, got %d", size, store.writer.Size())
+	}
+
+	if err := store.PutStream(nil, 1, strings.NewReader("v")); err != ErrInvalidKey {
+		t.Errorf("Expected ErrInvalidKey, got %v", err)
+	}
+	if err := store.PutStream([]byte("empty"), 0, strings.NewReader("")); err == nil {
+		t.Error("Expected an error for an empty stream")
+	}
+
+	// The record survives a reopen, which validates every CRC in the log
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	store, err = NewKVStore(KVStoreConfig{DataDir: dir})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	result, err := store.Open()
+	if err != nil {
+		t.Fatalf("Failed to reopen KV store: %v", err)
+	}
+	defer store.Close()
+	if result.RecordsTruncated != 0 {
+		t.Errorf("Expected no truncated records, got %+v", result)
+	}
+	if got, err := store.Get([]byte("big")); err != nil || !bytes.Equal(got, value) {
+		t.Errorf("Expected the streamed value after reopen, got %d bytes (%v)", len(got), err)
+	}
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Quiesce is an outstanding KVStore.Quiesce. While it is held, writes
+// block, compaction and segment rotation wait, and every byte of the store
+// is durable on disk, so the data directories can be snapshotted or
+// detached. Reads carry on.
+type Quiesce struct {
+	kv       *KVStore
+	manifest *BackupManifest
+	since    time.Time
+	once     sync.Once
+}
+
+// Quiesce blocks new writes, waits for those in flight, and flushes and
+// fsyncs the active log. It returns once the store is quiet, or with
+// ctx's error if the in-flight writes don't finish in time, in which case
+// nothing is held. A compaction or rotation in progress is waited for
+// regardless of ctx. Writes stay blocked until Release is called, so
+// always release, e.g. with a timer for operators who may forget. Only
+// one quiesce is held at a time; others wait for it to be released.
+func (kv *KVStore) Quiesce(ctx context.Context) (*Quiesce, error) {
+	if err := kv.lockWrites(ctx); err != nil {
+		return nil, err
+	}
+
+	manifest, err := kv.Freeze()
+	if err != nil {
+		kv.writeGate.Unlock()
+		return nil, err
+	}
+
+	kv.mutex.Lock()
+	kv.quiesced = true
+	kv.mutex.Unlock()
+	return &Quiesce{kv: kv, manifest: manifest, since: time.Now()}, nil
+}
+
+// lockWrites blocks new writes and waits for those in flight, giving up
+// with ctx's error, and holding nothing, if they don't finish in time.
+// kv.writeGate.Unlock lets writes resume.
+func (kv *KVStore) lockWrites(ctx context.Context) error {
+	acquired := make(chan struct{})
+	go func() {
+		kv.writeGate.Lock()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		return nil
+	case <-ctx.Done():
+		go func() {
+			<-acquired
+			kv.writeGate.Unlock()
+		}()
+		return ctx.Err()
+	}
+}
+
+// Manifest lists the segments and their sizes, which don't change while
+// the quiesce is held
+func (q *Quiesce) Manifest() *BackupManifest {
+	return q.manifest
+}
+
+// Since returns when the store became quiet
+func (q *Quiesce) Since() time.Time {
+	return q.since
+}
+
+// Release lets writes, compaction and rotation resume. Releasing again does
+// nothing.
+func (q *Quiesce) Release() {
+	q.once.Do(func() {
+		q.kv.mutex.Lock()
+		q.kv.quiesced = false
+		q.kv.mutex.Unlock()
+
+		_ = q.kv.Thaw()
+		q.kv.writeGate.Unlock()
+	})
+}
+
+// IsQuiesced reports whether a Quiesce is held
+func (kv *KVStore) IsQuiesced() bool {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	return kv.quiesced
+}
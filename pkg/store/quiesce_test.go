@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQuiesce(t *testing.T) {
+	kv, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir(), FsyncInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("Failed to create KVStore: %v", err)
+	}
+	if _, err := kv.Open(); err != nil {
+		t.Fatalf("Failed to open KVStore: %v", err)
+	}
+	defer kv.Close()
+
+	if err := kv.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	q, err := kv.Quiesce(context.Background())
+	if err != nil {
+		t.Fatalf("Quiesce failed: %v", err)
+	}
+	if !kv.IsQuiesced() {
+		t.Error("IsQuiesced = false while quiesced")
+	}
+	// The buffered write must be on disk
+	if got := q.Manifest().Segments[0].Size; got != kv.writer.Size() || got == 0 {
+		t.Errorf("manifest size = %d, want durable size %d", got, kv.writer.Size())
+	}
+
+	written := make(chan error, 1)
+	go func() { written <- kv.Put([]byte("b"), []byte("2")) }()
+	select {
+	case err := <-written:
+		t.Fatalf("Put finished while quiesced: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Reads carry on
+	if value, err := kv.Get([]byte("a")); err != nil || string(value) != "1" {
+		t.Errorf("Get(a) = %q, %v while quiesced", value, err)
+	}
+
+	// A second quiesce waits for the first
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := kv.Quiesce(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("second Quiesce error = %v, want DeadlineExceeded", err)
+	}
+
+	q.Release()
+	q.Release() // No-op
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatalf("Put failed after release: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Put still blocked after release")
+	}
+	if kv.IsQuiesced() {
+		t.Error("IsQuiesced = true after release")
+	}
+
+	// The abandoned second quiesce left nothing held
+	q, err = kv.Quiesce(context.Background())
+	if err != nil {
+		t.Fatalf("Quiesce after release failed: %v", err)
+	}
+	q.Release()
+	if err := kv.Put([]byte("c"), []byte("3")); err != nil {
+		t.Errorf("Put after second release failed: %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package store
+
+import (
+	"bytes"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/bptree"
+)
+
+// orderedIndexOrder is the order of the B+Tree holding the index's keys in
+// sorted order for range scans
+const orderedIndexOrder = 64
+
+// RangeOptions configures a Range scan
+type RangeOptions struct {
+	Limit    int  // Return at most this many pairs (0 = no limit)
+	Reverse  bool // Return keys in descending order
+	KeysOnly bool // Don't read values; Value returns nil
+}
+
+// Range returns an iterator over the key-value pairs with keys in
+// [start, end), in key order. A nil start means no lower bound and a nil end
+// no upper bound. Like ScanPrefix, the matching keys are captured when the
+// iterator is created and each value is read by Next; keys deleted or
+// expired after the scan started are skipped, so a scan with a Limit may
+// return fewer pairs than are left in the range.
+//
+// The hash index keeps no key order, so the first Range builds a sorted copy
+// of the keys, which the index then keeps up to date with every write.
+// Stores that never call Range don't pay for it.
+func (kv *KVStore) Range(start, end []byte, opts RangeOptions) (*RangeIterator, error) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if err := kv.checkOpenInternal(); err != nil {
+		return nil, err
+	}
+
+	keys := kv.index.KeysInRange(start, end, opts.Limit, opts.Reverse, time.Now())
+	return &RangeIterator{
+		scan: &PrefixIterator{
+			kv:       kv,
+			keys:     keys,
+			prefetch: newPrefetcher(kv.readaheadSize(), kv.codec),
+		},
+		keysOnly: opts.KeysOnly,
+	}, nil
+}
+
+// RangeIterator walks the pairs of a Range scan in key order. It is used
+// like a PrefixIterator:
+//
+//	it, err := kv.Range([]byte("user:100"), []byte("user:200"), store.RangeOptions{Limit: 50})
+//	if err != nil {
+//		return err
+//	}
+//	defer it.Close()
+//	for it.Next() {
+//		use(it.Key(), it.Value())
+//	}
+//	return it.Err()
+type RangeIterator struct {
+	scan     *PrefixIterator
+	keysOnly bool
+}
+
+// Next advances to the next pair, returning false when the scan is finished,
+// fails, or the iterator is closed
+func (it *RangeIterator) Next() bool {
+	if !it.keysOnly {
+		return it.scan.Next()
+	}
+
+	scan := it.scan
+	scan.current = KeyValuePair{}
+	for !scan.closed && scan.err == nil && scan.pos < len(scan.keys) {
+		key := []byte(scan.keys[scan.pos])
+		scan.keys[scan.pos] = ""
+		scan.pos++
+
+		live, err := scan.live(key)
+		if err != nil {
+			scan.err = err
+			return false
+		}
+		if live {
+			scan.current = KeyValuePair{Key: key}
+			return true
+		}
+	}
+	scan.finish()
+	return false
+}
+
+// live reports whether key still exists and hasn't expired
+func (it *PrefixIterator) live(key []byte) (bool, error) {
+	kv := it.kv
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if err := kv.checkOpenInternal(); err != nil {
+		return false, err
+	}
+	entry, exists := kv.index.Get(key)
+	return exists && !entry.expired(time.Now()), nil
+}
+
+// Key returns the current pair's key
+func (it *RangeIterator) Key() []byte {
+	return it.scan.Key()
+}
+
+// Value returns the current pair's value, nil for a KeysOnly scan
+func (it *RangeIterator) Value() []byte {
+	return it.scan.Value()
+}
+
+// Err returns the error that stopped the scan, if any
+func (it *RangeIterator) Err() error {
+	return it.scan.Err()
+}
+
+// Stats reports how the scan has read its values so far
+func (it *RangeIterator) Stats() ScanStats {
+	return it.scan.Stats()
+}
+
+// Close stops the scan and releases its remaining keys. It is safe to call
+// more than once.
+func (it *RangeIterator) Close() error {
+	return it.scan.Close()
+}
+
+// KeysInRange returns up to limit unexpired keys in [start, end), ascending
+// or, with reverse, descending (limit 0 = all). A nil start or end leaves
+// that side unbounded.
+func (idx *HashIndex) KeysInRange(start, end []byte, limit int, reverse bool, now time.Time) []string {
+	// The write lock lets the first call build the ordered keys
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	if idx.ordered == nil {
+		idx.buildOrderedInternal()
+	}
+	if end != nil && bytes.Compare(start, end) >= 0 {
+		return nil
+	}
+
+	var keys []string
+	idx.ordered.Ascend(start, end, func(key, _ []byte) bool {
+		if entry, ok := idx.getInternal(string(key)); ok && !entry.expired(now) {
+			keys = append(keys, string(key))
+		}
+		// Going forward the scan can stop at the limit; in reverse the
+		// last keys of the range are wanted
+		return reverse || limit <= 0 || len(keys) < limit
+	})
+	if reverse {
+		slices.Reverse(keys)
+		if limit > 0 && len(keys) > limit {
+			keys = keys[:limit]
+		}
+	}
+	return keys
+}
+
+// buildOrderedInternal bulk loads every key into the ordered key tree
+// (caller must hold the write lock)
+func (idx *HashIndex) buildOrderedInternal() {
+	keys := make([]string, 0, idx.size)
+	for id, bucket := range idx.entries {
+		prefix := idx.prefixes[id]
+		for suffix := range bucket {
+			keys = append(keys, prefix+suffix)
+		}
+	}
+	sort.Strings(keys)
+
+	pairs := make([]bptree.Pair, len(keys))
+	for i, key := range keys {
+		pairs[i] = bptree.Pair{Key: []byte(key)}
+	}
+	tree, err := bptree.BulkLoad(orderedIndexOrder, pairs, bptree.DefaultFillFactor)
+	if err != nil {
+		// Sorted, distinct keys always load; insert them one by one regardless
+		tree = bptree.NewBPlusTree(orderedIndexOrder)
+		for _, pair := range pairs {
+			tree.Insert(pair.Key, nil)
+		}
+	}
+	idx.ordered = tree
+}
@@ -0,0 +1,133 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rangeKeys runs a Range scan, returning "key=value" strings in scan order
+// ("key" alone for keys-only scans)
+func rangeKeys(t *testing.T, store *KVStore, start, end string, opts RangeOptions) string {
+	t.Helper()
+	var from, to []byte
+	if start != "" {
+		from = []byte(start)
+	}
+	if end != "" {
+		to = []byte(end)
+	}
+	it, err := store.Range(from, to, opts)
+	if err != nil {
+		t.Fatalf("Failed to range: %v", err)
+	}
+	defer it.Close()
+
+	var pairs []string
+	for it.Next() {
+		if opts.KeysOnly {
+			if it.Value() != nil {
+				t.Fatalf("Expected no value in a keys-only scan, got %q", it.Value())
+			}
+			pairs = append(pairs, string(it.Key()))
+			continue
+		}
+		pairs = append(pairs, string(it.Key())+"="+string(it.Value()))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Range scan failed: %v", err)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func TestKVStore_Range(t *testing.T) {
+	store, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	for _, i := range []int{5, 1, 4, 2, 3} {
+		if err := store.Put([]byte(fmt.Sprintf("k%d", i)), []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		start, end string
+		opts       RangeOptions
+		want       string
+	}{
+		{"bounded", "k2", "k4", RangeOptions{}, "k2=v2,k3=v3"},
+		{"unbounded", "", "", RangeOptions{}, "k1=v1,k2=v2,k3=v3,k4=v4,k5=v5"},
+		{"no lower bound", "", "k3", RangeOptions{}, "k1=v1,k2=v2"},
+		{"no upper bound", "k4", "", RangeOptions{}, "k4=v4,k5=v5"},
+		{"limit", "k2", "", RangeOptions{Limit: 2}, "k2=v2,k3=v3"},
+		{"reverse", "k2", "k5", RangeOptions{Reverse: true}, "k4=v4,k3=v3,k2=v2"},
+		{"reverse limit", "", "", RangeOptions{Reverse: true, Limit: 2}, "k5=v5,k4=v4"},
+		{"keys only", "k1", "k3", RangeOptions{KeysOnly: true}, "k1,k2"},
+		{"empty", "k3", "k3", RangeOptions{}, ""},
+		{"inverted", "k4", "k2", RangeOptions{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rangeKeys(t, store, tt.start, tt.end, tt.opts); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	// Once built, the ordering follows every kind of write
+	if err := store.Put([]byte("k0"), []byte("v0")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := store.Delete([]byte("k3")); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if err := store.DeleteRange([]byte("k5"), nil); err != nil {
+		t.Fatalf("Failed to delete range: %v", err)
+	}
+	if err := store.PutWithTTL([]byte("k6"), []byte("v6"), 20*time.Millisecond); err != nil {
+		t.Fatalf("Failed to put with TTL: %v", err)
+	}
+	if err := store.Put([]byte("k2"), []byte("v2b")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if got := rangeKeys(t, store, "", "", RangeOptions{}); got != "k0=v0,k1=v1,k2=v2b,k4=v4,k6=v6" {
+		t.Errorf("Expected the writes reflected in order, got %s", got)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if got := rangeKeys(t, store, "k4", "", RangeOptions{KeysOnly: true}); got != "k4" {
+		t.Errorf("Expected the expired key to be skipped, got %s", got)
+	}
+
+	// Keys deleted after the scan started are skipped
+	it, err := store.Range(nil, nil, RangeOptions{KeysOnly: true})
+	if err != nil {
+		t.Fatalf("Failed to range: %v", err)
+	}
+	if err := store.Delete([]byte("k1")); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	var keys []string
+	for it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	it.Close()
+	if got := strings.Join(keys, ","); got != "k0,k2,k4" {
+		t.Errorf("Expected k1 to be skipped once deleted, got %s", got)
+	}
+
+	// Compaction moves records without disturbing the order
+	if _, err := store.Compact(CompactOptions{}); err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	if got := rangeKeys(t, store, "", "", RangeOptions{Reverse: true}); got != "k4=v4,k2=v2b,k0=v0" {
+		t.Errorf("Expected the order to survive compaction, got %s", got)
+	}
+}
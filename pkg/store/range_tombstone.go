@@ -0,0 +1,86 @@
+package store
+
+import (
+	"bytes"
+
+	"github.com/ssargent/freyjadb/pkg/codec"
+)
+
+// RangeTombstone marks every key in [Start, End) written at or before
+// Timestamp as deleted. An empty End means the range is unbounded.
+type RangeTombstone struct {
+	Start     []byte
+	End       []byte
+	Timestamp uint64
+}
+
+// Contains reports whether key falls inside the tombstone's range
+func (rt RangeTombstone) Contains(key []byte) bool {
+	if bytes.Compare(key, rt.Start) < 0 {
+		return false
+	}
+	return len(rt.End) == 0 || bytes.Compare(key, rt.End) < 0
+}
+
+// Covers reports whether a record for key written at timestamp is deleted by
+// this tombstone. Compaction uses this to drop shadowed records.
+func (rt RangeTombstone) Covers(key []byte, timestamp uint64) bool {
+	return timestamp <= rt.Timestamp && rt.Contains(key)
+}
+
+// DeleteRange deletes every key in [start, end) by appending a single ranged
+// tombstone record. A nil end deletes every key >= start. The write cost is
+// independent of how many keys the range holds.
+func (kv *KVStore) DeleteRange(start, end []byte) error {
+	return kv.commit(func() error { return kv.deleteRangeInternal(start, end) })
+}
+
+// DeletePrefix deletes every key beginning with prefix using one ranged
+// tombstone record
+func (kv *KVStore) DeletePrefix(prefix []byte) error {
+	if len(prefix) == 0 {
+		return ErrInvalidKey
+	}
+
+	return kv.commit(func() error { return kv.deleteRangeInternal(prefix, codec.PrefixEnd(prefix)) })
+}
+
+// deleteRangeInternal writes a range tombstone without acquiring the mutex
+func (kv *KVStore) deleteRangeInternal(start, end []byte) error {
+	if err := kv.checkOpenInternal(); err != nil {
+		return err
+	}
+
+	if len(start) == 0 || (len(end) > 0 && bytes.Compare(start, end) >= 0) {
+		return ErrInvalidKey
+	}
+
+	key, value := codec.NewRangeTombstone(start, end)
+	offset, err := kv.writer.put(key, value, kv.writeTiming)
+	if err != nil {
+		return err
+	}
+
+	record := codec.NewRecord(key, value)
+	rt := RangeTombstone{
+		Start:     bytes.Clone(start),
+		End:       bytes.Clone(end),
+		Timestamp: record.Timestamp,
+	}
+	kv.index.DeleteRange(rt)
+	kv.invalidateCacheRangeInternal(rt.Start, rt.End)
+
+	if kv.memtable != nil {
+		kv.memtable.deleteRange(rt)
+		kv.memtable.addSpecial(offset, uint32(record.Size())) //nolint:gosec // record sizes fit in uint32
+		kv.maybeFlushMemtableInternal()
+	}
+
+	return nil
+}
+
+// RangeTombstones returns the range tombstones seen since the store was
+// opened, oldest first
+func (kv *KVStore) RangeTombstones() []RangeTombstone {
+	return kv.index.RangeTombstones()
+}
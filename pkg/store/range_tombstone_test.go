@@ -0,0 +1,171 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/ssargent/freyjadb/pkg/codec"
+)
+
+func TestKVStore_DeleteRange(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	store, err := NewKVStore(KVStoreConfig{DataDir: tmpDir})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+
+	for _, k := range []string{"user:1", "user:2", "user:3", "order:1"} {
+		if err := store.Put([]byte(k), []byte("v")); err != nil {
+			t.Fatalf("Failed to put %s: %v", k, err)
+		}
+	}
+
+	if err := store.DeleteRange([]byte("user:1"), []byte("user:3")); err != nil {
+		t.Fatalf("DeleteRange failed: %v", err)
+	}
+	if err := store.DeletePrefix([]byte("order:")); err != nil {
+		t.Fatalf("DeletePrefix failed: %v", err)
+	}
+
+	// Written after the tombstone, so it must survive
+	if err := store.Put([]byte("user:2"), []byte("again")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+
+	check := func(phase string, store *KVStore) {
+		expected := map[string]bool{"user:1": false, "user:2": true, "user:3": true, "order:1": false}
+		for k, present := range expected {
+			_, err := store.Get([]byte(k))
+			if present && err != nil {
+				t.Errorf("%s: expected %s to exist, got %v", phase, k, err)
+			}
+			if !present && err != ErrKeyNotFound {
+				t.Errorf("%s: expected %s to be deleted, got %v", phase, k, err)
+			}
+		}
+		if n := len(store.RangeTombstones()); n != 2 {
+			t.Errorf("%s: expected 2 range tombstones, got %d", phase, n)
+		}
+	}
+	check("live", store)
+
+	// Tombstones must be honored when the index is rebuilt from the log
+	store.Close()
+	reopened, err := NewKVStore(KVStoreConfig{DataDir: tmpDir})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := reopened.Open(); err != nil {
+		t.Fatalf("Failed to reopen: %v", err)
+	}
+	defer reopened.Close()
+	check("reopened", reopened)
+
+	if err := reopened.DeleteRange([]byte("b"), []byte("a")); err != ErrInvalidKey {
+		t.Errorf("Expected ErrInvalidKey for inverted range, got %v", err)
+	}
+	reserved, _ := codec.NewRangeTombstone([]byte("x"), nil)
+	if err := reopened.Put(reserved, []byte("v")); err != ErrInvalidKey {
+		t.Errorf("Expected ErrInvalidKey for reserved key, got %v", err)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	if got := string(codec.PrefixEnd([]byte("user:"))); got != "user;" {
+		t.Errorf("Expected user;, got %q", got)
+	}
+	if got := codec.PrefixEnd([]byte{0x61, 0xff}); string(got) != "b" {
+		t.Errorf("Expected b, got %q", got)
+	}
+	if got := codec.PrefixEnd([]byte{0xff}); got != nil {
+		t.Errorf("Expected nil, got %q", got)
+	}
+}
+
+func TestKVStore_CompactDropsSpentRanges(t *testing.T) {
+	tmpDir := t.TempDir()
+	open := func() *KVStore {
+		t.Helper()
+		store, err := NewKVStore(KVStoreConfig{DataDir: tmpDir})
+		if err != nil {
+			t.Fatalf("Failed to create KV store: %v", err)
+		}
+		if _, err := store.Open(); err != nil {
+			t.Fatalf("Failed to open KV store: %v", err)
+		}
+		return store
+	}
+
+	store := open()
+	for _, k := range []string{"user:1", "user:2", "order:1"} {
+		if err := store.Put([]byte(k), []byte("v")); err != nil {
+			t.Fatalf("Failed to put %s: %v", k, err)
+		}
+	}
+	if err := store.DeleteRange([]byte("user:1"), []byte("user:3")); err != nil {
+		t.Fatalf("DeleteRange failed: %v", err)
+	}
+	if err := store.DeletePrefix([]byte("order:")); err != nil {
+		t.Fatalf("DeletePrefix failed: %v", err)
+	}
+	if err := store.Put([]byte("user:2"), []byte("again")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+
+	// Every record the tombstones deleted is left behind, so both go
+	result, err := store.Compact(CompactOptions{})
+	if err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if result.SpentRanges != 2 || len(store.RangeTombstones()) != 0 {
+		t.Errorf("Expected both range tombstones dropped, got %d spent, %v kept", result.SpentRanges, store.RangeTombstones())
+	}
+
+	check := func(phase string, store *KVStore) {
+		t.Helper()
+		for k, present := range map[string]bool{"user:1": false, "user:2": true, "order:1": false} {
+			if _, err := store.Get([]byte(k)); (err == nil) != present {
+				t.Errorf("%s: expected %s present=%v, got %v", phase, k, present, err)
+			}
+		}
+	}
+	check("compacted", store)
+	store.Close()
+	reopened := open()
+	defer reopened.Close()
+	check("reopened", reopened)
+	if n := len(reopened.RangeTombstones()); n != 0 {
+		t.Errorf("Expected no range tombstones after reopening, got %d", n)
+	}
+}
+
+func TestKeepCoveringRanges(t *testing.T) {
+	tombstone := func(start, end string, timestamp uint64) compactRecord {
+		return compactRecord{rt: &RangeTombstone{Start: []byte(start), End: []byte(end), Timestamp: timestamp}}
+	}
+	special := []compactRecord{
+		tombstone("user:", "user;", 100), // Covers user:1, kept from before it
+		tombstone("order:", "order;", 100),
+		{entry: IndexEntry{Timestamp: 50}}, // A sequence reservation
+		tombstone("user:2", "", 300),
+	}
+	live := []compactRecord{
+		{key: "user:2", entry: IndexEntry{Timestamp: 400}},
+		{key: "user:1", entry: IndexEntry{Timestamp: 90}},
+		{key: "order:1", entry: IndexEntry{Timestamp: 200}},
+	}
+
+	kept, ranges := keepCoveringRanges(special, live)
+	if len(kept) != 2 || kept[0].rt == nil || kept[1].rt != nil {
+		t.Fatalf("Expected the user: tombstone and the reservation kept, got %+v", kept)
+	}
+	if len(ranges) != 1 || string(ranges[0].Start) != "user:" {
+		t.Errorf("Expected only the user: range, got %+v", ranges)
+	}
+	if live[0].key != "user:2" {
+		t.Error("Expected the live records left in their order")
+	}
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ssargent/freyjadb/pkg/codec"
+)
+
+// readKeyInternal reads the record an index entry points at for key. If the
+// record is corrupt or belongs to another key, as can happen after a partial
+// recovery, the segments are searched for the latest valid version of key
+// and the index entry is repaired. A repair that finds the key deleted
+// returns ErrKeyNotFound. The caller must hold the mutex.
+func (kv *KVStore) readKeyInternal(key []byte, entry *IndexEntry) (*codec.Record, error) {
+	return kv.readKeyFromInternal(key, entry, nil)
+}
+
+// readKeyFromInternal is readKeyInternal reading through files when it is
+// not nil
+func (kv *KVStore) readKeyFromInternal(key []byte, entry *IndexEntry, files *segmentFiles) (*codec.Record, error) {
+	record, err := kv.readRecordFromInternal(entry, files)
+	if err == nil && bytes.Equal(record.Key, key) {
+		return record, nil
+	}
+	if err != nil && !errors.Is(err, ErrCorruption) {
+		return nil, err
+	}
+
+	repaired, repairErr := kv.repairKeyInternal(key)
+	if repairErr != nil {
+		kv.readRepairFailures++
+		if err == nil {
+			err = fmt.Errorf("index entry for key %q points at a record for another key", key)
+		}
+		return nil, fmt.Errorf("read repair failed: %w (%w)", repairErr, err)
+	}
+	kv.readRepairs++
+	if repaired == nil {
+		return nil, ErrKeyNotFound
+	}
+	return repaired, nil
+}
+
+// repairKeyInternal scans every segment for the latest valid record of key
+// and points the index at it, or drops the key if its latest record is a
+// tombstone or no valid record remains. It returns the record, or nil if
+// the key is deleted. The caller must hold the mutex.
+func (kv *KVStore) repairKeyInternal(key []byte) (*codec.Record, error) {
+	// Buffered writes must be on disk for the scan to see them
+	if err := kv.writer.Sync(); err != nil {
+		return nil, err
+	}
+
+	var latest *codec.Record
+	var latestEntry *IndexEntry
+	for _, seg := range kv.segments.list() {
+		if err := kv.scanSegmentForKey(seg, key, func(record *codec.Record, entry *IndexEntry) {
+			if latest == nil || record.Timestamp >= latest.Timestamp {
+				latest, latestEntry = record, entry
+			}
+		}); err != nil {
+			return nil, fmt.Errorf("failed to scan segment %d: %w", seg.FileID, err)
+		}
+	}
+
+	kv.invalidateCachesInternal(key) // The repaired value may differ from one cached before
+	if latest != nil && len(latest.Value) > 0 && !kv.rangeDeletedInternal(key, latest.Timestamp) {
+		kv.index.Put(key, latestEntry)
+		return latest, nil
+	}
+	kv.index.Delete(key)
+	return nil, nil
+}
+
+// scanSegmentForKey calls fn with every valid record of key in a segment.
+// Records that fail their checksum are skipped; a damaged record header
+// usually ends the useful part of the segment, which the scan then reads to
+// the end without matching anything.
+func (kv *KVStore) scanSegmentForKey(seg SegmentTier, key []byte, fn func(*codec.Record, *IndexEntry)) error {
+	reader, err := NewLogReader(LogReaderConfig{FilePath: seg.Path, Codec: kv.codec})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for {
+		record, err := reader.ReadNext()
+		if err == io.EOF {
+			return nil
+		}
+		if errors.Is(err, ErrCorruption) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(record.Key, key) {
+			continue
+		}
+		fn(record, &IndexEntry{
+			FileID:    seg.FileID,
+			Offset:    reader.Offset() - int64(record.Size()),
+			Size:      uint32(record.Size()), //nolint: gosec // Size is uint32
+			Timestamp: record.Timestamp,
+		})
+	}
+}
+
+// rangeDeletedInternal reports whether a range tombstone deletes the record
+// of key written at timestamp
+func (kv *KVStore) rangeDeletedInternal(key []byte, timestamp uint64) bool {
+	for _, rt := range kv.index.RangeTombstones() {
+		if rt.Covers(key, timestamp) {
+			return true
+		}
+	}
+	return false
+}
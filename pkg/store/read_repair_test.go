@@ -0,0 +1,80 @@
+package store
+
+import (
+	"os"
+	"testing"
+)
+
+func TestKVStore_ReadRepair(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	store, err := NewKVStore(KVStoreConfig{DataDir: tmpDir})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	put := func(key, value string) {
+		t.Helper()
+		if err := store.Put([]byte(key), []byte(value)); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+	put("a", "first")
+	put("a", "second")
+	put("b", "other")
+	put("gone", "soon")
+	if err := store.Delete([]byte("gone")); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+
+	entryA, _ := store.index.Get([]byte("a"))
+	entryB, _ := store.index.Get([]byte("b"))
+
+	// An entry pointing at another key's record is repaired from the log
+	store.index.Put([]byte("a"), entryB)
+	if value, err := store.Get([]byte("a")); err != nil || string(value) != "second" {
+		t.Fatalf("Expected repaired read of 'second', got %q, %v", value, err)
+	}
+	if fixed, _ := store.index.Get([]byte("a")); fixed.Offset != entryA.Offset || fixed.Size != entryA.Size {
+		t.Errorf("Expected index entry at %d, got %d", entryA.Offset, fixed.Offset)
+	}
+
+	// A corrupt latest record falls back to the newest valid version
+	file, err := os.OpenFile(store.dataFile, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("Failed to open data file: %v", err)
+	}
+	if _, err := file.WriteAt([]byte("X"), entryA.Offset+int64(entryA.Size)-1); err != nil {
+		t.Fatalf("Failed to corrupt record: %v", err)
+	}
+	file.Close()
+	if value, err := store.Get([]byte("a")); err != nil || string(value) != "first" {
+		t.Fatalf("Expected fallback to 'first', got %q, %v", value, err)
+	}
+
+	// A stale entry for a deleted key is dropped
+	store.index.Put([]byte("gone"), entryB)
+	if _, err := store.Get([]byte("gone")); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+	if _, exists := store.index.Get([]byte("gone")); exists {
+		t.Error("Expected the stale index entry to be removed")
+	}
+
+	stats := store.Stats()
+	if stats.ReadRepairs != 3 || stats.ReadRepairFailures != 0 {
+		t.Errorf("Expected 3 repairs and no failures, got %d and %d", stats.ReadRepairs, stats.ReadRepairFailures)
+	}
+
+	// Repaired entries read normally afterwards
+	if _, err := store.Get([]byte("a")); err != nil {
+		t.Fatalf("Failed to read repaired key: %v", err)
+	}
+	if stats := store.Stats(); stats.ReadRepairs != 3 {
+		t.Errorf("Expected no further repairs, got %d", stats.ReadRepairs)
+	}
+}
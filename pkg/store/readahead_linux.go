@@ -0,0 +1,16 @@
+//go:build linux
+
+package store
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// adviseWillNeed asks the kernel to start reading a range of file into the
+// page cache, so it is there by the time a scan reaches it. It is only a
+// hint; errors are ignored.
+func adviseWillNeed(file *os.File, offset, length int64) {
+	_ = unix.Fadvise(int(file.Fd()), offset, length, unix.FADV_WILLNEED) //nolint:gosec // fds fit in an int
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package store
+
+import "os"
+
+// adviseWillNeed does nothing where posix_fadvise isn't available; readahead
+// then relies on its large reads alone
+func adviseWillNeed(*os.File, int64, int64) {}
@@ -1,9 +1,11 @@
 package store
 
 import (
+	"errors"
 	"fmt"
-	"strings"
 	"time"
+
+	"github.com/ssargent/freyjadb/pkg/keys"
 )
 
 // Relationship represents a relationship between two entities
@@ -30,54 +32,142 @@ type RelationshipResult struct {
 	Direction    string        `json:"direction"` // "outgoing" or "incoming"
 }
 
+// relationshipKeys holds both directions of every relationship
+const relationshipKeys = keys.Keyspace("relationship")
+
 // makeRelationshipKey generates a relationship key
 // Format: relationship:<direction>:<from_key>:<relation>:<to_key>
-// Note: We replace colons in keys with a safe separator to avoid parsing issues
+// Note: the entity keys are escaped so their colons don't split the key
 func makeRelationshipKey(direction, fromKey, relation, toKey string) string {
-	// Replace colons in keys with a safe separator
-	safeFromKey := strings.ReplaceAll(fromKey, ":", "|")
-	safeToKey := strings.ReplaceAll(toKey, ":", "|")
-	return fmt.Sprintf("relationship:%s:%s:%s:%s", direction, safeFromKey, relation, safeToKey)
+	return relationshipKeys.Key(direction, keys.Escape(fromKey), relation, keys.Escape(toKey))
+}
+
+// relationshipPrefix returns the scan prefix of a key's relationships in
+// one direction, optionally of a single relation
+func relationshipPrefix(direction, key, relation string) string {
+	if relation == "" {
+		return relationshipKeys.Prefix(direction, keys.Escape(key))
+	}
+	return relationshipKeys.Prefix(direction, keys.Escape(key), relation)
 }
 
 // parseRelationshipKey extracts components from a relationship key
 func parseRelationshipKey(key string) (direction, fromKey, relation, toKey string, err error) {
-	parts := strings.Split(key, ":")
-	if len(parts) != 5 || parts[0] != "relationship" {
-		return "", "", "", "", fmt.Errorf("invalid relationship key format: %s", key)
+	parts, err := relationshipKeys.Parse(key, 4)
+	if err != nil {
+		return "", "", "", "", fmt.Errorf("invalid relationship key format: %w", err)
 	}
 
-	direction = parts[1]
-	fromKey = strings.ReplaceAll(parts[2], "|", ":") // Restore colons
-	relation = parts[3]
-	toKey = strings.ReplaceAll(parts[4], "|", ":") // Restore colons
-	return
+	return parts[0], keys.Unescape(parts[1]), parts[2], keys.Unescape(parts[3]), nil
 }
 
 // validateRelationshipKeys checks if both keys exist
 // Note: This function assumes the caller already holds the mutex
 func (kv *KVStore) validateRelationshipKeys(fromKey, toKey string) error {
-	if !kv.isOpen {
-		return &KVError{"store is not open"}
+	if err := kv.checkOpenInternal(); err != nil {
+		return err
 	}
 
-	// Check if fromKey exists
-	_, err := kv.getInternal([]byte(fromKey))
-	if err != nil {
-		if err == ErrKeyNotFound {
-			return fmt.Errorf("source entity does not exist: %s", fromKey)
+	// Make buffered writes visible so recently written entities are found
+	if err := kv.writer.Sync(); err != nil {
+		return err
+	}
+
+	if _, err := kv.getInternal([]byte(fromKey)); err != nil {
+		return entityError("source", fromKey, err)
+	}
+	if _, err := kv.getInternal([]byte(toKey)); err != nil {
+		return entityError("target", toKey, err)
+	}
+	return nil
+}
+
+// ErrRelationshipBatch is returned by PutRelationships when any edge in the
+// batch is invalid; none of the batch is written
+var ErrRelationshipBatch = &KVError{"relationship batch rejected"}
+
+// PutRelationships creates a batch of relationships. Every edge is
+// validated before any is written, checking each referenced key once, and
+// the batch holds the write lock throughout so readers never see part of
+// it. If any edge is invalid nothing is written: the returned slice holds
+// each edge's validation error (nil for valid edges) and the error is
+// ErrRelationshipBatch. A failure while writing is returned as is.
+//
+// CreatedAt defaults to now and Metadata is stored with the edge.
+func (kv *KVStore) PutRelationships(rels []Relationship) ([]error, error) {
+	var edgeErrs []error
+	err := kv.commit(func() error {
+		if err := kv.checkOpenInternal(); err != nil {
+			return err
+		}
+
+		var err error
+		if edgeErrs, err = kv.validateRelationshipsInternal(rels); err != nil {
+			return err
+		}
+		for _, err := range edgeErrs {
+			if err != nil {
+				return ErrRelationshipBatch
+			}
+		}
+
+		now := time.Now()
+		for i := range rels {
+			rel := rels[i]
+			if rel.CreatedAt.IsZero() {
+				rel.CreatedAt = now
+			}
+			if err := kv.writeRelationshipInternal(&rel); err != nil {
+				return fmt.Errorf("failed to store relationship %d: %w", i, err)
+			}
 		}
-		return fmt.Errorf("failed to validate source entity: %w", err)
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrRelationshipBatch) {
+		return nil, err
 	}
+	return edgeErrs, err
+}
 
-	// Check if toKey exists
-	_, err = kv.getInternal([]byte(toKey))
-	if err != nil {
-		if err == ErrKeyNotFound {
-			return fmt.Errorf("target entity does not exist: %s", toKey)
+// validateRelationshipsInternal checks every edge of a batch, looking up
+// each distinct entity key only once (caller must hold the mutex)
+func (kv *KVStore) validateRelationshipsInternal(rels []Relationship) ([]error, error) {
+	// Make buffered writes visible so recently written entities are found
+	if err := kv.writer.Sync(); err != nil {
+		return nil, err
+	}
+
+	checked := make(map[string]error)
+	exists := func(key string) error {
+		if err, ok := checked[key]; ok {
+			return err
 		}
-		return fmt.Errorf("failed to validate target entity: %w", err)
+		_, err := kv.getInternal([]byte(key))
+		checked[key] = err
+		return err
 	}
 
-	return nil
+	edgeErrs := make([]error, len(rels))
+	for i, rel := range rels {
+		if rel.FromKey == "" || rel.ToKey == "" || rel.Relation == "" {
+			edgeErrs[i] = fmt.Errorf("from_key, to_key, and relation are required")
+			continue
+		}
+		if err := exists(rel.FromKey); err != nil {
+			edgeErrs[i] = entityError("source", rel.FromKey, err)
+			continue
+		}
+		if err := exists(rel.ToKey); err != nil {
+			edgeErrs[i] = entityError("target", rel.ToKey, err)
+		}
+	}
+	return edgeErrs, nil
+}
+
+// entityError describes why a relationship's source or target is unusable
+func entityError(role, key string, err error) error {
+	if err == ErrKeyNotFound {
+		return fmt.Errorf("%s entity does not exist: %s", role, key)
+	}
+	return fmt.Errorf("failed to validate %s entity: %w", role, err)
 }
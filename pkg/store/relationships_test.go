@@ -1,8 +1,11 @@
 package store
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestRelationships(t *testing.T) {
@@ -194,3 +197,163 @@ func TestRelationshipKeyGeneration(t *testing.T) {
 			direction, parsedFrom, parsedRelation, parsedTo)
 	}
 }
+
+func TestRelationships_KeyPrefixBoundary(t *testing.T) {
+	kv, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create KVStore: %v", err)
+	}
+	if _, err := kv.Open(); err != nil {
+		t.Fatalf("Failed to open KVStore: %v", err)
+	}
+	defer kv.Close()
+
+	for _, key := range []string{"user:1", "user:10", "user:2"} {
+		if err := kv.Put([]byte(key), []byte("{}")); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+	if err := kv.PutRelationship("user:1", "user:2", "follows"); err != nil {
+		t.Fatalf("Failed to create relationship: %v", err)
+	}
+	if err := kv.PutRelationship("user:10", "user:2", "follows"); err != nil {
+		t.Fatalf("Failed to create relationship: %v", err)
+	}
+
+	// user:1's relationships must not pick up user:10's
+	results, err := kv.GetRelationships(RelationshipQuery{Key: "user:1", Direction: "both"})
+	if err != nil {
+		t.Fatalf("Failed to get relationships: %v", err)
+	}
+	if len(results) != 1 || results[0].OtherKey != "user:2" {
+		t.Errorf("Expected only user:1 -> user:2, got %+v", results)
+	}
+}
+
+func TestPutRelationships(t *testing.T) {
+	kv, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create KVStore: %v", err)
+	}
+	if _, err := kv.Open(); err != nil {
+		t.Fatalf("Failed to open KVStore: %v", err)
+	}
+	defer kv.Close()
+
+	for _, key := range []string{"user:1", "user:2", "user:3"} {
+		if err := kv.Put([]byte(key), []byte("{}")); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+
+	// One bad edge rejects the whole batch
+	edgeErrs, err := kv.PutRelationships([]Relationship{
+		{FromKey: "user:1", ToKey: "user:2", Relation: "follows"},
+		{FromKey: "user:1", ToKey: "user:9", Relation: "follows"},
+		{FromKey: "user:2", ToKey: "user:3"},
+	})
+	if !errors.Is(err, ErrRelationshipBatch) {
+		t.Fatalf("Expected ErrRelationshipBatch, got %v", err)
+	}
+	if len(edgeErrs) != 3 || edgeErrs[0] != nil || edgeErrs[1] == nil || edgeErrs[2] == nil {
+		t.Fatalf("Unexpected edge errors: %v", edgeErrs)
+	}
+	if results, _ := kv.GetRelationships(RelationshipQuery{Key: "user:1", Direction: "both"}); len(results) != 0 {
+		t.Errorf("Rejected batch wrote %d relationships", len(results))
+	}
+
+	edgeErrs, err = kv.PutRelationships([]Relationship{
+		{FromKey: "user:1", ToKey: "user:2", Relation: "follows", Metadata: map[string]interface{}{"since": "2024"}},
+		{FromKey: "user:1", ToKey: "user:3", Relation: "follows"},
+		{FromKey: "user:3", ToKey: "user:2", Relation: "blocks"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to put relationships: %v (%v)", err, edgeErrs)
+	}
+
+	results, err := kv.GetRelationships(RelationshipQuery{Key: "user:2", Direction: "incoming"})
+	if err != nil {
+		t.Fatalf("Failed to get relationships: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 incoming relationships, got %+v", results)
+	}
+	for _, res := range results {
+		if res.Relationship.CreatedAt.IsZero() {
+			t.Errorf("Expected CreatedAt to default, got %+v", res.Relationship)
+		}
+		if res.OtherKey == "user:1" && res.Relationship.Metadata["since"] != "2024" {
+			t.Errorf("Expected metadata to be stored, got %+v", res.Relationship)
+		}
+	}
+}
+
+// benchmarkEdges is the fan-out of the hub node in relationship benchmarks
+const benchmarkEdges = 100_000
+
+// newRelationshipBenchStore builds a store whose hub node has
+// benchmarkEdges outgoing "links" edges, alongside 10k unrelated edges
+func newRelationshipBenchStore(b *testing.B) *KVStore {
+	b.Helper()
+	kv, err := NewKVStore(KVStoreConfig{DataDir: b.TempDir(), FsyncInterval: time.Second})
+	if err != nil {
+		b.Fatalf("Failed to create KVStore: %v", err)
+	}
+	if _, err := kv.Open(); err != nil {
+		b.Fatalf("Failed to open KVStore: %v", err)
+	}
+
+	if err := kv.Put([]byte("node:hub"), []byte("{}")); err != nil {
+		b.Fatalf("Failed to put hub: %v", err)
+	}
+	rels := make([]Relationship, 0, 1000)
+	flush := func() {
+		if _, err := kv.PutRelationships(rels); err != nil {
+			b.Fatalf("Failed to put relationships: %v", err)
+		}
+		rels = rels[:0]
+	}
+	for i := 0; i < benchmarkEdges; i++ {
+		target := fmt.Sprintf("node:%06d", i)
+		if err := kv.Put([]byte(target), []byte("{}")); err != nil {
+			b.Fatalf("Failed to put node: %v", err)
+		}
+		rels = append(rels, Relationship{FromKey: "node:hub", ToKey: target, Relation: "links"})
+		if i%10 == 0 && i > 0 {
+			rels = append(rels, Relationship{FromKey: target, ToKey: fmt.Sprintf("node:%06d", i-1), Relation: "knows"})
+		}
+		if len(rels) >= 1000 {
+			flush()
+		}
+	}
+	flush()
+	return kv
+}
+
+func BenchmarkGetRelationships(b *testing.B) {
+	kv := newRelationshipBenchStore(b)
+	defer kv.Close()
+
+	for _, bc := range []struct {
+		name  string
+		query RelationshipQuery
+		want  int
+	}{
+		{"first-page", RelationshipQuery{Key: "node:hub", Direction: "outgoing"}, 100},
+		{"all-edges", RelationshipQuery{Key: "node:hub", Direction: "outgoing", Limit: benchmarkEdges}, benchmarkEdges},
+		{"by-relation", RelationshipQuery{Key: "node:hub", Direction: "both", Relation: "links", Limit: 1000}, 1000},
+		{"leaf-node", RelationshipQuery{Key: "node:000500", Direction: "both"}, 2},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				results, err := kv.GetRelationships(bc.query)
+				if err != nil {
+					b.Fatalf("Failed to get relationships: %v", err)
+				}
+				if len(results) != bc.want {
+					b.Fatalf("Expected %d relationships, got %d", bc.want, len(results))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package store
+
+import "context"
+
+// requestIDKey is the context key for the caller's request ID
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying id so store operations that take a
+// context can report which request they ran on behalf of
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID stored by WithRequestID, or ""
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,29 @@
+package store
+
+import (
+	"context"
+	"testing"
+)
+
+func TestExplain_EchoesRequestID(t *testing.T) {
+	kv, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewKVStore: %v", err)
+	}
+	if _, err := kv.Open(); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer kv.Close()
+
+	if got := RequestIDFromContext(context.Background()); got != "" {
+		t.Fatalf("expected empty request ID, got %q", got)
+	}
+
+	res, err := kv.Explain(WithRequestID(context.Background(), "req-1"), ExplainOptions{})
+	if err != nil {
+		t.Fatalf("Explain: %v", err)
+	}
+	if res.RequestID != "req-1" {
+		t.Errorf("expected request ID req-1, got %q", res.RequestID)
+	}
+}
@@ -0,0 +1,229 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// DefaultMaxSegmentSize is the active log size that triggers a rotation
+// when KVStoreConfig doesn't set MaxSegmentSize
+const DefaultMaxSegmentSize = 64 * 1024 * 1024
+
+// maxSegmentSize returns the configured rotation threshold, zero when
+// rotation is disabled
+func (kv *KVStore) maxSegmentSize() int64 {
+	switch {
+	case kv.config.MaxSegmentSize < 0:
+		return 0
+	case kv.config.MaxSegmentSize == 0:
+		return DefaultMaxSegmentSize
+	default:
+		return kv.config.MaxSegmentSize
+	}
+}
+
+// Rotate seals the active log as a new segment and starts the log over,
+// whatever its size. Writes wait for the rotation, and it waits for any
+// outstanding Freeze. Rotating an empty log does nothing.
+func (kv *KVStore) Rotate() error {
+	if kv.IsStandby() {
+		return ErrReadOnly
+	}
+
+	kv.maintenance.Lock()
+	defer kv.maintenance.Unlock()
+
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if err := kv.checkOpenInternal(); err != nil {
+		return err
+	}
+	if kv.memtable != nil {
+		return kv.flushMemtableInternal()
+	}
+	return kv.rotateInternal()
+}
+
+// maybeRotateInternal rotates the active log once it reaches the maximum
+// segment size. It runs after a write, so like a memtable flush it is
+// skipped while the store is frozen and retried after a later write, and
+// failures are only reported. The caller must hold the mutex.
+func (kv *KVStore) maybeRotateInternal() {
+	limit := kv.maxSegmentSize()
+	if limit == 0 || kv.memtable != nil || kv.writer == nil || kv.writer.Size() < limit {
+		return
+	}
+	if !kv.maintenance.TryLock() {
+		return
+	}
+	defer kv.maintenance.Unlock()
+
+	if err := kv.rotateInternal(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error rotating active segment: %v\n", err)
+	}
+}
+
+// rotateInternal seals the active log as a new segment with the next
+// FileID, points the index at it, records the segment in the manifest and
+// then starts the log over. The segment is a hard link to the log and the
+// log restarts in a fresh file renamed over it, so the work done here does
+// not grow with the log. The protocol is a memtable flush's, so a crash at
+// any point loses no write: before the manifest is written the segment is
+// unlisted and the log complete, after it the log's records are also in the
+// segment, and Open restarts a log still linked to a listed segment. The
+// caller must hold the mutex and the maintenance lock.
+func (kv *KVStore) rotateInternal() error {
+	if err := kv.writer.Sync(); err != nil {
+		return err
+	}
+	logSize := kv.writer.Size()
+	if logSize == 0 {
+		return nil
+	}
+
+	fileID := kv.nextFileIDInternal()
+	dir, err := kv.placer.Next()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, segmentFileName(fileID))
+	fresh, err := kv.sealLog(path, logSize)
+	if err != nil {
+		return fmt.Errorf("failed to write segment %d: %w", fileID, err)
+	}
+	kv.segments.add(fileID, path)
+
+	// The segment holds the log's bytes, so every record keeps its offset
+	kv.index.MoveSegment(activeFileID, fileID)
+
+	kv.manifest.mutex.Lock()
+	kv.manifest.logBase += uint64(logSize) //nolint:gosec // log size is never negative
+	if minSeq, maxSeq, ok := kv.writer.seqRange(); ok {
+		kv.manifest.sealed = map[uint32][2]uint64{fileID: {minSeq, maxSeq}}
+	}
+	kv.manifest.mutex.Unlock()
+	if err := kv.saveManifest(); err != nil {
+		kv.manifest.mutex.Lock()
+		kv.manifest.logBase -= uint64(logSize) //nolint:gosec // log size is never negative
+		kv.manifest.mutex.Unlock()
+		kv.index.MoveSegment(fileID, activeFileID)
+		kv.segments.remove(fileID)
+		_ = os.Remove(path)
+		if fresh != nil {
+			fresh.Close()
+			_ = os.Remove(fresh.Name())
+		}
+		return err
+	}
+
+	if fresh == nil {
+		err = kv.writer.truncate()
+	} else if err = kv.writer.restart(fresh); err == nil {
+		err = kv.reader.reopen()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to empty log after rotation: %w", err)
+	}
+	kv.openSize -= logSize // Keep bytes written since open counting across the restart of the log
+	kv.rotations++
+	return nil
+}
+
+// sealLog makes the first size bytes of the active log, all of it once
+// synced, the segment at path. The segment is hard-linked to the log and a
+// fresh, empty file for the log to restart in is returned. Where the
+// segment can't be linked, as when the placer picks a data directory on
+// another filesystem, the log is copied and no file is returned: the log
+// is then emptied in place instead.
+func (kv *KVStore) sealLog(path string, size int64) (*os.File, error) {
+	// A segment at path is left from a rotation that crashed before the
+	// manifest listed it
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := os.Link(kv.dataFile, path); err != nil {
+		return nil, copyLog(kv.dataFile, path, size)
+	}
+
+	fresh, err := createEmptyFile(kv.dataFile + tempSuffix)
+	if err == nil {
+		err = syncDir(filepath.Dir(path))
+		if err != nil {
+			fresh.Close()
+			_ = os.Remove(fresh.Name())
+		}
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return nil, err
+	}
+	return fresh, nil
+}
+
+// finishRotationInternal completes a rotation interrupted after the
+// manifest listed the log's hard link as a segment but before the log
+// restarted. The log is still that segment's file, whose records are all
+// in the segment, so it is replaced by an empty one. The caller must hold
+// the mutex.
+func (kv *KVStore) finishRotationInternal() error {
+	log, err := os.Stat(kv.dataFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, seg := range kv.segments.list() {
+		if seg.FileID == activeFileID {
+			continue
+		}
+		if info, err := os.Stat(seg.Path); err != nil || !os.SameFile(log, info) {
+			continue
+		}
+
+		fresh, err := createEmptyFile(kv.dataFile + tempSuffix)
+		if err != nil {
+			return err
+		}
+		if err := fresh.Close(); err != nil {
+			return err
+		}
+		if err := os.Rename(fresh.Name(), kv.dataFile); err != nil {
+			return fmt.Errorf("failed to restart log linked to segment %d: %w", seg.FileID, err)
+		}
+		return syncDir(filepath.Dir(kv.dataFile))
+	}
+	return nil
+}
+
+// createEmptyFile creates an empty file at path and fsyncs it
+func createEmptyFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(filepath.Clean(path), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		_ = os.Remove(path)
+		return nil, err
+	}
+	return file, nil
+}
+
+// copyLog copies the first size bytes of the log at logPath into a new
+// file at path
+func copyLog(logPath, path string, size int64) error {
+	log, err := os.Open(filepath.Clean(logPath))
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+
+	return finalizeFile(path, func(w io.Writer) error {
+		_, err := io.CopyN(w, log, size)
+		return err
+	})
+}
@@ -0,0 +1,250 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKVStore_Rotation(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	open := func() *KVStore {
+		t.Helper()
+		store, err := NewKVStore(KVStoreConfig{DataDir: tmpDir, MaxSegmentSize: 1024})
+		if err != nil {
+			t.Fatalf("Failed to create KV store: %v", err)
+		}
+		if _, err := store.Open(); err != nil {
+			t.Fatalf("Failed to open KV store: %v", err)
+		}
+		return store
+	}
+
+	store := open()
+	for i := 0; i < 100; i++ {
+		if err := store.Put([]byte(fmt.Sprintf("key:%03d", i)), bytes.Repeat([]byte("x"), 32)); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	if err := store.Put([]byte("key:000"), []byte("updated")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := store.Delete([]byte("key:001")); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+
+	stats := store.Stats()
+	if stats.Rotations < 2 {
+		t.Fatalf("Expected the log to rotate at MaxSegmentSize, got %d rotations", stats.Rotations)
+	}
+	if store.writer.Size() >= 1024 {
+		t.Errorf("Expected the active log to stay under MaxSegmentSize, got %d bytes", store.writer.Size())
+	}
+	tiers := store.SegmentTiers()
+	if len(tiers) != int(stats.Rotations)+1 {
+		t.Fatalf("Expected the active log and %d segments, got %+v", stats.Rotations, tiers)
+	}
+	for i, tier := range tiers {
+		if tier.FileID != uint32(i) { //nolint:gosec // small test index
+			t.Errorf("Expected FileIDs to increase from 0, got %d at %d", tier.FileID, i)
+		}
+	}
+
+	check := func(store *KVStore) {
+		t.Helper()
+		if got, err := store.Get([]byte("key:000")); err != nil || string(got) != "updated" {
+			t.Errorf("Expected updated, got %q, %v", got, err)
+		}
+		if _, err := store.Get([]byte("key:001")); err != ErrKeyNotFound {
+			t.Errorf("Expected key:001 to be deleted, got %v", err)
+		}
+		for i := 2; i < 100; i++ {
+			key := fmt.Sprintf("key:%03d", i)
+			if got, err := store.Get([]byte(key)); err != nil || len(got) != 32 {
+				t.Fatalf("Expected %s across segments, got %q, %v", key, got, err)
+			}
+		}
+	}
+	check(store)
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	// The segments are found again on restart and compaction merges them
+	store = open()
+	defer store.Close()
+	check(store)
+	if got := store.Stats().UserKeys; got != 99 {
+		t.Errorf("Expected 99 keys after restart, got %d", got)
+	}
+	if _, err := store.Compact(CompactOptions{}); err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	check(store)
+}
+
+func TestKVStore_RotateManually(t *testing.T) {
+	store, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir(), MaxSegmentSize: -1})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Rotate(); err != nil {
+		t.Fatalf("Failed to rotate an empty log: %v", err)
+	}
+	if len(store.SegmentTiers()) != 1 {
+		t.Errorf("Expected rotating an empty log to do nothing, got %+v", store.SegmentTiers())
+	}
+
+	for i := 0; i < 100; i++ {
+		if err := store.Put([]byte(fmt.Sprintf("key:%03d", i)), bytes.Repeat([]byte("x"), 32)); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	if got := store.Stats().Rotations; got != 0 {
+		t.Errorf("Expected no automatic rotation when disabled, got %d", got)
+	}
+
+	// An iterator opened before the rotation still reads its values
+	it, err := store.ScanPrefix([]byte("key:"))
+	if err != nil {
+		t.Fatalf("Failed to scan: %v", err)
+	}
+	defer it.Close()
+	if err := store.Rotate(); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	count := 0
+	for it.Next() {
+		if len(it.Value()) != 32 {
+			t.Errorf("Expected a 32 byte value for %s, got %q", it.Key(), it.Value())
+		}
+		count++
+	}
+	if it.Err() != nil || count != 100 {
+		t.Errorf("Expected 100 keys across the rotation, got %d, %v", count, it.Err())
+	}
+	if store.writer.Size() != 0 || store.Stats().Rotations != 1 {
+		t.Errorf("Expected one rotation emptying the log, got %d bytes, %d rotations",
+			store.writer.Size(), store.Stats().Rotations)
+	}
+}
+
+func TestKVStore_RotateLinksSegment(t *testing.T) {
+	dir := t.TempDir()
+	open := func() *KVStore {
+		t.Helper()
+		store, err := NewKVStore(KVStoreConfig{DataDir: dir, MaxSegmentSize: -1})
+		if err != nil {
+			t.Fatalf("Failed to create KV store: %v", err)
+		}
+		if _, err := store.Open(); err != nil {
+			t.Fatalf("Failed to open KV store: %v", err)
+		}
+		return store
+	}
+	put := func(store *KVStore, key, value string) {
+		t.Helper()
+		if err := store.Put([]byte(key), []byte(value)); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	check := func(store *KVStore, want map[string]string) {
+		t.Helper()
+		for key, value := range want {
+			if got, err := store.Get([]byte(key)); err != nil || string(got) != value {
+				t.Errorf("Expected %s=%s, got %q, %v", key, value, got, err)
+			}
+		}
+	}
+	sameFile := func(a, b string) bool {
+		t.Helper()
+		infoA, errA := os.Stat(a)
+		infoB, errB := os.Stat(b)
+		return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+	}
+
+	store := open()
+	put(store, "key:1", "one")
+	put(store, "key:2", "two")
+	if err := store.Rotate(); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	segment := filepath.Join(dir, segmentFileName(1))
+	info, err := os.Stat(segment)
+	if err != nil {
+		t.Fatalf("Expected the sealed segment: %v", err)
+	}
+	if sameFile(store.dataFile, segment) || store.writer.Size() != 0 {
+		t.Fatal("Expected the log to restart in a new, empty file")
+	}
+
+	// The timestamp range the writer tracked is the one a scan finds
+	minSeq, maxSeq, err := segmentSeqRange(segment)
+	if err != nil {
+		t.Fatalf("Failed to scan segment: %v", err)
+	}
+	for _, seg := range store.Manifest().Segments {
+		if seg.FileID == 1 && (seg.MinSeq != minSeq || seg.MaxSeq != maxSeq) {
+			t.Errorf("Expected sequence range %d-%d, got %d-%d", minSeq, maxSeq, seg.MinSeq, seg.MaxSeq)
+		}
+	}
+
+	// Writes after the rotation go to the new log, not the segment
+	put(store, "key:3", "three")
+	if after, err := os.Stat(segment); err != nil || after.Size() != info.Size() {
+		t.Errorf("Expected the segment to stay %d bytes, got %v", info.Size(), err)
+	}
+	check(store, map[string]string{"key:1": "one", "key:2": "two", "key:3": "three"})
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	// A crash after the manifest listed the segment but before the log
+	// restarted leaves the log linked to it; Open restarts the log
+	if err := os.Remove(filepath.Join(dir, shutdownFile)); err != nil && !os.IsNotExist(err) {
+		t.Fatalf("Failed to remove shutdown hints: %v", err)
+	}
+	if err := os.Remove(store.dataFile); err != nil {
+		t.Fatalf("Failed to remove log: %v", err)
+	}
+	if err := os.Link(segment, store.dataFile); err != nil {
+		t.Fatalf("Failed to link log: %v", err)
+	}
+	store = open()
+	if sameFile(store.dataFile, segment) || store.writer.Size() != 0 {
+		t.Fatal("Expected Open to restart a log linked to a segment")
+	}
+	put(store, "key:4", "four")
+	if after, err := os.Stat(segment); err != nil || after.Size() != info.Size() {
+		t.Errorf("Expected the segment to stay %d bytes, got %v", info.Size(), err)
+	}
+	check(store, map[string]string{"key:1": "one", "key:2": "two", "key:4": "four"})
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	// A crash before the manifest listed a segment leaves an unlisted link,
+	// which the next rotation replaces
+	stale := filepath.Join(dir, segmentFileName(store.Manifest().NextFileID))
+	if err := os.Link(segment, stale); err != nil {
+		t.Fatalf("Failed to link stale segment: %v", err)
+	}
+	store = open()
+	defer store.Close()
+	put(store, "key:5", "five")
+	if err := store.Rotate(); err != nil {
+		t.Fatalf("Failed to rotate over a stale segment: %v", err)
+	}
+	if sameFile(stale, segment) {
+		t.Error("Expected the stale segment to be replaced")
+	}
+	check(store, map[string]string{"key:1": "one", "key:2": "two", "key:4": "four", "key:5": "five"})
+}
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"github.com/ssargent/freyjadb/pkg/codec"
+)
+
+// DefaultSequenceBatch is how many IDs NextID reserves with each fsync
+const DefaultSequenceBatch = 1000
+
+// sequenceRange is the block of IDs a sequence has reserved on disk; IDs
+// from next through limit can be handed out without writing
+type sequenceRange struct {
+	next  uint64
+	limit uint64
+}
+
+// NextID returns the next ID of the named sequence. IDs start at 1 and
+// increase monotonically, including across restarts, but are not dense:
+// IDs reserved but not handed out before the store closes are skipped.
+func (kv *KVStore) NextID(name string) (uint64, error) {
+	return kv.NextIDs(name, 1)
+}
+
+// NextIDs reserves count consecutive IDs of the named sequence and returns
+// the first. Reservations are written and fsynced in batches of
+// KVStoreConfig.SequenceBatch, so most calls don't touch the disk.
+func (kv *KVStore) NextIDs(name string, count int) (uint64, error) {
+	if name == "" {
+		return 0, ErrInvalidKey
+	}
+	if count < 1 {
+		return 0, ErrInvalidCount
+	}
+
+	kv.writeGate.RLock()
+	defer kv.writeGate.RUnlock()
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if err := kv.checkOpenInternal(); err != nil {
+		return 0, err
+	}
+
+	seq, ok := kv.sequences[name]
+	if !ok {
+		// Resume after the last reservation made before the store was opened
+		limit := kv.index.SequenceLimit(name)
+		seq = &sequenceRange{next: limit + 1, limit: limit}
+		kv.sequences[name] = seq
+	}
+
+	if available := seq.limit + 1 - seq.next; available < uint64(count) {
+		if err := kv.reserveSequenceInternal(name, seq, max(kv.sequenceBatch(), count)); err != nil {
+			return 0, err
+		}
+	}
+
+	first := seq.next
+	seq.next += uint64(count)
+	return first, nil
+}
+
+// reserveSequenceInternal durably extends a sequence's reservation by n IDs
+// (caller must hold the mutex)
+func (kv *KVStore) reserveSequenceInternal(name string, seq *sequenceRange, n int) error {
+	limit := seq.limit + uint64(n)
+	key, value := codec.NewSequenceReservation(name, limit)
+	if kv.config.MaxRecordSize > 0 && len(key)+len(value) > kv.config.MaxRecordSize {
+		return ErrRecordSizeExceeded
+	}
+
+	offset, err := kv.writer.Put(key, value)
+	if err != nil {
+		return err
+	}
+	// IDs must never be handed out twice, so the reservation is durable
+	// before any ID from it is returned
+	if err := kv.writer.Sync(); err != nil {
+		return err
+	}
+
+	kv.index.SetSequenceLimit(name, limit)
+	seq.limit = limit
+
+	if kv.memtable != nil {
+		kv.memtable.addSpecial(offset, uint32(codec.NewRecord(key, value).Size())) //nolint:gosec // record sizes fit in uint32
+		kv.maybeFlushMemtableInternal()
+	}
+	return nil
+}
+
+// sequenceBatch returns how many IDs each reservation covers
+func (kv *KVStore) sequenceBatch() int {
+	if kv.config.SequenceBatch > 0 {
+		return kv.config.SequenceBatch
+	}
+	return DefaultSequenceBatch
+}
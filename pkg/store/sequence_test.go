@@ -0,0 +1,101 @@
+package store
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/ssargent/freyjadb/pkg/codec"
+)
+
+func TestKVStore_NextID(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	open := func() *KVStore {
+		t.Helper()
+		store, err := NewKVStore(KVStoreConfig{DataDir: tmpDir, SequenceBatch: 10})
+		if err != nil {
+			t.Fatalf("Failed to create KV store: %v", err)
+		}
+		if _, err := store.Open(); err != nil {
+			t.Fatalf("Failed to open KV store: %v", err)
+		}
+		return store
+	}
+	store := open()
+
+	for want := uint64(1); want <= 3; want++ {
+		if id, err := store.NextID("orders"); err != nil || id != want {
+			t.Fatalf("Expected ID %d, got %d, %v", want, id, err)
+		}
+	}
+	// Sequences are independent
+	if id, err := store.NextID("invoices"); err != nil || id != 1 {
+		t.Fatalf("Expected first invoice ID 1, got %d, %v", id, err)
+	}
+	// A block larger than the batch is still contiguous
+	first, err := store.NextIDs("orders", 25)
+	if err != nil || first != 4 {
+		t.Fatalf("Expected block to start at 4, got %d, %v", first, err)
+	}
+	if id, _ := store.NextID("orders"); id != 29 {
+		t.Errorf("Expected ID 29 after the block, got %d", id)
+	}
+	if limit := store.index.SequenceLimit("orders"); limit != 35 {
+		t.Errorf("Expected reservation up to 35, got %d", limit)
+	}
+
+	// Reservations are not keys
+	if keys, err := store.ListKeys(nil); err != nil || len(keys) != 0 {
+		t.Errorf("Expected no keys, got %v, %v", keys, err)
+	}
+	reserved, _ := codec.NewSequenceReservation("orders", 1)
+	if err := store.Put(reserved, []byte("v")); err != ErrInvalidKey {
+		t.Errorf("Expected ErrInvalidKey for reserved key, got %v", err)
+	}
+	if _, err := store.NextID(""); err != ErrInvalidKey {
+		t.Errorf("Expected ErrInvalidKey for empty name, got %v", err)
+	}
+	if _, err := store.NextIDs("orders", 0); err != ErrInvalidCount {
+		t.Errorf("Expected ErrInvalidCount, got %v", err)
+	}
+
+	// After a restart the sequence resumes past everything reserved
+	store.Close()
+	store = open()
+	defer store.Close()
+
+	if id, err := store.NextID("orders"); err != nil || id != 36 {
+		t.Fatalf("Expected ID 36 after reopen, got %d, %v", id, err)
+	}
+	if id, err := store.NextID("invoices"); err != nil || id != 11 {
+		t.Fatalf("Expected invoice ID 11 after reopen, got %d, %v", id, err)
+	}
+
+	// Concurrent callers never receive the same ID
+	var mu sync.Mutex
+	seen := make(map[uint64]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				id, err := store.NextID("orders")
+				if err != nil {
+					t.Errorf("NextID failed: %v", err)
+					return
+				}
+				mu.Lock()
+				if seen[id] {
+					t.Errorf("ID %d handed out twice", id)
+				}
+				seen[id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(seen) != 400 {
+		t.Errorf("Expected 400 distinct IDs, got %d", len(seen))
+	}
+}
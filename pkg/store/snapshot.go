@@ -0,0 +1,225 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Snapshot is a read-only, point-in-time view of the store. Gets and scans
+// through it see every write committed before it was taken and none made
+// after, however the store changes meanwhile, so backup and analytics tools
+// can read a stable image while clients keep writing.
+//
+// A snapshot copies the location of every live record when it is taken and
+// freezes the store like Freeze, so the records stay where it found them:
+// rotation, memtable flushes and compaction wait until it is closed. Close
+// snapshots promptly; an abandoned one holds segment maintenance off
+// forever.
+type Snapshot struct {
+	kv        *KVStore
+	entries   []snapshotEntry // Sorted by key
+	createdAt time.Time
+	closed    atomic.Bool
+}
+
+// snapshotEntry is a key and where its record was when the snapshot was taken
+type snapshotEntry struct {
+	key   string
+	entry IndexEntry
+}
+
+// ErrSnapshotClosed is returned by reads through a closed snapshot
+var ErrSnapshotClosed = &KVError{"snapshot is closed"}
+
+// Snapshot returns a point-in-time view of the store, which must be closed
+// when done with
+func (kv *KVStore) Snapshot() (*Snapshot, error) {
+	snap := &Snapshot{kv: kv}
+	if err := kv.freeze(func() {
+		snap.createdAt = time.Now()
+		snap.entries = kv.index.snapshotEntries(snap.createdAt)
+	}); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// snapshotEntries copies every key and its entry, sorted by key, leaving out
+// keys that have expired by now
+func (idx *HashIndex) snapshotEntries(now time.Time) []snapshotEntry {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	entries := make([]snapshotEntry, 0, idx.size)
+	for id, bucket := range idx.entries {
+		prefix := idx.prefixes[id]
+		for suffix, entry := range bucket {
+			if entry.expired(now) {
+				continue
+			}
+			entries = append(entries, snapshotEntry{key: prefix + suffix, entry: *entry})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	return entries
+}
+
+// CreatedAt returns when the snapshot was taken
+func (s *Snapshot) CreatedAt() time.Time {
+	return s.createdAt
+}
+
+// Len returns the number of keys in the snapshot
+func (s *Snapshot) Len() int {
+	return len(s.entries)
+}
+
+// Get returns the value key had when the snapshot was taken
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	i := sort.Search(len(s.entries), func(i int) bool {
+		return s.entries[i].key >= string(key)
+	})
+	if i == len(s.entries) || s.entries[i].key != string(key) {
+		return nil, ErrKeyNotFound
+	}
+	return s.read(&s.entries[i])
+}
+
+// read returns the value of a record found by the snapshot
+func (s *Snapshot) read(e *snapshotEntry) ([]byte, error) {
+	kv := s.kv
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if s.closed.Load() {
+		return nil, ErrSnapshotClosed
+	}
+	if err := kv.checkOpenInternal(); err != nil {
+		return nil, err
+	}
+
+	// The store has been frozen since the snapshot synced the active
+	// segment, so the record is still on disk where the index said
+	entry := e.entry
+	record, err := kv.readRecordFromInternal(&entry, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q from snapshot: %w", e.key, err)
+	}
+	if !bytes.Equal(record.Key, []byte(e.key)) {
+		return nil, fmt.Errorf("%w: snapshot entry for key %q points at a record for another key",
+			ErrCorruption, e.key)
+	}
+	return record.Value, nil
+}
+
+// Scan returns an iterator over every pair in the snapshot, in key order
+func (s *Snapshot) Scan() *SnapshotIterator {
+	return &SnapshotIterator{snap: s, entries: s.entries}
+}
+
+// ScanPrefix returns an iterator over the pairs whose keys start with
+// prefix, in key order
+func (s *Snapshot) ScanPrefix(prefix []byte) *SnapshotIterator {
+	start := sort.Search(len(s.entries), func(i int) bool {
+		return s.entries[i].key >= string(prefix)
+	})
+	end := start + sort.Search(len(s.entries)-start, func(i int) bool {
+		return !strings.HasPrefix(s.entries[start+i].key, string(prefix))
+	})
+	return &SnapshotIterator{snap: s, entries: s.entries[start:end]}
+}
+
+// ScanRange returns an iterator over the pairs with keys in [start, end),
+// in key order. A nil end means no upper bound.
+func (s *Snapshot) ScanRange(start, end []byte) *SnapshotIterator {
+	from := sort.Search(len(s.entries), func(i int) bool {
+		return s.entries[i].key >= string(start)
+	})
+	to := len(s.entries)
+	if end != nil {
+		to = sort.Search(len(s.entries), func(i int) bool {
+			return s.entries[i].key >= string(end)
+		})
+	}
+	if to < from {
+		to = from
+	}
+	return &SnapshotIterator{snap: s, entries: s.entries[from:to]}
+}
+
+// Close releases the snapshot, letting segment maintenance resume once no
+// other snapshot or Freeze holds it. It is safe to call more than once.
+func (s *Snapshot) Close() error {
+	if s.closed.Swap(true) {
+		return nil
+	}
+	return s.kv.Thaw()
+}
+
+// SnapshotIterator walks pairs in a snapshot, reading each value from disk
+// as Next reaches it. It is used like a PrefixIterator:
+//
+//	it := snap.ScanPrefix([]byte("user:"))
+//	defer it.Close()
+//	for it.Next() {
+//		use(it.Key(), it.Value())
+//	}
+//	return it.Err()
+type SnapshotIterator struct {
+	snap    *Snapshot
+	entries []snapshotEntry
+	pos     int
+
+	current KeyValuePair
+	err     error
+	closed  bool
+}
+
+// Next advances to the next pair, returning false when the scan is finished,
+// fails, or the iterator or its snapshot is closed
+func (it *SnapshotIterator) Next() bool {
+	it.current = KeyValuePair{}
+	if it.closed || it.err != nil || it.pos >= len(it.entries) {
+		return false
+	}
+
+	e := &it.entries[it.pos]
+	it.pos++
+	value, err := it.snap.read(e)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.current = KeyValuePair{Key: []byte(e.key), Value: value}
+	return true
+}
+
+// Key returns the current pair's key
+func (it *SnapshotIterator) Key() []byte {
+	return it.current.Key
+}
+
+// Value returns the current pair's value
+func (it *SnapshotIterator) Value() []byte {
+	return it.current.Value
+}
+
+// Err returns the error that stopped the scan, if any
+func (it *SnapshotIterator) Err() error {
+	return it.err
+}
+
+// Close stops the scan. The snapshot stays open. It is safe to call more
+// than once.
+func (it *SnapshotIterator) Close() error {
+	it.closed = true
+	it.entries = nil
+	it.current = KeyValuePair{}
+	return nil
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// snapshotPairs drains it into "key=value" strings
+func snapshotPairs(t *testing.T, it *SnapshotIterator) []string {
+	t.Helper()
+	defer it.Close()
+
+	var pairs []string
+	for it.Next() {
+		pairs = append(pairs, string(it.Key())+"="+string(it.Value()))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Snapshot scan failed: %v", err)
+	}
+	return pairs
+}
+
+func TestKVStore_Snapshot(t *testing.T) {
+	store, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir(), MaxSegmentSize: 1024})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	for _, key := range []string{"user:1", "user:2", "user:3", "order:1"} {
+		if err := store.Put([]byte(key), []byte("v1")); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+
+	snap, err := store.Snapshot()
+	if err != nil {
+		t.Fatalf("Failed to take snapshot: %v", err)
+	}
+	defer snap.Close()
+
+	// Writes after the snapshot, enough to want a rotation, change nothing in it
+	if err := store.Put([]byte("user:1"), []byte("v2")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := store.Delete([]byte("user:2")); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if err := store.DeletePrefix([]byte("order:")); err != nil {
+		t.Fatalf("Failed to delete prefix: %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		if err := store.Put([]byte(fmt.Sprintf("user:new%02d", i)), bytes.Repeat([]byte("x"), 32)); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+
+	compacted := make(chan error, 1)
+	go func() {
+		_, err := store.Compact(CompactOptions{})
+		compacted <- err
+	}()
+
+	if got := strings.Join(snapshotPairs(t, snap.Scan()), ","); got != "order:1=v1,user:1=v1,user:2=v1,user:3=v1" {
+		t.Errorf("Expected the store as it was, got %s", got)
+	}
+	if got := strings.Join(snapshotPairs(t, snap.ScanPrefix([]byte("user:"))), ","); got != "user:1=v1,user:2=v1,user:3=v1" {
+		t.Errorf("Expected the users as they were, got %s", got)
+	}
+	if got := strings.Join(snapshotPairs(t, snap.ScanRange([]byte("order:"), []byte("user:2"))), ","); got != "order:1=v1,user:1=v1" {
+		t.Errorf("Expected [order:, user:2) as it was, got %s", got)
+	}
+	if got := snapshotPairs(t, snap.ScanRange([]byte("user:3"), []byte("user:1"))); len(got) != 0 {
+		t.Errorf("Expected an empty inverted range, got %v", got)
+	}
+	if value, err := snap.Get([]byte("user:2")); err != nil || string(value) != "v1" {
+		t.Errorf("Expected the deleted user:2 in the snapshot, got %q, %v", value, err)
+	}
+	if _, err := snap.Get([]byte("user:new00")); err != ErrKeyNotFound {
+		t.Errorf("Expected keys written after the snapshot to be missing, got %v", err)
+	}
+	if snap.Len() != 4 {
+		t.Errorf("Expected 4 keys in the snapshot, got %d", snap.Len())
+	}
+
+	// Compaction and rotation wait for the snapshot
+	select {
+	case err := <-compacted:
+		t.Fatalf("Expected compaction to wait for the snapshot, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if store.Stats().Rotations != 0 {
+		t.Errorf("Expected no rotation while the snapshot is open")
+	}
+
+	if err := snap.Close(); err != nil {
+		t.Fatalf("Failed to close snapshot: %v", err)
+	}
+	if err := snap.Close(); err != nil {
+		t.Errorf("Expected closing twice to be harmless, got %v", err)
+	}
+	if err := <-compacted; err != nil {
+		t.Fatalf("Failed to compact after the snapshot closed: %v", err)
+	}
+	if _, err := snap.Get([]byte("user:1")); !errors.Is(err, ErrSnapshotClosed) {
+		t.Errorf("Expected reads through a closed snapshot to fail, got %v", err)
+	}
+	it := snap.Scan()
+	if it.Next() || !errors.Is(it.Err(), ErrSnapshotClosed) {
+		t.Errorf("Expected scans of a closed snapshot to fail, got %v", it.Err())
+	}
+
+	if value, err := store.Get([]byte("user:1")); err != nil || string(value) != "v2" {
+		t.Errorf("Expected the store to have moved on, got %q, %v", value, err)
+	}
+}
@@ -0,0 +1,367 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/codec"
+)
+
+// OpenMode controls whether Open takes ownership of the data directory
+type OpenMode string
+
+const (
+	// OpenReadWrite recovers the log and accepts writes (default)
+	OpenReadWrite OpenMode = ""
+	// OpenStandby opens a directory another process is writing to without
+	// modifying it, and keeps the index up to date by tailing the log.
+	// Writes fail with ErrReadOnly until the store is promoted.
+	OpenStandby OpenMode = "standby"
+)
+
+// DefaultTailInterval is how often a standby checks the log for new records
+const DefaultTailInterval = 100 * time.Millisecond
+
+// recordHeaderSize is the encoded size of a record header: CRC32, key size,
+// value size and timestamp
+const recordHeaderSize = 20
+
+// tailRecordsPerCheck is how many records are indexed between context checks
+const tailRecordsPerCheck = 4096
+
+// standbyState tracks how far a standby has followed the primary's log
+type standbyState struct {
+	enabled bool
+	file    *os.File      // Handle on the log being followed, to notice it being replaced
+	offset  int64         // End of the last complete record indexed
+	stop    chan struct{} // Stops the tailer
+}
+
+// IsStandby reports whether the store is following another writer
+func (kv *KVStore) IsStandby() bool {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+	return kv.standby.enabled
+}
+
+// StandbyLag returns how many bytes of the primary's log the standby has not
+// indexed yet, or 0 if the store isn't a standby
+func (kv *KVStore) StandbyLag() int64 {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if !kv.standby.enabled {
+		return 0
+	}
+	info, err := os.Stat(kv.dataFile)
+	if err != nil || info.Size() < kv.standby.offset {
+		return 0
+	}
+	return info.Size() - kv.standby.offset
+}
+
+// openStandbyInternal opens the store as a standby: the log is never
+// validated in place or truncated, temp files are left alone and no
+// manifest is written, since all of them belong to the primary. The index
+// is built by tailing the log from the start, so a record the primary is
+// still writing is simply picked up by a later check. The caller must hold
+// the mutex.
+func (kv *KVStore) openStandbyInternal(ctx context.Context) (*RecoveryResult, error) {
+	start := time.Now()
+
+	info, err := os.Stat(kv.dataFile)
+	if err != nil {
+		return nil, fmt.Errorf("standby has no log to follow: %w", err)
+	}
+
+	kv.standby.enabled = true
+	if err := kv.loadManifestInternal(); err != nil {
+		kv.standby.enabled = false
+		return nil, err
+	}
+
+	writer, err := NewLogWriter(LogWriterConfig{FilePath: kv.dataFile, ReadOnly: true, Codec: kv.codec})
+	if err != nil {
+		kv.standby.enabled = false
+		return nil, err
+	}
+	reader, err := NewLogReader(LogReaderConfig{FilePath: kv.dataFile, Codec: kv.codec})
+	if err != nil {
+		kv.standby.enabled = false
+		writer.Close()
+		return nil, err
+	}
+	kv.writer, kv.reader = writer, reader
+
+	if err := kv.buildIndexFromInternal(false); err != nil {
+		kv.standby.enabled = false
+		reader.Close()
+		writer.Close()
+		return nil, err
+	}
+	records, err := kv.tailInternal(ctx)
+	if err != nil {
+		kv.standby.enabled = false
+		kv.closeTailInternal()
+		reader.Close()
+		writer.Close()
+		return nil, err
+	}
+	kv.indexLoaded = true
+
+	result := &RecoveryResult{
+		RecordsValidated: records,
+		FileSizeBefore:   info.Size(),
+		FileSizeAfter:    info.Size(),
+		IndexRebuilt:     true,
+	}
+	warmed, err := kv.warmupInternal()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error warming cache: %v\n", err)
+	}
+	result.WarmupBytes = warmed
+	result.RecoveryTime = time.Since(start).Nanoseconds()
+
+	kv.openedAt = time.Now()
+	kv.openSize = kv.writer.Size()
+	kv.crcErrors = 0
+	kv.gets, kv.getNanos, kv.bytesRead = 0, 0, 0
+	kv.access.reset()
+	kv.writes.reset()
+
+	kv.isOpen = true
+	kv.startTailerInternal()
+	return result, nil
+}
+
+// tailInternal indexes the complete records appended since the last call
+// and returns how many there were. It stops quietly at a record that is
+// still being written or fails its checksum, and retries it next time. If
+// the log shrank or was replaced, as when the primary flushes its memtable,
+// the index is rebuilt from the manifest's sealed segments and the log is
+// followed from the start. The caller must hold the mutex.
+func (kv *KVStore) tailInternal(ctx context.Context) (int64, error) {
+	if kv.standby.file != nil {
+		current, err := os.Stat(kv.dataFile)
+		if err != nil {
+			return 0, err
+		}
+		following, err := kv.standby.file.Stat()
+		if err != nil {
+			return 0, err
+		}
+		if !os.SameFile(current, following) || current.Size() < kv.standby.offset {
+			kv.closeTailInternal()
+			if err := kv.loadManifestInternal(); err != nil {
+				return 0, err
+			}
+			if err := kv.buildIndexFromInternal(false); err != nil {
+				return 0, err
+			}
+			kv.purgeCachesInternal()
+		}
+	}
+	if kv.standby.file == nil {
+		file, err := os.Open(filepath.Clean(kv.dataFile))
+		if err != nil {
+			return 0, err
+		}
+		kv.standby.file = file
+		kv.standby.offset = 0
+	}
+
+	info, err := kv.standby.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+
+	recordCodec := kv.codec
+	reader := bufio.NewReaderSize(io.NewSectionReader(kv.standby.file, kv.standby.offset, size-kv.standby.offset), 64*1024)
+	header := make([]byte, recordHeaderSize)
+	var records int64
+	for {
+		if err := readFullTail(reader, header); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return records, err
+		}
+		dataSize := codec.DataSize(header)
+		if kv.standby.offset+recordHeaderSize+dataSize > size {
+			break // Still being written
+		}
+
+		data := make([]byte, recordHeaderSize+dataSize)
+		copy(data, header)
+		if err := readFullTail(reader, data[recordHeaderSize:]); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return records, err
+		}
+		record, err := recordCodec.Decode(data)
+		if err != nil || record.Validate() != nil {
+			break // Possibly torn mid-write; checked again next time
+		}
+
+		kv.index.ApplyRecord(record, kv.standby.offset)
+		if start, end, ok := codec.DecodeRangeTombstone(record); ok {
+			kv.invalidateCacheRangeInternal(start, end)
+		} else {
+			kv.invalidateCachesInternal(record.Key)
+		}
+		kv.standby.offset += int64(len(data))
+		records++
+
+		if records%tailRecordsPerCheck == 0 {
+			if err := ctx.Err(); err != nil {
+				kv.writer.advance(kv.standby.offset)
+				return records, err
+			}
+		}
+	}
+
+	kv.writer.advance(kv.standby.offset)
+	return records, nil
+}
+
+// readFullTail fills buf, reporting a short read as io.EOF
+func readFullTail(reader io.Reader, buf []byte) error {
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return io.EOF
+		}
+		return err
+	}
+	return nil
+}
+
+// closeTailInternal releases the handle on the followed log (caller must
+// hold the mutex)
+func (kv *KVStore) closeTailInternal() {
+	if kv.standby.file != nil {
+		kv.standby.file.Close()
+		kv.standby.file = nil
+	}
+}
+
+// startTailerInternal follows the log every TailInterval while the store is
+// an open standby (caller must hold the mutex)
+func (kv *KVStore) startTailerInternal() {
+	if kv.standby.stop != nil {
+		return
+	}
+
+	interval := kv.config.TailInterval
+	if interval <= 0 {
+		interval = DefaultTailInterval
+	}
+
+	stop := make(chan struct{})
+	kv.standby.stop = stop
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				kv.mutex.Lock()
+				if kv.isOpen && kv.standby.enabled {
+					if _, err := kv.tailInternal(context.Background()); err != nil {
+						fmt.Fprintf(os.Stderr, "Error tailing log: %v\n", err)
+					}
+				}
+				kv.mutex.Unlock()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// stopTailerInternal stops the tailer (caller must hold the mutex)
+func (kv *KVStore) stopTailerInternal() {
+	if kv.standby.stop != nil {
+		close(kv.standby.stop)
+		kv.standby.stop = nil
+	}
+}
+
+// Promote turns a standby into a writable store, for failing over once the
+// primary has stopped. Records the primary finished writing are indexed
+// first; a record torn by the primary's exit is truncated, as recovery
+// would on a normal open. Promote takes the data directory's lock like Open,
+// waiting up to LockTimeout, and fails with ErrDatabaseLocked while the
+// primary still has it open.
+func (kv *KVStore) Promote() error {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if !kv.isOpen {
+		return &KVError{"store is not open"}
+	}
+	if !kv.standby.enabled {
+		return nil
+	}
+
+	// The primary must have let go of the directory before this store writes
+	lock, err := acquireDirLock(kv.config.DataDir, kv.config.LockTimeout)
+	if err != nil {
+		return err
+	}
+	kv.dirLock = lock
+	defer func() {
+		if kv.standby.enabled {
+			kv.releaseDirLockInternal()
+		}
+	}()
+
+	kv.stopTailerInternal()
+	if _, err := kv.tailInternal(context.Background()); err != nil {
+		kv.startTailerInternal()
+		return fmt.Errorf("failed to catch up before promotion: %w", err)
+	}
+
+	info, err := os.Stat(kv.dataFile)
+	if err != nil {
+		kv.startTailerInternal()
+		return err
+	}
+	if info.Size() > kv.standby.offset {
+		if err := kv.truncateCorruptedFile(kv.dataFile, kv.standby.offset); err != nil {
+			kv.startTailerInternal()
+			return fmt.Errorf("failed to truncate torn record: %w", err)
+		}
+		kv.crcErrors++
+	}
+
+	writer, err := NewLogWriter(LogWriterConfig{
+		FilePath:          kv.dataFile,
+		FsyncInterval:     kv.config.FsyncInterval,
+		BufferSize:        64 * 1024, // 64KB buffer
+		GroupCommitWindow: kv.config.GroupCommitWindow,
+		Codec:             kv.codec,
+	})
+	if err != nil {
+		kv.startTailerInternal()
+		return err
+	}
+	if err := kv.writer.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error closing standby writer: %v\n", err)
+	}
+	kv.writer = writer
+
+	kv.closeTailInternal()
+	kv.standby.enabled = false
+	kv.startArchiverInternal()
+	return nil
+}
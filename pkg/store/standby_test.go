@@ -0,0 +1,186 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/codec"
+)
+
+// eventually polls cond until it holds or a second has passed
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func openStandby(t *testing.T, dir string) *KVStore {
+	t.Helper()
+	standby, err := NewKVStore(KVStoreConfig{DataDir: dir, OpenMode: OpenStandby, TailInterval: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to create standby: %v", err)
+	}
+	if _, err := standby.Open(); err != nil {
+		t.Fatalf("Failed to open standby: %v", err)
+	}
+	return standby
+}
+
+func TestKVStore_Standby(t *testing.T) {
+	tmpDir := t.TempDir()
+	primary, err := NewKVStore(KVStoreConfig{DataDir: tmpDir})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := primary.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	for _, k := range []string{"user:1", "user:2"} {
+		if err := primary.Put([]byte(k), []byte("value")); err != nil {
+			t.Fatalf("Failed to put %s: %v", k, err)
+		}
+	}
+
+	standby := openStandby(t, tmpDir)
+	defer standby.Close()
+	if !standby.IsStandby() {
+		t.Fatal("Expected the store to be a standby")
+	}
+	if value, err := standby.Get([]byte("user:1")); err != nil || string(value) != "value" {
+		t.Fatalf("Expected the standby to read existing keys, got %q, %v", value, err)
+	}
+
+	// New writes and deletes reach the standby's index
+	if err := primary.Put([]byte("user:3"), []byte("new")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := primary.Delete([]byte("user:1")); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	eventually(t, "the standby to see new writes", func() bool {
+		_, err := standby.Get([]byte("user:1"))
+		value, _ := standby.Get([]byte("user:3"))
+		return err == ErrKeyNotFound && string(value) == "new"
+	})
+	if stats := standby.Stats(); stats.DataSize != primary.Stats().DataSize {
+		t.Errorf("Expected the standby to have followed the whole log, got %d of %d bytes",
+			stats.DataSize, primary.Stats().DataSize)
+	}
+
+	if err := standby.Put([]byte("user:4"), []byte("value")); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly, got %v", err)
+	}
+	if err := standby.Delete([]byte("user:2")); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly, got %v", err)
+	}
+	primary.Close()
+
+	// A record torn by the primary's exit is skipped, not indexed
+	encoded, err := codec.NewRecordCodec().Encode([]byte("user:5"), []byte("torn"))
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	file, err := os.OpenFile(filepath.Join(tmpDir, activeDataFile), os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	if _, err := file.Write(encoded[:len(encoded)-2]); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	file.Close()
+	time.Sleep(20 * time.Millisecond)
+	if _, err := standby.Get([]byte("user:5")); err != ErrKeyNotFound {
+		t.Errorf("Expected the torn record to be ignored, got %v", err)
+	}
+
+	// Promotion drops the torn record and makes the standby writable
+	if err := standby.Promote(); err != nil {
+		t.Fatalf("Failed to promote: %v", err)
+	}
+	if standby.IsStandby() {
+		t.Error("Expected the promoted store not to be a standby")
+	}
+	if err := standby.Put([]byte("user:6"), []byte("value")); err != nil {
+		t.Fatalf("Expected writes after promotion, got %v", err)
+	}
+	standby.Close()
+
+	reopened, err := NewKVStore(KVStoreConfig{DataDir: tmpDir})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	result, err := reopened.Open()
+	if err != nil {
+		t.Fatalf("Failed to reopen: %v", err)
+	}
+	defer reopened.Close()
+	if result.RecordsTruncated != 0 {
+		t.Errorf("Expected a clean log after promotion, got %d truncated records", result.RecordsTruncated)
+	}
+	keys, _ := reopened.ListKeys(nil)
+	if len(keys) != 3 {
+		t.Errorf("Expected user:2, user:3 and user:6, got %v", keys)
+	}
+}
+
+func TestKVStore_StandbyFollowsReplacedLog(t *testing.T) {
+	tmpDir := t.TempDir()
+	otherDir := t.TempDir()
+	for dir, key := range map[string]string{tmpDir: "old", otherDir: "new"} {
+		kv, err := NewKVStore(KVStoreConfig{DataDir: dir})
+		if err != nil {
+			t.Fatalf("Failed to create KV store: %v", err)
+		}
+		if _, err := kv.Open(); err != nil {
+			t.Fatalf("Failed to open KV store: %v", err)
+		}
+		if err := kv.Put([]byte(key), []byte("value")); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+		kv.Close()
+	}
+
+	standby := openStandby(t, tmpDir)
+	defer standby.Close()
+	if _, err := standby.Get([]byte("old")); err != nil {
+		t.Fatalf("Expected the standby to read old, got %v", err)
+	}
+
+	// The log being swapped out, as a rewrite would, rebuilds the index
+	if err := os.Rename(filepath.Join(otherDir, activeDataFile), filepath.Join(tmpDir, activeDataFile)); err != nil {
+		t.Fatalf("Failed to replace log: %v", err)
+	}
+	eventually(t, "the standby to follow the new log", func() bool {
+		_, errOld := standby.Get([]byte("old"))
+		_, errNew := standby.Get([]byte("new"))
+		return errOld == ErrKeyNotFound && errNew == nil
+	})
+}
+
+func TestKVStore_StandbyRequiresLog(t *testing.T) {
+	standby, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir(), OpenMode: OpenStandby})
+	if err != nil {
+		t.Fatalf("Failed to create standby: %v", err)
+	}
+	if _, err := standby.Open(); err == nil {
+		standby.Close()
+		t.Fatal("Expected opening a standby without a log to fail")
+	}
+
+	unknown, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir(), OpenMode: "replica"})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := unknown.Open(); err == nil {
+		unknown.Close()
+		t.Fatal("Expected an unknown open mode to fail")
+	}
+}
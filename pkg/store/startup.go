@@ -0,0 +1,224 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// IndexLoadMode controls when Open builds the in-memory index
+type IndexLoadMode string
+
+const (
+	// IndexLoadEager builds the index during Open (default)
+	IndexLoadEager IndexLoadMode = "eager"
+	// IndexLoadLazy defers building the index until the first operation,
+	// making Open return as soon as the log has been validated
+	IndexLoadLazy IndexLoadMode = "lazy"
+)
+
+// recoveryProgressInterval is how many bytes are scanned between progress callbacks
+const recoveryProgressInterval = 4 * 1024 * 1024
+
+// RecoveryProgress describes how far log validation has got during Open
+type RecoveryProgress struct {
+	BytesScanned     int64
+	TotalBytes       int64
+	RecordsValidated int64
+	Elapsed          time.Duration
+	ETA              time.Duration // Estimated time remaining, 0 when unknown or done
+	Done             bool
+}
+
+// Fraction returns the share of the log scanned so far, between 0 and 1
+func (p RecoveryProgress) Fraction() float64 {
+	if p.TotalBytes <= 0 {
+		return 1
+	}
+	return float64(p.BytesScanned) / float64(p.TotalBytes)
+}
+
+// checkOpenInternal verifies the store is open and its index is loaded
+// (caller must hold the mutex)
+func (kv *KVStore) checkOpenInternal() error {
+	if !kv.isOpen {
+		return &KVError{"store is not open"}
+	}
+	return kv.ensureIndexInternal()
+}
+
+// ensureIndexInternal builds the index if it was deferred by IndexLoadLazy
+// (caller must hold the mutex)
+func (kv *KVStore) ensureIndexInternal() error {
+	if kv.indexLoaded {
+		return nil
+	}
+
+	if err := kv.buildIndexInternal(); err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
+	}
+	kv.indexLoaded = true
+
+	if _, err := kv.warmupInternal(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error warming cache: %v\n", err)
+	}
+	return nil
+}
+
+// buildIndexInternal rebuilds the index from the sealed segments, oldest
+// first, and then the active log (caller must hold the mutex)
+func (kv *KVStore) buildIndexInternal() error {
+	return kv.buildIndexFromInternal(true)
+}
+
+// buildIndexFromInternal rebuilds the index from the sealed segments and,
+// if withActive is set, the active log (caller must hold the mutex)
+func (kv *KVStore) buildIndexFromInternal(withActive bool) error {
+	return kv.replayInternal(kv.index, withActive, math.MaxUint64)
+}
+
+// replayInternal rebuilds idx from the sealed segments and, if withActive
+// is set, the active log, applying only the records written at or before
+// until (caller must hold the mutex)
+func (kv *KVStore) replayInternal(idx *HashIndex, withActive bool, until uint64) error {
+	var logs []SegmentLog
+	defer func() {
+		for _, log := range logs {
+			if log.FileID != activeFileID {
+				log.Reader.Close()
+			}
+		}
+	}()
+
+	for _, seg := range kv.segments.list() {
+		if seg.FileID == activeFileID {
+			continue
+		}
+		reader, err := NewLogReader(LogReaderConfig{FilePath: seg.Path, Codec: kv.codec})
+		if err != nil {
+			return fmt.Errorf("failed to open segment %d: %w", seg.FileID, err)
+		}
+		logs = append(logs, SegmentLog{FileID: seg.FileID, Reader: reader})
+	}
+	if withActive {
+		logs = append(logs, SegmentLog{FileID: activeFileID, Reader: kv.reader})
+	}
+
+	return idx.BuildFromSegmentsUntil(logs, until)
+}
+
+// warmupInternal reads the records of every key under the configured warmup
+// prefixes, in file order, so their pages are resident before the first
+// request arrives. It returns the number of bytes read.
+func (kv *KVStore) warmupInternal() (int64, error) {
+	if len(kv.config.WarmupPrefixes) == 0 {
+		return 0, nil
+	}
+
+	var entries []*IndexEntry
+	for _, prefix := range kv.config.WarmupPrefixes {
+		for _, key := range kv.index.KeysWithPrefix(prefix) {
+			if entry, ok := kv.index.Get([]byte(key)); ok {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].FileID != entries[j].FileID {
+			return entries[i].FileID < entries[j].FileID
+		}
+		return entries[i].Offset < entries[j].Offset
+	})
+
+	files := make(map[uint32]*os.File)
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+
+	var warmed int64
+	buf := make([]byte, 0, 64*1024)
+	for _, entry := range entries {
+		if kv.config.WarmupMaxBytes > 0 && warmed+int64(entry.Size) > kv.config.WarmupMaxBytes {
+			break
+		}
+
+		file, ok := files[entry.FileID]
+		if !ok {
+			path, known := kv.segments.touch(entry.FileID)
+			if !known {
+				continue
+			}
+			var err error
+			if file, err = os.Open(filepath.Clean(path)); err != nil {
+				return warmed, err
+			}
+			files[entry.FileID] = file
+		}
+
+		if cap(buf) < int(entry.Size) {
+			buf = make([]byte, entry.Size)
+		}
+		n, err := file.ReadAt(buf[:entry.Size], entry.Offset)
+		warmed += int64(n)
+		if err != nil {
+			return warmed, err
+		}
+	}
+
+	return warmed, nil
+}
+
+// recoveryTracker reports validation progress and enforces the recovery
+// budget and cancellation
+type recoveryTracker struct {
+	ctx        context.Context
+	config     *KVStoreConfig
+	start      time.Time
+	totalBytes int64
+	lastReport int64
+}
+
+// update is called after each validated record; it returns ErrRecoveryBudget
+// once the configured budget is spent and the context error once canceled
+func (t *recoveryTracker) update(bytesScanned, records int64) error {
+	if bytesScanned-t.lastReport >= recoveryProgressInterval {
+		t.lastReport = bytesScanned
+		t.report(bytesScanned, records, false)
+	}
+
+	if err := t.ctx.Err(); err != nil {
+		return fmt.Errorf("recovery canceled: %w", err)
+	}
+
+	if t.config.RecoveryBudget > 0 && time.Since(t.start) > t.config.RecoveryBudget {
+		return ErrRecoveryBudget
+	}
+	return nil
+}
+
+// report invokes the progress callback, if any
+func (t *recoveryTracker) report(bytesScanned, records int64, done bool) {
+	if t.config.OnRecoveryProgress == nil {
+		return
+	}
+	elapsed := time.Since(t.start)
+	var eta time.Duration
+	if !done && bytesScanned > 0 && t.totalBytes > bytesScanned {
+		eta = time.Duration(float64(elapsed) * float64(t.totalBytes-bytesScanned) / float64(bytesScanned))
+	}
+
+	t.config.OnRecoveryProgress(RecoveryProgress{
+		BytesScanned:     bytesScanned,
+		TotalBytes:       t.totalBytes,
+		RecordsValidated: records,
+		Elapsed:          elapsed,
+		ETA:              eta,
+		Done:             done,
+	})
+}
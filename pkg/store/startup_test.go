@@ -0,0 +1,147 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestKVStore_StartupOptions(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	seed, err := NewKVStore(KVStoreConfig{DataDir: tmpDir})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := seed.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	for _, k := range []string{"hot:1", "hot:2", "cold:1"} {
+		if err := seed.Put([]byte(k), []byte("value")); err != nil {
+			t.Fatalf("Failed to put %s: %v", k, err)
+		}
+	}
+	seed.Close()
+
+	var progress []RecoveryProgress
+	store, err := NewKVStore(KVStoreConfig{
+		DataDir:            tmpDir,
+		IndexLoad:          IndexLoadLazy,
+		WarmupPrefixes:     []string{"hot:"},
+		OnRecoveryProgress: func(p RecoveryProgress) { progress = append(progress, p) },
+	})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	result, err := store.Open()
+	if err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	if result.IndexRebuilt || store.indexLoaded {
+		t.Error("Expected index load to be deferred")
+	}
+	if result.RecordsValidated != 3 {
+		t.Errorf("Expected 3 validated records, got %d", result.RecordsValidated)
+	}
+	if len(progress) == 0 || !progress[len(progress)-1].Done {
+		t.Fatalf("Expected a final progress report, got %+v", progress)
+	}
+	if last := progress[len(progress)-1]; last.RecordsValidated != 3 || last.BytesScanned != last.TotalBytes {
+		t.Errorf("Unexpected final progress: %+v", last)
+	}
+
+	// First access builds the index
+	value, err := store.Get([]byte("cold:1"))
+	if err != nil || string(value) != "value" {
+		t.Fatalf("Expected lazy Get to succeed, got %q, %v", value, err)
+	}
+	if !store.indexLoaded {
+		t.Error("Expected index to be loaded after first access")
+	}
+
+	warmed, err := store.warmupInternal()
+	if err != nil {
+		t.Fatalf("Warmup failed: %v", err)
+	}
+	entry, _ := store.index.Get([]byte("hot:1"))
+	if warmed != 2*int64(entry.Size) {
+		t.Errorf("Expected warmup to read 2 records (%d bytes), got %d", 2*entry.Size, warmed)
+	}
+
+	store.config.WarmupMaxBytes = int64(entry.Size)
+	if warmed, _ := store.warmupInternal(); warmed != int64(entry.Size) {
+		t.Errorf("Expected warmup to stop at the byte cap, read %d", warmed)
+	}
+}
+
+func TestKVStore_RecoveryBudget(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	seed, err := NewKVStore(KVStoreConfig{DataDir: tmpDir})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := seed.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	if err := seed.Put([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	seed.Close()
+
+	store, err := NewKVStore(KVStoreConfig{DataDir: tmpDir, RecoveryBudget: 1})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != ErrRecoveryBudget {
+		t.Errorf("Expected ErrRecoveryBudget, got %v", err)
+	}
+}
+
+func TestKVStore_OpenContextCanceled(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	seed, err := NewKVStore(KVStoreConfig{DataDir: tmpDir})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := seed.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	if err := seed.Put([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	seed.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	store, err := NewKVStore(KVStoreConfig{DataDir: tmpDir})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.OpenContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+
+	// A canceled recovery leaves the store openable
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Expected reopen to succeed, got %v", err)
+	}
+	defer store.Close()
+	if value, err := store.Get([]byte("k")); err != nil || string(value) != "v" {
+		t.Errorf("Expected k=v after reopen, got %q, %v", value, err)
+	}
+}
+
+func TestRecoveryProgress_Fraction(t *testing.T) {
+	p := RecoveryProgress{BytesScanned: 25, TotalBytes: 100}
+	if p.Fraction() != 0.25 {
+		t.Errorf("Expected 0.25, got %v", p.Fraction())
+	}
+	if (RecoveryProgress{}).Fraction() != 1 {
+		t.Error("Expected empty log to report complete")
+	}
+}
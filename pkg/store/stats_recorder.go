@@ -0,0 +1,42 @@
+package store
+
+// Names the store records through its StatsRecorder. Counters only grow;
+// histograms observe durations in seconds.
+const (
+	StatGets         = "gets"          // Counter: Get calls
+	StatGetMisses    = "get_misses"    // Counter: Gets of missing or deleted keys
+	StatPuts         = "puts"          // Counter: records appended by writes
+	StatDeletes      = "deletes"       // Counter: tombstones appended
+	StatBytesRead    = "bytes_read"    // Counter: record bytes read by Get
+	StatBytesWritten = "bytes_written" // Counter: record bytes appended to the log
+	StatGetSeconds   = "get_seconds"   // Histogram: Get latency
+	StatWriteSeconds = "write_seconds" // Histogram: write latency, including the wait for durability
+)
+
+// StatCounters and StatHistograms list every name the store records, so an
+// adapter can register its metrics up front
+var (
+	StatCounters   = []string{StatGets, StatGetMisses, StatPuts, StatDeletes, StatBytesRead, StatBytesWritten}
+	StatHistograms = []string{StatGetSeconds, StatWriteSeconds}
+)
+
+// StatsRecorder receives the store's operational metrics, so embedders can
+// feed them to whatever metrics system they use. It is called on the read
+// and write paths, sometimes with the store lock held, so implementations
+// must be cheap and safe for concurrent use.
+type StatsRecorder interface {
+	// Count adds delta to the named counter
+	Count(name string, delta int64)
+	// Observe records one sample of the named histogram
+	Observe(name string, value float64)
+}
+
+// NopStatsRecorder discards everything; it is the default when
+// KVStoreConfig.Stats is nil
+type NopStatsRecorder struct{}
+
+// Count implements StatsRecorder
+func (NopStatsRecorder) Count(string, int64) {}
+
+// Observe implements StatsRecorder
+func (NopStatsRecorder) Observe(string, float64) {}
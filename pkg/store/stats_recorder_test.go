@@ -0,0 +1,58 @@
+package store
+
+import (
+	"sync"
+	"testing"
+)
+
+// recordingStats is a StatsRecorder that keeps everything it is given
+type recordingStats struct {
+	mutex    sync.Mutex
+	counts   map[string]int64
+	observed map[string]int
+}
+
+func (r *recordingStats) Count(name string, delta int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.counts[name] += delta
+}
+
+func (r *recordingStats) Observe(name string, _ float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.observed[name]++
+}
+
+func TestKVStore_StatsRecorder(t *testing.T) {
+	stats := &recordingStats{counts: make(map[string]int64), observed: make(map[string]int)}
+	store, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir(), Stats: stats})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Put([]byte("a"), []byte("value")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := store.Delete([]byte("a")); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if _, err := store.Get([]byte("a")); err != ErrKeyNotFound {
+		t.Fatalf("Expected ErrKeyNotFound, got %v", err)
+	}
+
+	if stats.counts[StatPuts] != 1 || stats.counts[StatDeletes] != 1 || stats.counts[StatGets] != 1 ||
+		stats.counts[StatGetMisses] != 1 {
+		t.Errorf("Unexpected counts: %v", stats.counts)
+	}
+	if stats.counts[StatBytesWritten] <= int64(len("avalue")) {
+		t.Errorf("Expected record bytes written, got %d", stats.counts[StatBytesWritten])
+	}
+	if stats.observed[StatWriteSeconds] != 2 || stats.observed[StatGetSeconds] != 1 {
+		t.Errorf("Unexpected observations: %v", stats.observed)
+	}
+}
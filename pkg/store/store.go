@@ -2,10 +2,6 @@ package store
 
 import (
 	"context"
-	"fmt"
-	"os"
-	"path/filepath"
-	"runtime"
 	"time"
 )
 
@@ -16,8 +12,17 @@ type ExplainOptions struct {
 	PK          string
 }
 
+// ExplainSchemaVersion is the version of the ExplainResult JSON schema.
+// Within a version, fields are only ever added: existing fields keep their
+// name, type and meaning. Renaming, removing or redefining a field bumps
+// the version. See pkg/api/README.md for the documented schema.
+const ExplainSchemaVersion = 1
+
 // ExplainResult holds the results of an explain operation
 type ExplainResult struct {
+	SchemaVersion int       `json:"schema_version"`
+	GeneratedAt   time.Time `json:"generated_at"`
+
 	Global struct {
 		TotalKeys     int           `json:"total_keys"`
 		ActiveKeys    int           `json:"active_keys"`
@@ -26,6 +31,14 @@ type ExplainResult struct {
 		LiveSizeMB    float64       `json:"live_size_mb"`
 		IndexMemoryMB float64       `json:"index_memory_mb"`
 		Uptime        time.Duration `json:"uptime"`
+
+		// ActiveKeys and LiveSizeMB split into user data and internal
+		// bookkeeping, and the log bytes held by tombstones
+		UserKeys        int     `json:"user_keys"`
+		InternalKeys    int     `json:"internal_keys"`
+		UserSizeMB      float64 `json:"user_size_mb"`
+		InternalSizeMB  float64 `json:"internal_size_mb"`
+		TombstoneSizeMB float64 `json:"tombstone_size_mb"`
 	} `json:"global"`
 
 	Segments []Segment `json:"segments"`
@@ -40,9 +53,37 @@ type ExplainResult struct {
 			AvgGetLatencyMs float64 `json:"avg_get_latency_ms,omitempty"`
 			IORateMBs       float64 `json:"io_rate_mbs,omitempty"`
 		} `json:"metrics,omitempty"`
+
+		// Where writes spent their time since the store was opened
+		WritePhases WritePhaseStats `json:"write_phases"`
+
+		// Keys with a TTL by how soon they expire
+		Expirations ExpiryHistogram `json:"expirations"`
 	} `json:"diagnostics"`
 
 	Warnings []string `json:"warnings,omitempty"`
+
+	// Advisory suggests tuning from recent reads: the hot prefixes, a
+	// cache size and, through a query engine, stored-field indexes
+	Advisory Advisory `json:"advisory"`
+
+	// SecondaryIndexes reports each secondary index's memory when the explain
+	// runs through a query engine that owns them
+	SecondaryIndexes []IndexMemoryStats `json:"secondary_indexes,omitempty"`
+
+	// RequestID echoes the ID of the request the explain ran for, if any
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// IndexMemoryStats reports the estimated resident memory of a secondary index
+type IndexMemoryStats struct {
+	Field    string    `json:"field"`
+	State    string    `json:"state"`
+	Entries  int       `json:"entries"`
+	MemoryMB float64   `json:"memory_mb"`
+	TreeMB   float64   `json:"tree_mb"`  // Part of MemoryMB held by the index's B+Tree
+	Resident bool      `json:"resident"` // False while evicted to save memory
+	LastUsed time.Time `json:"last_used,omitempty"`
 }
 
 type Segment struct {
@@ -52,6 +93,20 @@ type Segment struct {
 	SizeMB  float64 `json:"size_mb"`
 }
 
+// WritePhaseStats breaks write latency down by phase: lock_wait, validate,
+// encode, buffer, fsync and index
+type WritePhaseStats struct {
+	Writes     int64                  `json:"writes"`
+	SlowWrites int64                  `json:"slow_writes"` // Over KVStoreConfig.SlowWriteThreshold
+	Phases     map[string]PhaseTiming `json:"phases"`
+}
+
+type PhaseTiming struct {
+	AvgMs    float64 `json:"avg_ms"`
+	MaxMs    float64 `json:"max_ms"`
+	SharePct float64 `json:"share_pct"` // Of all time spent in writes
+}
+
 type Sample struct {
 	Key   string    `json:"key"`
 	Value string    `json:"value_truncated"`
@@ -71,137 +126,40 @@ type PKStats struct {
 	Cardinality string    `json:"cardinality"`
 }
 
-// Store defines the basic store interface
+// Store is the key-value engine as embedders see it. *KVStore implements
+// it; NewStore opens one with the default configuration.
 type Store interface {
-	Explain(ctx context.Context, opts ExplainOptions) (*ExplainResult, error)
 	Put(key, value []byte) error
 	Get(key []byte) ([]byte, error)
+	Delete(key []byte) error
+	ListKeys(prefix []byte) ([]string, error)
+	Explain(ctx context.Context, opts ExplainOptions) (*ExplainResult, error)
+	Stats() *StoreStats
 	Close() error
 }
 
-// StoreImpl is the concrete implementation
-type StoreImpl struct {
-	dataDir   string
-	startTime time.Time
-	keys      int
-	keysMap   map[string]struct{}
-}
+var _ Store = (*KVStore)(nil)
 
-// NewStore creates a new store
+// NewStore creates and opens a KVStore in dataDir with the default
+// configuration. Use NewKVStore for anything beyond the defaults, or to see
+// what Open recovered.
 func NewStore(dataDir string) (Store, error) {
-	s := &StoreImpl{
-		dataDir:   dataDir,
-		startTime: time.Now(),
-		keys:      0,
-		keysMap:   make(map[string]struct{}),
+	kv, err := NewKVStore(KVStoreConfig{DataDir: dataDir})
+	if err != nil {
+		return nil, err
 	}
-
-	// Stub data
-	for i := 1; i <= 1250; i++ {
-		key := fmt.Sprintf("key:%d", i)
-		s.keysMap[key] = struct{}{}
-		if i%10 == 0 {
-			delete(s.keysMap, key)
-		}
+	if _, err := kv.Open(); err != nil {
+		return nil, err
 	}
-	s.keys = len(s.keysMap)
-
-	// Create dummy segments
-	if _, err := os.Stat(filepath.Join(dataDir, "001.data")); os.IsNotExist(err) {
-		if err := os.MkdirAll(dataDir, 0750); err != nil {
-			return nil, err
-		}
-		for _, seg := range []string{"001.data", "002.data"} {
-			// Validate segment name to prevent path traversal
-			if seg != "001.data" && seg != "002.data" {
-				return nil, fmt.Errorf("invalid segment name: %s", seg)
-			}
-			f, err := os.Create(filepath.Join(dataDir, seg))
-			if err != nil {
-				return nil, err
-			}
-			f.Close()
-		}
-	}
-
-	return s, nil
+	return kv, nil
 }
 
-// Explain gathers stats
-func (s *StoreImpl) Explain(ctx context.Context, opts ExplainOptions) (*ExplainResult, error) {
-	res := &ExplainResult{}
-	res.Global.TotalKeys = s.keys
-	res.Global.ActiveKeys = s.keys * 9 / 10
-	res.Global.Tombstones = s.keys / 10
-	res.Global.TotalSizeMB = 5.2
-	res.Global.LiveSizeMB = 4.1
-	res.Global.Uptime = time.Since(s.startTime)
-
-	var m runtime.MemStats
-	runtime.ReadMemStats(&m)
-	res.Global.IndexMemoryMB = float64(m.Alloc) / (1024 * 1024)
-
-	res.Segments = []Segment{
-		{ID: "001", Keys: 600, DeadPct: 10.0, SizeMB: 2.1},
-		{ID: "002", Keys: 650, DeadPct: 15.0, SizeMB: 3.1},
+// NewExplainResult returns an empty result stamped with the schema version,
+// the current time and the request ID carried by ctx
+func NewExplainResult(ctx context.Context) *ExplainResult {
+	return &ExplainResult{
+		SchemaVersion: ExplainSchemaVersion,
+		GeneratedAt:   time.Now().UTC(),
+		RequestID:     RequestIDFromContext(ctx),
 	}
-
-	for _, seg := range res.Segments {
-		if seg.DeadPct > 20.0 {
-			res.Diagnostics.CompactionReady = append(res.Diagnostics.CompactionReady, seg.ID)
-		}
-	}
-
-	res.Partitions = map[string]PKStats{
-		"User": {Keys: 800, SKRanges: []SKRange{{Name: "Location", Count: 500,
-			Min: "loc:1", Max: "loc:750"}}, Cardinality: "1:N"},
-		"Item": {Keys: 450, SKRanges: []SKRange{{Name: "Category", Count: 250,
-			Min: "cat:1", Max: "cat:300"}}, Cardinality: "N:1"},
-	}
-
-	if opts.WithSamples > 0 {
-		samples := []Sample{
-			{Key: "user:123", Value: "john_doe@example.com", Ts: time.Now().Add(-time.Hour)},
-			{Key: "item:456", Value: "laptop", Ts: time.Now().Add(-2 * time.Hour)},
-		}
-		if opts.WithSamples < len(samples) {
-			samples = samples[:opts.WithSamples]
-		}
-		res.Diagnostics.Samples = samples
-	}
-
-	if opts.PK != "" {
-		if pkStats, exists := res.Partitions[opts.PK]; !exists || pkStats.Keys == 0 {
-			res.Warnings = append(res.Warnings, fmt.Sprintf("No data for PK: %s", opts.PK))
-		}
-	}
-
-	res.Diagnostics.CRCErrors = 0
-
-	if opts.WithMetrics {
-		res.Diagnostics.Metrics.AvgGetLatencyMs = 1.2
-		res.Diagnostics.Metrics.IORateMBs = 10.5
-	}
-
-	return res, nil
-}
-
-// Put
-func (s *StoreImpl) Put(key, value []byte) error {
-	s.keysMap[string(key)] = struct{}{}
-	s.keys = len(s.keysMap)
-	return nil
-}
-
-// Get
-func (s *StoreImpl) Get(key []byte) ([]byte, error) {
-	if _, exists := s.keysMap[string(key)]; !exists {
-		return nil, fmt.Errorf("key not found")
-	}
-	return []byte("stub_value"), nil
-}
-
-// Close
-func (s *StoreImpl) Close() error {
-	return nil
 }
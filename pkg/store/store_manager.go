@@ -0,0 +1,297 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Names of the stores every StoreManager can hold besides namespaces
+const (
+	DefaultStoreName = "default" // User data, kept directly in the data directory
+	SystemStoreName  = "system"  // API keys and server configuration, in <data-dir>/system
+)
+
+// namespaceDir is the directory under the data directory that holds one
+// subdirectory per namespace store
+const namespaceDir = "ns"
+
+// namespacePattern is the set of valid namespace names; they become
+// directory names, so nothing that could escape the namespace directory
+var namespacePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Errors returned by StoreManager
+var (
+	ErrInvalidNamespace  = &KVError{"invalid namespace name"}
+	ErrNamespaceNotFound = &KVError{"namespace not found"}
+	ErrManagerClosed     = &KVError{"store manager is closed"}
+)
+
+// StoreManagerConfig configures a StoreManager
+type StoreManagerConfig struct {
+	DataDir string // Root data directory; the default store lives here
+
+	// Base is the configuration every store starts from; its DataDir is
+	// replaced per store. Only the default store keeps the extra data
+	// directories and archive tier, since those are shared across stores
+	// that would otherwise mix their segments.
+	Base KVStoreConfig
+
+	// Configure, if set, adjusts a store's configuration before it opens
+	Configure func(name string, config *KVStoreConfig)
+}
+
+// ManagedStoreStats describes one store held by a StoreManager
+type ManagedStoreStats struct {
+	Name     string          `json:"name"`
+	DataDir  string          `json:"data_dir"`
+	OpenedAt time.Time       `json:"opened_at"`
+	Recovery *RecoveryResult `json:"recovery"`
+	Stats    *StoreStats     `json:"stats"`
+}
+
+// managedStore is an open store and how it was opened
+type managedStore struct {
+	kv       *KVStore
+	dataDir  string
+	openedAt time.Time
+	recovery *RecoveryResult
+}
+
+// StoreManager owns the KVStores of a data directory: the default store,
+// the system store and any number of namespace stores under ns/<name>.
+// Stores open on first use, so a process only pays recovery for the stores
+// it touches, and Close closes them all. Safe for concurrent use.
+type StoreManager struct {
+	config StoreManagerConfig
+	stores map[string]*managedStore
+	closed bool
+	mutex  sync.Mutex
+}
+
+// NewStoreManager creates a manager for the stores under config.DataDir.
+// No store is opened until it is first requested.
+func NewStoreManager(config StoreManagerConfig) (*StoreManager, error) {
+	if config.DataDir == "" {
+		return nil, &KVError{"store manager requires a data directory"}
+	}
+	return &StoreManager{config: config, stores: make(map[string]*managedStore)}, nil
+}
+
+// DataDir returns the root data directory the manager's stores live under
+func (m *StoreManager) DataDir() string {
+	return m.config.DataDir
+}
+
+// ValidateNamespace reports whether name can be used as a namespace:
+// 1-64 lowercase letters, digits, '-' and '_', not starting with '-' or
+// '_', and not the name of the default or system store
+func ValidateNamespace(name string) error {
+	if !namespacePattern.MatchString(name) || name == DefaultStoreName || name == SystemStoreName {
+		return fmt.Errorf("%w: %q", ErrInvalidNamespace, name)
+	}
+	return nil
+}
+
+// Default returns the default store, opening it if needed
+func (m *StoreManager) Default(ctx context.Context) (*KVStore, error) {
+	return m.open(ctx, DefaultStoreName, true)
+}
+
+// System returns the system store, opening it if needed
+func (m *StoreManager) System(ctx context.Context) (*KVStore, error) {
+	return m.open(ctx, SystemStoreName, true)
+}
+
+// Namespace returns the store for a namespace, opening it if needed. With
+// create false a namespace that doesn't exist on disk yet is reported as
+// ErrNamespaceNotFound instead of being created.
+func (m *StoreManager) Namespace(ctx context.Context, name string, create bool) (*KVStore, error) {
+	if err := ValidateNamespace(name); err != nil {
+		return nil, err
+	}
+	return m.open(ctx, name, create)
+}
+
+// Namespaces lists the namespaces present on disk, opened or not
+func (m *StoreManager) Namespaces() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(m.config.DataDir, namespaceDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && ValidateNamespace(entry.Name()) == nil {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// Stats describes every open store, default first, then system, then
+// namespaces by name
+func (m *StoreManager) Stats() []ManagedStoreStats {
+	m.mutex.Lock()
+	names := make([]string, 0, len(m.stores))
+	stores := make(map[string]*managedStore, len(m.stores))
+	for name, s := range m.stores {
+		names = append(names, name)
+		stores[name] = s
+	}
+	m.mutex.Unlock()
+
+	rank := func(name string) int {
+		switch name {
+		case DefaultStoreName:
+			return 0
+		case SystemStoreName:
+			return 1
+		}
+		return 2
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if ri, rj := rank(names[i]), rank(names[j]); ri != rj {
+			return ri < rj
+		}
+		return names[i] < names[j]
+	})
+
+	stats := make([]ManagedStoreStats, 0, len(names))
+	for _, name := range names {
+		s := stores[name]
+		stats = append(stats, ManagedStoreStats{
+			Name:     name,
+			DataDir:  s.dataDir,
+			OpenedAt: s.openedAt,
+			Recovery: s.recovery,
+			Stats:    s.kv.Stats(),
+		})
+	}
+	return stats
+}
+
+// Recovery returns what opening the named store recovered, or nil if the
+// store isn't open
+func (m *StoreManager) Recovery(name string) *RecoveryResult {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if s, ok := m.stores[name]; ok {
+		return s.recovery
+	}
+	return nil
+}
+
+// Recoveries returns what opening each open store recovered, by store name
+func (m *StoreManager) Recoveries() map[string]*RecoveryResult {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	recoveries := make(map[string]*RecoveryResult, len(m.stores))
+	for name, s := range m.stores {
+		if s.recovery != nil {
+			recoveries[name] = s.recovery
+		}
+	}
+	return recoveries
+}
+
+// Close closes every open store. The manager can't open stores afterwards.
+func (m *StoreManager) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+
+	var errs []error
+	for name, s := range m.stores {
+		if err := s.kv.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close store %s: %w", name, err))
+		}
+	}
+	m.stores = nil
+	return errors.Join(errs...)
+}
+
+// storeDir is where the named store keeps its data
+func (m *StoreManager) storeDir(name string) string {
+	return storeDataDir(m.config.DataDir, name)
+}
+
+// storeDataDir is where the named store keeps its data under dataDir
+func storeDataDir(dataDir, name string) string {
+	switch name {
+	case DefaultStoreName:
+		return dataDir
+	case SystemStoreName:
+		return filepath.Join(dataDir, SystemStoreName)
+	}
+	return filepath.Join(dataDir, namespaceDir, name)
+}
+
+// storeConfig derives the named store's configuration from the base
+func (m *StoreManager) storeConfig(name string) KVStoreConfig {
+	config := m.config.Base
+	config.DataDir = m.storeDir(name)
+	if name != DefaultStoreName {
+		config.DataDirs = nil
+		config.Placement = ""
+		config.ArchiveDir = ""
+		config.ArchiveAfter = 0
+	}
+	if name == SystemStoreName {
+		config.FsyncInterval = time.Second // More frequent fsync for system data
+	}
+	if m.config.Configure != nil {
+		m.config.Configure(name, &config)
+	}
+	return config
+}
+
+// open returns the named store, opening it first if it isn't open yet.
+// Stores are opened with the manager locked, so concurrent callers wait
+// for a single recovery instead of racing to open the same directory.
+func (m *StoreManager) open(ctx context.Context, name string, create bool) (*KVStore, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.closed {
+		return nil, ErrManagerClosed
+	}
+	if s, ok := m.stores[name]; ok {
+		return s.kv, nil
+	}
+
+	if _, err := os.Stat(m.storeDir(name)); errors.Is(err, os.ErrNotExist) && !create {
+		return nil, fmt.Errorf("%w: %q", ErrNamespaceNotFound, name)
+	}
+	config := m.storeConfig(name)
+	if err := os.MkdirAll(config.DataDir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create data directory for store %s: %w", name, err)
+	}
+
+	kv, err := NewKVStore(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create store %s: %w", name, err)
+	}
+	recovery, err := kv.OpenContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store %s: %w", name, err)
+	}
+
+	m.stores[name] = &managedStore{kv: kv, dataDir: config.DataDir, openedAt: time.Now(), recovery: recovery}
+	return kv, nil
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreManager(t *testing.T) {
+	dir := t.TempDir()
+	var configured []string
+	manager, err := NewStoreManager(StoreManagerConfig{
+		DataDir: dir,
+		Base:    KVStoreConfig{DataDirs: []string{t.TempDir()}},
+		Configure: func(name string, config *KVStoreConfig) {
+			configured = append(configured, name)
+			if name == SystemStoreName && config.FsyncInterval != time.Second {
+				t.Errorf("Expected the system store to fsync every second, got %v", config.FsyncInterval)
+			}
+			if name != DefaultStoreName && config.DataDirs != nil {
+				t.Errorf("Expected store %s not to share extra data directories", name)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	ctx := context.Background()
+
+	def, err := manager.Default(ctx)
+	if err != nil {
+		t.Fatalf("Failed to open default store: %v", err)
+	}
+	if again, _ := manager.Default(ctx); again != def {
+		t.Error("Expected the default store to be opened once")
+	}
+	system, err := manager.System(ctx)
+	if err != nil {
+		t.Fatalf("Failed to open system store: %v", err)
+	}
+	orders, err := manager.Namespace(ctx, "orders", true)
+	if err != nil {
+		t.Fatalf("Failed to open namespace: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ns", "orders")); err != nil {
+		t.Errorf("Expected the namespace under ns/: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "system")); err != nil {
+		t.Errorf("Expected the system store under system/: %v", err)
+	}
+
+	// Stores are isolated from one another
+	if err := orders.Put([]byte("order:1"), []byte("shipped")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	for name, kv := range map[string]*KVStore{"default": def, "system": system} {
+		if _, err := kv.Get([]byte("order:1")); err != ErrKeyNotFound {
+			t.Errorf("Expected the %s store not to see namespace keys, got %v", name, err)
+		}
+	}
+
+	// Reads of missing namespaces don't create them
+	if _, err := manager.Namespace(ctx, "missing", false); !errors.Is(err, ErrNamespaceNotFound) {
+		t.Errorf("Expected ErrNamespaceNotFound, got %v", err)
+	}
+	for _, name := range []string{"", "Orders", "../etc", "-x", "default", "system"} {
+		if _, err := manager.Namespace(ctx, name, true); !errors.Is(err, ErrInvalidNamespace) {
+			t.Errorf("Expected namespace %q to be rejected, got %v", name, err)
+		}
+	}
+	if _, err := manager.Namespace(ctx, "archive", true); err != nil {
+		t.Fatalf("Failed to open namespace: %v", err)
+	}
+
+	namespaces, err := manager.Namespaces()
+	if err != nil || len(namespaces) != 2 || namespaces[0] != "archive" || namespaces[1] != "orders" {
+		t.Errorf("Expected namespaces [archive orders], got %v, %v", namespaces, err)
+	}
+
+	stats := manager.Stats()
+	var names []string
+	for _, s := range stats {
+		names = append(names, s.Name)
+	}
+	if len(names) != 4 || names[0] != "default" || names[1] != "system" || names[2] != "archive" || names[3] != "orders" {
+		t.Errorf("Expected stats for [default system archive orders], got %v", names)
+	}
+	if stats[3].Stats.Keys != 1 || stats[3].Recovery == nil {
+		t.Errorf("Expected the orders store's stats and recovery, got %+v", stats[3])
+	}
+	if len(configured) != 4 {
+		t.Errorf("Expected Configure once per store, got %v", configured)
+	}
+
+	if err := manager.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	if _, err := orders.Get([]byte("order:1")); err == nil {
+		t.Error("Expected Close to close the namespace store")
+	}
+	if _, err := manager.Default(ctx); !errors.Is(err, ErrManagerClosed) {
+		t.Errorf("Expected ErrManagerClosed, got %v", err)
+	}
+
+	// Namespace data survives reopening
+	reopened, err := NewStoreManager(StoreManagerConfig{DataDir: dir})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer reopened.Close()
+	orders, err = reopened.Namespace(ctx, "orders", false)
+	if err != nil {
+		t.Fatalf("Failed to reopen namespace: %v", err)
+	}
+	if value, err := orders.Get([]byte("order:1")); err != nil || string(value) != "shipped" {
+		t.Errorf("Expected order:1 to survive a reopen, got %q, %v", value, err)
+	}
+}
@@ -3,8 +3,6 @@ package store
 import (
 	"context"
 	"fmt"
-	"os"
-	"path/filepath"
 	"testing"
 	"time"
 
@@ -13,241 +11,167 @@ import (
 )
 
 func TestNewStore(t *testing.T) {
-	tmpDir, err := os.MkdirTemp("", "store_test")
-	require.NoError(t, err)
-	defer os.RemoveAll(tmpDir)
+	tmpDir := t.TempDir()
 
 	store, err := NewStore(tmpDir)
 	require.NoError(t, err)
-	assert.NotNil(t, store)
-
-	// Verify data directory was created
-	assert.DirExists(t, tmpDir)
+	assert.IsType(t, &KVStore{}, store)
 
-	// Verify segment files were created
-	assert.FileExists(t, filepath.Join(tmpDir, "001.data"))
-	assert.FileExists(t, filepath.Join(tmpDir, "002.data"))
+	// The store is open and persists to dataDir
+	require.NoError(t, store.Put([]byte("user:1"), []byte("ada")))
+	require.NoError(t, store.Close())
 
-	// Test cleanup
-	err = store.Close()
-	assert.NoError(t, err)
+	store, err = NewStore(tmpDir)
+	require.NoError(t, err)
+	defer store.Close()
+	value, err := store.Get([]byte("user:1"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("ada"), value)
 }
 
 func TestStore_PutAndGet(t *testing.T) {
-	tmpDir, err := os.MkdirTemp("", "store_put_get_test")
-	require.NoError(t, err)
-	defer os.RemoveAll(tmpDir)
-
-	store, err := NewStore(tmpDir)
+	store, err := NewStore(t.TempDir())
 	require.NoError(t, err)
 	defer store.Close()
 
-	// Test Put
-	err = store.Put([]byte("test_key"), []byte("test_value"))
-	assert.NoError(t, err)
+	require.NoError(t, store.Put([]byte("test_key"), []byte("test_value")))
 
-	// Test Get
 	value, err := store.Get([]byte("test_key"))
 	assert.NoError(t, err)
-	assert.Equal(t, []byte("stub_value"), value) // StoreImpl returns stub values
+	assert.Equal(t, []byte("test_value"), value)
 
-	// Test Get non-existent key
 	_, err = store.Get([]byte("non_existent"))
-	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "key not found")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	require.NoError(t, store.Delete([]byte("test_key")))
+	_, err = store.Get([]byte("test_key"))
+	assert.ErrorIs(t, err, ErrKeyNotFound)
 }
 
-func TestStore_Explain(t *testing.T) {
-	tmpDir, err := os.MkdirTemp("", "store_explain_test")
+// newExplainStore opens a store holding two users with orders and one item
+func newExplainStore(t *testing.T) Store {
+	t.Helper()
+	store, err := NewStore(t.TempDir())
 	require.NoError(t, err)
-	defer os.RemoveAll(tmpDir)
+	t.Cleanup(func() { store.Close() })
 
-	store, err := NewStore(tmpDir)
-	require.NoError(t, err)
-	defer store.Close()
+	for _, key := range []string{
+		"user:1:order:1", "user:1:order:2", "user:2:order:1", "user:2:profile",
+		"item:1",
+	} {
+		require.NoError(t, store.Put([]byte(key), []byte("value of "+key)))
+	}
+	return store
+}
 
-	ctx := context.Background()
+func TestStore_Explain(t *testing.T) {
+	store := newExplainStore(t)
 
-	// Test basic explain
-	opts := ExplainOptions{}
-	result, err := store.Explain(ctx, opts)
+	result, err := store.Explain(context.Background(), ExplainOptions{})
 	require.NoError(t, err)
-	assert.NotNil(t, result)
 
-	// Verify global stats
-	assert.Greater(t, result.Global.TotalKeys, 0)
+	assert.Equal(t, 5, result.Global.ActiveKeys)
+	assert.Equal(t, 5, result.Global.UserKeys)
 	assert.Greater(t, result.Global.Uptime, time.Duration(0))
 	assert.Greater(t, result.Global.TotalSizeMB, 0.0)
 
-	// Verify segments
-	assert.Len(t, result.Segments, 2)
-	for _, seg := range result.Segments {
-		assert.Greater(t, seg.Keys, 0)
-		assert.Greater(t, seg.SizeMB, 0.0)
-	}
-
-	// Verify partitions
-	assert.Contains(t, result.Partitions, "User")
-	assert.Contains(t, result.Partitions, "Item")
-
-	userPartition := result.Partitions["User"]
-	assert.Greater(t, userPartition.Keys, 0)
-	assert.Equal(t, "1:N", userPartition.Cardinality)
+	require.Len(t, result.Segments, 1)
+	assert.Equal(t, "active", result.Segments[0].ID)
+	assert.Equal(t, 5, result.Segments[0].Keys)
+
+	// Partitions come from the keys actually stored
+	require.Contains(t, result.Partitions, "user")
+	require.Contains(t, result.Partitions, "item")
+	user := result.Partitions["user"]
+	assert.Equal(t, 4, user.Keys)
+	assert.Equal(t, "1:N", user.Cardinality)
+	require.NotEmpty(t, user.SKRanges)
+	assert.Equal(t, SKRange{Name: "1", Count: 2, Min: "user:1:order:1", Max: "user:1:order:2"}, user.SKRanges[0])
+	assert.Equal(t, "1:1", result.Partitions["item"].Cardinality)
 }
 
 func TestStore_ExplainWithSamples(t *testing.T) {
-	tmpDir, err := os.MkdirTemp("", "store_explain_samples_test")
-	require.NoError(t, err)
-	defer os.RemoveAll(tmpDir)
+	store := newExplainStore(t)
 
-	store, err := NewStore(tmpDir)
+	result, err := store.Explain(context.Background(), ExplainOptions{WithSamples: 2})
 	require.NoError(t, err)
-	defer store.Close()
 
-	ctx := context.Background()
-
-	// Test explain with samples
-	opts := ExplainOptions{
-		WithSamples: 2,
-	}
-	result, err := store.Explain(ctx, opts)
-	require.NoError(t, err)
-
-	// Should have samples
 	assert.Len(t, result.Diagnostics.Samples, 2)
 	for _, sample := range result.Diagnostics.Samples {
-		assert.NotEmpty(t, sample.Key)
-		assert.NotEmpty(t, sample.Value)
+		assert.Equal(t, "value of "+sample.Key, sample.Value)
 		assert.False(t, sample.Ts.IsZero())
 	}
 }
 
 func TestStore_ExplainWithMetrics(t *testing.T) {
-	tmpDir, err := os.MkdirTemp("", "store_explain_metrics_test")
-	require.NoError(t, err)
-	defer os.RemoveAll(tmpDir)
-
-	store, err := NewStore(tmpDir)
+	store := newExplainStore(t)
+	_, err := store.Get([]byte("item:1"))
 	require.NoError(t, err)
-	defer store.Close()
 
-	ctx := context.Background()
-
-	// Test explain with metrics
-	opts := ExplainOptions{
-		WithMetrics: true,
-	}
-	result, err := store.Explain(ctx, opts)
+	result, err := store.Explain(context.Background(), ExplainOptions{WithMetrics: true})
 	require.NoError(t, err)
 
-	// Should have metrics
 	assert.Greater(t, result.Diagnostics.Metrics.AvgGetLatencyMs, 0.0)
 	assert.Greater(t, result.Diagnostics.Metrics.IORateMBs, 0.0)
 }
 
 func TestStore_ExplainWithPKFilter(t *testing.T) {
-	tmpDir, err := os.MkdirTemp("", "store_explain_pk_test")
-	require.NoError(t, err)
-	defer os.RemoveAll(tmpDir)
-
-	store, err := NewStore(tmpDir)
-	require.NoError(t, err)
-	defer store.Close()
-
+	store := newExplainStore(t)
 	ctx := context.Background()
 
-	// Test explain with existing PK
-	opts := ExplainOptions{
-		PK: "User",
-	}
-	result, err := store.Explain(ctx, opts)
+	result, err := store.Explain(ctx, ExplainOptions{PK: "user"})
 	require.NoError(t, err)
 	assert.Empty(t, result.Warnings)
+	assert.Len(t, result.Partitions, 1)
 
-	// Test explain with non-existent PK
-	opts = ExplainOptions{
-		PK: "NonExistent",
-	}
-	result, err = store.Explain(ctx, opts)
+	result, err = store.Explain(ctx, ExplainOptions{PK: "NonExistent"})
 	require.NoError(t, err)
-	assert.Len(t, result.Warnings, 1)
+	require.Len(t, result.Warnings, 1)
 	assert.Contains(t, result.Warnings[0], "No data for PK: NonExistent")
 }
 
 func TestStore_CompactionDetection(t *testing.T) {
-	tmpDir, err := os.MkdirTemp("", "store_compaction_test")
-	require.NoError(t, err)
-	defer os.RemoveAll(tmpDir)
-
-	store, err := NewStore(tmpDir)
-	require.NoError(t, err)
-	defer store.Close()
-
+	store := newExplainStore(t)
 	ctx := context.Background()
 
-	// The store has segments with 15% and 10% dead percentage
-	// Only segments with >20% dead should be marked for compaction
-	opts := ExplainOptions{}
-	result, err := store.Explain(ctx, opts)
+	result, err := store.Explain(ctx, ExplainOptions{})
 	require.NoError(t, err)
-
-	// Should have no segments marked for compaction (15% and 10% < 20%)
 	assert.Empty(t, result.Diagnostics.CompactionReady)
-}
-
-func TestStoreImpl_MultiplePuts(t *testing.T) {
-	tmpDir, err := os.MkdirTemp("", "store_multiple_puts_test")
-	require.NoError(t, err)
-	defer os.RemoveAll(tmpDir)
-
-	store, err := NewStore(tmpDir)
-	require.NoError(t, err)
-	defer store.Close()
-
-	// Put multiple keys
-	keys := [][]byte{
-		[]byte("key1"),
-		[]byte("key2"),
-		[]byte("key3"),
-	}
-
-	for _, key := range keys {
-		err := store.Put(key, []byte("value"))
-		assert.NoError(t, err)
-	}
 
-	// All keys should be retrievable
-	for _, key := range keys {
-		value, err := store.Get(key)
-		assert.NoError(t, err)
-		assert.Equal(t, []byte("stub_value"), value)
+	// Overwriting every key leaves most of the log dead
+	for i := 0; i < 5; i++ {
+		for _, key := range []string{"user:1:order:1", "user:1:order:2", "user:2:order:1", "user:2:profile", "item:1"} {
+			require.NoError(t, store.Put([]byte(key), []byte("new value")))
+		}
 	}
+	result, err = store.Explain(ctx, ExplainOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"active"}, result.Diagnostics.CompactionReady)
 }
 
-func TestStoreImpl_KeyTracking(t *testing.T) {
-	tmpDir, err := os.MkdirTemp("", "store_key_tracking_test")
-	require.NoError(t, err)
-	defer os.RemoveAll(tmpDir)
-
-	store, err := NewStore(tmpDir)
+func TestStore_KeyTracking(t *testing.T) {
+	store, err := NewStore(t.TempDir())
 	require.NoError(t, err)
 	defer store.Close()
-
-	// Initial state should have keys from stub data
 	ctx := context.Background()
+
 	result, err := store.Explain(ctx, ExplainOptions{})
 	require.NoError(t, err)
+	assert.Equal(t, 0, result.Global.TotalKeys)
 
-	initialKeys := result.Global.TotalKeys
-
-	// Add a new key
-	err = store.Put([]byte("new_key"), []byte("new_value"))
-	assert.NoError(t, err)
+	require.NoError(t, store.Put([]byte("new_key"), []byte("new_value")))
+	require.NoError(t, store.Put([]byte("other_key"), []byte("value")))
+	require.NoError(t, store.Delete([]byte("other_key")))
 
-	// Key count should increase
 	result, err = store.Explain(ctx, ExplainOptions{})
 	require.NoError(t, err)
-	assert.Equal(t, initialKeys+1, result.Global.TotalKeys)
+	assert.Equal(t, 1, result.Global.ActiveKeys)
+	assert.Equal(t, 1, result.Global.Tombstones)
+
+	keys, err := store.ListKeys(nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"new_key"}, keys)
+	assert.Equal(t, 1, store.Stats().UserKeys)
 }
 
 func TestStore_ErrorHandling(t *testing.T) {
@@ -257,11 +181,7 @@ func TestStore_ErrorHandling(t *testing.T) {
 }
 
 func BenchmarkStore_Put(b *testing.B) {
-	tmpDir, err := os.MkdirTemp("", "store_bench_put")
-	require.NoError(b, err)
-	defer os.RemoveAll(tmpDir)
-
-	store, err := NewStore(tmpDir)
+	store, err := NewStore(b.TempDir())
 	require.NoError(b, err)
 	defer store.Close()
 
@@ -276,11 +196,7 @@ func BenchmarkStore_Put(b *testing.B) {
 }
 
 func BenchmarkStore_Get(b *testing.B) {
-	tmpDir, err := os.MkdirTemp("", "store_bench_get")
-	require.NoError(b, err)
-	defer os.RemoveAll(tmpDir)
-
-	store, err := NewStore(tmpDir)
+	store, err := NewStore(b.TempDir())
 	require.NoError(b, err)
 	defer store.Close()
 
@@ -299,11 +215,7 @@ func BenchmarkStore_Get(b *testing.B) {
 }
 
 func BenchmarkStore_Explain(b *testing.B) {
-	tmpDir, err := os.MkdirTemp("", "store_bench_explain")
-	require.NoError(b, err)
-	defer os.RemoveAll(tmpDir)
-
-	store, err := NewStore(tmpDir)
+	store, err := NewStore(b.TempDir())
 	require.NoError(b, err)
 	defer store.Close()
 
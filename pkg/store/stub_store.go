@@ -0,0 +1,145 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// StoreImpl is an in-memory stand-in for the store that fabricates its
+// values and explain output.
+//
+// Deprecated: StoreImpl predates KVStore and reports nothing about real
+// data. Use NewStore or NewKVStore, both of which return the real engine.
+type StoreImpl struct {
+	dataDir   string
+	startTime time.Time
+	keys      int
+	keysMap   map[string]struct{}
+}
+
+// NewStubStore creates a StoreImpl seeded with fabricated keys, and empty
+// placeholder segment files in dataDir.
+//
+// Deprecated: use NewStore, which opens a real KVStore.
+func NewStubStore(dataDir string) (*StoreImpl, error) {
+	s := &StoreImpl{
+		dataDir:   dataDir,
+		startTime: time.Now(),
+		keys:      0,
+		keysMap:   make(map[string]struct{}),
+	}
+
+	// Stub data
+	for i := 1; i <= 1250; i++ {
+		key := fmt.Sprintf("key:%d", i)
+		s.keysMap[key] = struct{}{}
+		if i%10 == 0 {
+			delete(s.keysMap, key)
+		}
+	}
+	s.keys = len(s.keysMap)
+
+	// Create dummy segments
+	if _, err := os.Stat(filepath.Join(dataDir, "001.data")); os.IsNotExist(err) {
+		if err := os.MkdirAll(dataDir, 0750); err != nil {
+			return nil, err
+		}
+		for _, seg := range []string{"001.data", "002.data"} {
+			// Validate segment name to prevent path traversal
+			if seg != "001.data" && seg != "002.data" {
+				return nil, fmt.Errorf("invalid segment name: %s", seg)
+			}
+			f, err := os.Create(filepath.Join(dataDir, seg))
+			if err != nil {
+				return nil, err
+			}
+			f.Close()
+		}
+	}
+
+	return s, nil
+}
+
+// Explain returns fixed, made-up statistics
+func (s *StoreImpl) Explain(ctx context.Context, opts ExplainOptions) (*ExplainResult, error) {
+	res := NewExplainResult(ctx)
+	res.Global.TotalKeys = s.keys
+	res.Global.ActiveKeys = s.keys * 9 / 10
+	res.Global.Tombstones = s.keys / 10
+	res.Global.UserKeys = res.Global.ActiveKeys
+	res.Global.TotalSizeMB = 5.2
+	res.Global.LiveSizeMB = 4.1
+	res.Global.Uptime = time.Since(s.startTime)
+
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	res.Global.IndexMemoryMB = float64(m.Alloc) / (1024 * 1024)
+
+	res.Segments = []Segment{
+		{ID: "001", Keys: 600, DeadPct: 10.0, SizeMB: 2.1},
+		{ID: "002", Keys: 650, DeadPct: 15.0, SizeMB: 3.1},
+	}
+
+	for _, seg := range res.Segments {
+		if seg.DeadPct > 20.0 {
+			res.Diagnostics.CompactionReady = append(res.Diagnostics.CompactionReady, seg.ID)
+		}
+	}
+
+	res.Partitions = map[string]PKStats{
+		"User": {Keys: 800, SKRanges: []SKRange{{Name: "Location", Count: 500,
+			Min: "loc:1", Max: "loc:750"}}, Cardinality: "1:N"},
+		"Item": {Keys: 450, SKRanges: []SKRange{{Name: "Category", Count: 250,
+			Min: "cat:1", Max: "cat:300"}}, Cardinality: "N:1"},
+	}
+
+	if opts.WithSamples > 0 {
+		samples := []Sample{
+			{Key: "user:123", Value: "john_doe@example.com", Ts: time.Now().Add(-time.Hour)},
+			{Key: "item:456", Value: "laptop", Ts: time.Now().Add(-2 * time.Hour)},
+		}
+		if opts.WithSamples < len(samples) {
+			samples = samples[:opts.WithSamples]
+		}
+		res.Diagnostics.Samples = samples
+	}
+
+	if opts.PK != "" {
+		if pkStats, exists := res.Partitions[opts.PK]; !exists || pkStats.Keys == 0 {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("No data for PK: %s", opts.PK))
+		}
+	}
+
+	res.Diagnostics.CRCErrors = 0
+
+	if opts.WithMetrics {
+		res.Diagnostics.Metrics.AvgGetLatencyMs = 1.2
+		res.Diagnostics.Metrics.IORateMBs = 10.5
+	}
+
+	return res, nil
+}
+
+// Put records key; the value is discarded
+func (s *StoreImpl) Put(key, value []byte) error {
+	s.keysMap[string(key)] = struct{}{}
+	s.keys = len(s.keysMap)
+	return nil
+}
+
+// Get returns "stub_value" for any key that was put
+func (s *StoreImpl) Get(key []byte) ([]byte, error) {
+	if _, exists := s.keysMap[string(key)]; !exists {
+		return nil, fmt.Errorf("key not found")
+	}
+	return []byte("stub_value"), nil
+}
+
+// Close does nothing
+func (s *StoreImpl) Close() error {
+	return nil
+}
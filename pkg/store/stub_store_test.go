@@ -0,0 +1,26 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStubStore(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	stub, err := NewStubStore(tmpDir)
+	require.NoError(t, err)
+	defer stub.Close()
+	assert.FileExists(t, filepath.Join(tmpDir, "001.data"))
+
+	require.NoError(t, stub.Put([]byte("test_key"), []byte("test_value")))
+	value, err := stub.Get([]byte("test_key"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("stub_value"), value)
+
+	_, err = stub.Get([]byte("non_existent"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,338 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/codec"
+)
+
+// activeFileID is the FileID of the segment currently being written
+const activeFileID uint32 = 0
+
+// SegmentTier describes where a segment lives and when it was last used
+type SegmentTier struct {
+	FileID     uint32
+	Path       string
+	Archived   bool
+	LastAccess time.Time
+}
+
+// segmentTable maps FileIDs to their current location so index entries stay
+// valid when a segment is moved between tiers
+type segmentTable struct {
+	segments map[uint32]*SegmentTier
+	mutex    sync.RWMutex
+}
+
+// newSegmentTable creates a table holding only the active segment
+func newSegmentTable(activePath string) *segmentTable {
+	return &segmentTable{
+		segments: map[uint32]*SegmentTier{
+			activeFileID: {FileID: activeFileID, Path: activePath, LastAccess: time.Now()},
+		},
+	}
+}
+
+// add registers a sealed segment
+func (t *segmentTable) add(fileID uint32, path string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.segments[fileID] = &SegmentTier{FileID: fileID, Path: path, LastAccess: time.Now()}
+}
+
+// remove drops a sealed segment, as when compaction has replaced it
+func (t *segmentTable) remove(fileID uint32) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if fileID != activeFileID {
+		delete(t.segments, fileID)
+	}
+}
+
+// touch records an access and returns the segment's current path
+func (t *segmentTable) touch(fileID uint32) (string, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	seg, ok := t.segments[fileID]
+	if !ok {
+		return "", false
+	}
+	seg.LastAccess = time.Now()
+	return seg.Path, true
+}
+
+// list returns a copy of every segment sorted by FileID
+func (t *segmentTable) list() []SegmentTier {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	out := make([]SegmentTier, 0, len(t.segments))
+	for _, seg := range t.segments {
+		out = append(out, *seg)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].FileID < out[j].FileID })
+	return out
+}
+
+// readRecordInternal reads the record an index entry points at, wherever its
+// segment currently lives. Safe to call without the store mutex.
+func (kv *KVStore) readRecordInternal(entry *IndexEntry) (*codec.Record, error) {
+	return kv.readRecordFromInternal(entry, nil)
+}
+
+// segmentFiles keeps one handle per segment open across a batch of reads,
+// so a batch opens each segment once instead of once per record. Handles
+// are opened when first needed, so they see every write made before then.
+type segmentFiles struct {
+	files map[uint32]*os.File
+	codec *codec.RecordCodec
+}
+
+// newSegmentFiles creates an empty handle cache
+func (kv *KVStore) newSegmentFiles() *segmentFiles {
+	return &segmentFiles{files: make(map[uint32]*os.File), codec: kv.codec}
+}
+
+// close releases every handle opened by the batch
+func (f *segmentFiles) close() {
+	for _, file := range f.files {
+		_ = file.Close()
+	}
+}
+
+// readRecordFromInternal is readRecordInternal reading through files when
+// it is not nil
+func (kv *KVStore) readRecordFromInternal(entry *IndexEntry, files *segmentFiles) (*codec.Record, error) {
+	path, ok := kv.segments.touch(entry.FileID)
+	if !ok {
+		return nil, fmt.Errorf("unknown segment %d", entry.FileID)
+	}
+
+	if files != nil {
+		file, open := files.files[entry.FileID]
+		if !open {
+			var err error
+			if file, err = kv.openSegment(entry.FileID, path); err != nil {
+				return nil, err
+			}
+			files.files[entry.FileID] = file
+		}
+		return readRecordAt(file, entry.Offset, files.codec)
+	}
+
+	if entry.FileID == activeFileID {
+		return kv.reader.ReadAt(entry.Offset)
+	}
+
+	// Sealed segments are immutable, so a short-lived reader is enough
+	file, err := kv.openSegment(entry.FileID, path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return readRecordAt(file, entry.Offset, kv.codec)
+}
+
+// openSegment opens the segment at path. Readers that don't hold the store
+// mutex may look a path up just before ArchiveColdSegments moves the
+// segment, so a missing file is looked up again and opened at its new path.
+func (kv *KVStore) openSegment(fileID uint32, path string) (*os.File, error) {
+	for {
+		file, err := os.Open(path) //nolint:gosec // Segment path from the store's own table
+		if err == nil || !os.IsNotExist(err) {
+			return file, err
+		}
+		current, ok := kv.segments.touch(fileID)
+		if !ok || current == path {
+			return nil, err
+		}
+		path = current
+	}
+}
+
+// dataSizeInternal returns the bytes held by every segment, sealed segments
+// whose files can't be read aside (caller must hold the mutex)
+func (kv *KVStore) dataSizeInternal() int64 {
+	size := kv.writer.Size()
+	for _, seg := range kv.segments.list() {
+		if seg.FileID == activeFileID {
+			continue
+		}
+		if info, err := os.Stat(seg.Path); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// SegmentTiers reports the location and last access time of every segment
+func (kv *KVStore) SegmentTiers() []SegmentTier {
+	return kv.segments.list()
+}
+
+// ArchiveColdSegments moves sealed segments that have not been read for
+// ArchiveAfter into ArchiveDir. Index entries keep pointing at the same
+// FileID, so later reads transparently follow the segment to its new home.
+// It returns the FileIDs that were moved. The active segment is never moved,
+// and archiving waits for any outstanding Freeze.
+//
+// Each segment is linked or copied into the archive first and its path
+// switched under the store mutex, so no read can find it gone. The old
+// files are removed once the manifest lists the archived ones. A store
+// closed meanwhile stops the archive before the next switch, leaving the
+// manifest and the segments as they were.
+func (kv *KVStore) ArchiveColdSegments() ([]uint32, error) {
+	if kv.config.ArchiveDir == "" || kv.config.ArchiveAfter <= 0 {
+		return nil, nil
+	}
+	if kv.IsStandby() {
+		return nil, ErrReadOnly
+	}
+
+	kv.maintenance.Lock()
+	defer kv.maintenance.Unlock()
+
+	kv.mutex.Lock()
+	err := kv.checkOpenInternal()
+	kv.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(kv.config.ArchiveDir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	cutoff := time.Now().Add(-kv.config.ArchiveAfter)
+	var moved []uint32
+	var sources []string // Original path of each moved segment
+
+	for _, seg := range kv.segments.list() {
+		if seg.FileID == activeFileID || seg.Archived || seg.LastAccess.After(cutoff) {
+			continue
+		}
+
+		target := filepath.Join(kv.config.ArchiveDir, filepath.Base(seg.Path))
+		if err := linkOrCopyFile(seg.Path, target); err != nil {
+			return moved, fmt.Errorf("failed to archive segment %d: %w", seg.FileID, err)
+		}
+
+		kv.mutex.Lock()
+		if err := kv.checkOpenInternal(); err != nil {
+			kv.mutex.Unlock()
+			_ = os.Remove(target) // Unlisted, so never read
+			return moved, err
+		}
+		kv.segments.mutex.Lock()
+		if live, ok := kv.segments.segments[seg.FileID]; ok {
+			live.Path = target
+			live.Archived = true
+		}
+		kv.segments.mutex.Unlock()
+		kv.mutex.Unlock()
+
+		moved = append(moved, seg.FileID)
+		sources = append(sources, seg.Path)
+	}
+
+	// The manifest names the archived copies before the originals go, so
+	// after a crash it always lists files that exist
+	if len(moved) > 0 {
+		kv.mutex.Lock()
+		err := kv.checkOpenInternal()
+		if err == nil {
+			err = kv.saveManifest()
+		}
+		kv.mutex.Unlock()
+		if err != nil {
+			return moved, err
+		}
+	}
+	for i, src := range sources {
+		if err := removeFile(src); err != nil {
+			return moved, fmt.Errorf("failed to remove archived segment %d: %w", moved[i], err)
+		}
+	}
+	return moved, nil
+}
+
+// startArchiverInternal runs ArchiveColdSegments periodically while the
+// store is open (caller must hold the mutex)
+func (kv *KVStore) startArchiverInternal() {
+	if kv.config.ArchiveDir == "" || kv.config.ArchiveAfter <= 0 || kv.archiveStop != nil {
+		return
+	}
+
+	interval := kv.config.ArchiveAfter / 4
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+
+	stop := make(chan struct{})
+	kv.archiveStop = stop
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := kv.ArchiveColdSegments(); err != nil {
+					fmt.Fprintf(os.Stderr, "Error archiving segments: %v\n", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// stopArchiverInternal stops the background archiver (caller must hold the mutex)
+func (kv *KVStore) stopArchiverInternal() {
+	if kv.archiveStop != nil {
+		close(kv.archiveStop)
+		kv.archiveStop = nil
+	}
+}
+
+// linkOrCopyFile makes dst a durable copy of src, leaving src in place: a
+// hard link when both are on one filesystem, else a finalized copy. A stale
+// dst left by an earlier attempt is replaced.
+func linkOrCopyFile(src, dst string) error {
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(src, dst); err == nil {
+		return syncDir(filepath.Dir(dst))
+	}
+
+	in, err := os.Open(filepath.Clean(src))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return finalizeFile(dst, func(w io.Writer) error {
+		_, err := io.Copy(w, in)
+		return err
+	})
+}
+
+// removeFile removes path and fsyncs its directory
+func removeFile(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
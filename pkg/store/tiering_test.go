@@ -0,0 +1,318 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ssargent/freyjadb/pkg/codec"
+)
+
+func TestKVStore_ArchiveColdSegments(t *testing.T) {
+	tmpDir := t.TempDir()
+	archiveDir := filepath.Join(t.TempDir(), "archive")
+
+	store, err := NewKVStore(KVStoreConfig{
+		DataDir:      tmpDir,
+		ArchiveDir:   archiveDir,
+		ArchiveAfter: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	// Build a sealed segment holding one record and register it as FileID 1
+	sealedPath := filepath.Join(tmpDir, "000001.data")
+	writer, err := NewLogWriter(LogWriterConfig{FilePath: sealedPath})
+	if err != nil {
+		t.Fatalf("Failed to create segment writer: %v", err)
+	}
+	offset, err := writer.Put([]byte("cold:1"), []byte("frozen"))
+	if err != nil {
+		t.Fatalf("Failed to write segment: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close segment writer: %v", err)
+	}
+
+	store.segments.add(1, sealedPath)
+	store.index.Put([]byte("cold:1"), &IndexEntry{
+		FileID: 1,
+		Offset: offset,
+		Size:   uint32(codec.NewRecord([]byte("cold:1"), []byte("frozen")).Size()),
+	})
+
+	// Recently used segments stay put
+	moved, err := store.ArchiveColdSegments()
+	if err != nil || len(moved) != 0 {
+		t.Fatalf("Expected nothing archived, got %v, %v", moved, err)
+	}
+
+	// Age the segment past the policy threshold
+	store.segments.mutex.Lock()
+	store.segments.segments[1].LastAccess = time.Now().Add(-2 * time.Hour)
+	store.segments.mutex.Unlock()
+
+	moved, err = store.ArchiveColdSegments()
+	if err != nil {
+		t.Fatalf("ArchiveColdSegments failed: %v", err)
+	}
+	if len(moved) != 1 || moved[0] != 1 {
+		t.Fatalf("Expected segment 1 to be archived, got %v", moved)
+	}
+
+	if _, err := os.Stat(sealedPath); !os.IsNotExist(err) {
+		t.Error("Expected segment to be removed from the data directory")
+	}
+	if _, err := os.Stat(filepath.Join(archiveDir, "000001.data")); err != nil {
+		t.Errorf("Expected segment in archive directory: %v", err)
+	}
+
+	// Reads follow the segment to the archive
+	value, err := store.Get([]byte("cold:1"))
+	if err != nil || string(value) != "frozen" {
+		t.Fatalf("Expected transparent read from archive, got %q, %v", value, err)
+	}
+
+	tiers := store.SegmentTiers()
+	if len(tiers) != 2 || tiers[0].Archived || !tiers[1].Archived {
+		t.Errorf("Unexpected segment tiers: %+v", tiers)
+	}
+
+	// The active segment is never archived
+	store.segments.mutex.Lock()
+	store.segments.segments[activeFileID].LastAccess = time.Now().Add(-2 * time.Hour)
+	store.segments.mutex.Unlock()
+	if moved, _ := store.ArchiveColdSegments(); len(moved) != 0 {
+		t.Errorf("Expected active segment to stay, got %v", moved)
+	}
+}
+
+func TestKVStore_ArchiveWhileReading(t *testing.T) {
+	tmpDir := t.TempDir()
+	archiveDir := filepath.Join(t.TempDir(), "archive")
+	// Segments the readers touched are still cold to a nanosecond's cutoff
+	store, err := NewKVStore(KVStoreConfig{DataDir: tmpDir, ArchiveDir: archiveDir, ArchiveAfter: time.Nanosecond})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	// Many cold segments of one record each
+	const segments = 20
+	keys := make([][]byte, segments)
+	for i := 0; i < segments; i++ {
+		fileID := uint32(i + 1)
+		keys[i] = []byte(fmt.Sprintf("cold:%02d", i))
+		path := filepath.Join(tmpDir, fmt.Sprintf("%06d.data", fileID))
+		writer, err := NewLogWriter(LogWriterConfig{FilePath: path})
+		if err != nil {
+			t.Fatalf("Failed to create segment writer: %v", err)
+		}
+		offset, err := writer.Put(keys[i], []byte("frozen"))
+		if err != nil {
+			t.Fatalf("Failed to write segment: %v", err)
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatalf("Failed to close segment writer: %v", err)
+		}
+		store.segments.add(fileID, path)
+		store.index.Put(keys[i], &IndexEntry{
+			FileID: fileID,
+			Offset: offset,
+			Size:   uint32(codec.NewRecord(keys[i], []byte("frozen")).Size()),
+		})
+	}
+
+	// Point reads, batches and scans run throughout the archive
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	read := func(name string, fn func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := fn(); err != nil {
+					t.Errorf("%s during archive: %v", name, err)
+					return
+				}
+			}
+		}()
+	}
+	read("Get", func() error {
+		for _, key := range keys {
+			if _, err := store.Get(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	read("GetMany", func() error {
+		_, err := store.GetMany(keys)
+		return err
+	})
+	read("ScanPrefix", func() error {
+		it, err := store.ScanPrefix([]byte("cold:"))
+		if err != nil {
+			return err
+		}
+		defer it.Close()
+		count := 0
+		for it.Next() {
+			count++
+		}
+		if it.Err() == nil && count != segments {
+			return fmt.Errorf("scanned %d of %d keys", count, segments)
+		}
+		return it.Err()
+	})
+
+	moved, err := store.ArchiveColdSegments()
+	close(stop)
+	wg.Wait()
+	if err != nil || len(moved) != segments {
+		t.Fatalf("Expected every segment archived, got %v, %v", moved, err)
+	}
+	for _, key := range keys {
+		if value, err := store.Get(key); err != nil || string(value) != "frozen" {
+			t.Errorf("Expected %s read from the archive, got %q, %v", key, value, err)
+		}
+	}
+}
+
+func TestKVStore_ArchiveAfterClose(t *testing.T) {
+	tmpDir := t.TempDir()
+	archiveDir := filepath.Join(t.TempDir(), "archive")
+	open := func() *KVStore {
+		t.Helper()
+		store, err := NewKVStore(KVStoreConfig{DataDir: tmpDir, ArchiveDir: archiveDir, ArchiveAfter: time.Nanosecond})
+		if err != nil {
+			t.Fatalf("Failed to create KV store: %v", err)
+		}
+		if _, err := store.Open(); err != nil {
+			t.Fatalf("Failed to open KV store: %v", err)
+		}
+		return store
+	}
+
+	store := open()
+	if err := store.Put([]byte("cold:1"), []byte("frozen")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := store.Rotate(); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	segment := filepath.Join(tmpDir, segmentFileName(1))
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	// A closed store archives nothing, even with every segment cold
+	if moved, err := store.ArchiveColdSegments(); err == nil || len(moved) != 0 {
+		t.Fatalf("Expected archiving a closed store to fail, got %v, %v", moved, err)
+	}
+	if _, err := os.Stat(segment); err != nil {
+		t.Errorf("Expected the segment left in place: %v", err)
+	}
+	if entries, _ := os.ReadDir(archiveDir); len(entries) != 0 {
+		t.Errorf("Expected nothing in the archive, got %d files", len(entries))
+	}
+
+	store = open()
+	defer store.Close()
+	for _, tier := range store.SegmentTiers() {
+		if tier.Archived {
+			t.Errorf("Expected segment %d unarchived after reopening", tier.FileID)
+		}
+	}
+	if value, err := store.Get([]byte("cold:1")); err != nil || string(value) != "frozen" {
+		t.Errorf("Expected cold:1=frozen, got %q, %v", value, err)
+	}
+}
+
+func TestKVStore_ArchiveCrashRecovery(t *testing.T) {
+	dataDir := t.TempDir()
+	archiveDir := filepath.Join(t.TempDir(), "archive")
+	config := KVStoreConfig{DataDir: dataDir, ArchiveDir: archiveDir, ArchiveAfter: time.Hour}
+	open := func() *KVStore {
+		t.Helper()
+		store, err := NewKVStore(config)
+		if err != nil {
+			t.Fatalf("Failed to create KV store: %v", err)
+		}
+		if _, err := store.Open(); err != nil {
+			t.Fatalf("Failed to open KV store: %v", err)
+		}
+		return store
+	}
+
+	store := open()
+	if err := store.Put([]byte("cold:1"), []byte("frozen")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := store.Rotate(); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	segment := segmentFileName(1)
+	if _, err := os.Stat(filepath.Join(dataDir, segment)); err != nil {
+		t.Fatalf("Expected a sealed segment: %v", err)
+	}
+
+	// A crash after the segment reached the archive but before the manifest
+	// named it there: the store still opens, from the archived copy
+	if err := os.MkdirAll(archiveDir, 0750); err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	if err := os.Rename(filepath.Join(dataDir, segment), filepath.Join(archiveDir, segment)); err != nil {
+		t.Fatalf("Failed to move segment: %v", err)
+	}
+	store = open()
+	if value, err := store.Get([]byte("cold:1")); err != nil || string(value) != "frozen" {
+		t.Errorf("Expected the archived record, got %q, %v", value, err)
+	}
+	manifest := store.Manifest()
+	for _, seg := range manifest.Segments {
+		if seg.FileID == 1 && (!seg.Archived || filepath.Base(seg.Path) != segment || filepath.Dir(seg.Path) == dataDir) {
+			t.Errorf("Expected the manifest rewritten with the archived path, got %+v", seg)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	// A crash after the manifest named the archived copy but before the
+	// original was removed: the leftover is removed on open
+	data, err := os.ReadFile(filepath.Join(archiveDir, segment))
+	if err != nil {
+		t.Fatalf("Failed to read archived segment: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, segment), data, 0600); err != nil {
+		t.Fatalf("Failed to restore leftover: %v", err)
+	}
+	store = open()
+	defer store.Close()
+	if _, err := os.Stat(filepath.Join(dataDir, segment)); !os.IsNotExist(err) {
+		t.Errorf("Expected the leftover removed, got %v", err)
+	}
+	if value, err := store.Get([]byte("cold:1")); err != nil || string(value) != "frozen" {
+		t.Errorf("Expected the archived record, got %q, %v", value, err)
+	}
+}
@@ -0,0 +1,155 @@
+package store
+
+import "time"
+
+// ErrInvalidTTL is returned by PutWithTTL for a TTL that isn't positive
+var ErrInvalidTTL = &KVError{"TTL must be positive"}
+
+// PutWithTTL stores a key-value pair that expires after ttl. Once expired the
+// key reads as absent from Get, GetMany, scans and snapshots, and the next
+// compaction drops its record. Until then it still counts towards the
+// index's key count. A later Put, or PutWithTTL with a new TTL, replaces the
+// expiry along with the value.
+func (kv *KVStore) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	expiresAt := uint64(time.Now().Add(ttl).UnixNano()) //nolint:gosec // nanosecond timestamps are positive
+	return kv.commit(func() error { return kv.putExpiringInternal(key, value, expiresAt) })
+}
+
+// expired reports whether an expiry, in Unix nanoseconds, is at or before
+// now; 0 never expires
+func expired(expiresAt uint64, now time.Time) bool {
+	return expiresAt != 0 && expiresAt <= uint64(now.UnixNano()) //nolint:gosec // nanosecond timestamps are positive
+}
+
+// expired reports whether the entry's record has expired by now
+func (e *IndexEntry) expired(now time.Time) bool {
+	return expired(e.ExpiresAt, now)
+}
+
+// unexpiredKeys drops the keys whose records have expired, in place
+func (kv *KVStore) unexpiredKeys(keys []string) []string {
+	now := time.Now()
+	live := keys[:0]
+	for _, key := range keys {
+		if entry, ok := kv.index.Get([]byte(key)); ok && !entry.expired(now) {
+			live = append(live, key)
+		}
+	}
+	return live
+}
+
+// expiresAt returns when key's record expires, in Unix nanoseconds, or 0 if
+// it never does or doesn't exist
+func (kv *KVStore) expiresAt(key []byte) uint64 {
+	if entry, ok := kv.index.Get(key); ok {
+		return entry.ExpiresAt
+	}
+	return 0
+}
+
+// KeyExpiry returns when key expires, or the zero time if it never does. A
+// missing or already expired key returns ErrKeyNotFound.
+func (kv *KVStore) KeyExpiry(key []byte) (time.Time, error) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if err := kv.checkOpenInternal(); err != nil {
+		return time.Time{}, err
+	}
+	entry, ok := kv.index.Get(key)
+	if !ok || entry.expired(time.Now()) {
+		return time.Time{}, ErrKeyNotFound
+	}
+	if entry.ExpiresAt == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, int64(entry.ExpiresAt)).UTC(), nil //nolint:gosec // nanosecond timestamps fit in int64
+}
+
+// expiryWindows bound the buckets of an ExpiryHistogram; keys expiring
+// after the last one go in a final "later" bucket
+var expiryWindows = []struct {
+	label  string
+	within time.Duration
+}{
+	{"1m", time.Minute},
+	{"1h", time.Hour},
+	{"1d", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+// ExpiryHistogram counts the keys with a TTL by how soon they expire, so
+// operators can predict the churn expirations cause and the compaction work
+// they leave behind
+type ExpiryHistogram struct {
+	Expiring  int            `json:"expiring"`   // Keys with a TTL that haven't expired yet
+	Expired   int            `json:"expired"`    // Keys that have expired but not yet been compacted away
+	ExpiredMB float64        `json:"expired_mb"` // Record bytes the next compaction reclaims from Expired
+	Buckets   []ExpiryBucket `json:"buckets"`
+}
+
+// ExpiryBucket holds the keys expiring after the previous bucket's window
+// and within this one's
+type ExpiryBucket struct {
+	Within string  `json:"within"` // Such as "1h"; "later" for the last bucket
+	Keys   int     `json:"keys"`
+	SizeMB float64 `json:"size_mb"` // Record bytes, reclaimable once they expire
+}
+
+// ExpiryHistogram buckets the keys with a TTL by time until they expire
+func (kv *KVStore) ExpiryHistogram() (*ExpiryHistogram, error) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if err := kv.checkOpenInternal(); err != nil {
+		return nil, err
+	}
+	return kv.index.expiryHistogram(time.Now()), nil
+}
+
+// expiryHistogram walks every entry with an expiry, bucketing it by its
+// time until expiry from now
+func (idx *HashIndex) expiryHistogram(now time.Time) *ExpiryHistogram {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	keys := make([]int, len(expiryWindows)+1)
+	sizes := make([]int64, len(expiryWindows)+1)
+	var expiredBytes int64
+	hist := &ExpiryHistogram{}
+	for _, bucket := range idx.entries {
+		for _, entry := range bucket {
+			if entry.ExpiresAt == 0 {
+				continue
+			}
+			if entry.expired(now) {
+				hist.Expired++
+				expiredBytes += int64(entry.Size)
+				continue
+			}
+
+			hist.Expiring++
+			remaining := time.Duration(entry.ExpiresAt - uint64(now.UnixNano())) //nolint:gosec // unexpired, so after now
+			i := 0
+			for i < len(expiryWindows) && remaining > expiryWindows[i].within {
+				i++
+			}
+			keys[i]++
+			sizes[i] += int64(entry.Size)
+		}
+	}
+
+	hist.ExpiredMB = toMB(expiredBytes)
+	for i := range keys {
+		label := "later"
+		if i < len(expiryWindows) {
+			label = expiryWindows[i].label
+		}
+		hist.Buckets = append(hist.Buckets, ExpiryBucket{Within: label, Keys: keys[i], SizeMB: toMB(sizes[i])})
+	}
+	return hist
+}
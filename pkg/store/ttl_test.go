@@ -0,0 +1,243 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKVStore_PutWithTTL(t *testing.T) {
+	for _, mode := range []WriteMode{WriteModeAppend, WriteModeMemtable} {
+		t.Run(string(mode)+"mode", func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := NewKVStore(KVStoreConfig{DataDir: dir, WriteMode: mode})
+			if err != nil {
+				t.Fatalf("Failed to create KV store: %v", err)
+			}
+			if _, err := store.Open(); err != nil {
+				t.Fatalf("Failed to open KV store: %v", err)
+			}
+			defer store.Close()
+
+			if err := store.PutWithTTL([]byte("user:0"), []byte("v"), 0); err != ErrInvalidTTL {
+				t.Errorf("Expected a zero TTL to be rejected, got %v", err)
+			}
+			for _, key := range []string{"user:1", "user:2"} {
+				if err := store.PutWithTTL([]byte(key), []byte("short"), 50*time.Millisecond); err != nil {
+					t.Fatalf("Failed to put with TTL: %v", err)
+				}
+			}
+			if err := store.PutWithTTL([]byte("user:3"), []byte("long"), time.Hour); err != nil {
+				t.Fatalf("Failed to put with TTL: %v", err)
+			}
+			if err := store.Put([]byte("user:4"), []byte("forever")); err != nil {
+				t.Fatalf("Failed to put: %v", err)
+			}
+			// A plain Put clears an earlier expiry
+			if err := store.Put([]byte("user:2"), []byte("kept")); err != nil {
+				t.Fatalf("Failed to put: %v", err)
+			}
+
+			if value, err := store.Get([]byte("user:1")); err != nil || string(value) != "short" {
+				t.Fatalf("Expected user:1 before it expires, got %q, %v", value, err)
+			}
+			time.Sleep(60 * time.Millisecond)
+
+			if _, err := store.Get([]byte("user:1")); err != ErrKeyNotFound {
+				t.Errorf("Expected user:1 to have expired, got %v", err)
+			}
+			values, err := store.GetMany([][]byte{[]byte("user:1"), []byte("user:3")})
+			if err != nil || values[0] != nil || string(values[1]) != "long" {
+				t.Errorf("Expected GetMany to skip the expired key, got %q, %v", values, err)
+			}
+			if got := ttlScan(t, store); got != "user:2=kept,user:3=long,user:4=forever" {
+				t.Errorf("Expected the scan to skip the expired key, got %s", got)
+			}
+			keys, err := store.ListKeys([]byte("user:"))
+			sort.Strings(keys)
+			if err != nil || strings.Join(keys, ",") != "user:2,user:3,user:4" {
+				t.Errorf("Expected ListKeys to skip the expired key, got %v, %v", keys, err)
+			}
+			snap, err := store.Snapshot()
+			if err != nil {
+				t.Fatalf("Failed to take snapshot: %v", err)
+			}
+			if snap.Len() != 3 {
+				t.Errorf("Expected 3 keys in the snapshot, got %d", snap.Len())
+			}
+			snap.Close()
+
+			// Expiries survive a restart
+			if err := store.Close(); err != nil {
+				t.Fatalf("Failed to close: %v", err)
+			}
+			store, err = NewKVStore(KVStoreConfig{DataDir: dir, WriteMode: mode})
+			if err != nil {
+				t.Fatalf("Failed to create KV store: %v", err)
+			}
+			if _, err := store.Open(); err != nil {
+				t.Fatalf("Failed to reopen KV store: %v", err)
+			}
+			if _, err := store.Get([]byte("user:1")); err != ErrKeyNotFound {
+				t.Errorf("Expected user:1 to stay expired after reopening, got %v", err)
+			}
+			if value, err := store.Get([]byte("user:3")); err != nil || string(value) != "long" {
+				t.Errorf("Expected user:3 after reopening, got %q, %v", value, err)
+			}
+		})
+	}
+}
+
+func TestKVStore_CompactReclaimsExpired(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewKVStore(KVStoreConfig{DataDir: dir})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	// An older version without a TTL must not come back once the newer one
+	// expires and is compacted away
+	if err := store.Put([]byte("session"), []byte("old")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := store.PutWithTTL([]byte("session"), []byte("new"), 20*time.Millisecond); err != nil {
+		t.Fatalf("Failed to put with TTL: %v", err)
+	}
+	if err := store.PutWithTTL([]byte("cache"), []byte("warm"), time.Hour); err != nil {
+		t.Fatalf("Failed to put with TTL: %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	result, err := store.Compact(CompactOptions{})
+	if err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	if result.LiveKeys != 1 || result.ExpiredKeys != 1 {
+		t.Errorf("Expected 1 live and 1 expired key, got %d and %d", result.LiveKeys, result.ExpiredKeys)
+	}
+	if store.index.Size() != 1 {
+		t.Errorf("Expected the expired key to leave the index, got %d keys", store.index.Size())
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	store, err = NewKVStore(KVStoreConfig{DataDir: dir})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to reopen KV store: %v", err)
+	}
+	if _, err := store.Get([]byte("session")); err != ErrKeyNotFound {
+		t.Errorf("Expected the expired session to stay gone, got %v", err)
+	}
+	if value, err := store.Get([]byte("cache")); err != nil || string(value) != "warm" {
+		t.Errorf("Expected the unexpired key to keep its TTL through compaction, got %q, %v", value, err)
+	}
+	if entry, _ := store.index.Get([]byte("cache")); entry.ExpiresAt == 0 {
+		t.Error("Expected the compacted record to keep its expiry")
+	}
+}
+
+func TestKVStore_KeyExpiryAndHistogram(t *testing.T) {
+	store, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	puts := []struct {
+		key string
+		ttl time.Duration
+	}{
+		{"gone", 10 * time.Millisecond},
+		{"soon", 30 * time.Second},
+		{"hourly", 50 * time.Minute},
+		{"daily:1", 20 * time.Hour},
+		{"daily:2", 23 * time.Hour},
+		{"yearly", 365 * 24 * time.Hour},
+		{"forever", 0},
+	}
+	before := time.Now()
+	for _, p := range puts {
+		if p.ttl == 0 {
+			err = store.Put([]byte(p.key), []byte("v"))
+		} else {
+			err = store.PutWithTTL([]byte(p.key), []byte("v"), p.ttl)
+		}
+		if err != nil {
+			t.Fatalf("Failed to put %s: %v", p.key, err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	expiry, err := store.KeyExpiry([]byte("hourly"))
+	if err != nil {
+		t.Fatalf("Failed to get expiry: %v", err)
+	}
+	if want := before.Add(50 * time.Minute); expiry.Before(want) || expiry.After(want.Add(time.Second)) {
+		t.Errorf("Expected hourly to expire around %v, got %v", want, expiry)
+	}
+	if expiry, err := store.KeyExpiry([]byte("forever")); err != nil || !expiry.IsZero() {
+		t.Errorf("Expected no expiry for forever, got %v, %v", expiry, err)
+	}
+	for _, key := range []string{"gone", "missing"} {
+		if _, err := store.KeyExpiry([]byte(key)); err != ErrKeyNotFound {
+			t.Errorf("Expected %s to be not found, got %v", key, err)
+		}
+	}
+
+	hist, err := store.ExpiryHistogram()
+	if err != nil {
+		t.Fatalf("Failed to build histogram: %v", err)
+	}
+	if hist.Expiring != 5 || hist.Expired != 1 || hist.ExpiredMB <= 0 {
+		t.Errorf("Expected 5 expiring and 1 expired key, got %+v", hist)
+	}
+	var got []string
+	for _, b := range hist.Buckets {
+		got = append(got, fmt.Sprintf("%s=%d", b.Within, b.Keys))
+	}
+	if want := "1m=1,1h=1,1d=2,7d=0,30d=0,later=1"; strings.Join(got, ",") != want {
+		t.Errorf("Expected buckets %s, got %s", want, strings.Join(got, ","))
+	}
+
+	res, err := store.Explain(context.Background(), ExplainOptions{})
+	if err != nil {
+		t.Fatalf("Failed to explain: %v", err)
+	}
+	if res.Diagnostics.Expirations.Expiring != 5 || len(res.Diagnostics.Expirations.Buckets) != 6 {
+		t.Errorf("Expected the histogram in Explain, got %+v", res.Diagnostics.Expirations)
+	}
+}
+
+// ttlScan returns every pair in the store as sorted "key=value" strings
+func ttlScan(t *testing.T, store *KVStore) string {
+	t.Helper()
+	it, err := store.ScanPrefix(nil)
+	if err != nil {
+		t.Fatalf("Failed to scan: %v", err)
+	}
+	defer it.Close()
+
+	var pairs []string
+	for it.Next() {
+		pairs = append(pairs, string(it.Key())+"="+string(it.Value()))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/ssargent/freyjadb/pkg/codec"
+	"github.com/ssargent/freyjadb/pkg/keys"
 )
 
 // IndexEntry represents the location of a key-value pair in the log
@@ -12,6 +13,8 @@ type IndexEntry struct {
 	Offset    int64  // Byte offset within the file
 	Size      uint32 // Size of the record in bytes
 	Timestamp uint64 // Record timestamp
+	ValueHash uint64 // Fingerprint of the value for DedupeWrites (0 = unknown)
+	ExpiresAt uint64 // When the record expires, in Unix nanoseconds (0 = never)
 }
 
 // LogWriterConfig holds configuration for the log writer
@@ -19,17 +22,29 @@ type LogWriterConfig struct {
 	FilePath      string        // Path to the active data file
 	FsyncInterval time.Duration // How often to fsync (0 = every write)
 	BufferSize    int           // Write buffer size
+
+	// GroupCommitWindow, when set, replaces FsyncInterval: writes are
+	// fsynced together this long after the first write of each group
+	GroupCommitWindow time.Duration
+
+	// ReadOnly opens an existing file without write access; Put fails with
+	// ErrReadOnly. Used by standby stores, which follow another writer.
+	ReadOnly bool
+
+	Codec *codec.RecordCodec // Encodes records (default codec.NewRecordCodec())
 }
 
 // LogReaderConfig holds configuration for the log reader
 type LogReaderConfig struct {
-	FilePath    string // Path to the data file
-	StartOffset int64  // Offset to start reading from
+	FilePath    string             // Path to the data file
+	StartOffset int64              // Offset to start reading from
+	Codec       *codec.RecordCodec // Decodes records (default codec.NewRecordCodec())
 }
 
 // HashIndexConfig holds configuration for the hash index
 type HashIndexConfig struct {
-	// Future: max memory, persistence options, etc.
+	PrefixDelimiter   byte            // Byte that ends an interned key prefix (default ':')
+	InternalKeyspaces []keys.Keyspace // Keyspaces counted as internal keys (default DefaultInternalKeyspaces)
 }
 
 // KVStoreConfig holds configuration for the key-value store
@@ -37,6 +52,105 @@ type KVStoreConfig struct {
 	DataDir       string        // Directory for data files
 	FsyncInterval time.Duration // Fsync interval for durability
 	MaxRecordSize int           // Maximum size of a single record in bytes
+
+	// Durability of acknowledged writes:
+	//   - FsyncInterval and GroupCommitWindow zero (default): every write is
+	//     fsynced before it returns
+	//   - GroupCommitWindow set, e.g. 2ms: writes return once a shared fsync
+	//     covers them, at most one window plus the fsync later. Writes are
+	//     still durable when they return, and concurrent writers share fsyncs.
+	//     Reads may see a write before it is durable.
+	//   - FsyncInterval set: writes return once buffered and are fsynced within
+	//     the interval; a crash loses up to one interval of acknowledged writes
+	GroupCommitWindow time.Duration
+
+	// Multi-directory storage
+	DataDirs  []string        // Extra directories (e.g. on other disks) that may hold segments
+	Placement PlacementPolicy // How new segments are spread across directories
+
+	// Archival tier
+	ArchiveDir   string        // Directory on slower/cheaper storage for cold segments
+	ArchiveAfter time.Duration // Sealed segments unread for this long are archived (0 = never)
+
+	// Startup behaviour
+	IndexLoad          IndexLoadMode          // When to build the in-memory index (default eager)
+	WarmupPrefixes     []string               // Key prefixes whose records are read into the page cache after the index loads
+	WarmupMaxBytes     int64                  // Upper bound on bytes read during warmup (0 = unlimited)
+	RecoveryBudget     time.Duration          // Abort Open if log validation takes longer than this (0 = unlimited)
+	LockTimeout        time.Duration          // How long Open waits for another writer to release DataDir (0 = fail at once with ErrDatabaseLocked)
+	OnRecoveryProgress func(RecoveryProgress) // Called periodically while the log is validated
+
+	// FastRestart makes Close record a clean shutdown along with the index.
+	// The next Open then skips log validation and loads the index from that
+	// record instead of the segments, as long as the active log and manifest
+	// are unchanged. After a crash the log is validated as usual.
+	FastRestart bool
+
+	// Sequences
+	SequenceBatch int // IDs reserved per fsync by NextID (default DefaultSequenceBatch)
+
+	// Writes
+	DedupeWrites bool // Skip appending a Put whose value equals the key's current value
+
+	// Encryption, when set, encrypts values before they reach the log and
+	// decrypts them on read; see codec.NewRecordCodecWithEncryption. Each
+	// record names the key it was sealed with, so a provider that keeps
+	// retired keys, such as codec.Keyring, reads records from before a
+	// rotation. Keys are stored in the clear, since the index, prefix scans
+	// and range deletes work on them. Logs written without encryption stay
+	// readable; Reencrypt seals their values.
+	Encryption codec.EncryptionProvider
+
+	// Compression compresses values of at least Compression.MinSize bytes
+	// with snappy or zstd before they reach the log, and before they are
+	// encrypted. Each compressed record is flagged, so logs written with
+	// other settings stay readable and changing it only affects new writes.
+	Compression codec.CompressionOptions
+
+	// Reads
+	ReadaheadSize int // Bytes a sequential prefix scan reads at a time (default DefaultReadaheadSize, negative disables)
+
+	// IO scheduling: background work (backups, index rebuilds, compaction)
+	// runs at full speed only while client reads and writes have headroom,
+	// i.e. none is in flight and their recent latency is under
+	// BackgroundLatencyTarget. Otherwise it is held to MinBackgroundRate.
+	BackgroundLatencyTarget time.Duration // Default DefaultBackgroundLatencyTarget, negative disables scheduling
+	MinBackgroundRate       int64         // Bytes per second (default DefaultMinBackgroundRate)
+
+	// Memtable (LSM-lite) mode: recent writes are also held in a sorted
+	// memtable that is flushed as a key-ordered segment once it reaches
+	// MemtableSize, after which the active log starts over. A standby
+	// follows a memtable-mode primary across flushes; a promoted standby
+	// writes in append mode.
+	WriteMode    WriteMode // WriteModeMemtable enables the memtable (default append only)
+	MemtableSize int64     // Bytes of records that trigger a flush (default DefaultMemtableSize)
+
+	// In append mode the active log is sealed as a new segment with the
+	// next FileID once it reaches MaxSegmentSize, so compaction and tiering
+	// can work on it while writes continue in a fresh log
+	MaxSegmentSize int64 // Bytes (default DefaultMaxSegmentSize, negative disables rotation)
+
+	// Write diagnostics: every write's phases are timed for Explain, and
+	// writes slower than SlowWriteThreshold are passed to OnSlowWrite
+	SlowWriteThreshold time.Duration   // 0 = don't report slow writes
+	OnSlowWrite        func(SlowWrite) // Default logs them to stderr
+
+	// Circuit breakers: writes to a limited key prefix fail with
+	// ErrWriteRateExceeded while it is at its rate, see WriteStats
+	WriteLimits []PrefixWriteLimit
+
+	// Compaction
+	CompactionCluster ClusterStrategy    // Order of the records Compact writes (default ClusterNone)
+	ClusterDepth      int                // Key components ClusterPrefix groups by (default DefaultClusterDepth)
+	CompactionFilters []CompactionFilter // Run on live records by Compact, see RegisterCompactionFilter
+
+	// Metrics
+	Stats             StatsRecorder   // Receives operation counts and latencies (NopStatsRecorder if nil)
+	InternalKeyspaces []keys.Keyspace // Reported as internal, not user, keys (default DefaultInternalKeyspaces)
+
+	// Standby
+	OpenMode     OpenMode      // OpenStandby follows another process's writes read-only (default read-write)
+	TailInterval time.Duration // How often a standby checks the log for new records (default DefaultTailInterval)
 }
 
 // RecoveryResult holds statistics about crash recovery operations
@@ -47,6 +161,24 @@ type RecoveryResult struct {
 	FileSizeAfter    int64 // File size after recovery
 	IndexRebuilt     bool  // Whether index was rebuilt
 	RecoveryTime     int64 // Time taken for recovery in nanoseconds
+	WarmupBytes      int64 // Bytes read into the page cache by warmup
+	CleanShutdown    bool  // Validation was skipped and the index loaded from the hints of a clean Close
+
+	StartedAt time.Time      // When the recovery began
+	Policy    RecoveryPolicy // How the store was configured to recover
+}
+
+// TruncatedBytes returns how many bytes of the log recovery cut off as
+// corrupt
+func (r *RecoveryResult) TruncatedBytes() int64 {
+	return r.FileSizeBefore - r.FileSizeAfter
+}
+
+// RecoveryPolicy is the configuration a recovery ran under
+type RecoveryPolicy struct {
+	IndexLoad      IndexLoadMode `json:"index_load,omitempty"`
+	FastRestart    bool          `json:"fast_restart"`
+	RecoveryBudget time.Duration `json:"recovery_budget,omitempty"`
 }
 
 // RecordIterator provides streaming access to records
@@ -62,6 +194,9 @@ var (
 	ErrInvalidKey         = &KVError{"invalid key"}
 	ErrCorruption         = &KVError{"data corruption detected"}
 	ErrRecordSizeExceeded = &KVError{"record size exceeds maximum allowed size"}
+	ErrRecoveryBudget     = &KVError{"recovery exceeded its time budget"}
+	ErrInvalidCount       = &KVError{"count must be positive"}
+	ErrReadOnly           = &KVError{"store is a read-only standby"}
 )
 
 // KVError represents a key-value store error
@@ -0,0 +1,136 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+)
+
+// DefaultWatchBuffer is how many events a watcher holds for a subscriber
+// that hasn't read them yet, when WatchOptions doesn't set Buffer
+const DefaultWatchBuffer = 1024
+
+// ErrWatchLagged ends a watch whose subscriber fell so far behind that its
+// buffer filled. Events after that were not delivered, so the subscriber
+// should resynchronize, e.g. with a scan, before watching again.
+var ErrWatchLagged = errors.New("watcher fell behind and missed events")
+
+// ChangeEvent is one write seen by a watcher
+type ChangeEvent struct {
+	Key       []byte
+	Value     []byte // nil for a delete
+	Deleted   bool
+	Timestamp uint64
+}
+
+// WatchOptions selects the events a watcher receives
+type WatchOptions struct {
+	Prefix []byte // Only keys starting with Prefix; all keys if empty
+	Buffer int    // Events held for the subscriber (default DefaultWatchBuffer)
+}
+
+// Watcher receives the Puts and Deletes made through the store from the
+// time it was created. Events arrive in commit order once the write is
+// applied, which with GroupCommitWindow may be just before it is durable.
+// Range deletes and the store's own bookkeeping are not reported.
+type Watcher struct {
+	kv     *KVStore
+	prefix []byte
+	events chan ChangeEvent
+	err    error // Why events was closed; set under the store mutex
+}
+
+// Watch subscribes to the store's writes. The subscriber must read Events
+// promptly: a watcher whose buffer fills is closed with ErrWatchLagged
+// rather than slowing down writers. Call Close when done. A standby
+// returns ErrReadOnly until it is promoted, since its writes are made by
+// another process.
+func (kv *KVStore) Watch(opts WatchOptions) (*Watcher, error) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if err := kv.checkOpenInternal(); err != nil {
+		return nil, err
+	}
+	if kv.standby.enabled {
+		return nil, ErrReadOnly
+	}
+
+	buffer := opts.Buffer
+	if buffer <= 0 {
+		buffer = DefaultWatchBuffer
+	}
+	w := &Watcher{kv: kv, prefix: bytes.Clone(opts.Prefix), events: make(chan ChangeEvent, buffer)}
+	if kv.watchers == nil {
+		kv.watchers = make(map[*Watcher]struct{})
+	}
+	kv.watchers[w] = struct{}{}
+	return w, nil
+}
+
+// Events returns the watcher's events. The channel is closed when the watch
+// ends; Err then says why.
+func (w *Watcher) Events() <-chan ChangeEvent {
+	return w.events
+}
+
+// Err returns ErrWatchLagged if the subscriber fell behind, the store's
+// error if it was closed, or nil while the watch is running or after Close
+func (w *Watcher) Err() error {
+	w.kv.mutex.Lock()
+	defer w.kv.mutex.Unlock()
+	return w.err
+}
+
+// Close ends the watch. It is safe to call more than once.
+func (w *Watcher) Close() {
+	w.kv.mutex.Lock()
+	defer w.kv.mutex.Unlock()
+	w.kv.endWatchInternal(w, nil)
+}
+
+// publishInternal invalidates a write's key in every cache and sends the
+// write to every watcher whose prefix it matches. value is copied, since
+// the caller may reuse it (caller must hold the mutex).
+func (kv *KVStore) publishInternal(key, value []byte, timestamp uint64) {
+	kv.invalidateCachesInternal(key)
+	if len(kv.watchers) == 0 {
+		return
+	}
+
+	var event *ChangeEvent
+	for w := range kv.watchers {
+		if !bytes.HasPrefix(key, w.prefix) {
+			continue
+		}
+		if event == nil {
+			event = &ChangeEvent{Key: bytes.Clone(key), Deleted: len(value) == 0, Timestamp: timestamp}
+			if len(value) > 0 {
+				event.Value = bytes.Clone(value)
+			}
+		}
+		select {
+		case w.events <- *event:
+		default:
+			kv.endWatchInternal(w, ErrWatchLagged)
+		}
+	}
+}
+
+// endWatchInternal removes a watcher and closes its events, recording err
+// as the reason (caller must hold the mutex)
+func (kv *KVStore) endWatchInternal(w *Watcher, err error) {
+	if _, ok := kv.watchers[w]; !ok {
+		return
+	}
+	delete(kv.watchers, w)
+	w.err = err
+	close(w.events)
+}
+
+// closeWatchersInternal ends every watch with err, e.g. when the store
+// closes (caller must hold the mutex)
+func (kv *KVStore) closeWatchersInternal(err error) {
+	for w := range kv.watchers {
+		kv.endWatchInternal(w, err)
+	}
+}
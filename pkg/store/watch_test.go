@@ -0,0 +1,86 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestKVStore_Watch(t *testing.T) {
+	store, err := NewKVStore(KVStoreConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	users, err := store.Watch(WatchOptions{Prefix: []byte("user:")})
+	if err != nil {
+		t.Fatalf("Failed to watch: %v", err)
+	}
+	defer users.Close()
+
+	value := []byte("alice")
+	if err := store.Put([]byte("user:1"), value); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	copy(value, "XXXXX") // The event keeps its own copy
+	if err := store.Put([]byte("order:1"), []byte("ignored")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := store.PutStream([]byte("user:2"), 3, strings.NewReader("bob")); err != nil {
+		t.Fatalf("Failed to stream: %v", err)
+	}
+	if err := store.Delete([]byte("user:1")); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+
+	var got []ChangeEvent
+	for len(users.Events()) > 0 {
+		got = append(got, <-users.Events())
+	}
+	if len(got) != 3 {
+		t.Fatalf("Expected 3 user events, got %+v", got)
+	}
+	if string(got[0].Key) != "user:1" || !bytes.Equal(got[0].Value, []byte("alice")) || got[0].Deleted {
+		t.Errorf("Unexpected put event %+v", got[0])
+	}
+	if string(got[1].Key) != "user:2" || string(got[1].Value) != "bob" {
+		t.Errorf("Unexpected streamed event %+v", got[1])
+	}
+	if string(got[2].Key) != "user:1" || !got[2].Deleted || got[2].Value != nil || got[2].Timestamp == 0 {
+		t.Errorf("Unexpected delete event %+v", got[2])
+	}
+
+	// A subscriber that stops reading is dropped instead of blocking writes
+	slow, err := store.Watch(WatchOptions{Buffer: 1})
+	if err != nil {
+		t.Fatalf("Failed to watch: %v", err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err := store.Put([]byte(key), []byte("v")); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	<-slow.Events()
+	if _, open := <-slow.Events(); open || !errors.Is(slow.Err(), ErrWatchLagged) {
+		t.Errorf("Expected the lagging watch to end with ErrWatchLagged, got %v", slow.Err())
+	}
+	slow.Close() // Already ended
+
+	// Closing the store ends the rest
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	for range users.Events() {
+	}
+	if users.Err() == nil {
+		t.Error("Expected the watch to end with the store")
+	}
+	if _, err := store.Watch(WatchOptions{}); err == nil {
+		t.Error("Expected Watch to fail on a closed store")
+	}
+}
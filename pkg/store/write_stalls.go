@@ -0,0 +1,155 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// writePhase is a step of a write whose time is measured separately, so a
+// slow write can be attributed to the step that stalled it
+type writePhase int
+
+const (
+	phaseLockWait writePhase = iota // Waiting for the store and log locks
+	phaseValidate                   // Key and size checks, and DedupeWrites's comparison
+	phaseEncode                     // Encoding the record
+	phaseBuffer                     // Copying it into the log buffer, which writes out when full
+	phaseFsync                      // Flushing and fsyncing, or waiting for the group commit
+	phaseIndex                      // Updating the index and memtable, including memtable flushes
+	numWritePhases
+)
+
+// writePhaseNames name the phases in Explain and SlowWrite, in the order a
+// write goes through them
+var writePhaseNames = [numWritePhases]string{"lock_wait", "validate", "encode", "buffer", "fsync", "index"}
+
+// SlowWrite describes a write that took longer than
+// KVStoreConfig.SlowWriteThreshold
+type SlowWrite struct {
+	Key      string                   // Key of the write's first record
+	Duration time.Duration            // From the call until the write returned
+	Phases   map[string]time.Duration // Time spent in each phase
+}
+
+// String formats the write as a log line, phases in the order they ran
+func (s SlowWrite) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "slow write of %q took %s:", s.Key, s.Duration)
+	for _, name := range writePhaseNames {
+		fmt.Fprintf(&b, " %s=%s", name, s.Phases[name])
+	}
+	return b.String()
+}
+
+// logSlowWrite is the default OnSlowWrite
+func logSlowWrite(s SlowWrite) {
+	fmt.Fprintln(os.Stderr, s.String())
+}
+
+// writeTiming collects the phases of one write. Each lap charges the time
+// since the previous one to a phase. A nil *writeTiming records nothing, so
+// writes outside commit, such as NextID's reservations, need no checks.
+type writeTiming struct {
+	start  time.Time
+	mark   time.Time
+	key    string
+	phases [numWritePhases]time.Duration
+}
+
+func newWriteTiming() *writeTiming {
+	now := time.Now()
+	return &writeTiming{start: now, mark: now}
+}
+
+// lap charges the time since the last lap to phase
+func (t *writeTiming) lap(phase writePhase) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	t.phases[phase] += now.Sub(t.mark)
+	t.mark = now
+}
+
+// record notes the key of the write's first record
+func (t *writeTiming) record(key []byte) {
+	if t != nil && t.key == "" {
+		t.key = string(key)
+	}
+}
+
+// slowWrite reports the timing as a SlowWrite
+func (t *writeTiming) slowWrite() SlowWrite {
+	s := SlowWrite{Key: t.key, Duration: t.mark.Sub(t.start), Phases: make(map[string]time.Duration, numWritePhases)}
+	for phase, d := range t.phases {
+		s.Phases[writePhaseNames[phase]] = d
+	}
+	return s
+}
+
+// writeStalls aggregates the phases of every write since the store was
+// opened. It has its own lock because writes finish, waiting for group
+// commit, after releasing the store's.
+type writeStalls struct {
+	mutex  sync.Mutex
+	writes int64
+	slow   int64
+	total  [numWritePhases]time.Duration
+	max    [numWritePhases]time.Duration
+}
+
+// add records a finished write, reporting whether it was slow
+func (w *writeStalls) add(t *writeTiming, threshold time.Duration) bool {
+	slow := threshold > 0 && t.mark.Sub(t.start) > threshold
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.writes++
+	if slow {
+		w.slow++
+	}
+	for phase, d := range t.phases {
+		w.total[phase] += d
+		w.max[phase] = max(w.max[phase], d)
+	}
+	return slow
+}
+
+// reset forgets the writes recorded so far
+func (w *writeStalls) reset() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.writes, w.slow = 0, 0
+	w.total, w.max = [numWritePhases]time.Duration{}, [numWritePhases]time.Duration{}
+}
+
+// stats summarizes the recorded writes for Explain
+func (w *writeStalls) stats() WritePhaseStats {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	stats := WritePhaseStats{Writes: w.writes, SlowWrites: w.slow, Phases: make(map[string]PhaseTiming, numWritePhases)}
+	var all time.Duration
+	for _, d := range w.total {
+		all += d
+	}
+	for phase, name := range writePhaseNames {
+		timing := PhaseTiming{MaxMs: toMs(w.max[phase])}
+		if w.writes > 0 {
+			timing.AvgMs = toMs(w.total[phase]) / float64(w.writes)
+		}
+		if all > 0 {
+			timing.SharePct = float64(w.total[phase]) / float64(all) * 100
+		}
+		stats.Phases[name] = timing
+	}
+	return stats
+}
+
+// toMs converts a duration to fractional milliseconds
+func toMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
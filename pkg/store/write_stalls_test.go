@@ -0,0 +1,75 @@
+package store
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKVStore_WritePhases(t *testing.T) {
+	var slow []SlowWrite
+	store, err := NewKVStore(KVStoreConfig{
+		DataDir:            t.TempDir(),
+		SlowWriteThreshold: time.Nanosecond, // Every write is slow
+		OnSlowWrite:        func(s SlowWrite) { slow = append(slow, s) },
+	})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Put([]byte("user:1"), []byte("alice")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := store.Delete([]byte("user:1")); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+
+	if len(slow) != 2 || slow[0].Key != "user:1" {
+		t.Fatalf("Expected two slow writes of user:1, got %+v", slow)
+	}
+	var sum time.Duration
+	for _, name := range writePhaseNames {
+		d, ok := slow[0].Phases[name]
+		if !ok {
+			t.Errorf("Slow write is missing phase %s", name)
+		}
+		sum += d
+	}
+	if sum != slow[0].Duration || slow[0].Phases["fsync"] == 0 {
+		t.Errorf("Expected phases summing to %s with an fsync, got %v", slow[0].Duration, slow[0].Phases)
+	}
+	if line := slow[0].String(); !strings.HasPrefix(line, `slow write of "user:1" took`) || !strings.Contains(line, " fsync=") {
+		t.Errorf("Unexpected log line %q", line)
+	}
+
+	res, err := store.Explain(context.Background(), ExplainOptions{})
+	if err != nil {
+		t.Fatalf("Failed to explain: %v", err)
+	}
+	stats := res.Diagnostics.WritePhases
+	if stats.Writes != 2 || stats.SlowWrites != 2 || len(stats.Phases) != len(writePhaseNames) {
+		t.Fatalf("Unexpected write phase stats %+v", stats)
+	}
+	var share float64
+	for _, timing := range stats.Phases {
+		share += timing.SharePct
+	}
+	if math.Abs(share-100) > 0.001 || stats.Phases["fsync"].MaxMs <= 0 {
+		t.Errorf("Expected shares summing to 100%% with an fsync, got %+v", stats.Phases)
+	}
+
+	// Writes that aren't slow are counted but not reported
+	store.config.SlowWriteThreshold = time.Hour
+	if err := store.Put([]byte("user:2"), []byte("bob")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if len(slow) != 2 || store.writeStalls.stats().Writes != 3 {
+		t.Errorf("Expected 3 writes and still 2 slow ones, got %+v", store.writeStalls.stats())
+	}
+}
@@ -0,0 +1,266 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Write tracking per key prefix
+const (
+	writeSlots          = 60   // Slots in each ring: seconds of the last minute, minutes of the last hour
+	maxWritePrefixes    = 1024 // Prefixes tracked; ones without writes in the last hour are dropped to make room
+	maxStatsWritePrefix = 20   // Busiest prefixes reported in StoreStats, besides limited ones
+)
+
+// ErrWriteRateExceeded is returned for writes to a key prefix that has
+// taken its PrefixWriteLimit's writes in the last minute. Writes resume as
+// older ones leave the window.
+var ErrWriteRateExceeded = &KVError{"write rate limit exceeded for key prefix"}
+
+// PrefixWriteLimit is a circuit breaker pausing writes to a key prefix
+// once it takes MaxPerMinute writes in a rolling minute, so a runaway job
+// can't flood the store. Prefix is a first key component with its
+// delimiter, as WriteStats reports them, e.g. "logs:".
+type PrefixWriteLimit struct {
+	Prefix       string
+	MaxPerMinute int64
+}
+
+// PrefixWriteStats counts the puts and deletes of a key prefix over
+// rolling windows, for spotting write surges
+type PrefixWriteStats struct {
+	Prefix       string  `json:"prefix"`
+	LastMinute   int64   `json:"last_1m"`
+	Last5Minutes int64   `json:"last_5m"`
+	LastHour     int64   `json:"last_1h"`
+	PerSecond    float64 `json:"per_sec"` // Average over the last minute
+
+	// The prefix's PrefixWriteLimit, 0 without one, and the writes it has
+	// rejected since the store was opened
+	LimitPerMinute int64 `json:"limit_per_minute,omitempty"`
+	Throttled      int64 `json:"throttled,omitempty"`
+}
+
+// writeRing counts writes in writeSlots consecutive slots of equal length
+type writeRing struct {
+	slots [writeSlots]struct {
+		number int64 // Slot the count belongs to; stale unless within the window
+		count  int64
+	}
+}
+
+// add counts n writes in slot number
+func (r *writeRing) add(number, n int64) {
+	slot := &r.slots[number%writeSlots]
+	if slot.number != number {
+		slot.number, slot.count = number, 0
+	}
+	slot.count += n
+}
+
+// sum counts the writes in the span slots up to and including current
+func (r *writeRing) sum(current, span int64) int64 {
+	var total int64
+	for _, slot := range r.slots {
+		if slot.number > current-span && slot.number <= current {
+			total += slot.count
+		}
+	}
+	return total
+}
+
+// writeCounter counts a prefix's writes by second and by minute
+type writeCounter struct {
+	seconds   writeRing
+	minutes   writeRing
+	last      time.Time // Latest write
+	throttled int64
+}
+
+// lastMinute returns the writes in the minute up to now
+func (c *writeCounter) lastMinute(now time.Time) int64 {
+	return c.seconds.sum(now.Unix(), writeSlots)
+}
+
+// writeStats counts writes per key prefix and enforces the prefix write
+// limits (guarded by kv.mutex)
+type writeStats struct {
+	prefixes map[string]*writeCounter
+	limits   map[string]int64
+}
+
+// newWriteStats returns write statistics enforcing limits
+func newWriteStats(limits []PrefixWriteLimit) (writeStats, error) {
+	w := writeStats{}
+	for _, limit := range limits {
+		if limit.Prefix == "" || limit.MaxPerMinute <= 0 {
+			return w, fmt.Errorf("write limits need a prefix and a positive MaxPerMinute, got %+v", limit)
+		}
+		if w.limits == nil {
+			w.limits = make(map[string]int64)
+		}
+		w.limits[limit.Prefix] = limit.MaxPerMinute
+	}
+	return w, nil
+}
+
+// writePrefix returns the prefix key is counted under, or "" for keys without
+// the delimiter
+func writePrefix(key []byte, delimiter byte) string {
+	i := bytes.IndexByte(key, delimiter)
+	if i < 0 {
+		return ""
+	}
+	return string(key[:i+1])
+}
+
+// admit reports ErrWriteRateExceeded if n more writes to prefix would take
+// it over its limit, counting the rejection
+func (w *writeStats) admit(prefix string, n int64, now time.Time) error {
+	limit, ok := w.limits[prefix]
+	if !ok {
+		return nil
+	}
+	c := w.counter(prefix, now)
+	if c == nil || c.lastMinute(now)+n <= limit {
+		return nil
+	}
+	c.throttled += n
+	return fmt.Errorf("%w: %s is limited to %d writes a minute", ErrWriteRateExceeded, prefix, limit)
+}
+
+// record counts a write of key at now
+func (w *writeStats) record(key []byte, delimiter byte, now time.Time) {
+	prefix := writePrefix(key, delimiter)
+	if prefix == "" {
+		return
+	}
+	if c := w.counter(prefix, now); c != nil {
+		c.seconds.add(now.Unix(), 1)
+		c.minutes.add(now.Unix()/60, 1)
+		c.last = now
+	}
+}
+
+// counter returns prefix's counter, creating it if there is room
+func (w *writeStats) counter(prefix string, now time.Time) *writeCounter {
+	if c, ok := w.prefixes[prefix]; ok {
+		return c
+	}
+	if w.prefixes == nil {
+		w.prefixes = make(map[string]*writeCounter)
+	}
+	if len(w.prefixes) >= maxWritePrefixes && !w.prune(now) {
+		return nil
+	}
+	c := &writeCounter{}
+	w.prefixes[prefix] = c
+	return c
+}
+
+// prune drops prefixes without writes in the last hour, other than limited
+// ones, reporting whether that made room for another
+func (w *writeStats) prune(now time.Time) bool {
+	for prefix, c := range w.prefixes {
+		if _, limited := w.limits[prefix]; !limited && now.Sub(c.last) > time.Hour {
+			delete(w.prefixes, prefix)
+		}
+	}
+	return len(w.prefixes) < maxWritePrefixes
+}
+
+// reset forgets every count, keeping the limits
+func (w *writeStats) reset() {
+	w.prefixes = nil
+}
+
+// report returns the statistics of every prefix written in the last hour
+// or limited as of now, busiest in the last minute first
+func (w *writeStats) report(now time.Time, internal func(prefix string) bool) []PrefixWriteStats {
+	second, minute := now.Unix(), now.Unix()/60
+	stats := make([]PrefixWriteStats, 0, len(w.prefixes))
+	for prefix, limit := range w.limits {
+		if _, ok := w.prefixes[prefix]; !ok && !internal(prefix) {
+			stats = append(stats, PrefixWriteStats{Prefix: prefix, LimitPerMinute: limit})
+		}
+	}
+	for prefix, c := range w.prefixes {
+		if internal(prefix) {
+			continue
+		}
+		s := PrefixWriteStats{
+			Prefix:         prefix,
+			LastMinute:     c.seconds.sum(second, writeSlots),
+			Last5Minutes:   c.minutes.sum(minute, 5),
+			LastHour:       c.minutes.sum(minute, writeSlots),
+			LimitPerMinute: w.limits[prefix],
+			Throttled:      c.throttled,
+		}
+		s.PerSecond = float64(s.LastMinute) / writeSlots
+		if s.LastHour == 0 && s.Throttled == 0 && s.LimitPerMinute == 0 {
+			continue
+		}
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].LastMinute != stats[j].LastMinute {
+			return stats[i].LastMinute > stats[j].LastMinute
+		}
+		if stats[i].LastHour != stats[j].LastHour {
+			return stats[i].LastHour > stats[j].LastHour
+		}
+		return stats[i].Prefix < stats[j].Prefix
+	})
+	return stats
+}
+
+// WriteStats returns the puts and deletes of every key prefix over the last
+// minute, 5 minutes and hour, busiest in the last minute first, with the
+// prefix write limits and how many writes they rejected. Prefixes are the
+// first key component and its delimiter; FreyjaDB's own keyspaces are left
+// out. A prefix whose rate jumps, e.g. a runaway job writing millions of
+// keys, stands out at the top.
+func (kv *KVStore) WriteStats() []PrefixWriteStats {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	return kv.writes.report(time.Now(), kv.index.isInternal)
+}
+
+// admitWritesInternal checks the prefix write limits for writing keys,
+// rejecting the lot if any prefix would go over its limit (caller must
+// hold the mutex)
+func (kv *KVStore) admitWritesInternal(keys ...[]byte) error {
+	if len(kv.writes.limits) == 0 {
+		return nil
+	}
+	now := time.Now()
+	if len(keys) == 1 {
+		return kv.writes.admit(writePrefix(keys[0], kv.index.delimiter), 1, now)
+	}
+	counts := make(map[string]int64)
+	for _, key := range keys {
+		counts[writePrefix(key, kv.index.delimiter)]++
+	}
+	for prefix, n := range counts {
+		if err := kv.writes.admit(prefix, n, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prefixWriteStatsInternal returns the busiest prefixes and the limited
+// ones for StoreStats (caller must hold the mutex)
+func (kv *KVStore) prefixWriteStatsInternal() []PrefixWriteStats {
+	all := kv.writes.report(time.Now(), kv.index.isInternal)
+	var stats []PrefixWriteStats
+	for i, s := range all {
+		if i < maxStatsWritePrefix || s.LimitPerMinute > 0 {
+			stats = append(stats, s)
+		}
+	}
+	return stats
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestKVStore_WriteStats(t *testing.T) {
+	store, err := NewKVStore(KVStoreConfig{
+		DataDir:     t.TempDir(),
+		WriteLimits: []PrefixWriteLimit{{Prefix: "logs:", MaxPerMinute: 5}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to open KV store: %v", err)
+	}
+	defer store.Close()
+
+	for _, key := range []string{"user:1", "user:2", "user:3", "order:1", "nodelimiter"} {
+		if err := store.Put([]byte(key), []byte("v")); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+	if err := store.Delete([]byte("user:1")); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if err := store.PutRelationship("user:2", "order:1", "placed"); err != nil {
+		t.Fatalf("Failed to put relationship: %v", err)
+	}
+
+	// The limited prefix takes its 5 writes, then is paused
+	if err := store.PutBatch([]KeyValuePair{
+		{Key: []byte("logs:1"), Value: []byte("v")},
+		{Key: []byte("logs:2"), Value: []byte("v")},
+		{Key: []byte("logs:3"), Value: []byte("v")},
+	}); err != nil {
+		t.Fatalf("Failed to put batch: %v", err)
+	}
+	if err := store.PutBatch([]KeyValuePair{
+		{Key: []byte("logs:4"), Value: []byte("v")},
+		{Key: []byte("logs:5"), Value: []byte("v")},
+		{Key: []byte("logs:6"), Value: []byte("v")},
+	}); !errors.Is(err, ErrWriteRateExceeded) {
+		t.Fatalf("Expected a batch over the limit to fail whole, got %v", err)
+	}
+	if _, err := store.Get([]byte("logs:4")); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected none of the rejected batch stored, got %v", err)
+	}
+	if err := store.Put([]byte("logs:4"), []byte("v")); err != nil {
+		t.Fatalf("Failed to put under the limit: %v", err)
+	}
+	if err := store.Delete([]byte("logs:1")); err != nil {
+		t.Fatalf("Failed to delete under the limit: %v", err)
+	}
+	if err := store.Put([]byte("logs:5"), []byte("v")); !errors.Is(err, ErrWriteRateExceeded) {
+		t.Fatalf("Expected ErrWriteRateExceeded, got %v", err)
+	}
+	if err := store.Delete([]byte("logs:2")); !errors.Is(err, ErrWriteRateExceeded) {
+		t.Fatalf("Expected deletes limited too, got %v", err)
+	}
+	if err := store.Put([]byte("user:4"), []byte("v")); err != nil {
+		t.Fatalf("Expected other prefixes unaffected, got %v", err)
+	}
+
+	stats := store.WriteStats()
+	if len(stats) != 3 {
+		t.Fatalf("Expected logs:, user: and order: without internal keys, got %+v", stats)
+	}
+	logs, users, orders := stats[0], stats[1], stats[2]
+	if logs.Prefix != "logs:" || logs.LastMinute != 5 || logs.Last5Minutes != 5 || logs.LastHour != 5 ||
+		logs.LimitPerMinute != 5 || logs.Throttled != 5 {
+		t.Errorf("Unexpected logs: stats %+v", logs)
+	}
+	if users.Prefix != "user:" || users.LastMinute != 5 || users.LastHour != 5 || users.LimitPerMinute != 0 {
+		t.Errorf("Unexpected user: stats %+v", users)
+	}
+	if orders.Prefix != "order:" || orders.LastMinute != 1 {
+		t.Errorf("Unexpected order: stats %+v", orders)
+	}
+	if got := store.Stats().PrefixWrites; len(got) != 3 || got[0].Prefix != "logs:" {
+		t.Errorf("Expected StoreStats to carry the write stats, got %+v", got)
+	}
+
+	// Counts start over with the store
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	if _, err := store.Open(); err != nil {
+		t.Fatalf("Failed to reopen: %v", err)
+	}
+	if stats := store.WriteStats(); len(stats) != 1 || stats[0].Prefix != "logs:" || stats[0].LastMinute != 0 {
+		t.Errorf("Expected only the limit after reopening, got %+v", stats)
+	}
+	if err := store.Put([]byte("logs:5"), []byte("v")); err != nil {
+		t.Errorf("Expected writes admitted after reopening, got %v", err)
+	}
+
+	if _, err := NewKVStore(KVStoreConfig{
+		DataDir:     t.TempDir(),
+		WriteLimits: []PrefixWriteLimit{{Prefix: "logs:"}},
+	}); err == nil {
+		t.Error("Expected a limit without a rate to be rejected")
+	}
+}
+
+func TestWriteStats_Windows(t *testing.T) {
+	var w writeStats
+	start := time.Unix(1_700_000_000, 0)
+	for i := 0; i < 90; i++ { // One write a minute for an hour and a half
+		w.record([]byte("job:x"), ':', start.Add(time.Duration(i)*time.Minute))
+	}
+	now := start.Add(89 * time.Minute)
+	stats := w.report(now, func(string) bool { return false })
+	if len(stats) != 1 {
+		t.Fatalf("Expected one prefix, got %+v", stats)
+	}
+	if s := stats[0]; s.LastMinute != 1 || s.Last5Minutes != 5 || s.LastHour != 60 {
+		t.Errorf("Expected 1/5/60 writes in the windows, got %+v", s)
+	}
+
+	// Windows empty out as time passes, and idle prefixes are reported no more
+	stats = w.report(now.Add(2*time.Hour), func(string) bool { return false })
+	if len(stats) != 0 {
+		t.Errorf("Expected an idle prefix to drop out, got %+v", stats)
+	}
+}